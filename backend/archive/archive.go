@@ -0,0 +1,372 @@
+// Package archive implements a backend which shows the archive files
+// on another remote as read only directories.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	libcache "github.com/rclone/rclone/lib/cache"
+)
+
+// errorReadOnly is returned when trying to change an archive
+var errorReadOnly = errors.New("archives are read only")
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "archive",
+		Description: "Read archives on another remote as directories",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Required: true,
+			Help: `Remote containing the archives (e.g. myRemote:path).
+
+Archive files on it are shown as directories of their contents.`,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+}
+
+// Fs shows the archives on a base remote as directories
+type Fs struct {
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+	base     fs.Fs  // the remote containing the archives
+	prefix   string // path of the root on base if it is below an archive name
+	wrapper  fs.Fs
+
+	indexMu sync.Mutex      // held while reading archive indexes
+	indexes *libcache.Cache // archive indexes by path, size and modification time
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point archive remote at itself - check the value of the remote setting")
+	}
+	root = strings.Trim(root, "/")
+
+	// The base remote can't be rooted inside an archive so root it
+	// above the first path element which looks like an archive
+	basePath, prefix := root, ""
+	elements := strings.Split(root, "/")
+	for i, element := range elements {
+		if archiveFormat(element) != formatNone {
+			basePath, prefix = path.Join(elements[:i]...), path.Join(elements[i:]...)
+			break
+		}
+	}
+	base, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, basePath))
+	if err == fs.ErrorIsFile && prefix != "" {
+		return nil, fs.ErrorDirNotFound
+	}
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+	}
+	f := &Fs{
+		name:    name,
+		root:    root,
+		opt:     *opt,
+		base:    base,
+		prefix:  prefix,
+		indexes: libcache.New(),
+	}
+	// Correct root if definitely pointing to a file
+	if err == fs.ErrorIsFile {
+		f.root = parentDir(f.root)
+	}
+	f.features = (&fs.Features{
+		CanHaveEmptyDirectories: true,
+	}).Fill(ctx, f).Mask(ctx, base).WrapsFs(f, base)
+	cache.PinUntilFinalized(base, f)
+
+	// Check whether the root is a file inside an archive
+	if prefix != "" {
+		a, inner, findErr := f.findArchive(ctx, prefix)
+		if findErr != nil {
+			return nil, findErr
+		}
+		if a != nil && inner != "" {
+			e := a.entries[inner]
+			if e != nil && !e.dir {
+				f.root = parentDir(f.root)
+				f.prefix = parentDir(f.prefix)
+				return f, fs.ErrorIsFile
+			}
+		}
+	}
+	return f, err
+}
+
+// parentDir returns the parent of p or "" if it is at the top
+func parentDir(p string) string {
+	p = path.Dir(p)
+	if p == "." || p == "/" {
+		return ""
+	}
+	return p
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("archive root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Precision of the ModTimes in this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.base.Precision()
+}
+
+// Hashes returns the hash types supported by the base remote
+//
+// Files in zip archives support CRC-32 too.
+func (f *Fs) Hashes() hash.Set {
+	return f.base.Hashes()
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.base
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// basePath returns the path of remote on the base remote
+func (f *Fs) basePath(remote string) string {
+	return path.Join(f.prefix, remote)
+}
+
+// remote returns the path of basePath on this remote
+func (f *Fs) remote(basePath string) string {
+	if f.prefix == "" {
+		return basePath
+	}
+	return strings.TrimPrefix(basePath, f.prefix+"/")
+}
+
+// findArchive returns the archive which p on the base remote is in
+// and the path of p inside it.
+//
+// It returns a nil archive if p isn't in an archive.
+func (f *Fs) findArchive(ctx context.Context, p string) (a *archive, inner string, err error) {
+	if p == "" {
+		return nil, "", nil
+	}
+	elements := strings.Split(p, "/")
+	for i, element := range elements {
+		if archiveFormat(element) == formatNone {
+			continue
+		}
+		archivePath := path.Join(elements[:i+1]...)
+		o, err := f.base.NewObject(ctx, archivePath)
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+			// Not an archive - maybe a directory with an archive name
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		a, err := f.readArchive(ctx, o)
+		if err != nil {
+			return nil, "", err
+		}
+		return a, path.Join(elements[i+1:]...), nil
+	}
+	return nil, "", nil
+}
+
+// readArchive returns the index of the archive in o reading it if it
+// isn't cached
+func (f *Fs) readArchive(ctx context.Context, o fs.Object) (*archive, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%d", o.Remote(), o.Size(), o.ModTime(ctx).UnixNano())
+	f.indexMu.Lock()
+	defer f.indexMu.Unlock()
+	value, err := f.indexes.Get(key, func(string) (any, bool, error) {
+		fs.Debugf(o, "Reading archive index")
+		a, err := newArchive(ctx, o)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read archive %q: %w", o.Remote(), err)
+		}
+		return a, true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*archive), nil
+}
+
+// List the objects and directories in dir into entries. The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	p := f.basePath(dir)
+	a, inner, err := f.findArchive(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if a != nil {
+		return a.list(f, inner)
+	}
+	baseEntries, err := f.base.List(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range baseEntries {
+		remote := f.remote(entry.Remote())
+		switch x := entry.(type) {
+		case fs.Object:
+			if archiveFormat(path.Base(remote)) != formatNone {
+				entries = append(entries, fs.NewDir(remote, x.ModTime(ctx)))
+			} else {
+				entries = append(entries, f.newObject(x))
+			}
+		case fs.Directory:
+			entries = append(entries, fs.NewDirWrapper(remote, x))
+		default:
+			return nil, fmt.Errorf("unknown object type %T", entry)
+		}
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote. If it can't be found
+// it returns the error ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	p := f.basePath(remote)
+	a, inner, err := f.findArchive(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if a != nil {
+		e := a.entries[inner]
+		if inner == "" || (e != nil && e.dir) {
+			return nil, fs.ErrorIsDir
+		}
+		if e == nil {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return f.newMember(a, e), nil
+	}
+	o, err := f.base.NewObject(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// checkWritable returns an error if dir is inside an archive
+func (f *Fs) checkWritable(ctx context.Context, dir string) error {
+	a, _, err := f.findArchive(ctx, f.basePath(dir))
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		return errorReadOnly
+	}
+	return nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// Files can't be put inside archives.
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	remote := src.Remote()
+	err := f.checkWritable(ctx, parentDir(remote))
+	if err != nil {
+		return nil, err
+	}
+	o, err := f.base.Put(ctx, in, fs.NewOverrideRemote(src, f.basePath(remote)), options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	a, inner, err := f.findArchive(ctx, f.basePath(dir))
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		if e := a.entries[inner]; inner == "" || (e != nil && e.dir) {
+			return nil
+		}
+		return errorReadOnly
+	}
+	return f.base.Mkdir(ctx, f.basePath(dir))
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	err := f.checkWritable(ctx, dir)
+	if err != nil {
+		return err
+	}
+	return f.base.Rmdir(ctx, f.basePath(dir))
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.UnWrapper = (*Fs)(nil)
+	_ fs.Wrapper   = (*Fs)(nil)
+)
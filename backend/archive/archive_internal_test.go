@@ -0,0 +1,263 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modTime = time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)
+
+// testFiles are the files put in each archive
+func testFiles() map[string][]byte {
+	big := make([]byte, 3*tarSeekThreshold+1234)
+	_, _ = rand.New(rand.NewSource(1)).Read(big)
+	return map[string][]byte{
+		"hello.txt":       []byte("hello world\n"),
+		"dir/empty.txt":   nil,
+		"dir/sub/big.bin": big,
+		"dir/sub/log.txt": bytes.Repeat([]byte("compressible "), 10000),
+	}
+}
+
+// sortedNames returns the names of files in order
+func sortedNames(files map[string][]byte) (names []string) {
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeZip writes files into a zip file
+func writeZip(t *testing.T, file string, files map[string][]byte) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.CreateHeader(&zip.FileHeader{Name: "dir/", Modified: modTime})
+	require.NoError(t, err)
+	for _, name := range sortedNames(files) {
+		method := zip.Deflate
+		if name == "dir/sub/big.bin" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modTime})
+		require.NoError(t, err)
+		_, err = w.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0666))
+}
+
+// writeTar writes files into a tar file compressed with format
+func writeTar(t *testing.T, file string, files map[string][]byte, format format) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch format {
+	case formatTar:
+		w = nopWriteCloser{&buf}
+	case formatTarGz:
+		w = gzip.NewWriter(&buf)
+	case formatTarZstd:
+		var err error
+		w, err = zstd.NewWriter(&buf)
+		require.NoError(t, err)
+	}
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "hello.txt", ModTime: modTime}))
+	for _, name := range sortedNames(files) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(files[name])), ModTime: modTime}))
+		_, err := tw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0666))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newTestFs makes an archive remote of dir rooted at root
+func newTestFs(t *testing.T, dir, root string) (*Fs, error) {
+	f, err := NewFs(context.Background(), "TestArchive", root, configmap.Simple{
+		"remote": dir,
+	})
+	if f == nil {
+		return nil, err
+	}
+	return f.(*Fs), err
+}
+
+// listNames lists dir returning the names with / after directories
+func listNames(t *testing.T, f fs.Fs, dir string) (names []string) {
+	entries, err := f.List(context.Background(), dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		name := entry.Remote()
+		if _, ok := entry.(fs.Directory); ok {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// read reads o with options
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) []byte {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return got
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want format
+	}{
+		{"file.txt", formatNone},
+		{"a.zip", formatZip},
+		{"A.ZIP", formatZip},
+		{"a.tar", formatTar},
+		{"a.tar.gz", formatTarGz},
+		{"a.tgz", formatTarGz},
+		{"a.tar.zst", formatTarZstd},
+		{"a.tzst", formatTarZstd},
+		{"a.gz", formatNone},
+	} {
+		assert.Equal(t, test.want, archiveFormat(test.name), test.name)
+	}
+}
+
+func TestArchives(t *testing.T) {
+	ctx := context.Background()
+	files := testFiles()
+	for _, test := range []struct {
+		name   string
+		format format
+	}{
+		{"test.zip", formatZip},
+		{"test.tar", formatTar},
+		{"test.tar.gz", formatTarGz},
+		{"test.tar.zst", formatTarZstd},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, test.name)
+			if test.format == formatZip {
+				writeZip(t, file, files)
+			} else {
+				writeTar(t, file, files, test.format)
+			}
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0666))
+			f, err := newTestFs(t, dir, "")
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"plain.txt", test.name + "/"}, listNames(t, f, ""))
+			assert.Equal(t, []string{test.name + "/dir/", test.name + "/hello.txt"}, listNames(t, f, test.name))
+			assert.Equal(t, []string{test.name + "/dir/empty.txt", test.name + "/dir/sub/"}, listNames(t, f, test.name+"/dir"))
+			_, err = f.List(ctx, test.name+"/missing")
+			assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+			for _, name := range sortedNames(files) {
+				o, err := f.NewObject(ctx, test.name+"/"+name)
+				require.NoError(t, err)
+				data := files[name]
+				assert.Equal(t, int64(len(data)), o.Size())
+				assert.True(t, modTime.Equal(o.ModTime(ctx)), "%v", o.ModTime(ctx))
+				assert.Equal(t, len(data), len(read(t, o)), name)
+				assert.True(t, bytes.Equal(data, read(t, o)), name)
+				if len(data) > 100 {
+					start := int64(len(data) / 3)
+					assert.Equal(t, data[start:start+50], read(t, o, &fs.RangeOption{Start: start, End: start + 49}))
+					assert.Equal(t, data[start:], read(t, o, &fs.SeekOption{Offset: start}))
+				}
+				if test.format == formatZip {
+					crc, err := o.Hash(ctx, hash.CRC32)
+					require.NoError(t, err)
+					assert.Len(t, crc, 8)
+				}
+			}
+			_, err = f.NewObject(ctx, test.name+"/missing")
+			assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+			_, err = f.NewObject(ctx, test.name+"/dir")
+			assert.ErrorIs(t, err, fs.ErrorIsDir)
+			_, err = f.NewObject(ctx, test.name)
+			assert.ErrorIs(t, err, fs.ErrorIsDir)
+
+			// Archives can't be changed
+			o, err := f.NewObject(ctx, test.name+"/hello.txt")
+			require.NoError(t, err)
+			assert.ErrorIs(t, o.Remove(ctx), errorReadOnly)
+			src := object.NewStaticObjectInfo(test.name+"/new.txt", time.Now(), 1, true, nil, nil)
+			_, err = f.Put(ctx, bytes.NewReader([]byte("x")), src)
+			assert.ErrorIs(t, err, errorReadOnly)
+			assert.ErrorIs(t, f.Rmdir(ctx, test.name+"/dir"), errorReadOnly)
+			assert.NoError(t, f.Mkdir(ctx, test.name+"/dir"))
+			assert.ErrorIs(t, f.Mkdir(ctx, test.name+"/newdir"), errorReadOnly)
+
+			// Root inside the archive
+			sub, err := newTestFs(t, dir, test.name+"/dir")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"empty.txt", "sub/"}, listNames(t, sub, ""))
+			o, err = sub.NewObject(ctx, "sub/log.txt")
+			require.NoError(t, err)
+			assert.Equal(t, files["dir/sub/log.txt"], read(t, o))
+
+			// Root pointing at a file inside the archive
+			sub, err = newTestFs(t, dir, test.name+"/dir/sub/log.txt")
+			assert.Equal(t, fs.ErrorIsFile, err)
+			assert.Equal(t, test.name+"/dir/sub", sub.Root())
+			_, err = sub.NewObject(ctx, "log.txt")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCorruptZip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.zip")
+	writeZip(t, file, map[string][]byte{"file.txt": bytes.Repeat([]byte("data "), 1000)})
+	f, err := newTestFs(t, dir, "")
+	require.NoError(t, err)
+	o, err := f.NewObject(ctx, "test.zip/file.txt")
+	require.NoError(t, err)
+	o.(*Member).entry.crc32++
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(in)
+	assert.ErrorContains(t, err, "CRC-32")
+	require.NoError(t, in.Close())
+
+	// Files which aren't archives give an error
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.zip"), []byte("not a zip file at all - not at all"), 0666))
+	_, err = f.List(ctx, "bad.zip")
+	assert.ErrorIs(t, err, errZipFormat)
+}
@@ -0,0 +1,64 @@
+// Test Archive filesystem interface
+package archive_test
+
+import (
+	"testing"
+
+	"github.com/rclone/rclone/backend/archive"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against the remote
+//
+// This checks the files outside archives are passed through.
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*archive.Object)(nil),
+		UnimplementableFsMethods: []string{
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"PutUnchecked",
+			"PutStream",
+			"PutIfNotExists",
+			"UserInfo",
+			"Disconnect",
+			"Copy",
+			"Move",
+			"DirMove",
+			"Purge",
+			"CleanUp",
+			"ListR",
+			"ListP",
+			"About",
+			"PublicLink",
+			"Shutdown",
+			"ChangeNotify",
+			"DirSetModTime",
+			"MkdirMetadata",
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+			"ID",
+		},
+	}
+	if *fstest.RemoteName == "" {
+		name := "TestArchive"
+		tempDir := t.TempDir()
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "archive"},
+			{Name: name, Key: "remote", Value: tempDir},
+		}
+		opt.RemoteName = name + ":"
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// format is the type of an archive
+type format int
+
+// Archive formats
+const (
+	formatNone format = iota
+	formatZip
+	formatTar
+	formatTarGz
+	formatTarZstd
+)
+
+// extensions maps file extensions onto archive formats
+var extensions = []struct {
+	ext    string
+	format format
+}{
+	{".zip", formatZip},
+	{".tar", formatTar},
+	{".tar.gz", formatTarGz},
+	{".tgz", formatTarGz},
+	{".tar.zst", formatTarZstd},
+	{".tzst", formatTarZstd},
+}
+
+// archiveFormat returns the format of the archive called name or
+// formatNone if it isn't the name of an archive
+func archiveFormat(name string) format {
+	name = strings.ToLower(name)
+	for _, x := range extensions {
+		if strings.HasSuffix(name, x.ext) {
+			return x.format
+		}
+	}
+	return formatNone
+}
+
+// entry is a file or directory in an archive
+type entry struct {
+	name    string    // path in the archive
+	dir     bool      // set if this is a directory
+	size    int64     // uncompressed size
+	modTime time.Time // modification time
+	offset  int64     // zip: offset of the local header, tar: offset of the data in the tar
+	// zip only
+	compressedSize int64
+	method         uint16
+	crc32          uint32
+	encrypted      bool
+}
+
+// archive is the index of the files in an archive
+type archive struct {
+	o       fs.Object           // the archive on the base remote
+	format  format              // format of the archive
+	entries map[string]*entry   // files and directories by path
+	dirs    map[string][]*entry // contents of each directory
+}
+
+// newArchive reads the index of the archive in o
+func newArchive(ctx context.Context, o fs.Object) (*archive, error) {
+	a := &archive{
+		o:      o,
+		format: archiveFormat(path.Base(o.Remote())),
+	}
+	var entries []*entry
+	var err error
+	if a.format == formatZip {
+		entries, err = readZipIndex(ctx, o)
+	} else {
+		entries, err = readTarIndex(ctx, o, a.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.add(entries, o.ModTime(ctx))
+	return a, nil
+}
+
+// cleanName returns name as a relative path or "" if it can't be used
+func cleanName(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if name == "" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// add entries to the index of the archive
+//
+// Directories which aren't in the archive, but which files are in,
+// are made with modTime.
+func (a *archive) add(entries []*entry, modTime time.Time) {
+	a.entries = make(map[string]*entry, len(entries))
+	a.dirs = map[string][]*entry{}
+	for _, e := range entries {
+		e.name = cleanName(e.name)
+		if e.name == "" {
+			continue
+		}
+		// Later entries replace earlier ones like tar does
+		a.entries[e.name] = e
+		for dir := parentDir(e.name); dir != ""; dir = parentDir(dir) {
+			if _, found := a.entries[dir]; found {
+				break
+			}
+			a.entries[dir] = &entry{name: dir, dir: true, modTime: modTime}
+		}
+	}
+	for name, e := range a.entries {
+		dir := parentDir(name)
+		a.dirs[dir] = append(a.dirs[dir], e)
+	}
+}
+
+// list the directory dir in the archive
+func (a *archive) list(f *Fs, dir string) (entries fs.DirEntries, err error) {
+	if e := a.entries[dir]; dir != "" && (e == nil || !e.dir) {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, e := range a.dirs[dir] {
+		if e.dir {
+			entries = append(entries, fs.NewDir(a.remote(f, e), e.modTime))
+		} else {
+			entries = append(entries, f.newMember(a, e))
+		}
+	}
+	return entries, nil
+}
+
+// remote returns the path of e on f
+func (a *archive) remote(f *Fs, e *entry) string {
+	return f.remote(path.Join(a.o.Remote(), e.name))
+}
@@ -0,0 +1,233 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object is a file on the base remote which isn't an archive
+type Object struct {
+	fs.Object
+	f      *Fs
+	remote string
+}
+
+// newObject wraps o from the base remote
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+		remote: f.remote(o.Remote()),
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.Object.Update(ctx, in, fs.NewOverrideRemote(src, o.Object.Remote()), options...)
+}
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object {
+	return o.Object
+}
+
+// Member is a file inside an archive
+type Member struct {
+	f       *Fs
+	remote  string
+	archive *archive
+	entry   *entry
+}
+
+// newMember makes a Member for e in a
+func (f *Fs) newMember(a *archive, e *entry) *Member {
+	return &Member{
+		f:       f,
+		remote:  a.remote(f, e),
+		archive: a,
+		entry:   e,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (m *Member) Fs() fs.Info {
+	return m.f
+}
+
+// Return a string version
+func (m *Member) String() string {
+	if m == nil {
+		return "<nil>"
+	}
+	return m.remote
+}
+
+// Remote returns the remote path
+func (m *Member) Remote() string {
+	return m.remote
+}
+
+// ModTime returns the modification time of the file
+func (m *Member) ModTime(ctx context.Context) time.Time {
+	return m.entry.modTime
+}
+
+// Size returns the size of the file
+func (m *Member) Size() int64 {
+	return m.entry.size
+}
+
+// Hash returns the CRC-32 of files in zip archives
+//
+// The hashes of the base remote aren't known for files in archives so
+// they are returned as empty.
+func (m *Member) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht == hash.CRC32 && m.archive.format == formatZip {
+		return fmt.Sprintf("%08x", m.entry.crc32), nil
+	}
+	if m.f.Hashes().Contains(ht) {
+		return "", nil
+	}
+	return "", hash.ErrUnsupported
+}
+
+// Storable says whether this object can be stored
+func (m *Member) Storable() bool {
+	return true
+}
+
+// SetModTime can't be done in archives
+func (m *Member) SetModTime(ctx context.Context, modTime time.Time) error {
+	return fs.ErrorCantSetModTime
+}
+
+// Update can't be done in archives
+func (m *Member) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errorReadOnly
+}
+
+// Remove can't be done in archives
+func (m *Member) Remove(ctx context.Context) error {
+	return errorReadOnly
+}
+
+// Open the file in the archive for read
+func (m *Member) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(m.Size())
+		default:
+			if option.Mandatory() {
+				fs.Logf(m, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if m.archive.format == formatZip {
+		return m.archive.openZip(ctx, m.entry, offset, limit)
+	}
+	return m.archive.openTar(ctx, m.entry, offset, limit)
+}
+
+// eofReader is an empty reader
+type eofReader struct{}
+
+// Read returns io.EOF
+func (eofReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// readCloser reads from Reader and closes all the closers
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close all the closers returning the first error
+func (rc *readCloser) Close() (err error) {
+	for _, closer := range rc.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// skip discards offset bytes from rc then limits it to limit bytes
+// unless limit is -1
+func skip(rc *readCloser, offset, limit int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		_, err := io.CopyN(io.Discard, rc.Reader, offset)
+		if err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
+	}
+	if limit >= 0 {
+		rc.Reader = io.LimitReader(rc.Reader, limit)
+	}
+	return rc, nil
+}
+
+// crcReader checks the CRC-32 of the data read through it
+type crcReader struct {
+	r    io.Reader
+	crc  uint32 // CRC-32 so far
+	want uint32 // expected CRC-32
+	n    int64  // bytes left to read
+}
+
+// newCRCReader checks the size bytes read from r have CRC-32 want
+func newCRCReader(r io.Reader, want uint32, size int64) *crcReader {
+	return &crcReader{r: r, want: want, n: size}
+}
+
+// Read from the reader returning an error if the CRC is wrong at the end
+func (c *crcReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:n])
+	c.n -= int64(n)
+	if err == io.EOF {
+		if c.n != 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if c.crc != c.want {
+			return n, fmt.Errorf("corrupted file in archive: CRC-32 is %08x, expecting %08x", c.crc, c.want)
+		}
+	}
+	return n, err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+	_ fs.Object          = (*Member)(nil)
+)
@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+)
+
+// Tar archives have no index so they are indexed by reading through
+// them. Uncompressed tar files are read with ranged reads skipping
+// over the data of large files, and the files in them can be read
+// directly. Compressed tar files must be decompressed from the start
+// to index them and to read each file.
+
+// Skips shorter than this are read rather than opening the file again
+const tarSeekThreshold = 1024 * 1024
+
+// seekReader reads an object sequentially, reopening it to skip large
+// amounts of data
+type seekReader struct {
+	ctx context.Context
+	o   fs.Object
+	in  io.ReadCloser // nil if not open
+	pos int64         // position in the object
+}
+
+// Read from the object opening it at pos if necessary
+func (r *seekReader) Read(p []byte) (n int, err error) {
+	if r.in == nil {
+		var options []fs.OpenOption
+		if r.pos > 0 {
+			options = append(options, &fs.SeekOption{Offset: r.pos})
+		}
+		r.in, err = r.o.Open(r.ctx, options...)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.in.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek to a new position in the object
+//
+// Short skips forwards are read and discarded, otherwise the object is
+// opened again at the new position on the next Read.
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.o.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	skip := offset - r.pos
+	if skip == 0 {
+		return r.pos, nil
+	}
+	if r.in != nil && skip > 0 && skip < tarSeekThreshold {
+		_, err := io.CopyN(io.Discard, r, skip)
+		return r.pos, err
+	}
+	_ = r.Close()
+	r.pos = offset
+	return r.pos, nil
+}
+
+// Close the object if it is open
+func (r *seekReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read from the underlying reader counting the bytes
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newTarDecompressor returns a reader for the uncompressed tar data in
+// in for the compressed tar formats
+func newTarDecompressor(in io.Reader, format format) (io.ReadCloser, error) {
+	switch format {
+	case formatTarGz:
+		return gzip.NewReader(in)
+	case formatTarZstd:
+		dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown tar format %d", format)
+}
+
+// readTarIndex reads through the tar file o recording where the files
+// are
+func readTarIndex(ctx context.Context, o fs.Object, format format) (entries []*entry, err error) {
+	sr := &seekReader{ctx: ctx, o: o}
+	defer fs.CheckClose(sr, &err)
+	var (
+		in       io.Reader = sr
+		position           = func() int64 { return sr.pos }
+	)
+	if format != formatTar {
+		dec, err := newTarDecompressor(sr, format)
+		if err != nil {
+			return nil, err
+		}
+		defer fs.CheckClose(dec, &err)
+		counter := &countingReader{r: dec}
+		in, position = counter, func() int64 { return counter.n }
+	}
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			fs.Debugf(o, "Ignoring %q in archive which isn't a file or directory", header.Name)
+			continue
+		}
+		entries = append(entries, &entry{
+			name:    header.Name,
+			dir:     header.Typeflag == tar.TypeDir,
+			size:    header.Size,
+			modTime: header.ModTime,
+			offset:  position(),
+		})
+	}
+	return entries, nil
+}
+
+// openTar opens the file e in the tar archive a reading limit bytes
+// from offset, or to the end if limit is -1
+func (a *archive) openTar(ctx context.Context, e *entry, offset, limit int64) (io.ReadCloser, error) {
+	end := e.size
+	if limit >= 0 {
+		end = min(end, offset+limit)
+	}
+	if offset >= end {
+		return io.NopCloser(eofReader{}), nil
+	}
+	if a.format == formatTar {
+		return a.o.Open(ctx, &fs.RangeOption{Start: e.offset + offset, End: e.offset + end - 1})
+	}
+	in, err := a.o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := newTarDecompressor(in, a.format)
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	rc := &readCloser{Reader: dec, closers: []io.Closer{dec, in}}
+	return skip(rc, e.offset+offset, end-offset)
+}
@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+)
+
+// Zip archives are read by reading the central directory at the end
+// of the file with ranged reads, so only the index and the files
+// which are read are downloaded.
+const (
+	zipEOCDSignature      = 0x06054b50 // end of central directory
+	zipEOCD64Signature    = 0x06064b50 // zip64 end of central directory
+	zipLocatorSignature   = 0x07064b50 // zip64 end of central directory locator
+	zipCentralSignature   = 0x02014b50 // central directory file header
+	zipLocalSignature     = 0x04034b50 // local file header
+	zipEOCDLen            = 22
+	zipEOCD64Len          = 56
+	zipLocatorLen         = 20
+	zipCentralLen         = 46
+	zipLocalLen           = 30
+	zipMaxCommentLen      = 65535
+	zipFlagEncrypted      = 0x1
+	zipMethodStore        = 0
+	zipMethodDeflate      = 8
+	zipMethodZstd         = 93
+	zipExtraZip64         = 0x0001
+	zipExtraExtendedTime  = 0x5455
+	zipMax32              = 0xffffffff
+	zipMax16              = 0xffff
+	zipExtraHeaderLen     = 4
+	zipExtendedTimeModBit = 0x1
+)
+
+var errZipFormat = errors.New("not a valid zip file")
+
+// readRange reads length bytes at offset from o
+func readRange(ctx context.Context, o fs.Object, offset, length int64) (buf []byte, err error) {
+	in, err := o.Open(ctx, &fs.RangeOption{Start: offset, End: offset + length - 1})
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	buf = make([]byte, length)
+	_, err = io.ReadFull(in, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readZipIndex reads the central directory of the zip file o
+func readZipIndex(ctx context.Context, o fs.Object) (entries []*entry, err error) {
+	size := o.Size()
+	if size < zipEOCDLen {
+		return nil, errZipFormat
+	}
+	// Find the end of central directory record which is followed
+	// by a comment of unknown length
+	tailLen := min(size, zipLocatorLen+zipEOCDLen+zipMaxCommentLen)
+	tail, err := readRange(ctx, o, size-tailLen, tailLen)
+	if err != nil {
+		return nil, err
+	}
+	i := len(tail) - zipEOCDLen
+	for ; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipEOCDSignature {
+			break
+		}
+	}
+	if i < 0 {
+		return nil, errZipFormat
+	}
+	eocd := tail[i:]
+	count := uint64(binary.LittleEndian.Uint16(eocd[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(eocd[12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(eocd[16:]))
+	if count == zipMax16 || dirSize == zipMax32 || dirOffset == zipMax32 {
+		// Zip64 - the real values are in the zip64 record
+		if i < zipLocatorLen {
+			return nil, errZipFormat
+		}
+		locator := tail[i-zipLocatorLen:]
+		if binary.LittleEndian.Uint32(locator) != zipLocatorSignature {
+			return nil, errZipFormat
+		}
+		eocd64, err := readRange(ctx, o, int64(binary.LittleEndian.Uint64(locator[8:])), zipEOCD64Len)
+		if err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(eocd64) != zipEOCD64Signature {
+			return nil, errZipFormat
+		}
+		count = binary.LittleEndian.Uint64(eocd64[32:])
+		dirSize = binary.LittleEndian.Uint64(eocd64[40:])
+		dirOffset = binary.LittleEndian.Uint64(eocd64[48:])
+	}
+	if dirOffset+dirSize > uint64(size) {
+		return nil, errZipFormat
+	}
+	if dirSize == 0 {
+		return nil, nil
+	}
+
+	// Read the central directory in one go
+	in, err := o.Open(ctx, &fs.RangeOption{Start: int64(dirOffset), End: int64(dirOffset+dirSize) - 1})
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	r := bufio.NewReader(in)
+	entries = make([]*entry, 0, min(count, 1024*1024))
+	header := make([]byte, zipCentralLen)
+	for range count {
+		if _, err = io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("failed to read zip central directory: %w", err)
+		}
+		if binary.LittleEndian.Uint32(header) != zipCentralSignature {
+			return nil, errZipFormat
+		}
+		nameLen := int(binary.LittleEndian.Uint16(header[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(header[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(header[32:]))
+		variable := make([]byte, nameLen+extraLen+commentLen)
+		if _, err = io.ReadFull(r, variable); err != nil {
+			return nil, fmt.Errorf("failed to read zip central directory: %w", err)
+		}
+		name := string(variable[:nameLen])
+		e := &entry{
+			name:           name,
+			dir:            len(name) > 0 && name[len(name)-1] == '/',
+			method:         binary.LittleEndian.Uint16(header[10:]),
+			encrypted:      binary.LittleEndian.Uint16(header[8:])&zipFlagEncrypted != 0,
+			modTime:        msDosTime(binary.LittleEndian.Uint16(header[14:]), binary.LittleEndian.Uint16(header[12:])),
+			crc32:          binary.LittleEndian.Uint32(header[16:]),
+			compressedSize: int64(binary.LittleEndian.Uint32(header[20:])),
+			size:           int64(binary.LittleEndian.Uint32(header[24:])),
+			offset:         int64(binary.LittleEndian.Uint32(header[42:])),
+		}
+		e.parseExtra(variable[nameLen : nameLen+extraLen])
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// msDosTime converts an MS-DOS date and time into a time
+func msDosTime(date, t uint16) time.Time {
+	return time.Date(
+		int(date>>9)+1980,
+		time.Month(date>>5&0xf),
+		int(date&0x1f),
+		int(t>>11),
+		int(t>>5&0x3f),
+		int(t&0x1f)*2,
+		0,
+		time.UTC,
+	)
+}
+
+// parseExtra reads the zip64 sizes and offset and the extended
+// modification time from the extra fields of a central directory
+// entry
+func (e *entry) parseExtra(extra []byte) {
+	for len(extra) >= zipExtraHeaderLen {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[zipExtraHeaderLen:]
+		if size > len(extra) {
+			return
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		switch id {
+		case zipExtraZip64:
+			// Only the values which overflowed are present
+			for _, value := range []*int64{&e.size, &e.compressedSize, &e.offset} {
+				if *value != zipMax32 {
+					continue
+				}
+				if len(field) < 8 {
+					break
+				}
+				*value = int64(binary.LittleEndian.Uint64(field))
+				field = field[8:]
+			}
+		case zipExtraExtendedTime:
+			if len(field) >= 5 && field[0]&zipExtendedTimeModBit != 0 {
+				e.modTime = time.Unix(int64(int32(binary.LittleEndian.Uint32(field[1:]))), 0)
+			}
+		}
+	}
+}
+
+// openZip opens the file e in the zip archive a reading limit bytes
+// from offset, or to the end if limit is -1
+func (a *archive) openZip(ctx context.Context, e *entry, offset, limit int64) (io.ReadCloser, error) {
+	if e.encrypted {
+		return nil, fmt.Errorf("can't read encrypted file %q from zip", e.name)
+	}
+	var decompress func(io.Reader) (io.ReadCloser, error)
+	switch e.method {
+	case zipMethodStore:
+	case zipMethodDeflate:
+		decompress = func(in io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(in), nil
+		}
+	case zipMethodZstd:
+		decompress = func(in io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		}
+	default:
+		return nil, fmt.Errorf("can't read file %q from zip: unsupported compression method %d", e.name, e.method)
+	}
+	if e.size == 0 || offset >= e.size {
+		return io.NopCloser(eofReader{}), nil
+	}
+
+	// Find the start of the data from the local header
+	header, err := readRange(ctx, a.o, e.offset, zipLocalLen)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(header) != zipLocalSignature {
+		return nil, errZipFormat
+	}
+	dataOffset := e.offset + zipLocalLen + int64(binary.LittleEndian.Uint16(header[26:])) + int64(binary.LittleEndian.Uint16(header[28:]))
+
+	if decompress == nil {
+		// Stored files can be read directly
+		end := e.size
+		if limit >= 0 {
+			end = min(end, offset+limit)
+		}
+		return a.o.Open(ctx, &fs.RangeOption{Start: dataOffset + offset, End: dataOffset + end - 1})
+	}
+	if e.compressedSize == 0 {
+		return nil, errZipFormat
+	}
+	in, err := a.o.Open(ctx, &fs.RangeOption{Start: dataOffset, End: dataOffset + e.compressedSize - 1})
+	if err != nil {
+		return nil, err
+	}
+	dec, err := decompress(in)
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	rc := &readCloser{Reader: dec, closers: []io.Closer{dec, in}}
+	if offset == 0 && limit < 0 {
+		rc.Reader = newCRCReader(dec, e.crc32, e.size)
+	}
+	return skip(rc, offset, limit)
+}
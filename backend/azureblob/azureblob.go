@@ -1546,6 +1546,20 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	return fs, fs.Update(ctx, in, src, options...)
 }
 
+// PutIfNotExists puts the object into the container only if there is
+// no blob there already, returning fs.ErrorObjectExists if there is.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	err := o.update(ctx, in, src, true, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	return f.Put(ctx, in, src, options...)
@@ -2503,6 +2517,23 @@ func newBlockIDCreator() (bic *blockIDCreator, err error) {
 	return bic, nil
 }
 
+// recreate the blockID creator with the ID returned from ID
+func blockIDCreatorFromID(id string) (bic *blockIDCreator, err error) {
+	bic = &blockIDCreator{}
+	random, err := hex.DecodeString(id)
+	if err != nil || len(random) != len(bic.random) {
+		return nil, fmt.Errorf("invalid resume ID %q", id)
+	}
+	copy(bic.random[:], random)
+	return bic, nil
+}
+
+// ID returns an identifier for this creator which can be passed to
+// blockIDCreatorFromID
+func (bic *blockIDCreator) ID() string {
+	return hex.EncodeToString(bic.random[:])
+}
+
 // create a new block ID for chunkNumber
 func (bic *blockIDCreator) newBlockID(chunkNumber uint64) string {
 	var binaryBlockID [16]byte
@@ -2550,6 +2581,7 @@ type azChunkWriter struct {
 	o         *Object
 	bic       *blockIDCreator
 	checker   *checkForInvalidBlockOrBlob
+	resumed   bool // set if continuing an upload from a previous run
 }
 
 // OpenChunkWriter returns the chunk size and a ChunkWriter
@@ -2613,6 +2645,27 @@ func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectIn
 		Concurrency: o.fs.opt.UploadConcurrency,
 		//LeavePartsOnError: o.fs.opt.LeavePartsOnError,
 	}
+	// Continue an upload from a previous run if requested
+	for _, option := range options {
+		if resumeOption, ok := option.(*fs.ResumeOption); ok && resumeOption.ID != "" {
+			chunkWriter.bic, err = blockIDCreatorFromID(resumeOption.ID)
+			if err != nil {
+				return info, nil, err
+			}
+			for chunkNumber := range resumeOption.Chunks {
+				chunkWriter.blocks = append(chunkWriter.blocks, azBlock{
+					chunkNumber: uint64(chunkNumber),
+					id:          chunkWriter.bic.newBlockID(uint64(chunkNumber)),
+				})
+			}
+			chunkWriter.resumed = true
+			if err = chunkWriter.checkBlocks(ctx); err != nil {
+				return info, nil, err
+			}
+			fs.Debugf(o, "open chunk writer: resuming multipart upload with %d blocks", len(chunkWriter.blocks))
+			return info, chunkWriter, nil
+		}
+	}
 	chunkWriter.bic, err = newBlockIDCreator()
 	if err != nil {
 		return info, nil, err
@@ -2621,6 +2674,49 @@ func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectIn
 	return info, chunkWriter, nil
 }
 
+// checkBlocks checks the blocks of the upload being resumed are still
+// staged as they are discarded if not committed within a week
+func (w *azChunkWriter) checkBlocks(ctx context.Context) error {
+	var blockList blockblob.GetBlockListResponse
+	err := w.f.pacer.Call(func() (bool, error) {
+		var err error
+		blockList, err = w.o.getBlockBlobSVC().GetBlockList(ctx, blockblob.BlockListTypeUncommitted, nil)
+		return w.f.shouldRetry(ctx, err)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read uncommitted block list: %w", err)
+	}
+	staged := make(map[string]struct{}, len(blockList.UncommittedBlocks))
+	for _, block := range blockList.UncommittedBlocks {
+		if block.Name != nil {
+			staged[*block.Name] = struct{}{}
+		}
+	}
+	for _, block := range w.blocks {
+		if _, ok := staged[block.id]; !ok {
+			return fmt.Errorf("block for chunk %d is no longer staged", block.chunkNumber)
+		}
+	}
+	return nil
+}
+
+// ResumeID returns the random part of the block IDs which identifies
+// the upload
+func (w *azChunkWriter) ResumeID() string {
+	return w.bic.ID()
+}
+
+// ChunkToken returns the token for a written chunk which isn't needed
+// as the block ID can be recreated from the chunk number
+func (w *azChunkWriter) ChunkToken(chunkNumber int) string {
+	return ""
+}
+
+// Resumed returns true if this is continuing a previous upload
+func (w *azChunkWriter) Resumed() bool {
+	return w.resumed
+}
+
 // isInvalidBlockOrBlob looks for the InvalidBlockOrBlob error in err
 // returning true if it is found
 func isInvalidBlockOrBlob(err error) bool {
@@ -2725,12 +2821,15 @@ func (w *azChunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader
 	// Create a new blockID
 	blockID := w.bic.newBlockID(uint64(chunkNumber))
 
-	// Save the blockID for the commit
+	// Save the blockID for the commit unless the chunk was
+	// written before, as the block is replaced when staged again
 	w.blocksMu.Lock()
-	w.blocks = append(w.blocks, azBlock{
-		chunkNumber: uint64(chunkNumber),
-		id:          blockID,
-	})
+	if !slices.ContainsFunc(w.blocks, func(b azBlock) bool { return b.chunkNumber == uint64(chunkNumber) }) {
+		w.blocks = append(w.blocks, azBlock{
+			chunkNumber: uint64(chunkNumber),
+			id:          blockID,
+		})
+	}
 	w.blocksMu.Unlock()
 
 	err = w.f.pacer.Call(func() (bool, error) {
@@ -2956,8 +3055,14 @@ func (o *Object) uploadSinglepart(ctx context.Context, in io.Reader, size int64,
 		Tier:        parseTier(o.fs.opt.AccessTier),
 		HTTPHeaders: &ui.httpHeaders,
 	}
+	if ui.ifNotExists {
+		etagAny := azcore.ETagAny
+		options.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+		}
+	}
 
-	return o.fs.pacer.Call(func() (bool, error) {
+	err = o.fs.pacer.Call(func() (bool, error) {
 		// rewind the reader on retry
 		_, err = rs.Seek(0, io.SeekStart)
 		if err != nil {
@@ -2966,6 +3071,10 @@ func (o *Object) uploadSinglepart(ctx context.Context, in io.Reader, size int64,
 		_, err = ui.blb.Upload(ctx, rs, &options)
 		return o.fs.shouldRetry(ctx, err)
 	})
+	if storageErr, ok := err.(*azcore.ResponseError); ok && ui.ifNotExists && storageErr.ErrorCode == string(bloberror.BlobAlreadyExists) {
+		return fs.ErrorObjectExists
+	}
+	return err
 }
 
 // Info needed for an upload
@@ -2973,6 +3082,7 @@ type uploadInfo struct {
 	blb         *blockblob.Client
 	httpHeaders blob.HTTPHeaders
 	isDirMarker bool
+	ifNotExists bool // only upload if the blob doesn't exist
 }
 
 // Prepare the object for upload
@@ -3061,6 +3171,14 @@ func (o *Object) prepareUpload(ctx context.Context, src fs.ObjectInfo, options [
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	return o.update(ctx, in, src, false, options...)
+}
+
+// update the object with the contents of the io.Reader, modTime and size
+//
+// If ifNotExists is set then the blob is only created if it doesn't
+// exist, returning fs.ErrorObjectExists if it does.
+func (o *Object) update(ctx context.Context, in io.Reader, src fs.ObjectInfo, ifNotExists bool, options ...fs.OpenOption) (err error) {
 	if o.accessTier == blob.AccessTierArchive {
 		if o.fs.opt.ArchiveTierDelete {
 			fs.Debugf(o, "deleting archive tier blob before updating")
@@ -3077,6 +3195,9 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	multipartUpload := size < 0 || size > int64(o.fs.opt.ChunkSize)
 	var ui uploadInfo
 
+	if ifNotExists && multipartUpload {
+		return fmt.Errorf("can't upload if not exists with a multipart upload: size %d: %w", size, fs.ErrorNotImplemented)
+	}
 	if multipartUpload {
 		ui, err = o.uploadMultipart(ctx, in, src, options...)
 	} else {
@@ -3084,6 +3205,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		if err != nil {
 			return fmt.Errorf("failed to prepare upload: %w", err)
 		}
+		ui.ifNotExists = ifNotExists
 		err = o.uploadSinglepart(ctx, in, size, ui)
 	}
 	if err != nil {
@@ -3181,15 +3303,17 @@ func parseTier(tier string) *blob.AccessTier {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = &Fs{}
-	_ fs.Copier          = &Fs{}
-	_ fs.PutStreamer     = &Fs{}
-	_ fs.Purger          = &Fs{}
-	_ fs.ListRer         = &Fs{}
-	_ fs.ListPer         = &Fs{}
-	_ fs.OpenChunkWriter = &Fs{}
-	_ fs.Object          = &Object{}
-	_ fs.MimeTyper       = &Object{}
-	_ fs.GetTierer       = &Object{}
-	_ fs.SetTierer       = &Object{}
+	_ fs.Fs                 = &Fs{}
+	_ fs.Copier             = &Fs{}
+	_ fs.PutStreamer        = &Fs{}
+	_ fs.PutIfNotExistser   = &Fs{}
+	_ fs.Purger             = &Fs{}
+	_ fs.ListRer            = &Fs{}
+	_ fs.ListPer            = &Fs{}
+	_ fs.OpenChunkWriter    = &Fs{}
+	_ fs.ChunkWriterResumer = &azChunkWriter{}
+	_ fs.Object             = &Object{}
+	_ fs.MimeTyper          = &Object{}
+	_ fs.GetTierer          = &Object{}
+	_ fs.SetTierer          = &Object{}
 )
@@ -323,6 +323,27 @@ type CancelLargeFileResponse struct {
 	BucketID  string `json:"bucketId"`  // The unique ID of the bucket.
 }
 
+// ListPartsRequest is passed to b2_list_parts
+type ListPartsRequest struct {
+	ID              string `json:"fileId"`                    // The unique identifier of the file being uploaded.
+	StartPartNumber int    `json:"startPartNumber,omitempty"` // The first part to return.
+	MaxPartCount    int    `json:"maxPartCount,omitempty"`    // The maximum number of parts to return.
+}
+
+// Part describes a part of a large file which has been uploaded
+type Part struct {
+	ID            string `json:"fileId"`        // The unique identifier of the file being uploaded.
+	PartNumber    int    `json:"partNumber"`    // Which part this is (starting from 1)
+	ContentLength int64  `json:"contentLength"` // The number of bytes stored in the part.
+	SHA1          string `json:"contentSha1"`   // The SHA1 of the bytes stored in the part.
+}
+
+// ListPartsResponse is the response to ListPartsRequest
+type ListPartsResponse struct {
+	Parts          []Part `json:"parts"`          // The parts uploaded so far
+	NextPartNumber *int   `json:"nextPartNumber"` // What to pass in to startPartNumber for the next search to continue where this one left off, or nil if there are no more
+}
+
 // CopyFileRequest is as passed to b2_copy_file
 type CopyFileRequest struct {
 	SourceID          string            `json:"sourceFileId"`                  // The ID of the source file being copied.
@@ -2458,17 +2458,18 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = &Fs{}
-	_ fs.Purger          = &Fs{}
-	_ fs.Copier          = &Fs{}
-	_ fs.PutStreamer     = &Fs{}
-	_ fs.CleanUpper      = &Fs{}
-	_ fs.ListRer         = &Fs{}
-	_ fs.ListPer         = &Fs{}
-	_ fs.PublicLinker    = &Fs{}
-	_ fs.OpenChunkWriter = &Fs{}
-	_ fs.Commander       = &Fs{}
-	_ fs.Object          = &Object{}
-	_ fs.MimeTyper       = &Object{}
-	_ fs.IDer            = &Object{}
+	_ fs.Fs                 = &Fs{}
+	_ fs.Purger             = &Fs{}
+	_ fs.Copier             = &Fs{}
+	_ fs.PutStreamer        = &Fs{}
+	_ fs.CleanUpper         = &Fs{}
+	_ fs.ListRer            = &Fs{}
+	_ fs.ListPer            = &Fs{}
+	_ fs.PublicLinker       = &Fs{}
+	_ fs.OpenChunkWriter    = &Fs{}
+	_ fs.ChunkWriterResumer = &largeUpload{}
+	_ fs.Commander          = &Fs{}
+	_ fs.Object             = &Object{}
+	_ fs.MimeTyper          = &Object{}
+	_ fs.IDer               = &Object{}
 )
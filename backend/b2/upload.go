@@ -86,6 +86,7 @@ type largeUpload struct {
 	chunkSize int64                           // chunk size to use
 	src       *Object                         // if copying, object we are reading from
 	info      *api.FileInfo                   // final response with info about the object
+	resumed   bool                            // set if continuing an upload from a previous run
 }
 
 // newLargeUpload starts an upload of object o from in with metadata in src
@@ -150,12 +151,23 @@ func (f *Fs) newLargeUpload(ctx context.Context, o *Object, in io.Reader, src fs
 		Options: optionsToSend,
 	}
 	var response api.StartLargeFileResponse
-	err = f.pacer.Call(func() (bool, error) {
-		resp, err := f.srv.CallJSON(ctx, &opts, &request, &response)
-		return f.shouldRetry(ctx, resp, err)
-	})
-	if err != nil {
-		return nil, err
+	var resumeOption *fs.ResumeOption
+	for _, option := range options {
+		if do, ok := option.(*fs.ResumeOption); ok && do.ID != "" {
+			resumeOption = do
+		}
+	}
+	if resumeOption != nil {
+		// Continue the upload from a previous run
+		response.ID = resumeOption.ID
+	} else {
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err := f.srv.CallJSON(ctx, &opts, &request, &response)
+			return f.shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 	up = &largeUpload{
 		f:         f,
@@ -168,6 +180,17 @@ func (f *Fs) newLargeUpload(ctx context.Context, o *Object, in io.Reader, src fs
 		sha1s:     make([]string, 0, 16),
 		chunkSize: int64(chunkSize),
 	}
+	if resumeOption != nil {
+		for chunkNumber, sha1 := range resumeOption.Chunks {
+			up.addSha1(chunkNumber, sha1)
+		}
+		up.resumed = true
+		if err = up.checkParts(ctx, resumeOption.Chunks); err != nil {
+			_ = up.Abort(ctx)
+			return nil, err
+		}
+		fs.Debugf(o, "Resuming large file %s with %d parts", up.what, len(resumeOption.Chunks))
+	}
 	// unwrap the accounting from the input, we use wrap to put it
 	// back on after the buffering
 	if doCopy {
@@ -228,6 +251,64 @@ func (up *largeUpload) addSha1(chunkNumber int, sha1 string) {
 	up.sha1s[chunkNumber] = sha1
 }
 
+// checkParts checks the parts with the SHA1s in chunks have been
+// uploaded to the large file
+func (up *largeUpload) checkParts(ctx context.Context, chunks map[int]string) error {
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/b2_list_parts",
+	}
+	var request = api.ListPartsRequest{
+		ID:           up.id,
+		MaxPartCount: 1000,
+	}
+	sha1s := make(map[int]string, len(chunks))
+	for {
+		var response api.ListPartsResponse
+		err := up.f.pacer.Call(func() (bool, error) {
+			resp, err := up.f.srv.CallJSON(ctx, &opts, &request, &response)
+			return up.f.shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list parts of large file %q: %w", up.id, err)
+		}
+		for _, part := range response.Parts {
+			sha1s[part.PartNumber-1] = part.SHA1
+		}
+		if response.NextPartNumber == nil {
+			break
+		}
+		request.StartPartNumber = *response.NextPartNumber
+	}
+	for chunkNumber, sha1 := range chunks {
+		if sha1s[chunkNumber] != sha1 {
+			return fmt.Errorf("part %d of large file %q doesn't match the one uploaded", chunkNumber+1, up.id)
+		}
+	}
+	return nil
+}
+
+// ResumeID returns the ID of the large file being uploaded
+func (up *largeUpload) ResumeID() string {
+	return up.id
+}
+
+// ChunkToken returns the SHA1 of chunkNumber which is needed to
+// finish the upload
+func (up *largeUpload) ChunkToken(chunkNumber int) string {
+	up.sha1smu.Lock()
+	defer up.sha1smu.Unlock()
+	if chunkNumber < len(up.sha1s) {
+		return up.sha1s[chunkNumber]
+	}
+	return ""
+}
+
+// Resumed returns true if this is continuing a previous upload
+func (up *largeUpload) Resumed() bool {
+	return up.resumed
+}
+
 // WriteChunk will write chunk number with reader bytes, where chunk number >= 0
 func (up *largeUpload) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (size int64, err error) {
 	// Only account after the checksum reads have been done
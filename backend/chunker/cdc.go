@@ -0,0 +1,674 @@
+package chunker
+
+// Content-defined chunking
+//
+// In the "cdc" chunking mode the boundaries of data chunks are
+// chosen by the content of the file rather than by the offset in it,
+// so inserting or deleting bytes only changes the chunks around the
+// edit. Each data chunk is named by its SHA-256 and kept in a chunk
+// store shared by all the files on the wrapped remote, so a chunk
+// already in the store is never uploaded again.
+//
+// The list of chunks making up a composite file is offloaded to the
+// control chunk of type "cdc" next to the meta object, as it is too
+// big for the meta object itself. The meta object carries format
+// version 3 so older releases refuse to touch such files.
+//
+// The chunk lists are the reference counts of the chunks in the
+// store. Removing a file never removes data chunks as other files
+// may share them. Instead CleanUp counts the references from all the
+// chunk lists on the wrapped remote and removes the chunks which are
+// no longer referenced. The counts aren't kept in the store itself
+// as remotes can't update them atomically, and a lost update would
+// remove a chunk which is still in use.
+//
+// The modification time of a chunk in the store is the last time it
+// was uploaded or reused. CleanUp keeps the chunks stamped within the
+// grace period before it started, so a chunk can't be removed between
+// being reused by an upload and the upload writing its chunk list.
+// Uploads longer than that write the chunk list of the chunks so far
+// under its temporary name every quarter of the grace period, and
+// CleanUp counts these as references too. Temporary chunk lists which
+// haven't been written within the grace period are left from uploads
+// which stopped, so CleanUp removes them.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+const (
+	cdcStoreDir  = ".rclone_cdc" // directory of the chunk store in the root of the wrapped remote
+	cdcCtrlType  = "cdc"         // control chunk type of the chunk list
+	cdcHashType  = "sha256"      // hash naming the chunks in the store
+	cdcListVer   = 1             // version of the chunk list format
+	minCDCChunk  = 64            // smallest average chunk size allowed
+	cdcMinFactor = 4             // minimum chunk size is the average divided by this
+	cdcMaxFactor = 4             // maximum chunk size is the average multiplied by this
+)
+
+// cdcGear is the table of random numbers the rolling hash uses.
+//
+// It must never change as this would move all the chunk boundaries.
+var cdcGear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	seed := uint64(0x6a09e667f3bcc908)
+	for i := range cdcGear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		cdcGear[i] = z ^ (z >> 31)
+	}
+}
+
+// cdcChunker cuts a stream into content-defined chunks using FastCDC
+// with normalized chunking.
+type cdcChunker struct {
+	in       io.Reader
+	buf      []byte // holds up to a maximum sized chunk
+	n        int    // number of bytes in buf
+	off      int    // end of the chunk returned last
+	eof      bool   // set when in is exhausted
+	minSize  int
+	avgSize  int
+	maxSize  int
+	maskHard uint64 // used before the average size to make small chunks rarer
+	maskEasy uint64 // used after the average size to make large chunks rarer
+}
+
+// newCDCChunker returns a chunker with the average chunk size given
+// rounded down to a power of 2
+func newCDCChunker(in io.Reader, avgSize int) *cdcChunker {
+	avgBits := bits.Len(uint(avgSize)) - 1
+	avgSize = 1 << avgBits
+	mask := func(ones int) uint64 {
+		// Use the top bits as these depend on the most bytes
+		return (uint64(1)<<ones - 1) << (64 - ones)
+	}
+	return &cdcChunker{
+		in:       in,
+		buf:      make([]byte, avgSize*cdcMaxFactor),
+		minSize:  avgSize / cdcMinFactor,
+		avgSize:  avgSize,
+		maxSize:  avgSize * cdcMaxFactor,
+		maskHard: mask(avgBits + 1),
+		maskEasy: mask(avgBits - 1),
+	}
+}
+
+// cut returns the length of the first chunk in data
+func (c *cdcChunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	normal := min(c.avgSize, n)
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&c.maskHard == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&c.maskEasy == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// next returns the next chunk which is valid until the following
+// call, or io.EOF if there are no more chunks
+func (c *cdcChunker) next() ([]byte, error) {
+	c.n = copy(c.buf, c.buf[c.off:c.n])
+	c.off = 0
+	if !c.eof && c.n < c.maxSize {
+		n, err := io.ReadFull(c.in, c.buf[c.n:])
+		c.n += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	c.off = c.cut(c.buf[:c.n])
+	return c.buf[:c.off], nil
+}
+
+// last returns true if the chunk returned by next was the last one
+func (c *cdcChunker) last() bool {
+	return c.eof && c.off == c.n
+}
+
+// cdcRef refers to a data chunk in the store
+type cdcRef struct {
+	Hash string `json:"h"`
+	Size int64  `json:"s"`
+}
+
+// cdcList is the contents of the chunk list control chunk
+type cdcList struct {
+	Version int      `json:"ver"`
+	Hash    string   `json:"hash"`
+	Chunks  []cdcRef `json:"chunks"`
+}
+
+// cdcChunkPath returns the path of the chunk in the store
+func cdcChunkPath(sum string) string {
+	return sum[:2] + "/" + sum
+}
+
+// setChunking sets up the chunking mode
+func (f *Fs) setChunking(chunking string) error {
+	switch chunking {
+	case "", "fixed":
+		f.useCDC = false
+	case "cdc":
+		if !f.useMeta {
+			return errors.New("cdc chunking requires metadata")
+		}
+		if f.useNoRename {
+			return errors.New("cdc chunking requires rename transactions")
+		}
+		if f.opt.CDCChunkSize < minCDCChunk || f.opt.CDCChunkSize > math.MaxInt32/cdcMaxFactor {
+			return fmt.Errorf("cdc chunk size %v out of range", f.opt.CDCChunkSize)
+		}
+		f.useCDC = true
+	default:
+		return fmt.Errorf("unsupported chunking mode '%s'", chunking)
+	}
+	return nil
+}
+
+// cdcStore returns the chunk store, creating it on first use
+func (f *Fs) cdcStore(ctx context.Context) (fs.Fs, error) {
+	f.storeMu.Lock()
+	defer f.storeMu.Unlock()
+	if f.store != nil {
+		return f.store, nil
+	}
+	store, err := cache.Get(ctx, f.storeRemote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make chunk store %q: %w", f.storeRemote, err)
+	}
+	f.store = store
+	return store, nil
+}
+
+// isCDCStore returns true if dir relative to the root is the chunk store
+func (f *Fs) isCDCStore(dir string) bool {
+	return path.Join(f.root, dir) == cdcStoreDir
+}
+
+// putCDC uploads in to remote using content-defined chunking
+func (f *Fs) putCDC(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string) (obj fs.Object, err error) {
+	store, err := f.cdcStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole stream through the chunking reader for the
+	// hashing and accounting
+	c := f.newChunkingReader(src)
+	c.chunkSize = math.MaxInt64
+	c.chunkLimit = c.chunkSize
+	c.expectSingle = false
+	cdc := newCDCChunker(c.wrapStream(ctx, in, src), int(f.opt.CDCChunkSize))
+
+	data, err := cdc.next()
+	if err == io.EOF {
+		data, err = nil, nil // an empty file is a single empty chunk
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cdc.last() && !f.hashAll {
+		_, looksLikeMeta, _ := unmarshalSimpleJSON(ctx, nil, data)
+		if !looksLikeMeta {
+			return f.putSingleCDC(ctx, data, src, remote)
+		}
+	}
+
+	// The chunk list is written under a temporary name while the
+	// chunks are uploaded
+	xactID, err := f.newXactID(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	pending := f.newCDCPending(ctx, f.makeChunkName(remote, -1, cdcCtrlType, xactID))
+	var metaObject, ctrlObject fs.Object
+	defer func() {
+		if err != nil {
+			if ctrl := pending.finish(); ctrlObject == nil {
+				ctrlObject = ctrl
+			}
+			for _, o := range []fs.Object{ctrlObject, metaObject} {
+				if o != nil {
+					silentlyRemove(ctx, o)
+				}
+			}
+		}
+	}()
+
+	// Upload the chunks not in the store already
+	var sizeTotal int64
+	for err == nil {
+		if pending.len() > maxSafeChunkNumber {
+			return nil, ErrChunkOverflow
+		}
+		ref, errChunk := f.putCDCChunk(ctx, store, data)
+		if errChunk != nil {
+			return nil, errChunk
+		}
+		pending.add(ref)
+		sizeTotal += ref.Size
+		data, err = cdc.next()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.sizeTotal != -1 && sizeTotal != c.sizeTotal {
+		return nil, fmt.Errorf("incorrect upload size %d != %d", sizeTotal, c.sizeTotal)
+	}
+
+	// Write the whole chunk list under the temporary name
+	ctrlObject = pending.finish()
+	ctrlObject, err = f.writeCDCList(ctx, ctrlObject, pending.remote, pending.list.Chunks)
+	if err != nil {
+		return nil, err
+	}
+	list := pending.list
+
+	// If previous object was chunked, remove its chunks
+	f.removeOldChunks(ctx, remote)
+
+	ctrlObject, err = f.baseMove(ctx, ctrlObject, f.makeChunkName(remote, -1, cdcCtrlType, ""), delFailed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update meta object
+	c.updateHashes()
+	metadata, err := marshalSimpleJSON(ctx, sizeTotal, len(list.Chunks), c.md5, c.sha1, "", cdcHashType)
+	if err != nil {
+		return nil, err
+	}
+	metaObject, err = f.base.Put(ctx, bytes.NewReader(metadata), f.wrapInfo(src, remote, int64(len(metadata))))
+	if err != nil {
+		return nil, err
+	}
+
+	o := f.newObject("", metaObject, nil)
+	o.ctrl = ctrlObject
+	o.cdc = list.Chunks
+	o.size = sizeTotal
+	o.md5 = c.md5
+	o.sha1 = c.sha1
+	o.isFull = true
+	return o, nil
+}
+
+// cdcPending writes the chunk list of an upload in progress under its
+// temporary name regularly so CleanUp counts the chunks uploaded so
+// far as references
+type cdcPending struct {
+	f      *Fs
+	remote string        // temporary name of the chunk list
+	stop   chan struct{} // closed to stop writing
+	done   chan struct{} // closed when writing has stopped
+	mu     sync.Mutex
+	list   cdcList
+	ctrl   fs.Object // chunk list written so far or nil
+}
+
+// newCDCPending starts writing the chunk list of an upload to remote
+// every quarter of the grace period
+func (f *Fs) newCDCPending(ctx context.Context, remote string) *cdcPending {
+	p := &cdcPending{
+		f:      f,
+		remote: remote,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		list:   cdcList{Version: cdcListVer, Hash: cdcHashType},
+	}
+	interval := time.Duration(f.opt.CDCGracePeriod) / 4
+	if interval <= 0 {
+		close(p.done)
+		return p
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.write(ctx)
+			}
+		}
+	}()
+	return p
+}
+
+// add adds ref to the chunk list
+func (p *cdcPending) add(ref cdcRef) {
+	p.mu.Lock()
+	p.list.Chunks = append(p.list.Chunks, ref)
+	p.mu.Unlock()
+}
+
+// len returns the number of chunks in the list
+func (p *cdcPending) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.list.Chunks)
+}
+
+// write writes the chunk list so far
+func (p *cdcPending) write(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctrl, err := p.f.writeCDCList(ctx, p.ctrl, p.remote, p.list.Chunks)
+	if err != nil {
+		fs.Errorf(p.remote, "Failed to write chunk list of upload in progress: %v", err)
+		return
+	}
+	p.ctrl = ctrl
+}
+
+// finish stops writing the chunk list returning the one written if any
+func (p *cdcPending) finish() fs.Object {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ctrl
+}
+
+// writeCDCList writes the chunk list of chunks to remote, updating ctrl
+// if it was written already. The modification time is the time it was
+// written for cleanUpCDC.
+func (f *Fs) writeCDCList(ctx context.Context, ctrl fs.Object, remote string, chunks []cdcRef) (fs.Object, error) {
+	listData, err := json.Marshal(&cdcList{Version: cdcListVer, Hash: cdcHashType, Chunks: chunks})
+	if err != nil {
+		return nil, err
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(listData)), true, nil, f.base)
+	if ctrl != nil {
+		return ctrl, ctrl.Update(ctx, bytes.NewReader(listData), info)
+	}
+	return f.base.Put(ctx, bytes.NewReader(listData), info)
+}
+
+// putSingleCDC stores a file consisting of a single chunk as a
+// non-chunked file
+func (f *Fs) putSingleCDC(ctx context.Context, data []byte, src fs.ObjectInfo, remote string) (fs.Object, error) {
+	// If previous object was chunked, remove its chunks
+	f.removeOldChunks(ctx, remote)
+	o, err := f.base.Put(ctx, bytes.NewReader(data), f.wrapInfo(src, remote, int64(len(data))))
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject("", o, nil), nil
+}
+
+// putCDCChunk uploads data to the store unless it is there already
+func (f *Fs) putCDCChunk(ctx context.Context, store fs.Fs, data []byte) (ref cdcRef, err error) {
+	sum := sha256.Sum256(data)
+	ref = cdcRef{
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(data)),
+	}
+	chunkRemote := cdcChunkPath(ref.Hash)
+	if existing, err := store.NewObject(ctx, chunkRemote); err == nil && existing.Size() == ref.Size {
+		if time.Since(existing.ModTime(ctx)) < time.Duration(f.opt.CDCGracePeriod)/2 {
+			fs.Debugf(existing, "Chunk already in store")
+			return ref, nil
+		}
+		// The chunk may be unreferenced so stamp it to stop a
+		// concurrent cleanUpCDC removing it before our chunk list
+		// is written
+		err = existing.SetModTime(ctx, time.Now())
+		if err == nil {
+			fs.Debugf(existing, "Chunk already in store - reusing it")
+			return ref, nil
+		}
+		fs.Debugf(existing, "Uploading chunk again as failed to stamp it: %v", err)
+	}
+	// The modification time is the upload time for cleanUpCDC
+	info := object.NewStaticObjectInfo(chunkRemote, time.Now(), ref.Size, true, nil, store)
+	if _, err = store.Put(ctx, bytes.NewReader(data), info); err != nil {
+		return ref, fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	return ref, nil
+}
+
+// readCDCList reads the chunk list from the control chunk
+func readCDCList(ctx context.Context, ctrl fs.Object) (list *cdcList, err error) {
+	in, err := ctrl.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(in, &err)
+	list = new(cdcList)
+	if err = json.NewDecoder(in).Decode(list); err != nil {
+		return nil, fmt.Errorf("invalid chunk list: %w", err)
+	}
+	if list.Version > cdcListVer || list.Hash != cdcHashType {
+		return nil, ErrMetaUnknown
+	}
+	for _, ref := range list.Chunks {
+		if len(ref.Hash) != 2*sha256.Size || ref.Size < 0 {
+			return nil, errors.New("invalid chunk list entry")
+		}
+	}
+	return list, nil
+}
+
+// loadCDC reads the chunk list of the object if it isn't cached
+func (o *Object) loadCDC(ctx context.Context) error {
+	if o.cdc != nil {
+		return nil
+	}
+	list, err := readCDCList(ctx, o.ctrl)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, ref := range list.Chunks {
+		size += ref.Size
+	}
+	if size != o.size {
+		return errors.New("chunk list doesn't match file size")
+	}
+	o.cdc = list.Chunks
+	return nil
+}
+
+// storeChunk is a data chunk in the store which can be opened
+type storeChunk struct {
+	f   *Fs
+	ref cdcRef
+}
+
+// Size returns the size of the chunk
+func (sc storeChunk) Size() int64 {
+	return sc.ref.Size
+}
+
+// Open the chunk in the store
+func (sc storeChunk) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	store, err := sc.f.cdcStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o, err := store.NewObject(ctx, cdcChunkPath(sc.ref.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("missing chunk %s: %w", sc.ref.Hash, err)
+	}
+	return o.Open(ctx, options...)
+}
+
+// openCDC opens the content-defined chunks for read
+func (o *Object) openCDC(ctx context.Context, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
+	if err := o.loadCDC(ctx); err != nil {
+		return nil, fmt.Errorf("can't open: %w", err)
+	}
+	chunks := make([]chunkOpener, len(o.cdc))
+	for i, ref := range o.cdc {
+		chunks[i] = storeChunk{f: o.f, ref: ref}
+	}
+	return newLinearReader(ctx, chunks, offset, limit, options)
+}
+
+// copyOrMoveCDC copies or moves the chunk list and meta object only
+// as the data chunks are shared
+func (f *Fs) copyOrMoveCDC(ctx context.Context, o *Object, remote string, do copyMoveFn) (fs.Object, error) {
+	ctrlObject, err := do(ctx, o.ctrl, f.makeChunkName(remote, -1, cdcCtrlType, ""))
+	if err != nil {
+		return nil, err
+	}
+	metaObject, err := do(ctx, o.main, remote)
+	if err != nil {
+		silentlyRemove(ctx, ctrlObject)
+		return nil, err
+	}
+	newObj := f.newObject(remote, metaObject, nil)
+	newObj.ctrl = ctrlObject
+	newObj.cdc = o.cdc
+	newObj.size = o.size
+	newObj.md5 = o.md5
+	newObj.sha1 = o.sha1
+	newObj.isFull = true
+	return newObj, nil
+}
+
+// cleanUpCDC removes the chunks in the store which aren't referenced
+// by any chunk list on the wrapped remote
+func (f *Fs) cleanUpCDC(ctx context.Context) error {
+	store, err := f.cdcStore(ctx)
+	if err != nil {
+		return err
+	}
+	root, err := cache.Get(ctx, f.rootRemote)
+	if err != nil {
+		return fmt.Errorf("failed to make root of chunk store: %w", err)
+	}
+	// Filters must not hide any references
+	fi, _ := filter.NewFilter(&filter.Options{
+		MinAge:  fs.DurationOff,
+		MaxAge:  fs.DurationOff,
+		MinSize: fs.SizeSuffix(-1),
+		MaxSize: fs.SizeSuffix(-1),
+	})
+	ctx = filter.ReplaceConfig(ctx, fi)
+
+	// Chunks stamped after this may be referenced by chunk lists
+	// written after they were counted
+	cutoff := time.Now().Add(-time.Duration(f.opt.CDCGracePeriod))
+
+	// Count the references including those of uploads in progress
+	refs := make(map[string]int)
+	err = walk.ListR(ctx, root, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok || strings.HasPrefix(o.Remote(), cdcStoreDir+"/") {
+				continue
+			}
+			_, _, ctrlType, xactID := f.parseChunkName(o.Remote())
+			if ctrlType != cdcCtrlType {
+				continue
+			}
+			if xactID != "" && o.ModTime(ctx).Before(cutoff) {
+				fs.Infof(o, "Removing chunk list of upload which stopped")
+				if err := operations.DeleteFile(ctx, o); err != nil {
+					return err
+				}
+				continue
+			}
+			list, err := readCDCList(ctx, o)
+			if err != nil {
+				return fmt.Errorf("failed to read chunk list %q: %w", o.Remote(), err)
+			}
+			for _, ref := range list.Chunks {
+				refs[ref.Hash]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("not cleaning chunk store: %w", err)
+	}
+
+	// Remove the chunks nobody references
+	removed := 0
+	err = walk.ListR(ctx, store, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok || refs[path.Base(o.Remote())] > 0 || o.ModTime(ctx).After(cutoff) {
+				continue
+			}
+			// Check again as the chunk may have been reused since
+			// it was listed
+			o, err := store.NewObject(ctx, o.Remote())
+			if errors.Is(err, fs.ErrorObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if o.ModTime(ctx).After(cutoff) {
+				continue
+			}
+			if err := operations.DeleteFile(ctx, o); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		err = nil
+	}
+	fs.Infof(f, "Removed %d unreferenced chunks from the chunk store", removed)
+	return err
+}
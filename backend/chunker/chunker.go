@@ -108,7 +108,9 @@ const (
 )
 
 // Current/highest supported metadata format.
-const metadataVersion = 2
+//
+// Version 2 adds transaction IDs and version 3 content-defined chunks.
+const metadataVersion = 3
 
 // optimizeFirstChunk enables the following optimization in the Put:
 // If a single chunk is expected, put the first chunk using the
@@ -267,6 +269,48 @@ If meta format is set to "none", rename transactions will always be used.
 This method is EXPERIMENTAL, don't use on production systems.`,
 				},
 			},
+		}, {
+			Name:     "chunking",
+			Advanced: true,
+			Default:  "fixed",
+			Help:     `Choose how chunker splits files into chunks.`,
+			Examples: []fs.OptionExample{
+				{
+					Value: "fixed",
+					Help:  "Split files larger than chunk size into chunks of chunk size.",
+				}, {
+					Value: "cdc",
+					Help: `Split files at boundaries found from their content and store each chunk once.
+Chunks are named by their SHA-256 and shared by all files on the wrapped remote,
+so only chunks which have changed are uploaded.
+Requires metadata and rename transactions.`,
+				},
+			},
+		}, {
+			Name:     "cdc_chunk_size",
+			Advanced: true,
+			Default:  fs.SizeSuffix(4 * 1024 * 1024),
+			Help: `Average chunk size for cdc chunking.
+
+This is rounded down to a power of 2. Chunks will be between a quarter
+and four times this size. Each transfer buffers a chunk of the maximum
+size in memory.`,
+		}, {
+			Name:     "cdc_grace_period",
+			Advanced: true,
+			Default:  fs.Duration(time.Hour),
+			Help: `How long cleanup keeps unreferenced chunks for cdc chunking.
+
+Cleanup keeps the chunks in the chunk store uploaded or reused within
+this time, as the upload using them may not have written its chunk
+list yet. Uploads in progress write the list of the chunks uploaded so
+far every quarter of this time, and cleanup ignores and removes these
+lists once they haven't been written for this long as the upload must
+have stopped.
+
+Uploading a single chunk must take less than a quarter of this time,
+or a cleanup running at the same time may remove chunks the upload
+uses.`,
 		}},
 	})
 }
@@ -303,10 +347,12 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	}
 
 	f := &Fs{
-		base: baseFs,
-		name: name,
-		root: rpath,
-		opt:  *opt,
+		base:        baseFs,
+		name:        name,
+		root:        rpath,
+		opt:         *opt,
+		rootRemote:  baseName + fspath.JoinRootPath(basePath, ""),
+		storeRemote: baseName + fspath.JoinRootPath(basePath, cdcStoreDir),
 	}
 	f.dirSort = true // processEntries requires that meta Objects prerun data chunks atm.
 
@@ -358,20 +404,26 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 
 	f.features.ListR = nil // Recursive listing may cause chunker skip files
 	f.features.ListP = nil // ListP not supported yet
+	if f.useCDC {
+		f.features.CleanUp = f.CleanUp // cleans the chunk store
+	}
 
 	return f, err
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote       string        `config:"remote"`
-	ChunkSize    fs.SizeSuffix `config:"chunk_size"`
-	NameFormat   string        `config:"name_format"`
-	StartFrom    int           `config:"start_from"`
-	MetaFormat   string        `config:"meta_format"`
-	HashType     string        `config:"hash_type"`
-	FailHard     bool          `config:"fail_hard"`
-	Transactions string        `config:"transactions"`
+	Remote         string        `config:"remote"`
+	ChunkSize      fs.SizeSuffix `config:"chunk_size"`
+	NameFormat     string        `config:"name_format"`
+	StartFrom      int           `config:"start_from"`
+	MetaFormat     string        `config:"meta_format"`
+	HashType       string        `config:"hash_type"`
+	FailHard       bool          `config:"fail_hard"`
+	Transactions   string        `config:"transactions"`
+	Chunking       string        `config:"chunking"`
+	CDCChunkSize   fs.SizeSuffix `config:"cdc_chunk_size"`
+	CDCGracePeriod fs.Duration   `config:"cdc_grace_period"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -394,6 +446,11 @@ type Fs struct {
 	features     *fs.Features   // optional features
 	dirSort      bool           // reserved for future, ignored
 	useNoRename  bool           // can be set with the transactions option
+	useCDC       bool           // set if chunking is "cdc"
+	rootRemote   string         // root of the wrapped remote
+	storeRemote  string         // chunk store for cdc chunking
+	storeMu      sync.Mutex     // protects store
+	store        fs.Fs          // chunk store if in use
 }
 
 // configure sets up chunker for given name format, meta format and hash type.
@@ -412,6 +469,9 @@ func (f *Fs) configure(nameFormat, metaFormat, hashType, transactionMode string)
 	if err := f.setTransactionMode(transactionMode); err != nil {
 		return err
 	}
+	if err := f.setChunking(f.opt.Chunking); err != nil {
+		return err
+	}
 
 	randomSeed := time.Now().UnixNano()
 	f.xactIDRand = rand.New(rand.NewSource(randomSeed))
@@ -811,6 +871,12 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				}
 			}
 			if isSpecial {
+				if ctrlType == cdcCtrlType && xactID == "" && mainObject != nil && f.useMeta {
+					// chunk list of content-defined chunks
+					mainObject.ctrl = entry
+					mainObject.unsure = true
+					break
+				}
 				if revealHidden {
 					fs.Infof(f, "ignore non-data chunk %q", remote)
 				}
@@ -830,6 +896,9 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				badEntry[mainRemote] = true
 			}
 		case fs.Directory:
+			if f.isCDCStore(entry.Remote()) {
+				break // hide the chunk store
+			}
 			isSubdir[entry.Remote()] = true
 			wrapDir := fs.NewDirWrapper(entry.Remote(), entry)
 			tempEntries = append(tempEntries, wrapDir)
@@ -861,6 +930,16 @@ func (f *Fs) processEntries(ctx context.Context, origEntries fs.DirEntries, dirP
 				fs.Debugf(f, "invalid chunks in object %q", remote)
 				continue
 			}
+			if object.ctrl != nil {
+				// the size of content-defined chunks is in metadata
+				if err := object.readMetadata(ctx); err != nil {
+					if f.opt.FailHard {
+						return nil, err
+					}
+					fs.Debugf(f, "invalid metadata in object %q: %v", remote, err)
+					continue
+				}
+			}
 		}
 		newEntries = append(newEntries, entry)
 	}
@@ -975,6 +1054,12 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 		if !sameMain {
 			continue // skip alien chunks
 		}
+		if ctrlType == cdcCtrlType && xactID == "" && f.useMeta {
+			// chunk list of content-defined chunks
+			o.ctrl = entry
+			o.unsure = true
+			continue
+		}
 		if ctrlType != "" || xactID != currentXactID {
 			if f.useMeta {
 				// temporary/control chunk calls for lazy metadata read
@@ -1017,6 +1102,12 @@ func (f *Fs) scanObject(ctx context.Context, remote string, quickScan bool) (fs.
 		if err := o.validate(); err != nil {
 			return nil, err
 		}
+		if o.ctrl != nil {
+			// the size of content-defined chunks is in metadata
+			if err := o.readMetadata(ctx); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return o, nil
 }
@@ -1048,6 +1139,7 @@ func (o *Object) readMetadata(ctx context.Context) error {
 			// this is not metadata but a foreign object
 			o.unsure = false
 			o.chunks = nil  // make isComposite return false
+			o.ctrl = nil    // along with this
 			o.isFull = true // cache results
 			return nil
 		}
@@ -1073,6 +1165,7 @@ func (o *Object) readMetadata(ctx context.Context) error {
 			if !madeByChunker {
 				// this is not metadata but a foreign object
 				o.chunks = nil  // make isComposite return false
+				o.ctrl = nil    // along with this
 				o.isFull = true // cache results
 				return nil
 			}
@@ -1085,8 +1178,17 @@ func (o *Object) readMetadata(ctx context.Context) error {
 		default:
 			return fmt.Errorf("invalid metadata: %w", err)
 		}
-		if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
-			return errors.New("metadata doesn't match file size")
+		if metaInfo.cdc != "" {
+			if o.ctrl == nil {
+				return errors.New("missing chunk list")
+			}
+			o.chunks = nil // ignore stray data chunks
+			o.size = metaInfo.Size()
+		} else {
+			o.ctrl = nil // ignore stray chunk list
+			if o.size != metaInfo.Size() || len(o.chunks) != metaInfo.nChunks {
+				return errors.New("metadata doesn't match file size")
+			}
 		}
 		o.md5 = metaInfo.md5
 		o.sha1 = metaInfo.sha1
@@ -1165,6 +1267,9 @@ func (f *Fs) put(
 			return nil, fmt.Errorf("refusing to %s: %w", action, err)
 		}
 	}
+	if f.useCDC {
+		return f.putCDC(ctx, in, src, remote)
+	}
 
 	// Prepare to upload
 	c := f.newChunkingReader(src)
@@ -1320,7 +1425,7 @@ func (f *Fs) put(
 	switch f.opt.MetaFormat {
 	case "simplejson":
 		c.updateHashes()
-		metadata, err = marshalSimpleJSON(ctx, sizeTotal, len(c.chunks), c.md5, c.sha1, xactID)
+		metadata, err = marshalSimpleJSON(ctx, sizeTotal, len(c.chunks), c.md5, c.sha1, xactID, "")
 	}
 	if err == nil {
 		metaInfo := f.wrapInfo(src, baseRemote, int64(len(metadata)))
@@ -1505,6 +1610,11 @@ func (f *Fs) removeOldChunks(ctx context.Context, remote string) {
 				fs.Errorf(chunk, "Failed to remove old chunk: %v", err)
 			}
 		}
+		if oldObject.ctrl != nil {
+			if err := oldObject.ctrl.Remove(ctx); err != nil {
+				fs.Errorf(oldObject.ctrl, "Failed to remove old chunk list: %v", err)
+			}
+		}
 	}
 }
 
@@ -1670,6 +1780,14 @@ func (o *Object) Remove(ctx context.Context) (err error) {
 		}
 	}
 
+	// Content-defined chunks may be shared so are left for CleanUp.
+	if o.ctrl != nil {
+		ctrlErr := o.ctrl.Remove(ctx)
+		if err == nil {
+			err = ctrlErr
+		}
+	}
+
 	// There are no known control chunks to remove atm.
 	return err
 }
@@ -1692,6 +1810,10 @@ func (f *Fs) copyOrMove(ctx context.Context, o *Object, remote string, do copyMo
 		}
 		return f.newObject("", oResult, nil), nil
 	}
+	if o.ctrl != nil {
+		fs.Debugf(o, "%s chunk list...", opName)
+		return f.copyOrMoveCDC(ctx, o, remote, do)
+	}
 
 	fs.Debugf(o, "%s %d data chunks...", opName, len(o.chunks))
 	mainRemote := o.remote
@@ -1739,7 +1861,7 @@ func (f *Fs) copyOrMove(ctx context.Context, o *Object, remote string, do copyMo
 	var metadata []byte
 	switch f.opt.MetaFormat {
 	case "simplejson":
-		metadata, err = marshalSimpleJSON(ctx, newObj.size, len(newChunks), md5, sha1, o.xactID)
+		metadata, err = marshalSimpleJSON(ctx, newObj.size, len(newChunks), md5, sha1, o.xactID, "")
 		if err == nil {
 			metaInfo := f.wrapInfo(metaObject, "", int64(len(metadata)))
 			err = newObj.main.Update(ctx, bytes.NewReader(metadata), metaInfo)
@@ -1777,6 +1899,8 @@ func (f *Fs) okForServerSide(ctx context.Context, src fs.Object, opName string)
 		diff = "chunk numbering"
 	case f.opt.MetaFormat != obj.f.opt.MetaFormat:
 		diff = "meta formats"
+	case f.storeRemote != obj.f.storeRemote:
+		diff = "chunk stores"
 	}
 	if diff != "" {
 		fs.Debugf(src, "Can't %s - different %s", opName, diff)
@@ -1918,9 +2042,20 @@ func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) e
 //
 // Implement this if you have a way of emptying the trash or
 // otherwise cleaning up old versions of files.
+//
+// With cdc chunking this also removes the chunks no longer used by
+// any file from the chunk store.
 func (f *Fs) CleanUp(ctx context.Context) error {
+	if f.useCDC {
+		if err := f.cleanUpCDC(ctx); err != nil {
+			return err
+		}
+	}
 	do := f.base.Features().CleanUp
 	if do == nil {
+		if f.useCDC {
+			return nil
+		}
 		return errors.New("not supported by underlying remote")
 	}
 	return do(ctx)
@@ -2000,6 +2135,8 @@ type Object struct {
 	xIDCached bool        // true if xactID has been read
 	unsure    bool        // true if need to read metadata to detect object type
 	xactID    string      // transaction ID for "norename" or empty string for "renamed" chunks
+	ctrl      fs.Object   // chunk list if the file has content-defined chunks
+	cdc       []cdcRef    // cached contents of the chunk list
 	md5       string
 	sha1      string
 	f         *Fs
@@ -2035,6 +2172,10 @@ func (o *Object) validate() error {
 		return nil
 	}
 
+	if o.ctrl != nil && o.chunks == nil {
+		return nil // the size of content-defined chunks is in metadata
+	}
+
 	metaObject := o.main // this file is composite - o.main refers to meta object (or nil if meta format is 'none')
 	if metaObject != nil && metaObject.Size() > maxMetadataSize {
 		// metadata of a chunked file must be a tiny piece of json
@@ -2087,7 +2228,7 @@ func (o *Object) mainChunk() fs.Object {
 }
 
 func (o *Object) isComposite() bool {
-	return o.chunks != nil
+	return o.chunks != nil || o.ctrl != nil
 }
 
 // Fs returns read only access to the Fs that this object is part of
@@ -2216,13 +2357,26 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		limit = o.size - offset
 	}
 
-	return o.newLinearReader(ctx, offset, limit, openOptions)
+	if o.ctrl != nil {
+		return o.openCDC(ctx, offset, limit, openOptions)
+	}
+	chunks := make([]chunkOpener, len(o.chunks))
+	for i, chunk := range o.chunks {
+		chunks[i] = chunk
+	}
+	return newLinearReader(ctx, chunks, offset, limit, openOptions)
+}
+
+// chunkOpener is the part of a data chunk the linearReader needs
+type chunkOpener interface {
+	Size() int64
+	Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error)
 }
 
 // linearReader opens and reads file chunks sequentially, without read-ahead
 type linearReader struct {
 	ctx     context.Context
-	chunks  []fs.Object
+	chunks  []chunkOpener
 	options []fs.OpenOption
 	limit   int64
 	count   int64
@@ -2231,10 +2385,10 @@ type linearReader struct {
 	err     error
 }
 
-func (o *Object) newLinearReader(ctx context.Context, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
+func newLinearReader(ctx context.Context, chunks []chunkOpener, offset, limit int64, options []fs.OpenOption) (io.ReadCloser, error) {
 	r := &linearReader{
 		ctx:     ctx,
-		chunks:  o.chunks,
+		chunks:  chunks,
 		options: options,
 		limit:   limit,
 	}
@@ -2334,6 +2488,7 @@ type ObjectInfo struct {
 	remote  string // overrides remote name
 	md5     string // overrides MD5 checksum
 	sha1    string // overrides SHA1 checksum
+	cdc     string // hash naming content-defined chunks or empty string for numbered chunks
 }
 
 func (f *Fs) wrapInfo(src fs.ObjectInfo, newRemote string, totalSize int64) *ObjectInfo {
@@ -2431,6 +2586,7 @@ type metaSimpleJSON struct {
 	MD5    string `json:"md5,omitempty"`
 	SHA1   string `json:"sha1,omitempty"`
 	XactID string `json:"txn,omitempty"` // transaction ID for norename transactions
+	CDC    string `json:"cdc,omitempty"` // hash naming content-defined chunks
 }
 
 // marshalSimpleJSON
@@ -2439,10 +2595,15 @@ type metaSimpleJSON struct {
 // - for files larger than chunk size
 // - if file contents can be mistaken as meta object
 // - if consistent hashing is On but wrapped remote can't provide given hash
-func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1, xactID string) ([]byte, error) {
-	version := metadataVersion
-	if xactID == "" && version == 2 {
-		version = 1
+//
+// It writes the lowest version which can describe the file.
+func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1, xactID, cdc string) ([]byte, error) {
+	version := 1
+	switch {
+	case cdc != "":
+		version = 3
+	case xactID != "":
+		version = 2
 	}
 	metadata := metaSimpleJSON{
 		// required core fields
@@ -2453,6 +2614,7 @@ func marshalSimpleJSON(ctx context.Context, size int64, nChunks int, md5, sha1,
 		MD5:    md5,
 		SHA1:   sha1,
 		XactID: xactID,
+		CDC:    cdc,
 	}
 	data, err := json.Marshal(&metadata)
 	if err == nil && data != nil && len(data) >= maxMetadataSizeWritten {
@@ -2530,6 +2692,7 @@ func unmarshalSimpleJSON(ctx context.Context, metaObject fs.Object, data []byte)
 	info.md5 = metadata.MD5
 	info.sha1 = metadata.SHA1
 	info.xactID = metadata.XactID
+	info.cdc = metadata.CDC
 	return info, true, nil
 }
 
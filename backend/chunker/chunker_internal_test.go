@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configmap"
@@ -17,6 +18,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
 	"github.com/rclone/rclone/lib/random"
@@ -659,7 +661,7 @@ func testMetadataInput(t *testing.T, f *Fs) {
 		o, ok := obj.(*Object)
 		assert.NotNil(t, ok)
 		if o != nil {
-			assert.True(t, o.isComposite() && len(o.chunks) == 1, description+" is forced composite")
+			assert.True(t, o.isComposite() && (len(o.chunks) == 1 || o.ctrl != nil), description+" is forced composite")
 			o = nil
 		}
 
@@ -679,7 +681,7 @@ func testMetadataInput(t *testing.T, f *Fs) {
 		}
 	}
 
-	metaData, err := marshalSimpleJSON(ctx, 3, 1, "", "", "")
+	metaData, err := marshalSimpleJSON(ctx, 3, 1, "", "", "", "")
 	require.NoError(t, err)
 	todaysMeta := string(metaData)
 	runSubtest(todaysMeta, "today")
@@ -870,6 +872,7 @@ func testMD5AllSlow(t *testing.T, f *Fs) {
 		"hash_type":    "md5all",
 		"transactions": "rename",
 		"meta_format":  "simplejson",
+		"chunking":     "fixed",
 	})
 	chunkFs, ok := fsResult.(*Fs)
 	require.True(t, ok, "fs must be a chunker remote")
@@ -899,6 +902,194 @@ func testMD5AllSlow(t *testing.T, f *Fs) {
 	require.NoError(t, operations.Purge(ctx, baseFs, ""))
 }
 
+// Test that cdc chunking uploads only changed chunks and cleans up
+// the chunks no longer referenced
+func testCDC(t *testing.T, f *Fs) {
+	if !f.useMeta {
+		t.Skip("cdc chunking requires metadata")
+	}
+	ctx := context.Background()
+	fsResult := deriveFs(ctx, t, f, "cdc", settings{
+		"chunking":       "cdc",
+		"cdc_chunk_size": "1k",
+		"transactions":   "rename",
+	})
+	cdcFs, ok := fsResult.(*Fs)
+	require.True(t, ok, "fs must be a chunker remote")
+	require.True(t, cdcFs.useCDC)
+	store, err := cdcFs.cdcStore(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = operations.Purge(ctx, cdcFs.base, "")
+	}()
+
+	countChunks := func() int {
+		objs, _, err := walk.GetAll(ctx, store, "", true, -1)
+		if err == fs.ErrorDirNotFound {
+			return 0
+		}
+		require.NoError(t, err)
+		return len(objs)
+	}
+	checkContents := func(obj fs.Object, want string) {
+		r, err := obj.Open(ctx)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, want, string(data))
+	}
+
+	// Collect the chunks left unreferenced by other tests
+	cdcFs.opt.CDCGracePeriod = 0
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	before := countChunks()
+	contents := random.String(64 * 1024)
+	objA := testPutFile(ctx, t, cdcFs, "a", contents, "upload a", true)
+	chunksA := countChunks() - before
+	assert.Greater(t, chunksA, 8)
+
+	// Inserting a byte only uploads the chunks around it
+	edited := contents[:10000] + "X" + contents[10000:]
+	objB := testPutFile(ctx, t, cdcFs, "b", edited, "upload b", true)
+	assert.LessOrEqual(t, countChunks()-before-chunksA, 3)
+	checkContents(objB, edited)
+
+	// Server-side copy only copies the chunk list
+	objC, err := operations.Copy(ctx, cdcFs, nil, "c", objA)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), objC.Size())
+	checkContents(objC, contents)
+	objC, err = cdcFs.NewObject(ctx, "c")
+	require.NoError(t, err)
+	checkContents(objC, contents)
+
+	// The chunk store is hidden
+	if cdcFs.root == "" {
+		entries, err := cdcFs.List(ctx, "")
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, cdcStoreDir, entry.Remote())
+		}
+	}
+
+	// Chunks are removed once no file references them
+	require.NoError(t, objA.Remove(ctx))
+	require.NoError(t, objB.Remove(ctx))
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	assert.Equal(t, before+chunksA, countChunks(), "chunks of c must be kept")
+	checkContents(objC, contents)
+	require.NoError(t, objC.Remove(ctx))
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	assert.Equal(t, before, countChunks())
+}
+
+// Test that cdc chunks reused by an upload are kept by a concurrent
+// cleanup even if they were unreferenced and old
+func testCDCReuse(t *testing.T, f *Fs) {
+	if !f.useMeta {
+		t.Skip("cdc chunking requires metadata")
+	}
+	ctx := context.Background()
+	fsResult := deriveFs(ctx, t, f, "cdcreuse", settings{
+		"chunking":       "cdc",
+		"cdc_chunk_size": "1k",
+		"transactions":   "rename",
+	})
+	cdcFs, ok := fsResult.(*Fs)
+	require.True(t, ok, "fs must be a chunker remote")
+	store, err := cdcFs.cdcStore(ctx)
+	require.NoError(t, err)
+	defer func() {
+		_ = operations.Purge(ctx, cdcFs.base, "")
+	}()
+	cdcFs.opt.CDCGracePeriod = fs.Duration(time.Hour)
+
+	// Upload an unreferenced chunk and make it look old
+	data := []byte(random.String(1000))
+	ref, err := cdcFs.putCDCChunk(ctx, store, data)
+	require.NoError(t, err)
+	age := func() {
+		o, err := store.NewObject(ctx, cdcChunkPath(ref.Hash))
+		require.NoError(t, err)
+		err = o.SetModTime(ctx, time.Now().Add(-2*time.Duration(cdcFs.opt.CDCGracePeriod)))
+		if err == fs.ErrorCantSetModTime || err == fs.ErrorCantSetModTimeWithoutDelete {
+			t.Skip("can't set modification time of chunks")
+		}
+		require.NoError(t, err)
+	}
+	age()
+	exists := func() bool {
+		_, err := store.NewObject(ctx, cdcChunkPath(ref.Hash))
+		return err == nil
+	}
+
+	// An upload reusing the chunk protects it until its chunk list
+	// is written
+	_, err = cdcFs.putCDCChunk(ctx, store, data)
+	require.NoError(t, err)
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	assert.True(t, exists(), "reused chunk must be kept")
+
+	// Once old again nothing protects it
+	age()
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	assert.False(t, exists(), "unreferenced chunk must be removed")
+}
+
+// Test that the chunks of a cdc upload taking longer than the grace
+// period are kept by a concurrent cleanup
+func testCDCSlowUpload(t *testing.T, f *Fs) {
+	if !f.useMeta {
+		t.Skip("cdc chunking requires metadata")
+	}
+	ctx := context.Background()
+	fsResult := deriveFs(ctx, t, f, "cdcslow", settings{
+		"chunking":         "cdc",
+		"cdc_chunk_size":   "1k",
+		"cdc_grace_period": "400ms",
+		"transactions":     "rename",
+	})
+	cdcFs, ok := fsResult.(*Fs)
+	require.True(t, ok, "fs must be a chunker remote")
+	defer func() {
+		_ = operations.Purge(ctx, cdcFs.base, "")
+	}()
+
+	// Upload half the file then stall for longer than the grace period
+	contents := random.String(64 * 1024)
+	pr, pw := io.Pipe()
+	halfWritten := make(chan struct{})
+	resume := make(chan struct{})
+	go func() {
+		_, _ = pw.Write([]byte(contents[:len(contents)/2]))
+		close(halfWritten)
+		<-resume
+		_, _ = pw.Write([]byte(contents[len(contents)/2:]))
+		_ = pw.Close()
+	}()
+	uploaded := make(chan error, 1)
+	go func() {
+		src := object.NewStaticObjectInfo("slow", mtime1, int64(len(contents)), true, nil, nil)
+		_, err := cdcFs.Put(ctx, pr, src)
+		uploaded <- err
+	}()
+	<-halfWritten
+	time.Sleep(1 * time.Second)
+	require.NoError(t, cdcFs.CleanUp(ctx))
+	close(resume)
+	require.NoError(t, <-uploaded)
+
+	obj, err := cdcFs.NewObject(ctx, "slow")
+	require.NoError(t, err)
+	r, err := obj.Open(ctx)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, contents, string(data))
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("PutLarge", func(t *testing.T) {
@@ -934,6 +1125,15 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("MD5AllSlow", func(t *testing.T) {
 		testMD5AllSlow(t, f)
 	})
+	t.Run("CDC", func(t *testing.T) {
+		testCDC(t, f)
+	})
+	t.Run("CDCReuse", func(t *testing.T) {
+		testCDCReuse(t, f)
+	})
+	t.Run("CDCSlowUpload", func(t *testing.T) {
+		testCDCSlowUpload(t, f)
+	})
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
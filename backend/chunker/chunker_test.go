@@ -47,6 +47,7 @@ func TestIntegration(t *testing.T) {
 			"UserInfo",
 			"Disconnect",
 			"ListP",
+			"PutIfNotExists",
 		},
 	}
 	if *fstest.RemoteName == "" {
@@ -61,3 +62,43 @@ func TestIntegration(t *testing.T) {
 	}
 	fstests.Run(t, &opt)
 }
+
+// TestIntegrationCDC runs integration tests with content-defined
+// chunking wrapping a local temporary directory
+func TestIntegrationCDC(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestChunkerCDC"
+	tempDir := filepath.Join(os.TempDir(), "rclone-chunker-test-cdc")
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:               name + ":",
+		NilObject:                (*chunker.Object)(nil),
+		SkipBadWindowsCharacters: !*UseBadChars,
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"Metadata",
+			"SetMetadata",
+		},
+		UnimplementableFsMethods: []string{
+			"PublicLink",
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"UserInfo",
+			"Disconnect",
+			"ListP",
+			"PutIfNotExists",
+		},
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "chunker"},
+			{Name: name, Key: "remote", Value: tempDir},
+			{Name: name, Key: "chunking", Value: "cdc"},
+			{Name: name, Key: "cdc_chunk_size", Value: "64"},
+		},
+		QuickTestOK: true,
+	})
+}
@@ -999,6 +999,26 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	return do(ctx, in, uSrc, options...)
 }
 
+// PutIfNotExists in to the remote path only if no object exists
+// there already, returning fs.ErrorObjectExists if it does.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	srcPath := src.Remote()
+	u, uRemote, err := f.findUpstream(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	do := u.f.Features().PutIfNotExists
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	uSrc := fs.NewOverrideRemote(src, uRemote)
+	o, err := do(ctx, in, uSrc, options...)
+	if err != nil {
+		return nil, err
+	}
+	return u.newObject(o), nil
+}
+
 // MergeDirs merges the contents of all the directories passed
 // in into the first one and rmdirs the other directories.
 func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) error {
@@ -1175,23 +1195,24 @@ func (o *Object) SetTier(tier string) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = (*Fs)(nil)
-	_ fs.Purger          = (*Fs)(nil)
-	_ fs.PutStreamer     = (*Fs)(nil)
-	_ fs.Copier          = (*Fs)(nil)
-	_ fs.Mover           = (*Fs)(nil)
-	_ fs.DirMover        = (*Fs)(nil)
-	_ fs.DirCacheFlusher = (*Fs)(nil)
-	_ fs.ChangeNotifier  = (*Fs)(nil)
-	_ fs.Abouter         = (*Fs)(nil)
-	_ fs.ListRer         = (*Fs)(nil)
-	_ fs.Shutdowner      = (*Fs)(nil)
-	_ fs.PublicLinker    = (*Fs)(nil)
-	_ fs.PutUncheckeder  = (*Fs)(nil)
-	_ fs.MergeDirser     = (*Fs)(nil)
-	_ fs.DirSetModTimer  = (*Fs)(nil)
-	_ fs.MkdirMetadataer = (*Fs)(nil)
-	_ fs.CleanUpper      = (*Fs)(nil)
-	_ fs.OpenWriterAter  = (*Fs)(nil)
-	_ fs.FullObject      = (*Object)(nil)
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.PutStreamer      = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.DirCacheFlusher  = (*Fs)(nil)
+	_ fs.ChangeNotifier   = (*Fs)(nil)
+	_ fs.Abouter          = (*Fs)(nil)
+	_ fs.ListRer          = (*Fs)(nil)
+	_ fs.Shutdowner       = (*Fs)(nil)
+	_ fs.PublicLinker     = (*Fs)(nil)
+	_ fs.PutUncheckeder   = (*Fs)(nil)
+	_ fs.PutIfNotExistser = (*Fs)(nil)
+	_ fs.MergeDirser      = (*Fs)(nil)
+	_ fs.DirSetModTimer   = (*Fs)(nil)
+	_ fs.MkdirMetadataer  = (*Fs)(nil)
+	_ fs.CleanUpper       = (*Fs)(nil)
+	_ fs.OpenWriterAter   = (*Fs)(nil)
+	_ fs.FullObject       = (*Object)(nil)
 )
@@ -19,8 +19,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/buengese/sgzip"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/pierrec/lz4/v4"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
@@ -46,6 +48,9 @@ const (
 	minCompressionRatio = 1.1
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
+	lz4FileExt          = ".lz4"
+	brotliFileExt       = ".br"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -54,6 +59,9 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
+	Lz4          = 4
+	Brotli       = 5
 )
 
 var nameRegexp = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9-_]{11})$`)
@@ -65,6 +73,18 @@ func init() {
 		{ // Default compression mode options {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
+		}, {
+			Value: "zstd",
+			Help:  "Seekable zstd compression with a better ratio and speed than gzip.",
+		}, {
+			Value: "lz4",
+			Help:  "Very fast lz4 compression with a lower ratio.",
+		}, {
+			Value: "brotli",
+			Help:  "Slow brotli compression with a high ratio.",
+		}, {
+			Value: "auto",
+			Help:  "Seekable zstd compression, storing already compressed file types uncompressed.",
 		},
 	}
 
@@ -98,6 +118,30 @@ are doing.
 Level 0 turns off compression.`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
+		}, {
+			Name: "zstd_level",
+			Help: `Zstd compression level (1 to 22).
+
+Used by the zstd and auto modes. Levels are mapped onto the nearest
+of the four speeds the encoder supports: 1 and 2 are fastest, 3 to 5
+are the default, 6 to 9 are better and 10 and above are best.`,
+			Default:  3,
+			Advanced: true,
+		}, {
+			Name: "lz4_level",
+			Help: `LZ4 compression level (0 to 9).
+
+0 uses the fast compressor. Levels 1 to 9 use the high compression
+compressor which compresses better but is much slower.`,
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name: "brotli_level",
+			Help: `Brotli compression level (0 to 11).
+
+Levels above 9 compress better but are very slow.`,
+			Default:  6,
+			Advanced: true,
 		}, {
 			Name: "ram_cache_limit",
 			Help: `Some remotes don't allow the upload of files with unknown size.
@@ -117,6 +161,9 @@ type Options struct {
 	Remote           string        `config:"remote"`
 	CompressionMode  string        `config:"mode"`
 	CompressionLevel int           `config:"level"`
+	ZstdLevel        int           `config:"zstd_level"`
+	Lz4Level         int           `config:"lz4_level"`
+	BrotliLevel      int           `config:"brotli_level"`
 	RAMCacheLimit    fs.SizeSuffix `config:"ram_cache_limit"`
 }
 
@@ -130,6 +177,7 @@ type Fs struct {
 	root     string
 	opt      Options
 	mode     int          // compression mode id
+	auto     bool         // set to store already compressed MIME types uncompressed
 	features *fs.Features // optional features
 }
 
@@ -141,6 +189,10 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if err != nil {
 		return nil, err
 	}
+	err = checkLevel(opt)
+	if err != nil {
+		return nil, err
+	}
 
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
@@ -174,6 +226,7 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		root: rpath,
 		opt:  *opt,
 		mode: compressionModeFromName(opt.CompressionMode),
+		auto: opt.CompressionMode == "auto",
 	}
 	// Correct root if definitely pointing to a file
 	if err == fs.ErrorIsFile {
@@ -219,11 +272,37 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd", "auto":
+		return Zstd
+	case "lz4":
+		return Lz4
+	case "brotli":
+		return Brotli
 	default:
 		return Uncompressed
 	}
 }
 
+// checkLevel checks the compression level for the mode in use is valid
+func checkLevel(opt *Options) error {
+	var name string
+	var level, minLevel, maxLevel int
+	switch compressionModeFromName(opt.CompressionMode) {
+	case Zstd:
+		name, level, minLevel, maxLevel = "zstd_level", opt.ZstdLevel, 1, 22
+	case Lz4:
+		name, level, minLevel, maxLevel = "lz4_level", opt.Lz4Level, 0, 9
+	case Brotli:
+		name, level, minLevel, maxLevel = "brotli_level", opt.BrotliLevel, brotli.BestSpeed, brotli.BestCompression
+	default:
+		return nil
+	}
+	if level < minLevel || level > maxLevel {
+		return fmt.Errorf("%s must be between %d and %d but is %d", name, minLevel, maxLevel, level)
+	}
+	return nil
+}
+
 // Converts an int64 to base64
 func int64ToBase64(number int64) string {
 	intBytes := make([]byte, 8)
@@ -261,7 +340,7 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
 }
 
 // Generates the file name for a metadata file
@@ -287,13 +366,27 @@ func unwrapMetadataFile(filename string) (string, bool) {
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
 	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
+		newRemote = remote + "." + int64ToBase64(size) + compressedFileExt(mode)
 	} else {
 		newRemote = remote + uncompressedFileExt
 	}
 	return newRemote
 }
 
+// compressedFileExt returns the file extension for data compressed with mode
+func compressedFileExt(mode int) string {
+	switch mode {
+	case Zstd:
+		return zstdFileExt
+	case Lz4:
+		return lz4FileExt
+	case Brotli:
+		return brotliFileExt
+	default:
+		return gzFileExt
+	}
+}
+
 // dataName generates the file name for data file
 func (f *Fs) dataName(remote string, size int64, compressed bool) (name string) {
 	if !compressed {
@@ -428,7 +521,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 		return nil, fmt.Errorf("error decoding metadata: %w", err)
 	}
 	// Create our Object
-	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.CompressionMetadata.Size, meta.Mode))
+	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.Size, meta.Mode))
 	if err != nil {
 		return nil, err
 	}
@@ -437,7 +530,7 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 
 // checkCompressAndType checks if an object is compressible and determines it's mime type
 // returns a multireader with the bytes that were read to determine mime type
-func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool, mimeType string, err error) {
+func (f *Fs) checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool, mimeType string, err error) {
 	in, wrap := accounting.UnWrap(in)
 	buf := make([]byte, heuristicBytes)
 	n, err := in.Read(buf)
@@ -446,14 +539,62 @@ func checkCompressAndType(in io.Reader) (newReader io.Reader, compressible bool,
 		return nil, false, "", err
 	}
 	mime := mimetype.Detect(buf)
-	compressible, err = isCompressible(bytes.NewReader(buf))
-	if err != nil {
-		return nil, false, "", err
+	if f.auto && isCompressedMimeType(mime) {
+		compressible = false
+	} else {
+		compressible, err = isCompressible(bytes.NewReader(buf))
+		if err != nil {
+			return nil, false, "", err
+		}
 	}
 	in = io.MultiReader(bytes.NewReader(buf), in)
 	return wrap(in), compressible, mime.String(), nil
 }
 
+// compressedMimeTypes are the MIME types of data which is already
+// compressed, so isn't worth compressing again in auto mode. Types
+// based on these, like docx which is a zip file, are included.
+var compressedMimeTypes = []string{
+	"application/gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/lzip",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zip",
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/jxl",
+	"audio/mpeg",
+	"audio/aac",
+	"audio/ogg",
+	"audio/flac",
+	"audio/ape",
+	"audio/musepack",
+	"audio/amr",
+	"font/woff",
+	"font/woff2",
+}
+
+// isCompressedMimeType returns true if mime is a type of data which is
+// already compressed
+func isCompressedMimeType(mime *mimetype.MIME) bool {
+	for ; mime != nil; mime = mime.Parent() {
+		if strings.HasPrefix(mime.String(), "video/") {
+			return true
+		}
+		for _, compressed := range compressedMimeTypes {
+			if mime.Is(compressed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // isCompressible checks the compression ratio of the provided data and returns true if the ratio exceeds
 // the configured threshold
 func isCompressible(r io.Reader) (bool, error) {
@@ -496,7 +637,65 @@ type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ..
 
 type compressionResult struct {
 	err  error
-	meta sgzip.GzipMetadata
+	size int64              // uncompressed size
+	meta sgzip.GzipMetadata // gzip mode only
+	zstd *ZstdMetadata      // zstd mode only
+}
+
+// newCompressor returns a writer which compresses data to w in the
+// compression mode of f
+func (f *Fs) newCompressor(w io.Writer) (io.WriteCloser, error) {
+	switch f.mode {
+	case Gzip:
+		return sgzip.NewWriterLevel(w, f.opt.CompressionLevel)
+	case Zstd:
+		return newZstdWriter(w, f.opt.ZstdLevel)
+	case Lz4:
+		lw := lz4.NewWriter(w)
+		level := lz4.Fast
+		if f.opt.Lz4Level > 0 {
+			level = lz4.CompressionLevel(1 << (7 + f.opt.Lz4Level))
+		}
+		if err := lw.Apply(lz4.CompressionLevelOption(level)); err != nil {
+			return nil, err
+		}
+		return lw, nil
+	case Brotli:
+		return brotli.NewWriterLevel(w, f.opt.BrotliLevel), nil
+	}
+	return nil, fmt.Errorf("unknown compression mode %d", f.mode)
+}
+
+// newDecompressor returns a reader which decompresses the data in
+// compressed starting at offset in the uncompressed data
+func newDecompressor(compressed io.ReadSeeker, meta *ObjectMetadata, offset int64) (io.ReadCloser, error) {
+	switch meta.Mode {
+	case Gzip:
+		if offset != 0 {
+			return sgzip.NewReaderAt(compressed, &meta.CompressionMetadata, offset)
+		}
+		return sgzip.NewReader(compressed)
+	case Zstd:
+		return newZstdReader(compressed, meta.ZstdMetadata, offset)
+	case Lz4:
+		return skipDecompressed(io.NopCloser(lz4.NewReader(compressed)), offset)
+	case Brotli:
+		return skipDecompressed(io.NopCloser(brotli.NewReader(compressed)), offset)
+	}
+	return nil, fmt.Errorf("unknown compression mode %d", meta.Mode)
+}
+
+// skipDecompressed discards offset bytes from the start of rc for
+// modes which can't seek
+func skipDecompressed(rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		_, err := io.CopyN(io.Discard, rc, offset)
+		if err != nil && err != io.EOF {
+			_ = rc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
 }
 
 // replicating some of operations.Rcat functionality because we want to support remotes without streaming
@@ -559,17 +758,18 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
+		cw, err := f.newCompressor(pipeWriter)
 		if err != nil {
-			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
+			_ = pipeWriter.CloseWithError(err)
+			results <- compressionResult{err: err}
 			return
 		}
-		_, err = io.Copy(gz, in)
-		gzErr := gz.Close()
-		if gzErr != nil {
-			fs.Errorf(nil, "Failed to close compress: %v", gzErr)
+		n, err := io.Copy(cw, in)
+		cwErr := cw.Close()
+		if cwErr != nil {
+			fs.Errorf(nil, "Failed to close compress: %v", cwErr)
 			if err == nil {
-				err = gzErr
+				err = cwErr
 			}
 		}
 		closeErr := pipeWriter.Close()
@@ -579,7 +779,14 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 				err = closeErr
 			}
 		}
-		results <- compressionResult{err: err, meta: gz.MetaData()}
+		result := compressionResult{err: err, size: n}
+		switch x := cw.(type) {
+		case *sgzip.Writer:
+			result.meta = x.MetaData()
+		case *zstdWriter:
+			result.zstd = &x.meta
+		}
+		results <- result
 	}()
 	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
 
@@ -626,7 +833,8 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	}
 
 	// Generate metadata
-	meta := newMetadata(result.meta.Size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	meta := newMetadata(result.size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	meta.ZstdMetadata = result.zstd
 
 	// Check the hashes of the compressed data if we were comparing them
 	if ht != hash.None && hasher != nil {
@@ -751,7 +959,7 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	o, err := f.NewObject(ctx, src.Remote())
 	if err == fs.ErrorObjectNotFound {
 		// Get our file compressibility
-		in, compressible, mimeType, err := checkCompressAndType(in)
+		in, compressible, mimeType, err := f.checkCompressAndType(in)
 		if err != nil {
 			return nil, err
 		}
@@ -771,7 +979,7 @@ func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, opt
 	}
 	found := err == nil
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := f.checkCompressAndType(in)
 	if err != nil {
 		return nil, err
 	}
@@ -1095,6 +1303,7 @@ type ObjectMetadata struct {
 	MD5                 string // MD5 hash of the file.
 	MimeType            string // Mime type of the file
 	CompressionMetadata sgzip.GzipMetadata
+	ZstdMetadata        *ZstdMetadata `json:",omitempty"`
 }
 
 // Object with external metadata
@@ -1165,7 +1374,7 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		return o.mo, o.mo.Update(ctx, in, src, options...)
 	}
 
-	in, compressible, mimeType, err := checkCompressAndType(in)
+	in, compressible, mimeType, err := o.f.checkCompressAndType(in)
 	if err != nil {
 		return err
 	}
@@ -1396,13 +1605,9 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	// Get a chunkedreader for the wrapped object
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize, chunkStreams)
 	// Get file handle
-	var file io.Reader
-	if offset != 0 {
-		file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
-	} else {
-		file, err = sgzip.NewReader(chunkedReader)
-	}
+	file, err := newDecompressor(chunkedReader, o.meta, offset)
 	if err != nil {
+		_ = chunkedReader.Close()
 		return nil, err
 	}
 
@@ -1413,7 +1618,23 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		fileReader = file
 	}
 	// Return a ReadCloser
-	return ReadCloserWrapper{Reader: fileReader, Closer: chunkedReader}, nil
+	return ReadCloserWrapper{Reader: fileReader, Closer: &decompressCloser{dec: file, in: chunkedReader}}, nil
+}
+
+// decompressCloser closes the decompressor then the compressed data
+type decompressCloser struct {
+	dec io.Closer
+	in  io.Closer
+}
+
+// Close the decompressor and the compressed data
+func (c *decompressCloser) Close() error {
+	decErr := c.dec.Close()
+	err := c.in.Close()
+	if err == nil {
+		err = decErr
+	}
+	return err
 }
 
 // ObjectInfo describes a wrapped fs.ObjectInfo for being the source
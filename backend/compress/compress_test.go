@@ -2,16 +2,27 @@
 package compress
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/rclone/rclone/backend/drive"
 	_ "github.com/rclone/rclone/backend/local"
 	_ "github.com/rclone/rclone/backend/s3"
 	_ "github.com/rclone/rclone/backend/swift"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var defaultOpt = fstests.Opt{
@@ -23,6 +34,7 @@ var defaultOpt = fstests.Opt{
 		"MergeDirs",
 		"DirCacheFlush",
 		"PutUnchecked",
+		"PutIfNotExists",
 		"PutStream",
 		"UserInfo",
 		"Disconnect",
@@ -53,3 +65,116 @@ func TestRemoteGzip(t *testing.T) {
 	opt.QuickTestOK = true
 	fstests.Run(t, &opt)
 }
+
+// TestRemoteZstd tests zstd compression
+func TestRemoteZstd(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-compress-test-zstd")
+	name := "TestCompressZstd"
+	opt := defaultOpt
+	opt.RemoteName = name + ":"
+	opt.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "compress"},
+		{Name: name, Key: "remote", Value: tempdir},
+		{Name: name, Key: "mode", Value: "zstd"},
+	}
+	opt.QuickTestOK = true
+	fstests.Run(t, &opt)
+}
+
+// TestModes checks compressible data round trips in each mode,
+// including reads from part way through
+func TestModes(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 3*zstdFrameSize+12345; i++ {
+		_, _ = fmt.Fprintf(&buf, "%s line %d: something happened\n", time.Unix(int64(i), 0).UTC().Format(time.RFC3339), i)
+	}
+	data := buf.Bytes()
+	for _, test := range []struct {
+		mode string
+		ext  string
+	}{
+		{"gzip", gzFileExt},
+		{"zstd", zstdFileExt},
+		{"lz4", lz4FileExt},
+		{"brotli", brotliFileExt},
+		{"auto", zstdFileExt},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := NewFs(ctx, "TestCompress", "", configmap.Simple{
+				"remote":       dir,
+				"mode":         test.mode,
+				"level":        "-1",
+				"zstd_level":   "3",
+				"lz4_level":    "0",
+				"brotli_level": "1",
+			})
+			require.NoError(t, err)
+			src := object.NewStaticObjectInfo("file.log", time.Now(), int64(len(data)), true, nil, nil)
+			o, err := f.Put(ctx, bytes.NewReader(data), src)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), o.Size())
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			require.Len(t, names, 2)
+			assert.True(t, strings.HasSuffix(names[0], test.ext), names[0])
+			info, err := entries[0].Info()
+			require.NoError(t, err)
+			assert.Less(t, info.Size(), int64(len(data)/2))
+
+			o, err = f.NewObject(ctx, "file.log")
+			require.NoError(t, err)
+			read := func(options ...fs.OpenOption) []byte {
+				in, err := o.Open(ctx, options...)
+				require.NoError(t, err)
+				got, err := io.ReadAll(in)
+				require.NoError(t, err)
+				require.NoError(t, in.Close())
+				return got
+			}
+			assert.Equal(t, data, read())
+			for _, start := range []int64{1, zstdFrameSize - 1, zstdFrameSize, 2*zstdFrameSize + 17, int64(len(data)) - 5} {
+				assert.Equal(t, data[start:], read(&fs.SeekOption{Offset: start}), "seek %d", start)
+				end := min(start+999, int64(len(data))-1)
+				assert.Equal(t, data[start:end+1], read(&fs.RangeOption{Start: start, End: end}), "range %d", start)
+			}
+		})
+	}
+}
+
+// TestAutoMimeTypes checks auto mode stores already compressed data uncompressed
+func TestAutoMimeTypes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := NewFs(ctx, "TestCompress", "", configmap.Simple{
+		"remote":     dir,
+		"mode":       "auto",
+		"zstd_level": "3",
+	})
+	require.NoError(t, err)
+	// A zip file which compresses well but shouldn't be compressed again
+	data := append([]byte("PK\x03\x04"), bytes.Repeat([]byte("a"), 10000)...)
+	src := object.NewStaticObjectInfo("file.zip", time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	assert.Equal(t, Uncompressed, o.(*Object).meta.Mode)
+	assert.Equal(t, "application/zip", o.(*Object).MimeType(ctx))
+	_, err = os.Stat(filepath.Join(dir, "file.zip"+uncompressedFileExt))
+	assert.NoError(t, err)
+
+	_, err = NewFs(ctx, "TestCompress", "", configmap.Simple{
+		"remote":     dir,
+		"mode":       "zstd",
+		"zstd_level": "23",
+	})
+	assert.ErrorContains(t, err, "zstd_level")
+}
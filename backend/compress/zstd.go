@@ -0,0 +1,134 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Seekable zstd
+//
+// The data is compressed in independent frames of zstdFrameSize bytes
+// so reads can start at the frame containing the offset. The sizes of
+// the frames are kept in the metadata file. They are also written to
+// a seek table in a skippable frame at the end of the file in the zstd
+// seekable format so other tools can seek in the file too. Standard
+// zstd decoders skip the seek table.
+const (
+	zstdFrameSize      = 1024 * 1024 // uncompressed size of each frame
+	zstdSkippableMagic = 0x184D2A5E  // magic number of the skippable frame holding the seek table
+	zstdSeekableMagic  = 0x8F92EAB1  // magic number at the end of the seek table
+	zstdSeekFooterSize = 9
+)
+
+// ZstdMetadata describes the frames of a seekable zstd file
+type ZstdMetadata struct {
+	FrameSize int      // Uncompressed size of each frame but the last
+	FrameData []uint32 // Compressed size of each frame
+}
+
+// zstdWriter compresses data into a seekable zstd file
+type zstdWriter struct {
+	w    io.Writer
+	enc  *zstd.Encoder
+	buf  []byte // uncompressed data for the frame being written
+	out  []byte // compressed frame
+	size int64  // uncompressed bytes written
+	meta ZstdMetadata
+}
+
+// newZstdWriter returns a writer compressing to w at level
+func newZstdWriter(w io.Writer, level int) (*zstdWriter, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{
+		w:    w,
+		enc:  enc,
+		buf:  make([]byte, 0, zstdFrameSize),
+		meta: ZstdMetadata{FrameSize: zstdFrameSize},
+	}, nil
+}
+
+// Write compresses p, writing out each frame as it fills up
+func (z *zstdWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		copied := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+copied]
+		p = p[copied:]
+		n += copied
+		if len(z.buf) == cap(z.buf) {
+			if err = z.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush compresses and writes out the frame being written
+func (z *zstdWriter) flush() error {
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.out = z.enc.EncodeAll(z.buf, z.out[:0])
+	if _, err := z.w.Write(z.out); err != nil {
+		return err
+	}
+	z.size += int64(len(z.buf))
+	z.meta.FrameData = append(z.meta.FrameData, uint32(len(z.out)))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// Close writes out the last frame and the seek table
+func (z *zstdWriter) Close() error {
+	defer func() {
+		_ = z.enc.Close()
+	}()
+	if err := z.flush(); err != nil {
+		return err
+	}
+	frames := len(z.meta.FrameData)
+	table := make([]byte, 0, 8+8*frames+zstdSeekFooterSize)
+	table = binary.LittleEndian.AppendUint32(table, zstdSkippableMagic)
+	table = binary.LittleEndian.AppendUint32(table, uint32(8*frames+zstdSeekFooterSize))
+	for i, compressedSize := range z.meta.FrameData {
+		size := int64(z.meta.FrameSize)
+		if i == frames-1 {
+			size = z.size - int64(i)*size
+		}
+		table = binary.LittleEndian.AppendUint32(table, compressedSize)
+		table = binary.LittleEndian.AppendUint32(table, uint32(size))
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(frames))
+	table = append(table, 0) // descriptor - no checksums
+	table = binary.LittleEndian.AppendUint32(table, zstdSeekableMagic)
+	_, err := z.w.Write(table)
+	return err
+}
+
+// newZstdReader returns a reader for the seekable zstd file in
+// compressed starting at offset in the uncompressed data
+func newZstdReader(compressed io.ReadSeeker, meta *ZstdMetadata, offset int64) (io.ReadCloser, error) {
+	skip := offset
+	if offset > 0 && meta != nil && meta.FrameSize > 0 {
+		frame := min(offset/int64(meta.FrameSize), int64(len(meta.FrameData)))
+		var start int64
+		for _, compressedSize := range meta.FrameData[:frame] {
+			start += int64(compressedSize)
+		}
+		if _, err := compressed.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek to zstd frame: %w", err)
+		}
+		skip = offset - frame*int64(meta.FrameSize)
+	}
+	dec, err := zstd.NewReader(compressed, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return skipDecompressed(dec.IOReadCloser(), skip)
+}
@@ -0,0 +1,129 @@
+package crypt
+
+// Bech32 encoding as described in BIP 173 which is used for age keys
+//
+// Unlike BIP 173 there is no limit on the length of the string.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Polymod calculates the checksum of values
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>i)&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HRPExpand expands the human readable part for the checksum
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// bech32ConvertBits regroups the bits in data from frombits to tobits
+// per byte
+func bech32ConvertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var out []byte
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<tobits - 1
+	for _, b := range data {
+		if uint32(b)>>frombits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<frombits | uint32(b)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(tobits-bits)&maxv))
+		}
+	} else if bits >= frombits {
+		return nil, errors.New("illegal zero padding")
+	} else if acc<<(tobits-bits)&maxv != 0 {
+		return nil, errors.New("non zero padding")
+	}
+	return out, nil
+}
+
+// bech32Encode encodes data with the human readable part hrp
+//
+// The output is lower case.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	hrp = strings.ToLower(hrp)
+	check := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var out strings.Builder
+	out.WriteString(hrp)
+	out.WriteByte('1')
+	for _, v := range values {
+		out.WriteByte(bech32Charset[v])
+	}
+	for i := range 6 {
+		out.WriteByte(bech32Charset[(check>>(5*(5-i)))&31])
+	}
+	return out.String(), nil
+}
+
+// bech32Decode decodes s returning the human readable part in lower
+// case and the data
+func bech32Decode(s string) (hrp string, data []byte, err error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("separator '1' at invalid position")
+	}
+	hrp = s[:pos]
+	for i := range len(hrp) {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character %q in prefix", hrp[i])
+		}
+	}
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err = bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -170,17 +170,26 @@ func NewNameEncoding(s string) (enc fileNameEncoding, err error) {
 
 // Cipher defines an encoding and decoding cipher for the crypt backend
 type Cipher struct {
-	dataKey         [32]byte                  // Key for secretbox
-	nameKey         [32]byte                  // 16,24 or 32 bytes
-	nameTweak       [nameCipherBlockSize]byte // used to tweak the name crypto
-	block           gocipher.Block
-	mode            NameEncryptionMode
-	fileNameEnc     fileNameEncoding
-	buffers         sync.Pool // encrypt/decrypt buffers
-	cryptoRand      io.Reader // read crypto random numbers from here
-	dirNameEncrypt  bool
-	passBadBlocks   bool // if set passed bad blocks as zeroed blocks
-	encryptedSuffix string
+	dataKey          [32]byte                  // Key for secretbox
+	nameKey          [32]byte                  // 16,24 or 32 bytes
+	nameTweak        [nameCipherBlockSize]byte // used to tweak the name crypto
+	block            gocipher.Block
+	mode             NameEncryptionMode
+	fileNameEnc      fileNameEncoding
+	buffers          sync.Pool // encrypt/decrypt buffers
+	cryptoRand       io.Reader // read crypto random numbers from here
+	dirNameEncrypt   bool
+	passBadBlocks    bool // if set passed bad blocks as zeroed blocks
+	encryptedSuffix  string
+	salt             []byte      // salt used to derive keys from passwords
+	masterKeys       []masterKey // keys for the per file keys, current one first
+	perFileKeys      bool        // if set write files with their own keys
+	headerSize       int         // size of the headers written
+	publicKey        *[32]byte   // if set the file keys are sealed to this
+	publicKeyID      keyID       // ID of publicKey
+	privateKey       *[32]byte   // private key for reading sealed file keys
+	privatePublicKey *[32]byte   // public key of privateKey
+	privateKeyID     keyID       // ID of privatePublicKey
 }
 
 // newCipher initialises the cipher.  If salt is "" then it uses a built in salt val
@@ -199,6 +208,11 @@ func newCipher(mode NameEncryptionMode, password, salt string, dirNameEncrypt bo
 	if err != nil {
 		return nil, err
 	}
+	err = c.setMasterKeys("", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setPerFileKeys(false)
 	return c, nil
 }
 
@@ -234,6 +248,7 @@ func (c *Cipher) Key(password, salt string) (err error) {
 	if salt != "" {
 		saltBytes = []byte(salt)
 	}
+	c.salt = saltBytes
 	var key []byte
 	if password == "" {
 		key = make([]byte, keySize)
@@ -682,6 +697,7 @@ type encrypter struct {
 	mu       sync.Mutex
 	in       io.Reader
 	c        *Cipher
+	header   *fileHeader
 	nonce    nonce
 	buf      *[blockSize]byte
 	readBuf  *[blockSize]byte
@@ -691,27 +707,28 @@ type encrypter struct {
 }
 
 // newEncrypter creates a new file handle encrypting on the fly
-func (c *Cipher) newEncrypter(in io.Reader, nonce *nonce) (*encrypter, error) {
+//
+// If header is nil a new one is made.
+func (c *Cipher) newEncrypter(in io.Reader, header *fileHeader) (*encrypter, error) {
+	// Initialise header with the nonce and key
+	if header == nil {
+		var err error
+		header, err = c.newFileHeader()
+		if err != nil {
+			return nil, err
+		}
+	}
 	fh := &encrypter{
 		in:      in,
 		c:       c,
+		header:  header,
+		nonce:   header.nonce,
 		buf:     c.getBlock(),
 		readBuf: c.getBlock(),
-		bufSize: fileHeaderSize,
-	}
-	// Initialise nonce
-	if nonce != nil {
-		fh.nonce = *nonce
-	} else {
-		err := fh.nonce.fromReader(c.cryptoRand)
-		if err != nil {
-			return nil, err
-		}
+		bufSize: len(header.raw),
 	}
-	// Copy magic into buffer
-	copy((*fh.buf)[:], fileMagicBytes)
-	// Copy nonce into buffer
-	copy((*fh.buf)[fileMagicSize:], fh.nonce[:])
+	// Copy header into buffer
+	copy((*fh.buf)[:], header.raw)
 	return fh, nil
 }
 
@@ -734,7 +751,7 @@ func (fh *encrypter) Read(p []byte) (n int, err error) {
 		// possibly err != nil here, but we will process the
 		// data and the next call to ReadFill will return 0, err
 		// Encrypt the block using the nonce
-		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), &fh.c.dataKey)
+		secretbox.Seal((*fh.buf)[:0], readBuf[:n], fh.nonce.pointer(), &fh.header.key)
 		fh.bufIndex = 0
 		fh.bufSize = blockHeaderSize + n
 		fh.nonce.increment()
@@ -777,6 +794,7 @@ func (c *Cipher) EncryptData(in io.Reader) (io.Reader, error) {
 type decrypter struct {
 	mu           sync.Mutex
 	rc           io.ReadCloser
+	header       *fileHeader
 	nonce        nonce
 	initialNonce nonce
 	c            *Cipher
@@ -798,21 +816,13 @@ func (c *Cipher) newDecrypter(rc io.ReadCloser) (*decrypter, error) {
 		readBuf: c.getBlock(),
 		limit:   -1,
 	}
-	// Read file header (magic + nonce)
-	readBuf := (*fh.readBuf)[:fileHeaderSize]
-	n, err := readers.ReadFill(fh.rc, readBuf)
-	if n < fileHeaderSize && err == io.EOF {
-		// This read from 0..fileHeaderSize-1 bytes
-		return nil, fh.finishAndClose(ErrorEncryptedFileTooShort)
-	} else if err != io.EOF && err != nil {
+	// Read file header (magic + nonce and maybe key)
+	header, err := c.readFileHeader(fh.rc)
+	if err != nil {
 		return nil, fh.finishAndClose(err)
 	}
-	// check the magic
-	if !bytes.Equal(readBuf[:fileMagicSize], fileMagicBytes) {
-		return nil, fh.finishAndClose(ErrorEncryptedBadMagic)
-	}
-	// retrieve the nonce
-	fh.nonce.fromBuf(readBuf[fileMagicSize:])
+	fh.header = header
+	fh.nonce = header.nonce
 	fh.initialNonce = fh.nonce
 	return fh, nil
 }
@@ -828,12 +838,12 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 		rc, err = open(ctx, 0, -1)
 	} else if offset == 0 {
 		// If no offset open the header + limit worth of the file
-		_, underlyingLimit, _, _ := calculateUnderlying(offset, limit)
-		rc, err = open(ctx, 0, int64(fileHeaderSize)+underlyingLimit)
+		_, underlyingLimit, _, _ := calculateUnderlying(c.headerSize, offset, limit)
+		rc, err = open(ctx, 0, int64(c.headerSize)+underlyingLimit)
 		setLimit = true
 	} else {
 		// Otherwise just read the header to start with
-		rc, err = open(ctx, 0, int64(fileHeaderSize))
+		rc, err = open(ctx, 0, int64(maxFileHeaderSize))
 		doRangeSeek = true
 	}
 	if err != nil {
@@ -845,6 +855,11 @@ func (c *Cipher) newDecrypterSeek(ctx context.Context, open OpenRangeSeek, offse
 		return nil, err
 	}
 	fh.open = open // will be called by fh.RangeSeek
+	if setLimit && len(fh.header.raw) > c.headerSize {
+		// The header was bigger than expected so the limit was
+		// too short - reopen the file with the right limit
+		doRangeSeek, setLimit = true, false
+	}
 	if doRangeSeek {
 		_, err = fh.RangeSeek(ctx, offset, io.SeekStart, limit)
 		if err != nil {
@@ -877,7 +892,7 @@ func (fh *decrypter) fillBuffer() (err error) {
 		return ErrorEncryptedFileBadHeader
 	}
 	// Decrypt the block using the nonce
-	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), &fh.c.dataKey)
+	_, ok := secretbox.Open((*fh.buf)[:0], (*readBuf)[:n], fh.nonce.pointer(), &fh.header.key)
 	if !ok {
 		if err != nil && err != io.EOF {
 			return err // return pending error as it is likely more accurate
@@ -927,17 +942,18 @@ func (fh *decrypter) Read(p []byte) (n int, err error) {
 }
 
 // calculateUnderlying converts an (offset, limit) in an encrypted file
-// into an (underlyingOffset, underlyingLimit) for the underlying file.
+// with a header of headerSize into an (underlyingOffset,
+// underlyingLimit) for the underlying file.
 //
 // It also returns number of bytes to discard after reading the first
 // block and number of blocks this is from the start so the nonce can
 // be incremented.
-func calculateUnderlying(offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
+func calculateUnderlying(headerSize int, offset, limit int64) (underlyingOffset, underlyingLimit, discard, blocks int64) {
 	// blocks we need to seek, plus bytes we need to discard
 	blocks, discard = offset/blockDataSize, offset%blockDataSize
 
 	// Offset in underlying stream we need to seek
-	underlyingOffset = int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	underlyingOffset = int64(headerSize) + blocks*(blockHeaderSize+blockDataSize)
 
 	// work out how many blocks we need to read
 	underlyingLimit = int64(-1)
@@ -987,7 +1003,7 @@ func (fh *decrypter) RangeSeek(ctx context.Context, offset int64, whence int, li
 		return 0, fh.err
 	}
 
-	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(offset, limit)
+	underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(len(fh.header.raw), offset, limit)
 
 	// Move the nonce on the correct number of blocks from the start
 	fh.nonce = fh.initialNonce
@@ -1120,7 +1136,7 @@ func (c *Cipher) DecryptDataSeek(ctx context.Context, open OpenRangeSeek, offset
 // EncryptedSize calculates the size of the data when encrypted
 func (c *Cipher) EncryptedSize(size int64) int64 {
 	blocks, residue := size/blockDataSize, size%blockDataSize
-	encryptedSize := int64(fileHeaderSize) + blocks*(blockHeaderSize+blockDataSize)
+	encryptedSize := int64(c.headerSize) + blocks*(blockHeaderSize+blockDataSize)
 	if residue != 0 {
 		encryptedSize += blockHeaderSize + residue
 	}
@@ -1128,8 +1144,38 @@ func (c *Cipher) EncryptedSize(size int64) int64 {
 }
 
 // DecryptedSize calculates the size of the data when decrypted
+//
+// This assumes the file has a header of the size being written, see
+// headersMayDiffer.
 func (c *Cipher) DecryptedSize(size int64) (int64, error) {
-	size -= int64(fileHeaderSize)
+	return decryptedSize(c.headerSize, size)
+}
+
+// headersMayDiffer returns true if the files read may have headers of
+// different sizes, in which case the size of a file can't be worked
+// out from its encrypted size alone.
+func (c *Cipher) headersMayDiffer() bool {
+	return c.perFileKeys || c.publicKey != nil || c.privateKey != nil || len(c.masterKeys) > 1
+}
+
+// headerSizeFromMagic returns the size of the file header which
+// starts with magic
+func headerSizeFromMagic(magic []byte) (int, error) {
+	switch {
+	case bytes.Equal(magic, fileMagicBytes):
+		return fileHeaderSize, nil
+	case bytes.Equal(magic, fileMagicV2Bytes):
+		return fileHeaderSizeV2, nil
+	case bytes.Equal(magic, fileMagicV3Bytes):
+		return fileHeaderSizeV3, nil
+	}
+	return 0, ErrorEncryptedBadMagic
+}
+
+// decryptedSize calculates the size of the data when decrypted from
+// a file with a header of headerSize
+func decryptedSize(headerSize int, size int64) (int64, error) {
+	size -= int64(headerSize)
 	if size < 0 {
 		return 0, ErrorEncryptedFileTooShort
 	}
@@ -1474,7 +1474,7 @@ func TestDecrypterCalculateUnderlying(t *testing.T) {
 		{blockDataSize + 1, blockDataSize + 1, int64(fileHeaderSize) + blockSize, 2 * blockSize, 1, 1},
 	} {
 		what := fmt.Sprintf("offset = %d, limit = %d", test.offset, test.limit)
-		underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(test.offset, test.limit)
+		underlyingOffset, underlyingLimit, discard, blocks := calculateUnderlying(fileHeaderSize, test.offset, test.limit)
 		assert.Equal(t, test.wantOffset, underlyingOffset, what)
 		assert.Equal(t, test.wantLimit, underlyingLimit, what)
 		assert.Equal(t, test.wantDiscard, discard, what)
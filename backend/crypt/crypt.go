@@ -2,12 +2,14 @@
 package crypt
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
@@ -19,6 +21,7 @@ import (
 	"github.com/rclone/rclone/fs/fspath"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/list"
+	"golang.org/x/sync/errgroup"
 )
 
 // Globals
@@ -30,7 +33,11 @@ func init() {
 		NewFs:       NewFs,
 		CommandHelp: commandHelp,
 		MetadataInfo: &fs.MetadataInfo{
-			Help: `Any metadata supported by the underlying remote is read and written.`,
+			Help: `Any metadata supported by the underlying remote is read and written.
+
+If encrypt_metadata is set the metadata is encrypted and stored in a
+single user metadata item called "rclone-crypt" on the underlying
+remote.`,
 		},
 		Options: []fs.Option{{
 			Name:     "remote",
@@ -172,6 +179,120 @@ Setting suffix to "none" will result in an empty suffix. This may be useful
 when the path length is critical.`,
 			Default:  ".bin",
 			Advanced: true,
+		}, {
+			Name: "per_file_keys",
+			Help: `If set, encrypt each file with its own random key.
+
+The file key is stored in the header of the file encrypted with a
+master key, so the master key can be changed with the "rekey" backend
+command without re-encrypting the file data or renaming the files.
+
+This makes the header of each file 80 bytes larger. Files written
+without this set are still readable. As the files can then have
+headers of different sizes, the start of each file is read to find
+its size when it is listed, which costs an extra request per file.
+Keep this set once files have been written with it, as their sizes
+are worked out wrongly otherwise.
+
+Files written with this set can't be read by versions of rclone
+without it.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "master_password",
+			Help: `Password or pass phrase for the master key.
+
+This encrypts the file keys when per_file_keys is set. If it isn't set
+the master key is derived from password and password2.
+
+This is normally set by the "rekey" backend command.`,
+			IsPassword: true,
+			Advanced:   true,
+		}, {
+			Name: "old_master_passwords",
+			Help: `Previous master passwords, obscured and comma separated.
+
+These are used to read files whose keys haven't been re-encrypted
+with the current master password yet. The "rekey" backend command adds
+the old master password here when it changes the master password.
+
+Remove the passwords from here once "rekey" has finished on the whole
+remote without errors.`,
+			Default:   fs.CommaSepList{},
+			Advanced:  true,
+			Sensitive: true,
+		}, {
+			Name: "public_key",
+			Help: `Public key to encrypt the file keys to.
+
+If set, each file is encrypted with its own random key which is then
+encrypted to this X25519 public key. The matching private_key is
+needed to read the files, so a remote with only this set can write
+files but not read them back.
+
+This can be an age recipient (age1...) or 32 bytes of base64. A key
+pair can be made with the "keygen" backend command or with age-keygen.
+
+File names are still encrypted with password and password2.
+
+This makes the header of each file 88 bytes larger. Files written with
+this set can't be read by versions of rclone without it.`,
+			Advanced: true,
+		}, {
+			Name: "private_key",
+			Help: `Private key to decrypt the file keys with.
+
+This is needed to read files written with public_key set. Leave it
+blank on remotes which should only write files.
+
+This can be an age identity (AGE-SECRET-KEY-1...) or 32 bytes of
+base64.`,
+			Advanced:  true,
+			Sensitive: true,
+		}, {
+			Name: "encrypt_metadata",
+			Help: `If set, encrypt the metadata of files and directories.
+
+Metadata includes user metadata and the system metadata passed through
+with --metadata, such as the permissions and owner of local files.
+Normally this is stored unencrypted on the underlying remote.
+
+If this is set the metadata is encrypted with a key derived from
+password and password2 and stored in a single user metadata item
+called "rclone-crypt". This needs the underlying remote to support
+user metadata. As the system metadata is encrypted it won't be
+applied to the files on the underlying remote.
+
+The modification time of files is still stored unencrypted.`,
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name: "padding",
+			Help: `How to pad the size of files.
+
+Padding hides the exact size of files by adding zeros to the end of
+them before encrypting them. The real size is stored in the encrypted
+metadata so this needs encrypt_metadata to be set.
+
+The metadata of each file is read to find its size when it is listed.
+On remotes which don't return metadata when listing, such as S3, this
+is an extra request for each file listed, made --checkers at a time,
+so listings are much slower.
+
+Files can't be streamed to the remote, so rclone rcat and similar
+will save the data to a temporary file first.
+
+Files written with padding will show the wrong size if padding is
+turned off.`,
+			Default: paddingOff,
+			Examples: []fs.OptionExample{{
+				Value: paddingOff,
+				Help:  "Don't pad files.",
+			}, {
+				Value: paddingPadme,
+				Help:  "Pad files with the Padmé scheme adding up to 12% to their size.",
+			}},
+			Advanced: true,
 		}},
 	})
 }
@@ -206,9 +327,57 @@ func newCipherForConfig(opt *Options) (*Cipher, error) {
 	}
 	cipher.setEncryptedSuffix(opt.Suffix)
 	cipher.setPassBadBlocks(opt.PassBadBlocks)
+	cipher.setPerFileKeys(opt.PerFileKeys)
+	err = setMasterKeysForConfig(cipher, opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.PublicKey != "" {
+		publicKey, err := parsePublicKey(opt.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public_key: %w", err)
+		}
+		cipher.setPublicKey(publicKey)
+	}
+	if opt.PrivateKey != "" {
+		privateKey, err := parsePrivateKey(opt.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private_key: %w", err)
+		}
+		err = cipher.setPrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return cipher, nil
 }
 
+// setMasterKeysForConfig sets the master keys of cipher from the
+// obscured passwords in opt
+func setMasterKeysForConfig(cipher *Cipher, opt *Options) error {
+	var masterPassword string
+	if opt.MasterPassword != "" {
+		var err error
+		masterPassword, err = obscure.Reveal(opt.MasterPassword)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt master_password: %w", err)
+		}
+	}
+	oldPasswords := make([]string, 0, len(opt.OldMasterPasswords))
+	for i, obscured := range opt.OldMasterPasswords {
+		oldPassword, err := obscure.Reveal(obscured)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt old_master_passwords entry %d: %w", i+1, err)
+		}
+		oldPasswords = append(oldPasswords, oldPassword)
+	}
+	err := cipher.setMasterKeys(masterPassword, oldPasswords)
+	if err != nil {
+		return fmt.Errorf("failed to make master keys: %w", err)
+	}
+	return nil
+}
+
 // NewCipher constructs a Cipher for the given config
 func NewCipher(m configmap.Mapper) (*Cipher, error) {
 	// Parse config into Options struct
@@ -232,6 +401,18 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if err != nil {
 		return nil, err
 	}
+	switch opt.Padding {
+	case paddingOff:
+	case paddingPadme:
+		if !opt.EncryptMetadata {
+			return nil, errors.New("padding needs encrypt_metadata to be set")
+		}
+		if opt.NoDataEncryption {
+			return nil, errors.New("can't use padding with no_data_encryption")
+		}
+	default:
+		return nil, fmt.Errorf("unknown padding %q", opt.Padding)
+	}
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
 		return nil, errors.New("can't point crypt remote at itself - check the value of the remote setting")
@@ -256,11 +437,15 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	if err != fs.ErrorIsFile && err != nil {
 		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", remote, err)
 	}
+	if opt.EncryptMetadata && !wrappedFs.Features().UserMetadata {
+		return nil, fmt.Errorf("encrypt_metadata needs remote %q to support user metadata", remote)
+	}
 	f := &Fs{
 		Fs:     wrappedFs,
 		name:   name,
 		root:   rpath,
 		opt:    *opt,
+		m:      m,
 		cipher: cipher,
 	}
 	cache.PinUntilFinalized(f.Fs, f)
@@ -297,23 +482,35 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 	// Enable ListP always
 	f.features.ListP = f.ListP
 
+	// The size must be known to pad the file
+	if opt.Padding != paddingOff {
+		f.features.PutStream = nil
+	}
+
 	return f, err
 }
 
 // Options defines the configuration for this backend
 type Options struct {
-	Remote                  string `config:"remote"`
-	FilenameEncryption      string `config:"filename_encryption"`
-	DirectoryNameEncryption bool   `config:"directory_name_encryption"`
-	NoDataEncryption        bool   `config:"no_data_encryption"`
-	Password                string `config:"password"`
-	Password2               string `config:"password2"`
-	ServerSideAcrossConfigs bool   `config:"server_side_across_configs"`
-	ShowMapping             bool   `config:"show_mapping"`
-	PassBadBlocks           bool   `config:"pass_bad_blocks"`
-	FilenameEncoding        string `config:"filename_encoding"`
-	Suffix                  string `config:"suffix"`
-	StrictNames             bool   `config:"strict_names"`
+	Remote                  string          `config:"remote"`
+	FilenameEncryption      string          `config:"filename_encryption"`
+	DirectoryNameEncryption bool            `config:"directory_name_encryption"`
+	NoDataEncryption        bool            `config:"no_data_encryption"`
+	Password                string          `config:"password"`
+	Password2               string          `config:"password2"`
+	ServerSideAcrossConfigs bool            `config:"server_side_across_configs"`
+	ShowMapping             bool            `config:"show_mapping"`
+	PassBadBlocks           bool            `config:"pass_bad_blocks"`
+	FilenameEncoding        string          `config:"filename_encoding"`
+	Suffix                  string          `config:"suffix"`
+	StrictNames             bool            `config:"strict_names"`
+	PerFileKeys             bool            `config:"per_file_keys"`
+	MasterPassword          string          `config:"master_password"`
+	OldMasterPasswords      fs.CommaSepList `config:"old_master_passwords"`
+	PublicKey               string          `config:"public_key"`
+	PrivateKey              string          `config:"private_key"`
+	EncryptMetadata         bool            `config:"encrypt_metadata"`
+	Padding                 string          `config:"padding"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -323,7 +520,8 @@ type Fs struct {
 	name     string
 	root     string
 	opt      Options
-	features *fs.Features // optional features
+	m        configmap.Mapper // config map for saving changed master passwords
+	features *fs.Features     // optional features
 	cipher   *Cipher
 }
 
@@ -407,9 +605,37 @@ func (f *Fs) encryptEntries(ctx context.Context, entries fs.DirEntries) (newEntr
 	if firsterr != nil {
 		return nil, fmt.Errorf("there were %v undecryptable name errors. first error: %v", errors, firsterr)
 	}
+	err = f.readSizes(ctx, newEntries)
+	if err != nil {
+		return nil, err
+	}
 	return newEntries, nil
 }
 
+// sizesNeedReading returns true if the size of a file can't be worked
+// out from the listing of the underlying remote alone
+func (f *Fs) sizesNeedReading() bool {
+	return (!f.opt.NoDataEncryption && f.cipher.headersMayDiffer()) || f.opt.Padding != paddingOff
+}
+
+// readSizes reads what is needed to find the sizes of the objects in
+// entries, --checkers at a time, so Size doesn't need to
+func (f *Fs) readSizes(ctx context.Context, entries fs.DirEntries) error {
+	if !f.sizesNeedReading() {
+		return nil
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	for _, entry := range entries {
+		if o, ok := entry.(*Object); ok {
+			g.Go(func() error {
+				return o.readSize(gCtx)
+			})
+		}
+	}
+	return g.Wait()
+}
+
 // List the objects and directories in dir into entries.  The
 // entries can be returned in any order but should be for a
 // complete directory.
@@ -488,7 +714,14 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(o), nil
+	obj := f.newObject(o)
+	if f.sizesNeedReading() {
+		err = obj.readSize(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
 }
 
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
@@ -498,7 +731,11 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	ci := fs.GetConfig(ctx)
 
 	if f.opt.NoDataEncryption {
-		o, err := put(ctx, in, f.newObjectInfo(src, nonce{}), options...)
+		ctx, options, info, err := f.prepareMetadata(ctx, src, options, nil)
+		if err != nil {
+			return nil, err
+		}
+		o, err := put(ctx, in, info.withSrc(src, f.cipher.legacyFileHeader(nonce{})), options...)
 		if err == nil && o != nil {
 			o = f.newObject(o)
 		}
@@ -506,7 +743,11 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Encrypt the data into wrappedIn
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	wrappedIn, encrypter, err := f.encryptData(in, src)
+	if err != nil {
+		return nil, err
+	}
+	ctx, options, info, err := f.prepareMetadata(ctx, src, options, encrypter.header)
 	if err != nil {
 		return nil, err
 	}
@@ -533,7 +774,7 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 	}
 
 	// Transfer the data
-	o, err := put(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce), options...)
+	o, err := put(ctx, wrappedIn, info.withSrc(src, encrypter.header), options...)
 	if err != nil {
 		return nil, err
 	}
@@ -559,7 +800,47 @@ func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options [
 		}
 	}
 
-	return f.newObject(o), nil
+	obj := f.newObject(o)
+	obj.setHeader(encrypter.header)
+	obj.setMetadata(info.em)
+	return obj, nil
+}
+
+// prepareMetadata encrypts the metadata of src if encrypt_metadata is
+// set returning the ObjectInfo to upload along with the context and
+// options to pass to the underlying remote
+//
+// The metadata is bound to the file with header h.
+func (f *Fs) prepareMetadata(ctx context.Context, src fs.ObjectInfo, options []fs.OpenOption, h *fileHeader) (context.Context, []fs.OpenOption, *ObjectInfo, error) {
+	info := &ObjectInfo{f: f}
+	if !f.opt.EncryptMetadata {
+		return ctx, options, info, nil
+	}
+	if f.opt.Padding != paddingOff && src.Size() < 0 {
+		return nil, nil, nil, errors.New("can't upload files of unknown size with padding")
+	}
+	var err error
+	ctx, options, info.metadata, info.modTime, err = f.putMetadata(ctx, src, options, h)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	if f.opt.Padding != paddingOff {
+		size := src.Size()
+		info.em = &encryptedMetadata{Size: &size}
+		if h != nil {
+			info.em.Nonce = h.nonce[:]
+		}
+	}
+	return ctx, options, info, nil
+}
+
+// encryptData pads in if required then encrypts it
+func (f *Fs) encryptData(in io.Reader, src fs.ObjectInfo) (io.Reader, *encrypter, error) {
+	if f.opt.Padding != paddingOff {
+		size := src.Size()
+		in = newPadReader(in, size, f.paddedSize(size))
+	}
+	return f.cipher.encryptData(in)
 }
 
 // Put in to the remote path with the modTime given of the given size
@@ -594,10 +875,25 @@ func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata
 	if do == nil {
 		return nil, fs.ErrorNotImplemented
 	}
+	var mtime time.Time
+	if f.opt.EncryptMetadata && metadata != nil {
+		mtime, _ = mtimeFromMetadata(metadata)
+		value, err := f.cipher.encryptMetadata(&encryptedMetadata{Metadata: metadata})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+		metadata = fs.Metadata{metadataKey: value}
+	}
 	newDir, err := do(ctx, f.cipher.EncryptDirName(dir), metadata)
 	if err != nil {
 		return nil, err
 	}
+	if !mtime.IsZero() {
+		err = setModTime(ctx, newDir, mtime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set modification time: %w", err)
+		}
+	}
 	var entries = make(fs.DirEntries, 0, 1)
 	err = f.addDir(ctx, &entries, newDir)
 	if err != nil {
@@ -658,11 +954,25 @@ func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantCopy
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	doCtx, metadata, err := f.serverSideMetadata(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	oResult, err := do(doCtx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(oResult), nil
+	obj := f.newObject(oResult)
+	obj.copyHeader(o)
+	obj.copyMetadata(o)
+	if metadata != nil {
+		em, err := f.setEncryptedMetadata(ctx, oResult, metadata, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set metadata: %w", err)
+		}
+		obj.setMetadata(em)
+	}
+	return obj, nil
 }
 
 // Move src to this remote using server-side move operations.
@@ -683,11 +993,25 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if !ok {
 		return nil, fs.ErrorCantMove
 	}
-	oResult, err := do(ctx, o.Object, f.cipher.EncryptFileName(remote))
+	doCtx, metadata, err := f.serverSideMetadata(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	oResult, err := do(doCtx, o.Object, f.cipher.EncryptFileName(remote))
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(oResult), nil
+	obj := f.newObject(oResult)
+	obj.copyHeader(o)
+	obj.copyMetadata(o)
+	if metadata != nil {
+		em, err := f.setEncryptedMetadata(ctx, oResult, metadata, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set metadata: %w", err)
+		}
+		obj.setMetadata(em)
+	}
+	return obj, nil
 }
 
 // DirMove moves src, srcRemote to this remote at dstRemote
@@ -711,6 +1035,15 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 	return do(ctx, srcFs.Fs, f.cipher.EncryptDirName(srcRemote), f.cipher.EncryptDirName(dstRemote))
 }
 
+// PutIfNotExists uploads the object only if it doesn't already exist
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	do := f.Fs.Features().PutIfNotExists
+	if do == nil {
+		return nil, errors.New("can't PutIfNotExists")
+	}
+	return f.put(ctx, in, src, options, do)
+}
+
 // PutUnchecked uploads the object
 //
 // This will create a duplicate if we upload a new file without
@@ -720,15 +1053,22 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	if do == nil {
 		return nil, errors.New("can't PutUnchecked")
 	}
-	wrappedIn, encrypter, err := f.cipher.encryptData(in)
+	wrappedIn, encrypter, err := f.encryptData(in, src)
 	if err != nil {
 		return nil, err
 	}
-	o, err := do(ctx, wrappedIn, f.newObjectInfo(src, encrypter.nonce))
+	ctx, options, info, err := f.prepareMetadata(ctx, src, options, encrypter.header)
 	if err != nil {
 		return nil, err
 	}
-	return f.newObject(o), nil
+	o, err := do(ctx, wrappedIn, info.withSrc(src, encrypter.header), options...)
+	if err != nil {
+		return nil, err
+	}
+	obj := f.newObject(o)
+	obj.setHeader(encrypter.header)
+	obj.setMetadata(info.em)
+	return obj, nil
 }
 
 // CleanUp the trash in the Fs
@@ -777,11 +1117,12 @@ func (f *Fs) DecryptFileName(encryptedFileName string) (string, error) {
 	return f.cipher.DecryptFileName(encryptedFileName)
 }
 
-// computeHashWithNonce takes the nonce and encrypts the contents of
-// src with it, and calculates the hash given by HashType on the fly
+// computeHashWithHeader takes the file header and encrypts the
+// contents of src with its nonce and key, and calculates the hash
+// given by HashType on the fly
 //
 // Note that we break lots of encapsulation in this function.
-func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Object, hashType hash.Type) (hashStr string, err error) {
+func (f *Fs) computeHashWithHeader(ctx context.Context, header *fileHeader, src fs.Object, hashType hash.Type) (hashStr string, err error) {
 	// Open the src for input
 	in, err := src.Open(ctx)
 	if err != nil {
@@ -789,8 +1130,12 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	}
 	defer fs.CheckClose(in, &err)
 
-	// Now encrypt the src with the nonce
-	out, err := f.cipher.newEncrypter(in, &nonce)
+	// Now encrypt the src with the header
+	var padded io.Reader = in
+	if f.opt.Padding != paddingOff {
+		padded = newPadReader(in, src.Size(), f.paddedSize(src.Size()))
+	}
+	out, err := f.cipher.newEncrypter(padded, header)
 	if err != nil {
 		return "", fmt.Errorf("failed to make encrypter: %w", err)
 	}
@@ -808,7 +1153,7 @@ func (f *Fs) computeHashWithNonce(ctx context.Context, nonce nonce, src fs.Objec
 	return m.Sums()[hashType], nil
 }
 
-// ComputeHash takes the nonce from o, and encrypts the contents of
+// ComputeHash takes the header from o, and encrypts the contents of
 // src with it, and calculates the hash given by HashType on the fly
 //
 // Note that we break lots of encapsulation in this function.
@@ -819,7 +1164,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 
 	// Read the nonce - opening the file is sufficient to read the nonce in
 	// use a limited read so we only read the header
-	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(fileHeaderSize) - 1})
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(maxFileHeaderSize) - 1})
 	if err != nil {
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
@@ -828,7 +1173,8 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		_ = in.Close()
 		return "", fmt.Errorf("failed to open object to read nonce: %w", err)
 	}
-	nonce := d.nonce
+	header := d.header
+	nonce := header.nonce
 	// fs.Debugf(o, "Read nonce % 2x", nonce)
 
 	// Check nonce isn't all zeros
@@ -848,7 +1194,7 @@ func (f *Fs) ComputeHash(ctx context.Context, o *Object, src fs.Object, hashType
 		return "", fmt.Errorf("failed to close nonce read: %w", err)
 	}
 
-	return f.computeHashWithNonce(ctx, nonce, src, hashType)
+	return f.computeHashWithHeader(ctx, header, src, hashType)
 }
 
 // MergeDirs merges the contents of all the directories passed
@@ -944,6 +1290,67 @@ Usage Example:
 
     rclone backend decode crypt: encryptedfile1 [encryptedfile2...]
     rclone rc backend/command command=decode fs=crypt: encryptedfile1 [encryptedfile2...]
+`,
+	},
+	{
+		Name:  "rekey",
+		Short: "Re-encrypt the file keys with a new master key",
+		Long: `This needs per_file_keys or public_key to be set. It re-encrypts the
+key of each file with the current master key, changing the master key
+first if a new master password is passed with the password option.
+
+If public_key is set the file keys are encrypted to it instead. This
+converts files written before public_key was set. It can be run
+without private_key as long as the files being converted weren't
+encrypted to a different public key.
+
+When the master password is changed the new one is saved in the config
+as master_password and the previous one is added to
+old_master_passwords so files can still be read while they are being
+rekeyed. If rekey is interrupted run it again without the password
+option to finish rekeying. Once it has finished on the whole remote
+without errors, remove old_master_passwords from the config.
+
+Only the header of each file changes. The file data isn't decrypted
+and the file names aren't changed, but most remotes can't change part
+of a file so each file is downloaded and uploaded again.
+
+Files written without per_file_keys are converted to have their keys
+in the header too. They keep the key derived from password so they
+need to be uploaded again to protect them from a leaked password.
+
+It returns the number of files rekeyed, already up to date and which
+failed.
+
+Usage Example:
+
+    rclone backend rekey crypt: -o password=newpassword
+    rclone rc backend/command command=rekey fs=crypt: -o password=newpassword
+`,
+		Opts: map[string]string{
+			"password": "New master password to encrypt the file keys with",
+		},
+	},
+	{
+		Name:  "keygen",
+		Short: "Make a key pair for public_key and private_key",
+		Long: `This makes a new X25519 key pair in age format for use with the
+public_key and private_key options. The config isn't changed.
+
+Usage Example:
+
+    rclone backend keygen crypt:
+    rclone rc backend/command command=keygen fs=crypt:
+
+The output is like this:
+
+    {
+        "public_key": "age1...",
+        "private_key": "AGE-SECRET-KEY-1..."
+    }
+
+Keep the private key safe - without it files written with the public
+key can't be read.
 `,
 	},
 }
@@ -976,6 +1383,17 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			out = append(out, encryptedFileName)
 		}
 		return out, nil
+	case "rekey":
+		return f.rekey(ctx, opt)
+	case "keygen":
+		publicKey, privateKey, err := newKeyPair(f.cipher.cryptoRand)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make key pair: %w", err)
+		}
+		return map[string]string{
+			"public_key":  publicKey,
+			"private_key": privateKey,
+		}, nil
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -986,7 +1404,11 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 // This decrypts the remote name and decrypts the data
 type Object struct {
 	fs.Object
-	f *Fs
+	f           *Fs
+	meta        objectMetadata // encrypted metadata if padding
+	headerMu    sync.Mutex     // protects headerSize and headerNonce
+	headerSize  int            // size of the file header, 0 if not known or -1 if unreadable
+	headerNonce nonce          // nonce in the file header if headerSize is set
 }
 
 func (f *Fs) newObject(o fs.Object) *Object {
@@ -1020,19 +1442,134 @@ func (o *Object) Remote() string {
 	return decryptedName
 }
 
-// Size returns the size of the file
+// Size returns the size of the file or -1 if it can't be worked out
 func (o *Object) Size() int64 {
+	if o.f.opt.Padding != paddingOff {
+		size, err := o.unpaddedSize()
+		if err != nil {
+			fs.Debugf(o, "Bad size in metadata: %v", err)
+			return -1
+		} else if size >= 0 {
+			return size
+		}
+	}
 	size := o.Object.Size()
 	if !o.f.opt.NoDataEncryption {
 		var err error
-		size, err = o.f.cipher.DecryptedSize(size)
+		size, err = o.decryptedSize()
 		if err != nil {
 			fs.Debugf(o, "Bad size for decrypt: %v", err)
+			return -1
 		}
 	}
 	return size
 }
 
+// decryptedSize returns the size of the data in the encrypted object
+//
+// This uses the size of the header read by readSize or written to the
+// object if known, otherwise the size of the headers being written.
+func (o *Object) decryptedSize() (int64, error) {
+	size := o.Object.Size()
+	if size < 0 {
+		return -1, nil
+	}
+	o.headerMu.Lock()
+	headerSize := o.headerSize
+	o.headerMu.Unlock()
+	switch {
+	case headerSize < 0:
+		return -1, errors.New("file header couldn't be read")
+	case headerSize == 0:
+		return o.f.cipher.DecryptedSize(size)
+	}
+	return decryptedSize(headerSize, size)
+}
+
+// readSize reads the header at the start of the object if the files
+// may have headers of different sizes and the encrypted metadata with
+// the size before padding if padding is set
+//
+// If these can't be decoded the size of the object will be unknown.
+func (o *Object) readSize(ctx context.Context) error {
+	if !o.f.opt.NoDataEncryption && o.f.cipher.headersMayDiffer() {
+		_, _, err := o.readHeader(ctx)
+		if errors.Is(err, ErrorEncryptedFileTooShort) || errors.Is(err, ErrorEncryptedBadMagic) {
+			fs.Errorf(o, "Can't work out size: %v", err)
+			o.headerMu.Lock()
+			o.headerSize = -1
+			o.headerMu.Unlock()
+		} else if err != nil {
+			return err
+		}
+	}
+	if o.f.opt.Padding != paddingOff {
+		return o.readPaddedMetadata(ctx)
+	}
+	return nil
+}
+
+// readHeader reads the size of the file header and the nonce in it
+// from the start of the object, remembering them for next time
+func (o *Object) readHeader(ctx context.Context) (headerSize int, n nonce, err error) {
+	o.headerMu.Lock()
+	defer o.headerMu.Unlock()
+	if o.headerSize > 0 {
+		return o.headerSize, o.headerNonce, nil
+	}
+	if size := o.Object.Size(); size >= 0 && size < int64(fileHeaderSize) {
+		return 0, n, ErrorEncryptedFileTooShort
+	}
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(maxFileHeaderSize) - 1})
+	if err != nil {
+		return 0, n, fmt.Errorf("failed to open to read header: %w", err)
+	}
+	raw, err := io.ReadAll(io.LimitReader(in, int64(maxFileHeaderSize)))
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, n, fmt.Errorf("failed to read header: %w", err)
+	}
+	if len(raw) < fileMagicSize {
+		return 0, n, ErrorEncryptedFileTooShort
+	}
+	headerSize, err = headerSizeFromMagic(raw[:fileMagicSize])
+	if err != nil {
+		return 0, n, err
+	}
+	if len(raw) < headerSize {
+		return 0, n, ErrorEncryptedFileTooShort
+	}
+	// The nonce is at the end of every version of the header
+	n.fromBuf(raw[headerSize-fileNonceSize : headerSize])
+	o.headerSize, o.headerNonce = headerSize, n
+	return headerSize, n, nil
+}
+
+// copyHeader records that the object has the same header as src
+func (o *Object) copyHeader(src *Object) {
+	src.headerMu.Lock()
+	headerSize, headerNonce := src.headerSize, src.headerNonce
+	src.headerMu.Unlock()
+	o.headerMu.Lock()
+	o.headerSize, o.headerNonce = headerSize, headerNonce
+	o.headerMu.Unlock()
+}
+
+// setHeader records that the object has just been written or read
+// with h, or that its header isn't known if h is nil
+func (o *Object) setHeader(h *fileHeader) {
+	o.headerMu.Lock()
+	defer o.headerMu.Unlock()
+	if h == nil || o.f.opt.NoDataEncryption {
+		o.headerSize = 0
+		return
+	}
+	o.headerSize, o.headerNonce = len(h.raw), h.nonce
+}
+
 // Hash returns the selected checksum of the file
 // If no checksum is available it returns ""
 func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
@@ -1063,7 +1600,14 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 			openOptions = append(openOptions, option)
 		}
 	}
-	rc, err = o.f.cipher.DecryptDataSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
+	if o.f.opt.Padding != paddingOff {
+		// Don't read the padding
+		size := o.Size()
+		if limit < 0 || offset+limit > size {
+			limit = max(size-offset, 0)
+		}
+	}
+	fh, err := o.f.cipher.newDecrypterSeek(ctx, func(ctx context.Context, underlyingOffset, underlyingLimit int64) (io.ReadCloser, error) {
 		if underlyingOffset == 0 && underlyingLimit < 0 {
 			// Open with no seek
 			return o.Object.Open(ctx, openOptions...)
@@ -1082,15 +1626,38 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	if err != nil {
 		return nil, err
 	}
-	return rc, nil
+	o.setHeader(fh.header)
+	if o.f.opt.Padding != paddingOff {
+		// The size came from the metadata so check it is this file's
+		o.meta.mu.Lock()
+		em := o.meta.em
+		o.meta.mu.Unlock()
+		if em != nil && !bytes.Equal(em.Nonce, fh.header.nonce[:]) {
+			_ = fh.Close()
+			return nil, ErrorEncryptedMetadataWrongFile
+		}
+	}
+	return fh, nil
 }
 
 // Update in to the object with the modTime given of the given size
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	var (
+		header *fileHeader
+		em     *encryptedMetadata
+	)
 	update := func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+		if info, ok := src.(*ObjectInfo); ok {
+			header, em = info.header, info.em
+		}
 		return o.Object, o.Object.Update(ctx, in, src, options...)
 	}
 	_, err := o.f.put(ctx, in, src, options, update)
+	if err != nil {
+		header, em = nil, nil
+	}
+	o.setHeader(header)
+	o.setMetadata(em)
 	return err
 }
 
@@ -1104,6 +1671,9 @@ func (f *Fs) newDir(ctx context.Context, dir fs.Directory) fs.Directory {
 		remote = decryptedRemote
 	}
 	newDir := fs.NewDirWrapper(remote, dir)
+	if f.opt.EncryptMetadata {
+		return &Directory{DirWrapper: newDir, f: f}
+	}
 	return newDir
 }
 
@@ -1140,18 +1710,29 @@ func (f *Fs) Shutdown(ctx context.Context) error {
 // This encrypts the remote name and adjusts the size
 type ObjectInfo struct {
 	fs.ObjectInfo
-	f     *Fs
-	nonce nonce
+	f        *Fs
+	header   *fileHeader
+	metadata fs.Metadata        // encrypted metadata if encrypt_metadata is set
+	modTime  time.Time          // modification time from the metadata if set
+	em       *encryptedMetadata // size and nonce in the metadata if padding
 }
 
-func (f *Fs) newObjectInfo(src fs.ObjectInfo, nonce nonce) *ObjectInfo {
+func (f *Fs) newObjectInfo(src fs.ObjectInfo, header *fileHeader) *ObjectInfo {
 	return &ObjectInfo{
 		ObjectInfo: src,
 		f:          f,
-		nonce:      nonce,
+		header:     header,
 	}
 }
 
+// withSrc makes a copy of o with src and header filled in
+func (o *ObjectInfo) withSrc(src fs.ObjectInfo, header *fileHeader) *ObjectInfo {
+	newO := *o
+	newO.ObjectInfo = src
+	newO.header = header
+	return &newO
+}
+
 // Fs returns read only access to the Fs that this object is part of
 func (o *ObjectInfo) Fs() fs.Info {
 	return o.f
@@ -1162,6 +1743,16 @@ func (o *ObjectInfo) Remote() string {
 	return o.f.cipher.EncryptFileName(o.ObjectInfo.Remote())
 }
 
+// ModTime returns the modification time of the file
+//
+// This is the mtime from the metadata if it was encrypted.
+func (o *ObjectInfo) ModTime(ctx context.Context) time.Time {
+	if !o.modTime.IsZero() {
+		return o.modTime
+	}
+	return o.ObjectInfo.ModTime(ctx)
+}
+
 // Size returns the size of the file
 func (o *ObjectInfo) Size() int64 {
 	size := o.ObjectInfo.Size()
@@ -1171,7 +1762,7 @@ func (o *ObjectInfo) Size() int64 {
 	if o.f.opt.NoDataEncryption {
 		return size
 	}
-	return o.f.cipher.EncryptedSize(size)
+	return o.f.cipher.EncryptedSize(o.f.paddedSize(size))
 }
 
 // Hash returns the selected checksum of the file
@@ -1193,7 +1784,7 @@ func (o *ObjectInfo) Hash(ctx context.Context, hash hash.Type) (string, error) {
 	if srcObj.Fs().Features().IsLocal {
 		// Read the data and encrypt it to calculate the hash
 		fs.Debugf(o, "Computing %v hash of encrypted source", hash)
-		return o.f.computeHashWithNonce(ctx, o.nonce, srcObj, hash)
+		return o.f.computeHashWithHeader(ctx, o.header, srcObj, hash)
 	}
 	return "", nil
 }
@@ -1220,6 +1811,9 @@ func (o *ObjectInfo) ID() string {
 //
 // It should return nil if there is no Metadata
 func (o *ObjectInfo) Metadata(ctx context.Context) (fs.Metadata, error) {
+	if o.f.opt.EncryptMetadata {
+		return o.metadata, nil
+	}
 	do, ok := o.ObjectInfo.(fs.Metadataer)
 	if !ok {
 		return nil, nil
@@ -1274,6 +1868,9 @@ func (o *Object) GetTier() string {
 //
 // It should return nil if there is no Metadata
 func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	if o.f.opt.EncryptMetadata {
+		return o.f.encryptedMetadataOf(ctx, o.Object, o)
+	}
 	do, ok := o.Object.(fs.Metadataer)
 	if !ok {
 		return nil, nil
@@ -1285,6 +1882,14 @@ func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
 //
 // It should return fs.ErrorNotImplemented if it can't set metadata
 func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	if o.f.opt.EncryptMetadata {
+		em, err := o.f.setEncryptedMetadata(ctx, o.Object, metadata, o)
+		if err != nil {
+			return err
+		}
+		o.setMetadata(em)
+		return nil
+	}
 	do, ok := o.Object.(fs.SetMetadataer)
 	if !ok {
 		return fs.ErrorNotImplemented
@@ -1303,28 +1908,29 @@ func (o *Object) MimeType(ctx context.Context) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = (*Fs)(nil)
-	_ fs.Purger          = (*Fs)(nil)
-	_ fs.Copier          = (*Fs)(nil)
-	_ fs.Mover           = (*Fs)(nil)
-	_ fs.DirMover        = (*Fs)(nil)
-	_ fs.Commander       = (*Fs)(nil)
-	_ fs.PutUncheckeder  = (*Fs)(nil)
-	_ fs.PutStreamer     = (*Fs)(nil)
-	_ fs.CleanUpper      = (*Fs)(nil)
-	_ fs.UnWrapper       = (*Fs)(nil)
-	_ fs.ListRer         = (*Fs)(nil)
-	_ fs.Abouter         = (*Fs)(nil)
-	_ fs.Wrapper         = (*Fs)(nil)
-	_ fs.MergeDirser     = (*Fs)(nil)
-	_ fs.DirSetModTimer  = (*Fs)(nil)
-	_ fs.MkdirMetadataer = (*Fs)(nil)
-	_ fs.DirCacheFlusher = (*Fs)(nil)
-	_ fs.ChangeNotifier  = (*Fs)(nil)
-	_ fs.PublicLinker    = (*Fs)(nil)
-	_ fs.UserInfoer      = (*Fs)(nil)
-	_ fs.Disconnecter    = (*Fs)(nil)
-	_ fs.Shutdowner      = (*Fs)(nil)
-	_ fs.FullObjectInfo  = (*ObjectInfo)(nil)
-	_ fs.FullObject      = (*Object)(nil)
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.Commander        = (*Fs)(nil)
+	_ fs.PutUncheckeder   = (*Fs)(nil)
+	_ fs.PutIfNotExistser = (*Fs)(nil)
+	_ fs.PutStreamer      = (*Fs)(nil)
+	_ fs.CleanUpper       = (*Fs)(nil)
+	_ fs.UnWrapper        = (*Fs)(nil)
+	_ fs.ListRer          = (*Fs)(nil)
+	_ fs.Abouter          = (*Fs)(nil)
+	_ fs.Wrapper          = (*Fs)(nil)
+	_ fs.MergeDirser      = (*Fs)(nil)
+	_ fs.DirSetModTimer   = (*Fs)(nil)
+	_ fs.MkdirMetadataer  = (*Fs)(nil)
+	_ fs.DirCacheFlusher  = (*Fs)(nil)
+	_ fs.ChangeNotifier   = (*Fs)(nil)
+	_ fs.PublicLinker     = (*Fs)(nil)
+	_ fs.UserInfoer       = (*Fs)(nil)
+	_ fs.Disconnecter     = (*Fs)(nil)
+	_ fs.Shutdowner       = (*Fs)(nil)
+	_ fs.FullObjectInfo   = (*ObjectInfo)(nil)
+	_ fs.FullObject       = (*Object)(nil)
 )
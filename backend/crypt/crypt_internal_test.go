@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
+	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/lib/random"
@@ -57,11 +62,12 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 	obj := uploadFile(t, localFs, path, contents)
 
 	// encrypt the data
-	inBuf := bytes.NewBufferString(contents)
+	size := int64(len(contents))
+	inBuf := newPadReader(bytes.NewBufferString(contents), size, f.paddedSize(size))
 	var outBuf bytes.Buffer
 	enc, err := f.cipher.newEncrypter(inBuf, nil)
 	require.NoError(t, err)
-	nonce := enc.nonce // read the nonce at the start
+	header := enc.header // read the header at the start
 	_, err = io.Copy(&outBuf, enc)
 	require.NoError(t, err)
 
@@ -71,9 +77,9 @@ func testObjectInfo(t *testing.T, f *Fs, wrap bool) {
 		oi = fs.NewOverrideRemote(oi, "new_remote")
 	}
 
-	// wrap the object in a crypt for upload using the nonce we
+	// wrap the object in a crypt for upload using the header we
 	// saved from the encrypter
-	src := f.newObjectInfo(oi, nonce)
+	src := f.newObjectInfo(oi, header)
 
 	// Test ObjectInfo methods
 	if !f.opt.NoDataEncryption {
@@ -125,3 +131,333 @@ func (f *Fs) InternalTest(t *testing.T) {
 	t.Run("ObjectInfoWrap", func(t *testing.T) { testObjectInfo(t, f, true) })
 	t.Run("ComputeHash", func(t *testing.T) { testComputeHash(t, f) })
 }
+
+// newRekeyFs makes a crypt remote of dir with the config in m
+func newRekeyFs(t *testing.T, m configmap.Simple) *Fs {
+	f, err := NewFs(context.Background(), "TestRekey", "", m)
+	require.NoError(t, err)
+	return f.(*Fs)
+}
+
+// readFile reads the contents of remote on f
+func readFile(t *testing.T, f fs.Fs, remote string) (string, error) {
+	o, err := f.NewObject(context.Background(), remote)
+	require.NoError(t, err)
+	in, err := o.Open(context.Background())
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(in)
+	require.NoError(t, in.Close())
+	return string(data), err
+}
+
+func TestRekey(t *testing.T) {
+	ctx := context.Background()
+	m := configmap.Simple{
+		"remote":                    t.TempDir(),
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"filename_encoding":         "base32",
+		"directory_name_encryption": "true",
+		"suffix":                    ".bin",
+		"padding":                   "off",
+	}
+	legacy := newRekeyFs(t, m)
+	_, err := legacy.Command(ctx, "rekey", nil, nil)
+	assert.ErrorContains(t, err, "per_file_keys")
+	o := uploadFile(t, legacy, "dir/legacy.txt", "legacy")
+	modTime := o.ModTime(ctx)
+
+	// Convert the legacy files
+	m["per_file_keys"] = "true"
+	f := newRekeyFs(t, m)
+	out, err := f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rekeyStats{Rekeyed: 1}, out)
+	o, err = f.NewObject(ctx, "dir/legacy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("legacy")), o.Size())
+	assert.True(t, modTime.Equal(o.ModTime(ctx)))
+	data, err := readFile(t, f, "dir/legacy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", data)
+	uploadFile(t, f, "new.txt", "new")
+
+	// Nothing to do the second time
+	out, err = f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rekeyStats{Unchanged: 2}, out)
+
+	// Change the master password twice
+	for _, password := range []string{"first", "second"} {
+		out, err = f.Command(ctx, "rekey", nil, map[string]string{"password": password})
+		require.NoError(t, err)
+		assert.Equal(t, rekeyStats{Rekeyed: 2}, out)
+		revealed, err := obscure.Reveal(m["master_password"])
+		require.NoError(t, err)
+		assert.Equal(t, password, revealed)
+	}
+	var old fs.CommaSepList
+	require.NoError(t, old.Set(m["old_master_passwords"]))
+	require.Len(t, old, 1)
+	revealed, err := obscure.Reveal(old[0])
+	require.NoError(t, err)
+	assert.Equal(t, "first", revealed)
+
+	// The files can be read with just the new master password
+	delete(m, "old_master_passwords")
+	f = newRekeyFs(t, m)
+	for remote, want := range map[string]string{"dir/legacy.txt": "legacy", "new.txt": "new"} {
+		data, err = readFile(t, f, remote)
+		require.NoError(t, err)
+		assert.Equal(t, want, data)
+	}
+
+	// But not with the old one
+	m["master_password"] = obscure.MustObscure("first")
+	f = newRekeyFs(t, m)
+	_, err = readFile(t, f, "new.txt")
+	assert.ErrorIs(t, err, ErrorEncryptedUnknownKey)
+}
+
+func TestRekeyPublicKey(t *testing.T) {
+	ctx := context.Background()
+	m := configmap.Simple{
+		"remote":                    t.TempDir(),
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"filename_encoding":         "base32",
+		"directory_name_encryption": "true",
+		"suffix":                    ".bin",
+		"padding":                   "off",
+	}
+	legacy := newRekeyFs(t, m)
+	uploadFile(t, legacy, "legacy.txt", "legacy")
+	out, err := legacy.Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	keys := out.(map[string]string)
+
+	// A remote with just the public key converts the files and
+	// writes new ones but can't read them
+	m["public_key"] = keys["public_key"]
+	f := newRekeyFs(t, m)
+	out, err = f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rekeyStats{Rekeyed: 1}, out)
+	uploadFile(t, f, "new.txt", "new")
+	_, err = readFile(t, f, "legacy.txt")
+	assert.ErrorIs(t, err, ErrorEncryptedNoPrivateKey)
+	out, err = f.Command(ctx, "rekey", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rekeyStats{Unchanged: 2}, out)
+
+	// The private key reads them
+	m["private_key"] = keys["private_key"]
+	f = newRekeyFs(t, m)
+	for remote, want := range map[string]string{"legacy.txt": "legacy", "new.txt": "new"} {
+		data, err := readFile(t, f, remote)
+		require.NoError(t, err)
+		assert.Equal(t, want, data)
+	}
+}
+
+func TestMixedHeaderSizes(t *testing.T) {
+	ctx := context.Background()
+	m := configmap.Simple{
+		"remote":                    t.TempDir(),
+		"password":                  obscure.MustObscure("potato"),
+		"filename_encryption":       "standard",
+		"filename_encoding":         "base32",
+		"directory_name_encryption": "true",
+		"suffix":                    ".bin",
+		"padding":                   "off",
+	}
+	want := map[string]string{}
+	upload := func(f fs.Fs, remote, contents string) {
+		uploadFile(t, f, remote, contents)
+		want[remote] = contents
+	}
+
+	// Write files with each header version
+	legacy := newRekeyFs(t, m)
+	upload(legacy, "legacy-small.txt", "a")
+	upload(legacy, "legacy-big.txt", random.String(100*1024))
+	out, err := legacy.Command(ctx, "keygen", nil, nil)
+	require.NoError(t, err)
+	keys := out.(map[string]string)
+	m["per_file_keys"] = "true"
+	upload(newRekeyFs(t, m), "v2-small.txt", "b")
+	upload(newRekeyFs(t, m), "v2-big.txt", random.String(70*1024))
+	m["public_key"] = keys["public_key"]
+	m["private_key"] = keys["private_key"]
+	f := newRekeyFs(t, m)
+	upload(f, "v3-small.txt", "c")
+
+	// Check they all have the right size and can be read
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, len(want))
+	for _, entry := range entries {
+		o := entry.(fs.Object)
+		contents := want[o.Remote()]
+		assert.Equal(t, int64(len(contents)), o.Size(), o.Remote())
+		data, err := readFile(t, f, o.Remote())
+		require.NoError(t, err)
+		assert.Equal(t, contents, data, o.Remote())
+		in, err := o.Open(ctx, &fs.RangeOption{Start: int64(len(contents)) - 1, End: -1})
+		require.NoError(t, err)
+		data2, err := io.ReadAll(in)
+		require.NoError(t, err)
+		require.NoError(t, in.Close())
+		assert.Equal(t, contents[len(contents)-1:], string(data2), o.Remote())
+	}
+
+	// A file which isn't encrypted has an unknown size
+	uploadFile(t, f.Fs, f.cipher.EncryptFileName("bad.txt"), "potato")
+	o, err := f.NewObject(ctx, "bad.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), o.Size())
+	entries, err = f.List(ctx, "")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.Remote() == "bad.txt" {
+			assert.Equal(t, int64(-1), entry.Size())
+		}
+	}
+}
+
+func TestEncryptMetadataUnderlying(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.Metadata = true
+	m := configmap.Simple{
+		"remote":              t.TempDir(),
+		"password":            obscure.MustObscure("potato"),
+		"filename_encryption": "standard",
+		"filename_encoding":   "base32",
+		"suffix":              ".bin",
+		"padding":             "padme",
+	}
+	_, err := NewFs(ctx, "TestEncryptMetadata", "", m)
+	assert.ErrorContains(t, err, "encrypt_metadata")
+	m["encrypt_metadata"] = "true"
+	f := newRekeyFs(t, m)
+
+	contents := random.String(1000)
+	src := object.NewStaticObjectInfo("file.txt", time.Now(), int64(len(contents)), true, nil, nil)
+	src = src.WithMetadata(fs.Metadata{"potato": "jersey"})
+	o, err := f.Put(ctx, bytes.NewBufferString(contents), src)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+
+	// The underlying object has padded data and encrypted metadata
+	underlying := o.(*Object).Object
+	assert.Equal(t, f.cipher.EncryptedSize(1024), underlying.Size())
+	meta, err := fs.GetMetadata(ctx, underlying)
+	require.NoError(t, err)
+	assert.Contains(t, meta, metadataKey)
+	assert.NotContains(t, meta, "potato")
+	for _, value := range meta {
+		assert.NotContains(t, value, "jersey")
+	}
+
+	// The crypt object has the real size, data and metadata
+	o, err = f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), o.Size())
+	data, err := readFile(t, f, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, contents, data)
+	meta, err = fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, "jersey", meta["potato"])
+
+	// Ranges don't read the padding
+	in, err := o.Open(ctx, &fs.RangeOption{Start: 990, End: -1})
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, contents[990:], string(got))
+
+	// Updating the metadata keeps it encrypted
+	require.NoError(t, o.(fs.SetMetadataer).SetMetadata(ctx, fs.Metadata{"potato": "royal"}))
+	meta, err = fs.GetMetadata(ctx, underlying)
+	require.NoError(t, err)
+	assert.NotContains(t, meta, "potato")
+	meta, err = fs.GetMetadata(ctx, o)
+	require.NoError(t, err)
+	assert.Equal(t, "royal", meta["potato"])
+
+	// Metadata copied to another file isn't accepted
+	src = object.NewStaticObjectInfo("other.txt", time.Now(), int64(len(contents)), true, nil, nil)
+	other, err := f.Put(ctx, bytes.NewBufferString(random.String(len(contents))), src)
+	require.NoError(t, err)
+	meta, err = fs.GetMetadata(ctx, underlying)
+	require.NoError(t, err)
+	require.NoError(t, other.(*Object).Object.(fs.SetMetadataer).SetMetadata(ctx, fs.Metadata{metadataKey: meta[metadataKey]}))
+	other, err = f.NewObject(ctx, "other.txt")
+	require.NoError(t, err)
+	_, err = fs.GetMetadata(ctx, other)
+	assert.ErrorIs(t, err, ErrorEncryptedMetadataWrongFile)
+	_, err = other.Open(ctx)
+	assert.ErrorIs(t, err, ErrorEncryptedMetadataWrongFile)
+
+	// The size is read when the object is found, not by Size
+	o, err = f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	require.NoError(t, underlying.(fs.SetMetadataer).SetMetadata(ctx, fs.Metadata{metadataKey: "potato"}))
+	assert.Equal(t, int64(len(contents)), o.Size())
+
+	// It is unknown if the metadata can't be decrypted
+	o, err = f.NewObject(ctx, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), o.Size())
+}
+
+// failMoveFs fails to move the rekeyed files into place
+type failMoveFs struct {
+	fs.Fs
+}
+
+// Features returns the features with Move replaced
+func (f *failMoveFs) Features() *fs.Features {
+	features := *f.Fs.Features()
+	features.Move = func(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+		if strings.HasSuffix(src.Remote(), rekeyTempSuffix) {
+			return nil, errors.New("move failed")
+		}
+		return f.Fs.Features().Move(ctx, src, remote)
+	}
+	return &features
+}
+
+func TestRekeyMoveFails(t *testing.T) {
+	ctx := context.Background()
+	m := configmap.Simple{
+		"remote":              t.TempDir(),
+		"password":            obscure.MustObscure("potato"),
+		"filename_encryption": "standard",
+		"filename_encoding":   "base32",
+		"suffix":              ".bin",
+		"padding":             "off",
+	}
+	uploadFile(t, newRekeyFs(t, m), "legacy.txt", "legacy")
+
+	// The original is kept if the rekeyed file can't replace it
+	m["per_file_keys"] = "true"
+	f := newRekeyFs(t, m)
+	underlying := f.Fs
+	f.Fs = &failMoveFs{Fs: underlying}
+	out, err := f.Command(ctx, "rekey", nil, nil)
+	require.Error(t, err)
+	assert.Equal(t, rekeyStats{Errors: 1}, out)
+	f.Fs = underlying
+	data, err := readFile(t, f, "legacy.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", data)
+	entries, err := underlying.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
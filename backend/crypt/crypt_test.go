@@ -169,3 +169,75 @@ func TestNoDataObfuscate(t *testing.T) {
 		QuickTestOK:                  true,
 	})
 }
+
+// TestPerFileKeys runs integration tests against the remote
+func TestPerFileKeys(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-crypt-test-per-file-keys")
+	name := "TestCrypt5"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*crypt.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "crypt"},
+			{Name: name, Key: "remote", Value: tempdir},
+			{Name: name, Key: "password", Value: obscure.MustObscure("potato2")},
+			{Name: name, Key: "filename_encryption", Value: "standard"},
+			{Name: name, Key: "per_file_keys", Value: "true"},
+			{Name: name, Key: "master_password", Value: obscure.MustObscure("master")},
+		},
+		UnimplementableFsMethods:     []string{"OpenWriterAt", "OpenChunkWriter"},
+		UnimplementableObjectMethods: []string{"MimeType"},
+		QuickTestOK:                  true,
+	})
+}
+
+// TestPublicKey runs integration tests against the remote
+func TestPublicKey(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-crypt-test-public-key")
+	name := "TestCrypt6"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*crypt.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "crypt"},
+			{Name: name, Key: "remote", Value: tempdir},
+			{Name: name, Key: "password", Value: obscure.MustObscure("potato2")},
+			{Name: name, Key: "filename_encryption", Value: "standard"},
+			{Name: name, Key: "public_key", Value: "age18qfwl0jje76dxd653ql602t3vu028w3px0xxxmza28tukk7dlqws8rnles"},
+			{Name: name, Key: "private_key", Value: "AGE-SECRET-KEY-14AWEDF9ESFZMREZD7RSM52DLZP8EW3HZATL7JTK4YCDMRE23WUQQ99SSME"},
+		},
+		UnimplementableFsMethods:     []string{"OpenWriterAt", "OpenChunkWriter"},
+		UnimplementableObjectMethods: []string{"MimeType"},
+		QuickTestOK:                  true,
+	})
+}
+
+// TestEncryptMetadata runs integration tests against the remote
+func TestEncryptMetadata(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-crypt-test-encrypt-metadata")
+	name := "TestCrypt7"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*crypt.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "crypt"},
+			{Name: name, Key: "remote", Value: tempdir},
+			{Name: name, Key: "password", Value: obscure.MustObscure("potato2")},
+			{Name: name, Key: "filename_encryption", Value: "standard"},
+			{Name: name, Key: "encrypt_metadata", Value: "true"},
+			{Name: name, Key: "padding", Value: "padme"},
+		},
+		UnimplementableFsMethods:     []string{"OpenWriterAt", "OpenChunkWriter", "PutStream"},
+		UnimplementableObjectMethods: []string{"MimeType"},
+		QuickTestOK:                  true,
+	})
+}
@@ -0,0 +1,268 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rclone/rclone/lib/readers"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Constants for the version 2 file header
+//
+// This is the magic, the ID of the master key, the file key encrypted
+// with the master key and the nonce of the first block.
+const (
+	fileMagicV2       = "RCLONE\x00\x02"
+	keyIDSize         = 8
+	fileKeySize       = 32
+	wrappedKeySize    = fileNonceSize + secretbox.Overhead + fileKeySize
+	fileHeaderSizeV2  = fileMagicSize + keyIDSize + wrappedKeySize + fileNonceSize
+	maxFileHeaderSize = fileHeaderSizeV3
+)
+
+// Errors returned reading file keys
+var (
+	ErrorEncryptedUnknownKey = errors.New("file key encrypted with an unknown master key - check master_password and old_master_passwords")
+	ErrorEncryptedBadKey     = errors.New("failed to authenticate file key - bad master password?")
+)
+
+var fileMagicV2Bytes = []byte(fileMagicV2)
+
+// keyID identifies a master key without revealing it
+type keyID [keyIDSize]byte
+
+// masterKey encrypts the per file keys
+type masterKey struct {
+	id  keyID
+	key [32]byte
+}
+
+// newMasterKey makes a master key from the secret passed in
+//
+// The key and its ID are derived separately from secret so the ID
+// reveals nothing about the key.
+func newMasterKey(secret []byte) (mk masterKey) {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte("rclone crypt master key"))
+	copy(mk.key[:], mac.Sum(nil))
+	mac = hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte("rclone crypt master key id"))
+	copy(mk.id[:], mac.Sum(nil))
+	return mk
+}
+
+// deriveMasterKey makes a master key from a password using scrypt
+func (c *Cipher) deriveMasterKey(password string) (mk masterKey, err error) {
+	secret, err := scrypt.Key([]byte(password), c.salt, 16384, 8, 1, 32)
+	if err != nil {
+		return mk, err
+	}
+	return newMasterKey(secret), nil
+}
+
+// setMasterKeys sets the keys used to encrypt the per file keys
+//
+// Files are written with the key derived from password, or from the
+// data key if password is empty. The keys derived from oldPasswords
+// and the data key can be used to read files.
+func (c *Cipher) setMasterKeys(password string, oldPasswords []string) error {
+	dataMasterKey := newMasterKey(c.dataKey[:])
+	c.masterKeys = c.masterKeys[:0]
+	if password != "" {
+		mk, err := c.deriveMasterKey(password)
+		if err != nil {
+			return err
+		}
+		c.masterKeys = append(c.masterKeys, mk)
+	}
+	for _, oldPassword := range oldPasswords {
+		mk, err := c.deriveMasterKey(oldPassword)
+		if err != nil {
+			return err
+		}
+		c.masterKeys = append(c.masterKeys, mk)
+	}
+	c.masterKeys = append(c.masterKeys, dataMasterKey)
+	return nil
+}
+
+// setPerFileKeys sets whether files are written with their own key
+func (c *Cipher) setPerFileKeys(perFileKeys bool) {
+	c.perFileKeys = perFileKeys
+	c.setHeaderSize()
+}
+
+// setHeaderSize sets the size of the headers written from the config
+func (c *Cipher) setHeaderSize() {
+	switch {
+	case c.publicKey != nil:
+		c.headerSize = fileHeaderSizeV3
+	case c.perFileKeys:
+		c.headerSize = fileHeaderSizeV2
+	default:
+		c.headerSize = fileHeaderSize
+	}
+}
+
+// findMasterKey returns the master key with id or nil if not found
+func (c *Cipher) findMasterKey(id keyID) *masterKey {
+	for i := range c.masterKeys {
+		if c.masterKeys[i].id == id {
+			return &c.masterKeys[i]
+		}
+	}
+	return nil
+}
+
+// fileHeader is the header at the start of an encrypted file
+type fileHeader struct {
+	raw     []byte   // the header as stored in the file
+	nonce   nonce    // nonce of the first block
+	key     [32]byte // key the blocks are encrypted with
+	keyID   keyID    // ID of the master or public key
+	version byte     // version of the header - 1, 2 or 3
+}
+
+// legacyFileHeader makes an original format header which encrypts the
+// blocks with the data key
+func (c *Cipher) legacyFileHeader(n nonce) *fileHeader {
+	h := &fileHeader{
+		raw:     make([]byte, 0, fileHeaderSize),
+		nonce:   n,
+		key:     c.dataKey,
+		version: 1,
+	}
+	h.raw = append(h.raw, fileMagicBytes...)
+	h.raw = append(h.raw, n[:]...)
+	return h
+}
+
+// wrap encrypts the file key in h with mk making a version 2 header
+func (c *Cipher) wrap(h *fileHeader, mk *masterKey) (*fileHeader, error) {
+	var wrapNonce nonce
+	err := wrapNonce.fromReader(c.cryptoRand)
+	if err != nil {
+		return nil, err
+	}
+	out := &fileHeader{
+		raw:     make([]byte, 0, fileHeaderSizeV2),
+		nonce:   h.nonce,
+		key:     h.key,
+		keyID:   mk.id,
+		version: 2,
+	}
+	out.raw = append(out.raw, fileMagicV2Bytes...)
+	out.raw = append(out.raw, mk.id[:]...)
+	out.raw = append(out.raw, wrapNonce[:]...)
+	out.raw = secretbox.Seal(out.raw, h.key[:], wrapNonce.pointer(), &mk.key)
+	out.raw = append(out.raw, h.nonce[:]...)
+	return out, nil
+}
+
+// newFileHeader makes the header for a new file
+func (c *Cipher) newFileHeader() (*fileHeader, error) {
+	var n nonce
+	err := n.fromReader(c.cryptoRand)
+	if err != nil {
+		return nil, err
+	}
+	h := c.legacyFileHeader(n)
+	if !c.perFileKeys && c.publicKey == nil {
+		return h, nil
+	}
+	_, err = readers.ReadFill(c.cryptoRand, h.key[:])
+	if err != nil {
+		return nil, fmt.Errorf("short read of file key: %w", err)
+	}
+	return c.rekeyFileHeader(h)
+}
+
+// readFileHeader reads and decodes the header at the start of in
+func (c *Cipher) readFileHeader(in io.Reader) (*fileHeader, error) {
+	raw := make([]byte, fileHeaderSize, maxFileHeaderSize)
+	n, err := readers.ReadFill(in, raw)
+	if n < fileHeaderSize && err == io.EOF {
+		return nil, ErrorEncryptedFileTooShort
+	} else if err != io.EOF && err != nil {
+		return nil, err
+	}
+	var size int
+	switch {
+	case bytes.Equal(raw[:fileMagicSize], fileMagicBytes):
+		var n nonce
+		n.fromBuf(raw[fileMagicSize:])
+		return c.legacyFileHeader(n), nil
+	case bytes.Equal(raw[:fileMagicSize], fileMagicV2Bytes):
+		size = fileHeaderSizeV2
+	case bytes.Equal(raw[:fileMagicSize], fileMagicV3Bytes):
+		size = fileHeaderSizeV3
+	default:
+		return nil, ErrorEncryptedBadMagic
+	}
+	raw = raw[:size]
+	n, err = readers.ReadFill(in, raw[fileHeaderSize:])
+	if n < size-fileHeaderSize && err == io.EOF {
+		return nil, ErrorEncryptedFileTooShort
+	} else if err != io.EOF && err != nil {
+		return nil, err
+	}
+	h := &fileHeader{raw: raw, version: 2}
+	p := raw[fileMagicSize:]
+	copy(h.keyID[:], p)
+	p = p[keyIDSize:]
+	if size == fileHeaderSizeV3 {
+		h.version = 3
+		err = c.openSealedKey(h, p)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	mk := c.findMasterKey(h.keyID)
+	if mk == nil {
+		return nil, ErrorEncryptedUnknownKey
+	}
+	var wrapNonce nonce
+	wrapNonce.fromBuf(p[:fileNonceSize])
+	p = p[fileNonceSize:]
+	key, ok := secretbox.Open(nil, p[:secretbox.Overhead+fileKeySize], wrapNonce.pointer(), &mk.key)
+	if !ok {
+		return nil, ErrorEncryptedBadKey
+	}
+	copy(h.key[:], key)
+	h.nonce.fromBuf(p[secretbox.Overhead+fileKeySize:])
+	return h, nil
+}
+
+// needsRekey returns true if the header at the start of raw doesn't
+// have its file key encrypted with the current master key, or the
+// public key if set.
+//
+// This doesn't decrypt the header so doesn't need the private key.
+func (c *Cipher) needsRekey(raw []byte) bool {
+	magic, id := fileMagicV2Bytes, c.masterKeys[0].id
+	if c.publicKey != nil {
+		magic, id = fileMagicV3Bytes, c.publicKeyID
+	}
+	return len(raw) < fileMagicSize+keyIDSize ||
+		!bytes.Equal(raw[:fileMagicSize], magic) ||
+		!bytes.Equal(raw[fileMagicSize:fileMagicSize+keyIDSize], id[:])
+}
+
+// rekeyFileHeader returns h with its file key encrypted with the
+// current master key, or the public key if set.
+//
+// Original format headers are converted keeping the data key as the
+// file key so the blocks don't change.
+func (c *Cipher) rekeyFileHeader(h *fileHeader) (*fileHeader, error) {
+	if c.publicKey != nil {
+		return c.seal(h)
+	}
+	return c.wrap(h, &c.masterKeys[0])
+}
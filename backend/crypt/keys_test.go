@@ -0,0 +1,179 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPerFileKeysCipher makes a cipher writing files with their own
+// keys encrypted with masterPassword
+func newPerFileKeysCipher(t *testing.T, masterPassword string, oldPasswords ...string) *Cipher {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	c.setPerFileKeys(true)
+	require.NoError(t, c.setMasterKeys(masterPassword, oldPasswords))
+	return c
+}
+
+// encrypt encrypts plaintext with c
+func encrypt(t *testing.T, c *Cipher, plaintext []byte) []byte {
+	in, err := c.EncryptData(bytes.NewReader(plaintext))
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(in)
+	require.NoError(t, err)
+	return ciphertext
+}
+
+// decrypt decrypts ciphertext with c
+func decrypt(c *Cipher, ciphertext []byte) ([]byte, error) {
+	out, err := c.DecryptData(io.NopCloser(bytes.NewReader(ciphertext)))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(out)
+}
+
+func TestMasterKeyID(t *testing.T) {
+	mk1 := newMasterKey([]byte("potato"))
+	mk2 := newMasterKey([]byte("potato"))
+	mk3 := newMasterKey([]byte("sausage"))
+	assert.Equal(t, mk1, mk2)
+	assert.NotEqual(t, mk1.id, mk3.id)
+	assert.NotEqual(t, mk1.key, mk3.key)
+	assert.NotEqual(t, mk1.id[:], mk1.key[:keyIDSize])
+}
+
+func TestPerFileKeys(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("hello world "), 20000)
+	c := newPerFileKeysCipher(t, "master")
+	ciphertext := encrypt(t, c, plaintext)
+	assert.Equal(t, []byte(fileMagicV2), ciphertext[:fileMagicSize])
+	assert.Equal(t, c.EncryptedSize(int64(len(plaintext))), int64(len(ciphertext)))
+	size, err := c.DecryptedSize(int64(len(ciphertext)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(plaintext)), size)
+
+	// Each file has its own key
+	other := encrypt(t, c, plaintext)
+	assert.NotEqual(t, ciphertext[fileHeaderSizeV2:fileHeaderSizeV2+100], other[fileHeaderSizeV2:fileHeaderSizeV2+100])
+
+	got, err := decrypt(c, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// The master key is needed to read the file
+	_, err = decrypt(newPerFileKeysCipher(t, "wrong"), ciphertext)
+	assert.Equal(t, ErrorEncryptedUnknownKey, err)
+	got, err = decrypt(newPerFileKeysCipher(t, "new", "old", "master"), ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Corrupting the wrapped key is detected
+	corrupt := bytes.Clone(ciphertext)
+	corrupt[fileMagicSize+keyIDSize+fileNonceSize+3] ^= 1
+	_, err = decrypt(c, corrupt)
+	assert.Equal(t, ErrorEncryptedBadKey, err)
+
+	// Truncated headers are detected
+	for _, n := range []int{fileHeaderSize, fileHeaderSizeV2 - 1} {
+		_, err = decrypt(c, ciphertext[:n])
+		assert.Equal(t, ErrorEncryptedFileTooShort, err)
+	}
+
+	// Files without per file keys can still be read
+	legacy, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	legacyCiphertext := encrypt(t, legacy, plaintext)
+	assert.Equal(t, []byte(fileMagic), legacyCiphertext[:fileMagicSize])
+	got, err = decrypt(c, legacyCiphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// ...and files with them can be read by a cipher not
+	// writing them as the data key is always a master key
+	got, err = decrypt(legacy, encrypt(t, newPerFileKeysCipher(t, ""), plaintext))
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+}
+
+func TestPerFileKeysSeek(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("0123456789"), 20000)
+	writer := newPerFileKeysCipher(t, "")
+	ciphertext := encrypt(t, writer, plaintext)
+	open := func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+		end := int64(len(ciphertext))
+		if limit >= 0 {
+			end = min(offset+limit, end)
+		}
+		return io.NopCloser(bytes.NewReader(ciphertext[offset:end])), nil
+	}
+	// Read with a cipher expecting version 2 headers and one
+	// which isn't so has to reopen the file when it finds one
+	legacy, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	for _, c := range []*Cipher{writer, legacy} {
+		for _, test := range []struct {
+			offset, limit int64
+		}{
+			{0, -1},
+			{0, 100},
+			{0, blockDataSize + 1},
+			{1, 10},
+			{blockDataSize + 7, -1},
+			{blockDataSize + 7, 1000},
+		} {
+			rc, err := c.DecryptDataSeek(context.Background(), open, test.offset, test.limit)
+			require.NoError(t, err)
+			got, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			want := plaintext[test.offset:]
+			if test.limit >= 0 {
+				want = want[:test.limit]
+			}
+			assert.Equal(t, want, got, "offset %d limit %d", test.offset, test.limit)
+			require.NoError(t, rc.Close())
+		}
+	}
+}
+
+func TestRekeyFileHeader(t *testing.T) {
+	plaintext := []byte("hello")
+
+	// Legacy files are converted keeping their data
+	legacy, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	ciphertext := encrypt(t, legacy, plaintext)
+	c := newPerFileKeysCipher(t, "new")
+	header, err := c.readFileHeader(bytes.NewReader(ciphertext))
+	require.NoError(t, err)
+	assert.True(t, c.needsRekey(header.raw))
+	newHeader, err := c.rekeyFileHeader(header)
+	require.NoError(t, err)
+	assert.Len(t, newHeader.raw, fileHeaderSizeV2)
+	rekeyed := append(bytes.Clone(newHeader.raw), ciphertext[fileHeaderSize:]...)
+	got, err := decrypt(newPerFileKeysCipher(t, "new"), rekeyed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Files are rekeyed to the new master key
+	old := newPerFileKeysCipher(t, "old")
+	ciphertext = encrypt(t, old, plaintext)
+	c = newPerFileKeysCipher(t, "new", "old")
+	header, err = c.readFileHeader(bytes.NewReader(ciphertext))
+	require.NoError(t, err)
+	assert.True(t, c.needsRekey(header.raw))
+	newHeader, err = c.rekeyFileHeader(header)
+	require.NoError(t, err)
+	assert.False(t, c.needsRekey(newHeader.raw))
+	rekeyed = append(bytes.Clone(newHeader.raw), ciphertext[fileHeaderSizeV2:]...)
+	got, err = decrypt(newPerFileKeysCipher(t, "new"), rekeyed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+	_, err = decrypt(old, rekeyed)
+	assert.Equal(t, ErrorEncryptedUnknownKey, err)
+}
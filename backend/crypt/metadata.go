@@ -0,0 +1,460 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// metadataKey is the key of the encrypted metadata on the underlying
+// remote
+const metadataKey = "rclone-crypt"
+
+// Possible values for the padding option
+const (
+	paddingOff   = "off"
+	paddingPadme = "padme"
+)
+
+// Errors returned for encrypted metadata
+var (
+	// ErrorEncryptedBadMetadata is returned if the encrypted
+	// metadata can't be decrypted
+	ErrorEncryptedBadMetadata = errors.New("failed to authenticate encrypted metadata - bad password?")
+
+	// ErrorEncryptedMetadataWrongFile is returned if the encrypted
+	// metadata was written for a different file
+	ErrorEncryptedMetadataWrongFile = errors.New("encrypted metadata belongs to a different file")
+)
+
+// encryptedMetadata is stored encrypted in the metadata of the
+// underlying object
+//
+// Nonce binds the metadata of a file to it so it can't be moved to
+// another file, as it is the nonce in the file's header which is
+// random for each file.
+type encryptedMetadata struct {
+	Size     *int64      `json:"size,omitempty"`     // size of the data before padding if padded
+	Metadata fs.Metadata `json:"metadata,omitempty"` // metadata of the object
+	Nonce    []byte      `json:"nonce,omitempty"`    // nonce in the header of the file if data is encrypted
+}
+
+// metadataCipherKey returns the key used to encrypt the metadata
+func (c *Cipher) metadataCipherKey() (key [32]byte) {
+	mac := hmac.New(sha256.New, c.dataKey[:])
+	_, _ = mac.Write([]byte("rclone crypt metadata key"))
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// encryptMetadata encrypts em returning it as a string
+func (c *Cipher) encryptMetadata(em *encryptedMetadata) (string, error) {
+	data, err := json.Marshal(em)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var n nonce
+	err = n.fromReader(c.cryptoRand)
+	if err != nil {
+		return "", err
+	}
+	key := c.metadataCipherKey()
+	out := secretbox.Seal(n[:], data, n.pointer(), &key)
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// decryptMetadata decrypts a string made by encryptMetadata
+func (c *Cipher) decryptMetadata(s string) (*encryptedMetadata, error) {
+	in, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(in) < fileNonceSize {
+		return nil, ErrorEncryptedBadMetadata
+	}
+	var n nonce
+	n.fromBuf(in)
+	key := c.metadataCipherKey()
+	data, ok := secretbox.Open(nil, in[fileNonceSize:], n.pointer(), &key)
+	if !ok {
+		return nil, ErrorEncryptedBadMetadata
+	}
+	em := new(encryptedMetadata)
+	err = json.Unmarshal(data, em)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return em, nil
+}
+
+// padme returns the size to pad size to using the Padmé scheme
+//
+// This leaks O(log log size) bits of information about the size and
+// adds at most 12% to it.
+func padme(size int64) int64 {
+	if size < 2 {
+		return size
+	}
+	e := bits.Len64(uint64(size)) - 1
+	s := bits.Len64(uint64(e))
+	mask := int64(1)<<(e-s) - 1
+	return (size + mask) &^ mask
+}
+
+// paddedSize returns the size the data of a file of size is padded to
+func (f *Fs) paddedSize(size int64) int64 {
+	if f.opt.Padding == paddingPadme && size >= 0 {
+		return padme(size)
+	}
+	return size
+}
+
+// padReader reads size bytes from in then zeros up to the padded size
+type padReader struct {
+	in   io.Reader
+	size int64 // bytes left to read from in
+	pad  int64 // zeros left to read after in
+}
+
+// newPadReader reads in which should have size bytes then pads it
+// with zeros to paddedSize bytes
+func newPadReader(in io.Reader, size, paddedSize int64) *padReader {
+	return &padReader{in: in, size: size, pad: paddedSize - size}
+}
+
+// Read bytes from the underlying reader then the padding
+func (p *padReader) Read(buf []byte) (n int, err error) {
+	if p.size >= 0 {
+		n, err = p.in.Read(buf)
+		p.size -= int64(n)
+		if p.size < 0 {
+			return n, errors.New("padding: source file is longer than expected")
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		if p.size != 0 {
+			return n, errors.New("padding: source file is shorter than expected")
+		}
+		p.size = -1
+		if n > 0 {
+			return n, nil
+		}
+	}
+	if p.pad <= 0 {
+		return 0, io.EOF
+	}
+	n = int(min(int64(len(buf)), p.pad))
+	clear(buf[:n])
+	p.pad -= int64(n)
+	return n, nil
+}
+
+// metadataTimeFormat is the format of times in metadata
+const metadataTimeFormat = time.RFC3339Nano
+
+// mtimeFromMetadata returns the modification time in m if set
+func mtimeFromMetadata(m fs.Metadata) (t time.Time, ok bool) {
+	value, ok := m["mtime"]
+	if !ok {
+		return t, false
+	}
+	t, err := time.Parse(metadataTimeFormat, value)
+	if err != nil {
+		fs.Debugf(nil, "crypt: failed to parse metadata mtime: %q: %v", value, err)
+		return t, false
+	}
+	return t, true
+}
+
+// putMetadata reads the metadata of src the way the underlying
+// remote would and encrypts it.
+//
+// It returns the encrypted metadata to give to the underlying remote
+// with the context and options to use to make sure it is written as
+// is, and the modification time from the metadata if set.
+//
+// The metadata is bound to the file with header h if it isn't nil.
+func (f *Fs) putMetadata(ctx context.Context, src fs.ObjectInfo, options []fs.OpenOption, h *fileHeader) (context.Context, []fs.OpenOption, fs.Metadata, time.Time, error) {
+	var modTime time.Time
+	meta, err := fs.GetMetadataOptions(ctx, f, src, options)
+	if err != nil {
+		return nil, nil, nil, modTime, err
+	}
+	if mtime, ok := mtimeFromMetadata(meta); ok {
+		modTime = mtime
+	}
+	em := encryptedMetadata{Metadata: meta}
+	if f.opt.Padding != paddingOff {
+		size := src.Size()
+		em.Size = &size
+	}
+	// The metadata options are encrypted so don't pass them on
+	newOptions := make([]fs.OpenOption, 0, len(options))
+	for _, option := range options {
+		if _, ok := option.(fs.MetadataOption); !ok {
+			newOptions = append(newOptions, option)
+		}
+	}
+	if em.Metadata == nil && em.Size == nil {
+		return ctx, newOptions, nil, modTime, nil
+	}
+	if h != nil {
+		em.Nonce = h.nonce[:]
+	}
+	value, err := f.cipher.encryptMetadata(&em)
+	if err != nil {
+		return nil, nil, nil, modTime, err
+	}
+	// Make sure the underlying remote writes the metadata without
+	// mapping it again
+	newCtx, ci := fs.AddConfig(ctx)
+	ci.Metadata = true
+	ci.MetadataMapper = nil
+	return newCtx, newOptions, fs.Metadata{metadataKey: value}, modTime, nil
+}
+
+// readMetadata reads the encrypted metadata of the underlying entry
+// e returning nil if there isn't any.
+//
+// The unencrypted metadata is returned too.
+func (f *Fs) readMetadata(ctx context.Context, e fs.DirEntry) (*encryptedMetadata, fs.Metadata, error) {
+	meta, err := fs.GetMetadata(ctx, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, ok := meta[metadataKey]
+	if !ok {
+		return nil, meta, nil
+	}
+	em, err := f.cipher.decryptMetadata(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return em, meta, nil
+}
+
+// encryptedMetadataOf returns the metadata to show for the underlying
+// entry e, which holds the file of o if it isn't nil
+//
+// The modification time may have been changed since the metadata was
+// written so it is read from e.
+func (f *Fs) encryptedMetadataOf(ctx context.Context, e fs.DirEntry, o *Object) (fs.Metadata, error) {
+	em, meta, err := f.readMetadata(ctx, e)
+	if err != nil || em == nil {
+		// Not written with encrypt_metadata
+		return meta, err
+	}
+	if o != nil {
+		if err := o.checkMetadata(ctx, em); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := em.Metadata["mtime"]; ok {
+		em.Metadata["mtime"] = e.ModTime(ctx).Format(metadataTimeFormat)
+	}
+	return em.Metadata, nil
+}
+
+// setEncryptedMetadata merges metadata into the encrypted metadata of
+// the underlying entry e, which holds the file of o if it isn't nil
+func (f *Fs) setEncryptedMetadata(ctx context.Context, e fs.DirEntry, metadata fs.Metadata, o *Object) (*encryptedMetadata, error) {
+	do, ok := e.(fs.SetMetadataer)
+	if !ok {
+		return nil, fs.ErrorNotImplemented
+	}
+	em, _, err := f.readMetadata(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	if em == nil {
+		em = new(encryptedMetadata)
+		if o != nil {
+			em.Nonce, err = o.metadataNonce(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if o != nil {
+		if err := o.checkMetadata(ctx, em); err != nil {
+			return nil, err
+		}
+	}
+	if em.Metadata == nil {
+		em.Metadata = make(fs.Metadata, len(metadata))
+	}
+	em.Metadata.Merge(metadata)
+	value, err := f.cipher.encryptMetadata(em)
+	if err != nil {
+		return nil, err
+	}
+	err = do.SetMetadata(ctx, fs.Metadata{metadataKey: value})
+	if err != nil {
+		return nil, err
+	}
+	if mtime, ok := mtimeFromMetadata(metadata); ok {
+		err = setModTime(ctx, e, mtime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return em, nil
+}
+
+// serverSideMetadata returns the context to do a server-side copy or
+// move of src with on the underlying remote and any metadata to set on
+// the result afterwards.
+//
+// This stops the underlying remote writing the metadata from
+// --metadata-set and --metadata-mapper unencrypted.
+func (f *Fs) serverSideMetadata(ctx context.Context, src *Object) (context.Context, fs.Metadata, error) {
+	ci := fs.GetConfig(ctx)
+	if !f.opt.EncryptMetadata || !ci.Metadata || (ci.MetadataSet == nil && len(ci.MetadataMapper) == 0) {
+		return ctx, nil, nil
+	}
+	meta, err := fs.GetMetadataOptions(ctx, f, src, fs.MetadataAsOpenOptions(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	newCtx, newCi := fs.AddConfig(ctx)
+	newCi.MetadataSet = nil
+	newCi.MetadataMapper = nil
+	return newCtx, meta, nil
+}
+
+// setModTime sets the modification time of the underlying entry e
+func setModTime(ctx context.Context, e fs.DirEntry, modTime time.Time) error {
+	switch x := e.(type) {
+	case fs.Object:
+		return x.SetModTime(ctx, modTime)
+	case fs.SetModTimer:
+		return x.SetModTime(ctx, modTime)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// metadataNonce returns the nonce to bind the metadata of o to, or
+// nil if the data isn't encrypted
+func (o *Object) metadataNonce(ctx context.Context) ([]byte, error) {
+	if o.f.opt.NoDataEncryption {
+		return nil, nil
+	}
+	_, n, err := o.readHeader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header to check metadata: %w", err)
+	}
+	return n[:], nil
+}
+
+// checkMetadata checks em was written for the file of o
+func (o *Object) checkMetadata(ctx context.Context, em *encryptedMetadata) error {
+	n, err := o.metadataNonce(ctx)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(em.Nonce, n) {
+		return ErrorEncryptedMetadataWrongFile
+	}
+	return nil
+}
+
+// objectMetadata caches the encrypted metadata of an Object
+type objectMetadata struct {
+	mu     sync.Mutex
+	em     *encryptedMetadata
+	loaded bool  // set if em is known
+	err    error // set if the metadata couldn't be decrypted
+}
+
+// readPaddedMetadata reads the encrypted metadata of o to find its
+// size before padding
+func (o *Object) readPaddedMetadata(ctx context.Context) error {
+	em, _, err := o.f.readMetadata(ctx, o.Object)
+	if errors.Is(err, ErrorEncryptedBadMetadata) {
+		fs.Errorf(o, "Can't work out size: %v", err)
+	} else if err != nil {
+		return fmt.Errorf("failed to read size from metadata: %w", err)
+	}
+	o.meta.mu.Lock()
+	o.meta.em, o.meta.loaded, o.meta.err = em, true, err
+	o.meta.mu.Unlock()
+	return nil
+}
+
+// setMetadata records that em is the encrypted metadata of o, or that
+// it isn't known if em is nil
+func (o *Object) setMetadata(em *encryptedMetadata) {
+	o.meta.mu.Lock()
+	o.meta.em, o.meta.loaded, o.meta.err = em, em != nil, nil
+	o.meta.mu.Unlock()
+}
+
+// copyMetadata records that o has the same encrypted metadata as src
+func (o *Object) copyMetadata(src *Object) {
+	src.meta.mu.Lock()
+	em, loaded, err := src.meta.em, src.meta.loaded, src.meta.err
+	src.meta.mu.Unlock()
+	o.meta.mu.Lock()
+	o.meta.em, o.meta.loaded, o.meta.err = em, loaded, err
+	o.meta.mu.Unlock()
+}
+
+// unpaddedSize returns the size of o before it was padded from the
+// metadata read by readSize or written with o, or -1 if it wasn't
+// padded
+func (o *Object) unpaddedSize() (int64, error) {
+	o.meta.mu.Lock()
+	defer o.meta.mu.Unlock()
+	if !o.meta.loaded {
+		return -1, errors.New("metadata not read")
+	}
+	if o.meta.err != nil {
+		return -1, o.meta.err
+	}
+	if o.meta.em == nil || o.meta.em.Size == nil {
+		return -1, nil
+	}
+	size := *o.meta.em.Size
+	// Check the size is consistent with the encrypted size
+	decryptedSize, err := o.decryptedSize()
+	if err != nil {
+		return -1, err
+	}
+	if size < 0 || padme(size) != decryptedSize {
+		return -1, fmt.Errorf("size %d in metadata doesn't match padded size %d", size, decryptedSize)
+	}
+	return size, nil
+}
+
+// Directory is a directory with encrypted metadata
+type Directory struct {
+	*fs.DirWrapper
+	f *Fs
+}
+
+// Metadata returns the decrypted metadata of the directory
+func (d *Directory) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return d.f.encryptedMetadataOf(ctx, d.DirWrapper.Directory, nil)
+}
+
+// SetMetadata encrypts metadata and sets it on the directory
+func (d *Directory) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	_, err := d.f.setEncryptedMetadata(ctx, d.DirWrapper.Directory, metadata, nil)
+	return err
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Directory     = (*Directory)(nil)
+	_ fs.Metadataer    = (*Directory)(nil)
+	_ fs.SetMetadataer = (*Directory)(nil)
+)
@@ -0,0 +1,79 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPadme(t *testing.T) {
+	for _, test := range []struct {
+		in   int64
+		want int64
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 2},
+		{3, 3},
+		{9, 10},
+		{1000, 1024},
+		{1024, 1024},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 1<<20 + 1<<15},
+	} {
+		got := padme(test.in)
+		assert.Equal(t, test.want, got, test.in)
+		assert.LessOrEqual(t, float64(got), float64(test.in)*1.12+1, test.in)
+	}
+}
+
+func TestPadReader(t *testing.T) {
+	got, err := io.ReadAll(newPadReader(bytes.NewBufferString("hello"), 5, 8))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello\x00\x00\x00"), got)
+
+	// The size of the input is checked
+	_, err = io.ReadAll(newPadReader(bytes.NewBufferString("hello"), 4, 8))
+	assert.ErrorContains(t, err, "longer")
+	_, err = io.ReadAll(newPadReader(bytes.NewBufferString("hello"), 6, 8))
+	assert.ErrorContains(t, err, "shorter")
+}
+
+func TestEncryptMetadata(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "potato", "", true, nil)
+	require.NoError(t, err)
+	size := int64(42)
+	in := &encryptedMetadata{
+		Size:     &size,
+		Metadata: fs.Metadata{"mode": "100600", "potato": "sausage"},
+	}
+	value, err := c.encryptMetadata(in)
+	require.NoError(t, err)
+	assert.NotContains(t, value, "sausage")
+	out, err := c.decryptMetadata(value)
+	require.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	// Each encryption is different
+	value2, err := c.encryptMetadata(in)
+	require.NoError(t, err)
+	assert.NotEqual(t, value, value2)
+
+	// Tampering is detected
+	corrupt := []byte(value)
+	corrupt[len(corrupt)-3] ^= 1
+	_, err = c.decryptMetadata(string(corrupt))
+	assert.Equal(t, ErrorEncryptedBadMetadata, err)
+	_, err = c.decryptMetadata("AAAA")
+	assert.Equal(t, ErrorEncryptedBadMetadata, err)
+
+	// The password is needed
+	other, err := newCipher(NameEncryptionStandard, "sausage", "", true, nil)
+	require.NoError(t, err)
+	_, err = other.decryptMetadata(value)
+	assert.Equal(t, ErrorEncryptedBadMetadata, err)
+}
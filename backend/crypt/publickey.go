@@ -0,0 +1,174 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Constants for the version 3 file header
+//
+// This is the magic, the ID of the public key, the file key sealed to
+// the public key and the nonce of the first block.
+const (
+	fileMagicV3      = "RCLONE\x00\x03"
+	sealedKeySize    = box.AnonymousOverhead + fileKeySize
+	fileHeaderSizeV3 = fileMagicSize + keyIDSize + sealedKeySize + fileNonceSize
+)
+
+// Prefixes of age X25519 keys
+const (
+	agePublicKeyPrefix  = "age"
+	agePrivateKeyPrefix = "age-secret-key-"
+)
+
+// Errors returned reading file keys sealed to a public key
+var (
+	ErrorEncryptedNoPrivateKey    = errors.New("file key encrypted with a public key - private_key is needed to read it")
+	ErrorEncryptedWrongPrivateKey = errors.New("file key encrypted with a different public key - check private_key")
+)
+
+var fileMagicV3Bytes = []byte(fileMagicV3)
+
+// publicKeyID identifies the public key pub
+func publicKeyID(pub *[32]byte) (id keyID) {
+	h := sha256.New()
+	_, _ = h.Write([]byte("rclone crypt public key id"))
+	_, _ = h.Write(pub[:])
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// parseKey decodes an X25519 key in age format with prefix or base64
+func parseKey(s, prefix string) (*[32]byte, error) {
+	s = strings.TrimSpace(s)
+	var data []byte
+	if strings.HasPrefix(strings.ToLower(s), prefix+"1") {
+		hrp, decoded, err := bech32Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		if hrp != prefix {
+			return nil, fmt.Errorf("expecting prefix %q but got %q", prefix, hrp)
+		}
+		data = decoded
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("not an age key or base64: %w", err)
+		}
+		data = decoded
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("key is %d bytes long but should be 32", len(data))
+	}
+	var key [32]byte
+	copy(key[:], data)
+	return &key, nil
+}
+
+// parsePublicKey decodes an X25519 public key
+//
+// This can be an age recipient (age1...) or 32 bytes of base64.
+func parsePublicKey(s string) (*[32]byte, error) {
+	return parseKey(s, agePublicKeyPrefix)
+}
+
+// parsePrivateKey decodes an X25519 private key
+//
+// This can be an age identity (AGE-SECRET-KEY-1...) or 32 bytes of
+// base64.
+func parsePrivateKey(s string) (*[32]byte, error) {
+	return parseKey(s, agePrivateKeyPrefix)
+}
+
+// newKeyPair makes an X25519 key pair returning the keys in age format
+func newKeyPair(rand io.Reader) (publicKey, privateKey string, err error) {
+	pub, priv, err := box.GenerateKey(rand)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey, err = bech32Encode(agePublicKeyPrefix, pub[:])
+	if err != nil {
+		return "", "", err
+	}
+	privateKey, err = bech32Encode(agePrivateKeyPrefix, priv[:])
+	if err != nil {
+		return "", "", err
+	}
+	return publicKey, strings.ToUpper(privateKey), nil
+}
+
+// setPublicKey sets the public key the file keys are sealed to
+//
+// If pub is nil then files are written without using a public key.
+func (c *Cipher) setPublicKey(pub *[32]byte) {
+	c.publicKey = pub
+	if pub != nil {
+		c.publicKeyID = publicKeyID(pub)
+	}
+	c.setHeaderSize()
+}
+
+// setPrivateKey sets the private key used to read files whose keys
+// are sealed to a public key
+func (c *Cipher) setPrivateKey(priv *[32]byte) error {
+	c.privateKey = priv
+	c.privatePublicKey = nil
+	if priv == nil {
+		return nil
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return fmt.Errorf("bad private key: %w", err)
+	}
+	c.privatePublicKey = new([32]byte)
+	copy(c.privatePublicKey[:], pub)
+	c.privateKeyID = publicKeyID(c.privatePublicKey)
+	return nil
+}
+
+// seal encrypts the file key in h to the public key making a version
+// 3 header
+func (c *Cipher) seal(h *fileHeader) (*fileHeader, error) {
+	out := &fileHeader{
+		raw:     make([]byte, 0, fileHeaderSizeV3),
+		nonce:   h.nonce,
+		key:     h.key,
+		keyID:   c.publicKeyID,
+		version: 3,
+	}
+	out.raw = append(out.raw, fileMagicV3Bytes...)
+	out.raw = append(out.raw, c.publicKeyID[:]...)
+	var err error
+	out.raw, err = box.SealAnonymous(out.raw, h.key[:], c.publicKey, c.cryptoRand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal file key: %w", err)
+	}
+	out.raw = append(out.raw, h.nonce[:]...)
+	return out, nil
+}
+
+// openSealedKey decrypts the file key in p which follows the key ID
+// in a version 3 header with the private key
+func (c *Cipher) openSealedKey(h *fileHeader, p []byte) error {
+	if c.privateKey == nil {
+		return ErrorEncryptedNoPrivateKey
+	}
+	if h.keyID != c.privateKeyID {
+		return ErrorEncryptedWrongPrivateKey
+	}
+	key, ok := box.OpenAnonymous(nil, p[:sealedKeySize], c.privatePublicKey, c.privateKey)
+	if !ok || len(key) != fileKeySize {
+		return ErrorEncryptedBadKey
+	}
+	copy(h.key[:], key)
+	h.nonce.fromBuf(p[sealedKeySize:])
+	return nil
+}
@@ -0,0 +1,169 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPublicKeyCipher makes a cipher writing files with their keys
+// sealed to publicKey and reading them with privateKey if set
+func newPublicKeyCipher(t *testing.T, publicKey, privateKey string) *Cipher {
+	c, err := newCipher(NameEncryptionStandard, "", "", true, nil)
+	require.NoError(t, err)
+	if publicKey != "" {
+		pub, err := parsePublicKey(publicKey)
+		require.NoError(t, err)
+		c.setPublicKey(pub)
+	}
+	if privateKey != "" {
+		priv, err := parsePrivateKey(privateKey)
+		require.NoError(t, err)
+		require.NoError(t, c.setPrivateKey(priv))
+	}
+	return c
+}
+
+func TestBech32(t *testing.T) {
+	for _, valid := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		hrp, data, err := bech32Decode(valid)
+		require.NoError(t, err, valid)
+		got, err := bech32Encode(hrp, data)
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToLower(valid), got)
+	}
+	for _, invalid := range []string{
+		"",
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty prefix
+		"x1b4n0q5v",     // invalid character
+		"A1G7SGD8",      // invalid checksum
+		"a12uel5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // bad checksum
+	} {
+		_, _, err := bech32Decode(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	publicKey, privateKey, err := newKeyPair(rand.Reader)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(publicKey, "age1"))
+	assert.True(t, strings.HasPrefix(privateKey, "AGE-SECRET-KEY-1"))
+
+	pub, err := parsePublicKey(publicKey)
+	require.NoError(t, err)
+	priv, err := parsePrivateKey(privateKey)
+	require.NoError(t, err)
+	c := newPublicKeyCipher(t, "", "")
+	require.NoError(t, c.setPrivateKey(priv))
+	assert.Equal(t, pub, c.privatePublicKey)
+
+	// Keys can be base64 too
+	b64, err := parsePublicKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	require.NoError(t, err)
+	assert.Equal(t, byte(31), b64[31])
+
+	// The prefixes are checked
+	_, err = parsePublicKey(privateKey)
+	assert.Error(t, err)
+	_, err = parsePrivateKey(publicKey)
+	assert.Error(t, err)
+	_, err = parsePublicKey("AAEC")
+	assert.ErrorContains(t, err, "should be 32")
+}
+
+func TestPublicKey(t *testing.T) {
+	publicKey, privateKey, err := newKeyPair(rand.Reader)
+	require.NoError(t, err)
+	plaintext := bytes.Repeat([]byte("hello world "), 20000)
+
+	// A cipher with just the public key can write but not read
+	writer := newPublicKeyCipher(t, publicKey, "")
+	ciphertext := encrypt(t, writer, plaintext)
+	assert.Equal(t, []byte(fileMagicV3), ciphertext[:fileMagicSize])
+	assert.Equal(t, writer.EncryptedSize(int64(len(plaintext))), int64(len(ciphertext)))
+	_, err = decrypt(writer, ciphertext)
+	assert.Equal(t, ErrorEncryptedNoPrivateKey, err)
+
+	// Each file has its own key
+	other := encrypt(t, writer, plaintext)
+	assert.NotEqual(t, ciphertext[fileHeaderSizeV3:fileHeaderSizeV3+100], other[fileHeaderSizeV3:fileHeaderSizeV3+100])
+
+	// The private key is needed to read it
+	reader := newPublicKeyCipher(t, "", privateKey)
+	got, err := decrypt(reader, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+	_, otherPrivateKey, err := newKeyPair(rand.Reader)
+	require.NoError(t, err)
+	_, err = decrypt(newPublicKeyCipher(t, "", otherPrivateKey), ciphertext)
+	assert.Equal(t, ErrorEncryptedWrongPrivateKey, err)
+
+	// Corrupting the sealed key is detected
+	corrupt := bytes.Clone(ciphertext)
+	corrupt[fileMagicSize+keyIDSize+40] ^= 1
+	_, err = decrypt(reader, corrupt)
+	assert.Equal(t, ErrorEncryptedBadKey, err)
+
+	// Truncated headers are detected
+	_, err = decrypt(reader, ciphertext[:fileHeaderSizeV3-1])
+	assert.Equal(t, ErrorEncryptedFileTooShort, err)
+
+	// Files can be read at an offset by a cipher expecting a
+	// smaller header
+	open := func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+		end := int64(len(ciphertext))
+		if limit >= 0 {
+			end = min(offset+limit, end)
+		}
+		return io.NopCloser(bytes.NewReader(ciphertext[offset:end])), nil
+	}
+	rc, err := reader.DecryptDataSeek(context.Background(), open, blockDataSize+7, 100)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, plaintext[blockDataSize+7:blockDataSize+107], got)
+}
+
+func TestRekeyToPublicKey(t *testing.T) {
+	publicKey, privateKey, err := newKeyPair(rand.Reader)
+	require.NoError(t, err)
+	plaintext := []byte("hello")
+
+	// Files written with per file keys are converted without
+	// needing the private key
+	old := newPerFileKeysCipher(t, "master")
+	ciphertext := encrypt(t, old, plaintext)
+	c := newPublicKeyCipher(t, publicKey, "")
+	require.NoError(t, c.setMasterKeys("master", nil))
+	assert.True(t, c.needsRekey(ciphertext))
+	header, err := c.readFileHeader(bytes.NewReader(ciphertext))
+	require.NoError(t, err)
+	newHeader, err := c.rekeyFileHeader(header)
+	require.NoError(t, err)
+	assert.Len(t, newHeader.raw, fileHeaderSizeV3)
+	assert.False(t, c.needsRekey(newHeader.raw))
+	rekeyed := append(bytes.Clone(newHeader.raw), ciphertext[fileHeaderSizeV2:]...)
+	got, err := decrypt(newPublicKeyCipher(t, "", privateKey), rekeyed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	// Files sealed to another public key need rekeying
+	otherPublicKey, _, err := newKeyPair(rand.Reader)
+	require.NoError(t, err)
+	assert.True(t, newPublicKeyCipher(t, otherPublicKey, "").needsRekey(rekeyed))
+}
@@ -0,0 +1,211 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// rekeyStats is the output of the rekey command
+type rekeyStats struct {
+	Rekeyed   int64 `json:"rekeyed"`
+	Unchanged int64 `json:"unchanged"`
+	Errors    int64 `json:"errors"`
+}
+
+// setMasterPassword makes password the master password, keeping the
+// previous one so files which haven't been rekeyed can still be read.
+//
+// The changes are saved in the config.
+func (f *Fs) setMasterPassword(password string) error {
+	opt := f.opt
+	if opt.MasterPassword != "" && !slices.Contains(opt.OldMasterPasswords, opt.MasterPassword) {
+		opt.OldMasterPasswords = append(slices.Clone(opt.OldMasterPasswords), opt.MasterPassword)
+	}
+	var err error
+	opt.MasterPassword, err = obscure.Obscure(password)
+	if err != nil {
+		return err
+	}
+	err = setMasterKeysForConfig(f.cipher, &opt)
+	if err != nil {
+		return err
+	}
+	// Save the old passwords first so files can always be read
+	f.m.Set("old_master_passwords", opt.OldMasterPasswords.String())
+	f.m.Set("master_password", opt.MasterPassword)
+	f.opt = opt
+	return nil
+}
+
+// rekey encrypts the file keys of all the files with the current
+// master key, or to the public key if set, changing the master key
+// first if a new password is passed in.
+func (f *Fs) rekey(ctx context.Context, opt map[string]string) (any, error) {
+	if f.opt.NoDataEncryption {
+		return nil, errors.New("can't rekey when no_data_encryption is set")
+	}
+	if !f.opt.PerFileKeys && f.opt.PublicKey == "" {
+		return nil, errors.New("can't rekey unless per_file_keys or public_key is set")
+	}
+	if password, ok := opt["password"]; ok {
+		if password == "" {
+			return nil, errors.New("new master password must not be empty")
+		}
+		err := f.setMasterPassword(password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set master password: %w", err)
+		}
+		fs.Infof(f, "Changed master password - rekeying files")
+	}
+	var stats rekeyStats
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Transfers)
+	err := walk.ListR(ctx, f, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(*Object)
+			if !ok {
+				continue
+			}
+			g.Go(func() error {
+				changed, err := f.rekeyObject(gCtx, o)
+				switch {
+				case err != nil:
+					err = fs.CountError(gCtx, err)
+					fs.Errorf(o, "Failed to rekey: %v", err)
+					atomic.AddInt64(&stats.Errors, 1)
+				case changed:
+					atomic.AddInt64(&stats.Rekeyed, 1)
+				default:
+					atomic.AddInt64(&stats.Unchanged, 1)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	_ = g.Wait()
+	if err != nil {
+		return stats, err
+	}
+	if stats.Errors != 0 {
+		return stats, fmt.Errorf("failed to rekey %d files", stats.Errors)
+	}
+	return stats, nil
+}
+
+// Suffixes added to the names of the files while they are rekeyed
+const (
+	rekeyTempSuffix = ".rekey"     // rekeyed file while it is uploaded
+	rekeyOldSuffix  = ".rekey-old" // original file until it is replaced
+)
+
+// rekeyObject rewrites the header of o with its file key encrypted
+// with the current master key or the public key.
+//
+// The file data is copied unchanged but most remotes can't change
+// part of a file so the whole file is downloaded and uploaded again.
+func (f *Fs) rekeyObject(ctx context.Context, o *Object) (changed bool, err error) {
+	in, err := o.Object.Open(ctx, &fs.RangeOption{Start: 0, End: int64(maxFileHeaderSize) - 1})
+	if err != nil {
+		return false, fmt.Errorf("failed to open header: %w", err)
+	}
+	raw, err := io.ReadAll(io.LimitReader(in, int64(maxFileHeaderSize)))
+	_ = in.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !f.cipher.needsRekey(raw) {
+		fs.Debugf(o, "Already encrypted with the current key")
+		return false, nil
+	}
+	header, err := f.cipher.readFileHeader(bytes.NewReader(raw))
+	if err != nil {
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+	newHeader, err := f.cipher.rekeyFileHeader(header)
+	if err != nil {
+		return false, err
+	}
+
+	tr := accounting.Stats(ctx).NewTransfer(o, f)
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	rc, err := o.Object.Open(ctx, &fs.SeekOption{Offset: int64(len(header.raw))})
+	if err != nil {
+		return false, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if rc != nil {
+			_ = rc.Close()
+		}
+	}()
+	remote := o.Object.Remote()
+	size := o.Object.Size() - int64(len(header.raw)) + int64(len(newHeader.raw))
+	src := object.NewStaticObjectInfo(remote, o.Object.ModTime(ctx), size, true, nil, f.Fs)
+	if fs.GetConfig(ctx).Metadata {
+		meta, err := fs.GetMetadata(ctx, o.Object)
+		if err != nil {
+			return false, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		src = src.WithMetadata(meta)
+	}
+	body := tr.Account(ctx, io.NopCloser(io.MultiReader(bytes.NewReader(newHeader.raw), rc)))
+
+	// Upload to a temporary name to avoid overwriting the file
+	// while reading it. Remotes which can't move files have to be
+	// updated in place instead.
+	move := f.Fs.Features().Move
+	if move == nil {
+		err = o.Object.Update(ctx, body, src)
+		if err != nil {
+			return false, fmt.Errorf("failed to upload: %w", err)
+		}
+		o.setHeader(newHeader)
+		return true, nil
+	}
+	tmpRemote := remote + rekeyTempSuffix
+	tmp, err := f.Fs.Put(ctx, body, fs.NewOverrideRemote(src, tmpRemote))
+	if err != nil {
+		return false, fmt.Errorf("failed to upload: %w", err)
+	}
+	// Close the old file before removing it
+	err = rc.Close()
+	rc = nil
+	if err != nil {
+		_ = tmp.Remove(ctx)
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	// Keep the original until the rekeyed file has replaced it
+	oldRemote := remote + rekeyOldSuffix
+	old, err := move(ctx, o.Object, oldRemote)
+	if err != nil {
+		_ = tmp.Remove(ctx)
+		return false, fmt.Errorf("failed to rename old file: %w", err)
+	}
+	_, err = move(ctx, tmp, remote)
+	if err != nil {
+		if _, restoreErr := move(ctx, old, remote); restoreErr != nil {
+			return false, fmt.Errorf("failed to rename rekeyed file from %q: %w and failed to restore the original from %q: %v", tmpRemote, err, oldRemote, restoreErr)
+		}
+		_ = tmp.Remove(ctx)
+		return false, fmt.Errorf("failed to rename rekeyed file from %q: %w", tmpRemote, err)
+	}
+	err = old.Remove(ctx)
+	if err != nil {
+		fs.Errorf(o, "Failed to remove original file %q after rekeying: %v", oldRemote, err)
+	}
+	return true, nil
+}
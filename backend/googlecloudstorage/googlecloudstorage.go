@@ -936,6 +936,20 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	return o, o.Update(ctx, in, src, options...)
 }
 
+// PutIfNotExists puts the object into the bucket only if there is no
+// object there already, returning fs.ErrorObjectExists if there is.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	err := o.update(ctx, in, src, true, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	return f.Put(ctx, in, src, options...)
@@ -1406,6 +1420,14 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.Read
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	return o.update(ctx, in, src, false, options...)
+}
+
+// update the object with the contents of the io.Reader, modTime and size
+//
+// If ifNotExists is set then the object is only created if it doesn't
+// exist, returning fs.ErrorObjectExists if it does.
+func (o *Object) update(ctx context.Context, in io.Reader, src fs.ObjectInfo, ifNotExists bool, options ...fs.OpenOption) (err error) {
 	bucket, bucketPath := o.split()
 	// Create parent dir/bucket if not saving directory marker
 	if !strings.HasSuffix(o.remote, "/") {
@@ -1461,9 +1483,16 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 		if o.fs.opt.UserProject != "" {
 			insertObject = insertObject.UserProject(o.fs.opt.UserProject)
 		}
+		if ifNotExists {
+			// generation 0 matches only if there is no live object
+			insertObject = insertObject.IfGenerationMatch(0)
+		}
 		newObject, err = insertObject.Do()
 		return shouldRetry(ctx, err)
 	})
+	if gErr, ok := err.(*googleapi.Error); ok && ifNotExists && gErr.Code == http.StatusPreconditionFailed {
+		return fs.ErrorObjectExists
+	}
 	if err != nil {
 		return err
 	}
@@ -1493,11 +1522,12 @@ func (o *Object) MimeType(ctx context.Context) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.ListPer     = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
+	_ fs.Fs               = &Fs{}
+	_ fs.Copier           = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.PutIfNotExistser = &Fs{}
+	_ fs.ListRer          = &Fs{}
+	_ fs.ListPer          = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.MimeTyper        = &Object{}
 )
@@ -36,6 +36,18 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 			return nil, errors.New("please provide checksum type and path to sum file")
 		}
 		return nil, f.dbImport(ctx, arg[0], arg[1], sticky)
+	case "precompute":
+		return f.scrub(ctx, scrubOpt{})
+	case "scrub":
+		sopt := scrubOpt{verify: true}
+		if older, ok := opt["older"]; ok {
+			d, err := fs.ParseDuration(older)
+			if err != nil {
+				return nil, fmt.Errorf("bad older: %w", err)
+			}
+			sopt.older = d
+		}
+		return f.scrub(ctx, sopt)
 	default:
 		return nil, fs.ErrorCommandNotFound
 	}
@@ -70,6 +82,41 @@ Usage Example:
 Usage Example:
     rclone backend stickyimport hasher:subdir md5 remote:path/to/sum.md5
 `,
+}, {
+	Name:  "precompute",
+	Short: "Compute missing checksums",
+	Long: `Read every file which doesn't have all its checksums in the cache
+and store them.
+
+Files are read in parallel, up to --checkers at once. A JSON report
+is printed at the end.
+
+Usage Example:
+    rclone backend precompute hasher:subdir
+`,
+}, {
+	Name:  "scrub",
+	Short: "Verify cached checksums against the content",
+	Long: `Read every file computing its checksums. Files without cached
+checksums have them stored. Files with cached checksums are checked
+against them and any which don't match are reported as corrupted
+and counted as errors. The cached checksums of corrupted files are kept
+so they are reported again until the file is replaced.
+
+A file is only checked if its fingerprint (size and modification
+time) still matches the one the checksums were cached with, so files
+which have been updated aren't reported as corrupted.
+
+Files are read in parallel, up to --checkers at once. A JSON report
+is printed at the end listing the corrupted files and any errors.
+
+Usage Example:
+    rclone backend scrub hasher:subdir
+    rclone backend scrub hasher:subdir -o older=1w
+`,
+	Opts: map[string]string{
+		"older": "Only verify files not verified for this long, e.g. 1w",
+	},
 }}
 
 func (f *Fs) dbDump(ctx context.Context, full bool, root string) error {
@@ -51,6 +51,30 @@ func init() {
 			Advanced: true,
 			Default:  fs.SizeSuffix(0),
 			Help:     "Auto-update checksum for files smaller than this size (disabled by default).",
+		}, {
+			Name:     "scrub_interval",
+			Advanced: true,
+			Default:  fs.DurationOff,
+			Help: `Interval between background scrubs (disabled by default).
+
+If set, hasher walks the remote in the background computing any
+missing checksums and re-reading files to verify the cached checksums
+against their content, so that files which have changed without their
+size or modification time changing (bit rot) are found.
+
+Each file is verified about once per interval. The first scrub starts
+a minute after the remote is created so this is only useful with
+long running commands like "rclone mount" or "rclone serve".
+
+This needs the cache so max_age must not be 0.`,
+		}, {
+			Name:     "scrub_report",
+			Advanced: true,
+			Default:  "",
+			Help: `Path of a local file to write a JSON report of each background scrub to.
+
+The report is replaced at the end of every background scrub. It has
+the same format as the output of the "scrub" backend command.`,
 		}},
 	})
 }
@@ -61,6 +85,9 @@ type Options struct {
 	Hashes   fs.CommaSepList `config:"hashes"`
 	AutoSize fs.SizeSuffix   `config:"auto_size"`
 	MaxAge   fs.Duration     `config:"max_age"`
+	// background scrubbing
+	ScrubInterval fs.Duration `config:"scrub_interval"`
+	ScrubReport   string      `config:"scrub_report"`
 }
 
 // Fs represents a wrapped fs.Fs
@@ -81,6 +108,9 @@ type Fs struct {
 	slowHashes hash.Set // passed to the base and then cached
 	autoHashes hash.Set // calculated in-house and cached
 	keepHashes hash.Set // checksums to keep in cache (slow + auto)
+	// background scrubbing
+	scrubCancel context.CancelFunc // stops the scrubber if running
+	scrubDone   chan struct{}      // closed when the scrubber has stopped
 }
 
 var warnExperimental sync.Once
@@ -164,6 +194,13 @@ func NewFs(ctx context.Context, fsname, rpath string, cmap configmap.Mapper) (fs
 		f.db = db
 	}
 
+	if f.opt.ScrubInterval > 0 && f.opt.ScrubInterval != fs.DurationOff {
+		if f.db == nil {
+			return nil, errors.New("scrub_interval needs the cache - set max_age to more than 0")
+		}
+		f.startScrubber(ctx)
+	}
+
 	stubFeatures := &fs.Features{
 		CanHaveEmptyDirectories:  true,
 		IsLocal:                  true,
@@ -324,6 +361,15 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	return nil, errors.New("PutUnchecked not supported")
 }
 
+// PutIfNotExists uploads the object only if it doesn't already exist.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutIfNotExists; do != nil {
+		oResult, err := do(ctx, in, src, options...)
+		return f.wrapObject(oResult, err)
+	}
+	return nil, errors.New("PutIfNotExists not supported")
+}
+
 // pruneHash deletes hash for a path
 func (f *Fs) pruneHash(remote string) error {
 	return f.db.Do(true, &kvPrune{
@@ -472,6 +518,7 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 
 // Shutdown the backend, closing any background tasks and any cached connections.
 func (f *Fs) Shutdown(ctx context.Context) (err error) {
+	f.stopScrubber()
 	if f.db != nil && !f.db.IsStopped() {
 		err = f.db.Stop(false)
 	}
@@ -581,27 +628,28 @@ func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = (*Fs)(nil)
-	_ fs.Purger          = (*Fs)(nil)
-	_ fs.Copier          = (*Fs)(nil)
-	_ fs.Mover           = (*Fs)(nil)
-	_ fs.DirMover        = (*Fs)(nil)
-	_ fs.Commander       = (*Fs)(nil)
-	_ fs.PutUncheckeder  = (*Fs)(nil)
-	_ fs.PutStreamer     = (*Fs)(nil)
-	_ fs.CleanUpper      = (*Fs)(nil)
-	_ fs.UnWrapper       = (*Fs)(nil)
-	_ fs.ListRer         = (*Fs)(nil)
-	_ fs.Abouter         = (*Fs)(nil)
-	_ fs.Wrapper         = (*Fs)(nil)
-	_ fs.MergeDirser     = (*Fs)(nil)
-	_ fs.DirSetModTimer  = (*Fs)(nil)
-	_ fs.MkdirMetadataer = (*Fs)(nil)
-	_ fs.DirCacheFlusher = (*Fs)(nil)
-	_ fs.ChangeNotifier  = (*Fs)(nil)
-	_ fs.PublicLinker    = (*Fs)(nil)
-	_ fs.UserInfoer      = (*Fs)(nil)
-	_ fs.Disconnecter    = (*Fs)(nil)
-	_ fs.Shutdowner      = (*Fs)(nil)
-	_ fs.FullObject      = (*Object)(nil)
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.Commander        = (*Fs)(nil)
+	_ fs.PutUncheckeder   = (*Fs)(nil)
+	_ fs.PutIfNotExistser = (*Fs)(nil)
+	_ fs.PutStreamer      = (*Fs)(nil)
+	_ fs.CleanUpper       = (*Fs)(nil)
+	_ fs.UnWrapper        = (*Fs)(nil)
+	_ fs.ListRer          = (*Fs)(nil)
+	_ fs.Abouter          = (*Fs)(nil)
+	_ fs.Wrapper          = (*Fs)(nil)
+	_ fs.MergeDirser      = (*Fs)(nil)
+	_ fs.DirSetModTimer   = (*Fs)(nil)
+	_ fs.MkdirMetadataer  = (*Fs)(nil)
+	_ fs.DirCacheFlusher  = (*Fs)(nil)
+	_ fs.ChangeNotifier   = (*Fs)(nil)
+	_ fs.PublicLinker     = (*Fs)(nil)
+	_ fs.UserInfoer       = (*Fs)(nil)
+	_ fs.Disconnecter     = (*Fs)(nil)
+	_ fs.Shutdowner       = (*Fs)(nil)
+	_ fs.FullObject       = (*Object)(nil)
 )
@@ -1,13 +1,17 @@
 package hasher
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
@@ -69,12 +73,71 @@ func (f *Fs) testUploadFromCrypt(t *testing.T) {
 	_ = operations.Purge(ctx, f, dirName)
 }
 
+func (f *Fs) testScrub(t *testing.T) {
+	if f.db == nil {
+		t.Skip("cache is disabled")
+	}
+	ctx := context.Background()
+	const fileName = "scrub_1/file"
+	o := putFile(ctx, t, f, fileName, "potato salad").(*Object)
+	defer func() {
+		_ = operations.Purge(ctx, f, "scrub_1")
+	}()
+
+	scrub := func(opt scrubOpt) *scrubReport {
+		report := newScrubReport()
+		f.scrubObject(ctx, o, opt, report)
+		report.finish()
+		return report
+	}
+
+	// Missing hashes are computed
+	_ = f.pruneHash(fileName)
+	report := scrub(scrubOpt{})
+	assert.Equal(t, 1, report.Hashed)
+	hashType := f.keepHashes.GetOne()
+	hash, err := o.getHash(ctx, hashType)
+	require.NoError(t, err)
+	assert.NotEmpty(t, hash)
+
+	// Then skipped unless verifying
+	report = scrub(scrubOpt{})
+	assert.Equal(t, 1, report.Skipped)
+	report = scrub(scrubOpt{verify: true, older: time.Hour})
+	assert.Equal(t, 1, report.Skipped)
+	report = scrub(scrubOpt{verify: true})
+	assert.Equal(t, 1, report.Verified)
+	assert.Empty(t, report.Corrupted)
+
+	// Change the content without changing the fingerprint
+	item := fstest.Item{Path: fileName, ModTime: o.ModTime(ctx)}
+	in := bytes.NewBufferString("potato SALAD")
+	_, err = f.Fs.Put(ctx, in, object.NewStaticObjectInfo(item.Path, item.ModTime, int64(in.Len()), true, nil, nil))
+	require.NoError(t, err)
+	report = scrub(scrubOpt{verify: true})
+	require.Len(t, report.Corrupted, 1)
+	assert.Equal(t, fileName, report.Corrupted[0].Remote)
+	assert.Equal(t, hash, report.Corrupted[0].Expected[hashType.String()])
+	assert.NotEqual(t, hash, report.Corrupted[0].Actual[hashType.String()])
+
+	// The stored hash is kept
+	got, err := o.getHash(ctx, hashType)
+	require.NoError(t, err)
+	assert.Equal(t, hash, got)
+
+	// The report is JSON
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"corrupted":[{"remote":"scrub_1/file"`)
+}
+
 // InternalTest dispatches all internal tests
 func (f *Fs) InternalTest(t *testing.T) {
 	if !kv.Supported() {
 		t.Skip("hasher is not supported on this OS")
 	}
 	t.Run("UploadFromCrypt", f.testUploadFromCrypt)
+	t.Run("Scrub", f.testScrub)
 }
 
 var _ fstests.InternalTester = (*Fs)(nil)
@@ -24,9 +24,18 @@ const (
 type hashMap map[hash.Type]string
 
 type hashRecord struct {
-	Fp      string // fingerprint
-	Hashes  operations.HashSums
-	Created time.Time
+	Fp       string // fingerprint
+	Hashes   operations.HashSums
+	Created  time.Time
+	Verified time.Time // last time the hashes were checked against the content
+}
+
+// lastVerified returns when the hashes were last known to match the content
+func (r *hashRecord) lastVerified() time.Time {
+	if r.Verified.After(r.Created) {
+		return r.Verified
+	}
+	return r.Created
 }
 
 func (r *hashRecord) encode(key string) ([]byte, error) {
@@ -192,6 +201,7 @@ func (op *kvPut) Do(ctx context.Context, b kv.Bucket) (err error) {
 	}
 	if len(r.Hashes) == 0 {
 		r.Created = time.Now()
+		r.Verified = time.Time{}
 		r.Hashes = operations.HashSums{}
 		r.Fp = op.fp
 	}
@@ -206,6 +216,54 @@ func (op *kvPut) Do(ctx context.Context, b kv.Bucket) (err error) {
 	return err
 }
 
+// kvRecord: get the whole record for a key, nil if none or invalid
+type kvRecord struct {
+	key string
+	r   *hashRecord
+}
+
+func (op *kvRecord) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return nil
+	}
+	var r hashRecord
+	if err := r.decode(op.key, data); err != nil {
+		return nil
+	}
+	op.r = &r
+	return nil
+}
+
+// kvVerified: mark the hashes of a record as verified
+type kvVerified struct {
+	key  string
+	fp   string
+	when time.Time
+}
+
+func (op *kvVerified) Do(ctx context.Context, b kv.Bucket) (err error) {
+	data := b.Get([]byte(op.key))
+	if len(data) == 0 {
+		return errors.New("no record")
+	}
+	var r hashRecord
+	if err = r.decode(op.key, data); err != nil {
+		return errors.New("invalid record")
+	}
+	if r.Fp != op.fp {
+		return errors.New("fingerprint changed")
+	}
+	r.Verified = op.when
+	if data, err = r.encode(op.key); err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	if err = b.Put([]byte(op.key), data); err != nil {
+		return fmt.Errorf("put failed: %w", err)
+	}
+	return nil
+}
+
 // kvDump: dump the database.
 // Note: long dump can cause concurrent operations to fail.
 type kvDump struct {
@@ -0,0 +1,309 @@
+package hasher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/sync/errgroup"
+)
+
+// scrubStartDelay is how long to wait before the first background
+// scrub so short lived commands don't start one
+const scrubStartDelay = time.Minute
+
+// scrubOpt controls a pass over the files
+type scrubOpt struct {
+	verify bool          // re-read files with stored hashes to verify them
+	older  time.Duration // only verify hashes not verified for this long
+}
+
+// scrubEntry describes a file which failed to hash or verify
+type scrubEntry struct {
+	Remote   string              `json:"remote"`
+	Expected operations.HashSums `json:"expected,omitempty"`
+	Actual   operations.HashSums `json:"actual,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// scrubReport is the result of a pass over the files
+type scrubReport struct {
+	mu        sync.Mutex
+	Started   time.Time    `json:"started"`
+	Finished  time.Time    `json:"finished"`
+	Files     int          `json:"files"`     // files looked at
+	Hashed    int          `json:"hashed"`    // files with missing or outdated hashes stored
+	Verified  int          `json:"verified"`  // files whose content matched the stored hashes
+	Skipped   int          `json:"skipped"`   // files with hashes not due to be verified
+	Corrupted []scrubEntry `json:"corrupted"` // files whose content doesn't match the stored hashes
+	Errors    []scrubEntry `json:"errors"`    // files which couldn't be read
+}
+
+func newScrubReport() *scrubReport {
+	return &scrubReport{
+		Started:   time.Now(),
+		Corrupted: []scrubEntry{},
+		Errors:    []scrubEntry{},
+	}
+}
+
+// add runs fn with the report locked
+func (r *scrubReport) add(fn func(r *scrubReport)) {
+	r.mu.Lock()
+	fn(r)
+	r.mu.Unlock()
+}
+
+// finish sorts the entries and stamps the report
+func (r *scrubReport) finish() {
+	for _, entries := range [][]scrubEntry{r.Corrupted, r.Errors} {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Remote < entries[j].Remote
+		})
+	}
+	r.Finished = time.Now()
+}
+
+// String returns a one line summary of the report
+func (r *scrubReport) String() string {
+	return fmt.Sprintf("%d files: %d hashed, %d verified, %d skipped, %d corrupted, %d errors",
+		r.Files, r.Hashed, r.Verified, r.Skipped, len(r.Corrupted), len(r.Errors))
+}
+
+// scrub walks the files computing missing hashes and optionally
+// verifying the stored ones against the content
+//
+// Files are read in parallel up to --checkers at once.
+func (f *Fs) scrub(ctx context.Context, opt scrubOpt) (*scrubReport, error) {
+	if f.db == nil {
+		return nil, errors.New("hash cache is disabled by max_age = 0")
+	}
+	report := newScrubReport()
+	var g errgroup.Group
+	g.SetLimit(max(fs.GetConfig(ctx).Checkers, 1))
+	err := operations.ListFn(ctx, f, func(obj fs.Object) {
+		o, ok := obj.(*Object)
+		if !ok || ctx.Err() != nil {
+			return
+		}
+		g.Go(func() error {
+			f.scrubObject(ctx, o, opt, report)
+			return nil
+		})
+	})
+	_ = g.Wait()
+	report.finish()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return report, err
+}
+
+// scrubObject computes the missing hashes of o and verifies the
+// stored ones if they are due
+func (f *Fs) scrubObject(ctx context.Context, o *Object, opt scrubOpt, report *scrubReport) {
+	report.add(func(r *scrubReport) { r.Files++ })
+	fp := o.fingerprint(ctx)
+	if fp == "" {
+		f.scrubError(o, errors.New("fingerprint failed"), report)
+		return
+	}
+	key := path.Join(f.Fs.Root(), o.Remote())
+	op := &kvRecord{key: key}
+	if err := f.db.Do(false, op); err != nil && err != kv.ErrEmpty {
+		f.scrubError(o, err, report)
+		return
+	}
+
+	// Work out whether the stored hashes belong to this content
+	var stored operations.HashSums
+	complete := false
+	if r := op.r; r != nil && (r.Fp == anyFingerprint || r.Fp == fp) && time.Since(r.Created) <= time.Duration(f.opt.MaxAge) {
+		stored = r.Hashes
+		complete = true
+		for _, ht := range f.keepHashes.Array() {
+			if stored[ht.String()] == "" {
+				complete = false
+			}
+		}
+		if complete && (!opt.verify || time.Since(r.lastVerified()) < opt.older) {
+			report.add(func(r *scrubReport) { r.Skipped++ })
+			return
+		}
+	}
+
+	what := "hashing"
+	if complete {
+		what = "verifying"
+	}
+	tr := accounting.Stats(ctx).NewCheckingTransfer(o, what)
+	sums, err := f.hashContent(ctx, tr, o)
+	if err != nil {
+		tr.Done(ctx, err)
+		f.scrubError(o, err, report)
+		return
+	}
+
+	var mismatched []string
+	for name, want := range stored {
+		if got, ok := sums[name]; ok && want != "" && got != want {
+			mismatched = append(mismatched, name)
+		}
+	}
+	if len(mismatched) > 0 && f.changedSince(ctx, o, fp) {
+		// A legitimate update raced with reading the file
+		tr.Done(ctx, nil)
+		fs.Infof(o, "changed while being verified - skipping")
+		report.add(func(r *scrubReport) { r.Skipped++ })
+		return
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		err = fmt.Errorf("content doesn't match stored %s hash - possible corruption", strings.Join(mismatched, ", "))
+		fs.Errorf(o, "%v", err)
+		tr.Done(ctx, err)
+		report.add(func(r *scrubReport) {
+			r.Corrupted = append(r.Corrupted, scrubEntry{
+				Remote:   o.Remote(),
+				Expected: stored,
+				Actual:   sums,
+			})
+		})
+		return
+	}
+
+	if complete {
+		err = f.db.Do(true, &kvVerified{key: key, fp: op.r.Fp, when: time.Now()})
+	} else {
+		err = f.putRawHashes(ctx, key, fp, sums)
+	}
+	tr.Done(ctx, err)
+	if err != nil {
+		f.scrubError(o, err, report)
+		return
+	}
+	report.add(func(r *scrubReport) {
+		if complete {
+			r.Verified++
+		} else {
+			r.Hashed++
+		}
+	})
+}
+
+// scrubError notes err in the report
+func (f *Fs) scrubError(o *Object, err error, report *scrubReport) {
+	fs.Errorf(o, "scrub failed: %v", err)
+	report.add(func(r *scrubReport) {
+		r.Errors = append(r.Errors, scrubEntry{
+			Remote: o.Remote(),
+			Error:  err.Error(),
+		})
+	})
+}
+
+// hashContent reads the content of o returning the hashes to keep
+//
+// The base object is read so the hashes aren't stored as a side effect.
+func (f *Fs) hashContent(ctx context.Context, tr *accounting.Transfer, o *Object) (sums operations.HashSums, err error) {
+	in, err := operations.Open(ctx, o.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	acc := tr.Account(ctx, in).WithBuffer()
+	defer fs.CheckClose(acc, &err)
+	hashes, err := hash.StreamTypes(acc, f.keepHashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+	sums = operations.HashSums{}
+	for ht, sum := range hashes {
+		sums[ht.String()] = sum
+	}
+	return sums, nil
+}
+
+// changedSince returns true if o no longer has the fingerprint fp
+func (f *Fs) changedSince(ctx context.Context, o *Object, fp string) bool {
+	obj, err := f.NewObject(ctx, o.Remote())
+	if err != nil {
+		return true
+	}
+	return obj.(*Object).fingerprint(ctx) != fp
+}
+
+// startScrubber starts scrubbing in the background every scrub_interval
+func (f *Fs) startScrubber(ctx context.Context) {
+	ctx, f.scrubCancel = context.WithCancel(context.WithoutCancel(ctx))
+	f.scrubDone = make(chan struct{})
+	go f.scrubber(ctx)
+}
+
+// stopScrubber stops any background scrubbing and waits for it to finish
+func (f *Fs) stopScrubber() {
+	if f.scrubCancel == nil {
+		return
+	}
+	f.scrubCancel()
+	<-f.scrubDone
+	f.scrubCancel = nil
+}
+
+// scrubber runs background scrubs until ctx is cancelled
+//
+// Hashes not verified for half the interval are verified so that
+// each file is verified about once per interval.
+func (f *Fs) scrubber(ctx context.Context) {
+	defer close(f.scrubDone)
+	interval := time.Duration(f.opt.ScrubInterval)
+	timer := time.NewTimer(scrubStartDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		fs.Infof(f, "Background scrub starting")
+		report, err := f.scrub(ctx, scrubOpt{verify: true, older: interval / 2})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fs.Errorf(f, "Background scrub failed: %v", err)
+		}
+		fs.Infof(f, "Background scrub finished: %v", report)
+		if err := f.writeScrubReport(report); err != nil {
+			fs.Errorf(f, "Failed to write scrub report: %v", err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// writeScrubReport writes the report as JSON to scrub_report if set
+func (f *Fs) writeScrubReport(report *scrubReport) error {
+	if f.opt.ScrubReport == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := f.opt.ScrubReport + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.opt.ScrubReport)
+}
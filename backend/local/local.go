@@ -758,6 +758,18 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	return o, nil
 }
 
+// PutIfNotExists uploads the Object to the local filesystem only if
+// there isn't a file there already, returning fs.ErrorObjectExists if
+// there is.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	err := o.update(ctx, in, src, os.O_EXCL, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	return f.Put(ctx, in, src, options...)
@@ -1399,6 +1411,13 @@ func (nwc nopWriterCloser) Close() error {
 
 // Update the object from in with modTime and size
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (err error) {
+	return o.update(ctx, in, src, 0, options...)
+}
+
+// update the object from in, opening the file with the extra flags
+// given which may be os.O_EXCL to refuse to overwrite an existing
+// file
+func (o *Object) update(ctx context.Context, in io.Reader, src fs.ObjectInfo, flags int, options ...fs.OpenOption) (err error) {
 	var out io.WriteCloser
 	var hasher *hash.MultiHasher
 
@@ -1426,9 +1445,17 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 	// If the object is a regular file, create it.
 	// If it is a translated link, just read in the contents, and
 	// then create a symlink
+	if flags&os.O_EXCL != 0 && o.translatedLink {
+		if _, err := os.Lstat(o.path); err == nil {
+			return fs.ErrorObjectExists
+		}
+	}
 	if !o.translatedLink {
-		f, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		f, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|flags, 0666)
 		if err != nil {
+			if os.IsExist(err) {
+				return fs.ErrorObjectExists
+			}
 			if runtime.GOOS == "windows" && os.IsPermission(err) {
 				// If permission denied on Windows might be trying to update a
 				// hidden file, in which case try opening without CREATE
@@ -1530,6 +1557,18 @@ var sparseWarning sync.Once
 //
 // It truncates any existing object
 func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	return f.openWriterAt(ctx, remote, size, os.O_TRUNC)
+}
+
+// ResumeWriterAt opens a file previously opened with OpenWriterAt
+// without truncating it so a multi-thread copy can be resumed.
+func (f *Fs) ResumeWriterAt(ctx context.Context, remote string, size int64) (fs.WriterAtCloser, error) {
+	return f.openWriterAt(ctx, remote, size, 0)
+}
+
+// openWriterAt opens remote for random access writes with the extra
+// flags passed in
+func (f *Fs) openWriterAt(ctx context.Context, remote string, size int64, flags int) (fs.WriterAtCloser, error) {
 	// Temporary Object under construction
 	o := f.newObject(remote)
 
@@ -1542,7 +1581,7 @@ func (f *Fs) OpenWriterAt(ctx context.Context, remote string, size int64) (fs.Wr
 		return nil, errors.New("can't open a symlink for random writing")
 	}
 
-	out, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	out, err := file.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|flags, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -1735,18 +1774,20 @@ func (d *Directory) Hash() {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = &Fs{}
-	_ fs.PutStreamer     = &Fs{}
-	_ fs.Mover           = &Fs{}
-	_ fs.DirMover        = &Fs{}
-	_ fs.Commander       = &Fs{}
-	_ fs.OpenWriterAter  = &Fs{}
-	_ fs.DirSetModTimer  = &Fs{}
-	_ fs.MkdirMetadataer = &Fs{}
-	_ fs.Object          = &Object{}
-	_ fs.Metadataer      = &Object{}
-	_ fs.SetMetadataer   = &Object{}
-	_ fs.Directory       = &Directory{}
-	_ fs.SetModTimer     = &Directory{}
-	_ fs.SetMetadataer   = &Directory{}
+	_ fs.Fs               = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.PutIfNotExistser = &Fs{}
+	_ fs.Mover            = &Fs{}
+	_ fs.DirMover         = &Fs{}
+	_ fs.Commander        = &Fs{}
+	_ fs.OpenWriterAter   = &Fs{}
+	_ fs.WriterAtResumer  = &Fs{}
+	_ fs.DirSetModTimer   = &Fs{}
+	_ fs.MkdirMetadataer  = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.Metadataer       = &Object{}
+	_ fs.SetMetadataer    = &Object{}
+	_ fs.Directory        = &Directory{}
+	_ fs.SetModTimer      = &Directory{}
+	_ fs.SetMetadataer    = &Directory{}
 )
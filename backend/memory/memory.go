@@ -115,6 +115,19 @@ func (bi *bucketsInfo) updateObjectData(bucketName, bucketPath string, od *objec
 	b.mu.Unlock()
 }
 
+// createObjectData sets the object data at (bucketName, bucketPath)
+// only if there is no object there, returning true if it was set
+func (bi *bucketsInfo) createObjectData(bucketName, bucketPath string, od *objectData) (created bool) {
+	b := bi.makeBucket(bucketName)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.objects[bucketPath] != nil {
+		return false
+	}
+	b.objects[bucketPath] = od
+	return true
+}
+
 // removeObjectData removes an object from (bucketName, bucketPath) returning true if removed
 func (bi *bucketsInfo) removeObjectData(bucketName, bucketPath string) (removed bool) {
 	b := bi.getBucket(bucketName)
@@ -473,6 +486,30 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	return fs, fs.Update(ctx, in, src, options...)
 }
 
+// PutIfNotExists puts the object into the bucket only if there isn't
+// an object there already, returning fs.ErrorObjectExists if there
+// is.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory object: %w", err)
+	}
+	o.od = &objectData{
+		data:     data,
+		modTime:  src.ModTime(ctx),
+		mimeType: fs.MimeType(ctx, src),
+	}
+	bucket, bucketPath := o.split()
+	if !buckets.createObjectData(bucket, bucketPath, o.od) {
+		return nil, fs.ErrorObjectExists
+	}
+	return o, nil
+}
+
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	return f.Put(ctx, in, src, options...)
@@ -655,11 +692,12 @@ func (o *Object) MimeType(ctx context.Context) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs          = &Fs{}
-	_ fs.Copier      = &Fs{}
-	_ fs.PutStreamer = &Fs{}
-	_ fs.ListRer     = &Fs{}
-	_ fs.ListPer     = &Fs{}
-	_ fs.Object      = &Object{}
-	_ fs.MimeTyper   = &Object{}
+	_ fs.Fs               = &Fs{}
+	_ fs.Copier           = &Fs{}
+	_ fs.PutStreamer      = &Fs{}
+	_ fs.PutIfNotExistser = &Fs{}
+	_ fs.ListRer          = &Fs{}
+	_ fs.ListPer          = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.MimeTyper        = &Object{}
 )
@@ -0,0 +1,244 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "heal",
+	Short: "Bring the copies of the files on the upstreams back in sync.",
+	Long: `This compares the copies of every file in the path given, or the
+whole remote, on all the upstreams. Any copies which are missing or
+differ from the newest copy are copied again from the newest copy.
+
+Usage Example:
+
+` + "```console" + `
+rclone backend heal mirror:
+rclone backend heal mirror:path/to/dir
+rclone backend heal mirror: -o check
+` + "```" + `
+
+The copies are compared by size and then by hash if the upstreams
+have a hash in common, otherwise by modification time. Use the
+` + "`--size-only`" + ` flag to compare by size only.
+
+Directories which are missing on some of the upstreams are made too.
+
+With the ` + "`check`" + ` option the files which are out of sync are
+reported but not copied.
+
+The output is a JSON object with the number of files checked and in
+sync and lists of the files which were out of sync, healed or which
+failed to heal.`,
+	Opts: map[string]string{
+		"check": "Only report the files which are out of sync",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	switch name {
+	case "heal":
+		dir := ""
+		if len(arg) > 0 {
+			dir = strings.Trim(arg[0], "/")
+		}
+		_, check := opt["check"]
+		return f.heal(ctx, dir, check)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// healResult is the output of the heal command
+type healResult struct {
+	Checked   int      `json:"checked"`
+	InSync    int      `json:"in_sync"`
+	OutOfSync []string `json:"out_of_sync"`
+	Healed    []string `json:"healed"`
+	Failed    []string `json:"failed"`
+}
+
+// heal brings the replicas of the objects in dir back in sync
+func (f *Fs) heal(ctx context.Context, dir string, check bool) (*healResult, error) {
+	// List the upstreams directly so replicas missing from some
+	// of them can be found
+	var mu sync.Mutex
+	objects := map[string]*Object{}
+	dirs := map[string][]bool{}
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return walk.ListR(ctx, u, dir, true, -1, walk.ListAll, func(entries fs.DirEntries) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, entry := range entries {
+				remote := entry.Remote()
+				switch x := entry.(type) {
+				case fs.Directory:
+					if dirs[remote] == nil {
+						dirs[remote] = make([]bool, len(f.upstreams))
+					}
+					dirs[remote][i] = true
+				case fs.Object:
+					o := objects[remote]
+					if o == nil {
+						o = f.newObject(remote)
+						objects[remote] = o
+					}
+					o.replicas[i] = x
+				}
+			}
+			return nil
+		})
+	})
+	// All the upstreams need listing to know what is missing
+	err := f.checkErrors("heal list", errs, 0, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Make the missing directories
+	if !check {
+		for remote, present := range dirs {
+			for i, ok := range present {
+				if !ok {
+					if err := operations.Mkdir(ctx, f.upstreams[i], remote); err != nil {
+						fs.Errorf(f.upstreams[i], "Heal: failed to make directory %q: %v", remote, err)
+					}
+				}
+			}
+		}
+	}
+
+	result := &healResult{
+		OutOfSync: []string{},
+		Healed:    []string{},
+		Failed:    []string{},
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	for _, o := range objects {
+		g.Go(func() error {
+			stale := o.stale(gCtx)
+			var err error
+			if len(stale) > 0 && !check {
+				err = o.heal(gCtx, stale)
+				if err != nil {
+					fs.Errorf(o, "Heal: %v", err)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checked++
+			switch {
+			case len(stale) == 0:
+				result.InSync++
+			case check:
+				result.OutOfSync = append(result.OutOfSync, o.remote)
+			case err != nil:
+				result.Failed = append(result.Failed, o.remote)
+			default:
+				result.Healed = append(result.Healed, o.remote)
+			}
+			return gCtx.Err()
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(result.OutOfSync)
+	slices.Sort(result.Healed)
+	slices.Sort(result.Failed)
+	return result, nil
+}
+
+// stale returns the indexes of the replicas which are missing or
+// differ from the newest replica
+func (o *Object) stale(ctx context.Context) (stale []int) {
+	o.init(ctx)
+	src := o.replicas[o.primary]
+	for i, replica := range o.replicas {
+		if i == o.primary {
+			continue
+		}
+		if replica == nil {
+			fs.Infof(o, "Heal: missing on %v", o.f.upstreams[i])
+			stale = append(stale, i)
+			continue
+		}
+		equal, err := o.f.sameReplica(ctx, src, replica)
+		if err != nil {
+			fs.Errorf(replica, "Heal: failed to compare: %v", err)
+		}
+		if !equal {
+			fs.Infof(o, "Heal: out of date on %v", o.f.upstreams[i])
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// sameReplica returns true if src and dst have the same contents
+//
+// They are compared by size, then hash if available, otherwise
+// modification time.
+func (f *Fs) sameReplica(ctx context.Context, src, dst fs.Object) (bool, error) {
+	if src.Size() != dst.Size() {
+		return false, nil
+	}
+	if fs.GetConfig(ctx).SizeOnly {
+		return true, nil
+	}
+	equal, ht, err := operations.CheckHashes(ctx, src, dst)
+	if err != nil {
+		return false, err
+	}
+	if ht != hash.None {
+		return equal, nil
+	}
+	window := fs.GetModifyWindow(ctx, src.Fs(), dst.Fs())
+	if window == fs.ModTimeNotSupported {
+		return true, nil
+	}
+	dt := dst.ModTime(ctx).Sub(src.ModTime(ctx))
+	return dt >= -window && dt <= window, nil
+}
+
+// heal copies the newest replica over the stale replicas
+func (o *Object) heal(ctx context.Context, stale []int) error {
+	src := o.replicas[o.primary]
+	var errs []error
+	for _, i := range stale {
+		replica, err := operations.Copy(ctx, o.f.upstreams[i], o.replicas[i], o.remote, src)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to copy to %v: %w", o.f.upstreams[i], err))
+			continue
+		}
+		if replica != nil {
+			o.replicas[i] = replica
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package mirror
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// health keeps track of how quickly the upstreams respond to reads
+// and which of them have failed recently
+type health struct {
+	mu       sync.Mutex
+	timeout  time.Duration // how long to avoid an upstream after it fails
+	latency  []time.Duration
+	failedAt []time.Time
+}
+
+// newHealth makes a health tracker for n upstreams
+func newHealth(n int, timeout time.Duration) *health {
+	return &health{
+		timeout:  timeout,
+		latency:  make([]time.Duration, n),
+		failedAt: make([]time.Time, n),
+	}
+}
+
+// ok records that upstream i responded in latency
+func (h *health) ok(i int, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.latency[i] == 0 {
+		h.latency[i] = latency
+	} else {
+		// Exponentially weighted moving average
+		h.latency[i] = (3*h.latency[i] + latency) / 4
+	}
+	h.failedAt[i] = time.Time{}
+}
+
+// fail records that upstream i failed
+func (h *health) fail(i int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failedAt[i] = time.Now()
+}
+
+// healthy returns true if upstream i hasn't failed recently
+//
+// Call with the lock held.
+func (h *health) healthy(i int) bool {
+	return h.failedAt[i].IsZero() || time.Since(h.failedAt[i]) >= h.timeout
+}
+
+// order sorts the upstreams in which into the order they should be
+// tried for reading.
+//
+// Upstreams which haven't failed recently come first, fastest first.
+// Upstreams which haven't been read from yet count as fastest so they
+// get measured.
+func (h *health) order(which []int) []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	which = slices.Clone(which)
+	slices.SortStableFunc(which, func(a, b int) int {
+		aHealthy, bHealthy := h.healthy(a), h.healthy(b)
+		switch {
+		case aHealthy && !bHealthy:
+			return -1
+		case !aHealthy && bHealthy:
+			return 1
+		case !aHealthy:
+			// Try the one which failed longest ago first
+			return h.failedAt[a].Compare(h.failedAt[b])
+		}
+		return cmp.Compare(h.latency[a], h.latency[b])
+	})
+	return which
+}
@@ -0,0 +1,636 @@
+// Package mirror implements a backend which keeps a copy of each
+// object on several remotes
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"golang.org/x/sync/errgroup"
+)
+
+func init() {
+	fsi := &fs.RegInfo{
+		Name:        "mirror",
+		Description: "Keep a copy of each file on several remotes",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Every file is written to all of the upstreams and read from the
+fastest one which is working.
+
+Can be 'upstreama:test/dir upstreamb:', '"upstreama:test/space:ro dir" upstreamb:', etc.`,
+			Required: true,
+			Default:  fs.SpaceSepList(nil),
+		}, {
+			Name: "min_writes",
+			Help: `Number of upstreams a write must succeed on.
+
+By default a write must succeed on all the upstreams, otherwise an
+error is returned.
+
+If this is set lower, writes which succeed on at least this many
+upstreams succeed. The upstreams which failed are left without a copy
+of the file and a message is logged. Run the heal command to make the
+missing copies.
+
+0 means all the upstreams.`,
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name: "failure_timeout",
+			Help: `How long to avoid reading from an upstream after it fails.
+
+After a read from an upstream fails, the others are preferred for
+reads for this long, after which it is tried again.`,
+			Default:  fs.Duration(time.Minute),
+			Advanced: true,
+		}},
+	}
+	fs.Register(fsi)
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams      fs.SpaceSepList `config:"upstreams"`
+	MinWrites      int             `config:"min_writes"`
+	FailureTimeout fs.Duration     `config:"failure_timeout"`
+}
+
+// Fs represents a mirror of upstreams
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // options for this Fs
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // the upstreams in config order
+	health    *health      // read health of the upstreams
+	minWrites int          // number of upstreams writes must succeed on
+	hashes    hash.Set     // hashes supported by all the upstreams
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Upstreams) < 2 {
+		return nil, errors.New("mirror needs at least two upstreams - check the value of the upstreams setting")
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point mirror remote at itself - check the value of the upstreams setting")
+		}
+	}
+	if opt.MinWrites < 0 || opt.MinWrites > len(opt.Upstreams) {
+		return nil, fmt.Errorf("min_writes must be between 0 and %d", len(opt.Upstreams))
+	}
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		upstreams: make([]fs.Fs, len(opt.Upstreams)),
+		health:    newHealth(len(opt.Upstreams), time.Duration(opt.FailureTimeout)),
+		minWrites: opt.MinWrites,
+	}
+	if f.minWrites == 0 {
+		f.minWrites = len(f.upstreams)
+	}
+	isFile, err := f.makeUpstreams(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		// Point all the upstreams at the directory the file is in
+		f.root = path.Dir(root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+		_, err = f.makeUpstreams(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Pin the upstreams into the cache until f is finalized
+	upstreams := slices.Clone(f.upstreams)
+	for _, u := range upstreams {
+		cache.Pin(u)
+	}
+	runtime.SetFinalizer(f, func(*Fs) {
+		for _, u := range upstreams {
+			cache.Unpin(u)
+		}
+	})
+
+	f.hashes = hash.Supported()
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		ReadMimeType:            true,
+		WriteMimeType:           true,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+		PartialUploads:          true,
+	}).Fill(ctx, f)
+	canCopy, canMove, canDirMove, canAbout, canPutStream := true, true, true, true, true
+	for _, u := range f.upstreams {
+		f.features = f.features.Mask(ctx, u) // Mask all upstream fs
+		f.hashes = f.hashes.Overlap(u.Hashes())
+		features := u.Features()
+		canCopy = canCopy && features.Copy != nil
+		canMove = canMove && features.Move != nil
+		canDirMove = canDirMove && features.DirMove != nil
+		canAbout = canAbout && features.About != nil
+		canPutStream = canPutStream && features.PutStream != nil
+	}
+	// These only need all the upstreams to support them
+	if canCopy {
+		f.features.Copy = f.Copy
+	}
+	if canMove {
+		f.features.Move = f.Move
+	}
+	if canDirMove {
+		f.features.DirMove = f.DirMove
+	}
+	if canAbout {
+		f.features.About = f.About
+	}
+	if canPutStream {
+		f.features.PutStream = f.PutStream
+	}
+	f.features.Purge = f.Purge
+	f.features.Command = f.Command
+	f.features.Shutdown = f.Shutdown
+	f.features.DirCacheFlush = f.DirCacheFlush
+	// show that we wrap other backends
+	f.features.Overlay = true
+
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// makeUpstreams makes the upstream Fs pointing at root
+//
+// It returns true if root points to a file in any of them.
+func (f *Fs) makeUpstreams(ctx context.Context, root string) (isFile bool, err error) {
+	var mu sync.Mutex
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, remote := range f.opt.Upstreams {
+		g.Go(func() error {
+			u, err := cache.Get(gCtx, fspath.JoinRootPath(remote, root))
+			if err == fs.ErrorIsFile {
+				mu.Lock()
+				isFile = true
+				mu.Unlock()
+			} else if err != nil {
+				return fmt.Errorf("failed to create upstream %q: %w", remote, err)
+			}
+			f.upstreams[i] = u
+			return nil
+		})
+	}
+	return isFile, g.Wait()
+}
+
+// multithread runs fn on each upstream in parallel, returning the
+// errors from each
+func (f *Fs) multithread(ctx context.Context, fn func(ctx context.Context, i int, u fs.Fs) error) []error {
+	errs := make([]error, len(f.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range f.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(ctx, i, u)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// checkErrors returns nil if no more than maxFailed of errs are
+// errors, otherwise it returns the first error.
+//
+// Errors which satisfy ignore are not counted. They are returned
+// only if every upstream returned one.
+func (f *Fs) checkErrors(what string, errs []error, maxFailed int, ignore func(error) bool) error {
+	var firstErr, ignored error
+	failed, nIgnored := 0, 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if ignore != nil && ignore(err) {
+			ignored = err
+			nIgnored++
+			continue
+		}
+		fs.Debugf(f.upstreams[i], "%s failed: %v", what, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+	}
+	if failed > maxFailed {
+		return firstErr
+	}
+	if failed > 0 {
+		fs.Logf(f, "%s: %d of %d upstreams failed - run the heal command to bring them back in sync: %v", what, failed, len(errs), firstErr)
+	}
+	if nIgnored+failed == len(errs) {
+		if ignored != nil {
+			return ignored
+		}
+		return firstErr
+	}
+	return nil
+}
+
+// maxWriteFailures is the number of upstreams a write may fail on
+func (f *Fs) maxWriteFailures() int {
+	return len(f.upstreams) - f.minWrites
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("mirror root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Hashes returns the hashes supported by all the upstreams
+func (f *Fs) Hashes() hash.Set {
+	return f.hashes
+}
+
+// Precision is the greatest Precision of all upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatestPrecision time.Duration
+	for _, u := range f.upstreams {
+		greatestPrecision = max(greatestPrecision, u.Precision())
+	}
+	return greatestPrecision
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	lists := make([]fs.DirEntries, len(f.upstreams))
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		lists[i], err = u.List(ctx, dir)
+		return err
+	})
+	// Only one upstream needs to be working to list
+	err = f.checkErrors("list", errs, len(f.upstreams)-1, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the listings
+	dirs := map[string]fs.Directory{}
+	objects := map[string]*Object{}
+	for i, list := range lists {
+		for _, entry := range list {
+			remote := entry.Remote()
+			switch x := entry.(type) {
+			case fs.Directory:
+				if old, ok := dirs[remote]; !ok || x.ModTime(ctx).After(old.ModTime(ctx)) {
+					dirs[remote] = x
+				}
+			case fs.Object:
+				o := objects[remote]
+				if o == nil {
+					o = f.newObject(remote)
+					objects[remote] = o
+				}
+				o.replicas[i] = x
+			}
+		}
+	}
+	for remote, d := range dirs {
+		entries = append(entries, fs.NewDir(remote, d.ModTime(ctx)))
+	}
+	for _, o := range objects {
+		o.init(ctx)
+		entries = append(entries, o)
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := f.newObject(remote)
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		o.replicas[i], err = u.NewObject(ctx, remote)
+		return err
+	})
+	err := f.checkErrors("find object", errs, len(f.upstreams)-1, func(err error) bool {
+		return err == fs.ErrorObjectNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.init(ctx)
+	return o, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	return o, o.update(ctx, in, src, false, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	return o, o.update(ctx, in, src, true, options...)
+}
+
+// Mkdir makes the directory on all the upstreams
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Mkdir(ctx, dir)
+	})
+	return f.checkErrors("mkdir", errs, f.maxWriteFailures(), nil)
+}
+
+// Rmdir removes the directory on all the upstreams
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Rmdir(ctx, dir)
+	})
+	return f.checkErrors("rmdir", errs, f.maxWriteFailures(), func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// Purge all files in the directory
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		if do := u.Features().Purge; do != nil {
+			return do(ctx, dir)
+		}
+		return operations.Purge(ctx, u, dir)
+	})
+	return f.checkErrors("purge", errs, f.maxWriteFailures(), func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// replicaOp does a server-side operation on each replica of src
+// making an object at remote
+//
+// If too many of them fail then undo is called on the replicas which
+// worked.
+func (f *Fs) replicaOp(ctx context.Context, src fs.Object, remote string, what string, fail error,
+	op func(ctx context.Context, u fs.Fs, replica fs.Object, remote string) (fs.Object, error),
+	undo func(ctx context.Context, i int, replica fs.Object),
+) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok || len(srcObj.f.upstreams) != len(f.upstreams) {
+		fs.Debugf(src, "Can't %s - not same remote type", what)
+		return nil, fail
+	}
+	dst := f.newObject(remote)
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		if srcObj.replicas[i] == nil {
+			return nil
+		}
+		dst.replicas[i], err = op(ctx, u, srcObj.replicas[i], remote)
+		return err
+	})
+	err := f.checkErrors(what, errs, f.maxWriteFailures(), nil)
+	if err != nil {
+		for i, replica := range dst.replicas {
+			if replica != nil {
+				undo(ctx, i, replica)
+			}
+		}
+		return nil, err
+	}
+	dst.init(ctx)
+	if dst.primary < 0 {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return dst, nil
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.replicaOp(ctx, src, remote, "copy", fs.ErrorCantCopy,
+		func(ctx context.Context, u fs.Fs, replica fs.Object, remote string) (fs.Object, error) {
+			return u.Features().Copy(ctx, replica, remote)
+		},
+		func(ctx context.Context, i int, replica fs.Object) {
+			if err := replica.Remove(ctx); err != nil {
+				fs.Errorf(replica, "Failed to remove copied replica after failed copy: %v", err)
+			}
+		})
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	return f.replicaOp(ctx, src, remote, "move", fs.ErrorCantMove,
+		func(ctx context.Context, u fs.Fs, replica fs.Object, remote string) (fs.Object, error) {
+			return u.Features().Move(ctx, replica, remote)
+		},
+		func(ctx context.Context, i int, replica fs.Object) {
+			// Try to put the replica back where it was
+			u := srcObj.f.upstreams[i]
+			if _, err := u.Features().Move(ctx, replica, srcObj.remote); err != nil {
+				fs.Errorf(replica, "Failed to move replica back after failed move: %v", err)
+			}
+		})
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || len(srcFs.upstreams) != len(f.upstreams) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	return f.checkErrors("move directory", errs, f.maxWriteFailures(), func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// About gets quota information from the Fs
+//
+// The space used is the sum of the space used on the upstreams and
+// the free space is how big a file could be written before the
+// upstream with the least free space is full.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	usages := make([]*fs.Usage, len(f.upstreams))
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		usages[i], err = u.Features().About(ctx)
+		return err
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	usage := &fs.Usage{
+		Total: new(int64),
+		Used:  new(int64),
+		Free:  new(int64),
+	}
+	first := true
+	for _, u := range usages {
+		if u.Used != nil && usage.Used != nil {
+			*usage.Used += *u.Used
+		} else {
+			usage.Used = nil
+		}
+		if u.Total != nil && usage.Total != nil {
+			if first || *u.Total < *usage.Total {
+				*usage.Total = *u.Total
+			}
+		} else {
+			usage.Total = nil
+		}
+		if u.Free != nil && usage.Free != nil {
+			if first || *u.Free < *usage.Free {
+				*usage.Free = *u.Free
+			}
+		} else {
+			usage.Free = nil
+		}
+		first = false
+	}
+	return usage, nil
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		if do := u.Features().Shutdown; do != nil {
+			return do(ctx)
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	for _, u := range f.upstreams {
+		if do := u.Features().DirCacheFlush; do != nil {
+			do()
+		}
+	}
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+)
@@ -0,0 +1,229 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFs makes a mirror over n local directories which it returns
+func newTestFs(t *testing.T, n int) (*Fs, []string) {
+	var dirs []string
+	for range n {
+		dirs = append(dirs, t.TempDir())
+	}
+	f, err := NewFs(context.Background(), "TestMirror", "", configmap.Simple{
+		"upstreams":       strings.Join(dirs, " "),
+		"failure_timeout": "1m",
+	})
+	require.NoError(t, err)
+	return f.(*Fs), dirs
+}
+
+// put uploads random data to remote returning the data
+func put(t *testing.T, f *Fs, remote string, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(size), true, nil, nil)
+	_, err := f.Put(context.Background(), bytes.NewReader(data), src)
+	require.NoError(t, err)
+	return data
+}
+
+// read reads o with options
+func read(t *testing.T, o fs.Object, options ...fs.OpenOption) []byte {
+	in, err := o.Open(context.Background(), options...)
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return got
+}
+
+// brokenReplica is a replica which fails after reading n bytes
+type brokenReplica struct {
+	fs.Object
+	n int64
+}
+
+// Open the replica returning a reader which fails after n bytes
+func (b *brokenReplica) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	in, err := b.Object.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &brokenReader{in: in, n: b.n}, nil
+}
+
+type brokenReader struct {
+	in io.ReadCloser
+	n  int64
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, errors.New("broken")
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.in.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+func (b *brokenReader) Close() error {
+	return b.in.Close()
+}
+
+func TestHealthOrder(t *testing.T) {
+	h := newHealth(3, time.Minute)
+	assert.Equal(t, []int{0, 1, 2}, h.order([]int{0, 1, 2}))
+	h.ok(0, 30*time.Millisecond)
+	h.ok(1, 10*time.Millisecond)
+	h.ok(2, 20*time.Millisecond)
+	assert.Equal(t, []int{1, 2, 0}, h.order([]int{0, 1, 2}))
+	h.fail(1)
+	assert.Equal(t, []int{2, 0, 1}, h.order([]int{0, 1, 2}))
+	assert.Equal(t, []int{0, 1}, h.order([]int{1, 0}))
+
+	// After the timeout the upstream is tried again
+	h.failedAt[1] = time.Now().Add(-2 * time.Minute)
+	assert.Equal(t, []int{1, 2, 0}, h.order([]int{0, 1, 2}))
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 3)
+	data := put(t, f, "file", 100000)
+	obj, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	o := obj.(*Object)
+	assert.Equal(t, data, read(t, o))
+
+	// Make upstream 0 the favourite then remove its replica so
+	// opening it fails
+	f.health.latency = []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+	require.NoError(t, os.Remove(filepath.Join(dirs[0], "file")))
+	assert.Equal(t, data, read(t, o))
+	assert.False(t, f.health.failedAt[0].IsZero())
+	assert.Equal(t, []int{1, 2, 0}, f.health.order([]int{0, 1, 2}))
+
+	// Break upstream 1 part way through a read
+	o.replicas[1] = &brokenReplica{Object: o.replicas[1], n: 1000}
+	assert.Equal(t, data, read(t, o))
+	assert.Equal(t, data[500:60000], read(t, o, &fs.RangeOption{Start: 500, End: 59999}))
+	assert.Equal(t, data[99000:], read(t, o, &fs.SeekOption{Offset: 99000}))
+	assert.False(t, f.health.failedAt[1].IsZero())
+
+	// With all the replicas broken the read fails
+	o.replicas[2] = &brokenReplica{Object: o.replicas[2], n: 1000}
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	_, err = io.ReadAll(in)
+	assert.Error(t, err)
+	require.NoError(t, in.Close())
+}
+
+func TestHeal(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 3)
+	data := put(t, f, "dir/file", 1000)
+	put(t, f, "other", 100)
+	require.NoError(t, f.Mkdir(ctx, "empty"))
+
+	heal := func(opt map[string]string) *healResult {
+		out, err := f.Command(ctx, "heal", nil, opt)
+		require.NoError(t, err)
+		return out.(*healResult)
+	}
+	result := heal(nil)
+	assert.Equal(t, 2, result.Checked)
+	assert.Equal(t, 2, result.InSync)
+
+	// Lose a replica, an empty directory and make a replica out of date
+	require.NoError(t, os.Remove(filepath.Join(dirs[1], "dir", "file")))
+	require.NoError(t, os.Remove(filepath.Join(dirs[2], "empty")))
+	stale := filepath.Join(dirs[0], "other")
+	require.NoError(t, os.WriteFile(stale, []byte("out of date"), 0666))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	result = heal(map[string]string{"check": ""})
+	assert.Equal(t, []string{"dir/file", "other"}, result.OutOfSync)
+	assert.Equal(t, []string{}, result.Healed)
+	_, err := os.Stat(filepath.Join(dirs[1], "dir", "file"))
+	assert.True(t, os.IsNotExist(err))
+
+	result = heal(nil)
+	assert.Equal(t, []string{"dir/file", "other"}, result.Healed)
+	assert.Equal(t, []string{}, result.Failed)
+	got, err := os.ReadFile(filepath.Join(dirs[1], "dir", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	got, err = os.ReadFile(stale)
+	require.NoError(t, err)
+	assert.Len(t, got, 100)
+	_, err = os.Stat(filepath.Join(dirs[2], "empty"))
+	assert.NoError(t, err)
+
+	result = heal(nil)
+	assert.Equal(t, 2, result.InSync)
+
+	_, err = f.Command(ctx, "unknown", nil, nil)
+	assert.ErrorIs(t, err, fs.ErrorCommandNotFound)
+}
+
+func TestMinWrites(t *testing.T) {
+	ctx := context.Background()
+	dirs := []string{t.TempDir(), t.TempDir()}
+	// Make the second upstream unwritable by putting a file where
+	// the directory should be
+	bad := filepath.Join(dirs[1], "dir")
+	require.NoError(t, os.WriteFile(bad, []byte("not a directory"), 0666))
+	newFs := func(minWrites string) *Fs {
+		f, err := NewFs(ctx, "TestMirror", "", configmap.Simple{
+			"upstreams":  strings.Join(dirs, " "),
+			"min_writes": minWrites,
+		})
+		require.NoError(t, err)
+		return f.(*Fs)
+	}
+	src := object.NewStaticObjectInfo("dir/file", time.Now(), 5, true, nil, nil)
+
+	// By default all the writes must succeed
+	f := newFs("0")
+	_, err := f.Put(ctx, strings.NewReader("hello"), src)
+	require.Error(t, err)
+	_, err = os.Stat(filepath.Join(dirs[0], "dir", "file"))
+	assert.True(t, os.IsNotExist(err), "partial write should be removed")
+
+	// Allow one to fail
+	f = newFs("1")
+	obj, err := f.Put(ctx, strings.NewReader("hello"), src)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), read(t, obj))
+
+	// Heal it once the upstream is fixed
+	require.NoError(t, os.Remove(bad))
+	out, err := f.Command(ctx, "heal", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dir/file"}, out.(*healResult).Healed)
+	got, err := os.ReadFile(filepath.Join(dirs[1], "dir", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), got)
+}
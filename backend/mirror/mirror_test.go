@@ -0,0 +1,69 @@
+// Test Mirror filesystem interface
+package mirror_test
+
+import (
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var (
+	unimplementableFsMethods        = []string{"UnWrap", "WrapFs", "SetWrapper", "UserInfo", "Disconnect", "OpenChunkWriter", "ListR", "ListP", "MkdirMetadata", "ChangeNotify", "PublicLink", "PutUnchecked", "PutIfNotExists", "MergeDirs", "DirSetModTime", "CleanUp", "OpenWriterAt"}
+	unimplementableObjectMethods    = []string{"ID", "GetTier", "SetTier", "Metadata", "SetMetadata", "UnWrap"}
+	unimplementableDirectoryMethods = []string{"Metadata", "SetMetadata", "SetModTime"}
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                      *fstest.RemoteName,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := t.TempDir() + " " + t.TempDir() + " " + t.TempDir()
+	name := "TestMirrorLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "mirror"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+// TestLocalMinWrites tests with writes allowed to fail on some upstreams
+func TestLocalMinWrites(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := t.TempDir() + " " + t.TempDir()
+	name := "TestMirrorLocalMinWrites"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "mirror"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "min_writes", Value: "1"},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
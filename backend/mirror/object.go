@@ -0,0 +1,409 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Size of the buffer used to copy data to the uploads
+const bufferSize = 128 * 1024
+
+// Object describes a mirrored object
+type Object struct {
+	f        *Fs
+	remote   string
+	replicas []fs.Object // replica i is on upstream i - may be nil
+	primary  int         // index of the replica the metadata comes from or -1
+}
+
+// newObject makes an Object with no replicas
+func (f *Fs) newObject(remote string) *Object {
+	return &Object{
+		f:        f,
+		remote:   remote,
+		replicas: make([]fs.Object, len(f.upstreams)),
+		primary:  -1,
+	}
+}
+
+// init chooses the primary replica which is the newest one
+func (o *Object) init(ctx context.Context) {
+	o.primary = -1
+	var newest time.Time
+	for i, replica := range o.replicas {
+		if replica == nil {
+			continue
+		}
+		modTime := replica.ModTime(ctx)
+		if o.primary < 0 || modTime.After(newest) {
+			o.primary, newest = i, modTime
+		}
+	}
+}
+
+// readable returns the indexes of the replicas which can be read
+//
+// These are the replicas which are the same size as the primary so
+// any which are obviously out of date aren't read from.
+func (o *Object) readable() (which []int) {
+	if o.primary < 0 {
+		return nil
+	}
+	size := o.replicas[o.primary].Size()
+	for i, replica := range o.replicas {
+		if replica != nil && (size < 0 || replica.Size() == size) {
+			which = append(which, i)
+		}
+	}
+	return which
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	if o.primary < 0 {
+		return -1
+	}
+	return o.replicas[o.primary].Size()
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	if o.primary < 0 {
+		return time.Time{}
+	}
+	return o.replicas[o.primary].ModTime(ctx)
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if !o.f.hashes.Contains(ht) || o.primary < 0 {
+		return "", hash.ErrUnsupported
+	}
+	return o.replicas[o.primary].Hash(ctx, ht)
+}
+
+// MimeType returns the content type of the Object if known
+func (o *Object) MimeType(ctx context.Context) string {
+	if o.primary < 0 {
+		return ""
+	}
+	if do, ok := o.replicas[o.primary].(fs.MimeTyper); ok {
+		return do.MimeType(ctx)
+	}
+	return ""
+}
+
+// Storable says whether this object can be stored
+func (o *Object) Storable() bool {
+	return true
+}
+
+// forEachReplica runs fn on each replica in parallel returning the
+// errors for each upstream
+func (o *Object) forEachReplica(ctx context.Context, fn func(ctx context.Context, replica fs.Object) error) []error {
+	return o.f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		if o.replicas[i] == nil {
+			return nil
+		}
+		return fn(ctx, o.replicas[i])
+	})
+}
+
+// SetModTime sets the modification time of all the replicas
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	errs := o.forEachReplica(ctx, func(ctx context.Context, replica fs.Object) error {
+		return replica.SetModTime(ctx, modTime)
+	})
+	return o.f.checkErrors("set modification time", errs, o.f.maxWriteFailures(), nil)
+}
+
+// Remove all the replicas
+func (o *Object) Remove(ctx context.Context) error {
+	errs := o.forEachReplica(ctx, func(ctx context.Context, replica fs.Object) error {
+		return replica.Remove(ctx)
+	})
+	return o.f.checkErrors("remove", errs, o.f.maxWriteFailures(), func(err error) bool {
+		return errors.Is(err, fs.ErrorObjectNotFound)
+	})
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// The data is written to all the upstreams at once.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.update(ctx, in, src, src.Size() < 0, options...)
+}
+
+// update does the work of Update, using PutStream if stream is set
+func (o *Object) update(ctx context.Context, in io.Reader, src fs.ObjectInfo, stream bool, options ...fs.OpenOption) error {
+	f := o.f
+	if stream && f.features.PutStream == nil {
+		return errors.New("mirror can't upload files of unknown size as not all the upstreams support it")
+	}
+	// src may have a different name so make sure the replicas have ours
+	src = fs.NewOverrideRemote(src, o.remote)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	n := len(f.upstreams)
+	replicas := make([]fs.Object, n)
+	uploadErrs := make([]error, n)
+	writers := make([]*io.PipeWriter, n)
+	var wg sync.WaitGroup
+	for i, u := range f.upstreams {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var replica fs.Object
+			var err error
+			if stream {
+				replica, err = u.Features().PutStream(ctx, pr, src, options...)
+			} else {
+				replica, err = u.Put(ctx, pr, src, options...)
+			}
+			if err != nil {
+				uploadErrs[i] = err
+				_ = pr.CloseWithError(err)
+				return
+			}
+			// Stop the writes to this pipe if they are still going on
+			_ = pr.CloseWithError(errors.New("upload finished before all the data was read"))
+			replicas[i] = replica
+		}()
+	}
+
+	// Copy the data into all the pipes dropping any which fail
+	maxFailed := f.maxWriteFailures()
+	failed := 0
+	err := func() error {
+		buf := make([]byte, bufferSize)
+		for {
+			nRead, readErr := in.Read(buf)
+			for i, pw := range writers {
+				if pw == nil || nRead == 0 {
+					continue
+				}
+				if _, err := pw.Write(buf[:nRead]); err != nil {
+					fs.Errorf(f.upstreams[i], "Failed to upload %q: %v", o.remote, err)
+					writers[i] = nil
+					failed++
+					if failed > maxFailed {
+						return fmt.Errorf("too many uploads failed: %w", err)
+					}
+				}
+			}
+			if readErr == io.EOF {
+				return nil
+			}
+			if readErr != nil {
+				return fmt.Errorf("failed to read data to upload: %w", readErr)
+			}
+		}
+	}()
+	if err != nil {
+		// Abort the uploads
+		cancel()
+	}
+	for _, pw := range writers {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	wg.Wait()
+	if err == nil {
+		failed = 0
+		for _, uploadErr := range uploadErrs {
+			if uploadErr != nil {
+				err = uploadErr
+				failed++
+			}
+		}
+		if failed <= maxFailed {
+			err = nil
+		}
+	}
+	if err != nil {
+		if o.primary < 0 {
+			// Remove any new replicas so the object isn't
+			// created on only some of the upstreams
+			for _, replica := range replicas {
+				if replica != nil {
+					_ = replica.Remove(context.Background())
+				}
+			}
+		} else {
+			fs.Errorf(o, "Replicas may be out of sync after failed update - run the heal command to fix")
+			for i, replica := range replicas {
+				if replica != nil {
+					o.replicas[i] = replica
+				}
+			}
+			o.init(ctx)
+		}
+		return err
+	}
+
+	// Remove any old replicas where the upload failed so they can't
+	// be read instead of the new data
+	for i, replica := range replicas {
+		if replica != nil {
+			continue
+		}
+		old := o.replicas[i]
+		if old == nil {
+			old, _ = f.upstreams[i].NewObject(ctx, o.remote)
+		}
+		if old != nil {
+			if err := old.Remove(ctx); err != nil {
+				fs.Errorf(old, "Failed to remove out of date replica: %v", err)
+			}
+		}
+	}
+	if failed > 0 {
+		fs.Logf(o, "Uploaded to %d of %d upstreams - run the heal command to make the missing copies", n-failed, n)
+	}
+	o.replicas = replicas
+	o.init(ctx)
+	return nil
+}
+
+// Open an object for read
+//
+// The object is read from the fastest healthy upstream. If reading
+// from it fails, the read carries on from the next one.
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	r := &reader{
+		ctx:        ctx,
+		o:          o,
+		options:    options,
+		limit:      -1,
+		candidates: o.f.health.order(o.readable()),
+	}
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			r.offset = x.Offset
+		case *fs.RangeOption:
+			r.offset, r.limit = x.Decode(o.Size())
+		default:
+			r.other = append(r.other, option)
+		}
+	}
+	err := r.next(fs.ErrorObjectNotFound)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reader reads an object failing over between the replicas
+type reader struct {
+	ctx        context.Context
+	o          *Object
+	options    []fs.OpenOption // options the object was opened with
+	other      []fs.OpenOption // options apart from seek and range
+	offset     int64           // offset the read starts at
+	limit      int64           // number of bytes to read or -1 for all
+	done       int64           // bytes read so far
+	candidates []int           // upstreams not tried yet in order
+	i          int             // upstream being read
+	in         io.ReadCloser   // the current replica being read
+}
+
+// openOptions returns the options to open the next replica with
+//
+// This carries on from where the previous replica stopped.
+func (r *reader) openOptions() []fs.OpenOption {
+	if r.done == 0 {
+		return r.options
+	}
+	end := int64(-1)
+	if r.limit >= 0 {
+		end = r.offset + r.limit - 1
+	}
+	return append(slices.Clone(r.other), &fs.RangeOption{Start: r.offset + r.done, End: end})
+}
+
+// next opens the next replica returning lastErr if there aren't any
+func (r *reader) next(lastErr error) error {
+	f := r.o.f
+	for len(r.candidates) > 0 {
+		i := r.candidates[0]
+		r.candidates = r.candidates[1:]
+		start := time.Now()
+		in, err := r.o.replicas[i].Open(r.ctx, r.openOptions()...)
+		if err != nil {
+			if r.ctx.Err() != nil {
+				return err
+			}
+			fs.Errorf(r.o, "Failed to open on %v - trying the next upstream: %v", f.upstreams[i], err)
+			f.health.fail(i)
+			lastErr = err
+			continue
+		}
+		f.health.ok(i, time.Since(start))
+		r.i, r.in = i, in
+		return nil
+	}
+	return lastErr
+}
+
+// Read data from the object
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.in == nil {
+		return 0, io.EOF
+	}
+	n, err = r.in.Read(p)
+	r.done += int64(n)
+	if err == nil || err == io.EOF || r.ctx.Err() != nil {
+		return n, err
+	}
+	// Carry on reading from the next replica
+	f := r.o.f
+	fs.Errorf(r.o, "Failed to read from %v - trying the next upstream: %v", f.upstreams[r.i], err)
+	f.health.fail(r.i)
+	_ = r.in.Close()
+	r.in = nil
+	err = r.next(err)
+	if err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Close the reader
+func (r *reader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
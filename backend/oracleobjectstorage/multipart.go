@@ -202,13 +202,22 @@ func (w *objectChunkWriter) WriteChunk(ctx context.Context, chunkNumber int, rea
 }
 
 // add a part number and etag to the completed parts
+//
+// If the part was written before it is replaced.
 func (w *objectChunkWriter) addCompletedPart(partNum *int, eTag *string) {
 	w.partsToCommitMu.Lock()
 	defer w.partsToCommitMu.Unlock()
-	w.partsToCommit = append(w.partsToCommit, objectstorage.CommitMultipartUploadPartDetails{
+	part := objectstorage.CommitMultipartUploadPartDetails{
 		PartNum: partNum,
 		Etag:    eTag,
-	})
+	}
+	for i := range w.partsToCommit {
+		if *w.partsToCommit[i].PartNum == *partNum {
+			w.partsToCommit[i] = part
+			return
+		}
+	}
+	w.partsToCommit = append(w.partsToCommit, part)
 }
 
 func (w *objectChunkWriter) Close(ctx context.Context) (err error) {
@@ -234,9 +234,17 @@ func (w *pikpakChunkWriter) shouldRetry(ctx context.Context, err error) (bool, e
 }
 
 // add a part number and etag to the completed parts
+//
+// If the part was written before it is replaced.
 func (w *pikpakChunkWriter) addCompletedPart(part types.CompletedPart) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	for i := range w.completedParts {
+		if *w.completedParts[i].PartNumber == *part.PartNumber {
+			w.completedParts[i] = part
+			return
+		}
+	}
 	w.completedParts = append(w.completedParts, part)
 }
 
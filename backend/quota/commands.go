@@ -0,0 +1,53 @@
+package quota
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/fs"
+)
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "recount",
+	Short: "Find the space and number of files in use again.",
+	Long: `The usage is normally only found when it is first needed and then
+kept up to date as files are written and deleted through this remote.
+Files written to the underlying remote in other ways aren't noticed,
+so run this to find the usage again.
+
+Usage Example:
+
+` + "```console" + `
+rclone backend recount quota:
+` + "```" + `
+
+It returns the space and number of files in use and the limits.
+`,
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	switch name {
+	case "recount":
+		f.usage.invalidate()
+		size, objects, err := f.usage.get(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{
+			"size":        size,
+			"objects":     objects,
+			"max_size":    int64(f.opt.MaxSize),
+			"max_objects": f.opt.MaxObjects,
+		}, nil
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
@@ -0,0 +1,671 @@
+// Package quota implements a backend which limits the space and
+// number of files used on another remote
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Ways of finding the usage
+const (
+	usageCounter = "counter"
+	usageAbout   = "about"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "quota",
+		Description: "Limit the space and number of files used on another remote",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		MetadataInfo: &fs.MetadataInfo{
+			Help: `Any metadata supported by the underlying remote is read and written.`,
+		},
+		Options: []fs.Option{{
+			Name:     "remote",
+			Help:     "Remote or path to limit.\n\nCan be \"myremote:path/to/dir\", \"myremote:bucket\", \"myremote:\" or \"/local/path\".",
+			Required: true,
+		}, {
+			Name: "max_size",
+			Help: `Maximum total size of the files.
+
+Uploads which would take the total size over this fail.
+
+Use "off" for no limit.`,
+			Default: fs.SizeSuffix(-1),
+		}, {
+			Name: "max_objects",
+			Help: `Maximum number of files.
+
+Uploads of new files which would take the number of files over this
+fail.
+
+Use 0 for no limit.`,
+			Default: 0,
+		}, {
+			Name: "usage",
+			Help: `How to find the space and number of files in use.
+
+The usage is found when it is first needed and then kept up to date
+as files are written and deleted through this remote.`,
+			Default: usageCounter,
+			Examples: []fs.OptionExample{{
+				Value: usageCounter,
+				Help:  "Count the files in the wrapped remote by listing it.",
+			}, {
+				Value: usageAbout,
+				Help:  "Ask the underlying remote with About.\nThis is quick but counts the whole remote, not just the remote path.",
+			}},
+			Advanced: true,
+		}},
+	})
+}
+
+// ErrorQuotaExceeded is returned when a write would exceed the quota
+var ErrorQuotaExceeded = fserrors.NoRetryError(errors.New("quota exceeded"))
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote     string        `config:"remote"`
+	MaxSize    fs.SizeSuffix `config:"max_size"`
+	MaxObjects int64         `config:"max_objects"`
+	Usage      string        `config:"usage"`
+}
+
+// Fs represents a wrapped fs.Fs with a quota
+type Fs struct {
+	fs.Fs
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+	wrapper  fs.Fs
+	usage    *usage // shared with the other Fs made from this remote
+}
+
+// NewFs constructs an Fs from the path.
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	f := &Fs{
+		name: name,
+		root: root,
+	}
+	err := configstruct.Set(m, &f.opt)
+	if err != nil {
+		return nil, err
+	}
+	if f.opt.Remote == "" {
+		return nil, errors.New("quota can't point to an empty remote - check the value of the remote setting")
+	}
+	if strings.HasPrefix(f.opt.Remote, name+":") {
+		return nil, errors.New("can't point quota remote at itself - check the value of the remote setting")
+	}
+	switch f.opt.Usage {
+	case usageCounter, usageAbout:
+	default:
+		return nil, fmt.Errorf("unknown usage %q", f.opt.Usage)
+	}
+	baseFs, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Remote, root))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", f.opt.Remote, err)
+	}
+	f.Fs = baseFs
+	// Correct root if definitely pointing to a file
+	if err == fs.ErrorIsFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+	}
+	f.usage = getUsage(name, f.opt.Remote)
+	if f.opt.Usage == usageAbout && baseFs.Features().About == nil {
+		return nil, errors.New("usage = about needs a remote which supports about")
+	}
+	// OpenWriterAt and OpenChunkWriter aren't implemented as the
+	// size written can't be tracked
+	f.features = (&fs.Features{
+		CaseInsensitive:          true,
+		DuplicateFiles:           true,
+		ReadMimeType:             true,
+		WriteMimeType:            true,
+		ReadMetadata:             true,
+		WriteMetadata:            true,
+		UserMetadata:             true,
+		ReadDirMetadata:          true,
+		WriteDirMetadata:         true,
+		WriteDirSetModTime:       true,
+		UserDirMetadata:          true,
+		DirModTimeUpdatesOnWrite: true,
+		CanHaveEmptyDirectories:  true,
+		BucketBased:              true,
+		BucketBasedRootOK:        true,
+		SetTier:                  true,
+		GetTier:                  true,
+		SlowModTime:              true,
+		SlowHash:                 true,
+		FilterAware:              true,
+		PartialUploads:           true,
+		NoMultiThreading:         true,
+		DoubleSlash:              true,
+	}).Fill(ctx, f).Mask(ctx, baseFs).WrapsFs(f, baseFs)
+	cache.PinUntilFinalized(f.Fs, f)
+	return f, err
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.root }
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("quota %s:%s", f.name, f.root)
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set { return f.Fs.Hashes() }
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs { return f.Fs }
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs { return f.wrapper }
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) { f.wrapper = wrapper }
+
+// wrapEntries wraps the objects of the base remote
+func (f *Fs) wrapEntries(entries fs.DirEntries) fs.DirEntries {
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = f.newObject(o)
+		}
+	}
+	return entries
+}
+
+// List the objects and directories in dir into entries.
+func (f *Fs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries), nil
+}
+
+// ListR lists the objects and directories recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	return f.Fs.Features().ListR(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// ListP lists the objects and directories of the Fs starting from dir
+// non recursively calling callback for each tranche of entries read.
+func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	return f.Fs.Features().ListP(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// putFn is the signature of the base remote's upload methods
+type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
+
+// put uploads an object with put charging it to the quota
+//
+// If replace is set and the object exists, the upload replaces it so
+// only the change in size is charged, as in Object.Update.
+func (f *Fs) put(ctx context.Context, put putFn, replace bool, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (fs.Object, error) {
+	var oldSize, objects int64 = 0, 1
+	if replace {
+		oldSize, objects = f.replaced(ctx, src.Remote())
+	}
+	size := src.Size()
+	var err error
+	if size >= 0 {
+		err = f.usage.add(ctx, f, size-oldSize, objects)
+	} else {
+		in, err = f.reserve(ctx, in, -1, objects)
+	}
+	if err != nil {
+		return nil, err
+	}
+	o, err := put(ctx, in, src, options...)
+	if err != nil {
+		if size < 0 {
+			f.release(0, objects)
+			f.usage.invalidate()
+		} else {
+			f.release(size-oldSize, objects)
+		}
+		return nil, err
+	}
+	if size < 0 {
+		// Only the bytes read were charged
+		f.release(oldSize, 0)
+	} else {
+		f.release(size-o.Size(), 0)
+	}
+	return f.newObject(o), nil
+}
+
+// replaced returns the size of the object at remote which a write to
+// it replaces and the number of objects the write adds
+func (f *Fs) replaced(ctx context.Context, remote string) (oldSize, objects int64) {
+	if old, err := f.Fs.NewObject(ctx, remote); err == nil {
+		return max(old.Size(), 0), 0
+	}
+	return 0, 1
+}
+
+// Put in to the remote path with the modTime given of the given size
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, f.Fs.Put, true, in, src, options)
+}
+
+// PutStream uploads to the remote path with undeterminate size.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutStream; do != nil {
+		return f.put(ctx, do, true, in, src, options)
+	}
+	return nil, errors.New("PutStream not supported")
+}
+
+// PutUnchecked uploads the object, allowing duplicates.
+func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutUnchecked; do != nil {
+		return f.put(ctx, do, !f.Fs.Features().DuplicateFiles, in, src, options)
+	}
+	return nil, errors.New("PutUnchecked not supported")
+}
+
+// PutIfNotExists uploads the object only if it doesn't already exist.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutIfNotExists; do != nil {
+		return f.put(ctx, do, false, in, src, options)
+	}
+	return nil, errors.New("PutIfNotExists not supported")
+}
+
+// MkdirMetadata makes the directory passed in as dir.
+func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata) (fs.Directory, error) {
+	if do := f.Fs.Features().MkdirMetadata; do != nil {
+		return do(ctx, dir, metadata)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// DirSetModTime sets the directory modtime for dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	if do := f.Fs.Features().DirSetModTime; do != nil {
+		return do(ctx, dir, modTime)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// Purge all files in the directory specified
+//
+// The usage is found again afterwards.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	do := f.Fs.Features().Purge
+	if do == nil {
+		return fs.ErrorCantPurge
+	}
+	defer f.usage.invalidate()
+	return do(ctx, dir)
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// If the copy replaces an object only the change in size is charged.
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	var oldSize, objects int64 = 0, 1
+	if !f.Fs.Features().DuplicateFiles {
+		oldSize, objects = f.replaced(ctx, remote)
+	}
+	size := max(o.Size(), 0)
+	if err := f.usage.add(ctx, f, size-oldSize, objects); err != nil {
+		return nil, err
+	}
+	newObj, err := do(ctx, o.Object, remote)
+	if err != nil {
+		f.release(size-oldSize, objects)
+		return nil, err
+	}
+	return f.newObject(newObj), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// If the move replaces an object its usage is released.
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	var oldSize, objects int64 = 0, 1
+	// A change of case may find the source on case insensitive remotes
+	sameFile := o.f == f && strings.EqualFold(o.Remote(), remote)
+	if !f.Fs.Features().DuplicateFiles && !sameFile {
+		oldSize, objects = f.replaced(ctx, remote)
+	}
+	size := max(o.Size(), 0)
+	if o.f.usage != f.usage {
+		if err := f.usage.add(ctx, f, size-oldSize, objects); err != nil {
+			return nil, err
+		}
+	}
+	newObj, err := do(ctx, o.Object, remote)
+	if o.f.usage != f.usage {
+		if err != nil {
+			f.release(size-oldSize, objects)
+		} else {
+			o.f.release(size, 1)
+		}
+	} else if err == nil {
+		f.release(oldSize, 1-objects)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(newObj), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using
+// server-side move operations.
+//
+// Moving between different quota remotes means the usage of both is
+// found again afterwards.
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+	if srcFs.usage != f.usage {
+		// Check the usage can be found before moving
+		if err := f.usage.add(ctx, f, 0, 0); err != nil {
+			return err
+		}
+		defer f.usage.invalidate()
+		defer srcFs.usage.invalidate()
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// MergeDirs merges the contents of all the directories passed
+// in into the first one and rmdirs the other directories.
+func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) error {
+	if do := f.Fs.Features().MergeDirs; do != nil {
+		return do(ctx, dirs)
+	}
+	return errors.New("MergeDirs not supported")
+}
+
+// CleanUp the trash in the Fs
+func (f *Fs) CleanUp(ctx context.Context) error {
+	if do := f.Fs.Features().CleanUp; do != nil {
+		return do(ctx)
+	}
+	return errors.New("not supported by underlying remote")
+}
+
+// About gets quota information from the Fs
+//
+// This returns the usage and the limits of the quota.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	size, objects, err := f.usage.get(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	u := &fs.Usage{
+		Used:    fs.NewUsageValue(size),
+		Objects: fs.NewUsageValue(objects),
+	}
+	if f.opt.MaxSize >= 0 {
+		u.Total = fs.NewUsageValue(int64(f.opt.MaxSize))
+		u.Free = fs.NewUsageValue(max(int64(f.opt.MaxSize)-size, 0))
+	}
+	return u, nil
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.Fs.Features().ChangeNotify; do != nil {
+		do(ctx, notifyFunc, pollIntervalChan)
+	}
+}
+
+// UserInfo returns info about the connected user
+func (f *Fs) UserInfo(ctx context.Context) (map[string]string, error) {
+	if do := f.Fs.Features().UserInfo; do != nil {
+		return do(ctx)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// Disconnect the current user
+func (f *Fs) Disconnect(ctx context.Context) error {
+	if do := f.Fs.Features().Disconnect; do != nil {
+		return do(ctx)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// PublicLink generates a public link to the remote path (usually readable by anyone)
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
+	if do := f.Fs.Features().PublicLink; do != nil {
+		return do(ctx, remote, expire, unlink)
+	}
+	return "", errors.New("PublicLink not supported")
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// Shutdown the backend, closing any background tasks and any cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// Object is an object on the wrapped remote whose size is counted
+// towards the quota
+type Object struct {
+	fs.Object
+	f *Fs
+}
+
+// newObject wraps o
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{Object: o, f: f}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// Only the change in size is charged to the quota.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	oldSize, size := max(o.Object.Size(), 0), src.Size()
+	var err error
+	if size >= 0 {
+		err = o.f.usage.add(ctx, o.f, size-oldSize, 0)
+	} else {
+		in, err = o.f.reserve(ctx, in, -1, 0)
+	}
+	if err != nil {
+		return err
+	}
+	err = o.Object.Update(ctx, in, src, options...)
+	if err != nil {
+		if size < 0 {
+			o.f.usage.invalidate()
+		} else {
+			o.f.release(size-oldSize, 0)
+		}
+		return err
+	}
+	if size < 0 {
+		// Only the bytes read were charged
+		o.f.release(oldSize, 0)
+	} else {
+		o.f.release(size-o.Object.Size(), 0)
+	}
+	return nil
+}
+
+// Remove an object releasing its space
+func (o *Object) Remove(ctx context.Context) error {
+	size := o.Object.Size()
+	err := o.Object.Remove(ctx)
+	if err != nil {
+		return err
+	}
+	o.f.release(max(size, 0), 1)
+	return nil
+}
+
+// ID returns the ID of the Object if possible
+func (o *Object) ID() string {
+	if doer, ok := o.Object.(fs.IDer); ok {
+		return doer.ID()
+	}
+	return ""
+}
+
+// GetTier returns the Tier of the Object if possible
+func (o *Object) GetTier() string {
+	if doer, ok := o.Object.(fs.GetTierer); ok {
+		return doer.GetTier()
+	}
+	return ""
+}
+
+// SetTier set the Tier of the Object if possible
+func (o *Object) SetTier(tier string) error {
+	if doer, ok := o.Object.(fs.SetTierer); ok {
+		return doer.SetTier(tier)
+	}
+	return errors.New("SetTier not supported")
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if doer, ok := o.Object.(fs.MimeTyper); ok {
+		return doer.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// SetMetadata sets metadata for an Object
+//
+// It should return fs.ErrorNotImplemented if it can't set metadata
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	do, ok := o.Object.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.Commander        = (*Fs)(nil)
+	_ fs.PutUncheckeder   = (*Fs)(nil)
+	_ fs.PutIfNotExistser = (*Fs)(nil)
+	_ fs.PutStreamer      = (*Fs)(nil)
+	_ fs.CleanUpper       = (*Fs)(nil)
+	_ fs.UnWrapper        = (*Fs)(nil)
+	_ fs.ListRer          = (*Fs)(nil)
+	_ fs.ListPer          = (*Fs)(nil)
+	_ fs.Abouter          = (*Fs)(nil)
+	_ fs.Wrapper          = (*Fs)(nil)
+	_ fs.MergeDirser      = (*Fs)(nil)
+	_ fs.DirSetModTimer   = (*Fs)(nil)
+	_ fs.MkdirMetadataer  = (*Fs)(nil)
+	_ fs.DirCacheFlusher  = (*Fs)(nil)
+	_ fs.ChangeNotifier   = (*Fs)(nil)
+	_ fs.PublicLinker     = (*Fs)(nil)
+	_ fs.UserInfoer       = (*Fs)(nil)
+	_ fs.Disconnecter     = (*Fs)(nil)
+	_ fs.Shutdowner       = (*Fs)(nil)
+	_ fs.FullObject       = (*Object)(nil)
+)
@@ -0,0 +1,173 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQuota makes a quota remote on a temporary directory
+func newQuota(t *testing.T, config string) (*Fs, string) {
+	dir := t.TempDir()
+	f, err := fs.NewFs(context.Background(), ":quota,remote='"+dir+"',"+config+":")
+	require.NoError(t, err)
+	return f.(*Fs), dir
+}
+
+// put uploads data to remote on f
+func put(ctx context.Context, f fs.Fs, remote, data string) (fs.Object, error) {
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, nil)
+	return f.Put(ctx, bytes.NewBufferString(data), src)
+}
+
+func TestMaxSize(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newQuota(t, "max_size=10B")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "existing"), []byte("1234"), 0o666))
+
+	// Existing files are counted
+	_, err := put(ctx, f, "a", "1234")
+	require.NoError(t, err)
+	_, err = put(ctx, f, "b", "1234")
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+	assert.ErrorContains(t, err, "8 B of 10 B used so no room for 4 B more")
+	_, err = os.Stat(filepath.Join(dir, "b"))
+	assert.True(t, os.IsNotExist(err), "shouldn't have started the upload")
+
+	// Removing files makes room
+	o, err := f.NewObject(ctx, "existing")
+	require.NoError(t, err)
+	require.NoError(t, o.Remove(ctx))
+	b, err := put(ctx, f, "b", "1234")
+	require.NoError(t, err)
+
+	// Only growth is charged on update
+	src := object.NewStaticObjectInfo("b", time.Now(), 6, true, nil, nil)
+	require.NoError(t, b.Update(ctx, bytes.NewBufferString("123456"), src))
+	src = object.NewStaticObjectInfo("b", time.Now(), 7, true, nil, nil)
+	assert.ErrorIs(t, b.Update(ctx, bytes.NewBufferString("1234567"), src), ErrorQuotaExceeded)
+
+	// Streams of unknown size are stopped when they don't fit
+	src = object.NewStaticObjectInfo("c", time.Now(), -1, true, nil, nil)
+	_, err = f.Features().PutStream(ctx, strings.NewReader("123"), src)
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+
+	usage, err := f.About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *usage.Total)
+	assert.Equal(t, int64(10), *usage.Used)
+	assert.Equal(t, int64(0), *usage.Free)
+	assert.Equal(t, int64(2), *usage.Objects)
+}
+
+func TestOverwrite(t *testing.T) {
+	ctx := context.Background()
+	f, _ := newQuota(t, "max_size=10B")
+
+	// Uploading over a file only charges the change in size
+	for range 3 {
+		_, err := put(ctx, f, "a", "123456")
+		require.NoError(t, err)
+	}
+	_, err := f.Features().PutStream(ctx, strings.NewReader("1234"), object.NewStaticObjectInfo("a", time.Now(), -1, true, nil, nil))
+	require.NoError(t, err)
+	usage, err := f.About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *usage.Used)
+	assert.Equal(t, int64(1), *usage.Objects)
+	_, err = put(ctx, f, "a", "1234567890")
+	require.NoError(t, err)
+}
+
+func TestServerSideOverwrite(t *testing.T) {
+	ctx := context.Background()
+	checkUsage := func(f *Fs, used, objects int64) {
+		usage, err := f.About(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, used, *usage.Used)
+		assert.Equal(t, objects, *usage.Objects)
+	}
+
+	// Copying over a file only charges the change in size
+	fsys, err := fs.NewFs(ctx, ":quota,remote=':memory:quota-overwrite',max_size=12B:")
+	require.NoError(t, err)
+	f := fsys.(*Fs)
+	defer func() {
+		require.NoError(t, operations.Purge(ctx, f, ""))
+	}()
+	a, err := put(ctx, f, "a", "123456")
+	require.NoError(t, err)
+	_, err = put(ctx, f, "b", "1234")
+	require.NoError(t, err)
+	for range 3 {
+		_, err = f.Copy(ctx, a, "b")
+		require.NoError(t, err)
+	}
+	checkUsage(f, 12, 2)
+
+	// Moving over a file releases it
+	f, _ = newQuota(t, "max_size=12B")
+	_, err = put(ctx, f, "a", "123456")
+	require.NoError(t, err)
+	b, err := put(ctx, f, "b", "1234")
+	require.NoError(t, err)
+	_, err = f.Move(ctx, b, "a")
+	require.NoError(t, err)
+	checkUsage(f, 4, 1)
+}
+
+func TestSharedUsage(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newQuota(t, "max_size=10B")
+	sub, err := fs.NewFs(ctx, ":quota,remote='"+dir+"',max_size=10B:sub")
+	require.NoError(t, err)
+
+	// Writes through the sub directory count against the same quota
+	_, err = f.About(ctx)
+	require.NoError(t, err)
+	_, err = put(ctx, sub, "a", "123456")
+	require.NoError(t, err)
+	_, err = put(ctx, f, "b", "123456")
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+	usage, err := sub.Features().About(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *usage.Used)
+}
+
+func TestMaxObjects(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newQuota(t, "max_objects=2")
+	_, err := put(ctx, f, "a", "1")
+	require.NoError(t, err)
+	_, err = put(ctx, f, "b", "2")
+	require.NoError(t, err)
+	_, err = put(ctx, f, "c", "3")
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+	assert.ErrorContains(t, err, "2 of 2 files used")
+
+	// Copies are counted
+	a, err := f.NewObject(ctx, "a")
+	require.NoError(t, err)
+	_, err = operations.Copy(ctx, f, nil, "d", a)
+	assert.ErrorIs(t, err, ErrorQuotaExceeded)
+
+	// Files written behind our back are found by recount
+	require.NoError(t, os.Remove(filepath.Join(dir, "b")))
+	out, err := f.Command(ctx, "recount", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), out.(map[string]int64)["objects"])
+	_, err = operations.Copy(ctx, f, nil, "d", a)
+	require.NoError(t, err)
+}
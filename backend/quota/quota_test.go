@@ -0,0 +1,41 @@
+// Test Quota filesystem interface
+package quota_test
+
+import (
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var unimplementableFsMethods = []string{"OpenWriterAt", "OpenChunkWriter"}
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:               *fstest.RemoteName,
+		UnimplementableFsMethods: unimplementableFsMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestQuotaLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "quota"},
+			{Name: name, Key: "remote", Value: t.TempDir()},
+			{Name: name, Key: "max_size", Value: "1G"},
+			{Name: name, Key: "max_objects", Value: "1000"},
+		},
+		QuickTestOK:              true,
+		UnimplementableFsMethods: unimplementableFsMethods,
+	})
+}
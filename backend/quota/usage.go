@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// usage is the space and number of files in use
+type usage struct {
+	mu      sync.Mutex
+	loaded  bool  // set if size and objects are known
+	size    int64 // bytes in use
+	objects int64 // number of files
+}
+
+// The usage of each quota remote, shared by all the Fs made from it
+// whatever their root, so the quota covers the whole remote
+var (
+	usagesMu sync.Mutex
+	usages   = map[string]*usage{}
+)
+
+// getUsage returns the usage of the quota remote called name wrapping
+// remote
+func getUsage(name, remote string) *usage {
+	usagesMu.Lock()
+	defer usagesMu.Unlock()
+	key := name + "\x00" + remote
+	u := usages[key]
+	if u == nil {
+		u = new(usage)
+		usages[key] = u
+	}
+	return u
+}
+
+// load finds the usage if it isn't known - call with mu held
+func (u *usage) load(ctx context.Context, f *Fs) (err error) {
+	if u.loaded {
+		return nil
+	}
+	if f.opt.Usage == usageAbout {
+		u.size, u.objects, err = f.usageFromAbout(ctx)
+	} else {
+		u.size, u.objects, err = f.count(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find usage: %w", err)
+	}
+	u.loaded = true
+	fs.Debugf(f, "Using %v in %d files", fs.SizeSuffix(u.size), u.objects)
+	return nil
+}
+
+// get returns the usage finding it if necessary
+func (u *usage) get(ctx context.Context, f *Fs) (size, objects int64, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	err = u.load(ctx, f)
+	return u.size, u.objects, err
+}
+
+// add checks that size more bytes and objects more files fit in the
+// quota and adds them to the usage
+//
+// Negative values always fit.
+func (u *usage) add(ctx context.Context, f *Fs, size, objects int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.load(ctx, f); err != nil {
+		return err
+	}
+	if size > 0 && f.opt.MaxSize >= 0 && u.size+size > int64(f.opt.MaxSize) {
+		return fmt.Errorf("%w: %s of %s used so no room for %s more", ErrorQuotaExceeded, fs.SizeSuffix(u.size).ByteUnit(), f.opt.MaxSize.ByteUnit(), fs.SizeSuffix(size).ByteUnit())
+	}
+	if objects > 0 && f.opt.MaxObjects > 0 && u.objects+objects > f.opt.MaxObjects {
+		return fmt.Errorf("%w: %d of %d files used", ErrorQuotaExceeded, u.objects, f.opt.MaxObjects)
+	}
+	u.size += size
+	u.objects += objects
+	return nil
+}
+
+// invalidate makes the usage be found again when next needed
+func (u *usage) invalidate() {
+	u.mu.Lock()
+	u.loaded = false
+	u.mu.Unlock()
+}
+
+// release takes size bytes and objects files off the usage
+func (f *Fs) release(size, objects int64) {
+	f.usage.mu.Lock()
+	defer f.usage.mu.Unlock()
+	if f.usage.loaded {
+		f.usage.size -= size
+		f.usage.objects -= objects
+	}
+}
+
+// reserve charges size bytes and objects files to the quota
+//
+// If size is negative then the bytes are charged as in is read and
+// the returned reader fails if they don't fit.
+func (f *Fs) reserve(ctx context.Context, in io.Reader, size, objects int64) (io.Reader, error) {
+	if err := f.usage.add(ctx, f, max(size, 0), objects); err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		return in, nil
+	}
+	return &quotaReader{ctx: ctx, in: in, f: f}, nil
+}
+
+// quotaReader charges the bytes read to the quota
+type quotaReader struct {
+	ctx context.Context
+	in  io.Reader
+	f   *Fs
+}
+
+// Read bytes charging them to the quota
+func (r *quotaReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if addErr := r.f.usage.add(r.ctx, r.f, int64(n), 0); addErr != nil {
+			return n, addErr
+		}
+	}
+	return n, err
+}
+
+// count lists the whole of the wrapped remote, not just the root of
+// f, to find the usage
+func (f *Fs) count(ctx context.Context) (size, objects int64, err error) {
+	rootFs, err := cache.Get(ctx, f.opt.Remote)
+	if err != nil && err != fs.ErrorIsFile {
+		return 0, 0, err
+	}
+	// Filters must not hide any files
+	fi, _ := filter.NewFilter(&filter.Options{
+		MinAge:  fs.DurationOff,
+		MaxAge:  fs.DurationOff,
+		MinSize: fs.SizeSuffix(-1),
+		MaxSize: fs.SizeSuffix(-1),
+	})
+	ctx = filter.ReplaceConfig(ctx, fi)
+	err = walk.ListR(ctx, rootFs, "", true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			if o, ok := entry.(fs.Object); ok {
+				size += max(o.Size(), 0)
+				objects++
+			}
+		}
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	return size, objects, err
+}
+
+// usageFromAbout reads the usage of the whole underlying remote
+func (f *Fs) usageFromAbout(ctx context.Context) (size, objects int64, err error) {
+	u, err := f.Fs.Features().About(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if u.Used == nil {
+		return 0, 0, errors.New("remote doesn't report the space used - use usage = counter")
+	}
+	if u.Objects != nil {
+		objects = *u.Objects
+	} else if f.opt.MaxObjects > 0 {
+		return 0, 0, errors.New("remote doesn't report the number of files - use usage = counter")
+	}
+	return *u.Used, objects, nil
+}
@@ -0,0 +1,239 @@
+package raid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+var errParityMismatch = errors.New("shards don't agree with each other")
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "scrub",
+	Short: "Check the shards of the files and optionally repair them.",
+	Long: `This reads all the shards of every file in the path given, or the
+whole remote, checks that they agree with each other and reports any
+files with missing or damaged shards.
+
+Usage Example:
+
+` + "```console" + `
+rclone backend scrub raid:path/to/dir
+rclone backend scrub raid: -o repair
+rclone backend scrub raid: -o quick
+` + "```" + `
+
+Files with shards which are missing, are from an older version of the
+file or have the wrong size are reported as degraded. With the
+` + "`repair`" + ` option their shards are made again from the good shards
+and written to the upstreams, after which they are reported as
+repaired.
+
+Files which don't have enough good shards to be read are reported as
+lost. Files whose shards don't agree with each other are reported as
+corrupt - this can't be repaired automatically as it isn't known which
+shard is bad.
+
+With the ` + "`quick`" + ` option only the presence and headers of the
+shards are checked rather than reading all of them.
+
+The output is a JSON object with the number of files checked and
+healthy and lists of the files which are degraded, repaired, corrupt
+or lost.`,
+	Opts: map[string]string{
+		"repair": "Make any missing or damaged shards again",
+		"quick":  "Only check the shards are present rather than reading them",
+	},
+}}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out any, err error) {
+	switch name {
+	case "scrub":
+		dir := ""
+		if len(arg) > 0 {
+			dir = strings.Trim(arg[0], "/")
+		}
+		_, repair := opt["repair"]
+		_, quick := opt["quick"]
+		return f.scrub(ctx, dir, repair, quick)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
+// scrubResult is the output of the scrub command
+type scrubResult struct {
+	Checked  int      `json:"checked"`
+	Healthy  int      `json:"healthy"`
+	Degraded []string `json:"degraded"`
+	Repaired []string `json:"repaired"`
+	Corrupt  []string `json:"corrupt"`
+	Lost     []string `json:"lost"`
+}
+
+// scrub checks all the objects in dir
+func (f *Fs) scrub(ctx context.Context, dir string, repair, quick bool) (*scrubResult, error) {
+	// List the upstreams directly so objects without enough shards
+	// to be listed normally are found too
+	var mu sync.Mutex
+	objects := map[string]*Object{}
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return walk.ListR(ctx, u, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, entry := range entries {
+				shard, ok := entry.(fs.Object)
+				if !ok {
+					continue
+				}
+				o := objects[shard.Remote()]
+				if o == nil {
+					o = f.newObject(shard.Remote())
+					objects[shard.Remote()] = o
+				}
+				o.shards[i] = shard
+			}
+			return nil
+		})
+	})
+	err := f.checkErrors("scrub list", errs, 0, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &scrubResult{
+		Degraded: []string{},
+		Repaired: []string{},
+		Corrupt:  []string{},
+		Lost:     []string{},
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(fs.GetConfig(ctx).Checkers)
+	for _, o := range objects {
+		g.Go(func() error {
+			status, err := o.scrub(gCtx, repair, quick)
+			if err != nil {
+				fs.Errorf(o, "Scrub: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checked++
+			switch status {
+			case scrubHealthy:
+				result.Healthy++
+			case scrubDegraded:
+				result.Degraded = append(result.Degraded, o.remote)
+			case scrubRepaired:
+				result.Repaired = append(result.Repaired, o.remote)
+			case scrubCorrupt:
+				result.Corrupt = append(result.Corrupt, o.remote)
+			case scrubLost:
+				result.Lost = append(result.Lost, o.remote)
+			}
+			return gCtx.Err()
+		})
+	}
+	err = g.Wait()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(result.Degraded)
+	slices.Sort(result.Repaired)
+	slices.Sort(result.Corrupt)
+	slices.Sort(result.Lost)
+	return result, nil
+}
+
+// status of an object found by scrub
+type scrubStatus int
+
+const (
+	scrubHealthy scrubStatus = iota
+	scrubDegraded
+	scrubRepaired
+	scrubCorrupt
+	scrubLost
+)
+
+// scrub checks the shards of the object, repairing them if required
+func (o *Object) scrub(ctx context.Context, repair, quick bool) (scrubStatus, error) {
+	hdr, good, err := o.readHeaders(ctx)
+	if err != nil {
+		return scrubLost, err
+	}
+	var bad []int
+	for i, ok := range good {
+		if !ok {
+			bad = append(bad, i)
+		}
+	}
+	if len(bad) > 0 {
+		fs.Logf(o, "Scrub: shards %v are missing or bad", bad)
+	}
+	if quick && !(repair && len(bad) > 0) {
+		if len(bad) > 0 {
+			return scrubDegraded, nil
+		}
+		return scrubHealthy, nil
+	}
+
+	// Read all the good shards checking they agree
+	l := hdr.layout()
+	sr, err := newStripeReader(ctx, o, *hdr, good, 0, true)
+	if err != nil {
+		return scrubLost, err
+	}
+	defer fs.CheckClose(sr, &err)
+	if repair && len(bad) > 0 {
+		fill := func(s int64, shards [][]byte) error {
+			got, err := sr.read(true, true)
+			if err != nil {
+				return err
+			}
+			for i := range shards {
+				copy(shards[i], got[i])
+			}
+			return nil
+		}
+		_, err = o.f.writeShards(ctx, o.remote, o.ModTime(ctx), *hdr, bad, 0, fill)
+		if errors.Is(err, errParityMismatch) {
+			return scrubCorrupt, err
+		}
+		if err != nil {
+			return scrubDegraded, fmt.Errorf("failed to repair: %w", err)
+		}
+		fs.Infof(o, "Scrub: repaired shards %v", bad)
+		return scrubRepaired, nil
+	}
+	for range l.stripes() {
+		_, err = sr.read(false, true)
+		if errors.Is(err, errParityMismatch) {
+			return scrubCorrupt, err
+		}
+		if err != nil {
+			return scrubDegraded, err
+		}
+	}
+	if len(bad) > 0 {
+		return scrubDegraded, nil
+	}
+	return scrubHealthy, nil
+}
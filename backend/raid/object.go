@@ -0,0 +1,795 @@
+package raid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+	"golang.org/x/sync/errgroup"
+)
+
+var errNotEnoughShards = errors.New("not enough good shards to read object")
+
+// updateSuffix is added to the names of the shards written by Update
+// until they are moved over the old shards
+const updateSuffix = ".raid-update"
+
+// Object describes a raid object made from a shard on each upstream
+type Object struct {
+	f      *Fs
+	remote string
+	shards []fs.Object // shard i or nil if it is missing
+	size   int64       // size of the object
+
+	mu   sync.Mutex
+	hdr  *header // header of the object if read
+	good []bool  // which shards agree with hdr
+}
+
+// newObject makes an Object with no shards
+func (f *Fs) newObject(remote string) *Object {
+	return &Object{
+		f:      f,
+		remote: remote,
+		shards: make([]fs.Object, len(f.upstreams)),
+		size:   -1,
+	}
+}
+
+// init works out the size of the object from its shards
+//
+// If all the data shards are present the size is the sum of their
+// sizes, otherwise the headers need to be read.
+func (o *Object) init(ctx context.Context) error {
+	present, dataPresent := 0, true
+	var size int64
+	for i, shard := range o.shards {
+		if shard == nil {
+			if i < o.f.k {
+				dataPresent = false
+			}
+			continue
+		}
+		present++
+		if i < o.f.k {
+			if shard.Size() < headerSize {
+				dataPresent = false
+			}
+			size += shard.Size() - headerSize
+		}
+	}
+	if present == 0 {
+		return fs.ErrorObjectNotFound
+	}
+	if present < o.f.k {
+		return fmt.Errorf("%w: found %d of %d shards, need %d", errNotEnoughShards, present, len(o.shards), o.f.k)
+	}
+	if dataPresent {
+		o.size = size
+		return nil
+	}
+	hdr, _, err := o.getHeader(ctx)
+	if err != nil {
+		return err
+	}
+	o.size = hdr.size
+	return nil
+}
+
+// readHeader reads the header of shard i
+func (o *Object) readHeader(ctx context.Context, i int) (h header, err error) {
+	in, err := o.shards[i].Open(ctx, &fs.RangeOption{Start: 0, End: headerSize - 1})
+	if err != nil {
+		return h, err
+	}
+	defer fs.CheckClose(in, &err)
+	buf := make([]byte, headerSize)
+	_, err = io.ReadFull(in, buf)
+	if err != nil {
+		return h, fmt.Errorf("failed to read shard header: %w", err)
+	}
+	return decodeHeader(buf)
+}
+
+// readHeaders reads the headers of all the shards and works out which
+// of them belong to the object.
+//
+// If shards from more than one version of the object are found, the
+// version with the most shards wins.
+func (o *Object) readHeaders(ctx context.Context) (hdr *header, good []bool, err error) {
+	n := len(o.shards)
+	hdrs := make([]*header, n)
+	var wg sync.WaitGroup
+	for i, shard := range o.shards {
+		if shard == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h, err := o.readHeader(ctx, i)
+			switch {
+			case err != nil:
+				fs.Errorf(shard, "Bad shard: %v", err)
+			case h.index != i || h.k+h.m != n:
+				fs.Errorf(shard, "Bad shard: shard %d of %d found in upstream %d of %d", h.index, h.k+h.m, i, n)
+			case shard.Size() >= 0 && shard.Size() != h.layout().shardSize(i):
+				fs.Errorf(shard, "Bad shard: size %d, expecting %d", shard.Size(), h.layout().shardSize(i))
+			default:
+				hdrs[i] = &h
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Find the version of the object with the most shards
+	best := 0
+	for _, h := range hdrs {
+		if h == nil {
+			continue
+		}
+		count := 0
+		for _, other := range hdrs {
+			if other != nil && h.sameObject(*other) {
+				count++
+			}
+		}
+		if count > best {
+			best, hdr = count, h
+		}
+	}
+	if hdr == nil || best < hdr.k {
+		return nil, nil, fmt.Errorf("%w: found %d matching shards", errNotEnoughShards, best)
+	}
+	good = make([]bool, n)
+	for i, h := range hdrs {
+		good[i] = h != nil && hdr.sameObject(*h)
+	}
+	return hdr, good, nil
+}
+
+// getHeader returns the header of the object and which shards are
+// good, reading them if necessary
+func (o *Object) getHeader(ctx context.Context) (hdr *header, good []bool, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.hdr == nil {
+		o.hdr, o.good, err = o.readHeaders(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return o.hdr, o.good, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the object
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// Hash returns the selected checksum of the file
+//
+// Hashes aren't supported as no upstream has the whole object
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	return "", hash.ErrUnsupported
+}
+
+// ModTime returns the modification time of the first shard found
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	for _, shard := range o.shards {
+		if shard != nil {
+			return shard.ModTime(ctx)
+		}
+	}
+	return time.Time{}
+}
+
+// Storable returns whether the object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// forEachShard runs fn on each shard which is present in parallel
+// returning the first error
+func (o *Object) forEachShard(ctx context.Context, fn func(ctx context.Context, i int, shard fs.Object) error) error {
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, shard := range o.shards {
+		if shard == nil {
+			continue
+		}
+		g.Go(func() error {
+			return fn(gCtx, i, shard)
+		})
+	}
+	return g.Wait()
+}
+
+// SetModTime sets the modification time of all the shards
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return o.forEachShard(ctx, func(ctx context.Context, i int, shard fs.Object) error {
+		return shard.SetModTime(ctx, modTime)
+	})
+}
+
+// Remove all the shards
+func (o *Object) Remove(ctx context.Context) error {
+	return o.forEachShard(ctx, func(ctx context.Context, i int, shard fs.Object) error {
+		err := shard.Remove(ctx)
+		if err == fs.ErrorObjectNotFound {
+			err = nil
+		}
+		return err
+	})
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// All the shards are written, replacing any existing shards. The
+// size of the object must be known in advance.
+//
+// If the object exists and the upstreams can move files, the shards
+// are written under temporary names and only moved over the old
+// shards once enough of them have been written, so a failed update
+// leaves the old version of the object. Otherwise the old shards are
+// overwritten and a failed update can lose the object.
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	f := o.f
+	size := src.Size()
+	if size < 0 {
+		return errors.New("raid can't upload files of unknown size")
+	}
+	hdr, err := newHeader(f.k, f.m, int64(f.opt.BlockSize), size)
+	if err != nil {
+		return err
+	}
+	enc, err := newEncoder(f.k, f.m)
+	if err != nil {
+		return err
+	}
+	l := hdr.layout()
+	buf := make([]byte, min(l.stripeSize(), size))
+	fill := func(s int64, shards [][]byte) error {
+		n := l.stripeLen(s)
+		_, err := io.ReadFull(in, buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to read data to upload: %w", err)
+		}
+		if s == l.stripes()-1 {
+			var extra [1]byte
+			if n, _ := in.Read(extra[:]); n > 0 {
+				return errors.New("more data to upload than the size of the file")
+			}
+		}
+		b := l.blockLen(s)
+		for j := range f.k {
+			start := min(int64(j)*b, n)
+			end := min(start+b, n)
+			clear(shards[j][copy(shards[j], buf[start:end]):])
+		}
+		return enc.Encode(shards)
+	}
+	all := make([]int, len(f.upstreams))
+	for i := range all {
+		all[i] = i
+	}
+	remote := o.remote
+	replace := f.features.Move != nil && slices.ContainsFunc(o.shards, func(shard fs.Object) bool { return shard != nil })
+	if replace {
+		remote += "." + random.String(8) + updateSuffix
+	}
+	shards, err := f.writeShards(ctx, remote, src.ModTime(ctx), hdr, all, f.m, fill)
+	if err != nil {
+		// Remove any shards which were written as the object can't
+		// be read without enough of them
+		for _, shard := range shards {
+			if shard != nil {
+				_ = shard.Remove(ctx)
+			}
+		}
+		return err
+	}
+	if replace {
+		shards, err = o.moveShards(ctx, shards)
+		if err != nil {
+			return err
+		}
+	}
+	// Remove any old shards where the upload failed so they can't
+	// be mistaken for part of this object
+	for i, shard := range shards {
+		if shard == nil {
+			if old, err := f.upstreams[i].NewObject(ctx, o.remote); err == nil {
+				_ = old.Remove(ctx)
+			}
+		}
+	}
+	good := make([]bool, len(shards))
+	for i, shard := range shards {
+		good[i] = shard != nil
+	}
+	o.mu.Lock()
+	o.shards = shards
+	o.size = size
+	o.hdr = &hdr
+	o.good = good
+	o.mu.Unlock()
+	return nil
+}
+
+// moveShards moves the shards written by Update over the shards of o
+//
+// It returns the moved shards indexed by shard.
+func (o *Object) moveShards(ctx context.Context, tmpShards []fs.Object) ([]fs.Object, error) {
+	f := o.f
+	shards := make([]fs.Object, len(tmpShards))
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		tmp := tmpShards[i]
+		if tmp == nil {
+			return nil
+		}
+		if old := o.shards[i]; old != nil && u.Features().DuplicateFiles {
+			// Remove the old shard so the move doesn't make a duplicate
+			if err := old.Remove(ctx); err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+				fs.Errorf(old, "Failed to remove old shard: %v", err)
+			}
+		}
+		shards[i], err = u.Features().Move(ctx, tmp, o.remote)
+		if err != nil {
+			if removeErr := tmp.Remove(ctx); removeErr != nil {
+				fs.Errorf(tmp, "Failed to remove shard after failed move: %v", removeErr)
+			}
+			return fmt.Errorf("failed to move shard %d into place: %w", i, err)
+		}
+		return nil
+	})
+	moved := 0
+	for i, err := range errs {
+		if err != nil {
+			fs.Errorf(f.upstreams[i], "%q: %v", o.remote, err)
+		}
+		if shards[i] != nil {
+			moved++
+		}
+	}
+	if moved < f.k {
+		return shards, fmt.Errorf("%w: only %d shards moved into place", errNotEnoughShards, moved)
+	}
+	if moved < len(shards) {
+		fs.Logf(f, "%q: %d shards failed to upload - run the scrub command to repair", o.remote, len(shards)-moved)
+	}
+	return shards, nil
+}
+
+// writeShards uploads the shards in which of an object with hdr
+//
+// fill is called for each stripe to fill in the blocks of all the
+// shards, each of which is the block length for that stripe.
+//
+// Up to tolerate of the uploads may fail without returning an error.
+//
+// It returns the uploaded shards indexed by shard.
+func (f *Fs) writeShards(ctx context.Context, remote string, modTime time.Time, hdr header, which []int, tolerate int, fill func(s int64, shards [][]byte) error) ([]fs.Object, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	l := hdr.layout()
+	n := hdr.k + hdr.m
+	objs := make([]fs.Object, n)
+	uploadErrs := make([]error, n)
+	writers := make([]*io.PipeWriter, n)
+	var wg sync.WaitGroup
+	for _, i := range which {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := object.NewStaticObjectInfo(remote, modTime, l.shardSize(i), true, nil, f.upstreams[i])
+			obj, err := f.upstreams[i].Put(ctx, pr, src)
+			if err != nil {
+				uploadErrs[i] = fmt.Errorf("failed to upload shard %d: %w", i, err)
+				_ = pr.CloseWithError(uploadErrs[i])
+				return
+			}
+			// Stop the writes to this pipe if they are still going on
+			_ = pr.CloseWithError(errors.New("upload finished before all the shard was read"))
+			objs[i] = obj
+		}()
+	}
+
+	// Write the shards into the pipes
+	failed := 0
+	write := func(i int, p []byte) error {
+		if writers[i] == nil {
+			return nil
+		}
+		_, err := writers[i].Write(p)
+		if err != nil {
+			fs.Errorf(f.upstreams[i], "Failed to write shard %d of %q: %v", i, remote, err)
+			writers[i] = nil
+			failed++
+			if failed > tolerate {
+				return fmt.Errorf("too many shards failed to upload: %w", err)
+			}
+		}
+		return nil
+	}
+	err := func() error {
+		for _, i := range which {
+			if err := write(i, hdr.encode(i)); err != nil {
+				return err
+			}
+		}
+		bufs := make([][]byte, n)
+		shards := make([][]byte, n)
+		for i := range bufs {
+			bufs[i] = make([]byte, l.blockLen(0))
+		}
+		for s := range l.stripes() {
+			b := l.blockLen(s)
+			for i := range shards {
+				shards[i] = bufs[i][:b]
+			}
+			if err := fill(s, shards); err != nil {
+				return err
+			}
+			for _, i := range which {
+				if err := write(i, shards[i][:l.shardBlockLen(i, s)]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		// Abort the uploads
+		cancel()
+	}
+	for _, pw := range writers {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return objs, err
+	}
+	failed = 0
+	for _, i := range which {
+		if uploadErrs[i] != nil {
+			err = uploadErrs[i]
+			failed++
+		}
+	}
+	if failed > tolerate {
+		return objs, err
+	}
+	if failed > 0 {
+		fs.Logf(f, "%q: %d shards failed to upload - run the scrub command to repair: %v", remote, failed, err)
+	}
+	return objs, nil
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	hdr, good, err := o.getHeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(hdr.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	l := hdr.layout()
+	if offset > hdr.size {
+		offset = hdr.size
+	}
+	stripe := offset / l.stripeSize()
+	sr, err := newStripeReader(ctx, o, *hdr, good, stripe, false)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		sr:        sr,
+		skip:      offset - stripe*l.stripeSize(),
+		remaining: limit,
+	}, nil
+}
+
+// stripeReader reads the object a stripe at a time, reconstructing
+// any blocks from shards which can't be read
+type stripeReader struct {
+	ctx     context.Context
+	o       *Object
+	l       layout
+	enc     reedsolomon.Encoder
+	usable  []bool          // shards which may still be read
+	readers []io.ReadCloser // open shards, nil if not in use
+	bufs    [][]byte        // buffer for each shard
+	stripe  int64           // next stripe to read
+}
+
+// newStripeReader makes a stripeReader starting at stripe.
+//
+// If useAll is set then all the good shards are read rather than
+// just enough to reconstruct the data.
+func newStripeReader(ctx context.Context, o *Object, hdr header, good []bool, stripe int64, useAll bool) (*stripeReader, error) {
+	enc, err := newEncoder(hdr.k, hdr.m)
+	if err != nil {
+		return nil, err
+	}
+	n := hdr.k + hdr.m
+	sr := &stripeReader{
+		ctx:     ctx,
+		o:       o,
+		l:       hdr.layout(),
+		enc:     enc,
+		usable:  append([]bool(nil), good...),
+		readers: make([]io.ReadCloser, n),
+		bufs:    make([][]byte, n),
+		stripe:  stripe,
+	}
+	if stripe >= sr.l.stripes() {
+		return sr, nil
+	}
+	want := hdr.k
+	if useAll {
+		want = n
+	}
+	if err := sr.open(want); err != nil {
+		_ = sr.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+// inUse returns the number of shards being read
+func (sr *stripeReader) inUse() (count int) {
+	for _, rc := range sr.readers {
+		if rc != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// fail marks shard i as unusable
+func (sr *stripeReader) fail(i int, err error) {
+	fs.Errorf(sr.o.shards[i], "Failed to read shard - trying another: %v", err)
+	if sr.readers[i] != nil {
+		_ = sr.readers[i].Close()
+		sr.readers[i] = nil
+	}
+	sr.usable[i] = false
+}
+
+// open usable shards which aren't open at the current stripe until
+// want shards are open, preferring data shards as they don't need
+// decoding.
+func (sr *stripeReader) open(want int) error {
+	for sr.inUse() < want {
+		var toOpen []int
+		for i := range sr.readers {
+			if sr.inUse()+len(toOpen) >= want {
+				break
+			}
+			if sr.usable[i] && sr.readers[i] == nil {
+				toOpen = append(toOpen, i)
+			}
+		}
+		if len(toOpen) == 0 {
+			if sr.inUse() >= sr.l.k {
+				return nil
+			}
+			return fmt.Errorf("%w: only %d shards could be opened", errNotEnoughShards, sr.inUse())
+		}
+		errs := make([]error, len(sr.readers))
+		var wg sync.WaitGroup
+		for _, i := range toOpen {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sr.readers[i], errs[i] = sr.o.shards[i].Open(sr.ctx, &fs.RangeOption{Start: sr.l.shardOffset(sr.stripe), End: -1})
+			}()
+		}
+		wg.Wait()
+		for _, i := range toOpen {
+			if errs[i] != nil {
+				sr.fail(i, errs[i])
+			}
+		}
+	}
+	return nil
+}
+
+// read the next stripe returning the blocks for each shard
+//
+// If all is set then all the blocks are returned, otherwise only the
+// data blocks are guaranteed to be filled in. If check is set and
+// more than k blocks were read then they are checked against each
+// other.
+func (sr *stripeReader) read(all bool, check bool) (shards [][]byte, err error) {
+	l := sr.l
+	s := sr.stripe
+	b := l.blockLen(s)
+	n := len(sr.readers)
+	shards = make([][]byte, n)
+	want := sr.inUse()
+	for {
+		// Read the block from each open shard in parallel
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i, rc := range sr.readers {
+			if rc == nil || shards[i] != nil {
+				continue
+			}
+			if sr.bufs[i] == nil {
+				sr.bufs[i] = make([]byte, l.blockSize)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := sr.bufs[i][:b]
+				got := l.shardBlockLen(i, s)
+				_, errs[i] = io.ReadFull(rc, buf[:got])
+				clear(buf[got:])
+			}()
+		}
+		wg.Wait()
+		for i, rc := range sr.readers {
+			if rc == nil || shards[i] != nil {
+				continue
+			}
+			if errs[i] != nil {
+				sr.fail(i, errs[i])
+			} else {
+				shards[i] = sr.bufs[i][:b]
+			}
+		}
+		if sr.inUse() >= want {
+			break
+		}
+		// Replace any shards which failed
+		before := sr.inUse()
+		if err = sr.open(want); err != nil {
+			return nil, err
+		}
+		if sr.inUse() == before {
+			// No more shards to open but there are enough
+			break
+		}
+	}
+	missing := false
+	for i := range shards {
+		if shards[i] == nil {
+			if i < l.k || all {
+				missing = true
+			}
+			if sr.bufs[i] == nil {
+				sr.bufs[i] = make([]byte, l.blockSize)
+			}
+			shards[i] = sr.bufs[i][:0]
+		}
+	}
+	read := sr.inUse()
+	if missing {
+		if all || (check && read > l.k) {
+			err = sr.enc.Reconstruct(shards)
+		} else {
+			err = sr.enc.ReconstructData(shards)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconstruct stripe %d: %w", s, err)
+		}
+	}
+	if check && read > l.k {
+		ok, err := sr.enc.Verify(shards)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w in stripe %d", errParityMismatch, s)
+		}
+	}
+	sr.stripe++
+	return shards, nil
+}
+
+// Close all the shards
+func (sr *stripeReader) Close() error {
+	var errs []error
+	for i, rc := range sr.readers {
+		if rc != nil {
+			errs = append(errs, rc.Close())
+			sr.readers[i] = nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reader reads the data of an object
+type reader struct {
+	sr        *stripeReader
+	data      []byte // data from the current stripe not yet read
+	buf       []byte // buffer for data
+	skip      int64  // bytes to skip at the start
+	remaining int64  // bytes left to read or -1 for all
+}
+
+// Read data from the object
+func (r *reader) Read(p []byte) (n int, err error) {
+	sr := r.sr
+	l := sr.l
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	for len(r.data) == 0 {
+		if sr.stripe >= l.stripes() {
+			return 0, io.EOF
+		}
+		s := sr.stripe
+		shards, err := sr.read(false, false)
+		if err != nil {
+			return 0, err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 0, l.stripeSize())
+		}
+		r.data = r.buf[:0]
+		for j := range l.k {
+			r.data = append(r.data, shards[j][:l.shardBlockLen(j, s)]...)
+		}
+		skip := min(r.skip, int64(len(r.data)))
+		r.data = r.data[skip:]
+		r.skip -= skip
+	}
+	if r.remaining >= 0 && int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	if r.remaining >= 0 {
+		r.remaining -= int64(n)
+	}
+	return n, nil
+}
+
+// Close the reader
+func (r *reader) Close() error {
+	return r.sr.Close()
+}
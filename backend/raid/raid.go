@@ -0,0 +1,647 @@
+// Package raid implements a backend which spreads each object over
+// several remotes using erasure coding
+package raid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"golang.org/x/sync/errgroup"
+)
+
+// Register with Fs
+func init() {
+	fsi := &fs.RegInfo{
+		Name:        "raid",
+		Description: "Spread files over several remotes with erasure coding",
+		NewFs:       NewFs,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name: "upstreams",
+			Help: `List of space separated upstreams.
+
+Each file is split into shards, one for each upstream, and any
+parity_shards of the upstreams can be lost without losing any files.
+
+The order of the upstreams matters as shard N of each file is stored
+on upstream N, so don't change the order once files have been stored.
+
+Can be 'upstreama:test/dir upstreamb:', '"upstreama:test/space:ro dir" upstreamb:', etc.`,
+			Required: true,
+			Default:  fs.SpaceSepList(nil),
+		}, {
+			Name: "parity_shards",
+			Help: `Number of parity shards.
+
+This is the number of upstreams which can be lost without losing any
+files. The rest of the upstreams store data shards, so with 4 upstreams
+and 1 parity shard the files take 4/3 of their size.
+
+Don't change this once files have been stored.`,
+			Default: 1,
+		}, {
+			Name: "block_size",
+			Help: `Size of the blocks each shard is coded in.
+
+Each open file uses a buffer of this size for each upstream. This is
+stored in each shard so can be changed without affecting existing
+files.`,
+			Default:  defaultBlockSize,
+			Advanced: true,
+		}},
+	}
+	fs.Register(fsi)
+}
+
+const (
+	defaultBlockSize = 256 * fs.Kibi
+	maxBlockSize     = 64 * fs.Mebi
+	maxShards        = 256
+)
+
+// Options defines the configuration for this backend
+type Options struct {
+	Upstreams    fs.SpaceSepList `config:"upstreams"`
+	ParityShards int             `config:"parity_shards"`
+	BlockSize    fs.SizeSuffix   `config:"block_size"`
+}
+
+// Fs represents a raid of upstreams
+type Fs struct {
+	name      string       // name of this remote
+	root      string       // the path we are working on
+	opt       Options      // options for this Fs
+	features  *fs.Features // optional features
+	upstreams []fs.Fs      // shard i is stored on upstream i
+	k, m      int          // number of data and parity shards
+}
+
+// NewFs constructs an Fs from the path.
+//
+// The returned Fs is the actual Fs, referenced by remote in the config
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Upstreams) < 2 {
+		return nil, errors.New("raid needs at least two upstreams - check the value of the upstreams setting")
+	}
+	if len(opt.Upstreams) > maxShards {
+		return nil, fmt.Errorf("raid can't have more than %d upstreams", maxShards)
+	}
+	for _, u := range opt.Upstreams {
+		if strings.HasPrefix(u, name+":") {
+			return nil, errors.New("can't point raid remote at itself - check the value of the upstreams setting")
+		}
+	}
+	if opt.ParityShards < 1 || opt.ParityShards >= len(opt.Upstreams) {
+		return nil, fmt.Errorf("parity_shards must be between 1 and %d", len(opt.Upstreams)-1)
+	}
+	if opt.BlockSize < 1 || opt.BlockSize > maxBlockSize {
+		return nil, fmt.Errorf("block_size must be between 1 and %v", maxBlockSize)
+	}
+
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		upstreams: make([]fs.Fs, len(opt.Upstreams)),
+		m:         opt.ParityShards,
+		k:         len(opt.Upstreams) - opt.ParityShards,
+	}
+	isFile, err := f.makeUpstreams(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		// Point all the upstreams at the directory the file is in
+		f.root = path.Dir(root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+		_, err = f.makeUpstreams(ctx, f.root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Pin the upstreams into the cache until f is finalized
+	upstreams := slices.Clone(f.upstreams)
+	for _, u := range upstreams {
+		cache.Pin(u)
+	}
+	runtime.SetFinalizer(f, func(*Fs) {
+		for _, u := range upstreams {
+			cache.Unpin(u)
+		}
+	})
+
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          false,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+		PartialUploads:          true,
+	}).Fill(ctx, f)
+	canCopy, canMove, canDirMove, canAbout := true, true, true, true
+	for _, u := range f.upstreams {
+		f.features = f.features.Mask(ctx, u) // Mask all upstream fs
+		features := u.Features()
+		canCopy = canCopy && features.Copy != nil
+		canMove = canMove && features.Move != nil
+		canDirMove = canDirMove && features.DirMove != nil
+		canAbout = canAbout && features.About != nil
+	}
+	// These only need all the upstreams to support them
+	if canCopy {
+		f.features.Copy = f.Copy
+	}
+	if canMove {
+		f.features.Move = f.Move
+	}
+	if canDirMove {
+		f.features.DirMove = f.DirMove
+	}
+	if canAbout {
+		f.features.About = f.About
+	}
+	f.features.Purge = f.Purge
+	f.features.Command = f.Command
+	f.features.Shutdown = f.Shutdown
+	f.features.DirCacheFlush = f.DirCacheFlush
+	// show that we wrap other backends
+	f.features.Overlay = true
+
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// makeUpstreams makes the upstream Fs pointing at root
+//
+// It returns true if root points to a file in any of them.
+func (f *Fs) makeUpstreams(ctx context.Context, root string) (isFile bool, err error) {
+	var mu sync.Mutex
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, remote := range f.opt.Upstreams {
+		g.Go(func() error {
+			u, err := cache.Get(gCtx, fspath.JoinRootPath(remote, root))
+			if err == fs.ErrorIsFile {
+				mu.Lock()
+				isFile = true
+				mu.Unlock()
+			} else if err != nil {
+				return fmt.Errorf("failed to create upstream %q: %w", remote, err)
+			}
+			f.upstreams[i] = u
+			return nil
+		})
+	}
+	return isFile, g.Wait()
+}
+
+// multithread runs fn on each upstream in parallel, returning the
+// errors from each
+func (f *Fs) multithread(ctx context.Context, fn func(ctx context.Context, i int, u fs.Fs) error) []error {
+	errs := make([]error, len(f.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range f.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(ctx, i, u)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// checkErrors returns nil if no more than maxFailed of errs are
+// errors, otherwise it returns the first error.
+//
+// Errors which satisfy ignore are not counted. They are returned
+// only if every upstream returned one.
+func (f *Fs) checkErrors(what string, errs []error, maxFailed int, ignore func(error) bool) error {
+	var firstErr, ignored error
+	failed, nIgnored := 0, 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if ignore != nil && ignore(err) {
+			ignored = err
+			nIgnored++
+			continue
+		}
+		fs.Debugf(f.upstreams[i], "%s failed: %v", what, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+		failed++
+	}
+	if failed > maxFailed {
+		return firstErr
+	}
+	if failed > 0 {
+		fs.Logf(f, "%s: %d of %d upstreams failed - continuing as at most %d may fail: %v", what, failed, len(errs), maxFailed, firstErr)
+	}
+	if nIgnored+failed == len(errs) {
+		if ignored != nil {
+			return ignored
+		}
+		return firstErr
+	}
+	return nil
+}
+
+// newEncoder returns a Reed-Solomon encoder for k data and m parity shards
+func newEncoder(k, m int) (reedsolomon.Encoder, error) {
+	enc, err := reedsolomon.New(k, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make erasure coder: %w", err)
+	}
+	return enc, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("raid root '%s'", f.root)
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return hash.Set(hash.None)
+}
+
+// Precision is the greatest Precision of all upstreams
+func (f *Fs) Precision() time.Duration {
+	var greatestPrecision time.Duration
+	for _, u := range f.upstreams {
+		greatestPrecision = max(greatestPrecision, u.Precision())
+	}
+	return greatestPrecision
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	lists := make([]fs.DirEntries, len(f.upstreams))
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		lists[i], err = u.List(ctx, dir)
+		return err
+	})
+	err = f.checkErrors("list", errs, f.m, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the listings
+	dirs := map[string]fs.Directory{}
+	objects := map[string]*Object{}
+	for i, list := range lists {
+		for _, entry := range list {
+			remote := entry.Remote()
+			switch x := entry.(type) {
+			case fs.Directory:
+				if old, ok := dirs[remote]; !ok || x.ModTime(ctx).After(old.ModTime(ctx)) {
+					dirs[remote] = x
+				}
+			case fs.Object:
+				o := objects[remote]
+				if o == nil {
+					o = f.newObject(remote)
+					objects[remote] = o
+				}
+				o.shards[i] = x
+			}
+		}
+	}
+	for remote, d := range dirs {
+		entries = append(entries, fs.NewDir(remote, d.ModTime(ctx)))
+	}
+	for _, o := range objects {
+		err = o.init(ctx)
+		if err != nil {
+			fs.Errorf(o, "Ignoring object: %v", err)
+			continue
+		}
+		entries = append(entries, o)
+	}
+	return entries, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o := f.newObject(remote)
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		o.shards[i], err = u.NewObject(ctx, remote)
+		return err
+	})
+	err := f.checkErrors("find object", errs, f.m, func(err error) bool {
+		return err == fs.ErrorObjectNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = o.init(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := f.newObject(src.Remote())
+	return o, o.Update(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory on all the upstreams
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Mkdir(ctx, dir)
+	})
+	return f.checkErrors("mkdir", errs, f.m, nil)
+}
+
+// Rmdir removes the directory on all the upstreams
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Rmdir(ctx, dir)
+	})
+	return f.checkErrors("rmdir", errs, f.m, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// Purge all files in the directory
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		if do := u.Features().Purge; do != nil {
+			return do(ctx, dir)
+		}
+		return operations.Purge(ctx, u, dir)
+	})
+	return f.checkErrors("purge", errs, f.m, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// shardOp does a server-side operation on each shard of src making
+// an object at remote
+//
+// If any of them fail then undo is called on the shards which worked.
+func (f *Fs) shardOp(ctx context.Context, src fs.Object, remote string, what string, fail error,
+	op func(ctx context.Context, u fs.Fs, shard fs.Object, remote string) (fs.Object, error),
+	undo func(ctx context.Context, i int, shard fs.Object),
+) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't %s - not same remote type", what)
+		return nil, fail
+	}
+	// Only do this between raids with the same layout
+	srcFs := srcObj.f
+	if srcFs.k != f.k || srcFs.m != f.m {
+		fs.Debugf(src, "Can't %s - different number of shards", what)
+		return nil, fail
+	}
+	dst := f.newObject(remote)
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		if srcObj.shards[i] == nil {
+			return nil
+		}
+		dst.shards[i], err = op(ctx, u, srcObj.shards[i], remote)
+		return err
+	})
+	for _, err := range errs {
+		if err != nil {
+			for i, shard := range dst.shards {
+				if shard != nil {
+					undo(ctx, i, shard)
+				}
+			}
+			return nil, err
+		}
+	}
+	dst.size = srcObj.size
+	dst.hdr = srcObj.hdr
+	return dst, nil
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	return f.shardOp(ctx, src, remote, "copy", fs.ErrorCantCopy,
+		func(ctx context.Context, u fs.Fs, shard fs.Object, remote string) (fs.Object, error) {
+			return u.Features().Copy(ctx, shard, remote)
+		},
+		func(ctx context.Context, i int, shard fs.Object) {
+			if err := shard.Remove(ctx); err != nil {
+				fs.Errorf(shard, "Failed to remove copied shard after failed copy: %v", err)
+			}
+		})
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debugf(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	return f.shardOp(ctx, src, remote, "move", fs.ErrorCantMove,
+		func(ctx context.Context, u fs.Fs, shard fs.Object, remote string) (fs.Object, error) {
+			return u.Features().Move(ctx, shard, remote)
+		},
+		func(ctx context.Context, i int, shard fs.Object) {
+			// Try to put the shard back where it was
+			u := srcObj.f.upstreams[i]
+			if _, err := u.Features().Move(ctx, shard, srcObj.remote); err != nil {
+				fs.Errorf(shard, "Failed to move shard back after failed move: %v", err)
+			}
+		})
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Fs)
+	if !ok || len(srcFs.upstreams) != len(f.upstreams) {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		return u.Features().DirMove(ctx, srcFs.upstreams[i], srcRemote, dstRemote)
+	})
+	// Don't allow any failures as the shards would be left split
+	// between the directories
+	return f.checkErrors("move directory", errs, 0, func(err error) bool {
+		return errors.Is(err, fs.ErrorDirNotFound)
+	})
+}
+
+// About gets quota information from the Fs
+//
+// The space used is the sum of the space used on the upstreams and
+// the free space is how big a file could be written before the
+// upstream with the least free space is full.
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	usages := make([]*fs.Usage, len(f.upstreams))
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) (err error) {
+		usages[i], err = u.Features().About(ctx)
+		return err
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	usage := &fs.Usage{
+		Total: new(int64),
+		Used:  new(int64),
+		Free:  new(int64),
+	}
+	first := true
+	for _, u := range usages {
+		if u.Used != nil && usage.Used != nil {
+			*usage.Used += *u.Used
+		} else {
+			usage.Used = nil
+		}
+		if u.Total != nil && usage.Total != nil {
+			if first || *u.Total < *usage.Total {
+				*usage.Total = *u.Total
+			}
+		} else {
+			usage.Total = nil
+		}
+		if u.Free != nil && usage.Free != nil {
+			if first || *u.Free < *usage.Free {
+				*usage.Free = *u.Free
+			}
+		} else {
+			usage.Free = nil
+		}
+		first = false
+	}
+	// Each upstream stores 1/k of each file
+	if usage.Total != nil {
+		*usage.Total *= int64(f.k)
+	}
+	if usage.Free != nil {
+		*usage.Free *= int64(f.k)
+	}
+	return usage, nil
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	errs := f.multithread(ctx, func(ctx context.Context, i int, u fs.Fs) error {
+		if do := u.Features().Shutdown; do != nil {
+			return do(ctx)
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	for _, u := range f.upstreams {
+		if do := u.Features().DirCacheFlush; do != nil {
+			do()
+		}
+	}
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+)
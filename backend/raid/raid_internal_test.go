@@ -0,0 +1,264 @@
+package raid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayout(t *testing.T) {
+	l := layout{k: 3, m: 2, blockSize: 10, size: 65}
+	assert.Equal(t, int64(30), l.stripeSize())
+	assert.Equal(t, int64(3), l.stripes())
+	assert.Equal(t, int64(30), l.stripeLen(0))
+	assert.Equal(t, int64(5), l.stripeLen(2))
+	assert.Equal(t, int64(10), l.blockLen(0))
+	assert.Equal(t, int64(2), l.blockLen(2))
+	assert.Equal(t, int64(2), l.shardBlockLen(0, 2))
+	assert.Equal(t, int64(2), l.shardBlockLen(1, 2))
+	assert.Equal(t, int64(1), l.shardBlockLen(2, 2))
+	assert.Equal(t, int64(2), l.shardBlockLen(4, 2))
+	assert.Equal(t, int64(headerSize+22), l.shardSize(0))
+	assert.Equal(t, int64(headerSize+21), l.shardSize(2))
+	assert.Equal(t, int64(headerSize+22), l.shardSize(3))
+	assert.Equal(t, int64(headerSize+20), l.shardOffset(2))
+
+	// The data shards add up to the size of the object
+	var total int64
+	for i := range l.k {
+		total += l.shardSize(i) - headerSize
+	}
+	assert.Equal(t, l.size, total)
+
+	// Empty objects have just the header
+	l.size = 0
+	assert.Equal(t, int64(0), l.stripes())
+	assert.Equal(t, int64(headerSize), l.shardSize(0))
+	assert.Equal(t, int64(headerSize), l.shardSize(4))
+}
+
+func TestHeader(t *testing.T) {
+	h, err := newHeader(3, 2, 1024, 123456789)
+	require.NoError(t, err)
+	buf := h.encode(4)
+	assert.Len(t, buf, headerSize)
+	got, err := decodeHeader(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 4, got.index)
+	assert.True(t, h.sameObject(got))
+
+	other, err := newHeader(3, 2, 1024, 123456789)
+	require.NoError(t, err)
+	assert.False(t, h.sameObject(other))
+
+	_, err = decodeHeader(buf[:10])
+	assert.ErrorIs(t, err, errBadMagic)
+	buf[6] = 99
+	_, err = decodeHeader(buf)
+	assert.ErrorIs(t, err, errBadVersion)
+}
+
+// newTestFs makes a raid over n local directories which it returns
+func newTestFs(t *testing.T, n, parity int) (*Fs, []string) {
+	var dirs []string
+	for range n {
+		dirs = append(dirs, t.TempDir())
+	}
+	f, err := NewFs(context.Background(), "TestRaid", "", configmap.Simple{
+		"upstreams":     strings.Join(dirs, " "),
+		"parity_shards": strconv.Itoa(parity),
+		"block_size":    "16",
+	})
+	require.NoError(t, err)
+	return f.(*Fs), dirs
+}
+
+// put uploads data to remote returning the data
+func put(t *testing.T, f *Fs, remote string, size int) []byte {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	src := object.NewStaticObjectInfo(remote, time.Now(), int64(size), true, nil, nil)
+	_, err := f.Put(context.Background(), bytes.NewReader(data), src)
+	require.NoError(t, err)
+	return data
+}
+
+// read reads remote from f with options
+func read(t *testing.T, f *Fs, remote string, options ...fs.OpenOption) []byte {
+	ctx := context.Background()
+	o, err := f.NewObject(ctx, remote)
+	require.NoError(t, err)
+	in, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	return got
+}
+
+// scrub runs the scrub command on f
+func scrub(t *testing.T, f *Fs, opt map[string]string) *scrubResult {
+	out, err := f.Command(context.Background(), "scrub", nil, opt)
+	require.NoError(t, err)
+	return out.(*scrubResult)
+}
+
+func TestDegradedRead(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 5, 2)
+	data := put(t, f, "file", 1000)
+	assert.Equal(t, data, read(t, f, "file"))
+
+	// Lose a data shard and a parity shard
+	require.NoError(t, os.Remove(filepath.Join(dirs[1], "file")))
+	require.NoError(t, os.Remove(filepath.Join(dirs[4], "file")))
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+	assert.Equal(t, data, read(t, f, "file"))
+	assert.Equal(t, data[100:200], read(t, f, "file", &fs.RangeOption{Start: 100, End: 199}))
+	assert.Equal(t, data[990:], read(t, f, "file", &fs.RangeOption{Start: -1, End: 10}))
+	assert.Equal(t, data[47:], read(t, f, "file", &fs.SeekOption{Offset: 47}))
+
+	// Lose one more and the object can't be read
+	require.NoError(t, os.Remove(filepath.Join(dirs[0], "file")))
+	_, err = f.NewObject(ctx, "file")
+	assert.ErrorIs(t, err, errNotEnoughShards)
+}
+
+// failPutFs is an upstream whose uploads fail
+type failPutFs struct {
+	fs.Fs
+}
+
+// Put fails after reading the data
+func (f failPutFs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	_, _ = io.Copy(io.Discard, in)
+	return nil, errors.New("upload failed")
+}
+
+// checkNoUpdateShards checks no shards written by Update are left in dirs
+func checkNoUpdateShards(t *testing.T, dirs []string) {
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotContains(t, entry.Name(), updateSuffix)
+		}
+	}
+}
+
+func TestFailedUpdate(t *testing.T) {
+	ctx := context.Background()
+	f, dirs := newTestFs(t, 4, 1)
+	old := put(t, f, "file", 500)
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+
+	// Too many uploads fail so the update fails
+	upstreams := slices.Clone(f.upstreams)
+	f.upstreams[0] = failPutFs{f.upstreams[0]}
+	f.upstreams[3] = failPutFs{f.upstreams[3]}
+	data := make([]byte, 600)
+	_, _ = rand.Read(data)
+	src := object.NewStaticObjectInfo("file", time.Now(), int64(len(data)), true, nil, nil)
+	err = o.Update(ctx, bytes.NewReader(data), src)
+	require.Error(t, err)
+	copy(f.upstreams, upstreams)
+
+	// The old version is still there
+	assert.Equal(t, old, read(t, f, "file"))
+	checkNoUpdateShards(t, dirs)
+
+	// One upload can fail and the update still works
+	f.upstreams[2] = failPutFs{f.upstreams[2]}
+	err = o.Update(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	copy(f.upstreams, upstreams)
+	assert.Equal(t, data, read(t, f, "file"))
+	checkNoUpdateShards(t, dirs)
+	_, err = os.Stat(filepath.Join(dirs[2], "file"))
+	assert.True(t, os.IsNotExist(err), "stale shard not removed")
+}
+
+func TestScrub(t *testing.T) {
+	f, dirs := newTestFs(t, 3, 1)
+	data := put(t, f, "dir/file", 500)
+	put(t, f, "healthy", 100)
+	put(t, f, "empty", 0)
+
+	result := scrub(t, f, nil)
+	assert.Equal(t, 3, result.Checked)
+	assert.Equal(t, 3, result.Healthy)
+
+	// Make shard 0 stale by putting it back after an update
+	shard := filepath.Join(dirs[0], "dir", "file")
+	stale, err := os.ReadFile(shard)
+	require.NoError(t, err)
+	data = put(t, f, "dir/file", 500)
+	require.NoError(t, os.WriteFile(shard, stale, 0666))
+	assert.Equal(t, data, read(t, f, "dir/file"))
+
+	result = scrub(t, f, nil)
+	assert.Equal(t, []string{"dir/file"}, result.Degraded)
+	assert.Equal(t, 2, result.Healthy)
+	result = scrub(t, f, map[string]string{"quick": ""})
+	assert.Equal(t, []string{"dir/file"}, result.Degraded)
+
+	// Repair it and check it is healthy
+	result = scrub(t, f, map[string]string{"repair": ""})
+	assert.Equal(t, []string{"dir/file"}, result.Repaired)
+	result = scrub(t, f, nil)
+	assert.Equal(t, 3, result.Healthy)
+	require.NoError(t, os.Remove(filepath.Join(dirs[1], "dir", "file")))
+	assert.Equal(t, data, read(t, f, "dir/file"))
+
+	// Repair a missing shard from a subdirectory
+	out, err := f.Command(context.Background(), "scrub", []string{"dir"}, map[string]string{"repair": ""})
+	require.NoError(t, err)
+	result = out.(*scrubResult)
+	assert.Equal(t, 1, result.Checked)
+	assert.Equal(t, []string{"dir/file"}, result.Repaired)
+	require.NoError(t, os.Remove(filepath.Join(dirs[0], "dir", "file")))
+	assert.Equal(t, data, read(t, f, "dir/file"))
+
+	// Replace a missing shard with a stale one and repair it
+	require.NoError(t, os.WriteFile(filepath.Join(dirs[0], "dir", "file"), stale, 0666))
+	result = scrub(t, f, map[string]string{"repair": ""})
+	assert.Equal(t, []string{"dir/file"}, result.Repaired)
+
+	// Damage a shard so the shards don't agree
+	shard = filepath.Join(dirs[2], "healthy")
+	buf, err := os.ReadFile(shard)
+	require.NoError(t, err)
+	buf[headerSize] ^= 0xFF
+	require.NoError(t, os.WriteFile(shard, buf, 0666))
+	result = scrub(t, f, map[string]string{"repair": ""})
+	assert.Equal(t, []string{"healthy"}, result.Corrupt)
+	assert.Equal(t, 2, result.Healthy)
+
+	// Lose too many shards
+	require.NoError(t, os.Remove(filepath.Join(dirs[0], "empty")))
+	require.NoError(t, os.Remove(filepath.Join(dirs[1], "empty")))
+	result = scrub(t, f, nil)
+	assert.Equal(t, []string{"empty"}, result.Lost)
+
+	_, err = f.Command(context.Background(), "unknown", nil, nil)
+	assert.ErrorIs(t, err, fs.ErrorCommandNotFound)
+}
@@ -0,0 +1,71 @@
+// Test Raid filesystem interface
+package raid_test
+
+import (
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var (
+	unimplementableFsMethods        = []string{"UnWrap", "WrapFs", "SetWrapper", "UserInfo", "Disconnect", "OpenChunkWriter", "PutStream", "ListR", "ListP", "MkdirMetadata", "ChangeNotify", "PublicLink", "PutUnchecked", "PutIfNotExists", "MergeDirs", "DirSetModTime", "CleanUp", "OpenWriterAt"}
+	unimplementableObjectMethods    = []string{"MimeType", "ID", "GetTier", "SetTier", "Metadata", "SetMetadata", "UnWrap"}
+	unimplementableDirectoryMethods = []string{"Metadata", "SetMetadata", "SetModTime"}
+)
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:                      *fstest.RemoteName,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := t.TempDir() + " " + t.TempDir() + " " + t.TempDir()
+	name := "TestRaidLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "raid"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "parity_shards", Value: "1"},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
+
+// TestLocalBlocks tests with small blocks so files have many stripes
+func TestLocalBlocks(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	upstreams := t.TempDir() + " " + t.TempDir() + " " + t.TempDir() + " " + t.TempDir()
+	name := "TestRaidLocalBlocks"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "raid"},
+			{Name: name, Key: "upstreams", Value: upstreams},
+			{Name: name, Key: "parity_shards", Value: "2"},
+			{Name: name, Key: "block_size", Value: "7"},
+		},
+		QuickTestOK:                     true,
+		UnimplementableFsMethods:        unimplementableFsMethods,
+		UnimplementableObjectMethods:    unimplementableObjectMethods,
+		UnimplementableDirectoryMethods: unimplementableDirectoryMethods,
+	})
+}
@@ -0,0 +1,157 @@
+package raid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Each shard starts with a header of this many bytes
+const headerSize = 32
+
+// Shard file format version
+const shardVersion = 1
+
+// Magic bytes at the start of each shard
+var shardMagic = []byte("RCRAID")
+
+var (
+	errBadMagic   = errors.New("not a raid shard")
+	errBadVersion = errors.New("unknown raid shard version")
+)
+
+// header is stored at the start of each shard
+//
+// All the shards of an object have the same header apart from the
+// index so the shards of an object written at a different time can be
+// spotted by their id.
+type header struct {
+	index     int     // index of this shard
+	k         int     // number of data shards
+	m         int     // number of parity shards
+	blockSize int64   // size of each shard block
+	size      int64   // size of the object
+	id        [8]byte // random id shared by all the shards written together
+}
+
+// newHeader makes a header for an object of size with a new id
+func newHeader(k, m int, blockSize, size int64) (h header, err error) {
+	h = header{
+		k:         k,
+		m:         m,
+		blockSize: blockSize,
+		size:      size,
+	}
+	_, err = rand.Read(h.id[:])
+	if err != nil {
+		return h, fmt.Errorf("failed to make shard id: %w", err)
+	}
+	return h, nil
+}
+
+// encode the header for shard index
+func (h header) encode(index int) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, shardMagic)
+	buf[6] = shardVersion
+	buf[7] = byte(index)
+	buf[8] = byte(h.k)
+	buf[9] = byte(h.m)
+	binary.BigEndian.PutUint32(buf[12:], uint32(h.blockSize))
+	binary.BigEndian.PutUint64(buf[16:], uint64(h.size))
+	copy(buf[24:], h.id[:])
+	return buf
+}
+
+// decodeHeader decodes the header in buf
+func decodeHeader(buf []byte) (h header, err error) {
+	if len(buf) < headerSize || !bytes.Equal(buf[:6], shardMagic) {
+		return h, errBadMagic
+	}
+	if buf[6] != shardVersion {
+		return h, fmt.Errorf("%w %d", errBadVersion, buf[6])
+	}
+	h = header{
+		index:     int(buf[7]),
+		k:         int(buf[8]),
+		m:         int(buf[9]),
+		blockSize: int64(binary.BigEndian.Uint32(buf[12:])),
+		size:      int64(binary.BigEndian.Uint64(buf[16:])),
+	}
+	copy(h.id[:], buf[24:])
+	if h.k < 1 || h.blockSize <= 0 || h.size < 0 || h.index >= h.k+h.m {
+		return h, errors.New("corrupted raid shard header")
+	}
+	return h, nil
+}
+
+// sameObject returns true if h and other are shards of the same object
+func (h header) sameObject(other header) bool {
+	return h.id == other.id && h.k == other.k && h.m == other.m && h.blockSize == other.blockSize && h.size == other.size
+}
+
+// layout describes how an object is split into stripes and shards
+//
+// The object is split into stripes of k*blockSize bytes and each
+// stripe is split into k blocks, one for each data shard, from which
+// m parity blocks are calculated.
+//
+// The last stripe may be short. If so it is split into k blocks of
+// ceil(len/k) bytes. The data shards are stored without padding so
+// the size of the object is the sum of the sizes of the data shards.
+type layout struct {
+	k, m      int
+	blockSize int64
+	size      int64
+}
+
+// layout returns the layout described by the header
+func (h header) layout() layout {
+	return layout{k: h.k, m: h.m, blockSize: h.blockSize, size: h.size}
+}
+
+// stripeSize returns the number of bytes of data in a full stripe
+func (l layout) stripeSize() int64 {
+	return int64(l.k) * l.blockSize
+}
+
+// stripes returns the number of stripes
+func (l layout) stripes() int64 {
+	return (l.size + l.stripeSize() - 1) / l.stripeSize()
+}
+
+// stripeLen returns the number of bytes of data in stripe s
+func (l layout) stripeLen(s int64) int64 {
+	return min(l.stripeSize(), l.size-s*l.stripeSize())
+}
+
+// blockLen returns the size of the blocks in stripe s
+func (l layout) blockLen(s int64) int64 {
+	n := l.stripeLen(s)
+	return (n + int64(l.k) - 1) / int64(l.k)
+}
+
+// shardBlockLen returns the number of bytes shard i stores for stripe s
+func (l layout) shardBlockLen(i int, s int64) int64 {
+	b := l.blockLen(s)
+	if i >= l.k {
+		return b
+	}
+	return max(0, min(b, l.stripeLen(s)-int64(i)*b))
+}
+
+// shardSize returns the size of shard i including the header
+func (l layout) shardSize(i int) int64 {
+	n := l.stripes()
+	if n == 0 {
+		return headerSize
+	}
+	return headerSize + (n-1)*l.blockSize + l.shardBlockLen(i, n-1)
+}
+
+// shardOffset returns the offset in each shard of stripe s
+func (l layout) shardOffset(s int64) int64 {
+	return headerSize + s*l.blockSize
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// bwCheckInterval is how often the bandwidth timetable is looked at
+const bwCheckInterval = time.Minute
+
+// limits holds the rate limiters of the remote
+type limits struct {
+	tps *rate.Limiter // for transactions, nil if unlimited
+
+	mu        sync.Mutex
+	timetable fs.BwTimetable
+	checked   time.Time     // when the timetable was last looked at
+	bandwidth fs.BwPair     // bandwidth currently in use
+	tx        *rate.Limiter // for uploads, nil if unlimited
+	rx        *rate.Limiter // for downloads, nil if unlimited
+}
+
+// newLimits makes the limiters from the options
+func newLimits(opt *Options) *limits {
+	l := &limits{
+		timetable: opt.BwLimit,
+	}
+	if opt.TPSLimit > 0 {
+		l.tps = rate.NewLimiter(rate.Limit(opt.TPSLimit), max(opt.TPSLimitBurst, 1))
+	}
+	return l
+}
+
+// newBandwidthLimiter makes an empty token bucket for bandwidth
+//
+// The burst is a second's worth of data.
+func newBandwidthLimiter(bandwidth fs.SizeSuffix) *rate.Limiter {
+	if bandwidth <= 0 {
+		return nil
+	}
+	burst := int(max(bandwidth, 1))
+	tb := rate.NewLimiter(rate.Limit(bandwidth), burst)
+	tb.AllowN(time.Now(), burst)
+	return tb
+}
+
+// transaction waits until a transaction is allowed
+func (l *limits) transaction(ctx context.Context) error {
+	if l.tps == nil {
+		return nil
+	}
+	return l.tps.Wait(ctx)
+}
+
+// limiter returns the bandwidth limiter for the direction given
+// updating them from the timetable if necessary
+func (l *limits) limiter(upload bool) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.checked) >= bwCheckInterval {
+		l.checked = now
+		bandwidth := l.timetable.LimitAt(now).Bandwidth
+		if bandwidth != l.bandwidth {
+			if bandwidth.IsSet() {
+				fs.Debugf(nil, "ratelimit: bandwidth limit set to %v Byte/s", &bandwidth)
+			}
+			l.bandwidth = bandwidth
+			l.tx = newBandwidthLimiter(bandwidth.Tx)
+			l.rx = newBandwidthLimiter(bandwidth.Rx)
+		}
+	}
+	if upload {
+		return l.tx
+	}
+	return l.rx
+}
+
+// waitBytes waits until n bytes may be transferred
+func (l *limits) waitBytes(ctx context.Context, upload bool, n int) error {
+	tb := l.limiter(upload)
+	if tb == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, tb.Burst())
+		if err := tb.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// limitedReader limits the rate in is read at
+type limitedReader struct {
+	ctx    context.Context
+	in     io.Reader
+	l      *limits
+	upload bool
+}
+
+// Read bytes waiting for the bandwidth limit
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if waitErr := r.l.waitBytes(r.ctx, r.upload, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// limitedReadCloser limits the rate a download is read at
+type limitedReadCloser struct {
+	limitedReader
+	io.Closer
+}
@@ -0,0 +1,630 @@
+// Package ratelimit implements a backend which limits the rate of
+// transactions and the bandwidth used on another remote
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "ratelimit",
+		Description: "Limit the transactions and bandwidth used on another remote",
+		NewFs:       NewFs,
+		MetadataInfo: &fs.MetadataInfo{
+			Help: `Any metadata supported by the underlying remote is read and written.`,
+		},
+		Options: []fs.Option{{
+			Name:     "remote",
+			Help:     "Remote or path to limit.\n\nCan be \"myremote:path/to/dir\", \"myremote:bucket\", \"myremote:\" or \"/local/path\".",
+			Required: true,
+		}, {
+			Name: "tpslimit",
+			Help: `Limit transactions per second to this.
+
+A transaction is one call into the underlying remote, such as listing
+a directory, opening a file or deleting one. This may be more than one
+HTTP request.
+
+Use 0 for no limit.`,
+			Default: 0.0,
+		}, {
+			Name:     "tpslimit_burst",
+			Help:     "Max burst of transactions for tpslimit.",
+			Default:  1,
+			Advanced: true,
+		}, {
+			Name: "bwlimit",
+			Help: `Bandwidth limit in KiB/s, or use suffix B|K|M|G|T|P or a full timetable.
+
+This takes the same values as the global --bwlimit flag, so separate
+upload and download limits can be given as "10M:100M" and the limit
+can change with the time of day.
+
+Leave blank for no limit.`,
+			Default: fs.BwTimetable{},
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote        string         `config:"remote"`
+	TPSLimit      float64        `config:"tpslimit"`
+	TPSLimitBurst int            `config:"tpslimit_burst"`
+	BwLimit       fs.BwTimetable `config:"bwlimit"`
+}
+
+// Fs represents a wrapped fs.Fs with rate limits
+type Fs struct {
+	fs.Fs
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+	wrapper  fs.Fs
+	limits   *limits
+}
+
+// NewFs constructs an Fs from the path.
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	f := &Fs{
+		name: name,
+		root: root,
+	}
+	err := configstruct.Set(m, &f.opt)
+	if err != nil {
+		return nil, err
+	}
+	if f.opt.Remote == "" {
+		return nil, errors.New("ratelimit can't point to an empty remote - check the value of the remote setting")
+	}
+	if strings.HasPrefix(f.opt.Remote, name+":") {
+		return nil, errors.New("can't point ratelimit remote at itself - check the value of the remote setting")
+	}
+	if f.opt.TPSLimit < 0 {
+		return nil, fmt.Errorf("tpslimit must not be negative, got %v", f.opt.TPSLimit)
+	}
+	f.limits = newLimits(&f.opt)
+	baseFs, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Remote, root))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", f.opt.Remote, err)
+	}
+	f.Fs = baseFs
+	// Correct root if definitely pointing to a file
+	if err == fs.ErrorIsFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+	}
+	// OpenWriterAt and OpenChunkWriter aren't implemented as the
+	// bandwidth of their writes can't be limited
+	f.features = (&fs.Features{
+		CaseInsensitive:          true,
+		DuplicateFiles:           true,
+		ReadMimeType:             true,
+		WriteMimeType:            true,
+		ReadMetadata:             true,
+		WriteMetadata:            true,
+		UserMetadata:             true,
+		ReadDirMetadata:          true,
+		WriteDirMetadata:         true,
+		WriteDirSetModTime:       true,
+		UserDirMetadata:          true,
+		DirModTimeUpdatesOnWrite: true,
+		CanHaveEmptyDirectories:  true,
+		BucketBased:              true,
+		BucketBasedRootOK:        true,
+		SetTier:                  true,
+		GetTier:                  true,
+		SlowModTime:              true,
+		SlowHash:                 true,
+		FilterAware:              true,
+		PartialUploads:           true,
+		NoMultiThreading:         true,
+		DoubleSlash:              true,
+	}).Fill(ctx, f).Mask(ctx, baseFs).WrapsFs(f, baseFs)
+	cache.PinUntilFinalized(f.Fs, f)
+	return f, err
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.root }
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("ratelimit %s:%s", f.name, f.root)
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set { return f.Fs.Hashes() }
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs { return f.Fs }
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs { return f.wrapper }
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) { f.wrapper = wrapper }
+
+// limitUpload limits the rate in is read at to the upload bandwidth
+func (f *Fs) limitUpload(ctx context.Context, in io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, in: in, l: f.limits, upload: true}
+}
+
+// wrapEntries wraps the objects of the base remote
+func (f *Fs) wrapEntries(entries fs.DirEntries) fs.DirEntries {
+	for i, entry := range entries {
+		if o, ok := entry.(fs.Object); ok {
+			entries[i] = f.newObject(o)
+		}
+	}
+	return entries
+}
+
+// List the objects and directories in dir into entries.
+func (f *Fs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries), nil
+}
+
+// ListR lists the objects and directories recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return f.Fs.Features().ListR(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// ListP lists the objects and directories of the Fs starting from dir
+// non recursively calling callback for each tranche of entries read.
+func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return f.Fs.Features().ListP(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// putFn is the signature of the base remote's upload methods
+type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
+
+// put uploads a new object with put within the limits
+func (f *Fs) put(ctx context.Context, put putFn, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (fs.Object, error) {
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	o, err := put(ctx, f.limitUpload(ctx, in), src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, f.Fs.Put, in, src, options)
+}
+
+// PutStream uploads to the remote path with undeterminate size.
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutStream; do != nil {
+		return f.put(ctx, do, in, src, options)
+	}
+	return nil, errors.New("PutStream not supported")
+}
+
+// PutUnchecked uploads the object, allowing duplicates.
+func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutUnchecked; do != nil {
+		return f.put(ctx, do, in, src, options)
+	}
+	return nil, errors.New("PutUnchecked not supported")
+}
+
+// PutIfNotExists uploads the object only if it doesn't already exist.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if do := f.Fs.Features().PutIfNotExists; do != nil {
+		return f.put(ctx, do, in, src, options)
+	}
+	return nil, errors.New("PutIfNotExists not supported")
+}
+
+// Mkdir makes the directory (container, bucket)
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return f.Fs.Mkdir(ctx, dir)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return f.Fs.Rmdir(ctx, dir)
+}
+
+// MkdirMetadata makes the directory passed in as dir.
+func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata) (fs.Directory, error) {
+	do := f.Fs.Features().MkdirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	return do(ctx, dir, metadata)
+}
+
+// DirSetModTime sets the directory modtime for dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	do := f.Fs.Features().DirSetModTime
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx, dir, modTime)
+}
+
+// Purge all files in the directory specified
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	do := f.Fs.Features().Purge
+	if do == nil {
+		return fs.ErrorCantPurge
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx, dir)
+}
+
+// Copy src to this remote using server-side copy operations.
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	newObj, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(newObj), nil
+}
+
+// Move src to this remote using server-side move operations.
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	newObj, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(newObj), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote using
+// server-side move operations.
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// MergeDirs merges the contents of all the directories passed
+// in into the first one and rmdirs the other directories.
+func (f *Fs) MergeDirs(ctx context.Context, dirs []fs.Directory) error {
+	do := f.Fs.Features().MergeDirs
+	if do == nil {
+		return errors.New("MergeDirs not supported")
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx, dirs)
+}
+
+// CleanUp the trash in the Fs
+func (f *Fs) CleanUp(ctx context.Context) error {
+	do := f.Fs.Features().CleanUp
+	if do == nil {
+		return errors.New("not supported by underlying remote")
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx)
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	do := f.Fs.Features().About
+	if do == nil {
+		return nil, errors.New("not supported by underlying remote")
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	return do(ctx)
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.Fs.Features().ChangeNotify; do != nil {
+		do(ctx, notifyFunc, pollIntervalChan)
+	}
+}
+
+// UserInfo returns info about the connected user
+func (f *Fs) UserInfo(ctx context.Context) (map[string]string, error) {
+	do := f.Fs.Features().UserInfo
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	return do(ctx)
+}
+
+// Disconnect the current user
+func (f *Fs) Disconnect(ctx context.Context) error {
+	do := f.Fs.Features().Disconnect
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do(ctx)
+}
+
+// PublicLink generates a public link to the remote path (usually readable by anyone)
+func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration, unlink bool) (string, error) {
+	do := f.Fs.Features().PublicLink
+	if do == nil {
+		return "", errors.New("PublicLink not supported")
+	}
+	if err := f.limits.transaction(ctx); err != nil {
+		return "", err
+	}
+	return do(ctx, remote, expire, unlink)
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// Shutdown the backend, closing any background tasks and any cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// Object is an object on the wrapped remote whose transactions and
+// transfers are limited
+type Object struct {
+	fs.Object
+	f *Fs
+}
+
+// newObject wraps o
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{Object: o, f: f}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// Open an object for read limiting the download bandwidth
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	if err := o.f.limits.transaction(ctx); err != nil {
+		return nil, err
+	}
+	in, err := o.Object.Open(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{
+		limitedReader: limitedReader{ctx: ctx, in: in, l: o.f.limits},
+		Closer:        in,
+	}, nil
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+// limiting the upload bandwidth
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if err := o.f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return o.Object.Update(ctx, o.f.limitUpload(ctx, in), src, options...)
+}
+
+// Remove an object
+func (o *Object) Remove(ctx context.Context) error {
+	if err := o.f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return o.Object.Remove(ctx)
+}
+
+// SetModTime sets the modification time of the object
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	if err := o.f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return o.Object.SetModTime(ctx, modTime)
+}
+
+// ID returns the ID of the Object if possible
+func (o *Object) ID() string {
+	if doer, ok := o.Object.(fs.IDer); ok {
+		return doer.ID()
+	}
+	return ""
+}
+
+// GetTier returns the Tier of the Object if possible
+func (o *Object) GetTier() string {
+	if doer, ok := o.Object.(fs.GetTierer); ok {
+		return doer.GetTier()
+	}
+	return ""
+}
+
+// SetTier set the Tier of the Object if possible
+func (o *Object) SetTier(tier string) error {
+	if doer, ok := o.Object.(fs.SetTierer); ok {
+		return doer.SetTier(tier)
+	}
+	return errors.New("SetTier not supported")
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if doer, ok := o.Object.(fs.MimeTyper); ok {
+		return doer.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// SetMetadata sets metadata for an Object
+//
+// It should return fs.ErrorNotImplemented if it can't set metadata
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	do, ok := o.Object.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	if err := o.f.limits.transaction(ctx); err != nil {
+		return err
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.PutUncheckeder   = (*Fs)(nil)
+	_ fs.PutIfNotExistser = (*Fs)(nil)
+	_ fs.PutStreamer      = (*Fs)(nil)
+	_ fs.CleanUpper       = (*Fs)(nil)
+	_ fs.UnWrapper        = (*Fs)(nil)
+	_ fs.ListRer          = (*Fs)(nil)
+	_ fs.ListPer          = (*Fs)(nil)
+	_ fs.Abouter          = (*Fs)(nil)
+	_ fs.Wrapper          = (*Fs)(nil)
+	_ fs.MergeDirser      = (*Fs)(nil)
+	_ fs.DirSetModTimer   = (*Fs)(nil)
+	_ fs.MkdirMetadataer  = (*Fs)(nil)
+	_ fs.DirCacheFlusher  = (*Fs)(nil)
+	_ fs.ChangeNotifier   = (*Fs)(nil)
+	_ fs.PublicLinker     = (*Fs)(nil)
+	_ fs.UserInfoer       = (*Fs)(nil)
+	_ fs.Disconnecter     = (*Fs)(nil)
+	_ fs.Shutdowner       = (*Fs)(nil)
+	_ fs.FullObject       = (*Object)(nil)
+)
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRateLimit makes a ratelimit remote on a temporary directory
+func newRateLimit(t *testing.T, config string) (*Fs, string) {
+	dir := t.TempDir()
+	f, err := fs.NewFs(context.Background(), ":ratelimit,remote='"+dir+"',"+config+":")
+	require.NoError(t, err)
+	return f.(*Fs), dir
+}
+
+func TestTPSLimit(t *testing.T) {
+	ctx := context.Background()
+	f, dir := newRateLimit(t, "tpslimit=20")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o666))
+
+	start := time.Now()
+	for range 6 {
+		_, err := f.NewObject(ctx, "file")
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	// Waiting stops when the context is cancelled
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := f.NewObject(cancelCtx, "file")
+	assert.Error(t, err)
+}
+
+func TestBwLimit(t *testing.T) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("x"), 10*1024)
+
+	// Uploads use the first limit
+	f, _ := newRateLimit(t, "bwlimit='20K:40K'")
+	start := time.Now()
+	src := object.NewStaticObjectInfo("file", time.Now(), int64(len(data)), true, nil, nil)
+	_, err := f.Put(ctx, bytes.NewReader(data), src)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Downloads use the second limit
+	f, dir := newRateLimit(t, "bwlimit='20K:40K'")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), data, 0o666))
+	o, err := f.NewObject(ctx, "file")
+	require.NoError(t, err)
+	start = time.Now()
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	got, err := io.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, data, got)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Less(t, elapsed, 400*time.Millisecond)
+}
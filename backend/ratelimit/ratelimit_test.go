@@ -0,0 +1,41 @@
+// Test RateLimit filesystem interface
+package ratelimit_test
+
+import (
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var unimplementableFsMethods = []string{"OpenWriterAt", "OpenChunkWriter"}
+
+// TestIntegration runs integration tests against the remote
+func TestIntegration(t *testing.T) {
+	if *fstest.RemoteName == "" {
+		t.Skip("Skipping as -remote not set")
+	}
+	fstests.Run(t, &fstests.Opt{
+		RemoteName:               *fstest.RemoteName,
+		UnimplementableFsMethods: unimplementableFsMethods,
+	})
+}
+
+func TestLocal(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestRateLimitLocal"
+	fstests.Run(t, &fstests.Opt{
+		RemoteName: name + ":",
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "ratelimit"},
+			{Name: name, Key: "remote", Value: t.TempDir()},
+			{Name: name, Key: "tpslimit", Value: "1000"},
+			{Name: name, Key: "bwlimit", Value: "1G"},
+		},
+		QuickTestOK:              true,
+		UnimplementableFsMethods: unimplementableFsMethods,
+	})
+}
@@ -0,0 +1,346 @@
+// Package readonly implements a backend which gives a read only view
+// of another remote
+package readonly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "readonly",
+		Description: "Read only view of another remote",
+		NewFs:       NewFs,
+		MetadataInfo: &fs.MetadataInfo{
+			Help: `Any metadata supported by the underlying remote is read but can't be written.`,
+		},
+		Options: []fs.Option{{
+			Name:     "remote",
+			Help:     "Remote or path to give read only access to.\n\nCan be \"myremote:path/to/dir\", \"myremote:bucket\", \"myremote:\" or \"/local/path\".",
+			Required: true,
+		}},
+	})
+}
+
+// ErrorReadOnly is returned for any attempt to write to the remote
+var ErrorReadOnly = fserrors.NoRetryError(errors.New("remote is read only"))
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+}
+
+// Fs represents a read only view of a wrapped fs.Fs
+type Fs struct {
+	fs.Fs
+	name     string
+	root     string
+	opt      Options
+	features *fs.Features
+	wrapper  fs.Fs
+}
+
+// NewFs constructs an Fs from the path.
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	f := &Fs{
+		name: name,
+		root: root,
+	}
+	err := configstruct.Set(m, &f.opt)
+	if err != nil {
+		return nil, err
+	}
+	if f.opt.Remote == "" {
+		return nil, errors.New("readonly can't point to an empty remote - check the value of the remote setting")
+	}
+	if strings.HasPrefix(f.opt.Remote, name+":") {
+		return nil, errors.New("can't point readonly remote at itself - check the value of the remote setting")
+	}
+	baseFs, err := cache.Get(ctx, fspath.JoinRootPath(f.opt.Remote, root))
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", f.opt.Remote, err)
+	}
+	f.Fs = baseFs
+	// Correct root if definitely pointing to a file
+	if err == fs.ErrorIsFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+	}
+	// Only the optional methods which read are implemented so
+	// all the write features are turned off by Fill
+	f.features = (&fs.Features{
+		CaseInsensitive:         true,
+		DuplicateFiles:          true,
+		ReadMimeType:            true,
+		ReadMetadata:            true,
+		ReadDirMetadata:         true,
+		CanHaveEmptyDirectories: true,
+		BucketBased:             true,
+		BucketBasedRootOK:       true,
+		GetTier:                 true,
+		SlowModTime:             true,
+		SlowHash:                true,
+		FilterAware:             true,
+		DoubleSlash:             true,
+	}).Fill(ctx, f).Mask(ctx, baseFs).WrapsFs(f, baseFs)
+	cache.PinUntilFinalized(f.Fs, f)
+	return f, err
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string { return f.name }
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string { return f.root }
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features { return f.features }
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("readonly %s:%s", f.name, f.root)
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set { return f.Fs.Hashes() }
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs { return f.Fs }
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs { return f.wrapper }
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) { f.wrapper = wrapper }
+
+// wrapEntries wraps the objects and directories of the base remote
+func (f *Fs) wrapEntries(entries fs.DirEntries) fs.DirEntries {
+	for i, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Object:
+			entries[i] = f.newObject(x)
+		case fs.Directory:
+			entries[i] = &Directory{Directory: x}
+		}
+	}
+	return entries
+}
+
+// List the objects and directories in dir into entries.
+func (f *Fs) List(ctx context.Context, dir string) (fs.DirEntries, error) {
+	entries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(entries), nil
+}
+
+// ListR lists the objects and directories recursively into out.
+func (f *Fs) ListR(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	return f.Fs.Features().ListR(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// ListP lists the objects and directories of the Fs starting from dir
+// non recursively calling callback for each tranche of entries read.
+func (f *Fs) ListP(ctx context.Context, dir string, callback fs.ListRCallback) error {
+	return f.Fs.Features().ListP(ctx, dir, func(entries fs.DirEntries) error {
+		return callback(f.wrapEntries(entries))
+	})
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Put is refused as the remote is read only
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return nil, ErrorReadOnly
+}
+
+// Mkdir is refused as the remote is read only
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return ErrorReadOnly
+}
+
+// Rmdir is refused as the remote is read only
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	return ErrorReadOnly
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if do := f.Fs.Features().About; do != nil {
+		return do(ctx)
+	}
+	return nil, errors.New("not supported by underlying remote")
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	if do := f.Fs.Features().ChangeNotify; do != nil {
+		do(ctx, notifyFunc, pollIntervalChan)
+	}
+}
+
+// UserInfo returns info about the connected user
+func (f *Fs) UserInfo(ctx context.Context) (map[string]string, error) {
+	if do := f.Fs.Features().UserInfo; do != nil {
+		return do(ctx)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// DirCacheFlush resets the directory cache - used in testing
+// as an optional interface
+func (f *Fs) DirCacheFlush() {
+	if do := f.Fs.Features().DirCacheFlush; do != nil {
+		do()
+	}
+}
+
+// Shutdown the backend, closing any background tasks and any cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// Object is a read only view of an object on the wrapped remote
+type Object struct {
+	fs.Object
+	f *Fs
+}
+
+// newObject wraps o
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{Object: o, f: f}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info { return o.f }
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object { return o.Object }
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// Update is refused as the remote is read only
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return ErrorReadOnly
+}
+
+// Remove is refused as the remote is read only
+func (o *Object) Remove(ctx context.Context) error {
+	return ErrorReadOnly
+}
+
+// SetModTime is refused as the remote is read only
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	return ErrorReadOnly
+}
+
+// ID returns the ID of the Object if possible
+func (o *Object) ID() string {
+	if doer, ok := o.Object.(fs.IDer); ok {
+		return doer.ID()
+	}
+	return ""
+}
+
+// GetTier returns the Tier of the Object if possible
+func (o *Object) GetTier() string {
+	if doer, ok := o.Object.(fs.GetTierer); ok {
+		return doer.GetTier()
+	}
+	return ""
+}
+
+// MimeType of an Object if known, "" otherwise
+func (o *Object) MimeType(ctx context.Context) string {
+	if doer, ok := o.Object.(fs.MimeTyper); ok {
+		return doer.MimeType(ctx)
+	}
+	return ""
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// Directory is a read only view of a directory on the wrapped remote
+//
+// It doesn't implement SetModTime or SetMetadata.
+type Directory struct {
+	fs.Directory
+}
+
+// Metadata returns metadata for a directory
+//
+// It should return nil if there is no Metadata
+func (d *Directory) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := d.Directory.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.ListRer         = (*Fs)(nil)
+	_ fs.ListPer         = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.UserInfoer      = (*Fs)(nil)
+	_ fs.DirCacheFlusher = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.IDer            = (*Object)(nil)
+	_ fs.GetTierer       = (*Object)(nil)
+	_ fs.MimeTyper       = (*Object)(nil)
+	_ fs.Metadataer      = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+	_ fs.Directory       = (*Directory)(nil)
+	_ fs.Metadataer      = (*Directory)(nil)
+)
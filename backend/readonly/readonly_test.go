@@ -0,0 +1,81 @@
+package readonly_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/backend/readonly"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("potato"), 0o666))
+
+	f, err := fs.NewFs(ctx, ":readonly,remote='"+dir+"':")
+	require.NoError(t, err)
+
+	// Reading works
+	entries, err := f.List(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "sub", entries[0].Remote())
+	_, ok := entries[0].(fs.SetModTimer)
+	assert.False(t, ok, "directories shouldn't be writable")
+	o, err := f.NewObject(ctx, "sub/file.txt")
+	require.NoError(t, err)
+	in, err := o.Open(ctx)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "potato", buf.String())
+
+	// Writing doesn't
+	src := object.NewStaticObjectInfo("new.txt", time.Now(), 5, true, nil, nil)
+	_, err = f.Put(ctx, bytes.NewBufferString("hello"), src)
+	assert.ErrorIs(t, err, readonly.ErrorReadOnly)
+	assert.ErrorIs(t, f.Mkdir(ctx, "dir"), readonly.ErrorReadOnly)
+	assert.ErrorIs(t, f.Rmdir(ctx, "sub"), readonly.ErrorReadOnly)
+	assert.ErrorIs(t, o.Remove(ctx), readonly.ErrorReadOnly)
+	assert.ErrorIs(t, o.SetModTime(ctx, time.Now()), readonly.ErrorReadOnly)
+	assert.ErrorIs(t, o.Update(ctx, bytes.NewBufferString("hello"), src), readonly.ErrorReadOnly)
+	_, ok = o.(fs.SetMetadataer)
+	assert.False(t, ok)
+	assert.Error(t, operations.Purge(ctx, f, "sub"))
+
+	// None of the write features are available
+	features := f.Features()
+	assert.Nil(t, features.Copy)
+	assert.Nil(t, features.Move)
+	assert.Nil(t, features.DirMove)
+	assert.Nil(t, features.Purge)
+	assert.Nil(t, features.PutStream)
+	assert.Nil(t, features.PutUnchecked)
+	assert.Nil(t, features.MkdirMetadata)
+	assert.Nil(t, features.DirSetModTime)
+	assert.Nil(t, features.OpenWriterAt)
+	assert.Nil(t, features.OpenChunkWriter)
+	assert.Nil(t, features.CleanUp)
+	assert.Nil(t, features.Command)
+	assert.False(t, features.WriteMetadata)
+
+	// Nothing was changed
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(data))
+	_, err = os.Stat(filepath.Join(dir, "new.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
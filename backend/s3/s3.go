@@ -3084,6 +3084,22 @@ knows about - please make a bug report if not.
 `,
 			Default:  fs.Tristate{},
 			Advanced: true,
+		}, {
+			Name: "use_conditional_writes",
+			Help: strings.ReplaceAll(`Set if rclone should use conditional writes.
+
+If set rclone will use |If-None-Match: *| to upload objects only if
+they don't exist already. This is used to take locks on the remote
+atomically, for example by |rclone bisync --remote-lock|.
+
+If not set then lock objects are created without an atomic check
+which is much more likely to let two hosts take the same lock.
+
+This should be automatically set correctly for all providers rclone
+knows about - please make a bug report if not.
+`, "|", "`"),
+			Default:  fs.Tristate{},
+			Advanced: true,
 		}, {
 			Name: "directory_bucket",
 			Help: strings.ReplaceAll(`Set to use AWS Directory Buckets
@@ -3303,6 +3319,7 @@ type Options struct {
 	IBMInstanceID         string               `config:"ibm_resource_instance_id"`
 	UseXID                fs.Tristate          `config:"use_x_id"`
 	SignAcceptEncoding    fs.Tristate          `config:"sign_accept_encoding"`
+	UseConditionalWrites  fs.Tristate          `config:"use_conditional_writes"`
 }
 
 // Fs represents a remote s3 server
@@ -3788,23 +3805,25 @@ func setEndpointValueForIDriveE2(m configmap.Mapper) (err error) {
 //	go test -v -remote NewS3Provider:
 func setQuirks(opt *Options) {
 	var (
-		listObjectsV2         = true // Always use ListObjectsV2 instead of ListObjects
-		virtualHostStyle      = true // Use bucket.provider.com instead of putting the bucket in the URL
-		urlEncodeListings     = true // URL encode the listings to help with control characters
-		useMultipartEtag      = true // Set if Etags for multipart uploads are compatible with AWS
-		useAcceptEncodingGzip = true // Set Accept-Encoding: gzip
-		mightGzip             = true // assume all providers might use content encoding gzip until proven otherwise
-		useAlreadyExists      = true // Set if provider returns AlreadyOwnedByYou or no error if you try to remake your own bucket
-		useMultipartUploads   = true // Set if provider supports multipart uploads
-		useUnsignedPayload    = true // Do we need to use unsigned payloads to avoid seeking in PutObject
-		useXID                = true // Add x-id URL parameter into requests
-		signAcceptEncoding    = true // If we should include AcceptEncoding in the signature
+		listObjectsV2         = true  // Always use ListObjectsV2 instead of ListObjects
+		virtualHostStyle      = true  // Use bucket.provider.com instead of putting the bucket in the URL
+		urlEncodeListings     = true  // URL encode the listings to help with control characters
+		useMultipartEtag      = true  // Set if Etags for multipart uploads are compatible with AWS
+		useAcceptEncodingGzip = true  // Set Accept-Encoding: gzip
+		mightGzip             = true  // assume all providers might use content encoding gzip until proven otherwise
+		useAlreadyExists      = true  // Set if provider returns AlreadyOwnedByYou or no error if you try to remake your own bucket
+		useMultipartUploads   = true  // Set if provider supports multipart uploads
+		useUnsignedPayload    = true  // Do we need to use unsigned payloads to avoid seeking in PutObject
+		useXID                = true  // Add x-id URL parameter into requests
+		signAcceptEncoding    = true  // If we should include AcceptEncoding in the signature
+		useConditionalWrites  = false // Set if provider supports If-None-Match on PutObject
 	)
 	switch opt.Provider {
 	case "AWS":
 		// No quirks
 		mightGzip = false          // Never auto gzips objects
 		useUnsignedPayload = false // AWS has trailer support which means it adds checksums in the trailer without seeking
+		useConditionalWrites = true
 	case "Alibaba":
 		useMultipartEtag = false // Alibaba seems to calculate multipart Etags differently from AWS
 		useAlreadyExists = true  // returns 200 OK
@@ -3828,6 +3847,7 @@ func setQuirks(opt *Options) {
 	case "Cloudflare":
 		virtualHostStyle = false
 		useMultipartEtag = false // currently multipart Etags are random
+		useConditionalWrites = true
 	case "ArvanCloud":
 		listObjectsV2 = false
 		virtualHostStyle = false
@@ -3892,6 +3912,7 @@ func setQuirks(opt *Options) {
 		opt.CopyCutoff = math.MaxInt64
 	case "Minio":
 		virtualHostStyle = false
+		useConditionalWrites = true
 	case "Netease":
 		listObjectsV2 = false // untested
 		urlEncodeListings = false
@@ -4068,6 +4089,12 @@ func setQuirks(opt *Options) {
 		opt.SignAcceptEncoding.Valid = true
 		opt.SignAcceptEncoding.Value = signAcceptEncoding
 	}
+
+	// Set the correct UseConditionalWrites if not manually set
+	if !opt.UseConditionalWrites.Valid {
+		opt.UseConditionalWrites.Valid = true
+		opt.UseConditionalWrites.Value = useConditionalWrites
+	}
 }
 
 // setRoot changes the root of the Fs
@@ -4199,6 +4226,9 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		fs.Debugf(f, "Disabling multipart uploads")
 		f.features.OpenChunkWriter = nil
 	}
+	if !opt.UseConditionalWrites.Value {
+		f.features.PutIfNotExists = nil
+	}
 
 	if f.rootBucket != "" && f.rootDirectory != "" && !opt.NoHeadObject && !strings.HasSuffix(root, "/") {
 		// Check to see if the (bucket,directory) is actually an existing file
@@ -5041,6 +5071,23 @@ func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options .
 	return fs, fs.Update(ctx, in, src, options...)
 }
 
+// PutIfNotExists uploads the Object into the bucket only if there is
+// no object there already, returning fs.ErrorObjectExists if there
+// is.
+//
+// This uses If-None-Match so only works for single part uploads.
+func (f *Fs) PutIfNotExists(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	err := o.update(ctx, in, src, true, options...)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // PutStream uploads to the remote path with the modTime given of indeterminate size
 func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	return f.Put(ctx, in, src, options...)
@@ -6588,6 +6635,7 @@ type s3ChunkWriter struct {
 	md5s                 []byte
 	ui                   uploadInfo
 	o                    *Object
+	resumed              bool // set if continuing an upload from a previous run
 }
 
 // OpenChunkWriter returns the chunk size and a ChunkWriter
@@ -6632,6 +6680,13 @@ func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectIn
 		chunkSize = chunksize.Calculator(src, size, uploadParts, chunkSize)
 	}
 
+	// Continue an upload from a previous run if requested
+	for _, option := range options {
+		if resumeOption, ok := option.(*fs.ResumeOption); ok && resumeOption.ID != "" {
+			return f.resumeChunkWriter(ctx, o, ui, &mReq, int64(chunkSize), size, resumeOption)
+		}
+	}
+
 	var mOut *s3.CreateMultipartUploadOutput
 	err = f.pacer.Call(func() (bool, error) {
 		mOut, err = f.c.CreateMultipartUpload(ctx, &mReq)
@@ -6669,14 +6724,122 @@ func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectIn
 	return info, chunkWriter, err
 }
 
+// resumeChunkWriter makes a ChunkWriter to continue the multipart
+// upload described by resumeOption
+//
+// It returns an error if the upload no longer exists or its parts
+// don't match the ones in resumeOption.
+func (f *Fs) resumeChunkWriter(ctx context.Context, o *Object, ui uploadInfo, mReq *s3.CreateMultipartUploadInput, chunkSize int64, size int64, resumeOption *fs.ResumeOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	completedParts := make([]types.CompletedPart, 0, len(resumeOption.Chunks))
+	for chunkNumber, eTag := range resumeOption.Chunks {
+		completedParts = append(completedParts, types.CompletedPart{
+			PartNumber: aws.Int32(int32(chunkNumber + 1)),
+			ETag:       aws.String(eTag),
+		})
+	}
+	chunkWriter := &s3ChunkWriter{
+		chunkSize:            chunkSize,
+		size:                 size,
+		f:                    f,
+		bucket:               ui.req.Bucket,
+		key:                  ui.req.Key,
+		uploadID:             aws.String(resumeOption.ID),
+		multiPartUploadInput: mReq,
+		completedParts:       completedParts,
+		ui:                   ui,
+		o:                    o,
+		resumed:              true,
+	}
+	parts, err := chunkWriter.listParts(ctx)
+	if err != nil {
+		return info, nil, fmt.Errorf("failed to list parts of multipart upload %q: %w", resumeOption.ID, err)
+	}
+	for chunkNumber, eTag := range resumeOption.Chunks {
+		partETag, ok := parts[int32(chunkNumber+1)]
+		if !ok || strings.Trim(partETag, `"`) != strings.Trim(eTag, `"`) {
+			_ = chunkWriter.Abort(ctx)
+			return info, nil, fmt.Errorf("part %d of multipart upload %q doesn't match the one uploaded", chunkNumber+1, resumeOption.ID)
+		}
+	}
+	info = fs.ChunkWriterInfo{
+		ChunkSize:         chunkSize,
+		Concurrency:       o.fs.opt.UploadConcurrency,
+		LeavePartsOnError: o.fs.opt.LeavePartsOnError,
+	}
+	fs.Debugf(o, "open chunk writer: resuming multipart upload %v with %d parts", resumeOption.ID, len(completedParts))
+	return info, chunkWriter, nil
+}
+
+// listParts returns the ETags of the parts uploaded so far indexed
+// by part number
+func (w *s3ChunkWriter) listParts(ctx context.Context) (parts map[int32]string, err error) {
+	parts = make(map[int32]string)
+	req := s3.ListPartsInput{
+		Bucket:       w.bucket,
+		Key:          w.key,
+		UploadId:     w.uploadID,
+		RequestPayer: w.multiPartUploadInput.RequestPayer,
+	}
+	for {
+		var resp *s3.ListPartsOutput
+		err = w.f.pacer.Call(func() (bool, error) {
+			resp, err = w.f.c.ListParts(ctx, &req)
+			return w.f.shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range resp.Parts {
+			if part.PartNumber != nil {
+				parts[*part.PartNumber] = deref(part.ETag)
+			}
+		}
+		if !deref(resp.IsTruncated) || resp.NextPartNumberMarker == nil {
+			return parts, nil
+		}
+		req.PartNumberMarker = resp.NextPartNumberMarker
+	}
+}
+
+// ResumeID returns the multipart upload ID
+func (w *s3ChunkWriter) ResumeID() string {
+	return *w.uploadID
+}
+
+// ChunkToken returns the ETag of the part for chunkNumber
+func (w *s3ChunkWriter) ChunkToken(chunkNumber int) string {
+	w.completedPartsMu.Lock()
+	defer w.completedPartsMu.Unlock()
+	for _, part := range w.completedParts {
+		if int(*part.PartNumber) == chunkNumber+1 {
+			return deref(part.ETag)
+		}
+	}
+	return ""
+}
+
+// Resumed returns true if this is continuing a previous multipart upload
+func (w *s3ChunkWriter) Resumed() bool {
+	return w.resumed
+}
+
 // add a part number and etag to the completed parts
+//
+// If the part was written before it is replaced.
 func (w *s3ChunkWriter) addCompletedPart(partNum *int32, eTag *string) {
 	w.completedPartsMu.Lock()
 	defer w.completedPartsMu.Unlock()
-	w.completedParts = append(w.completedParts, types.CompletedPart{
+	part := types.CompletedPart{
 		PartNumber: partNum,
 		ETag:       eTag,
-	})
+	}
+	for i := range w.completedParts {
+		if *w.completedParts[i].PartNumber == *partNum {
+			w.completedParts[i] = part
+			return
+		}
+	}
+	w.completedParts = append(w.completedParts, part)
 }
 
 // addMd5 adds a binary md5 to the md5 calculated so far
@@ -7112,11 +7275,22 @@ func (o *Object) prepareUpload(ctx context.Context, src fs.ObjectInfo, options [
 
 // Update the Object from in with modTime and size
 func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return o.update(ctx, in, src, false, options...)
+}
+
+// update the Object from in with modTime and size
+//
+// If ifNotExists is set then the upload is made with If-None-Match
+// and fs.ErrorObjectExists is returned if the object exists.
+func (o *Object) update(ctx context.Context, in io.Reader, src fs.ObjectInfo, ifNotExists bool, options ...fs.OpenOption) error {
 	if o.fs.opt.VersionAt.IsSet() {
 		return errNotWithVersionAt
 	}
 	size := src.Size()
 	multipart := size < 0 || size >= int64(o.fs.opt.UploadCutoff)
+	if ifNotExists && multipart {
+		return fmt.Errorf("can't upload if not exists with a multipart upload: size %d: %w", size, fs.ErrorNotImplemented)
+	}
 
 	var wantETag string        // Multipart upload Etag to check
 	var gotETag string         // Etag we got from the upload
@@ -7132,7 +7306,18 @@ func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, op
 			return fmt.Errorf("failed to prepare upload: %w", err)
 		}
 
-		if o.fs.opt.UsePresignedRequest {
+		if ifNotExists {
+			ui.req.IfNoneMatch = aws.String("*")
+			gotETag, lastModified, versionID, err = o.uploadSinglepartPutObject(ctx, ui.req, size, in)
+			var awsErr smithy.APIError
+			if errors.As(err, &awsErr) {
+				switch awsErr.ErrorCode() {
+				case "PreconditionFailed", "ConditionalRequestConflict":
+					// ConditionalRequestConflict means another conditional write is in progress
+					return fs.ErrorObjectExists
+				}
+			}
+		} else if o.fs.opt.UsePresignedRequest {
 			gotETag, lastModified, versionID, err = o.uploadSinglepartPresignedRequest(ctx, ui.req, size, in)
 		} else {
 			gotETag, lastModified, versionID, err = o.uploadSinglepartPutObject(ctx, ui.req, size, in)
@@ -7294,18 +7479,20 @@ func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error)
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs              = &Fs{}
-	_ fs.Purger          = &Fs{}
-	_ fs.Copier          = &Fs{}
-	_ fs.PutStreamer     = &Fs{}
-	_ fs.ListRer         = &Fs{}
-	_ fs.ListPer         = &Fs{}
-	_ fs.Commander       = &Fs{}
-	_ fs.CleanUpper      = &Fs{}
-	_ fs.OpenChunkWriter = &Fs{}
-	_ fs.Object          = &Object{}
-	_ fs.MimeTyper       = &Object{}
-	_ fs.GetTierer       = &Object{}
-	_ fs.SetTierer       = &Object{}
-	_ fs.Metadataer      = &Object{}
+	_ fs.Fs                 = &Fs{}
+	_ fs.Purger             = &Fs{}
+	_ fs.Copier             = &Fs{}
+	_ fs.PutStreamer        = &Fs{}
+	_ fs.PutIfNotExistser   = &Fs{}
+	_ fs.ListRer            = &Fs{}
+	_ fs.ListPer            = &Fs{}
+	_ fs.Commander          = &Fs{}
+	_ fs.CleanUpper         = &Fs{}
+	_ fs.OpenChunkWriter    = &Fs{}
+	_ fs.ChunkWriterResumer = &s3ChunkWriter{}
+	_ fs.Object             = &Object{}
+	_ fs.MimeTyper          = &Object{}
+	_ fs.GetTierer          = &Object{}
+	_ fs.SetTierer          = &Object{}
+	_ fs.Metadataer         = &Object{}
 )
@@ -163,6 +163,19 @@ func TestVersionLess(t *testing.T) {
 	}
 }
 
+func TestAddCompletedPart(t *testing.T) {
+	w := &s3ChunkWriter{}
+	w.addCompletedPart(aws.Int32(2), aws.String("b"))
+	w.addCompletedPart(aws.Int32(1), aws.String("a"))
+	// A retried part replaces the first attempt
+	w.addCompletedPart(aws.Int32(2), aws.String("c"))
+	require.Len(t, w.completedParts, 2)
+	for _, part := range w.completedParts {
+		want := map[int32]string{1: "a", 2: "c"}[*part.PartNumber]
+		assert.Equal(t, want, *part.ETag)
+	}
+}
+
 func TestMergeDeleteMarkers(t *testing.T) {
 	key1 := "key1"
 	key2 := "key2"
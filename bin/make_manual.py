@@ -32,6 +32,7 @@ docs = [
     "fichier.md",
     "alias.md",
     "s3.md",
+    "archive.md",
     "b2.md",
     "box.md",
     "cache.md",
@@ -64,6 +65,7 @@ docs = [
     "mailru.md",
     "mega.md",
     "memory.md",
+    "mirror.md",
     "netstorage.md",
     "azureblob.md",
     "azurefiles.md",
@@ -72,6 +74,10 @@ docs = [
     "oracleobjectstorage/_index.md",
     "qingstor.md",
     "quatrix.md",
+    "quota.md",
+    "raid.md",
+    "ratelimit.md",
+    "readonly.md",
     "sia.md",
     "swift.md",
     "pcloud.md",
@@ -0,0 +1,204 @@
+// Package archive provides the archive command.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/spf13/cobra"
+)
+
+// Globals
+var (
+	formatName         = ""
+	createEmptySrcDirs = false
+)
+
+func init() {
+	cmd.Root.AddCommand(Command)
+	Command.AddCommand(createCommand, extractCommand)
+	for _, command := range []*cobra.Command{createCommand, extractCommand} {
+		flags.StringVarP(command.Flags(), &formatName, "format", "", formatName, "Archive format: zip, tar, tar.gz or tar.zst (default from the file extension)", "")
+	}
+	flags.BoolVarP(extractCommand.Flags(), &createEmptySrcDirs, "create-empty-src-dirs", "", createEmptySrcDirs, "Create empty directories in the archive on destination", "")
+}
+
+// format is an archive format
+type format string
+
+// Archive formats
+const (
+	formatZip     format = "zip"
+	formatTar     format = "tar"
+	formatTarGz   format = "tar.gz"
+	formatTarZstd format = "tar.zst"
+)
+
+// formatExtensions maps file extensions onto formats, longest first
+var formatExtensions = []struct {
+	ext    string
+	format format
+}{
+	{".tar.gz", formatTarGz},
+	{".tar.zst", formatTarZstd},
+	{".tgz", formatTarGz},
+	{".tzst", formatTarZstd},
+	{".zip", formatZip},
+	{".tar", formatTar},
+}
+
+// getFormat returns the format named by name, or the format of
+// fileName from its extension if name is empty
+func getFormat(name, fileName string) (format, error) {
+	if name != "" {
+		switch f := format(strings.ToLower(name)); f {
+		case formatZip, formatTar, formatTarGz, formatTarZstd:
+			return f, nil
+		case "tgz":
+			return formatTarGz, nil
+		case "tzst":
+			return formatTarZstd, nil
+		}
+		return "", fmt.Errorf("unknown archive format %q - use zip, tar, tar.gz or tar.zst", name)
+	}
+	lowerName := strings.ToLower(fileName)
+	for _, x := range formatExtensions {
+		if strings.HasSuffix(lowerName, x.ext) {
+			return x.format, nil
+		}
+	}
+	return "", fmt.Errorf("can't work out the archive format of %q - use --format", fileName)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "archive <subcommand>",
+	Short: `Create and extract archives between remotes.`,
+	Long: strings.ReplaceAll(`Rclone archive streams zip and tar archives from any remote to
+any other remote without storing them locally.
+
+These archive formats are supported
+
+- |zip| - files are compressed with deflate
+- |tar| - uncompressed tar
+- |tar.gz| - tar compressed with gzip (also |.tgz|)
+- |tar.zst| - tar compressed with zstd (also |.tzst|)
+
+The format is worked out from the extension of the archive file name,
+or can be set with |--format|.
+
+Select which action you want with the subcommand, eg
+
+|||sh
+rclone archive create remote:logs s3:bucket/logs-2026.tar.zst
+rclone archive extract s3:bucket/logs-2026.tar.zst /tmp/logs
+|||
+
+Use |rclone archive create --help| and |rclone archive extract --help|
+for the details of each subcommand. The files inside archives can be
+listed and read without extracting them with the [archive
+backend](/archive/).`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+}
+
+var createCommand = &cobra.Command{
+	Use:   "create source:path dest:path/archive",
+	Short: `Archive the files in source:path into dest:path/archive.`,
+	Long: strings.ReplaceAll(`Writes the files in source:path into a new archive at
+dest:path/archive, overwriting it if it exists.
+
+The archive is uploaded while it is being written, so it is never
+stored locally unless the destination can't upload files of unknown
+size, in which case it is spooled to a temporary file first.
+
+The filters are applied to the source, so only the files and
+directories included by the filters are archived, and |--max-depth|
+limits how deep the source is read.
+
+The modification times of files and directories are stored in the
+archive. With |--metadata| the permissions are taken from the |mode|
+metadata of the source if it has it, otherwise files are stored with
+permissions 0644 and directories with 0755.
+
+The progress shows each file being read as it is archived and the
+archive being uploaded.
+
+Tar archives need the size of each file before it is written, so
+sources which have files of unknown size, like Google Docs, can only be
+archived with zip.
+
+Bundle millions of small files into one archive ready for a cold
+storage class like this
+
+    rclone archive create remote:photos s3:bucket/photos.tar --s3-storage-class DEEP_ARCHIVE`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Copy,Filter,Listing",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc := cmd.NewFsSrc(args[:1])
+		fdst, dstFileName := cmd.NewFsDstFile(args[1:])
+		archiveFormat, err := getFormat(formatName, dstFileName)
+		if err != nil {
+			fs.Fatal(nil, err.Error())
+		}
+		cmd.Run(true, true, command, func() error {
+			return Create(context.Background(), fsrc, fdst, dstFileName, archiveFormat)
+		})
+	},
+}
+
+var extractCommand = &cobra.Command{
+	Use:   "extract source:path/archive dest:path",
+	Short: `Extract the files in source:path/archive into dest:path.`,
+	Long: strings.ReplaceAll(`Copies the files out of the archive at source:path/archive into
+dest:path, overwriting any files there with the same names. Files
+already in dest:path which aren't in the archive are left alone.
+
+Nothing is stored locally while extracting. Tar archives are read from
+start to finish once. Zip archives are read with ranged reads using the
+index at the end of the archive, so only the files which are
+extracted are downloaded, and files already in dest:path which are
+identical are skipped as with |rclone copy|.
+
+The filters are applied to the names of the files in the archive, so
+only the files included by the filters are extracted.
+
+The modification times in the archive are set on the extracted files.
+With |--metadata| the permissions of files in tar archives are set
+too, on destinations which support the |mode| metadata.
+
+Directories are made for the files extracted into them. Use
+|--create-empty-src-dirs| to make the empty directories in the archive
+too.
+
+Symbolic links and other special files in tar archives are skipped.
+Names in the archive which would be outside of dest:path are put
+inside it.`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Copy,Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc, srcFileName := cmd.NewFsFile(args[0])
+		if srcFileName == "" {
+			fs.Fatalf(nil, "%q is not a file", args[0])
+		}
+		fdst := cmd.NewFsDir(args[1:])
+		archiveFormat, err := getFormat(formatName, srcFileName)
+		if err != nil {
+			fs.Fatal(nil, err.Error())
+		}
+		cmd.Run(true, true, command, func() error {
+			return Extract(context.Background(), fsrc, srcFileName, fdst, archiveFormat, createEmptySrcDirs)
+		})
+	},
+}
@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Some times used in the tests
+var (
+	t1 = fstest.Time("2001-02-03T04:05:06Z")
+	t2 = fstest.Time("2011-12-25T12:59:59Z")
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+func TestGetFormat(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		fileName string
+		want     format
+		wantErr  bool
+	}{
+		{"", "a.zip", formatZip, false},
+		{"", "A.TAR", formatTar, false},
+		{"", "a.tar.gz", formatTarGz, false},
+		{"", "a.tgz", formatTarGz, false},
+		{"", "a.tar.zst", formatTarZstd, false},
+		{"", "a.tzst", formatTarZstd, false},
+		{"", "a.txt", "", true},
+		{"tar.gz", "a.txt", formatTarGz, false},
+		{"ZIP", "a.tar", formatZip, false},
+		{"rar", "a.rar", "", true},
+	} {
+		got, err := getFormat(test.name, test.fileName)
+		assert.Equal(t, test.want, got, test.fileName)
+		assert.Equal(t, test.wantErr, err != nil, test.fileName)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("a.txt", "hello", t1)
+	file2 := r.WriteFile("dir/b.txt", "hello world", t2)
+	file3 := r.WriteFile("dir/sub/c.log", "potato", t1)
+	items := []fstest.Item{file1, file2, file3}
+	dirs := []string{"dir", "dir/sub"}
+
+	for _, test := range []struct {
+		name   string
+		format format
+	}{
+		{"test.zip", formatZip},
+		{"test.tar", formatTar},
+		{"test.tar.gz", formatTarGz},
+		{"test.tar.zst", formatTarZstd},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, Create(ctx, r.Flocal, r.Fremote, test.name, test.format))
+			o, err := r.Fremote.NewObject(ctx, test.name)
+			require.NoError(t, err)
+			assert.Greater(t, o.Size(), int64(0))
+
+			fdst, err := fs.NewFs(ctx, t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, Extract(ctx, r.Fremote, test.name, fdst, test.format, false))
+			fstest.CheckListingWithPrecision(t, fdst, items, dirs, time.Second)
+
+			// Filters apply to the names in the archive
+			fi, err := filter.NewFilter(nil)
+			require.NoError(t, err)
+			require.NoError(t, fi.AddRule("- *.log"))
+			filterCtx := filter.ReplaceConfig(ctx, fi)
+			fdst, err = fs.NewFs(ctx, t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, Extract(filterCtx, r.Fremote, test.name, fdst, test.format, false))
+			fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{file1, file2}, []string{"dir"}, time.Second)
+
+			// Empty directories are only made when asked for
+			fdst, err = fs.NewFs(ctx, t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, Extract(filterCtx, r.Fremote, test.name, fdst, test.format, true))
+			fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{file1, file2}, dirs, time.Second)
+
+			// Filters apply to the source when creating
+			require.NoError(t, Create(filterCtx, r.Flocal, r.Fremote, "filtered-"+test.name, test.format))
+			fdst, err = fs.NewFs(ctx, t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, Extract(ctx, r.Fremote, "filtered-"+test.name, fdst, test.format, false))
+			fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{file1, file2}, []string{"dir"}, time.Second)
+		})
+	}
+}
+
+func TestArchiveMode(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.Metadata = true
+	r := fstest.NewRun(t)
+	r.WriteFile("secret.txt", "hello", t1)
+	require.NoError(t, os.Chmod(filepath.Join(r.LocalName, "secret.txt"), 0600))
+
+	for _, test := range []struct {
+		name   string
+		format format
+	}{
+		{"test.zip", formatZip},
+		{"test.tar", formatTar},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, Create(ctx, r.Flocal, r.Fremote, test.name, test.format))
+			dir := t.TempDir()
+			fdst, err := fs.NewFs(ctx, dir)
+			require.NoError(t, err)
+			require.NoError(t, Extract(ctx, r.Fremote, test.name, fdst, test.format, false))
+			fi, err := os.Stat(filepath.Join(dir, "secret.txt"))
+			require.NoError(t, err)
+			if test.format == formatZip {
+				// Permissions aren't restored from zip archives
+				assert.Equal(t, os.FileMode(0644), fi.Mode().Perm()&^0022)
+			} else {
+				assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExtractUnsafeNames(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"a/b.txt", "a/b.txt"},
+		{"./a/b.txt", "a/b.txt"},
+		{"/a/b.txt", "a/b.txt"},
+		{"../../a/b.txt", "a/b.txt"},
+		{"a/../../b.txt", "b.txt"},
+		{"./", ""},
+		{"..", ""},
+	} {
+		assert.Equal(t, test.want, cleanName(test.in), test.in)
+	}
+}
@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/list"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// Default permissions used when the source doesn't supply any
+const (
+	defaultFileMode = 0644
+	defaultDirMode  = 0755
+)
+
+// archiveWriter writes the entries of an archive in order
+type archiveWriter interface {
+	// dir adds the directory name
+	dir(name string, modTime time.Time, mode os.FileMode) error
+	// file adds the file name returning a writer for its contents
+	file(name string, size int64, modTime time.Time, mode os.FileMode) (io.Writer, error)
+	// Close finishes the archive
+	Close() error
+}
+
+// newArchiveWriter makes an archiveWriter of format writing to out
+func newArchiveWriter(out io.Writer, format format) (archiveWriter, error) {
+	switch format {
+	case formatZip:
+		return &zipWriter{zw: zip.NewWriter(out)}, nil
+	case formatTar:
+		return &tarWriter{tw: tar.NewWriter(out)}, nil
+	case formatTarGz:
+		gz := gzip.NewWriter(out)
+		return &tarWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case formatTarZstd:
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %q", format)
+}
+
+// zipWriter writes zip archives
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) dir(name string, modTime time.Time, mode os.FileMode) error {
+	fh := &zip.FileHeader{Name: name + "/", Modified: modTime}
+	fh.SetMode(mode | os.ModeDir)
+	_, err := w.zw.CreateHeader(fh)
+	return err
+}
+
+func (w *zipWriter) file(name string, size int64, modTime time.Time, mode os.FileMode) (io.Writer, error) {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	fh.SetMode(mode)
+	return w.zw.CreateHeader(fh)
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// tarWriter writes tar archives, compressed if compressor is set
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (w *tarWriter) dir(name string, modTime time.Time, mode os.FileMode) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(mode.Perm()),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (w *tarWriter) file(name string, size int64, modTime time.Time, mode os.FileMode) (io.Writer, error) {
+	if size < 0 {
+		return nil, errors.New("can't add files of unknown size to tar archives")
+	}
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode.Perm()),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	return w.tw, err
+}
+
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if w.compressor != nil {
+		if closeErr := w.compressor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// entryMode returns the permissions of entry from its metadata if
+// --metadata is in use, or def if they aren't known
+func entryMode(ctx context.Context, fdst fs.Fs, entry fs.DirEntry, def os.FileMode) os.FileMode {
+	meta, err := fs.GetMetadataOptions(ctx, fdst, entry, nil)
+	if err != nil {
+		fs.Debugf(entry, "Failed to read metadata: %v", err)
+		return def
+	}
+	mode, ok := meta["mode"]
+	if !ok {
+		return def
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		fs.Debugf(entry, "Ignoring bad mode %q: %v", mode, err)
+		return def
+	}
+	return os.FileMode(value).Perm()
+}
+
+// archiver writes the files on a remote into an archive
+type archiver struct {
+	fsrc fs.Fs
+	fdst fs.Fs
+	aw   archiveWriter
+}
+
+// addDir adds the contents of dir on the source recursively
+func (a *archiver) addDir(ctx context.Context, dir string, depth int) error {
+	entries, err := list.DirSorted(ctx, a.fsrc, false, dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %q: %w", dir, err)
+	}
+	ci := fs.GetConfig(ctx)
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Object:
+			err = a.addFile(ctx, x)
+			if err != nil {
+				return fmt.Errorf("failed to archive %q: %w", x.Remote(), err)
+			}
+		case fs.Directory:
+			err = a.aw.dir(x.Remote(), x.ModTime(ctx), entryMode(ctx, a.fdst, x, defaultDirMode))
+			if err != nil {
+				return err
+			}
+			if ci.MaxDepth < 0 || depth < ci.MaxDepth {
+				err = a.addDir(ctx, x.Remote(), depth+1)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addFile adds the contents of o to the archive
+func (a *archiver) addFile(ctx context.Context, o fs.Object) (err error) {
+	tr := accounting.Stats(ctx).NewCheckingTransfer(o, "archiving")
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	size := o.Size()
+	w, err := a.aw.file(o.Remote(), size, o.ModTime(ctx), entryMode(ctx, a.fdst, o, defaultFileMode))
+	if err != nil {
+		return err
+	}
+	in, err := operations.Open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	if size < 0 {
+		_, err = io.Copy(w, in)
+		return err
+	}
+	// Tar archives must get exactly the size in the header
+	_, err = io.CopyN(w, in, size)
+	if err == io.EOF {
+		return fmt.Errorf("file shrank while archiving: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return err
+	}
+	n, _ := in.Read(make([]byte, 1))
+	if n != 0 {
+		return errors.New("file grew while archiving")
+	}
+	return nil
+}
+
+// Create streams the files in fsrc into an archive of format called
+// dstFileName on fdst.
+//
+// The archive is uploaded as it is written so nothing is stored
+// locally unless fdst can't stream uploads.
+func Create(ctx context.Context, fsrc, fdst fs.Fs, dstFileName string, format format) (err error) {
+	pr, pw := io.Pipe()
+	aw, err := newArchiveWriter(pw, format)
+	if err != nil {
+		return err
+	}
+	a := &archiver{fsrc: fsrc, fdst: fdst, aw: aw}
+	done := make(chan error, 1)
+	go func() {
+		err := a.addDir(ctx, "", 1)
+		if closeErr := aw.Close(); err == nil {
+			err = closeErr
+		}
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	_, err = operations.Rcat(ctx, fdst, dstFileName, pr, time.Now(), nil)
+	// Stop the writer if the upload failed early
+	_ = pr.CloseWithError(err)
+	writeErr := <-done
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %q: %w", path.Join(fdst.Root(), dstFileName), err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	archivebackend "github.com/rclone/rclone/backend/archive"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/sync"
+)
+
+// Extract unpacks the archive of format called srcFileName on fsrc
+// into fdst.
+//
+// If createEmptySrcDirs is set the empty directories in the archive
+// are made on fdst too.
+func Extract(ctx context.Context, fsrc fs.Fs, srcFileName string, fdst fs.Fs, format format, createEmptySrcDirs bool) error {
+	if format == formatZip {
+		return extractZip(ctx, fsrc, srcFileName, fdst, createEmptySrcDirs)
+	}
+	return extractTar(ctx, fsrc, srcFileName, fdst, format, createEmptySrcDirs)
+}
+
+// extractZip copies the files out of a zip archive
+//
+// Zip files have their index at the end so they are read with the
+// archive backend which uses ranged reads to find the files.
+func extractZip(ctx context.Context, fsrc fs.Fs, srcFileName string, fdst fs.Fs, createEmptySrcDirs bool) error {
+	farchive, err := archivebackend.NewFs(ctx, "archive", srcFileName, configmap.Simple{
+		"remote": fs.ConfigStringFull(fsrc),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	return sync.CopyDir(ctx, fdst, farchive, createEmptySrcDirs)
+}
+
+// cleanName returns the name of a tar entry relative to the
+// destination, or "" if it should be skipped
+func cleanName(name string) string {
+	name = path.Clean("/" + strings.TrimPrefix(name, "./"))
+	if name == "/" {
+		return ""
+	}
+	return name[1:]
+}
+
+// extractTar streams the files out of a tar archive
+func extractTar(ctx context.Context, fsrc fs.Fs, srcFileName string, fdst fs.Fs, format format, createEmptySrcDirs bool) (err error) {
+	o, err := fsrc.NewObject(ctx, srcFileName)
+	if err != nil {
+		return fmt.Errorf("failed to find archive: %w", err)
+	}
+	tr := accounting.Stats(ctx).NewCheckingTransfer(o, "extracting")
+	defer func() {
+		tr.Done(ctx, err)
+	}()
+	rc, err := operations.Open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(rc, &err)
+	var in io.Reader = rc
+	switch format {
+	case formatTarGz:
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decompress archive: %w", err)
+		}
+		defer fs.CheckClose(gz, &err)
+		in = gz
+	case formatTarZstd:
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decompress archive: %w", err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	fi := filter.GetConfig(ctx)
+	var lastErr error
+	tarReader := tar.NewReader(in)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		remote := cleanName(hdr.Name)
+		if remote == "" {
+			continue
+		}
+		meta := fs.Metadata{"mode": fmt.Sprintf("%0o", hdr.Mode&0o7777)}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if !createEmptySrcDirs || !fi.IncludeRemote(remote+"/") {
+				continue
+			}
+			err = operations.Mkdir(ctx, fdst, remote)
+			if err != nil {
+				err = fs.CountError(ctx, err)
+				fs.Errorf(remote, "Failed to make directory: %v", err)
+			}
+		case tar.TypeReg:
+			if !fi.Include(remote, hdr.Size, hdr.ModTime, meta) {
+				fs.Debugf(remote, "Excluded from extraction")
+				continue
+			}
+			_, err = operations.RcatSize(ctx, fdst, remote, io.NopCloser(tarReader), hdr.Size, hdr.ModTime, meta)
+		default:
+			fs.Logf(remote, "Skipping unsupported tar entry type %q", hdr.Typeflag)
+			continue
+		}
+		// Errors have already been counted and logged
+		if err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return errors.New("not all files were extracted")
+	}
+	return nil
+}
@@ -40,6 +40,7 @@ var rcHelp = makeHelp(`This takes the following parameters
 - backupdir1 - --backup-dir for Path1. Must be a non-overlapping path on the same remote.
 - backupdir2 - --backup-dir for Path2. Must be a non-overlapping path on the same remote.
 - noCleanup - retain working files
+- remoteLock - also take a lock on the remote at this path e.g. |remote:path/name|
 
 See [bisync command help](https://rclone.org/commands/rclone_bisync/)
 and [full bisync description](https://rclone.org/bisync/)
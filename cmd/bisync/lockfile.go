@@ -1,16 +1,21 @@
 package bisync
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/rclone/rclone/cmd/bisync/bilib"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/lock"
 	"github.com/rclone/rclone/lib/terminal"
 )
 
@@ -51,7 +56,60 @@ func (b *bisyncRun) setLockFile() (err error) {
 	return nil
 }
 
+// setRemoteLock takes the lock given by --remote-lock which stops
+// bisync runs on other hosts using the same remote lock interfering
+func (b *bisyncRun) setRemoteLock(ctx context.Context) (err error) {
+	if b.opt.RemoteLock == "" || b.opt.DryRun {
+		return nil
+	}
+	parent, leaf, err := fspath.Split(b.opt.RemoteLock)
+	if err != nil {
+		return fmt.Errorf("bad --remote-lock %q: %w", b.opt.RemoteLock, err)
+	}
+	if leaf == "" {
+		return fmt.Errorf("--remote-lock %q needs a lock name after the remote", b.opt.RemoteLock)
+	}
+	f, err := cache.Get(ctx, parent)
+	if err != nil {
+		return fmt.Errorf("cannot open --remote-lock remote: %w", err)
+	}
+	opt := lock.Options{
+		TTL:   lock.DefaultTTL,
+		Owner: fmt.Sprintf("bisync %s pid %d", filepath.Base(b.basePath), os.Getpid()),
+		Renew: true,
+	}
+	if b.opt.MaxLock < basicallyforever {
+		opt.TTL = time.Duration(b.opt.MaxLock)
+	}
+	b.remoteLock, err = lock.Acquire(ctx, f, leaf, opt)
+	if err != nil {
+		return fmt.Errorf(Color(terminal.RedFg, "cannot take remote lock %s: %w"), b.opt.RemoteLock, err)
+	}
+	fs.Infof(nil, "Remote lock taken: %s", b.opt.RemoteLock)
+	return nil
+}
+
+// removeRemoteLock releases the lock taken by setRemoteLock
+func (b *bisyncRun) removeRemoteLock() (err error) {
+	if b.remoteLock != nil {
+		err = b.remoteLock.Release(context.Background())
+		if err == nil {
+			fs.Debugf(nil, "Remote lock released: %s", b.opt.RemoteLock)
+		} else {
+			fs.Errorf(nil, "cannot release remote lock %s: %v", b.opt.RemoteLock, err)
+		}
+		b.remoteLock = nil // block releasing it again
+	}
+	return err
+}
+
 func (b *bisyncRun) removeLockFile() (err error) {
+	remoteErr := b.removeRemoteLock()
+	defer func() {
+		if err == nil {
+			err = remoteErr
+		}
+	}()
 	if b.lockFile != "" {
 		b.lockFileOpt.stopRenewal()
 		err = os.Remove(b.lockFile)
@@ -17,6 +17,7 @@ import (
 	"github.com/rclone/rclone/cmd/bisync/bilib"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/lock"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/lib/atexit"
@@ -56,6 +57,7 @@ type bisyncRun struct {
 	queueOpt           bisyncQueueOpt
 	downloadHashOpt    downloadHashOpt
 	lockFileOpt        lockFileOpt
+	remoteLock         *lock.Lock
 }
 
 type queues struct {
@@ -129,6 +131,11 @@ func Bisync(ctx context.Context, fs1, fs2 fs.Fs, optArg *Options) (err error) {
 	if err != nil {
 		return err
 	}
+	err = b.setRemoteLock(ctx)
+	if err != nil {
+		_ = b.removeLockFile()
+		return err
+	}
 
 	b.queueOpt.logger = operations.NewLoggerOpt()
 
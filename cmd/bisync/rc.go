@@ -80,6 +80,9 @@ func rcBisync(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	if opt.BackupDir2, err = in.GetString("backupdir2"); rc.NotErrParamNotFound(err) {
 		return
 	}
+	if opt.RemoteLock, err = in.GetString("remoteLock"); rc.NotErrParamNotFound(err) {
+		return
+	}
 
 	checkSync, err := in.GetString("checkSync")
 	if rc.NotErrParamNotFound(err) {
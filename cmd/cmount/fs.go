@@ -599,6 +599,8 @@ func translateError(err error) (errc int) {
 		return -fuse.EINVAL
 	case vfs.ELOOP:
 		return -fuse.ELOOP
+	case vfs.EAGAIN:
+		return -fuse.EAGAIN
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return -fuse.EIO
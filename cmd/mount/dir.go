@@ -227,7 +227,7 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	}
 	node = &File{file, d.fsys}
 	file.SetSys(node) // cache the FUSE node for later
-	return node, &FileHandle{fh}, err
+	return node, newFileHandle(fh), err
 }
 
 var _ fusefs.NodeMkdirer = (*Dir)(nil)
@@ -162,7 +162,7 @@ func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenR
 		resp.Flags |= fuse.OpenDirectIO
 	}
 
-	return &FileHandle{handle}, nil
+	return newFileHandle(handle), nil
 }
 
 // Check interface satisfied
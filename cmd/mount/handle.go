@@ -4,7 +4,11 @@ package mount
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math"
+	"sync"
+	"syscall"
 
 	"bazil.org/fuse"
 	fusefs "bazil.org/fuse/fs"
@@ -15,6 +19,13 @@ import (
 // FileHandle is an open for read file handle on a File
 type FileHandle struct {
 	vfs.Handle
+	mu     sync.Mutex
+	owners map[fuse.LockOwner]struct{} // owners which have taken locks through this handle
+}
+
+// newFileHandle makes a FileHandle from a vfs.Handle
+func newFileHandle(handle vfs.Handle) *FileHandle {
+	return &FileHandle{Handle: handle}
 }
 
 // Check interface satisfied
@@ -67,6 +78,8 @@ var _ fusefs.HandleFlusher = (*FileHandle)(nil)
 // some writes, or that if will be called at all.
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// POSIX locks are released on any close of the file
+	fh.unlockOwner(req.LockOwner)
 	return translateError(fh.Handle.Flush())
 }
 
@@ -78,5 +91,116 @@ var _ fusefs.HandleReleaser = (*FileHandle)(nil)
 // the kernel
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	fh.unlockAll()
 	return translateError(fh.Handle.Release())
 }
+
+// lockOwner returns the VFS lock owner for the FUSE lock owner
+func lockOwner(owner fuse.LockOwner) string {
+	return fmt.Sprintf("mount:%x", uint64(owner))
+}
+
+// vfsLock converts a FUSE lock request into a VFS lock
+func (fh *FileHandle) vfsLock(owner fuse.LockOwner, lk fuse.FileLock) vfs.Lock {
+	l := vfs.Lock{
+		Owner: lockOwner(owner),
+		Type:  vfs.LockRead,
+		Start: int64(lk.Start),
+		End:   vfs.LockEOF,
+	}
+	if lk.Type == fuse.LockWrite {
+		l.Type = vfs.LockWrite
+	}
+	// FUSE lock ranges are inclusive
+	if lk.End < math.MaxInt64 {
+		l.End = int64(lk.End) + 1
+	}
+	return l
+}
+
+// addOwner records that owner has taken locks through this handle
+func (fh *FileHandle) addOwner(owner fuse.LockOwner) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.owners == nil {
+		fh.owners = make(map[fuse.LockOwner]struct{})
+	}
+	fh.owners[owner] = struct{}{}
+}
+
+// unlockOwner releases the locks owner holds on the file whichever
+// handle they were taken through
+func (fh *FileHandle) unlockOwner(owner fuse.LockOwner) {
+	node := fh.Handle.Node()
+	node.VFS().UnlockAll(node.Path(), lockOwner(owner))
+}
+
+// unlockAll releases the locks of every owner which used this handle
+func (fh *FileHandle) unlockAll() {
+	fh.mu.Lock()
+	owners := fh.owners
+	fh.owners = nil
+	fh.mu.Unlock()
+	node := fh.Handle.Node()
+	for owner := range owners {
+		node.VFS().UnlockAll(node.Path(), lockOwner(owner))
+	}
+}
+
+// Check interface satisfied
+var (
+	_ fusefs.HandleFlockLocker = (*FileHandle)(nil)
+	_ fusefs.HandlePOSIXLocker = (*FileHandle)(nil)
+)
+
+// Lock tries to take a byte range lock on the file without waiting
+func (fh *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, range=%d..%d, type=%v", req.LockOwner, req.Lock.Start, req.Lock.End, req.Lock.Type)("err=%v", &err)
+	node := fh.Handle.Node()
+	fh.addOwner(req.LockOwner)
+	return translateError(node.VFS().Lock(node.Path(), fh.vfsLock(req.LockOwner, req.Lock)))
+}
+
+// LockWait takes a byte range lock on the file, waiting until it is
+// available or the request is interrupted
+func (fh *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, range=%d..%d, type=%v", req.LockOwner, req.Lock.Start, req.Lock.End, req.Lock.Type)("err=%v", &err)
+	node := fh.Handle.Node()
+	fh.addOwner(req.LockOwner)
+	err = node.VFS().LockWait(ctx, node.Path(), fh.vfsLock(req.LockOwner, req.Lock))
+	if ctx.Err() != nil {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return translateError(err)
+}
+
+// Unlock releases a byte range lock on the file
+func (fh *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) (err error) {
+	defer log.Trace(fh, "owner=%v, range=%d..%d", req.LockOwner, req.Lock.Start, req.Lock.End)("err=%v", &err)
+	node := fh.Handle.Node()
+	node.VFS().Unlock(node.Path(), fh.vfsLock(req.LockOwner, req.Lock))
+	return nil
+}
+
+// QueryLock returns a lock which would conflict with the one in req
+func (fh *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(fh, "owner=%v, range=%d..%d, type=%v", req.LockOwner, req.Lock.Start, req.Lock.End, req.Lock.Type)("err=%v", &err)
+	node := fh.Handle.Node()
+	conflict, found := node.VFS().TestLock(node.Path(), fh.vfsLock(req.LockOwner, req.Lock))
+	if !found {
+		return nil
+	}
+	resp.Lock = fuse.FileLock{
+		Start: uint64(conflict.Start),
+		End:   math.MaxInt64,
+		Type:  fuse.LockRead,
+		PID:   -1, // held by a remote party
+	}
+	if conflict.End != vfs.LockEOF {
+		resp.Lock.End = uint64(conflict.End - 1)
+	}
+	if conflict.Type == vfs.LockWrite {
+		resp.Lock.Type = fuse.LockWrite
+	}
+	return nil
+}
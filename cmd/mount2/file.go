@@ -6,6 +6,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"sync"
 	"syscall"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
@@ -33,8 +35,10 @@ import (
 // FOPEN_DIRECT_IO flag from their `Open` method. See directio_test.go
 // for an example.
 type FileHandle struct {
-	h    vfs.Handle
-	fsys *FS
+	h      vfs.Handle
+	fsys   *FS
+	mu     sync.Mutex
+	owners map[uint64]struct{} // owners which have taken locks through this handle
 }
 
 // Create a new FileHandle
@@ -95,6 +99,7 @@ var _ fusefs.FileFlusher = (*FileHandle)(nil)
 // so any cleanup that requires specific synchronization or
 // could fail with I/O errors should happen in Flush instead.
 func (f *FileHandle) Release(ctx context.Context) syscall.Errno {
+	f.unlockAll()
 	return translateError(f.h.Release())
 }
 
@@ -149,3 +154,109 @@ func (f *FileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.
 }
 
 var _ fusefs.FileSetattrer = (*FileHandle)(nil)
+
+// lockOwner returns the VFS lock owner for the FUSE lock owner
+func lockOwner(owner uint64) string {
+	return fmt.Sprintf("mount2:%x", owner)
+}
+
+// vfsLock converts a FUSE lock into a VFS lock
+func vfsLock(owner uint64, lk *fuse.FileLock) vfs.Lock {
+	l := vfs.Lock{
+		Owner: lockOwner(owner),
+		Type:  vfs.LockRead,
+		Start: int64(lk.Start),
+		End:   vfs.LockEOF,
+	}
+	if lk.Typ == syscall.F_WRLCK {
+		l.Type = vfs.LockWrite
+	}
+	// FUSE lock ranges are inclusive
+	if lk.End < math.MaxInt64 {
+		l.End = int64(lk.End) + 1
+	}
+	return l
+}
+
+// unlockAll releases the locks of every owner which used this handle
+//
+// The kernel doesn't tell us the lock owner when a file descriptor
+// is closed so POSIX locks are held until the last close of the open
+// file.
+func (f *FileHandle) unlockAll() {
+	f.mu.Lock()
+	owners := f.owners
+	f.owners = nil
+	f.mu.Unlock()
+	node := f.h.Node()
+	for owner := range owners {
+		node.VFS().UnlockAll(node.Path(), lockOwner(owner))
+	}
+}
+
+// setlk takes or releases a lock, waiting if wait is set
+func (f *FileHandle) setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, wait bool) syscall.Errno {
+	node := f.h.Node()
+	l := vfsLock(owner, lk)
+	if lk.Typ == syscall.F_UNLCK {
+		node.VFS().Unlock(node.Path(), l)
+		return 0
+	}
+	f.mu.Lock()
+	if f.owners == nil {
+		f.owners = make(map[uint64]struct{})
+	}
+	f.owners[owner] = struct{}{}
+	f.mu.Unlock()
+	if !wait {
+		return translateError(node.VFS().Lock(node.Path(), l))
+	}
+	err := node.VFS().LockWait(ctx, node.Path(), l)
+	if ctx.Err() != nil {
+		return syscall.EINTR
+	}
+	return translateError(err)
+}
+
+// Getlk returns locks that would conflict with the given input
+// lock. If no locks conflict, the output has type L_UNLCK.
+func (f *FileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%x, lk=%+v", owner, lk)("out=%+v, errno=%v", out, &errno)
+	node := f.h.Node()
+	conflict, found := node.VFS().TestLock(node.Path(), vfsLock(owner, lk))
+	if !found {
+		*out = fuse.FileLock{Typ: syscall.F_UNLCK}
+		return 0
+	}
+	*out = fuse.FileLock{
+		Start: uint64(conflict.Start),
+		End:   math.MaxInt64,
+		Typ:   syscall.F_RDLCK,
+	}
+	if conflict.End != vfs.LockEOF {
+		out.End = uint64(conflict.End - 1)
+	}
+	if conflict.Type == vfs.LockWrite {
+		out.Typ = syscall.F_WRLCK
+	}
+	return 0
+}
+
+var _ fusefs.FileGetlker = (*FileHandle)(nil)
+
+// Setlk obtains a lock on a file, or fail if the lock could not
+// obtained.
+func (f *FileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%x, lk=%+v", owner, lk)("errno=%v", &errno)
+	return f.setlk(ctx, owner, lk, false)
+}
+
+var _ fusefs.FileSetlker = (*FileHandle)(nil)
+
+// Setlkw obtains a lock on a file, waiting if necessary.
+func (f *FileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%x, lk=%+v", owner, lk)("errno=%v", &errno)
+	return f.setlk(ctx, owner, lk, true)
+}
+
+var _ fusefs.FileSetlkwer = (*FileHandle)(nil)
@@ -33,6 +33,7 @@ func mountOptions(fsys *FS, f fs.Fs, opt *mountlib.Options) (mountOpts *fuse.Mou
 		MaxReadAhead:       int(fsys.opt.MaxReadAhead),
 		MaxWrite:           1024 * 1024, // Linux v4.20+ caps requests at 1 MiB
 		DisableReadDirPlus: true,
+		EnableLocks:        true,
 
 		// RememberInodes: true,
 		// SingleThreaded: true,
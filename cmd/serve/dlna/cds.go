@@ -17,6 +17,7 @@ import (
 	"github.com/anacrolix/dms/dlna"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/rclone/rclone/cmd/serve/dlna/upnpav"
+	"github.com/rclone/rclone/cmd/serve/preview"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/vfs"
 )
@@ -77,6 +78,13 @@ func (cds *contentDirectoryService) cdsObjectToUpnpavObject(cdsObject object, fi
 	obj.Class = "object.item." + mediaType[1] + "Item"
 	obj.Title = fileInfo.Name()
 	obj.Date = upnpav.Timestamp{Time: fileInfo.ModTime()}
+	if cds.previewer != nil && mediaType[1] == "image" && preview.CanThumbnail(fileInfo.Name()) {
+		obj.AlbumArtURI = (&url.URL{
+			Scheme: "http",
+			Host:   host,
+			Path:   path.Join(thumbnailPath, cdsObject.Path),
+		}).String()
+	}
 
 	item := upnpav.Item{
 		Object: obj,
@@ -22,6 +22,7 @@ import (
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/dlna/data"
+	"github.com/rclone/rclone/cmd/serve/preview"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/config/flags"
@@ -54,6 +55,10 @@ var OptionsInfo = fs.Options{{
 	Name:    "announce_interval",
 	Default: fs.Duration(12 * time.Minute),
 	Help:    "The interval between SSDP announcements",
+}, {
+	Name:    "thumbnails",
+	Default: false,
+	Help:    "Serve thumbnails of images as album art",
 }}
 
 // Options is the type for DLNA serving options.
@@ -63,6 +68,7 @@ type Options struct {
 	LogTrace         bool        `config:"log_trace"`
 	InterfaceNames   []string    `config:"interface"`
 	AnnounceInterval fs.Duration `config:"announce_interval"`
+	Thumbnails       bool        `config:"thumbnails"`
 }
 
 // Opt contains the options for DLNA serving.
@@ -123,6 +129,10 @@ default "rclone (hostname)".
 Use ` + "`--log-trace` in conjunction with `-vv`" + ` to enable additional debug
 logging of all UPNP traffic.
 
+Use ` + "`--thumbnails`" + ` to serve thumbnails of JPEG, PNG, GIF and WebP
+images as their album art so clients can show them while browsing.
+The thumbnails are kept in the cache directory (see ` + "`--cache-dir`" + `).
+
 ` + strings.TrimSpace(vfs.Help()),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.46",
@@ -147,7 +157,9 @@ const (
 	serverField       = "Linux/3.4 DLNADOC/1.50 UPnP/1.0 DMS/1.0"
 	rootDescPath      = "/rootDesc.xml"
 	resPath           = "/r/"
+	thumbnailPath     = "/t/"
 	serviceControlURL = "/ctl"
+	thumbnailSize     = 160 // the largest size in the DLNA JPEG_TN profile
 )
 
 type server struct {
@@ -172,6 +184,9 @@ type server struct {
 
 	f   fs.Fs
 	vfs *vfs.VFS
+
+	// Makes thumbnails of images, nil if not enabled
+	previewer *preview.Previewer
 }
 
 func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options) (*server, error) {
@@ -204,6 +219,10 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		httpListenAddr:   opt.ListenAddr,
 		f:                f,
 		vfs:              vfs.New(f, vfsOpt),
+		previewer: preview.New(&preview.Options{
+			Thumbnails:    opt.Thumbnails,
+			ThumbnailSize: thumbnailSize,
+		}),
 	}
 
 	s.services = map[string]UPnPService{
@@ -222,6 +241,10 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	r := http.NewServeMux()
 	r.Handle(resPath, http.StripPrefix(resPath,
 		http.HandlerFunc(s.resourceHandler)))
+	if s.previewer != nil {
+		r.Handle(thumbnailPath, http.StripPrefix(thumbnailPath,
+			http.HandlerFunc(s.thumbnailHandler)))
+	}
 	if opt.LogTrace {
 		r.Handle(rootDescPath, traceLogging(http.HandlerFunc(s.rootDescHandler)))
 		r.Handle(serviceControlURL, traceLogging(http.HandlerFunc(s.serviceControlHandler)))
@@ -367,6 +390,16 @@ func (s *server) resourceHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, remotePath, node.ModTime(), in)
 }
 
+// Serves thumbnails of images for use as album art.
+func (s *server) thumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	node, err := s.vfs.Stat(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.previewer.ServeThumbnail(w, r, node)
+}
+
 // Serve runs the server - returns the error only if the listener was
 // not started. Blocks until the server is closed.
 func (s *server) Serve() (err error) {
@@ -5,15 +5,20 @@ import (
 	"context"
 	"fmt"
 	"html"
+	"image"
+	"image/png"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/anacrolix/dms/soap"
 
+	"github.com/rclone/rclone/cmd/serve/dlna/upnpav"
 	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configfile"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/vfs"
@@ -282,3 +287,63 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+// Check images get thumbnails as album art when enabled
+func TestThumbnails(t *testing.T) {
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	}()
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 320, 200))))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), buf.Bytes(), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.jpg"), []byte{0xFF, 0xD8}, 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "video.mp4"), []byte("video"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	opt := Opt
+	opt.ListenAddr = testBindAddress
+	opt.Thumbnails = true
+	s, err := newServer(ctx, f, &opt, &vfscommon.Opt)
+	require.NoError(t, err)
+	go func() {
+		assert.NoError(t, s.Serve())
+	}()
+	defer func() {
+		assert.NoError(t, s.Shutdown())
+	}()
+	host := s.HTTPConn.Addr().String()
+	cds := s.services["ContentDirectory"].(*contentDirectoryService)
+
+	albumArt := func(name string) string {
+		node, err := s.vfs.Stat(name)
+		require.NoError(t, err)
+		ret, err := cds.cdsObjectToUpnpavObject(object{Path: "/" + name}, node, nil, host)
+		require.NoError(t, err)
+		item, ok := ret.(upnpav.Item)
+		require.True(t, ok)
+		return item.AlbumArtURI
+	}
+	get := func(URL string) *http.Response {
+		resp, err := http.Get(URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	artURI := albumArt("image.png")
+	assert.Equal(t, "http://"+host+thumbnailPath+"image.png", artURI)
+	resp := get(artURI)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+
+	// Images which can't be decoded
+	resp = get(albumArt("broken.jpg"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	// Videos don't get album art
+	assert.Equal(t, "", albumArt("video.mp4"))
+}
@@ -12,13 +12,16 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rclone/rclone/cmd"
 	cmdserve "github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/preview"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/cmd/serve/share"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/accounting"
 	"github.com/rclone/rclone/fs/config/configstruct"
@@ -26,6 +29,7 @@ import (
 	"github.com/rclone/rclone/fs/rc"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/lib/rest"
 	"github.com/rclone/rclone/lib/systemd"
 	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
@@ -37,14 +41,19 @@ import (
 var OptionsInfo = fs.Options{}.
 	Add(libhttp.ConfigInfo).
 	Add(libhttp.AuthConfigInfo).
-	Add(libhttp.TemplateConfigInfo)
+	Add(libhttp.TemplateConfigInfo).
+	Add(share.OptionsInfo).
+	Add(preview.OptionsInfo)
 
 // Options required for http server
 type Options struct {
-	Auth       libhttp.AuthConfig
-	HTTP       libhttp.Config
-	Template   libhttp.TemplateConfig
-	DisableZip bool
+	Auth        libhttp.AuthConfig
+	HTTP        libhttp.Config
+	Template    libhttp.TemplateConfig
+	Share       share.Options
+	Preview     preview.Options
+	DisableZip  bool
+	EnableWrite bool
 }
 
 // DefaultOpt is the default values used for Options
@@ -71,6 +80,7 @@ func init() {
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
 	flagSet.BoolVar(&Opt.DisableZip, "disable-zip", false, "Disable zip download of directories")
+	flagSet.BoolVar(&Opt.EnableWrite, "enable-write", false, "Enable uploading, renaming and deleting files from the browser")
 	cmdserve.Command.AddCommand(Command)
 	cmdserve.AddRc("http", func(ctx context.Context, f fs.Fs, in rc.Params) (cmdserve.Handle, error) {
 		// Read VFS Opts
@@ -112,7 +122,33 @@ The server will log errors.  Use ` + "`-v`" + ` to see access logs.
 ` + "`--bwlimit`" + ` will be respected for file transfers.  Use ` + "`--stats`" + ` to
 control the stats printing.
 
-` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+vfs.Help()+proxy.Help),
+### Writing
+
+By default the server is read only. Use ` + "`--enable-write`" + ` to let
+users upload files by dragging and dropping them onto the directory
+listing, make directories, and rename and delete files and
+directories. Use this with the authentication flags below as anyone
+who can reach the server can change the remote otherwise.
+
+Files can also be uploaded with a PUT to their URL, for example
+
+    curl -T file.txt http://localhost:8080/dir/file.txt
+
+Large files can be sent in chunks with a ` + "`Content-Range: bytes start-end/size`" + `
+header on each PUT. The chunks are stored in a temporary file until
+the last one arrives then the file is written to the remote. Each
+chunk must start where the last one finished. If an upload is
+interrupted, send a PUT with ` + "`Content-Range: bytes */size`" + ` and no
+body and the server replies with the number of bytes received in the
+` + "`Upload-Offset`" + ` header so the upload can carry on from there. Uploads
+which aren't added to for 24 hours are thrown away.
+
+Directories can be made with a POST to the directory with
+` + "`?action=mkdir&name=leaf`" + `, files and directories renamed with a POST
+with ` + "`?action=rename&to=name`" + ` and removed with DELETE. Directories
+which aren't empty are only removed if ` + "`?recursive=true`" + ` is given.
+
+` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+share.Help+preview.Help+vfs.Help()+proxy.Help),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
 		"groups":            "Filter",
@@ -145,6 +181,11 @@ type HTTP struct {
 	opt    Options
 	proxy  *proxy.Proxy
 	ctx    context.Context // for global config
+
+	previewer *preview.Previewer // nil if no thumbnails or previews
+
+	uploadsMu sync.Mutex
+	uploads   map[string]*partialUpload // chunked uploads in progress
 }
 
 // Gets the VFS in use for this request
@@ -178,6 +219,7 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		ctx: ctx,
 		opt: *opt,
 	}
+	s.previewer = preview.New(&s.opt.Preview)
 
 	if proxyOpt.AuthProxy != "" {
 		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
@@ -187,11 +229,15 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		s._vfs = vfs.New(f, vfsOpt)
 	}
 
-	s.server, err = libhttp.NewServer(ctx,
+	serverOpts := []libhttp.Option{
 		libhttp.WithConfig(s.opt.HTTP),
 		libhttp.WithAuth(s.opt.Auth),
 		libhttp.WithTemplate(s.opt.Template),
-	)
+	}
+	if s.opt.Share.Enabled {
+		serverOpts = append(serverOpts, libhttp.WithPublicPrefix(share.Prefix))
+	}
+	s.server, err = libhttp.NewServer(ctx, serverOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
@@ -203,6 +249,16 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	)
 	router.Get("/*", s.handler)
 	router.Head("/*", s.handler)
+	if s.opt.EnableWrite {
+		s.addWriteRoutes(router)
+	}
+	if s.opt.Share.Enabled {
+		shareHandler, err := share.NewHandler(&s.opt.Share, s._vfs, s.server.HTMLTemplate())
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(share.Prefix+"*", shareHandler)
+	}
 
 	return s, nil
 }
@@ -222,6 +278,7 @@ func (s *HTTP) Addr() net.Addr {
 
 // Shutdown the server
 func (s *HTTP) Shutdown() error {
+	defer s.removeUploads()
 	return s.server.Shutdown()
 }
 
@@ -291,6 +348,10 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 		} else {
 			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), node.ModTime().UTC())
 		}
+		if !node.IsDir() {
+			entry := &directory.Entries[len(directory.Entries)-1]
+			entry.ThumbnailURL, entry.PreviewURL = s.previewer.Links(node.Name(), rest.URLPathEscape(node.Name()))
+		}
 	}
 
 	sortParm := r.URL.Query().Get("sort")
@@ -301,6 +362,7 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
 
 	directory.DisableZip = s.opt.DisableZip
+	directory.EnableWrite = s.opt.EnableWrite
 
 	directory.Serve(w, r)
 }
@@ -328,6 +390,9 @@ func (s *HTTP) serveFile(w http.ResponseWriter, r *http.Request, remote string)
 		http.Error(w, "Not a file", http.StatusNotFound)
 		return
 	}
+	if view := r.URL.Query().Get("view"); view != "" && s.previewer.Serve(w, r, view, node) {
+		return
+	}
 	entry := node.DirEntry()
 	if entry == nil {
 		http.Error(w, "Can't open file being written", http.StatusNotFound)
@@ -1,21 +1,30 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"flag"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
 	stdfs "io/fs"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/preview"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/cmd/serve/share"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/rc"
 	libhttp "github.com/rclone/rclone/lib/http"
@@ -35,7 +44,8 @@ const (
 	testTemplate    = "testdata/golden/testindex.html"
 )
 
-func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string) {
+// start the server, calling setOpts if set to adjust the options
+func start(ctx context.Context, t *testing.T, f fs.Fs, setOpts func(opts *Options)) (s *HTTP, testURL string) {
 	opts := Options{
 		HTTP: libhttp.DefaultCfg(),
 		Template: libhttp.TemplateConfig{
@@ -47,6 +57,9 @@ func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string)
 		opts.Auth.BasicUser = testUser
 		opts.Auth.BasicPass = testPass
 	}
+	if setOpts != nil {
+		setOpts(&opts)
+	}
 
 	s, err := newServer(ctx, f, &opts, &vfscommon.Opt, &proxy.Opt)
 	require.NoError(t, err, "failed to start server")
@@ -147,7 +160,7 @@ func testGET(t *testing.T, useProxy bool) {
 		require.NoError(t, obj.SetModTime(context.Background(), expectedTime))
 	}
 
-	s, testURL := start(ctx, t, f)
+	s, testURL := start(ctx, t, f, nil)
 	defer func() {
 		assert.NoError(t, s.server.Shutdown())
 	}()
@@ -303,3 +316,225 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	s, testURL := start(ctx, t, f, func(opts *Options) {
+		opts.EnableWrite = true
+	})
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	do := func(method, URL, body string, headers ...string) *http.Response {
+		req, err := http.NewRequest(method, testURL+URL, strings.NewReader(body))
+		require.NoError(t, err)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, err = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+	checkFile := func(name, want string) {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, want, string(got), name)
+	}
+
+	// Simple upload with a modtime
+	resp := do("PUT", "one.txt", "hello", "X-OC-Mtime", strconv.FormatInt(expectedTime.Unix(), 10))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile("one.txt", "hello")
+	fi, err := os.Stat(filepath.Join(dir, "one.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, expectedTime, fi.ModTime().UTC())
+
+	// Authentication is needed
+	req, err := http.NewRequest("PUT", testURL+"noauth.txt", strings.NewReader("x"))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Cross origin requests are refused
+	resp = do("PUT", "evil.txt", "x", "Origin", "http://evil.example.com")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.NoFileExists(t, filepath.Join(dir, "evil.txt"))
+
+	// Make a directory
+	resp = do("POST", "?action=mkdir&name=dir", "")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.DirExists(t, filepath.Join(dir, "dir"))
+	resp = do("POST", "?action=mkdir&name=a%2Fb", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Chunked upload with a resume
+	const chunked = "dir/chunked.txt"
+	resp = do("PUT", chunked, "01234", "Content-Range", "bytes 0-4/10")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+	resp = do("PUT", chunked, "789", "Content-Range", "bytes 7-9/10")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+	resp = do("PUT", chunked, "", "Content-Range", "bytes */10")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+	assert.NoFileExists(t, filepath.Join(dir, chunked))
+	resp = do("PUT", chunked, "56789", "Content-Range", "bytes 5-9/10")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile(chunked, "0123456789")
+	resp = do("PUT", chunked, "", "Content-Range", "bytes */10")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("Upload-Offset"))
+	resp = do("PUT", chunked, "x", "Content-Range", "bytes 5-4/10")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Upload with a form
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("file", "form.txt")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("from a form"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	resp = do("POST", "dir/", form.String(), "Content-Type", mw.FormDataContentType())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile("dir/form.txt", "from a form")
+
+	// Rename
+	resp = do("POST", "one.txt?action=rename&to=two.txt", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	checkFile("two.txt", "hello")
+	assert.NoFileExists(t, filepath.Join(dir, "one.txt"))
+	resp = do("POST", "two.txt?action=rename&to=%2Fdir%2Fform.txt", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = do("POST", "two.txt?action=rename&to=%2Fdir%2Fthree.txt", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	checkFile("dir/three.txt", "hello")
+	resp = do("POST", "dir/three.txt?action=rename&to=..", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Delete
+	resp = do("DELETE", "dir/", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = do("DELETE", "dir/three.txt", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoFileExists(t, filepath.Join(dir, "dir/three.txt"))
+	resp = do("DELETE", "dir/three.txt", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do("DELETE", "dir/?recursive=true", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.NoDirExists(t, filepath.Join(dir, "dir"))
+}
+
+func TestShares(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, "testdata/files")
+	require.NoError(t, err)
+	storePath := filepath.Join(t.TempDir(), "shares.json")
+
+	s, testURL := start(ctx, t, f, func(opts *Options) {
+		opts.Share = share.Options{Enabled: true, Store: storePath}
+	})
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	remote, isDir, err := share.Resolve(ctx, f, "three")
+	require.NoError(t, err)
+	sh, tok, err := share.NewStore(storePath).Create(remote, isDir, share.CreateOptions{})
+	require.NoError(t, err)
+	link := testURL + strings.TrimPrefix(share.LinkPath(sh, tok), "/")
+
+	get := func(URL string) (int, string) {
+		resp, err := http.Get(URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(body)
+	}
+
+	// Share links don't need the server's auth
+	code, body := get(link + "a.txt")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "three\n", body)
+
+	// But everything else does
+	code, _ = get(testURL + "three/a.txt")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get(testURL + "%2Eshare/" + tok + "/a.txt")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get(testURL + ".share/bad/a.txt")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestPreviews(t *testing.T) {
+	ctx := context.Background()
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	}()
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 600, 300))))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image.png"), buf.Bytes(), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.md"), []byte("# Notes\n"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	s, testURL := start(ctx, t, f, func(opts *Options) {
+		opts.Template.Path = ""
+		opts.Preview = preview.Options{Thumbnails: true, ThumbnailSize: 100, Previews: true}
+	})
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	get := func(URL string) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", testURL+URL, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp, body
+	}
+
+	// The listing links to the thumbnail and preview
+	resp, body := get("")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `src="image.png?view=thumbnail"`)
+	assert.Contains(t, string(body), `src="notes.md?view=preview"`)
+	assert.NotContains(t, string(body), `image.png?view=preview`)
+
+	resp, body = get("image.png?view=thumbnail")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(body))
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Width)
+	assert.Equal(t, 50, cfg.Height)
+
+	resp, body = get("notes.md?view=preview")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "sandbox")
+	assert.Contains(t, string(body), "<h1>Notes</h1>")
+
+	// Unknown views serve the file
+	_, body = get("notes.md?view=other")
+	assert.Equal(t, "# Notes\n", string(body))
+}
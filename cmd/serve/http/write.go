@@ -0,0 +1,474 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Partial uploads which haven't been added to for this long are
+// thrown away
+const uploadExpiry = 24 * time.Hour
+
+// partialUpload is an upload being sent in chunks with Content-Range
+//
+// The chunks are stored in a local temporary file until the last one
+// arrives, then the whole file is written to the VFS.
+type partialUpload struct {
+	mu      sync.Mutex
+	key     string
+	file    *os.File
+	size    int64     // total size of the upload
+	offset  int64     // number of bytes received so far
+	updated time.Time // when the last bytes were received
+}
+
+// remove the temporary file - call with mu held
+func (u *partialUpload) remove() {
+	if u.file == nil {
+		return
+	}
+	_ = u.file.Close()
+	err := os.Remove(u.file.Name())
+	if err != nil {
+		fs.Errorf(nil, "Failed to remove partial upload: %v", err)
+	}
+	u.file = nil
+}
+
+// addWriteRoutes adds the routes used for writing with --enable-write
+func (s *HTTP) addWriteRoutes(router chi.Router) {
+	s.uploads = make(map[string]*partialUpload)
+	router.Group(func(r chi.Router) {
+		r.Use(checkOrigin)
+		r.Put("/*", s.handlePut)
+		r.Post("/*", s.handlePost)
+		r.Delete("/*", s.handleDelete)
+	})
+}
+
+// checkOrigin refuses write requests made from pages served by
+// other sites. Browsers send credentials with cross site form posts
+// so without this another site could write to the remote.
+func checkOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "Cross origin request refused", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError reports err from a write operation on remote
+func writeError(ctx context.Context, remote string, w http.ResponseWriter, text string, err error) {
+	switch {
+	case errors.Is(err, vfs.ENOENT):
+		http.Error(w, text+": not found", http.StatusNotFound)
+	case errors.Is(err, vfs.EEXIST), errors.Is(err, vfs.ENOTEMPTY):
+		http.Error(w, text+": "+err.Error(), http.StatusConflict)
+	case errors.Is(err, vfs.EPERM), errors.Is(err, vfs.EROFS):
+		http.Error(w, text+": "+err.Error(), http.StatusForbidden)
+	default:
+		serve.Error(ctx, remote, w, text, err)
+	}
+}
+
+// checkLeaf checks name is usable as a single path element
+func checkLeaf(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// parseModTime reads the modification time sent by the client in
+// X-OC-Mtime as the webdav server does, returning a zero time if it
+// isn't set
+func parseModTime(r *http.Request) time.Time {
+	mh := r.Header.Get("X-OC-Mtime")
+	if mh == "" {
+		return time.Time{}
+	}
+	modtimeUnix, err := strconv.ParseInt(mh, 10, 64)
+	if err != nil {
+		fs.Errorf(nil, "Failed to parse modtime: %v", err)
+		return time.Time{}
+	}
+	return time.Unix(modtimeUnix, 0)
+}
+
+// upload writes in to remote on the VFS
+func upload(VFS *vfs.VFS, remote string, in io.Reader, modTime time.Time) (err error) {
+	fh, err := VFS.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fh, in)
+	closeErr := fh.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if !modTime.IsZero() {
+		node, err := VFS.Stat(remote)
+		if err == nil {
+			err = node.SetModTime(modTime)
+		}
+		if err != nil {
+			fs.Errorf(remote, "Failed to set modtime: %v", err)
+		}
+	}
+	return nil
+}
+
+// handlePut uploads the request body to the file at the URL
+//
+// Large files can be sent in chunks with a Content-Range header of
+// "bytes start-end/size". Each chunk must start where the previous
+// one finished and the file is written to the remote when the last
+// one arrives. A Content-Range of "bytes */size" asks how much has
+// been received so far so that an interrupted upload can be resumed.
+// While the upload is incomplete the number of bytes received is
+// returned in the Upload-Offset header.
+func (s *HTTP) handlePut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Can't upload to a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	remote := strings.Trim(r.URL.Path, "/")
+	VFS, err := s.getVFS(ctx)
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to upload file: %v", err)
+		return
+	}
+	modTime := parseModTime(r)
+	contentRange := r.Header.Get("Content-Range")
+	if contentRange == "" {
+		err = upload(VFS, remote, r.Body, modTime)
+		if err != nil {
+			writeError(ctx, remote, w, "Failed to upload file", err)
+			return
+		}
+		fs.Infof(remote, "%s: Uploaded file", r.RemoteAddr)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	s.putChunk(w, r, VFS, remote, contentRange, modTime)
+}
+
+// putChunk receives one chunk of a chunked upload
+func (s *HTTP) putChunk(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, remote, contentRange string, modTime time.Time) {
+	ctx := r.Context()
+	var start, end, size int64
+	query := false
+	if _, err := fmt.Sscanf(contentRange, "bytes */%d", &size); err == nil {
+		query = true
+	} else if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err != nil || start < 0 || end < start || end >= size {
+		http.Error(w, "Bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	key := fmt.Sprintf("%p:%s", VFS, remote)
+	u, err := s.getUpload(key, size, start == 0 && !query)
+	if err != nil {
+		serve.Error(ctx, remote, w, "Failed to start upload", err)
+		return
+	}
+	if u == nil {
+		w.Header().Set("Upload-Offset", "0")
+		if query {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			http.Error(w, "No upload in progress", http.StatusConflict)
+		}
+		return
+	}
+	defer u.mu.Unlock()
+	if query || start != u.offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		if query {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			http.Error(w, "Chunk doesn't start at the end of the upload", http.StatusConflict)
+		}
+		return
+	}
+	n, err := io.Copy(io.NewOffsetWriter(u.file, start), io.LimitReader(r.Body, end-start+1))
+	u.offset += n
+	u.updated = time.Now()
+	if err == nil && n != end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		fs.Errorf(remote, "%s: Failed to receive chunk: %v", r.RemoteAddr, err)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		http.Error(w, "Failed to receive chunk", http.StatusBadRequest)
+		return
+	}
+	if u.offset < u.size {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Upload complete so write it to the VFS
+	s.finishUpload(u)
+	defer u.remove()
+	_, err = u.file.Seek(0, io.SeekStart)
+	if err == nil {
+		err = upload(VFS, remote, u.file, modTime)
+	}
+	if err != nil {
+		writeError(ctx, remote, w, "Failed to upload file", err)
+		return
+	}
+	fs.Infof(remote, "%s: Uploaded file in chunks", r.RemoteAddr)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// getUpload finds the partial upload for key and returns it locked
+//
+// If create is set a new upload is started, replacing any existing
+// one. Otherwise it returns nil if there is no upload of size in
+// progress.
+func (s *HTTP) getUpload(key string, size int64, create bool) (*partialUpload, error) {
+	s.uploadsMu.Lock()
+
+	// Throw away any abandoned uploads
+	now := time.Now()
+	for k, u := range s.uploads {
+		if now.Sub(u.updated) > uploadExpiry && u.mu.TryLock() {
+			delete(s.uploads, k)
+			u.remove()
+			u.mu.Unlock()
+		}
+	}
+
+	u := s.uploads[key]
+	if !create {
+		s.uploadsMu.Unlock()
+		if u == nil {
+			return nil, nil
+		}
+		u.mu.Lock()
+		if u.file == nil || u.size != size {
+			u.mu.Unlock()
+			return nil, nil
+		}
+		return u, nil
+	}
+
+	file, err := os.CreateTemp("", "rclone-serve-http-upload-")
+	if err != nil {
+		s.uploadsMu.Unlock()
+		return nil, err
+	}
+	newU := &partialUpload{
+		key:     key,
+		file:    file,
+		size:    size,
+		updated: now,
+	}
+	newU.mu.Lock()
+	s.uploads[key] = newU
+	s.uploadsMu.Unlock()
+
+	// Throw away the upload this replaces
+	if u != nil {
+		u.mu.Lock()
+		u.remove()
+		u.mu.Unlock()
+	}
+	return newU, nil
+}
+
+// finishUpload removes u from the uploads in progress
+func (s *HTTP) finishUpload(u *partialUpload) {
+	s.uploadsMu.Lock()
+	if s.uploads[u.key] == u {
+		delete(s.uploads, u.key)
+	}
+	s.uploadsMu.Unlock()
+}
+
+// removeUploads throws away all the partial uploads
+func (s *HTTP) removeUploads() {
+	s.uploadsMu.Lock()
+	uploads := s.uploads
+	s.uploads = make(map[string]*partialUpload)
+	s.uploadsMu.Unlock()
+	for _, u := range uploads {
+		u.mu.Lock()
+		u.remove()
+		u.mu.Unlock()
+	}
+}
+
+// handlePost does the actions for directory listings
+//
+//   - a multipart/form-data POST to a directory uploads the files in it
+//   - ?action=mkdir&name=leaf makes a directory in the directory
+//   - ?action=rename&to=name renames the file or directory to name in
+//     the same directory, or to the path name if it starts with /
+func (s *HTTP) handlePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+	remote := strings.Trim(r.URL.Path, "/")
+	VFS, err := s.getVFS(ctx)
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to write: %v", err)
+		return
+	}
+	query := r.URL.Query()
+	switch action := query.Get("action"); action {
+	case "":
+		if !isDir {
+			http.Error(w, "Can only upload files to a directory", http.StatusMethodNotAllowed)
+			return
+		}
+		s.postFiles(w, r, VFS, remote)
+	case "mkdir":
+		if !isDir {
+			http.Error(w, "Can only make directories in a directory", http.StatusBadRequest)
+			return
+		}
+		name := query.Get("name")
+		if err := checkLeaf(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		newRemote := path.Join(remote, name)
+		err = VFS.Mkdir(newRemote, 0777)
+		if err != nil {
+			writeError(ctx, newRemote, w, "Failed to make directory", err)
+			return
+		}
+		fs.Infof(newRemote, "%s: Made directory", r.RemoteAddr)
+		w.WriteHeader(http.StatusCreated)
+	case "rename":
+		to := query.Get("to")
+		var newRemote string
+		if strings.HasPrefix(to, "/") {
+			newRemote = strings.Trim(path.Clean(to), "/")
+		} else if err := checkLeaf(to); err == nil {
+			newRemote = path.Join(path.Dir(remote), to)
+		}
+		if remote == "" || newRemote == "" {
+			http.Error(w, fmt.Sprintf("Can't rename %q to %q", "/"+remote, to), http.StatusBadRequest)
+			return
+		}
+		if _, err := VFS.Stat(newRemote); err == nil {
+			http.Error(w, "Failed to rename: destination exists", http.StatusConflict)
+			return
+		}
+		err = VFS.Rename(remote, newRemote)
+		if err != nil {
+			writeError(ctx, remote, w, "Failed to rename", err)
+			return
+		}
+		fs.Infof(remote, "%s: Renamed to %q", r.RemoteAddr, newRemote)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown action %q", action), http.StatusBadRequest)
+	}
+}
+
+// postFiles uploads the files in a multipart/form-data POST to the
+// directory at dirRemote
+func (s *HTTP) postFiles(w http.ResponseWriter, r *http.Request, VFS *vfs.VFS, dirRemote string) {
+	ctx := r.Context()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expecting multipart/form-data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, "Failed to read form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		leaf := part.FileName()
+		if leaf == "" {
+			continue
+		}
+		leaf = path.Base(strings.ReplaceAll(leaf, "\\", "/"))
+		if err := checkLeaf(leaf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remote := path.Join(dirRemote, leaf)
+		err = upload(VFS, remote, part, time.Time{})
+		if err != nil {
+			writeError(ctx, remote, w, "Failed to upload file", err)
+			return
+		}
+		fs.Infof(remote, "%s: Uploaded file", r.RemoteAddr)
+	}
+	// Send browsers posting the upload form back to the listing
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		// relative so it works with --baseurl
+		w.Header().Set("Location", "./")
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleDelete removes the file or empty directory at the URL
+//
+// Directories which aren't empty are only removed with ?recursive=true
+func (s *HTTP) handleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	remote := strings.Trim(r.URL.Path, "/")
+	if remote == "" {
+		http.Error(w, "Can't delete the root", http.StatusForbidden)
+		return
+	}
+	VFS, err := s.getVFS(ctx)
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to delete: %v", err)
+		return
+	}
+	node, err := VFS.Stat(remote)
+	if err != nil {
+		writeError(ctx, remote, w, "Failed to delete", err)
+		return
+	}
+	if node.IsDir() && r.URL.Query().Get("recursive") == "true" {
+		err = node.RemoveAll()
+	} else {
+		err = node.Remove()
+	}
+	if err != nil {
+		writeError(ctx, remote, w, "Failed to delete", err)
+		return
+	}
+	fs.Infof(remote, "%s: Deleted", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -64,9 +64,9 @@ func (h *Handler) getCache() (c Cache, err error) {
 	case cacheMemory:
 		return nfshelper.NewCachingHandler(h, h.opt.HandleLimit), nil
 	case cacheDisk:
-		return newDiskHandler(h)
+		return newDiskHandler(h, h.opt.HandleCache.String())
 	case cacheSymlink:
-		dh, err := newDiskHandler(h)
+		dh, err := newDiskHandler(h, h.opt.HandleCache.String())
 		if err != nil {
 			return nil, err
 		}
@@ -93,7 +93,9 @@ type diskHandler struct {
 }
 
 // Create a new disk handler
-func newDiskHandler(h *Handler) (dh *diskHandler, err error) {
+//
+// kind is used to name the cache directory if --nfs-cache-dir isn't set
+func newDiskHandler(h *Handler, kind string) (dh *diskHandler, err error) {
 	cacheDir := h.opt.HandleCacheDir
 	// If cacheDir isn't set then make one from the config
 	if cacheDir == "" {
@@ -101,7 +103,7 @@ func newDiskHandler(h *Handler) (dh *diskHandler, err error) {
 		configString := fs.ConfigString(h.vfs.Fs())
 		// Turn it into a valid OS directory name
 		dirName := encoder.OS.ToStandardName(configString)
-		cacheDir = filepath.Join(config.GetCacheDir(), "serve-nfs-handle-cache-"+h.opt.HandleCache.String(), dirName)
+		cacheDir = filepath.Join(config.GetCacheDir(), "serve-nfs-handle-cache-"+kind, dirName)
 	}
 	// Create the cache dir
 	err = file.MkdirAll(cacheDir, 0700)
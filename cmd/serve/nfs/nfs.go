@@ -1,6 +1,7 @@
 //go:build unix
 
-// Package nfs implements a server to serve a VFS remote over the NFSv3 protocol
+// Package nfs implements a server to serve a VFS remote over the NFSv3
+// and NFSv4.1 protocols
 //
 // There is no authentication available on this server and it is
 // served on the loopback interface by default.
@@ -126,7 +127,9 @@ var Command = &cobra.Command{
 	Short: `Serve the remote as an NFS mount`,
 	Long: strings.ReplaceAll(`Create an NFS server that serves the given remote over the network.
 
-This implements an NFSv3 server to serve any rclone remote via NFS.
+This implements an NFSv3 and NFSv4.1 server to serve any rclone remote
+via NFS. Both versions are served on the same TCP port, along with the
+MOUNT protocol, so no portmapper is needed.
 
 The primary purpose for this command is to enable the [mount
 command](/commands/rclone_mount/) on recent macOS versions where
@@ -194,6 +197,24 @@ Where |$PORT| is the same port number used in the |serve nfs| command
 and |$HOSTNAME| is the network address of the machine that |serve nfs|
 was run on.
 
+To mount using NFSv4.1 instead, use the following command under Linux:
+
+|||sh
+mount -t nfs -o vers=4.1,port=$PORT $HOSTNAME:/ path/to/mountpoint
+|||
+
+NFSv4.1 brings sessions, open and byte-range lock state and
+exactly-once semantics for retried requests. Locks taken by NFSv4.1
+clients are held by the server and are not visible to other users of
+the remote. Delegations and the back channel are not supported.
+
+NFSv4.1 file handles are always persistent. Short paths are encoded
+directly into the handle. Longer paths are hashed and the hash is
+stored in an on disk handle cache in the directory controlled by
+|--nfs-cache-dir| or |--cache-dir|, whatever |--nfs-cache-type| is
+set to. This means NFSv4.1 clients don't see stale file handles when
+the server is restarted.
+
 If |--vfs-metadata-extension| is in use then for the |--nfs-cache-type disk|
 and |--nfs-cache-type cache| the metadata files will have the file
 handle of their parent file suffixed with |0x00, 0x00, 0x00, 0x01|.
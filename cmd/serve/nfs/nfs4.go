@@ -0,0 +1,745 @@
+//go:build unix
+
+package nfs
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	billy "github.com/go-git/go-billy/v5"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// This implements an NFSv4.1 server (RFC 5661) alongside the NFSv3
+// server from go-nfs.
+//
+// NFSv4 needs no portmapper or mount protocol so it is served on the
+// same port as NFSv3 - connections are passed to the right server by
+// looking at the version of the first RPC call.
+
+const (
+	nfs4MaxIO         = 1024 * 1024            // largest READ or WRITE
+	nfs4MaxReqSize    = nfs4MaxIO + 64*1024    // largest request
+	nfs4MaxRespSize   = nfs4MaxIO + 64*1024    // largest response
+	nfs4MaxOps        = 64                     // most ops in a compound
+	nfs4MaxSlots      = 64                     // most slots in a session
+	nfs4ReaperPeriod  = leaseTime / 2          // how often to look for expired clients
+	nfs4MaxOwnerIDLen = 1024                   // longest client owner
+	nfs4MaxNameLen    = 255                    // longest file name
+	nfs4MaxTagLen     = 1024                   // longest compound tag
+	nfs4MaxOpaque     = nfs4MaxReqSize         // longest opaque
+	nfs4ServerDomain  = "rclone.org"           // for server_impl_id
+	nfs4Scope         = "rclone"               // prefix for server_owner and server_scope
+	nfs4FSIDMajor     = uint64(0x72636c6f6e65) // "rclone"
+)
+
+// server4 is the NFSv4.1 server
+type server4 struct {
+	h        *Handler
+	fs       *FS
+	vfs      *vfs.VFS
+	handles  *handles4
+	bootID   uint32  // differs each time the server starts
+	verifier [8]byte // write verifier - changes on restart
+	owner    []byte  // server owner and scope
+	quit     chan struct{}
+	wg       sync.WaitGroup
+
+	mu        sync.Mutex
+	nextID    uint64                   // for making client, session and state IDs
+	clients   map[uint64]*client4      // clients by ID
+	owners    map[string]*client4      // clients by owner
+	sessions  map[sessionID4]*session4 // all sessions
+	states    map[[12]byte]*state4     // open and lock states by stateid
+	exclusive map[string][8]byte       // verifiers of exclusively created files
+	conns     map[net.Conn]struct{}    // open connections
+	closed    bool
+}
+
+// newServer4 makes a new NFSv4.1 server for the handler
+//
+// addr is used to make the server owner unique
+func newServer4(h *Handler, addr string) *server4 {
+	s := &server4{
+		h:         h,
+		fs:        h.billyFS,
+		vfs:       h.vfs,
+		handles:   newHandles4(h),
+		bootID:    uint32(time.Now().Unix()),
+		owner:     []byte(nfs4Scope + " " + addr),
+		quit:      make(chan struct{}),
+		clients:   make(map[uint64]*client4),
+		owners:    make(map[string]*client4),
+		sessions:  make(map[sessionID4]*session4),
+		states:    make(map[[12]byte]*state4),
+		exclusive: make(map[string][8]byte),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	_, _ = rand.Read(s.verifier[:])
+	s.wg.Add(1)
+	go s.reaper()
+	return s
+}
+
+// reaper expires clients which haven't renewed their leases
+func (s *server4) reaper() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(nfs4ReaperPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.expireClients(now)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// close shuts down the server, closing all the connections and files
+func (s *server4) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.quit)
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	for _, client := range s.clients {
+		s.destroyClient(client)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// writable returns true if the VFS can be written to
+func (s *server4) writable() bool {
+	return s.fs.Capabilities()&billy.WriteCapability != 0
+}
+
+// serveConn serves RPC calls on the connection until it is closed
+func (s *server4) serveConn(c net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = c.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.Close()
+		s.wg.Done()
+	}()
+	fs.Debugf(nil, "nfs4: connection from %v", c.RemoteAddr())
+	var (
+		writeMu sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for {
+		record, err := readRecord(c)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(nil, "nfs4: read from %v failed: %v", c.RemoteAddr(), err)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := s.handleRPC(record)
+			if reply == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			err := writeRecord(c, reply)
+			if err != nil {
+				fs.Debugf(nil, "nfs4: write to %v failed: %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// handleRPC decodes an RPC call and returns the reply to send or nil
+// if there shouldn't be a reply
+func (s *server4) handleRPC(record []byte) []byte {
+	r := &xdrReader{b: record}
+	xid := r.uint32()
+	msgType := r.uint32()
+	if r.err != nil || msgType != rpcCall {
+		return nil
+	}
+	rpcvers := r.uint32()
+	prog := r.uint32()
+	vers := r.uint32()
+	proc := r.uint32()
+	credFlavor := r.uint32()
+	_ = r.opaque(400) // credentials
+	_ = r.uint32()    // verifier flavor
+	_ = r.opaque(400) // verifier
+	if r.err != nil {
+		return nil
+	}
+	w := &xdrWriter{}
+	w.uint32(xid)
+	w.uint32(rpcReply)
+	if rpcvers != rpcVersion {
+		w.uint32(msgDenied)
+		w.uint32(0) // RPC_MISMATCH
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return w.b
+	}
+	if credFlavor != authNone && credFlavor != authSys {
+		w.uint32(msgDenied)
+		w.uint32(rejectAuth)
+		w.uint32(authBadCred)
+		return w.b
+	}
+	w.uint32(msgAccepted)
+	w.uint32(authNone) // verifier
+	w.opaque(nil)
+	switch {
+	case prog != nfsProgram:
+		w.uint32(progUnavail)
+	case vers != nfsVersion4:
+		w.uint32(progMismatch)
+		w.uint32(nfsVersion4)
+		w.uint32(nfsVersion4)
+	case proc == procNull:
+		w.uint32(acceptSuccess)
+	case proc == procCompound:
+		res := s.compound(r)
+		if res == nil {
+			w.uint32(garbageArgs)
+		} else {
+			w.uint32(acceptSuccess)
+			w.b = append(w.b, res...)
+		}
+	default:
+		w.uint32(procUnavail)
+	}
+	return w.b
+}
+
+// compound4 is the state of a COMPOUND being executed
+type compound4 struct {
+	s         *server4
+	sess      *session4 // session from SEQUENCE
+	slot      *slot4    // slot from SEQUENCE
+	cachethis bool      // set if the reply should be cached
+	replay    []byte    // set if the reply is a replay from the cache
+	fh        string    // current file handle as a path
+	hasFH     bool      // set if fh is valid
+	savedFH   string    // saved file handle
+	hasSaved  bool      // set if savedFH is valid
+	cur       stateid4  // current stateid
+	savedSid  stateid4  // saved stateid
+}
+
+// opFunc executes an operation reading the arguments from r and
+// writing the result after the status to w
+//
+// It should only write to w if it has something to return.
+type opFunc func(c *compound4, r *xdrReader, w *xdrWriter) (status uint32)
+
+// ops are the operations which are implemented
+var ops map[uint32]opFunc
+
+func init() {
+	ops = map[uint32]opFunc{
+		opAccess:            (*compound4).access,
+		opClose:             (*compound4).close,
+		opCommit:            (*compound4).commit,
+		opCreate:            (*compound4).create,
+		opDelegPurge:        notSupported,
+		opDelegReturn:       (*compound4).delegReturn,
+		opGetattr:           (*compound4).getattr,
+		opGetFH:             (*compound4).getFH,
+		opLink:              notSupported,
+		opLock:              (*compound4).lock,
+		opLockT:             (*compound4).lockT,
+		opLockU:             (*compound4).lockU,
+		opLookup:            (*compound4).lookup,
+		opLookupP:           (*compound4).lookupP,
+		opNVerify:           (*compound4).nverify,
+		opOpen:              (*compound4).open,
+		opOpenAttr:          notSupported,
+		opOpenDowngrade:     (*compound4).openDowngrade,
+		opPutFH:             (*compound4).putFH,
+		opPutPubFH:          (*compound4).putRootFH,
+		opPutRootFH:         (*compound4).putRootFH,
+		opRead:              (*compound4).read,
+		opReadDir:           (*compound4).readDir,
+		opReadLink:          (*compound4).readLink,
+		opRemove:            (*compound4).remove,
+		opRename:            (*compound4).rename,
+		opRestoreFH:         (*compound4).restoreFH,
+		opSaveFH:            (*compound4).saveFH,
+		opSecInfo:           (*compound4).secInfo,
+		opSetattr:           (*compound4).setattr,
+		opVerify:            (*compound4).verify,
+		opWrite:             (*compound4).write,
+		opBackchannelCtl:    notSupported,
+		opBindConnToSession: (*compound4).bindConnToSession,
+		opExchangeID:        (*compound4).exchangeID,
+		opCreateSession:     (*compound4).createSession,
+		opDestroySession:    (*compound4).destroySession,
+		opFreeStateID:       (*compound4).freeStateID,
+		opSecInfoNoName:     (*compound4).secInfoNoName,
+		opSequence:          (*compound4).sequence,
+		opTestStateID:       (*compound4).testStateID,
+		opDestroyClientID:   (*compound4).destroyClientID,
+		opReclaimComplete:   (*compound4).reclaimComplete,
+		// NFSv4.0 only operations
+		opOpenConfirm:      notSupported,
+		opRenew:            notSupported,
+		opSetClientID:      notSupported,
+		opSetClientIDConf:  notSupported,
+		opReleaseLockOwner: notSupported,
+	}
+}
+
+// sessionless returns true for operations which may start a
+// compound without a SEQUENCE
+func sessionless(op uint32) bool {
+	switch op {
+	case opExchangeID, opCreateSession, opDestroySession, opDestroyClientID, opBindConnToSession:
+		return true
+	}
+	return false
+}
+
+// notSupported is used for operations which aren't implemented
+func notSupported(c *compound4, r *xdrReader, w *xdrWriter) uint32 {
+	return nfs4errNotSupp
+}
+
+// compound executes a COMPOUND returning the encoded result or nil if
+// the arguments couldn't be decoded
+func (s *server4) compound(r *xdrReader) []byte {
+	tag := r.opaque(nfs4MaxTagLen)
+	minorVersion := r.uint32()
+	n := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+	w := &xdrWriter{}
+	w.uint32(nfs4OK)
+	w.opaque(tag)
+	countPos := w.len()
+	w.uint32(0)
+	if minorVersion != nfsMinorVers {
+		w.putUint32(0, nfs4errMinorVersMismatch)
+		return w.b
+	}
+	c := &compound4{s: s}
+	defer c.finish(w)
+	var (
+		status uint32
+		count  uint32
+	)
+	for i := range n {
+		op := r.uint32()
+		if r.err != nil {
+			status = nfs4errBadXDR
+			break
+		}
+		count++
+		w.uint32(op)
+		statusPos := w.len()
+		w.uint32(0)
+		fn := ops[op]
+		switch {
+		case fn == nil:
+			w.putUint32(statusPos-4, opIllegal)
+			status = nfs4errOpIllegal
+		case i == 0 && n > nfs4MaxOps:
+			status = nfs4errTooManyOps
+		case i == 0 && op != opSequence && !sessionless(op):
+			status = nfs4errOpNotInSession
+		case i > 0 && op == opSequence:
+			status = nfs4errSequencePos
+		case c.sess == nil && op != opSequence && !sessionless(op):
+			status = nfs4errOpNotInSession
+		default:
+			status = fn(c, r, w)
+			if r.err != nil {
+				w.truncate(statusPos + 4)
+				status = nfs4errBadXDR
+			}
+		}
+		w.putUint32(statusPos, status)
+		if c.replay != nil {
+			return c.replay
+		}
+		if status != nfs4OK {
+			break
+		}
+	}
+	w.putUint32(0, status)
+	w.putUint32(countPos, count)
+	return w.b
+}
+
+// finish releases the session slot, caching the reply if required
+func (c *compound4) finish(w *xdrWriter) {
+	if c.slot == nil || c.replay != nil {
+		return
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	c.slot.busy = false
+	if c.cachethis {
+		c.slot.reply = w.b
+	}
+}
+
+// client returns the client of the session
+func (c *compound4) client() *client4 {
+	return c.sess.client
+}
+
+// channelAttrs4 are the attributes of a session channel
+type channelAttrs4 struct {
+	headerPadSize         uint32
+	maxRequestSize        uint32
+	maxResponseSize       uint32
+	maxResponseSizeCached uint32
+	maxOperations         uint32
+	maxRequests           uint32
+	rdmaIRD               []uint32
+}
+
+func (r *xdrReader) channelAttrs() (ca channelAttrs4) {
+	ca.headerPadSize = r.uint32()
+	ca.maxRequestSize = r.uint32()
+	ca.maxResponseSize = r.uint32()
+	ca.maxResponseSizeCached = r.uint32()
+	ca.maxOperations = r.uint32()
+	ca.maxRequests = r.uint32()
+	n := r.uint32()
+	if n > 1 {
+		r.err = errBadXDR
+		return ca
+	}
+	for range n {
+		ca.rdmaIRD = append(ca.rdmaIRD, r.uint32())
+	}
+	return ca
+}
+
+func (w *xdrWriter) channelAttrs(ca channelAttrs4) {
+	w.uint32(ca.headerPadSize)
+	w.uint32(ca.maxRequestSize)
+	w.uint32(ca.maxResponseSize)
+	w.uint32(ca.maxResponseSizeCached)
+	w.uint32(ca.maxOperations)
+	w.uint32(ca.maxRequests)
+	w.uint32(uint32(len(ca.rdmaIRD)))
+	for _, v := range ca.rdmaIRD {
+		w.uint32(v)
+	}
+}
+
+// clamp returns v limited to the range [lo, hi]
+func clamp(v, lo, hi uint32) uint32 {
+	return max(lo, min(v, hi))
+}
+
+// EXCHANGE_ID - register a client
+func (c *compound4) exchangeID(r *xdrReader, w *xdrWriter) uint32 {
+	var verifier [8]byte
+	copy(verifier[:], r.fixed(8))
+	ownerID := string(r.opaque(nfs4MaxOwnerIDLen))
+	flags := r.uint32()
+	if how := r.uint32(); how != sp4None {
+		return nfs4errNotSupp
+	}
+	nImpl := r.uint32()
+	if nImpl > 1 {
+		r.err = errBadXDR
+	}
+	for range nImpl {
+		_ = r.string(nfs4MaxOpaque) // domain
+		_ = r.string(nfs4MaxOpaque) // name
+		_ = r.uint64()              // date seconds
+		_ = r.uint32()              // date nanoseconds
+	}
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.owners[ownerID]
+	if flags&exchgid4FlagUpdConfirmedRec != 0 {
+		if client == nil || !client.confirmed {
+			return nfs4errNoEnt
+		}
+		if client.verifier != verifier {
+			return nfs4errNotSame
+		}
+	} else if client != nil && client.verifier != verifier {
+		// Client has restarted so throw away its old state
+		fs.Debugf(nil, "nfs4: client %x restarted", client.id)
+		s.destroyClient(client)
+		client = nil
+	}
+	if client == nil {
+		s.nextID++
+		client = &client4{
+			id:       uint64(s.bootID)<<32 | (s.nextID & 0xFFFFFFFF),
+			ownerID:  ownerID,
+			verifier: verifier,
+			seq:      1,
+			sessions: make(map[sessionID4]*session4),
+		}
+		s.clients[client.id] = client
+		s.owners[ownerID] = client
+	}
+	client.renewed = time.Now()
+	respFlags := uint32(exchgid4FlagUseNonPNFS)
+	if client.confirmed {
+		respFlags |= exchgid4FlagConfirmedR
+	}
+	w.uint64(client.id)
+	w.uint32(client.seq)
+	w.uint32(respFlags)
+	w.uint32(sp4None)
+	w.uint64(0) // server_owner minor ID
+	w.opaque(s.owner)
+	w.opaque(s.owner) // server scope
+	w.uint32(1)       // server_impl_id
+	w.string(nfs4ServerDomain)
+	w.string("rclone " + fs.Version)
+	w.uint64(0)
+	w.uint32(0)
+	return nfs4OK
+}
+
+// CREATE_SESSION - make a session for a client
+func (c *compound4) createSession(r *xdrReader, w *xdrWriter) uint32 {
+	clientID := r.uint64()
+	seq := r.uint32()
+	_ = r.uint32() // flags
+	fore := r.channelAttrs()
+	back := r.channelAttrs()
+	_ = r.uint32() // callback program
+	nSec := r.uint32()
+	for range nSec {
+		switch flavor := r.uint32(); flavor {
+		case authNone:
+		case authSys:
+			_ = r.uint32()    // stamp
+			_ = r.string(255) // machine name
+			_ = r.uint32()    // uid
+			_ = r.uint32()    // gid
+			nGids := r.uint32()
+			if nGids > 16 {
+				r.err = errBadXDR
+			}
+			for range nGids {
+				_ = r.uint32()
+			}
+		case 6: // RPCSEC_GSS
+			_ = r.uint32()
+			_ = r.opaque(nfs4MaxOpaque)
+			_ = r.opaque(nfs4MaxOpaque)
+		default:
+			r.err = errBadXDR
+		}
+	}
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil {
+		return nfs4errStaleClientID
+	}
+	if seq == client.seq-1 && client.csReply != nil {
+		// Replay of the last CREATE_SESSION
+		w.b = append(w.b, client.csReply...)
+		return nfs4OK
+	}
+	if seq != client.seq {
+		return nfs4errSeqMisordered
+	}
+	fore = channelAttrs4{
+		maxRequestSize:        min(fore.maxRequestSize, nfs4MaxReqSize),
+		maxResponseSize:       min(fore.maxResponseSize, nfs4MaxRespSize),
+		maxResponseSizeCached: min(fore.maxResponseSizeCached, nfs4MaxRespSize),
+		maxOperations:         clamp(fore.maxOperations, 1, nfs4MaxOps),
+		maxRequests:           clamp(fore.maxRequests, 1, nfs4MaxSlots),
+	}
+	back.rdmaIRD = nil
+	s.nextID++
+	sess := &session4{
+		client: client,
+		slots:  make([]slot4, fore.maxRequests),
+	}
+	be.PutUint64(sess.id[:8], client.id)
+	be.PutUint64(sess.id[8:], s.nextID)
+	s.sessions[sess.id] = sess
+	client.sessions[sess.id] = sess
+	client.confirmed = true
+	client.renewed = time.Now()
+	res := &xdrWriter{}
+	res.fixed(sess.id[:])
+	res.uint32(seq)
+	res.uint32(0) // flags - no persistent reply cache and no back channel
+	res.channelAttrs(fore)
+	res.channelAttrs(back)
+	client.csReply = res.b
+	client.seq++
+	w.b = append(w.b, res.b...)
+	fs.Debugf(nil, "nfs4: created session %x for client %x with %d slots", sess.id, client.id, len(sess.slots))
+	return nfs4OK
+}
+
+// DESTROY_SESSION - remove a session
+func (c *compound4) destroySession(r *xdrReader, w *xdrWriter) uint32 {
+	var id sessionID4
+	copy(id[:], r.fixed(len(id)))
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[id]
+	if sess == nil {
+		return nfs4errBadSession
+	}
+	delete(s.sessions, id)
+	delete(sess.client.sessions, id)
+	return nfs4OK
+}
+
+// DESTROY_CLIENTID - remove a client with no sessions
+func (c *compound4) destroyClientID(r *xdrReader, w *xdrWriter) uint32 {
+	clientID := r.uint64()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil {
+		return nfs4errStaleClientID
+	}
+	if len(client.sessions) > 0 {
+		return nfs4errClientIDBusy
+	}
+	s.destroyClient(client)
+	return nfs4OK
+}
+
+// BIND_CONN_TO_SESSION - associate a connection with a session
+//
+// All connections can be used with any session so this just checks
+// the session exists.
+func (c *compound4) bindConnToSession(r *xdrReader, w *xdrWriter) uint32 {
+	var id sessionID4
+	copy(id[:], r.fixed(len(id)))
+	_ = r.uint32() // direction
+	_ = r.bool()   // use RDMA
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	c.s.mu.Lock()
+	sess := c.s.sessions[id]
+	c.s.mu.Unlock()
+	if sess == nil {
+		return nfs4errBadSession
+	}
+	w.fixed(id[:])
+	w.uint32(1) // CDFS4_FORE
+	w.bool(false)
+	return nfs4OK
+}
+
+// SEQUENCE - start a request in a session
+func (c *compound4) sequence(r *xdrReader, w *xdrWriter) uint32 {
+	var id sessionID4
+	copy(id[:], r.fixed(len(id)))
+	seq := r.uint32()
+	slotID := r.uint32()
+	_ = r.uint32() // highest slot ID
+	cachethis := r.bool()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[id]
+	if sess == nil {
+		return nfs4errBadSession
+	}
+	if slotID >= uint32(len(sess.slots)) {
+		return nfs4errBadSlot
+	}
+	slot := &sess.slots[slotID]
+	switch seq {
+	case slot.seq + 1:
+		if slot.busy {
+			return nfs4errSeqMisordered
+		}
+		slot.seq = seq
+		slot.busy = true
+		slot.reply = nil
+		c.sess = sess
+		c.slot = slot
+		c.cachethis = cachethis
+	case slot.seq:
+		switch {
+		case slot.busy:
+			return nfs4errDelay
+		case slot.reply == nil:
+			return nfs4errRetryUncachedRep
+		}
+		c.replay = slot.reply
+		return nfs4OK
+	default:
+		return nfs4errSeqMisordered
+	}
+	sess.client.renewed = time.Now()
+	highest := uint32(len(sess.slots) - 1)
+	w.fixed(id[:])
+	w.uint32(seq)
+	w.uint32(slotID)
+	w.uint32(highest)
+	w.uint32(highest) // target highest slot ID
+	w.uint32(0)       // status flags
+	return nfs4OK
+}
+
+// RECLAIM_COMPLETE - the client has finished reclaiming state
+//
+// The server doesn't keep state over restarts and grants all
+// reclaims, so there is nothing to do.
+func (c *compound4) reclaimComplete(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.bool() // one fs
+	return nfs4OK
+}
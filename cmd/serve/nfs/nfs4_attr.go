@@ -0,0 +1,381 @@
+//go:build unix
+
+package nfs
+
+import (
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/willscott/go-nfs/file"
+)
+
+// File attributes for NFSv4
+
+// supportedAttrs are the attributes which are supported
+var supportedAttrs = newBitmap(
+	attrSupportedAttrs, attrType, attrFHExpireType, attrChange, attrSize,
+	attrLinkSupport, attrSymlinkSupport, attrNamedAttr, attrFSID,
+	attrUniqueHandles, attrLeaseTime, attrRdattrError, attrACLSupport,
+	attrCanSetTime, attrCaseInsensitive, attrCasePreserving,
+	attrChownRestricted, attrFilehandle, attrFileID, attrFilesAvail,
+	attrFilesFree, attrFilesTotal, attrHomogeneous, attrMaxFileSize,
+	attrMaxLink, attrMaxName, attrMaxRead, attrMaxWrite, attrMode,
+	attrNoTrunc, attrNumLinks, attrOwner, attrOwnerGroup, attrRawDev,
+	attrSpaceAvail, attrSpaceFree, attrSpaceTotal, attrSpaceUsed,
+	attrTimeAccess, attrTimeAccessSet, attrTimeDelta, attrTimeMetadata,
+	attrTimeModify, attrTimeModifySet, attrMountedOnFileID,
+	attrSuppattrExclcreat,
+)
+
+// settableAttrs are the attributes which can be set with SETATTR
+var settableAttrs = newBitmap(attrSize, attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet, attrTimeModifySet)
+
+// exclcreatAttrs are the attributes which can be set with an
+// EXCLUSIVE4_1 create
+var exclcreatAttrs = newBitmap(attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet, attrTimeModifySet)
+
+// fileID returns a file ID for the path
+//
+// This is made from the path rather than the VFS inode number so it
+// stays the same when the server is restarted.
+func fileID(p string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(p))
+	id := h.Sum64()
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// changeOf returns the change attribute for the file
+func changeOf(fi os.FileInfo) uint64 {
+	return uint64(fi.ModTime().UnixNano()) + uint64(fi.Size())
+}
+
+// fileType returns the NFSv4 type of the file
+func fileType(fi os.FileInfo) uint32 {
+	switch {
+	case fi.IsDir():
+		return nf4Dir
+	case fi.Mode()&os.ModeSymlink != 0:
+		return nf4Lnk
+	}
+	return nf4Reg
+}
+
+// owners returns the uid and gid of the file
+func owners(fi os.FileInfo) (uid, gid uint32) {
+	if stat, ok := fi.Sys().(*file.FileInfo); ok {
+		return stat.UID, stat.GID
+	}
+	return 0, 0
+}
+
+func (w *xdrWriter) time(t time.Time) {
+	w.uint64(uint64(t.Unix()))
+	w.uint32(uint32(t.Nanosecond()))
+}
+
+func (r *xdrReader) time() time.Time {
+	secs := int64(r.uint64())
+	nsecs := r.uint32()
+	if nsecs >= 1e9 {
+		r.err = errBadXDR
+	}
+	return time.Unix(secs, int64(nsecs))
+}
+
+// fattr reads an fattr4
+func (r *xdrReader) fattr() (mask bitmap4, vals []byte) {
+	mask = r.bitmap()
+	vals = r.opaque(nfs4MaxOpaque)
+	return mask, vals
+}
+
+// encodeAttrs writes an fattr4 for the file at p with the attributes
+// in req which are supported
+func (s *server4) encodeAttrs(w *xdrWriter, req bitmap4, p string, fi os.FileInfo) {
+	var (
+		got   bitmap4
+		vals  xdrWriter
+		statd bool
+		total int64
+		used  int64
+		free  int64
+	)
+	statfs := func() {
+		if !statd {
+			total, used, free = s.vfs.Statfs()
+			if total < 0 {
+				total = math.MaxInt64
+			}
+			if free < 0 {
+				free = total - max(used, 0)
+			}
+			if used < 0 {
+				used = total - free
+			}
+			statd = true
+		}
+	}
+	uid, gid := owners(fi)
+	for _, bit := range req.and(supportedAttrs).bits() {
+		switch bit {
+		case attrSupportedAttrs:
+			vals.bitmap(supportedAttrs)
+		case attrType:
+			vals.uint32(fileType(fi))
+		case attrFHExpireType:
+			vals.uint32(0) // FH4_PERSISTENT
+		case attrChange:
+			vals.uint64(changeOf(fi))
+		case attrSize:
+			vals.uint64(uint64(fi.Size()))
+		case attrLinkSupport:
+			vals.bool(false)
+		case attrSymlinkSupport:
+			vals.bool(s.vfs.Opt.Links)
+		case attrNamedAttr:
+			vals.bool(false)
+		case attrFSID:
+			vals.uint64(nfs4FSIDMajor)
+			vals.uint64(0)
+		case attrUniqueHandles:
+			vals.bool(true)
+		case attrLeaseTime:
+			vals.uint32(uint32(leaseTime / time.Second))
+		case attrRdattrError:
+			vals.uint32(nfs4OK)
+		case attrACLSupport:
+			vals.uint32(0)
+		case attrCanSetTime:
+			vals.bool(true)
+		case attrCaseInsensitive:
+			vals.bool(s.vfs.Opt.CaseInsensitive)
+		case attrCasePreserving:
+			vals.bool(true)
+		case attrChownRestricted:
+			vals.bool(true)
+		case attrFilehandle:
+			vals.opaque(s.handles.toHandle(p))
+		case attrFileID, attrMountedOnFileID:
+			vals.uint64(fileID(p))
+		case attrFilesAvail, attrFilesFree, attrFilesTotal:
+			vals.uint64(math.MaxInt32)
+		case attrHomogeneous:
+			vals.bool(true)
+		case attrMaxFileSize:
+			vals.uint64(math.MaxInt64)
+		case attrMaxLink:
+			vals.uint32(1)
+		case attrMaxName:
+			vals.uint32(nfs4MaxNameLen)
+		case attrMaxRead, attrMaxWrite:
+			vals.uint64(nfs4MaxIO)
+		case attrMode:
+			vals.uint32(uint32(fi.Mode().Perm()))
+		case attrNoTrunc:
+			vals.bool(true)
+		case attrNumLinks:
+			vals.uint32(1)
+		case attrOwner:
+			vals.string(strconv.FormatUint(uint64(uid), 10))
+		case attrOwnerGroup:
+			vals.string(strconv.FormatUint(uint64(gid), 10))
+		case attrRawDev:
+			vals.uint32(0)
+			vals.uint32(0)
+		case attrSpaceAvail, attrSpaceFree:
+			statfs()
+			vals.uint64(uint64(free))
+		case attrSpaceTotal:
+			statfs()
+			vals.uint64(uint64(total))
+		case attrSpaceUsed:
+			vals.uint64(uint64(fi.Size()))
+		case attrTimeAccess, attrTimeMetadata, attrTimeModify:
+			vals.time(fi.ModTime())
+		case attrTimeDelta:
+			precision := s.vfs.Fs().Precision()
+			if precision <= 0 || precision == fs.ModTimeNotSupported {
+				precision = time.Second
+			}
+			vals.time(time.Unix(0, 0).Add(precision))
+		case attrSuppattrExclcreat:
+			vals.bitmap(exclcreatAttrs)
+		default:
+			// write only attributes
+			continue
+		}
+		got.set(bit)
+	}
+	w.bitmap(got)
+	w.opaque(vals.b)
+}
+
+// setAttrs4 are attributes decoded from an fattr4 to be set
+type setAttrs4 struct {
+	mask     bitmap4
+	size     uint64
+	mode     uint32
+	uid      uint32
+	gid      uint32
+	atime    time.Time
+	mtime    time.Time
+	hasOwner bool
+	hasGroup bool
+}
+
+// parseOwner parses a user or group which should be numeric
+func parseOwner(s string) (uint32, bool) {
+	s, _, _ = strings.Cut(s, "@")
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err == nil
+}
+
+// decodeSetAttrs decodes the attributes to be set
+func decodeSetAttrs(mask bitmap4, vals []byte) (a setAttrs4, status uint32) {
+	r := &xdrReader{b: vals}
+	a.mask = mask
+	now := time.Now()
+	settime := func() time.Time {
+		if r.uint32() == setToClientTime {
+			return r.time()
+		}
+		return now
+	}
+	for _, bit := range mask.bits() {
+		if !supportedAttrs.has(bit) {
+			return a, nfs4errAttrNotSupp
+		}
+		if !settableAttrs.has(bit) {
+			return a, nfs4errInval
+		}
+		var ok bool
+		switch bit {
+		case attrSize:
+			a.size = r.uint64()
+		case attrMode:
+			a.mode = r.uint32() & 0o7777
+		case attrOwner:
+			a.uid, ok = parseOwner(r.string(nfs4MaxOwnerIDLen))
+			if !ok && r.err == nil {
+				return a, nfs4errBadOwner
+			}
+			a.hasOwner = true
+		case attrOwnerGroup:
+			a.gid, ok = parseOwner(r.string(nfs4MaxOwnerIDLen))
+			if !ok && r.err == nil {
+				return a, nfs4errBadOwner
+			}
+			a.hasGroup = true
+		case attrTimeAccessSet:
+			a.atime = settime()
+		case attrTimeModifySet:
+			a.mtime = settime()
+		}
+	}
+	if r.err != nil || len(r.b) != 0 {
+		return a, nfs4errBadXDR
+	}
+	return a, nfs4OK
+}
+
+// setAttrs sets the attributes on the file at p, using the open state
+// st for truncation if set
+func (s *server4) setAttrs(p string, a setAttrs4, st *state4) (set bitmap4, status uint32) {
+	if len(a.mask.bits()) == 0 {
+		return nil, nfs4OK
+	}
+	if !s.writable() {
+		return nil, nfs4errROFS
+	}
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		return nil, errStatus(err)
+	}
+	if a.mask.has(attrSize) {
+		if fi.IsDir() {
+			return set, nfs4errIsDir
+		}
+		if st != nil && st.handle != nil && st.writable {
+			err = st.handle.Truncate(int64(a.size))
+		} else {
+			err = s.truncate(p, int64(a.size))
+		}
+		if err != nil {
+			return set, errStatus(err)
+		}
+		set.set(attrSize)
+	}
+	if a.mask.has(attrMode) {
+		err = s.fs.Chmod(p, os.FileMode(a.mode))
+		if err != nil {
+			return set, errStatus(err)
+		}
+		set.set(attrMode)
+	}
+	if a.hasOwner || a.hasGroup {
+		uid, gid := owners(fi)
+		if a.hasOwner {
+			uid = a.uid
+		}
+		if a.hasGroup {
+			gid = a.gid
+		}
+		err = s.fs.Chown(p, int(uid), int(gid))
+		if err != nil {
+			return set, errStatus(err)
+		}
+		if a.hasOwner {
+			set.set(attrOwner)
+		}
+		if a.hasGroup {
+			set.set(attrOwnerGroup)
+		}
+	}
+	if a.mask.has(attrTimeAccessSet) || a.mask.has(attrTimeModifySet) {
+		atime, mtime := a.atime, a.mtime
+		if !a.mask.has(attrTimeModifySet) {
+			mtime = fi.ModTime()
+		}
+		if !a.mask.has(attrTimeAccessSet) {
+			atime = mtime
+		}
+		err = s.fs.Chtimes(p, atime, mtime)
+		if err != nil {
+			return set, errStatus(err)
+		}
+		if a.mask.has(attrTimeAccessSet) {
+			set.set(attrTimeAccessSet)
+		}
+		if a.mask.has(attrTimeModifySet) {
+			set.set(attrTimeModifySet)
+		}
+	}
+	return set, nfs4OK
+}
+
+// truncate sets the size of the file at p which isn't open
+func (s *server4) truncate(p string, size int64) error {
+	flags := os.O_RDWR
+	if size == 0 {
+		flags |= os.O_TRUNC
+	}
+	handle, err := s.vfs.OpenFile(p, flags, 0)
+	if err != nil {
+		return err
+	}
+	err = handle.Truncate(size)
+	closeErr := handle.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,256 @@
+//go:build unix
+
+package nfs
+
+// Constants from RFC 5531 (ONC RPC) and RFC 5661 (NFSv4.1)
+
+// RPC
+const (
+	rpcVersion    = 2
+	rpcCall       = 0
+	rpcReply      = 1
+	nfsProgram    = 100003
+	nfsVersion4   = 4
+	nfsMinorVers  = 1
+	procNull      = 0
+	procCompound  = 1
+	authNone      = 0
+	authSys       = 1
+	msgAccepted   = 0
+	msgDenied     = 1
+	acceptSuccess = 0
+	progUnavail   = 1
+	progMismatch  = 2
+	procUnavail   = 3
+	garbageArgs   = 4
+	rejectAuth    = 1
+	authBadCred   = 1
+)
+
+// Operations
+const (
+	opAccess            = 3
+	opClose             = 4
+	opCommit            = 5
+	opCreate            = 6
+	opDelegPurge        = 7
+	opDelegReturn       = 8
+	opGetattr           = 9
+	opGetFH             = 10
+	opLink              = 11
+	opLock              = 12
+	opLockT             = 13
+	opLockU             = 14
+	opLookup            = 15
+	opLookupP           = 16
+	opNVerify           = 17
+	opOpen              = 18
+	opOpenAttr          = 19
+	opOpenConfirm       = 20
+	opOpenDowngrade     = 21
+	opPutFH             = 22
+	opPutPubFH          = 23
+	opPutRootFH         = 24
+	opRead              = 25
+	opReadDir           = 26
+	opReadLink          = 27
+	opRemove            = 28
+	opRename            = 29
+	opRenew             = 30
+	opRestoreFH         = 31
+	opSaveFH            = 32
+	opSecInfo           = 33
+	opSetattr           = 34
+	opSetClientID       = 35
+	opSetClientIDConf   = 36
+	opVerify            = 37
+	opWrite             = 38
+	opReleaseLockOwner  = 39
+	opBackchannelCtl    = 40
+	opBindConnToSession = 41
+	opExchangeID        = 42
+	opCreateSession     = 43
+	opDestroySession    = 44
+	opFreeStateID       = 45
+	opSecInfoNoName     = 52
+	opSequence          = 53
+	opTestStateID       = 55
+	opDestroyClientID   = 57
+	opReclaimComplete   = 58
+	opIllegal           = 10044
+)
+
+// Status codes
+const (
+	nfs4OK                   = 0
+	nfs4errPerm              = 1
+	nfs4errNoEnt             = 2
+	nfs4errIO                = 5
+	nfs4errExist             = 17
+	nfs4errNotDir            = 20
+	nfs4errIsDir             = 21
+	nfs4errInval             = 22
+	nfs4errROFS              = 30
+	nfs4errNameTooLong       = 63
+	nfs4errNotEmpty          = 66
+	nfs4errStale             = 70
+	nfs4errBadHandle         = 10001
+	nfs4errBadCookie         = 10003
+	nfs4errNotSupp           = 10004
+	nfs4errTooSmall          = 10005
+	nfs4errServerFault       = 10006
+	nfs4errBadType           = 10007
+	nfs4errDelay             = 10008
+	nfs4errSame              = 10009
+	nfs4errDenied            = 10010
+	nfs4errShareDenied       = 10015
+	nfs4errNoFileHandle      = 10020
+	nfs4errMinorVersMismatch = 10021
+	nfs4errStaleClientID     = 10022
+	nfs4errOldStateID        = 10024
+	nfs4errBadStateID        = 10025
+	nfs4errNotSame           = 10027
+	nfs4errSymlink           = 10029
+	nfs4errRestoreFH         = 10030
+	nfs4errAttrNotSupp       = 10032
+	nfs4errBadXDR            = 10036
+	nfs4errLocksHeld         = 10037
+	nfs4errOpenMode          = 10038
+	nfs4errBadOwner          = 10039
+	nfs4errBadName           = 10041
+	nfs4errBadRange          = 10042
+	nfs4errOpIllegal         = 10044
+	nfs4errBadSession        = 10052
+	nfs4errBadSlot           = 10053
+	nfs4errSeqMisordered     = 10063
+	nfs4errSequencePos       = 10064
+	nfs4errRetryUncachedRep  = 10068
+	nfs4errTooManyOps        = 10070
+	nfs4errOpNotInSession    = 10071
+	nfs4errClientIDBusy      = 10074
+)
+
+// File types
+const (
+	nf4Reg = 1
+	nf4Dir = 2
+	nf4Blk = 3
+	nf4Chr = 4
+	nf4Lnk = 5
+)
+
+// Attributes
+const (
+	attrSupportedAttrs    = 0
+	attrType              = 1
+	attrFHExpireType      = 2
+	attrChange            = 3
+	attrSize              = 4
+	attrLinkSupport       = 5
+	attrSymlinkSupport    = 6
+	attrNamedAttr         = 7
+	attrFSID              = 8
+	attrUniqueHandles     = 9
+	attrLeaseTime         = 10
+	attrRdattrError       = 11
+	attrACLSupport        = 13
+	attrCanSetTime        = 15
+	attrCaseInsensitive   = 16
+	attrCasePreserving    = 17
+	attrChownRestricted   = 18
+	attrFilehandle        = 19
+	attrFileID            = 20
+	attrFilesAvail        = 21
+	attrFilesFree         = 22
+	attrFilesTotal        = 23
+	attrHomogeneous       = 26
+	attrMaxFileSize       = 27
+	attrMaxLink           = 28
+	attrMaxName           = 29
+	attrMaxRead           = 30
+	attrMaxWrite          = 31
+	attrMode              = 33
+	attrNoTrunc           = 34
+	attrNumLinks          = 35
+	attrOwner             = 36
+	attrOwnerGroup        = 37
+	attrRawDev            = 41
+	attrSpaceAvail        = 42
+	attrSpaceFree         = 43
+	attrSpaceTotal        = 44
+	attrSpaceUsed         = 45
+	attrTimeAccess        = 47
+	attrTimeAccessSet     = 48
+	attrTimeDelta         = 51
+	attrTimeMetadata      = 52
+	attrTimeModify        = 53
+	attrTimeModifySet     = 54
+	attrMountedOnFileID   = 55
+	attrSuppattrExclcreat = 75
+)
+
+// ACCESS bits
+const (
+	access4Read    = 0x01
+	access4Lookup  = 0x02
+	access4Modify  = 0x04
+	access4Extend  = 0x08
+	access4Delete  = 0x10
+	access4Execute = 0x20
+)
+
+// OPEN arguments and results
+const (
+	shareAccessRead  = 1
+	shareAccessWrite = 2
+	shareAccessBoth  = 3
+	shareDenyBoth    = 3
+
+	open4NoCreate = 0
+	open4Create   = 1
+
+	createUnchecked  = 0
+	createGuarded    = 1
+	createExclusive  = 2
+	createExclusive1 = 3
+
+	claimNull     = 0
+	claimPrevious = 1
+	claimFH       = 4
+
+	open4ResultLocktypePosix = 4
+
+	openDelegateNone = 0
+)
+
+// Lock types
+const (
+	readLT   = 1
+	writeLT  = 2
+	writewLT = 4
+)
+
+// WRITE stability
+const (
+	unstable4 = 0
+	fileSync4 = 2
+)
+
+// EXCHANGE_ID and CREATE_SESSION
+const (
+	exchgid4FlagUseNonPNFS      = 0x00010000
+	exchgid4FlagUpdConfirmedRec = 0x40000000
+	exchgid4FlagConfirmedR      = 0x80000000
+	sp4None                     = 0
+)
+
+// time_how4 for settime4
+const setToClientTime = 1
+
+// Special stateids
+var (
+	stateidAnonymous = stateid4{}
+	stateidBypass    = stateid4{seqid: 0xFFFFFFFF, other: [12]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}
+	stateidCurrent   = stateid4{seqid: 1}
+	stateidInvalid   = stateid4{seqid: 0xFFFFFFFF}
+)
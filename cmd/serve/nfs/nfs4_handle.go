@@ -0,0 +1,120 @@
+//go:build unix
+
+package nfs
+
+import (
+	"strings"
+	"sync"
+)
+
+// NFSv4 file handles contain the path of the file so they stay valid
+// when the server is restarted. Paths which are too long to fit are
+// hashed and looked up in the on disk handle cache instead.
+
+const (
+	nfs4FHSize = 128 // maximum size of an NFSv4 file handle
+
+	fhTypePath = 1 // handle contains the path
+	fhTypeHash = 2 // handle contains a hash looked up in the disk cache
+)
+
+// handles4 converts between paths and NFSv4 file handles
+type handles4 struct {
+	h       *Handler
+	mu      sync.Mutex
+	disk    *diskHandler      // cache for long paths - made on first use
+	renamed map[string]string // old path to new path of files renamed while open
+}
+
+// newHandles4 makes a new handle converter
+func newHandles4(h *Handler) *handles4 {
+	return &handles4{
+		h:       h,
+		renamed: make(map[string]string),
+	}
+}
+
+// getDisk returns the disk cache making it if necessary
+//
+// call with mu held
+func (hs *handles4) getDisk() (*diskHandler, error) {
+	if hs.disk == nil {
+		dh, err := newDiskHandler(hs.h, cacheDisk.String())
+		if err != nil {
+			return nil, err
+		}
+		hs.disk = dh
+	}
+	return hs.disk, nil
+}
+
+// toHandle returns the file handle for the path
+func (hs *handles4) toHandle(p string) []byte {
+	if 1+len(p) <= nfs4FHSize {
+		return append([]byte{fhTypePath}, p...)
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	dh, err := hs.getDisk()
+	if err != nil {
+		// Returning a handle we can't resolve will give the client a
+		// stale handle error which is the best we can do
+		return []byte{fhTypeHash}
+	}
+	return append([]byte{fhTypeHash}, dh.ToHandle(hs.h.billyFS, []string{p})...)
+}
+
+// fromHandle returns the path for the file handle
+func (hs *handles4) fromHandle(fh []byte) (p string, status uint32) {
+	if len(fh) < 1 || len(fh) > nfs4FHSize {
+		return "", nfs4errBadHandle
+	}
+	switch fh[0] {
+	case fhTypePath:
+		p = string(fh[1:])
+	case fhTypeHash:
+		hs.mu.Lock()
+		dh, err := hs.getDisk()
+		hs.mu.Unlock()
+		if err != nil {
+			return "", nfs4errServerFault
+		}
+		_, splitPath, err := dh.FromHandle(fh[1:])
+		if err != nil {
+			return "", nfs4errStale
+		}
+		p = strings.Join(splitPath, "/")
+	default:
+		return "", nfs4errBadHandle
+	}
+	hs.mu.Lock()
+	if newPath, ok := hs.renamed[p]; ok {
+		p = newPath
+	}
+	hs.mu.Unlock()
+	return p, nfs4OK
+}
+
+// rename records that oldPath was renamed to newPath while open so
+// handles to oldPath carry on working until it is closed
+func (hs *handles4) rename(oldPath, newPath string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for from, to := range hs.renamed {
+		if to == oldPath {
+			hs.renamed[from] = newPath
+		}
+	}
+	hs.renamed[oldPath] = newPath
+}
+
+// forget removes any rename records pointing to p
+func (hs *handles4) forget(p string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for from, to := range hs.renamed {
+		if to == p {
+			delete(hs.renamed, from)
+		}
+	}
+}
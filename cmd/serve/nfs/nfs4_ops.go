@@ -0,0 +1,1298 @@
+//go:build unix
+
+package nfs
+
+import (
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// NFSv4 file system operations
+
+// errStatus converts a VFS error into an NFSv4 status
+func errStatus(err error) uint32 {
+	var vfsErr vfs.Error
+	switch {
+	case err == nil:
+		return nfs4OK
+	case errors.Is(err, vfs.ENOENT):
+		return nfs4errNoEnt
+	case errors.Is(err, vfs.EEXIST):
+		return nfs4errExist
+	case errors.Is(err, vfs.EPERM):
+		return nfs4errPerm
+	case errors.Is(err, vfs.EINVAL):
+		return nfs4errInval
+	case errors.As(err, &vfsErr):
+		switch vfsErr {
+		case vfs.ENOTEMPTY:
+			return nfs4errNotEmpty
+		case vfs.EROFS:
+			return nfs4errROFS
+		case vfs.ENOSYS:
+			return nfs4errNotSupp
+		case vfs.ELOOP:
+			return nfs4errSymlink
+		case vfs.ESPIPE, vfs.EBADF:
+			return nfs4errInval
+		case vfs.EAGAIN:
+			return nfs4errDelay
+		}
+	}
+	fs.Debugf(nil, "nfs4: returning IO error for: %v", err)
+	return nfs4errIO
+}
+
+// checkName checks a file name component is valid
+func checkName(name string) uint32 {
+	switch {
+	case name == "":
+		return nfs4errInval
+	case len(name) > nfs4MaxNameLen:
+		return nfs4errNameTooLong
+	case !utf8.ValidString(name):
+		return nfs4errInval
+	case name == "." || name == "..", strings.ContainsAny(name, "/\x00"):
+		return nfs4errBadName
+	}
+	return nfs4OK
+}
+
+// setFH sets the current file handle
+func (c *compound4) setFH(p string) {
+	c.fh = p
+	c.hasFH = true
+}
+
+// stat returns the info for the current file handle
+func (c *compound4) stat() (os.FileInfo, uint32) {
+	if !c.hasFH {
+		return nil, nfs4errNoFileHandle
+	}
+	fi, err := c.s.fs.Stat(c.fh)
+	if errors.Is(err, vfs.ENOENT) {
+		return nil, nfs4errStale
+	}
+	if err != nil {
+		return nil, errStatus(err)
+	}
+	return fi, nfs4OK
+}
+
+// dir checks the current file handle is a directory returning its info
+func (c *compound4) dir() (os.FileInfo, uint32) {
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return nil, status
+	}
+	switch fileType(fi) {
+	case nf4Dir:
+		return fi, nfs4OK
+	case nf4Lnk:
+		return nil, nfs4errSymlink
+	}
+	return nil, nfs4errNotDir
+}
+
+// file checks the current file handle is a regular file
+func (c *compound4) file() (os.FileInfo, uint32) {
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return nil, status
+	}
+	switch fileType(fi) {
+	case nf4Dir:
+		return nil, nfs4errIsDir
+	case nf4Lnk:
+		return nil, nfs4errSymlink
+	}
+	return fi, nfs4OK
+}
+
+// child returns the path of name in the current directory
+func (c *compound4) child(name string) (p string, status uint32) {
+	if _, status = c.dir(); status != nfs4OK {
+		return "", status
+	}
+	if status = checkName(name); status != nfs4OK {
+		return "", status
+	}
+	return path.Join(c.fh, name), nfs4OK
+}
+
+// change returns the change attribute of p or 0
+func (s *server4) change(p string) uint64 {
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		return 0
+	}
+	return changeOf(fi)
+}
+
+// changeInfo writes a change_info4
+func (w *xdrWriter) changeInfo(before, after uint64) {
+	w.bool(false) // not atomic
+	w.uint64(before)
+	w.uint64(after)
+}
+
+// stateidArg resolves the current stateid
+func (c *compound4) stateidArg(sid stateid4) stateid4 {
+	if sid == stateidCurrent {
+		return c.cur
+	}
+	return sid
+}
+
+// ioState looks up the state used for a READ, WRITE or SETATTR
+// returning nil for the special anonymous stateids
+func (c *compound4) ioState(sid stateid4) (*state4, uint32) {
+	sid = c.stateidArg(sid)
+	if sid == stateidAnonymous || sid == stateidBypass {
+		return nil, nfs4OK
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	st, status := c.s.findState(c.client(), sid)
+	if status != nfs4OK {
+		return nil, status
+	}
+	if st.isLock() {
+		st = st.open
+	}
+	return st, nfs4OK
+}
+
+// PUTROOTFH and PUTPUBFH - set the current file handle to the root
+func (c *compound4) putRootFH(r *xdrReader, w *xdrWriter) uint32 {
+	c.setFH("")
+	return nfs4OK
+}
+
+// PUTFH - set the current file handle
+func (c *compound4) putFH(r *xdrReader, w *xdrWriter) uint32 {
+	fh := r.opaque(nfs4FHSize)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	p, status := c.s.handles.fromHandle(fh)
+	if status != nfs4OK {
+		return status
+	}
+	c.setFH(p)
+	_, status = c.stat()
+	if status != nfs4OK {
+		c.hasFH = false
+	}
+	return status
+}
+
+// GETFH - return the current file handle
+func (c *compound4) getFH(r *xdrReader, w *xdrWriter) uint32 {
+	if !c.hasFH {
+		return nfs4errNoFileHandle
+	}
+	w.opaque(c.s.handles.toHandle(c.fh))
+	return nfs4OK
+}
+
+// SAVEFH - save the current file handle
+func (c *compound4) saveFH(r *xdrReader, w *xdrWriter) uint32 {
+	if !c.hasFH {
+		return nfs4errNoFileHandle
+	}
+	c.savedFH, c.hasSaved, c.savedSid = c.fh, true, c.cur
+	return nfs4OK
+}
+
+// RESTOREFH - restore the saved file handle
+func (c *compound4) restoreFH(r *xdrReader, w *xdrWriter) uint32 {
+	if !c.hasSaved {
+		return nfs4errRestoreFH
+	}
+	c.fh, c.hasFH, c.cur = c.savedFH, true, c.savedSid
+	return nfs4OK
+}
+
+// LOOKUP - look up a name in the current directory
+func (c *compound4) lookup(r *xdrReader, w *xdrWriter) uint32 {
+	name := r.string(nfs4MaxOpaque)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	p, status := c.child(name)
+	if status != nfs4OK {
+		return status
+	}
+	_, err := c.s.fs.Stat(p)
+	if err != nil {
+		return errStatus(err)
+	}
+	c.setFH(p)
+	return nfs4OK
+}
+
+// LOOKUPP - look up the parent of the current directory
+func (c *compound4) lookupP(r *xdrReader, w *xdrWriter) uint32 {
+	if _, status := c.dir(); status != nfs4OK {
+		return status
+	}
+	if c.fh == "" {
+		return nfs4errNoEnt
+	}
+	parent := path.Dir(c.fh)
+	if parent == "." {
+		parent = ""
+	}
+	c.setFH(parent)
+	return nfs4OK
+}
+
+// GETATTR - read attributes of the current file handle
+func (c *compound4) getattr(r *xdrReader, w *xdrWriter) uint32 {
+	req := r.bitmap()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return status
+	}
+	c.s.encodeAttrs(w, req, c.fh, fi)
+	return nfs4OK
+}
+
+// compareAttrs is used by VERIFY and NVERIFY to check the attributes
+// passed in are the same as those of the current file handle
+func (c *compound4) compareAttrs(r *xdrReader) (same bool, status uint32) {
+	mask, vals := r.fattr()
+	if r.err != nil {
+		return false, nfs4errBadXDR
+	}
+	for _, bit := range mask.bits() {
+		if !supportedAttrs.has(bit) {
+			return false, nfs4errAttrNotSupp
+		}
+		if bit == attrRdattrError || bit == attrTimeAccessSet || bit == attrTimeModifySet {
+			return false, nfs4errInval
+		}
+	}
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return false, status
+	}
+	ours := &xdrWriter{}
+	c.s.encodeAttrs(ours, mask, c.fh, fi)
+	want := &xdrWriter{}
+	want.bitmap(mask)
+	want.opaque(vals)
+	return string(ours.b) == string(want.b), nfs4OK
+}
+
+// VERIFY - check attributes are the same
+func (c *compound4) verify(r *xdrReader, w *xdrWriter) uint32 {
+	same, status := c.compareAttrs(r)
+	if status == nfs4OK && !same {
+		status = nfs4errNotSame
+	}
+	return status
+}
+
+// NVERIFY - check attributes are different
+func (c *compound4) nverify(r *xdrReader, w *xdrWriter) uint32 {
+	same, status := c.compareAttrs(r)
+	if status == nfs4OK && same {
+		status = nfs4errSame
+	}
+	return status
+}
+
+// SETATTR - set attributes of the current file handle
+func (c *compound4) setattr(r *xdrReader, w *xdrWriter) uint32 {
+	sid := r.stateid()
+	mask, vals := r.fattr()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	var set bitmap4
+	defer func() { w.bitmap(set) }()
+	if _, status := c.stat(); status != nfs4OK {
+		return status
+	}
+	a, status := decodeSetAttrs(mask, vals)
+	if status != nfs4OK {
+		return status
+	}
+	st, status := c.ioState(sid)
+	if status != nfs4OK {
+		return status
+	}
+	set, status = c.s.setAttrs(c.fh, a, st)
+	return status
+}
+
+// ACCESS - check access to the current file handle
+//
+// There are no permission checks other than the file system being
+// read only.
+func (c *compound4) access(r *xdrReader, w *xdrWriter) uint32 {
+	req := r.uint32()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return status
+	}
+	var allowed uint32 = access4Read
+	if fi.IsDir() {
+		allowed |= access4Lookup
+	}
+	if fi.Mode()&0o111 != 0 || fi.IsDir() {
+		allowed |= access4Execute
+	}
+	if c.s.writable() {
+		allowed |= access4Modify | access4Extend | access4Delete
+	}
+	supported := req & (access4Read | access4Lookup | access4Modify | access4Extend | access4Delete | access4Execute)
+	w.uint32(supported)
+	w.uint32(supported & allowed)
+	return nfs4OK
+}
+
+// READLINK - read the target of a symlink
+func (c *compound4) readLink(r *xdrReader, w *xdrWriter) uint32 {
+	fi, status := c.stat()
+	if status != nfs4OK {
+		return status
+	}
+	if fileType(fi) != nf4Lnk {
+		return nfs4errInval
+	}
+	target, err := c.s.fs.Readlink(c.fh)
+	if err != nil {
+		return errStatus(err)
+	}
+	w.string(target)
+	return nfs4OK
+}
+
+// CREATE - make a directory or a symlink
+func (c *compound4) create(r *xdrReader, w *xdrWriter) uint32 {
+	objType := r.uint32()
+	var target string
+	switch objType {
+	case nf4Lnk:
+		target = r.string(nfs4MaxOpaque)
+	case nf4Blk, nf4Chr:
+		_ = r.uint32() // specdata1
+		_ = r.uint32() // specdata2
+	}
+	name := r.string(nfs4MaxOpaque)
+	mask, vals := r.fattr()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	p, status := c.child(name)
+	if status != nfs4OK {
+		return status
+	}
+	a, status := decodeSetAttrs(mask, vals)
+	if status != nfs4OK {
+		return status
+	}
+	if !c.s.writable() {
+		return nfs4errROFS
+	}
+	if _, err := c.s.fs.Stat(p); err == nil {
+		return nfs4errExist
+	}
+	before := c.s.change(c.fh)
+	var err error
+	switch objType {
+	case nf4Dir:
+		perm := os.FileMode(0777)
+		if a.mask.has(attrMode) {
+			perm = os.FileMode(a.mode)
+		}
+		err = c.s.vfs.Mkdir(p, perm)
+	case nf4Lnk:
+		err = c.s.fs.Symlink(target, p)
+	default:
+		return nfs4errBadType
+	}
+	if err != nil {
+		return errStatus(err)
+	}
+	set, status := c.s.setAttrs(p, a, nil)
+	if status != nfs4OK {
+		fs.Debugf(p, "nfs4: failed to set attributes on create: %d", status)
+	}
+	w.changeInfo(before, c.s.change(c.fh))
+	w.bitmap(set)
+	c.setFH(p)
+	return nfs4OK
+}
+
+// REMOVE - remove a file or an empty directory
+func (c *compound4) remove(r *xdrReader, w *xdrWriter) uint32 {
+	name := r.string(nfs4MaxOpaque)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	p, status := c.child(name)
+	if status != nfs4OK {
+		return status
+	}
+	if !c.s.writable() {
+		return nfs4errROFS
+	}
+	before := c.s.change(c.fh)
+	err := c.s.fs.Remove(p)
+	if err != nil {
+		return errStatus(err)
+	}
+	c.s.mu.Lock()
+	delete(c.s.exclusive, p)
+	c.s.mu.Unlock()
+	w.changeInfo(before, c.s.change(c.fh))
+	return nfs4OK
+}
+
+// RENAME - rename from the saved directory to the current directory
+func (c *compound4) rename(r *xdrReader, w *xdrWriter) uint32 {
+	oldName := r.string(nfs4MaxOpaque)
+	newName := r.string(nfs4MaxOpaque)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if !c.hasSaved {
+		return nfs4errNoFileHandle
+	}
+	dst, status := c.child(newName)
+	if status != nfs4OK {
+		return status
+	}
+	srcDir := c.savedFH
+	fi, err := c.s.fs.Stat(srcDir)
+	if err != nil {
+		return nfs4errStale
+	}
+	if !fi.IsDir() {
+		return nfs4errNotDir
+	}
+	if status = checkName(oldName); status != nfs4OK {
+		return status
+	}
+	src := path.Join(srcDir, oldName)
+	if !c.s.writable() {
+		return nfs4errROFS
+	}
+	srcInfo, err := c.s.fs.Stat(src)
+	if err != nil {
+		return errStatus(err)
+	}
+	srcBefore, dstBefore := c.s.change(srcDir), c.s.change(c.fh)
+	if src != dst {
+		if srcInfo.IsDir() && strings.HasPrefix(dst, src+"/") {
+			return nfs4errInval
+		}
+		if dstInfo, err := c.s.fs.Stat(dst); err == nil {
+			switch {
+			case srcInfo.IsDir() != dstInfo.IsDir():
+				return nfs4errExist
+			case dstInfo.IsDir():
+				// Replacing an empty directory
+				if err := c.s.fs.Remove(dst); err != nil {
+					return nfs4errExist
+				}
+			}
+		}
+		if err := c.s.fs.Rename(src, dst); err != nil {
+			return errStatus(err)
+		}
+		c.s.mu.Lock()
+		c.s.renameStates(src, dst)
+		if verifier, ok := c.s.exclusive[src]; ok {
+			delete(c.s.exclusive, src)
+			c.s.exclusive[dst] = verifier
+		}
+		c.s.mu.Unlock()
+	}
+	w.changeInfo(srcBefore, c.s.change(srcDir))
+	w.changeInfo(dstBefore, c.s.change(c.fh))
+	return nfs4OK
+}
+
+// READDIR - list the current directory
+//
+// Entry i has cookie i+3 as 0 means the start and 1 and 2 are
+// reserved.
+func (c *compound4) readDir(r *xdrReader, w *xdrWriter) uint32 {
+	cookie := r.uint64()
+	_ = r.fixed(8) // cookie verifier
+	_ = r.uint32() // dircount
+	maxCount := r.uint32()
+	req := r.bitmap()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := c.dir(); status != nfs4OK {
+		return status
+	}
+	start := uint64(0)
+	switch {
+	case cookie == 1 || cookie == 2:
+		return nfs4errBadCookie
+	case cookie > 2:
+		start = cookie - 2
+	}
+	entries, err := c.s.fs.ReadDir(c.fh)
+	if err != nil {
+		return errStatus(err)
+	}
+	if start > uint64(len(entries)) {
+		return nfs4errBadCookie
+	}
+	const overhead = 8 + 4 + 4 + 4 // cookie verifier, end of list, eof, status
+	var (
+		list  xdrWriter
+		n     int
+		entry xdrWriter
+		eof   = true
+	)
+	for i := start; i < uint64(len(entries)); i++ {
+		fi := entries[i]
+		entry.truncate(0)
+		entry.bool(true)
+		entry.uint64(i + 3)
+		entry.string(fi.Name())
+		c.s.encodeAttrs(&entry, req, path.Join(c.fh, fi.Name()), fi)
+		if overhead+list.len()+entry.len() > int(maxCount) {
+			eof = false
+			break
+		}
+		list.b = append(list.b, entry.b...)
+		n++
+	}
+	if n == 0 && !eof {
+		return nfs4errTooSmall
+	}
+	w.fixed(make([]byte, 8)) // cookie verifier
+	w.b = append(w.b, list.b...)
+	w.bool(false) // end of entries
+	w.bool(eof)
+	return nfs4OK
+}
+
+// SECINFO and SECINFO_NO_NAME reply
+func writeSecInfo(w *xdrWriter) {
+	w.uint32(2)
+	w.uint32(authSys)
+	w.uint32(authNone)
+}
+
+// SECINFO - return the security flavors for a name
+func (c *compound4) secInfo(r *xdrReader, w *xdrWriter) uint32 {
+	name := r.string(nfs4MaxOpaque)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	p, status := c.child(name)
+	if status != nfs4OK {
+		return status
+	}
+	if _, err := c.s.fs.Stat(p); err != nil {
+		return errStatus(err)
+	}
+	writeSecInfo(w)
+	c.hasFH = false
+	return nfs4OK
+}
+
+// SECINFO_NO_NAME - return the security flavors for the current file handle
+func (c *compound4) secInfoNoName(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.uint32() // style
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if !c.hasFH {
+		return nfs4errNoFileHandle
+	}
+	writeSecInfo(w)
+	c.hasFH = false
+	return nfs4OK
+}
+
+// OPEN - open or create a file
+func (c *compound4) open(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.uint32() // seqid - not used in NFSv4.1
+	shareAccess := r.uint32()
+	shareDeny := r.uint32()
+	clientID := r.uint64()
+	owner := string(r.opaque(nfs4MaxOwnerIDLen))
+	openType := r.uint32()
+	var (
+		createMode uint32
+		verifier   [8]byte
+		mask       bitmap4
+		vals       []byte
+	)
+	switch openType {
+	case open4NoCreate:
+	case open4Create:
+		createMode = r.uint32()
+		switch createMode {
+		case createUnchecked, createGuarded:
+			mask, vals = r.fattr()
+		case createExclusive:
+			copy(verifier[:], r.fixed(8))
+		case createExclusive1:
+			copy(verifier[:], r.fixed(8))
+			mask, vals = r.fattr()
+		default:
+			return nfs4errBadXDR
+		}
+	default:
+		return nfs4errBadXDR
+	}
+	var name string
+	switch claim := r.uint32(); claim {
+	case claimNull:
+		name = r.string(nfs4MaxOpaque)
+	case claimPrevious:
+		_ = r.uint32() // delegation type
+	case claimFH:
+	default:
+		// Delegations aren't supported
+		return nfs4errNotSupp
+	}
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	client := c.client()
+	access := shareAccess & shareAccessBoth
+	if access == 0 || shareDeny > shareDenyBoth {
+		return nfs4errInval
+	}
+	if clientID != client.id {
+		return nfs4errStaleClientID
+	}
+	a, status := decodeSetAttrs(mask, vals)
+	if status != nfs4OK {
+		return status
+	}
+	if createMode == createExclusive1 && len(mask.and(exclcreatAttrs).bits()) != len(mask.bits()) {
+		return nfs4errInval
+	}
+	if access&shareAccessWrite != 0 && !c.s.writable() {
+		return nfs4errROFS
+	}
+
+	// Work out which file is being opened
+	var (
+		p      string
+		dir    string
+		before uint64
+	)
+	if name != "" {
+		p, status = c.child(name)
+		if status != nfs4OK {
+			return status
+		}
+		dir = c.fh
+		before = c.s.change(dir)
+	} else {
+		if _, status = c.file(); status != nfs4OK {
+			return status
+		}
+		p = c.fh
+		openType = open4NoCreate
+	}
+
+	// Create the file if necessary
+	var (
+		created vfs.Handle
+		set     bitmap4
+	)
+	fi, err := c.s.fs.Stat(p)
+	switch {
+	case err == nil:
+		switch fileType(fi) {
+		case nf4Dir:
+			return nfs4errIsDir
+		case nf4Lnk:
+			return nfs4errSymlink
+		}
+		if openType == open4Create {
+			switch createMode {
+			case createGuarded:
+				return nfs4errExist
+			case createExclusive, createExclusive1:
+				c.s.mu.Lock()
+				existing, ok := c.s.exclusive[p]
+				c.s.mu.Unlock()
+				if !ok || existing != verifier {
+					return nfs4errExist
+				}
+				// Retransmission of the create which succeeded
+				set = a.mask
+				a = setAttrs4{}
+			case createUnchecked:
+				// Only the size is set on existing files
+				if a.mask.has(attrSize) {
+					a = setAttrs4{mask: newBitmap(attrSize), size: a.size}
+				} else {
+					a = setAttrs4{}
+				}
+			}
+		} else {
+			a = setAttrs4{}
+		}
+	case !errors.Is(err, vfs.ENOENT):
+		return errStatus(err)
+	case openType != open4Create:
+		return nfs4errNoEnt
+	case !c.s.writable():
+		return nfs4errROFS
+	default:
+		perm := os.FileMode(0666)
+		if a.mask.has(attrMode) {
+			perm = os.FileMode(a.mode)
+		}
+		created, err = c.s.vfs.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return errStatus(err)
+		}
+		if createMode == createExclusive || createMode == createExclusive1 {
+			c.s.mu.Lock()
+			c.s.exclusive[p] = verifier
+			c.s.mu.Unlock()
+		}
+	}
+
+	// Find or make the open state
+	s := c.s
+	s.mu.Lock()
+	st := s.findOpen(client, owner, p)
+	if s.shareConflict(p, access, shareDeny, st) {
+		s.mu.Unlock()
+		if created != nil {
+			_ = created.Close()
+		}
+		return nfs4errShareDenied
+	}
+	isNew := st == nil
+	if isNew {
+		st = &state4{
+			sid:    s.newStateid(),
+			client: client,
+			owner:  owner,
+			path:   p,
+		}
+	} else {
+		st.bump()
+	}
+	st.access |= access
+	st.deny |= shareDeny
+	if created != nil {
+		if st.handle == nil {
+			st.handle, st.writable = created, true
+		} else if err := created.Close(); err != nil {
+			fs.Debugf(p, "nfs4: failed to close created file: %v", err)
+		}
+	}
+	err = s.openHandle(st)
+	if err != nil {
+		s.mu.Unlock()
+		return errStatus(err)
+	}
+	if isNew {
+		s.states[st.sid.other] = st
+	}
+	sid := st.sid
+	s.mu.Unlock()
+
+	// Set the attributes
+	attrsSet, status := s.setAttrs(p, a, st)
+	if status != nfs4OK {
+		fs.Debugf(p, "nfs4: failed to set attributes on open: %d", status)
+	}
+	for _, bit := range attrsSet.bits() {
+		set.set(bit)
+	}
+
+	w.stateid(sid)
+	if name != "" {
+		w.changeInfo(before, s.change(dir))
+	} else {
+		w.changeInfo(0, 0)
+	}
+	w.uint32(open4ResultLocktypePosix)
+	w.bitmap(set)
+	w.uint32(openDelegateNone)
+	c.setFH(p)
+	c.cur = sid
+	return nfs4OK
+}
+
+// OPEN_DOWNGRADE - reduce the access of an open
+func (c *compound4) openDowngrade(r *xdrReader, w *xdrWriter) uint32 {
+	sid := r.stateid()
+	_ = r.uint32() // seqid
+	access := r.uint32() & shareAccessBoth
+	deny := r.uint32()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, status := s.findState(c.client(), c.stateidArg(sid))
+	if status != nfs4OK {
+		return status
+	}
+	if st.isLock() {
+		return nfs4errBadStateID
+	}
+	if access == 0 || access&^st.access != 0 || deny&^st.deny != 0 {
+		return nfs4errInval
+	}
+	st.access, st.deny = access, deny
+	st.bump()
+	w.stateid(st.sid)
+	c.cur = st.sid
+	return nfs4OK
+}
+
+// CLOSE - close an open file
+func (c *compound4) close(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.uint32() // seqid
+	sid := r.stateid()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, status := s.findState(c.client(), c.stateidArg(sid))
+	if status != nfs4OK {
+		return status
+	}
+	if st.isLock() {
+		return nfs4errBadStateID
+	}
+	for _, lock := range st.locks {
+		if s.holdsLocks(lock) {
+			return nfs4errLocksHeld
+		}
+	}
+	s.closeState(st)
+	w.stateid(stateidInvalid)
+	c.cur = stateidInvalid
+	return nfs4OK
+}
+
+// READ - read from a file
+func (c *compound4) read(r *xdrReader, w *xdrWriter) uint32 {
+	sid := r.stateid()
+	offset := r.uint64()
+	count := r.uint32()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	fi, status := c.file()
+	if status != nfs4OK {
+		return status
+	}
+	st, status := c.ioState(sid)
+	if status != nfs4OK {
+		return status
+	}
+	if offset > math.MaxInt64 {
+		return nfs4errInval
+	}
+	var handle vfs.Handle
+	if st != nil {
+		c.s.mu.Lock()
+		handle = st.handle
+		c.s.mu.Unlock()
+	}
+	if handle == nil {
+		var err error
+		handle, err = c.s.vfs.OpenFile(c.fh, os.O_RDONLY, 0)
+		if err != nil {
+			return errStatus(err)
+		}
+		defer func() { _ = handle.Close() }()
+	}
+	buf := make([]byte, min(count, nfs4MaxIO))
+	n, err := handle.ReadAt(buf, int64(offset))
+	eof := errors.Is(err, io.EOF)
+	if err != nil && !eof {
+		return errStatus(err)
+	}
+	if offset+uint64(n) >= uint64(fi.Size()) {
+		eof = true
+	}
+	w.bool(eof)
+	w.opaque(buf[:n])
+	return nfs4OK
+}
+
+// WRITE - write to a file
+func (c *compound4) write(r *xdrReader, w *xdrWriter) uint32 {
+	sid := r.stateid()
+	offset := r.uint64()
+	stable := r.uint32()
+	data := r.opaque(nfs4MaxIO)
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := c.file(); status != nfs4OK {
+		return status
+	}
+	if !c.s.writable() {
+		return nfs4errROFS
+	}
+	if offset > math.MaxInt64 {
+		return nfs4errInval
+	}
+	st, status := c.ioState(sid)
+	if status != nfs4OK {
+		return status
+	}
+	var handle vfs.Handle
+	if st != nil {
+		c.s.mu.Lock()
+		if st.access&shareAccessWrite == 0 || !st.writable {
+			c.s.mu.Unlock()
+			return nfs4errOpenMode
+		}
+		handle = st.handle
+		c.s.mu.Unlock()
+	} else {
+		var err error
+		handle, err = c.s.vfs.OpenFile(c.fh, os.O_RDWR, 0)
+		if err != nil {
+			return errStatus(err)
+		}
+		defer func() { _ = handle.Close() }()
+	}
+	n, err := handle.WriteAt(data, int64(offset))
+	if err != nil {
+		return errStatus(err)
+	}
+	committed := uint32(unstable4)
+	if stable != unstable4 {
+		if err := handle.Sync(); err != nil {
+			return errStatus(err)
+		}
+		committed = fileSync4
+	}
+	w.uint32(uint32(n))
+	w.uint32(committed)
+	w.fixed(c.s.verifier[:])
+	return nfs4OK
+}
+
+// COMMIT - flush writes to stable storage
+func (c *compound4) commit(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.uint64() // offset
+	_ = r.uint32() // count
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := c.file(); status != nfs4OK {
+		return status
+	}
+	var handles []vfs.Handle
+	c.s.mu.Lock()
+	for _, st := range c.s.states {
+		if !st.isLock() && st.path == c.fh && st.handle != nil && st.writable {
+			handles = append(handles, st.handle)
+		}
+	}
+	c.s.mu.Unlock()
+	for _, handle := range handles {
+		if err := handle.Sync(); err != nil && !errors.Is(err, vfs.ECLOSED) {
+			return errStatus(err)
+		}
+	}
+	w.fixed(c.s.verifier[:])
+	return nfs4OK
+}
+
+// lockOf returns the VFS lock for a lock request
+//
+// The VFS only supports 63 bit offsets so locks which extend beyond
+// that are treated as extending to the end of the file.
+func lockOf(owner string, lockType uint32, offset, length uint64) (vfs.Lock, uint32) {
+	l := vfs.Lock{Owner: owner, Type: vfs.LockRead, Start: int64(offset), End: vfs.LockEOF}
+	if lockType < readLT || lockType > writewLT {
+		return l, nfs4errInval
+	}
+	if lockType == writeLT || lockType == writewLT {
+		l.Type = vfs.LockWrite
+	}
+	switch {
+	case length == 0:
+		return l, nfs4errInval
+	case length != math.MaxUint64 && offset+length < offset:
+		return l, nfs4errInval
+	case offset >= math.MaxInt64:
+		return l, nfs4errBadRange
+	case length != math.MaxUint64 && offset+length < math.MaxInt64:
+		l.End = int64(offset + length)
+	}
+	return l, nfs4OK
+}
+
+// lockDenied writes a LOCK4denied for the conflicting lock
+//
+// call with mu held
+func (c *compound4) lockDenied(w *xdrWriter, conflict vfs.Lock) {
+	w.uint64(uint64(conflict.Start))
+	if conflict.End == vfs.LockEOF {
+		w.uint64(math.MaxUint64)
+	} else {
+		w.uint64(uint64(conflict.End - conflict.Start))
+	}
+	if conflict.Type == vfs.LockWrite {
+		w.uint32(writeLT)
+	} else {
+		w.uint32(readLT)
+	}
+	// Locks taken outside NFSv4 are reported with client ID 0
+	if holder := c.s.lockHolder(conflict); holder != nil {
+		w.uint64(holder.client.id)
+		w.opaque([]byte(holder.owner))
+	} else {
+		w.uint64(0)
+		w.opaque([]byte(conflict.Owner))
+	}
+}
+
+// takeLock takes the VFS lock l on p writing a LOCK4denied if there
+// is a conflict
+//
+// call with mu held
+func (c *compound4) takeLock(w *xdrWriter, p string, l vfs.Lock) uint32 {
+	err := c.s.vfs.Lock(p, l)
+	if err == nil {
+		return nfs4OK
+	}
+	if conflict, found := c.s.vfs.TestLock(p, l); found {
+		c.lockDenied(w, conflict)
+		return nfs4errDenied
+	}
+	return errStatus(err)
+}
+
+// LOCK - take a byte range lock
+//
+// Blocking locks aren't queued - the client will poll for them.
+func (c *compound4) lock(r *xdrReader, w *xdrWriter) uint32 {
+	lockType := r.uint32()
+	_ = r.bool() // reclaim
+	offset := r.uint64()
+	length := r.uint64()
+	newOwner := r.bool()
+	var (
+		openSid  stateid4
+		lockSid  stateid4
+		clientID uint64
+		owner    string
+	)
+	if newOwner {
+		_ = r.uint32() // open seqid
+		openSid = r.stateid()
+		_ = r.uint32() // lock seqid
+		clientID = r.uint64()
+		owner = string(r.opaque(nfs4MaxOwnerIDLen))
+	} else {
+		lockSid = r.stateid()
+		_ = r.uint32() // lock seqid
+	}
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := c.file(); status != nfs4OK {
+		return status
+	}
+	_, status := lockOf("", lockType, offset, length)
+	if status != nfs4OK {
+		return status
+	}
+	client := c.client()
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var st, open *state4
+	if newOwner {
+		if clientID != client.id {
+			return nfs4errStaleClientID
+		}
+		open, status = s.findState(client, c.stateidArg(openSid))
+		if status != nfs4OK {
+			return status
+		}
+		if open.isLock() {
+			return nfs4errBadStateID
+		}
+		for _, lock := range open.locks {
+			if lock.owner == owner {
+				st = lock
+			}
+		}
+	} else {
+		st, status = s.findState(client, c.stateidArg(lockSid))
+		if status != nfs4OK {
+			return status
+		}
+		if !st.isLock() {
+			return nfs4errBadStateID
+		}
+		open, owner = st.open, st.owner
+	}
+	l, _ := lockOf(lockOwner(client.id, owner), lockType, offset, length)
+	if l.Type == vfs.LockWrite && open.access&shareAccessWrite == 0 {
+		return nfs4errOpenMode
+	}
+	if status := c.takeLock(w, open.path, l); status != nfs4OK {
+		return status
+	}
+	if st == nil {
+		st = &state4{
+			sid:    s.newStateid(),
+			client: client,
+			owner:  owner,
+			path:   open.path,
+			open:   open,
+		}
+		s.states[st.sid.other] = st
+		open.locks = append(open.locks, st)
+	} else {
+		st.bump()
+	}
+	w.stateid(st.sid)
+	c.cur = st.sid
+	return nfs4OK
+}
+
+// LOCKT - test for a conflicting lock
+func (c *compound4) lockT(r *xdrReader, w *xdrWriter) uint32 {
+	lockType := r.uint32()
+	offset := r.uint64()
+	length := r.uint64()
+	_ = r.uint64() // client ID
+	owner := string(r.opaque(nfs4MaxOwnerIDLen))
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := c.file(); status != nfs4OK {
+		return status
+	}
+	l, status := lockOf(lockOwner(c.client().id, owner), lockType, offset, length)
+	if status != nfs4OK {
+		return status
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if conflict, found := c.s.vfs.TestLock(c.fh, l); found {
+		c.lockDenied(w, conflict)
+		return nfs4errDenied
+	}
+	return nfs4OK
+}
+
+// LOCKU - release a byte range lock
+func (c *compound4) lockU(r *xdrReader, w *xdrWriter) uint32 {
+	lockType := r.uint32()
+	_ = r.uint32() // seqid
+	sid := r.stateid()
+	offset := r.uint64()
+	length := r.uint64()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	if _, status := lockOf("", lockType, offset, length); status != nfs4OK {
+		return status
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	st, status := c.s.findState(c.client(), c.stateidArg(sid))
+	if status != nfs4OK {
+		return status
+	}
+	if !st.isLock() {
+		return nfs4errBadStateID
+	}
+	l, _ := lockOf(st.lockOwner(), lockType, offset, length)
+	c.s.vfs.Unlock(st.path, l)
+	st.bump()
+	w.stateid(st.sid)
+	c.cur = st.sid
+	return nfs4OK
+}
+
+// FREE_STATEID - free a lock state with no locks
+func (c *compound4) freeStateID(r *xdrReader, w *xdrWriter) uint32 {
+	sid := r.stateid()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	st, status := c.s.findState(c.client(), c.stateidArg(sid))
+	if status != nfs4OK {
+		return status
+	}
+	if !st.isLock() || c.s.holdsLocks(st) {
+		return nfs4errLocksHeld
+	}
+	c.s.closeState(st)
+	return nfs4OK
+}
+
+// TEST_STATEID - check whether stateids are valid
+func (c *compound4) testStateID(r *xdrReader, w *xdrWriter) uint32 {
+	n := r.uint32()
+	if n > nfs4MaxOps*16 {
+		return nfs4errBadXDR
+	}
+	sids := make([]stateid4, n)
+	for i := range sids {
+		sids[i] = r.stateid()
+	}
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	w.uint32(n)
+	for _, sid := range sids {
+		_, status := c.s.findState(c.client(), sid)
+		w.uint32(status)
+	}
+	return nfs4OK
+}
+
+// DELEGRETURN - return a delegation
+//
+// No delegations are handed out so the stateid can't be valid.
+func (c *compound4) delegReturn(r *xdrReader, w *xdrWriter) uint32 {
+	_ = r.stateid()
+	if r.err != nil {
+		return nfs4errBadXDR
+	}
+	return nfs4errBadStateID
+}
@@ -0,0 +1,299 @@
+//go:build unix
+
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// NFSv4.1 server state - clients, sessions, opens and locks
+//
+// All of these are protected by server4.mu
+
+// leaseTime is the lease period clients must renew within
+const leaseTime = 90 * time.Second
+
+// stateid4 identifies an open or a lock
+type stateid4 struct {
+	seqid uint32
+	other [12]byte
+}
+
+func (r *xdrReader) stateid() (sid stateid4) {
+	sid.seqid = r.uint32()
+	copy(sid.other[:], r.fixed(12))
+	return sid
+}
+
+func (w *xdrWriter) stateid(sid stateid4) {
+	w.uint32(sid.seqid)
+	w.fixed(sid.other[:])
+}
+
+// sessionID4 identifies a session
+type sessionID4 [16]byte
+
+// client4 is a client as set up by EXCHANGE_ID
+type client4 struct {
+	id        uint64
+	ownerID   string
+	verifier  [8]byte
+	confirmed bool
+	seq       uint32                   // next CREATE_SESSION sequence
+	csReply   []byte                   // cached reply to last CREATE_SESSION
+	renewed   time.Time                // last time the lease was renewed
+	sessions  map[sessionID4]*session4 // sessions belonging to this client
+}
+
+// slot4 is a session slot used for exactly once semantics
+type slot4 struct {
+	seq   uint32 // sequence ID of last request
+	busy  bool   // set if a request is in progress
+	reply []byte // cached reply if requested
+}
+
+// session4 is a session as set up by CREATE_SESSION
+type session4 struct {
+	id     sessionID4
+	client *client4
+	slots  []slot4
+}
+
+// state4 is an open or a lock state
+type state4 struct {
+	sid    stateid4
+	client *client4
+	owner  string // open owner or lock owner
+	path   string // path of the file
+
+	// For open states
+	access   uint32     // share access
+	deny     uint32     // share deny
+	handle   vfs.Handle // open file
+	writable bool       // set if handle is open for writing
+	locks    []*state4  // lock states made with this
+
+	// For lock states - the locks themselves are held in the VFS
+	open *state4 // open state this lock was made with
+}
+
+// isLock returns true for lock states
+func (st *state4) isLock() bool {
+	return st.open != nil
+}
+
+// bump increments the seqid of the state
+func (st *state4) bump() {
+	st.sid.seqid++
+	if st.sid.seqid == 0 {
+		st.sid.seqid = 1
+	}
+}
+
+// newStateid makes a new unique stateid
+//
+// call with mu held
+func (s *server4) newStateid() stateid4 {
+	s.nextID++
+	sid := stateid4{seqid: 1}
+	be.PutUint32(sid.other[:4], s.bootID)
+	be.PutUint64(sid.other[4:], s.nextID)
+	return sid
+}
+
+// findState looks up the state for sid checking it belongs to client
+//
+// call with mu held
+func (s *server4) findState(client *client4, sid stateid4) (*state4, uint32) {
+	st, ok := s.states[sid.other]
+	if !ok || st.client != client {
+		return nil, nfs4errBadStateID
+	}
+	if sid.seqid != 0 {
+		if sid.seqid > st.sid.seqid {
+			return nil, nfs4errBadStateID
+		}
+		if sid.seqid < st.sid.seqid {
+			return nil, nfs4errOldStateID
+		}
+	}
+	return st, nfs4OK
+}
+
+// findOpen looks for an open of p by owner
+//
+// call with mu held
+func (s *server4) findOpen(client *client4, owner, p string) *state4 {
+	for _, st := range s.states {
+		if st.client == client && !st.isLock() && st.owner == owner && st.path == p {
+			return st
+		}
+	}
+	return nil
+}
+
+// shareConflict returns true if opening p with access and deny
+// conflicts with an existing open not belonging to except
+//
+// call with mu held
+func (s *server4) shareConflict(p string, access, deny uint32, except *state4) bool {
+	for _, st := range s.states {
+		if st == except || st.isLock() || st.path != p {
+			continue
+		}
+		if access&st.deny != 0 || deny&st.access != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lockOwner returns the VFS lock owner for an NFSv4 lock owner
+func lockOwner(clientID uint64, owner string) string {
+	return fmt.Sprintf("nfs4:%x:%x", clientID, owner)
+}
+
+// lockOwner returns the VFS lock owner of a lock state
+func (st *state4) lockOwner() string {
+	return lockOwner(st.client.id, st.owner)
+}
+
+// holdsLocks returns true if the lock state holds any locks
+func (s *server4) holdsLocks(st *state4) bool {
+	owner := st.lockOwner()
+	for _, l := range s.vfs.Locks(st.path) {
+		if l.Owner == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// lockHolder returns the lock state which holds the VFS lock l or nil
+// if it is held by something other than NFSv4
+//
+// call with mu held
+func (s *server4) lockHolder(l vfs.Lock) *state4 {
+	for _, st := range s.states {
+		if st.isLock() && st.lockOwner() == l.Owner {
+			return st
+		}
+	}
+	return nil
+}
+
+// openHandle opens or reopens the VFS handle of an open state so it
+// allows the share access of the state
+func (s *server4) openHandle(st *state4) error {
+	write := st.access&shareAccessWrite != 0 && s.writable()
+	if st.handle != nil {
+		if !write || st.writable {
+			return nil
+		}
+		// Handle needs to be writable so reopen it
+		if err := st.handle.Close(); err != nil {
+			fs.Debugf(st.path, "nfs4: failed to close handle for reopen: %v", err)
+		}
+		st.handle = nil
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_RDWR
+	}
+	handle, err := s.vfs.OpenFile(st.path, flags, 0)
+	if err != nil {
+		return err
+	}
+	st.handle = handle
+	st.writable = write
+	return nil
+}
+
+// closeState removes the state and any locks made with it, closing
+// the open file
+//
+// call with mu held
+func (s *server4) closeState(st *state4) {
+	delete(s.states, st.sid.other)
+	for _, lock := range st.locks {
+		delete(s.states, lock.sid.other)
+		s.vfs.UnlockAll(lock.path, lock.lockOwner())
+	}
+	if st.isLock() {
+		s.vfs.UnlockAll(st.path, st.lockOwner())
+		st.open.locks = slices.DeleteFunc(st.open.locks, func(lock *state4) bool { return lock == st })
+		return
+	}
+	if st.handle != nil {
+		if err := st.handle.Close(); err != nil {
+			fs.Errorf(st.path, "nfs4: failed to close file: %v", err)
+		}
+		st.handle = nil
+	}
+	for _, other := range s.states {
+		if !other.isLock() && other.path == st.path {
+			return
+		}
+	}
+	s.handles.forget(st.path)
+}
+
+// renameStates updates the paths of states when oldPath is renamed
+// to newPath
+//
+// call with mu held
+func (s *server4) renameStates(oldPath, newPath string) {
+	for _, st := range s.states {
+		var p string
+		switch {
+		case st.path == oldPath:
+			p = newPath
+		case strings.HasPrefix(st.path, oldPath+"/"):
+			p = path.Join(newPath, st.path[len(oldPath)+1:])
+		default:
+			continue
+		}
+		if !st.isLock() {
+			s.handles.rename(st.path, p)
+		}
+		st.path = p
+	}
+}
+
+// destroyClient removes the client and all its state
+//
+// call with mu held
+func (s *server4) destroyClient(c *client4) {
+	for _, st := range s.states {
+		if st.client == c && !st.isLock() {
+			s.closeState(st)
+		}
+	}
+	for id := range c.sessions {
+		delete(s.sessions, id)
+	}
+	delete(s.clients, c.id)
+	if s.owners[c.ownerID] == c {
+		delete(s.owners, c.ownerID)
+	}
+}
+
+// expireClients removes clients which haven't renewed their leases
+//
+// call with mu held
+func (s *server4) expireClients(now time.Time) {
+	for _, c := range s.clients {
+		if now.Sub(c.renewed) > 2*leaseTime {
+			fs.Debugf(nil, "nfs4: expiring client %x", c.id)
+			s.destroyClient(c)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+//go:build unix
+
+package nfs
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start an NFS server serving dir returning its address
+func start4(t *testing.T, dir, handleDir string) string {
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	VFS := vfs.New(f, &vfsOpt)
+	opt := Opt
+	opt.ListenAddr = "127.0.0.1:0"
+	opt.HandleCacheDir = handleDir
+	s, err := NewServer(context.Background(), VFS, &opt)
+	require.NoError(t, err)
+	go func() { _ = s.Serve() }()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+		VFS.Shutdown()
+	})
+	return s.Addr().String()
+}
+
+// testClient4 is a minimal NFSv4.1 client for testing
+type testClient4 struct {
+	t        *testing.T
+	conn     net.Conn
+	xid      uint32
+	clientID uint64
+	session  sessionID4
+	seq      uint32
+}
+
+// dial4 connects to the server at addr
+func dial4(t *testing.T, addr string) *testClient4 {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testClient4{t: t, conn: conn}
+}
+
+// rpc makes an RPC call returning a reader for the results
+func (c *testClient4) rpc(prog, vers, proc uint32, args []byte) *xdrReader {
+	c.xid++
+	w := &xdrWriter{}
+	w.uint32(c.xid)
+	w.uint32(rpcCall)
+	w.uint32(rpcVersion)
+	w.uint32(prog)
+	w.uint32(vers)
+	w.uint32(proc)
+	w.uint32(authNone)
+	w.opaque(nil)
+	w.uint32(authNone)
+	w.opaque(nil)
+	w.b = append(w.b, args...)
+	require.NoError(c.t, writeRecord(c.conn, w.b))
+	reply, err := readRecord(c.conn)
+	require.NoError(c.t, err)
+	r := &xdrReader{b: reply}
+	assert.Equal(c.t, c.xid, r.uint32(), "xid")
+	assert.Equal(c.t, uint32(rpcReply), r.uint32(), "reply")
+	require.Equal(c.t, uint32(msgAccepted), r.uint32(), "accepted")
+	_ = r.uint32()
+	_ = r.opaque(400)
+	require.Equal(c.t, uint32(acceptSuccess), r.uint32(), "accept status")
+	return r
+}
+
+// compound sends the ops written by fn, prefixed with a SEQUENCE if
+// there is a session, returning the overall status and a reader
+// positioned at the first result after the SEQUENCE
+func (c *testClient4) compound(n uint32, fn func(w *xdrWriter)) (uint32, *xdrReader) {
+	w := &xdrWriter{}
+	w.string("test")
+	w.uint32(nfsMinorVers)
+	inSession := c.clientID != 0 && c.session != sessionID4{}
+	if inSession {
+		c.seq++
+		w.uint32(n + 1)
+		w.uint32(opSequence)
+		w.fixed(c.session[:])
+		w.uint32(c.seq)
+		w.uint32(0) // slot
+		w.uint32(0) // highest slot
+		w.bool(true)
+	} else {
+		w.uint32(n)
+	}
+	fn(w)
+	r := c.rpc(nfsProgram, nfsVersion4, procCompound, w.b)
+	status := r.uint32()
+	_ = r.string(100)
+	_ = r.uint32()
+	if inSession {
+		require.Equal(c.t, uint32(opSequence), r.uint32())
+		if r.uint32() != nfs4OK {
+			return status, r
+		}
+		_ = r.fixed(16 + 5*4)
+	}
+	return status, r
+}
+
+// result reads the header of an op result checking the status
+func (c *testClient4) result(r *xdrReader, op, status uint32) {
+	require.Equal(c.t, op, r.uint32(), "op")
+	require.Equal(c.t, status, r.uint32(), "status of op %d", op)
+}
+
+// connect makes a client and session
+func (c *testClient4) connect() {
+	_, r := c.compound(1, func(w *xdrWriter) {
+		w.uint32(opExchangeID)
+		w.fixed([]byte("verifier"))
+		w.string("test client " + c.conn.LocalAddr().String())
+		w.uint32(0)
+		w.uint32(sp4None)
+		w.uint32(0)
+	})
+	c.result(r, opExchangeID, nfs4OK)
+	c.clientID = r.uint64()
+	seq := r.uint32()
+
+	attrs := channelAttrs4{maxRequestSize: 1 << 20, maxResponseSize: 1 << 20, maxResponseSizeCached: 1 << 16, maxOperations: 16, maxRequests: 8}
+	_, r = c.compound(1, func(w *xdrWriter) {
+		w.uint32(opCreateSession)
+		w.uint64(c.clientID)
+		w.uint32(seq)
+		w.uint32(0)
+		w.channelAttrs(attrs)
+		w.channelAttrs(attrs)
+		w.uint32(0x40000000)
+		w.uint32(1)
+		w.uint32(authNone)
+	})
+	c.result(r, opCreateSession, nfs4OK)
+	copy(c.session[:], r.fixed(16))
+	assert.Equal(c.t, seq, r.uint32())
+	_ = r.uint32()
+	fore := r.channelAttrs()
+	assert.Equal(c.t, uint32(8), fore.maxRequests)
+	require.NoError(c.t, r.err)
+}
+
+// putPath writes ops to set the current file handle to p
+func putPath(w *xdrWriter, p string) (n uint32) {
+	w.uint32(opPutRootFH)
+	n++
+	if p == "" {
+		return n
+	}
+	for _, name := range strings.Split(p, "/") {
+		w.uint32(opLookup)
+		w.string(name)
+		n++
+	}
+	return n
+}
+
+// skip reads the results of n ops which return nothing
+func (c *testClient4) skip(r *xdrReader, n uint32) {
+	for range n {
+		_ = r.uint32()
+		require.Equal(c.t, uint32(nfs4OK), r.uint32())
+	}
+}
+
+// getFH returns the file handle for p
+func (c *testClient4) getFH(p string) []byte {
+	n := putPath(&xdrWriter{}, p)
+	status, r := c.compound(n+1, func(w *xdrWriter) {
+		putPath(w, p)
+		w.uint32(opGetFH)
+	})
+	require.Equal(c.t, uint32(nfs4OK), status)
+	c.skip(r, n)
+	c.result(r, opGetFH, nfs4OK)
+	return r.opaque(nfs4FHSize)
+}
+
+// getattrs reads the type and size of the file with handle fh
+func (c *testClient4) getattrs(fh []byte) (status uint32, fileType uint32, size uint64) {
+	status, r := c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutFH)
+		w.opaque(fh)
+		w.uint32(opGetattr)
+		w.bitmap(newBitmap(attrType, attrSize))
+	})
+	if status != nfs4OK {
+		return status, 0, 0
+	}
+	c.skip(r, 1)
+	c.result(r, opGetattr, nfs4OK)
+	mask, vals := r.fattr()
+	assert.Equal(c.t, newBitmap(attrType, attrSize), mask)
+	vr := &xdrReader{b: vals}
+	return status, vr.uint32(), vr.uint64()
+}
+
+// writeOpen writes an OPEN of name in the current directory
+func writeOpen(w *xdrWriter, clientID uint64, owner string, access uint32, create bool, name string) {
+	w.uint32(opOpen)
+	w.uint32(0)
+	w.uint32(access)
+	w.uint32(0)
+	w.uint64(clientID)
+	w.string(owner)
+	if create {
+		w.uint32(open4Create)
+		w.uint32(createUnchecked)
+		vals := &xdrWriter{}
+		vals.uint32(0644)
+		w.bitmap(newBitmap(attrMode))
+		w.opaque(vals.b)
+	} else {
+		w.uint32(open4NoCreate)
+	}
+	w.uint32(claimNull)
+	w.string(name)
+}
+
+// open opens name in the root directory returning the stateid
+func (c *testClient4) open(owner string, access uint32, create bool, name string) stateid4 {
+	status, r := c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		writeOpen(w, c.clientID, owner, access, create, name)
+	})
+	require.Equal(c.t, uint32(nfs4OK), status)
+	c.skip(r, 1)
+	c.result(r, opOpen, nfs4OK)
+	return r.stateid()
+}
+
+// lock takes a write lock on the file at fh
+func (c *testClient4) lock(fh []byte, open stateid4, owner string, offset, length uint64) (uint32, stateid4) {
+	status, r := c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutFH)
+		w.opaque(fh)
+		w.uint32(opLock)
+		w.uint32(writeLT)
+		w.bool(false)
+		w.uint64(offset)
+		w.uint64(length)
+		w.bool(true)
+		w.uint32(0)
+		w.stateid(open)
+		w.uint32(0)
+		w.uint64(c.clientID)
+		w.string(owner)
+	})
+	if status != nfs4OK {
+		return status, stateid4{}
+	}
+	c.skip(r, 1)
+	c.result(r, opLock, nfs4OK)
+	return status, r.stateid()
+}
+
+func TestNFS4(t *testing.T) {
+	fstest.Initialise()
+	dir := t.TempDir()
+	handleDir := t.TempDir()
+	addr := start4(t, dir, handleDir)
+	c := dial4(t, addr)
+
+	// NULL procedure
+	c.rpc(nfsProgram, nfsVersion4, procNull, nil)
+
+	// Operations need a session
+	status, _ := c.compound(1, func(w *xdrWriter) { w.uint32(opPutRootFH) })
+	assert.Equal(t, uint32(nfs4errOpNotInSession), status)
+	c.connect()
+
+	// Root is a directory
+	rootFH := c.getFH("")
+	status, fileType, _ := c.getattrs(rootFH)
+	require.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, uint32(nf4Dir), fileType)
+
+	// Create and write a file
+	data := []byte("hello world")
+	sid := c.open("owner1", shareAccessBoth, true, "file.txt")
+	fh := c.getFH("file.txt")
+	status, r := c.compound(3, func(w *xdrWriter) {
+		w.uint32(opPutFH)
+		w.opaque(fh)
+		w.uint32(opWrite)
+		w.stateid(sid)
+		w.uint64(0)
+		w.uint32(fileSync4)
+		w.opaque(data)
+		w.uint32(opRead)
+		w.stateid(sid)
+		w.uint64(0)
+		w.uint32(1000)
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+	c.skip(r, 1)
+	c.result(r, opWrite, nfs4OK)
+	assert.Equal(t, uint32(len(data)), r.uint32())
+	assert.Equal(t, uint32(fileSync4), r.uint32())
+	_ = r.fixed(8)
+	c.result(r, opRead, nfs4OK)
+	assert.True(t, r.bool(), "eof")
+	assert.Equal(t, data, r.opaque(nfs4MaxIO))
+
+	// Byte range locks
+	status, lockSid := c.lock(fh, sid, "lock1", 0, 5)
+	require.Equal(t, uint32(nfs4OK), status)
+	sid2 := c.open("owner2", shareAccessBoth, false, "file.txt")
+	status, _ = c.lock(fh, sid2, "lock2", 2, 1)
+	assert.Equal(t, uint32(nfs4errDenied), status)
+	status, _ = c.lock(fh, sid2, "lock2", 5, 10)
+	assert.Equal(t, uint32(nfs4OK), status)
+	status, _ = c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutFH)
+		w.opaque(fh)
+		w.uint32(opClose)
+		w.uint32(0)
+		w.stateid(sid)
+	})
+	assert.Equal(t, uint32(nfs4errLocksHeld), status)
+	status, r = c.compound(3, func(w *xdrWriter) {
+		w.uint32(opPutFH)
+		w.opaque(fh)
+		w.uint32(opLockU)
+		w.uint32(writeLT)
+		w.uint32(0)
+		w.stateid(lockSid)
+		w.uint64(0)
+		w.uint64(5)
+		w.uint32(opClose)
+		w.uint32(0)
+		w.stateid(sid)
+	})
+	assert.Equal(t, uint32(nfs4OK), status)
+	status, _ = c.lock(fh, sid2, "lock2", 2, 1)
+	assert.Equal(t, uint32(nfs4OK), status)
+
+	// Directories, rename and remove
+	status, _ = c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		w.uint32(opCreate)
+		w.uint32(nf4Dir)
+		w.string("dir")
+		w.bitmap(nil)
+		w.opaque(nil)
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+	status, r = c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		w.uint32(opReadDir)
+		w.uint64(0)
+		w.fixed(make([]byte, 8))
+		w.uint32(4096)
+		w.uint32(4096)
+		w.bitmap(newBitmap(attrType))
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+	c.skip(r, 1)
+	c.result(r, opReadDir, nfs4OK)
+	_ = r.fixed(8)
+	var names []string
+	for r.bool() {
+		_ = r.uint64()
+		names = append(names, r.string(nfs4MaxNameLen))
+		_, _ = r.fattr()
+	}
+	assert.True(t, r.bool(), "eof")
+	assert.Equal(t, []string{"dir", "file.txt"}, names)
+
+	status, _ = c.compound(5, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		w.uint32(opSaveFH)
+		w.uint32(opPutRootFH)
+		w.uint32(opLookup)
+		w.string("dir")
+		w.uint32(opRename)
+		w.string("file.txt")
+		w.string("moved.txt")
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+
+	// The open file can still be used through its old handle
+	status, fileType, size := c.getattrs(fh)
+	require.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, uint32(nf4Reg), fileType)
+	assert.Equal(t, uint64(len(data)), size)
+	movedFH := c.getFH("dir/moved.txt")
+
+	// A long path which needs the handle cache
+	longDir := strings.Repeat("d", 100) + "/" + strings.Repeat("e", 100)
+	status, _ = c.compound(3, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		for _, name := range strings.Split(longDir, "/") {
+			w.uint32(opCreate)
+			w.uint32(nf4Dir)
+			w.string(name)
+			w.bitmap(nil)
+			w.opaque(nil)
+		}
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+	longFH := c.getFH(longDir)
+	assert.LessOrEqual(t, len(longFH), nfs4FHSize)
+
+	status, _ = c.compound(2, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		w.uint32(opRemove)
+		w.string("dir")
+	})
+	assert.Equal(t, uint32(nfs4errNotEmpty), status)
+
+	// NFSv3 and MOUNT are served on the same port
+	c3 := dial4(t, addr)
+	c3.rpc(nfsProgram, 3, procNull, nil)
+	cm := dial4(t, addr)
+	cm.rpc(100005, 3, procNull, nil)
+
+	// Handles survive a restart of the server
+	addr = start4(t, dir, handleDir)
+	c = dial4(t, addr)
+	status, _ = c.compound(1, func(w *xdrWriter) { w.uint32(opPutRootFH) })
+	assert.Equal(t, uint32(nfs4errOpNotInSession), status)
+	c.connect()
+	status, fileType, size = c.getattrs(movedFH)
+	require.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, uint32(nf4Reg), fileType)
+	assert.Equal(t, uint64(len(data)), size)
+	status, fileType, _ = c.getattrs(longFH)
+	require.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, uint32(nf4Dir), fileType)
+
+	// Handles of removed files are stale
+	status, _ = c.compound(3, func(w *xdrWriter) {
+		w.uint32(opPutRootFH)
+		w.uint32(opLookup)
+		w.string("dir")
+		w.uint32(opRemove)
+		w.string("moved.txt")
+	})
+	require.Equal(t, uint32(nfs4OK), status)
+	status, _, _ = c.getattrs(movedFH)
+	assert.Equal(t, uint32(nfs4errStale), status)
+}
+
+func TestNFS4Replay(t *testing.T) {
+	fstest.Initialise()
+	addr := start4(t, t.TempDir(), t.TempDir())
+	c := dial4(t, addr)
+	c.connect()
+	sid := c.open("owner", shareAccessBoth, true, "file.txt")
+	assert.NotEqual(t, stateid4{}, sid)
+
+	// Resending the request on the same slot gives the cached reply
+	c.seq--
+	sid2 := c.open("owner", shareAccessBoth, true, "file.txt")
+	assert.Equal(t, sid, sid2)
+
+	// Skipping a sequence number is an error
+	c.seq++
+	status, _ := c.compound(0, func(w *xdrWriter) {})
+	assert.Equal(t, uint32(nfs4errSeqMisordered), status)
+}
+
+func TestNFS4BadSession(t *testing.T) {
+	fstest.Initialise()
+	addr := start4(t, t.TempDir(), t.TempDir())
+	c := dial4(t, addr)
+	c.connect()
+	c.session[0] ^= 0xFF
+	w := &xdrWriter{}
+	w.string("")
+	w.uint32(nfsMinorVers)
+	w.uint32(1)
+	w.uint32(opSequence)
+	w.fixed(c.session[:])
+	w.uint32(1)
+	w.uint32(0)
+	w.uint32(0)
+	w.bool(false)
+	r := c.rpc(nfsProgram, nfsVersion4, procCompound, w.b)
+	assert.Equal(t, uint32(nfs4errBadSession), r.uint32())
+}
+
+func TestLockOf(t *testing.T) {
+	_, status := lockOf("owner", writeLT, 10, 0)
+	assert.Equal(t, uint32(nfs4errInval), status)
+	_, status = lockOf("owner", 7, 10, 1)
+	assert.Equal(t, uint32(nfs4errInval), status)
+	_, status = lockOf("owner", readLT, 1<<63, 1)
+	assert.Equal(t, uint32(nfs4errBadRange), status)
+	l, status := lockOf("owner", readLT, 10, 1<<64-1)
+	assert.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, vfs.Lock{Owner: "owner", Type: vfs.LockRead, Start: 10, End: vfs.LockEOF}, l)
+	l, status = lockOf("owner", writewLT, 10, 5)
+	assert.Equal(t, uint32(nfs4OK), status)
+	assert.Equal(t, vfs.Lock{Owner: "owner", Type: vfs.LockWrite, Start: 10, End: 15}, l)
+}
+
+func TestCheckName(t *testing.T) {
+	assert.Equal(t, uint32(nfs4OK), checkName("file.txt"))
+	assert.Equal(t, uint32(nfs4errInval), checkName(""))
+	assert.Equal(t, uint32(nfs4errBadName), checkName(".."))
+	assert.Equal(t, uint32(nfs4errBadName), checkName("a/b"))
+	assert.Equal(t, uint32(nfs4errNameTooLong), checkName(strings.Repeat("a", 256)))
+}
@@ -0,0 +1,252 @@
+//go:build unix
+
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// XDR (RFC 4506) encoding and decoding and ONC RPC record marking
+// (RFC 5531) for the NFSv4 server
+
+var be = binary.BigEndian
+
+// errBadXDR is returned when a message can't be decoded
+var errBadXDR = errors.New("nfs4: badly formed XDR")
+
+// maxRecordSize is the largest RPC record we will read
+const maxRecordSize = 4 * 1024 * 1024
+
+// xdrReader decodes XDR from a byte slice
+//
+// The first error is remembered and all subsequent reads return zero
+// values so callers only need to check err once at the end.
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes or nil if there aren't enough
+func (r *xdrReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = errBadXDR
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *xdrReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return be.Uint32(b)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return be.Uint64(b)
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+// fixed reads n bytes of fixed length opaque data
+func (r *xdrReader) fixed(n int) []byte {
+	b := r.next(pad4(n))
+	if b == nil {
+		return nil
+	}
+	return b[:n]
+}
+
+// opaque reads variable length opaque data of at most max bytes
+func (r *xdrReader) opaque(max int) []byte {
+	n := r.uint32()
+	if r.err == nil && int64(n) > int64(max) {
+		r.err = errBadXDR
+	}
+	return r.fixed(int(n))
+}
+
+func (r *xdrReader) string(max int) string {
+	return string(r.opaque(max))
+}
+
+// bitmap reads a bitmap4
+func (r *xdrReader) bitmap() bitmap4 {
+	n := r.uint32()
+	if r.err == nil && n > 8 {
+		r.err = errBadXDR
+		return nil
+	}
+	bm := make(bitmap4, n)
+	for i := range bm {
+		bm[i] = r.uint32()
+	}
+	return bm
+}
+
+// xdrWriter encodes XDR into a byte slice
+type xdrWriter struct {
+	b []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.b = be.AppendUint32(w.b, v)
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	w.b = be.AppendUint64(w.b, v)
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+// fixed writes fixed length opaque data
+func (w *xdrWriter) fixed(b []byte) {
+	w.b = append(w.b, b...)
+	for range pad4(len(b)) - len(b) {
+		w.b = append(w.b, 0)
+	}
+}
+
+// opaque writes variable length opaque data
+func (w *xdrWriter) opaque(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.fixed(b)
+}
+
+func (w *xdrWriter) string(s string) {
+	w.opaque([]byte(s))
+}
+
+// bitmap writes a bitmap4 without trailing zero words
+func (w *xdrWriter) bitmap(bm bitmap4) {
+	n := len(bm)
+	for n > 0 && bm[n-1] == 0 {
+		n--
+	}
+	w.uint32(uint32(n))
+	for _, v := range bm[:n] {
+		w.uint32(v)
+	}
+}
+
+// len returns the number of bytes written so far
+func (w *xdrWriter) len() int {
+	return len(w.b)
+}
+
+// truncate discards everything written after n bytes
+func (w *xdrWriter) truncate(n int) {
+	w.b = w.b[:n]
+}
+
+// putUint32 overwrites the uint32 at offset
+func (w *xdrWriter) putUint32(offset int, v uint32) {
+	be.PutUint32(w.b[offset:], v)
+}
+
+// pad4 rounds n up to a multiple of 4
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// bitmap4 is a set of attribute numbers
+type bitmap4 []uint32
+
+// has returns true if bit is set
+func (bm bitmap4) has(bit int) bool {
+	return bit/32 < len(bm) && bm[bit/32]&(1<<(bit%32)) != 0
+}
+
+// set sets bit, growing the bitmap if necessary
+func (bm *bitmap4) set(bit int) {
+	for bit/32 >= len(*bm) {
+		*bm = append(*bm, 0)
+	}
+	(*bm)[bit/32] |= 1 << (bit % 32)
+}
+
+// and returns the bits set in both bm and other
+func (bm bitmap4) and(other bitmap4) bitmap4 {
+	out := make(bitmap4, min(len(bm), len(other)))
+	for i := range out {
+		out[i] = bm[i] & other[i]
+	}
+	return out
+}
+
+// bits returns the set bits in ascending order
+func (bm bitmap4) bits() (bits []int) {
+	for i, v := range bm {
+		for j := range 32 {
+			if v&(1<<j) != 0 {
+				bits = append(bits, i*32+j)
+			}
+		}
+	}
+	return bits
+}
+
+// newBitmap makes a bitmap with the bits passed in set
+func newBitmap(bits ...int) (bm bitmap4) {
+	for _, bit := range bits {
+		bm.set(bit)
+	}
+	return bm
+}
+
+// readRecord reads a complete RPC record, joining the fragments
+func readRecord(r io.Reader) ([]byte, error) {
+	var (
+		record []byte
+		header [4]byte
+	)
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err != nil {
+			return nil, err
+		}
+		h := be.Uint32(header[:])
+		n := int(h & 0x7FFFFFFF)
+		if len(record)+n > maxRecordSize {
+			return nil, fmt.Errorf("nfs4: RPC record too big (%d bytes)", len(record)+n)
+		}
+		start := len(record)
+		record = append(record, make([]byte, n)...)
+		_, err = io.ReadFull(r, record[start:])
+		if err != nil {
+			return nil, err
+		}
+		if h&0x80000000 != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes b as a single fragment RPC record
+func writeRecord(w io.Writer, b []byte) error {
+	buf := make([]byte, 4, 4+len(b))
+	be.PutUint32(buf, 0x80000000|uint32(len(b)))
+	_, err := w.Write(append(buf, b...))
+	return err
+}
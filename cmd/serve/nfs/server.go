@@ -3,9 +3,13 @@
 package nfs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
 
 	nfs "github.com/willscott/go-nfs"
 
@@ -20,6 +24,8 @@ type Server struct {
 	handler             nfs.Handler
 	ctx                 context.Context // for global config
 	listener            net.Listener
+	demux               *demuxListener
+	v4                  *server4
 	UnmountedExternally bool
 }
 
@@ -45,6 +51,8 @@ func NewServer(ctx context.Context, vfs *vfs.VFS, opt *Options) (s *Server, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to open listening socket: %w", err)
 	}
+	s.v4 = newServer4(s.handler.(*Handler), s.listener.Addr().String())
+	s.demux = newDemuxListener(s.listener, s.v4)
 	return s, nil
 }
 
@@ -55,11 +63,114 @@ func (s *Server) Addr() net.Addr {
 
 // Shutdown stops the server
 func (s *Server) Shutdown() error {
-	return s.listener.Close()
+	err := s.demux.Close()
+	s.v4.close()
+	return err
 }
 
 // Serve starts the server
 func (s *Server) Serve() (err error) {
 	fs.Logf(nil, "NFS Server running at %s\n", s.listener.Addr())
-	return nfs.Serve(s.listener, s.handler)
+	go s.demux.serve()
+	return nfs.Serve(s.demux, s.handler)
+}
+
+// sniffTimeout is how long to wait for the first RPC call on a new
+// connection
+const sniffTimeout = time.Minute
+
+// demuxListener accepts connections, passing the ones speaking NFSv4
+// to the NFSv4 server and returning the rest (NFSv3 and MOUNT) from
+// Accept for go-nfs to serve.
+type demuxListener struct {
+	net.Listener
+	v4        *server4
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newDemuxListener makes a demuxListener for l
+func newDemuxListener(l net.Listener, v4 *server4) *demuxListener {
+	return &demuxListener{
+		Listener: l,
+		v4:       v4,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+}
+
+// serve accepts connections from the underlying listener until it is
+// closed
+func (d *demuxListener) serve() {
+	for {
+		c, err := d.Listener.Accept()
+		if err != nil {
+			select {
+			case <-d.done:
+				return
+			default:
+			}
+			fs.Errorf(nil, "NFS accept failed: %v", err)
+			_ = d.Close()
+			return
+		}
+		go d.sniff(c)
+	}
+}
+
+// sniff reads the start of the first RPC call on c to see which
+// version of NFS it is for
+func (d *demuxListener) sniff(c net.Conn) {
+	// record marker, xid, message type, RPC version, program, version
+	header := make([]byte, 6*4)
+	_ = c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	_, err := io.ReadFull(c, header)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		fs.Debugf(nil, "NFS failed to read first call from %v: %v", c.RemoteAddr(), err)
+		_ = c.Close()
+		return
+	}
+	c = &prefixConn{Conn: c, r: io.MultiReader(bytes.NewReader(header), c)}
+	prog, vers := be.Uint32(header[16:]), be.Uint32(header[20:])
+	if prog == nfsProgram && vers == nfsVersion4 {
+		d.v4.serveConn(c)
+		return
+	}
+	select {
+	case d.conns <- c:
+	case <-d.done:
+		_ = c.Close()
+	}
+}
+
+// Accept returns the next connection which isn't NFSv4
+func (d *demuxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-d.conns:
+		return c, nil
+	case <-d.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close the listener
+func (d *demuxListener) Close() (err error) {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		err = d.Listener.Close()
+	})
+	return err
+}
+
+// prefixConn is a net.Conn which reads from r
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read reads from the prefixed reader
+func (c *prefixConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
 }
@@ -0,0 +1,113 @@
+package preview
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// jpegOrientation returns the EXIF orientation of the JPEG in in, or
+// 1 (normal) if it can't be found
+func jpegOrientation(in io.Reader) int {
+	r := bufio.NewReader(in)
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		// Start of scan or end of image - no more metadata
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return 1
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return 1
+		}
+		segment := make([]byte, int(length)-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 1
+		}
+		if marker[1] == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag from the TIFF header and
+// first IFD in b
+func exifOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(b[4:8]))
+	if offset < 8 || offset+2 > len(b) {
+		return 1
+	}
+	n := int(order.Uint16(b[offset:]))
+	for i := range n {
+		entry := offset + 2 + i*12
+		if entry+12 > len(b) {
+			return 1
+		}
+		if order.Uint16(b[entry:]) == 0x0112 {
+			o := int(order.Uint16(b[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient transforms img so it displays the right way up given the
+// EXIF orientation o
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch o {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 anticlockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, img.RGBAAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,123 @@
+// Package preview makes thumbnails of images and previews of text
+// files for rclone serve
+package preview
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/sync/singleflight"
+)
+
+// Help contains text describing thumbnails and previews
+var Help = strings.ReplaceAll(`### Thumbnails and previews
+
+Use |--thumbnails| to show thumbnails of JPEG, PNG, GIF and WebP
+images in the directory listings. These are made by rclone the first
+time they are asked for, which means reading the whole image, then
+kept in the |vfsThumbs| directory in the cache directory (see
+|--cache-dir|) until the image changes. |--thumbnail-size| sets the
+largest width or height of the thumbnails.
+
+Use |--previews| to show previews of text and markdown files in the
+directory listings. Only the start of large files is shown.
+
+A thumbnail or preview of a file can also be fetched by adding
+|?view=thumbnail| or |?view=preview| to its URL.
+
+`, "|", "`")
+
+// OptionsInfo describes the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "thumbnails",
+	Default: false,
+	Help:    "Show thumbnails of images in directory listings",
+}, {
+	Name:    "thumbnail_size",
+	Default: DefaultThumbnailSize,
+	Help:    "Maximum width and height of thumbnails in pixels",
+}, {
+	Name:    "previews",
+	Default: false,
+	Help:    "Show previews of text and markdown files in directory listings",
+}}
+
+// Options for thumbnails and previews
+type Options struct {
+	Thumbnails    bool `config:"thumbnails"`
+	ThumbnailSize int  `config:"thumbnail_size"`
+	Previews      bool `config:"previews"`
+}
+
+// DefaultThumbnailSize is the thumbnail size used if not set
+const DefaultThumbnailSize = 256
+
+// Previewer makes thumbnails and previews
+type Previewer struct {
+	opt   Options
+	dir   string             // where thumbnails are cached
+	group singleflight.Group // so each thumbnail is only made once at a time
+	sem   chan struct{}      // limits the number of thumbnails being made at once
+}
+
+// New makes a Previewer from opt, returning nil if neither
+// thumbnails nor previews are enabled
+func New(opt *Options) *Previewer {
+	if !opt.Thumbnails && !opt.Previews {
+		return nil
+	}
+	p := &Previewer{
+		opt: *opt,
+		dir: filepath.Join(config.GetCacheDir(), "vfsThumbs"),
+		sem: make(chan struct{}, runtime.NumCPU()),
+	}
+	if p.opt.ThumbnailSize <= 0 {
+		p.opt.ThumbnailSize = DefaultThumbnailSize
+	}
+	return p
+}
+
+// Links returns the URLs of the thumbnail and preview of the file
+// called name at fileURL, or empty strings if there aren't any
+func (p *Previewer) Links(name, fileURL string) (thumbnailURL, previewURL string) {
+	if p == nil {
+		return "", ""
+	}
+	if p.opt.Thumbnails && CanThumbnail(name) {
+		thumbnailURL = fileURL + "?view=thumbnail"
+	}
+	if p.opt.Previews && CanPreview(name) {
+		previewURL = fileURL + "?view=preview"
+	}
+	return thumbnailURL, previewURL
+}
+
+// Serve serves the thumbnail or preview of node asked for by view
+//
+// It returns false if view isn't one it knows about or that type of
+// view isn't enabled.
+func (p *Previewer) Serve(w http.ResponseWriter, r *http.Request, view string, node vfs.Node) bool {
+	if p == nil {
+		return false
+	}
+	switch {
+	case view == "thumbnail" && p.opt.Thumbnails:
+		p.ServeThumbnail(w, r, node)
+	case view == "preview" && p.opt.Previews:
+		p.ServePreview(w, r, node)
+	default:
+		return false
+	}
+	return true
+}
+
+// ext returns the lower case extension of name
+func ext(name string) string {
+	return strings.ToLower(path.Ext(name))
+}
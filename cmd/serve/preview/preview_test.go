@@ -0,0 +1,198 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVFS makes a VFS on a temporary directory containing files
+func newVFS(t *testing.T, files map[string][]byte) *vfs.VFS {
+	dir := t.TempDir()
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0666))
+	}
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, &vfscommon.Opt)
+	t.Cleanup(VFS.Shutdown)
+	return VFS
+}
+
+// makeImage returns an encoded w x h image
+func makeImage(t *testing.T, format string, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if format == "png" {
+		require.NoError(t, png.Encode(&buf, img))
+	} else {
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	}
+	return buf.Bytes()
+}
+
+func TestCan(t *testing.T) {
+	assert.True(t, CanThumbnail("a.JPG"))
+	assert.True(t, CanThumbnail("a.webp"))
+	assert.False(t, CanThumbnail("a.txt"))
+	assert.True(t, CanPreview("README.md"))
+	assert.True(t, CanPreview("a.txt"))
+	assert.True(t, CanPreview("a.json"))
+	assert.True(t, CanPreview("a.yaml"))
+	assert.False(t, CanPreview("a.png"))
+	assert.False(t, CanPreview("a.bin"))
+}
+
+func TestNewAndLinks(t *testing.T) {
+	assert.Nil(t, New(&Options{}))
+	var p *Previewer
+	thumb, prev := p.Links("a.png", "a.png")
+	assert.Equal(t, "", thumb)
+	assert.Equal(t, "", prev)
+
+	p = New(&Options{Thumbnails: true})
+	assert.Equal(t, DefaultThumbnailSize, p.opt.ThumbnailSize)
+	thumb, prev = p.Links("a.png", "a.png")
+	assert.Equal(t, "a.png?view=thumbnail", thumb)
+	assert.Equal(t, "", prev)
+	thumb, prev = p.Links("a.md", "a.md")
+	assert.Equal(t, "", thumb)
+	assert.Equal(t, "", prev)
+}
+
+func TestThumbnail(t *testing.T) {
+	ctx := context.Background()
+	VFS := newVFS(t, map[string][]byte{
+		"wide.png":  makeImage(t, "png", 400, 100),
+		"tall.jpg":  makeImage(t, "jpeg", 50, 300),
+		"small.png": makeImage(t, "png", 10, 20),
+		"bad.png":   []byte("not an image"),
+	})
+	p := New(&Options{Thumbnails: true, ThumbnailSize: 100})
+	p.dir = t.TempDir()
+
+	for _, test := range []struct {
+		name string
+		w, h int
+	}{
+		{"wide.png", 100, 25},
+		{"tall.jpg", 16, 100},
+		{"small.png", 10, 20},
+	} {
+		node, err := VFS.Stat(test.name)
+		require.NoError(t, err)
+		cachePath, err := p.Thumbnail(ctx, node)
+		require.NoError(t, err, test.name)
+		assert.True(t, strings.HasPrefix(cachePath, p.dir))
+		in, err := os.Open(cachePath)
+		require.NoError(t, err)
+		cfg, format, err := image.DecodeConfig(in)
+		require.NoError(t, in.Close())
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, test.w, cfg.Width, test.name)
+		assert.Equal(t, test.h, cfg.Height, test.name)
+
+		// The second time it comes from the cache
+		again, err := p.Thumbnail(ctx, node)
+		require.NoError(t, err)
+		assert.Equal(t, cachePath, again)
+	}
+
+	// Serve a thumbnail
+	node, err := VFS.Stat("wide.png")
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	assert.True(t, p.Serve(w, httptest.NewRequest("GET", "/wide.png?view=thumbnail", nil), "thumbnail", node))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+
+	// Previews aren't enabled
+	w = httptest.NewRecorder()
+	assert.False(t, p.Serve(w, httptest.NewRequest("GET", "/wide.png?view=preview", nil), "preview", node))
+
+	// Bad images
+	node, err = VFS.Stat("bad.png")
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	p.ServeThumbnail(w, httptest.NewRequest("GET", "/bad.png?view=thumbnail", nil), node)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{255, 0, 0, 255}
+	img.SetRGBA(0, 0, red)
+	assert.Equal(t, img, orient(img, 1))
+	out := orient(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), out.Bounds())
+	assert.Equal(t, red, out.RGBAAt(0, 0))
+	out = orient(img, 8)
+	assert.Equal(t, red, out.RGBAAt(0, 1))
+	out = orient(img, 3)
+	assert.Equal(t, red, out.RGBAAt(1, 0))
+
+	// A minimal JPEG with an EXIF orientation of 6 in big endian
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, byte(len(exif) + 2)}
+	data = append(data, exif...)
+	data = append(data, 0xFF, 0xDA)
+	assert.Equal(t, 6, jpegOrientation(bytes.NewReader(data)))
+	assert.Equal(t, 1, jpegOrientation(bytes.NewReader(data[:10])))
+	assert.Equal(t, 1, jpegOrientation(strings.NewReader("not a jpeg")))
+}
+
+func TestPreview(t *testing.T) {
+	VFS := newVFS(t, map[string][]byte{
+		"README.md": []byte("# Title\n\n<script>alert(1)</script>\n\n[link](javascript:alert(1))\n"),
+		"a.txt":     []byte("<b>not bold</b>"),
+		"big.txt":   bytes.Repeat([]byte("é"), maxPreview),
+	})
+	p := New(&Options{Previews: true})
+
+	get := func(name string) *httptest.ResponseRecorder {
+		node, err := VFS.Stat(name)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		assert.True(t, p.Serve(w, httptest.NewRequest("GET", "/"+name+"?view=preview", nil), "preview", node))
+		return w
+	}
+
+	w := get("README.md")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, previewCSP, w.Header().Get("Content-Security-Policy"))
+	body := w.Body.String()
+	assert.Contains(t, body, "<h1>Title</h1>")
+	assert.NotContains(t, body, "<script>")
+	assert.NotContains(t, body, "javascript:")
+
+	body = get("a.txt").Body.String()
+	assert.Contains(t, body, "<pre>&lt;b&gt;not bold&lt;/b&gt;</pre>")
+
+	body = get("big.txt").Body.String()
+	assert.Less(t, len(body), maxPreview+1024)
+	assert.NotContains(t, body, "�")
+	assert.Contains(t, body, "…")
+}
@@ -0,0 +1,124 @@
+package preview
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+	"github.com/russross/blackfriday/v2"
+)
+
+// Only this much of a file is read to make a preview
+const maxPreview = 256 * 1024
+
+// The previews are sandboxed so can't run scripts or load anything
+// other than images from the server
+const previewCSP = "sandbox; default-src 'none'; style-src 'unsafe-inline'; img-src 'self'"
+
+const previewStyle = `<style>
+body { font-family: sans-serif; font-size: 14px; margin: 8px; }
+pre { white-space: pre-wrap; word-wrap: break-word; margin: 0; }
+img { max-width: 100%; }
+</style>
+`
+
+// isMarkdown returns true if name is a markdown file
+func isMarkdown(name string) bool {
+	switch ext(name) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// CanPreview returns true if a preview can be made of the file
+// called name
+func CanPreview(name string) bool {
+	if isMarkdown(name) {
+		return true
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(ext(name)), ";")
+	switch {
+	case strings.HasPrefix(mimeType, "text/"):
+		return true
+	case mimeType == "application/json", mimeType == "application/xml", mimeType == "application/yaml", mimeType == "application/x-yaml":
+		return true
+	}
+	switch ext(name) {
+	case ".yml", ".yaml", ".log", ".ini", ".conf", ".toml":
+		return true
+	}
+	return false
+}
+
+// preview returns the HTML preview of the start of the text in data
+func preview(name string, data []byte, truncated bool) []byte {
+	// Don't cut a multi-byte character in half
+	for i := 0; truncated && i < utf8.UTFMax-1; i++ {
+		r, size := utf8.DecodeLastRune(data)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+	data = bytes.ToValidUTF8(data, []byte("�"))
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	out.WriteString(previewStyle)
+	out.WriteString("</head>\n<body>\n")
+	if isMarkdown(name) {
+		renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+			Flags: blackfriday.SkipHTML | blackfriday.Safelink | blackfriday.NofollowLinks | blackfriday.NoreferrerLinks | blackfriday.HrefTargetBlank,
+		})
+		out.Write(blackfriday.Run(data, blackfriday.WithRenderer(renderer), blackfriday.WithExtensions(blackfriday.CommonExtensions)))
+	} else {
+		out.WriteString("<pre>")
+		out.WriteString(html.EscapeString(string(data)))
+		out.WriteString("</pre>\n")
+	}
+	if truncated {
+		out.WriteString("<p><em>…</em></p>\n")
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.Bytes()
+}
+
+// ServePreview serves an HTML preview of node
+func (p *Previewer) ServePreview(w http.ResponseWriter, r *http.Request, node vfs.Node) {
+	ctx := r.Context()
+	if !node.IsFile() || !CanPreview(node.Name()) {
+		http.Error(w, "No preview for this file", http.StatusNotFound)
+		return
+	}
+	in, err := node.Open(os.O_RDONLY)
+	if err != nil {
+		serve.Error(ctx, node.Path(), w, "Failed to open file", err)
+		return
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(in, maxPreview+1))
+	if err != nil {
+		serve.Error(ctx, node.Path(), w, "Failed to read file", err)
+		return
+	}
+	truncated := len(data) > maxPreview
+	if truncated {
+		data = data[:maxPreview]
+	}
+	fs.Debugf(node.Path(), "Serving preview of %d bytes", len(data))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", previewCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", node.ModTime(), bytes.NewReader(preview(node.Name(), data, truncated)))
+}
@@ -0,0 +1,181 @@
+package preview
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/jpeg"
+	_ "image/png" // register decoder
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register decoder
+)
+
+// Images with more pixels than this aren't decoded to stop a small
+// file using lots of memory
+const maxPixels = 100 * 1000 * 1000
+
+// Quality of the JPEG thumbnails
+const thumbnailQuality = 80
+
+// errTooBig is returned for images with too many pixels
+var errTooBig = errors.New("image too big to make a thumbnail")
+
+// CanThumbnail returns true if a thumbnail can be made of the file
+// called name
+func CanThumbnail(name string) bool {
+	switch ext(name) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// cachePath returns where the thumbnail of node is cached
+//
+// The name depends on the size and modification time of node so a
+// new thumbnail is made when it changes.
+func (p *Previewer) cachePath(node vfs.Node) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d", fs.ConfigString(node.VFS().Fs()), node.Path(), node.Size(), node.ModTime().UnixNano(), p.opt.ThumbnailSize)
+	name := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(p.dir, name[:2], name+".jpg")
+}
+
+// Thumbnail returns the path of a JPEG thumbnail of node, making it
+// if necessary
+func (p *Previewer) Thumbnail(ctx context.Context, node vfs.Node) (string, error) {
+	cachePath := p.cachePath(node)
+	if _, err := os.Stat(cachePath); err == nil {
+		return cachePath, nil
+	}
+	_, err, _ := p.group.Do(cachePath, func() (any, error) {
+		if _, err := os.Stat(cachePath); err == nil {
+			return nil, nil
+		}
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-p.sem }()
+		return nil, p.makeThumbnail(node, cachePath)
+	})
+	if err != nil {
+		return "", err
+	}
+	return cachePath, nil
+}
+
+// makeThumbnail reads the image in node and writes a thumbnail of it
+// to cachePath
+func (p *Previewer) makeThumbnail(node vfs.Node, cachePath string) (err error) {
+	in, err := node.Open(os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+
+	// Check the size before decoding the whole image
+	cfg, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return errTooBig
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, format, err := image.Decode(in)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	thumb := scale(img, p.opt.ThumbnailSize)
+	if format == "jpeg" {
+		if _, err = in.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		thumb = orient(thumb, jpegOrientation(in))
+	}
+
+	// Write the thumbnail atomically
+	err = os.MkdirAll(filepath.Dir(cachePath), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make thumbnail directory: %w", err)
+	}
+	out, err := os.CreateTemp(filepath.Dir(cachePath), "tmp-*.jpg")
+	if err != nil {
+		return fmt.Errorf("failed to make thumbnail: %w", err)
+	}
+	err = jpeg.Encode(out, thumb, &jpeg.Options{Quality: thumbnailQuality})
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), cachePath)
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	fs.Debugf(node.Path(), "Made %dx%d thumbnail", thumb.Bounds().Dx(), thumb.Bounds().Dy())
+	return nil
+}
+
+// scale img so it fits in a size x size square, drawing it on white
+// so transparent images look right as a JPEG
+func scale(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// ServeThumbnail serves a JPEG thumbnail of node
+func (p *Previewer) ServeThumbnail(w http.ResponseWriter, r *http.Request, node vfs.Node) {
+	ctx := r.Context()
+	if !node.IsFile() || !CanThumbnail(node.Name()) {
+		http.Error(w, "No thumbnail for this file", http.StatusNotFound)
+		return
+	}
+	cachePath, err := p.Thumbnail(ctx, node)
+	if err != nil {
+		fs.Debugf(node.Path(), "Failed to make thumbnail: %v", err)
+		http.Error(w, "Can't make a thumbnail of this file", http.StatusUnsupportedMediaType)
+		return
+	}
+	in, err := os.Open(cachePath)
+	if err != nil {
+		serve.Error(ctx, node.Path(), w, "Failed to open thumbnail", err)
+		return
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", node.ModTime(), in)
+}
@@ -73,6 +73,23 @@ proxy process (on STDIN) would look similar to this:
 }
 |||
 
+If the client used a protocol where the password is never sent to
+rclone, such as NTLM used by |serve smb|, the input will only have the
+|user|
+
+|||json
+{
+  "user": "me"
+}
+|||
+
+and the output must have this extra parameter
+
+- |_password| - the password the client must know
+
+which rclone checks the client's response against. This parameter
+isn't passed on to the backend.
+
 And as an example return this on STDOUT
 
 |||json
@@ -138,8 +155,9 @@ type Proxy struct {
 
 // cacheEntry is what is stored in the vfsCache
 type cacheEntry struct {
-	vfs    *vfs.VFS          // stored VFS
-	pwHash [sha256.Size]byte // sha256 hash of the password/publicKey
+	vfs      *vfs.VFS          // stored VFS
+	pwHash   [sha256.Size]byte // sha256 hash of the password/publicKey
+	password string            // password returned by the proxy if challenged
 }
 
 // New creates a new proxy with the Options passed in
@@ -197,10 +215,17 @@ func (p *Proxy) run(in map[string]string) (config configmap.Simple, err error) {
 }
 
 // call runs the auth proxy and returns a cacheEntry and an error
-func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error) {
+//
+// If challenge is set the proxy is only given the user and must
+// return the password in _password.
+func (p *Proxy) call(user, auth string, isPublicKey, challenge bool) (value any, err error) {
 	var config configmap.Simple
 	// Contact the proxy
-	if isPublicKey {
+	if challenge {
+		config, err = p.run(map[string]string{
+			"user": user,
+		})
+	} else if isPublicKey {
 		config, err = p.run(map[string]string{
 			"user":       user,
 			"public_key": auth,
@@ -225,6 +250,14 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error)
 	if !ok {
 		return nil, errors.New("proxy: _root not set in result")
 	}
+	var password string
+	if challenge {
+		password, ok = config.Get("_password")
+		if !ok {
+			return nil, errors.New("proxy: _password not set in result")
+		}
+		delete(config, "_password")
+	}
 
 	// Find the backend
 	fsInfo, err := fs.Find(fsName)
@@ -257,8 +290,9 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error)
 		// need to in memory. An attacker would find it easier to go
 		// after the unencrypted password in memory most likely.
 		entry := cacheEntry{
-			vfs:    vfs.New(f, &p.vfsOpt),
-			pwHash: sha256.Sum256([]byte(auth)),
+			vfs:      vfs.New(f, &p.vfsOpt),
+			pwHash:   sha256.Sum256([]byte(auth)),
+			password: password,
 		}
 		return entry, true, nil
 	})
@@ -276,7 +310,7 @@ func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey
 
 	// If not found then call the proxy for a fresh answer
 	if !ok {
-		value, err = p.call(user, auth, isPublicKey)
+		value, err = p.call(user, auth, isPublicKey, false)
 		if err != nil {
 			return nil, "", err
 		}
@@ -301,6 +335,41 @@ func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey
 		return nil, "", errors.New("proxy: incorrect password")
 	}
 
+	if entry.password != "" {
+		return nil, "", errors.New("proxy: user authenticates with a challenge")
+	}
+
+	return entry.vfs, user, nil
+}
+
+// CallChallenge runs the auth proxy for a user of a protocol which
+// doesn't send the password, such as NTLM. The proxy returns the
+// password of the user which is passed to verify to check the
+// client's response to the challenge.
+//
+// It returns a *vfs.VFS and the key used in the VFS cache.
+func (p *Proxy) CallChallenge(user string, verify func(password string) bool) (VFS *vfs.VFS, vfsKey string, err error) {
+	// Look in the cache first
+	value, ok := p.vfsCache.GetMaybe(user)
+
+	// If not found then call the proxy for a fresh answer
+	if !ok {
+		value, err = p.call(user, "", false, true)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// check we got what we were expecting
+	entry, ok := value.(cacheEntry)
+	if !ok {
+		return nil, "", fmt.Errorf("proxy: value is not cache entry: %#v", value)
+	}
+
+	if entry.password == "" || !verify(entry.password) {
+		return nil, "", errors.New("proxy: incorrect password")
+	}
+
 	return entry.vfs, user, nil
 }
 
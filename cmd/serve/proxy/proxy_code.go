@@ -31,6 +31,9 @@ func main() {
 	if out["type"] == "" {
 		out["type"] = "local"
 	}
+	if _, ok := in["pass"]; !ok && in["public_key"] == "" {
+		out["_password"] = in["user"] + "-password"
+	}
 	if out["_root"] == "" {
 		out["_root"] = ""
 	}
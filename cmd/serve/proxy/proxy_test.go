@@ -81,7 +81,7 @@ func TestRun(t *testing.T) {
 		defer p.vfsCache.Clear()
 
 		passwordBytes := []byte(testPass)
-		value, err := p.call(testUser, testPass, false)
+		value, err := p.call(testUser, testPass, false, false)
 		require.NoError(t, err)
 		entry, ok := value.(cacheEntry)
 		require.True(t, ok)
@@ -164,7 +164,7 @@ func TestRun(t *testing.T) {
 		assert.Equal(t, 0, p.vfsCache.Entries())
 		defer p.vfsCache.Clear()
 
-		value, err := p.call(testUser, publicKeyString, true)
+		value, err := p.call(testUser, publicKeyString, true, false)
 		require.NoError(t, err)
 		entry, ok := value.(cacheEntry)
 		require.True(t, ok)
@@ -233,4 +233,36 @@ func TestRun(t *testing.T) {
 		// check cache is at the same level
 		assert.Equal(t, 1, p.vfsCache.Entries())
 	})
+	t.Run("CallChallenge", func(t *testing.T) {
+		// check cache empty
+		assert.Equal(t, 0, p.vfsCache.Entries())
+		defer p.vfsCache.Clear()
+
+		var gotPassword string
+		vfs, vfsKey, err := p.CallChallenge(testUser, func(password string) bool {
+			gotPassword = password
+			return true
+		})
+		require.NoError(t, err)
+		require.NotNil(t, vfs)
+		assert.Equal(t, "proxy-"+testUser, vfs.Fs().Name())
+		assert.Equal(t, testUser, vfsKey)
+		assert.Equal(t, testUser+"-password", gotPassword)
+
+		// check it is in the cache
+		assert.Equal(t, 1, p.vfsCache.Entries())
+
+		// now try again from the cache but with a wrong response
+		vfs, vfsKey, err = p.CallChallenge(testUser, func(password string) bool {
+			return false
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "incorrect password")
+		require.Nil(t, vfs)
+		require.Equal(t, "", vfsKey)
+
+		// a password login can't use the challenge entry
+		_, _, err = p.Call(testUser, "", false)
+		require.Error(t, err)
+	})
 }
@@ -25,15 +25,22 @@ var (
 // s3Backend implements the gofacess3.Backend interface to make an S3
 // backend for gofakes3
 type s3Backend struct {
-	s    *Server
-	meta *sync.Map
+	s        *Server
+	meta     *sync.Map
+	configMu sync.Mutex
+	configs  map[bucketKey]bucketConfig // cache of the bucket configurations
+
+	uploadsMu sync.Mutex
+	uploads   map[gofakes3.UploadID]*multipartUpload // multipart uploads written with chunk writers
 }
 
 // newBackend creates a new SimpleBucketBackend.
-func newBackend(s *Server) gofakes3.Backend {
+func newBackend(s *Server) *s3Backend {
 	return &s3Backend{
-		s:    s,
-		meta: new(sync.Map),
+		s:       s,
+		meta:    new(sync.Map),
+		configs: make(map[bucketKey]bucketConfig),
+		uploads: make(map[gofakes3.UploadID]*multipartUpload),
 	}
 }
 
@@ -49,7 +56,7 @@ func (b *s3Backend) ListBuckets(ctx context.Context) ([]gofakes3.BucketInfo, err
 	}
 	var response []gofakes3.BucketInfo
 	for _, entry := range dirEntries {
-		if entry.IsDir() {
+		if entry.IsDir() && b.s.allowedBucket(ctx, entry.Name()) {
 			response = append(response, gofakes3.BucketInfo{
 				Name:         entry.Name(),
 				CreationDate: gofakes3.NewContentTime(entry.ModTime()),
@@ -119,40 +126,15 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 
 	fp := path.Join(bucketName, objectName)
 	node, err := _vfs.Stat(fp)
-	if err != nil {
-		return nil, gofakes3.KeyNotFound(objectName)
-	}
-
-	if !node.IsFile() {
+	if err != nil || isReserved(objectName) {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
-	entry := node.DirEntry()
-	if entry == nil {
-		return nil, gofakes3.KeyNotFound(objectName)
-	}
-
-	fobj := entry.(fs.Object)
-	size := node.Size()
-	hash := getFileHashByte(fobj, b.s.etagHashType)
-
-	meta := map[string]string{
-		"Last-Modified": formatHeaderTime(node.ModTime()),
-		"Content-Type":  fs.MimeType(context.Background(), fobj),
-	}
-
-	if val, ok := b.meta.Load(fp); ok {
-		metaMap := val.(map[string]string)
-		maps.Copy(meta, metaMap)
+	obj, err := b.openObject(node, fp, objectName, nil, false)
+	if err != nil {
+		return nil, err
 	}
-
-	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     hash,
-		Metadata: meta,
-		Size:     size,
-		Contents: noOpReadCloser{},
-	}, nil
+	return obj, b.setVersionID(_vfs, bucketName, objectName, obj)
 }
 
 // GetObject fetches the object from the filesystem.
@@ -168,10 +150,26 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 
 	fp := path.Join(bucketName, objectName)
 	node, err := _vfs.Stat(fp)
-	if err != nil {
+	if err != nil || isReserved(objectName) {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
+	obj, err = b.openObject(node, fp, objectName, rangeRequest, true)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.setVersionID(_vfs, bucketName, objectName, obj); err != nil {
+		_ = obj.Contents.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// openObject returns the object for the file node at fp in the VFS.
+//
+// If open is set the contents are opened for reading, otherwise only
+// the fileinfo is returned.
+func (b *s3Backend) openObject(node vfs.Node, fp, objectName string, rangeRequest *gofakes3.ObjectRangeRequest, open bool) (obj *gofakes3.Object, err error) {
 	if !node.IsFile() {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
@@ -187,6 +185,27 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 	size := node.Size()
 	hash := getFileHashByte(fobj, b.s.etagHashType)
 
+	meta := map[string]string{
+		"Last-Modified": formatHeaderTime(node.ModTime()),
+		"Content-Type":  fs.MimeType(context.Background(), fobj),
+	}
+
+	if val, ok := b.meta.Load(fp); ok {
+		metaMap := val.(map[string]string)
+		maps.Copy(meta, metaMap)
+	}
+	tagsToCount(meta)
+
+	if !open {
+		return &gofakes3.Object{
+			Name:     objectName,
+			Hash:     hash,
+			Metadata: meta,
+			Size:     size,
+			Contents: noOpReadCloser{},
+		}, nil
+	}
+
 	in, err := file.Open(os.O_RDONLY)
 	if err != nil {
 		return nil, gofakes3.ErrInternal
@@ -211,16 +230,6 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 		rdr = limitReadCloser(rdr, in.Close, rnge.Length)
 	}
 
-	meta := map[string]string{
-		"Last-Modified": formatHeaderTime(node.ModTime()),
-		"Content-Type":  fs.MimeType(context.Background(), fobj),
-	}
-
-	if val, ok := b.meta.Load(fp); ok {
-		metaMap := val.(map[string]string)
-		maps.Copy(meta, metaMap)
-	}
-
 	return &gofakes3.Object{
 		Name:     objectName,
 		Hash:     hash,
@@ -296,25 +305,20 @@ func (b *s3Backend) PutObject(
 	if err != nil {
 		return result, err
 	}
-	_, err = _vfs.Stat(bucketName)
+	versioning, err := b.beginPut(_vfs, bucketName, objectName)
 	if err != nil {
-		return result, gofakes3.BucketNotFound(bucketName)
+		return result, err
 	}
-
-	fp := path.Join(bucketName, objectName)
-	objectDir := path.Dir(fp)
-	// _, err = db.fs.Stat(objectDir)
-	// if err == vfs.ENOENT {
-	// 	fs.Errorf(objectDir, "PutObject failed: path not found")
-	// 	return result, gofakes3.KeyNotFound(objectName)
-	// }
-
-	if objectDir != "." {
-		if err := mkdirRecursive(objectDir, _vfs); err != nil {
-			return result, err
-		}
+	if versioning != gofakes3.VersioningNone {
+		defer func() {
+			if err != nil {
+				// restore the previous version
+				_ = b.promote(_vfs, bucketName, objectName)
+			}
+		}()
 	}
 
+	fp := path.Join(bucketName, objectName)
 	f, err := _vfs.Create(fp)
 	if err != nil {
 		return result, err
@@ -333,13 +337,11 @@ func (b *s3Backend) PutObject(
 		return result, err
 	}
 
-	_, err = _vfs.Stat(fp)
+	result, err = b.endPut(_vfs, bucketName, objectName, versioning, meta)
 	if err != nil {
 		return result, err
 	}
 
-	b.meta.Store(fp, meta)
-
 	if val, ok := meta["X-Amz-Meta-Mtime"]; ok {
 		ti, err := swift.FloatStringToTime(val)
 		if err == nil {
@@ -358,10 +360,75 @@ func (b *s3Backend) PutObject(
 	return result, nil
 }
 
+// beginPut checks objectName can be written to bucketName and makes
+// its directory.
+//
+// If the bucket is versioned the object being overwritten is kept as
+// a noncurrent version which should be restored with promote if the
+// write fails.
+func (b *s3Backend) beginPut(_vfs *vfs.VFS, bucketName, objectName string) (versioning gofakes3.VersioningStatus, err error) {
+	_, err = _vfs.Stat(bucketName)
+	if err != nil {
+		return versioning, gofakes3.BucketNotFound(bucketName)
+	}
+	if isReserved(objectName) {
+		return versioning, gofakes3.ErrorInvalidArgument("key", objectName, "key is reserved for serve s3")
+	}
+
+	fp := path.Join(bucketName, objectName)
+	objectDir := path.Dir(fp)
+	// _, err = db.fs.Stat(objectDir)
+	// if err == vfs.ENOENT {
+	// 	fs.Errorf(objectDir, "PutObject failed: path not found")
+	// 	return result, gofakes3.KeyNotFound(objectName)
+	// }
+
+	if objectDir != "." {
+		if err := mkdirRecursive(objectDir, _vfs); err != nil {
+			return versioning, err
+		}
+	}
+
+	// keep the object being overwritten if the bucket is versioned
+	versioning, err = b.versioningStatus(_vfs, bucketName)
+	if err != nil {
+		return versioning, err
+	}
+	if versioning != gofakes3.VersioningNone {
+		if err := b.archiveCurrent(_vfs, bucketName, objectName, versioning); err != nil {
+			return versioning, err
+		}
+	}
+	return versioning, nil
+}
+
+// endPut checks objectName has been written, gives it a version if
+// the bucket is versioned and stores its metadata.
+func (b *s3Backend) endPut(_vfs *vfs.VFS, bucketName, objectName string, versioning gofakes3.VersioningStatus, meta map[string]string) (result gofakes3.PutObjectResult, err error) {
+	fp := path.Join(bucketName, objectName)
+	_, err = _vfs.Stat(fp)
+	if err != nil {
+		return result, err
+	}
+
+	switch versioning {
+	case gofakes3.VersioningEnabled:
+		result.VersionID = newVersionID()
+		if err := setCurrentVersionID(_vfs, bucketName, objectName, result.VersionID); err != nil {
+			return result, err
+		}
+	case gofakes3.VersioningSuspended:
+		result.VersionID = nullVersion
+	}
+
+	b.meta.Store(fp, meta)
+	return result, nil
+}
+
 // DeleteMulti deletes multiple objects in a single request.
 func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects ...string) (result gofakes3.MultiDeleteResult, rerr error) {
 	for _, object := range objects {
-		if err := b.deleteObject(ctx, bucketName, object); err != nil {
+		if _, err := b.deleteObject(ctx, bucketName, object); err != nil {
 			fs.Errorf("serve s3", "delete object failed: %v", err)
 			result.Error = append(result.Error, gofakes3.ErrorResult{
 				Code:    gofakes3.ErrInternal,
@@ -380,30 +447,52 @@ func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects
 
 // DeleteObject deletes the object with the given name.
 func (b *s3Backend) DeleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, rerr error) {
-	return result, b.deleteObject(ctx, bucketName, objectName)
+	return b.deleteObject(ctx, bucketName, objectName)
 }
 
 // deleteObject deletes the object from the filesystem.
-func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) error {
+//
+// If the bucket is versioned the object is kept as a noncurrent
+// version and a delete marker is added instead.
+func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, err error) {
 	_vfs, err := b.s.getVFS(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
 	_, err = _vfs.Stat(bucketName)
 	if err != nil {
-		return gofakes3.BucketNotFound(bucketName)
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+	if isReserved(objectName) {
+		return result, nil
 	}
 
 	fp := path.Join(bucketName, objectName)
-	// S3 does not report an error when attempting to delete a key that does not exist, so
-	// we need to skip IsNotExist errors.
-	if err := _vfs.Remove(fp); err != nil && !os.IsNotExist(err) {
-		return err
+	versioning, err := b.versioningStatus(_vfs, bucketName)
+	if err != nil {
+		return result, err
+	}
+	if versioning != gofakes3.VersioningNone {
+		if err := b.archiveCurrent(_vfs, bucketName, objectName, versioning); err != nil {
+			return result, err
+		}
+		result.IsDeleteMarker = true
+		result.VersionID = nullVersion
+		if versioning == gofakes3.VersioningEnabled {
+			result.VersionID = newVersionID()
+		}
+		if err := b.addDeleteMarker(_vfs, bucketName, objectName, result.VersionID); err != nil {
+			return result, err
+		}
+	} else if err := _vfs.Remove(fp); err != nil && !os.IsNotExist(err) {
+		// S3 does not report an error when attempting to delete a key that does not exist, so
+		// we need to skip IsNotExist errors.
+		return result, err
 	}
 
 	// FIXME: unsafe operation
 	rmdirRecursive(fp, _vfs)
-	return nil
+	return result, nil
 }
 
 // CreateBucket creates a new bucket.
@@ -438,6 +527,21 @@ func (b *s3Backend) DeleteBucket(ctx context.Context, name string) error {
 		return gofakes3.BucketNotFound(name)
 	}
 
+	// remove the bucket state if the bucket is otherwise empty
+	entries, err := getDirEntries(name, _vfs)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 && entries[0].Name() == metaDir && entries[0].IsDir() {
+		if hasVersions(_vfs, name) {
+			return gofakes3.ErrBucketNotEmpty
+		}
+		if err := entries[0].(*vfs.Dir).RemoveAll(); err != nil {
+			return err
+		}
+		b.forgetBucketConfig(_vfs, name)
+	}
+
 	if err := _vfs.Remove(name); err != nil {
 		return gofakes3.ErrBucketNotEmpty
 	}
@@ -499,10 +603,16 @@ func (b *s3Backend) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket
 	}()
 
 	for k, v := range c.Metadata {
-		if _, found := meta[k]; !found && k != "X-Amz-Acl" {
+		if _, found := meta[k]; !found && k != "X-Amz-Acl" && k != "X-Amz-Tagging-Count" {
 			meta[k] = v
 		}
 	}
+	// copy the tags which GetObject only returns the count of
+	if _, found := meta[tagsMetaKey]; !found {
+		if tags := b.getTags(ctx, fp, cStat); len(tags) > 0 {
+			meta[tagsMetaKey] = tags.Encode()
+		}
+	}
 	if _, ok := meta["mtime"]; !ok {
 		meta["mtime"] = swift.TimeToFloatString(cStat.ModTime())
 	}
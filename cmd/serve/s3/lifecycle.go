@@ -0,0 +1,367 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// day is the unit of the lifecycle rules
+const day = 24 * time.Hour
+
+// lifecycleConfiguration is the lifecycle of a bucket
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration" json:"-"`
+	Xmlns   string          `xml:"xmlns,attr,omitempty" json:"-"`
+	Rules   []lifecycleRule `xml:"Rule" json:"rules"`
+}
+
+// lifecycleRule is a single lifecycle rule
+type lifecycleRule struct {
+	ID                          string                       `xml:"ID,omitempty" json:"id,omitempty"`
+	Status                      string                       `xml:"Status" json:"status"`
+	Prefix                      *string                      `xml:"Prefix" json:"prefix,omitempty"` // deprecated form of Filter.Prefix
+	Filter                      *lifecycleFilter             `xml:"Filter,omitempty" json:"filter,omitempty"`
+	Expiration                  *lifecycleExpiration         `xml:"Expiration,omitempty" json:"expiration,omitempty"`
+	NoncurrentVersionExpiration *noncurrentVersionExpiration `xml:"NoncurrentVersionExpiration,omitempty" json:"noncurrentVersionExpiration,omitempty"`
+}
+
+// lifecycleFilter selects the objects a rule applies to
+type lifecycleFilter struct {
+	Prefix string        `xml:"Prefix,omitempty" json:"prefix,omitempty"`
+	Tag    *tag          `xml:"Tag,omitempty" json:"tag,omitempty"`
+	And    *lifecycleAnd `xml:"And,omitempty" json:"and,omitempty"`
+}
+
+// lifecycleAnd combines a prefix and tags in a filter
+type lifecycleAnd struct {
+	Prefix string `xml:"Prefix,omitempty" json:"prefix,omitempty"`
+	Tags   []tag  `xml:"Tag" json:"tags,omitempty"`
+}
+
+// lifecycleExpiration expires the current version of objects
+type lifecycleExpiration struct {
+	Days                      int    `xml:"Days,omitempty" json:"days,omitempty"`
+	Date                      string `xml:"Date,omitempty" json:"date,omitempty"`
+	ExpiredObjectDeleteMarker bool   `xml:"ExpiredObjectDeleteMarker,omitempty" json:"expiredObjectDeleteMarker,omitempty"`
+}
+
+// noncurrentVersionExpiration expires the noncurrent versions of objects
+type noncurrentVersionExpiration struct {
+	NoncurrentDays          int `xml:"NoncurrentDays" json:"noncurrentDays"`
+	NewerNoncurrentVersions int `xml:"NewerNoncurrentVersions,omitempty" json:"newerNoncurrentVersions,omitempty"`
+}
+
+// check the lifecycle is one we can apply
+func (lc *lifecycleConfiguration) check() error {
+	if len(lc.Rules) == 0 {
+		return gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, "no lifecycle rules")
+	}
+	ids := map[string]struct{}{}
+	for i := range lc.Rules {
+		rule := &lc.Rules[i]
+		if rule.ID != "" {
+			if _, found := ids[rule.ID]; found {
+				return gofakes3.ErrorInvalidArgument("ID", rule.ID, "Rule ID must be unique")
+			}
+			ids[rule.ID] = struct{}{}
+		}
+		if rule.Status != "Enabled" && rule.Status != "Disabled" {
+			return gofakes3.ErrorMessagef(gofakes3.ErrMalformedXML, "bad rule status %q", rule.Status)
+		}
+		if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil {
+			return gofakes3.ErrorInvalidArgument("Rule", rule.ID, "At least one action needs to be specified in a rule")
+		}
+		if exp := rule.Expiration; exp != nil {
+			actions := 0
+			if exp.Days != 0 {
+				actions++
+			}
+			if exp.Date != "" {
+				actions++
+			}
+			if exp.ExpiredObjectDeleteMarker {
+				actions++
+			}
+			if actions != 1 {
+				return gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, "Expiration needs exactly one of Days, Date or ExpiredObjectDeleteMarker")
+			}
+			if exp.Days < 0 {
+				return gofakes3.ErrorInvalidArgument("Days", fmt.Sprint(exp.Days), "'Days' for Expiration action must be a positive integer")
+			}
+			if _, err := exp.date(); err != nil {
+				return gofakes3.ErrorInvalidArgument("Date", exp.Date, "'Date' must be at midnight GMT")
+			}
+		}
+		if nve := rule.NoncurrentVersionExpiration; nve != nil && (nve.NoncurrentDays <= 0 || nve.NewerNoncurrentVersions < 0) {
+			return gofakes3.ErrorInvalidArgument("NoncurrentDays", fmt.Sprint(nve.NoncurrentDays), "'NoncurrentDays' for NoncurrentVersionExpiration action must be a positive integer")
+		}
+	}
+	return nil
+}
+
+// date returns the expiry date or the zero time if not set
+func (exp *lifecycleExpiration) date() (time.Time, error) {
+	if exp.Date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, exp.Date)
+	if err != nil {
+		return t, err
+	}
+	if !t.Equal(t.Truncate(day)) {
+		return t, fmt.Errorf("date %q is not at midnight", exp.Date)
+	}
+	return t, nil
+}
+
+// matches returns true if the rule applies to the object key with tags
+func (rule *lifecycleRule) matches(key string, tags url.Values) bool {
+	if rule.Status != "Enabled" {
+		return false
+	}
+	prefix := ""
+	var wantTags []tag
+	switch {
+	case rule.Filter != nil && rule.Filter.And != nil:
+		prefix = rule.Filter.And.Prefix
+		wantTags = rule.Filter.And.Tags
+	case rule.Filter != nil:
+		prefix = rule.Filter.Prefix
+		if rule.Filter.Tag != nil {
+			wantTags = []tag{*rule.Filter.Tag}
+		}
+	case rule.Prefix != nil:
+		prefix = *rule.Prefix
+	}
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	for _, t := range wantTags {
+		if !tags.Has(t.Key) || tags.Get(t.Key) != t.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// serveLifecycle serves the lifecycle APIs of the bucket
+func (b *s3Backend) serveLifecycle(w http.ResponseWriter, r *http.Request, bucket string) error {
+	_vfs, err := b.s.getVFS(r.Context())
+	if err != nil {
+		return err
+	}
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return gofakes3.BucketNotFound(bucket)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		cfg, err := b.getBucketConfig(_vfs, bucket)
+		if err != nil {
+			return err
+		}
+		if cfg.Lifecycle == nil {
+			return gofakes3.ErrorMessage(errNoSuchLifecycleConfiguration, "The lifecycle configuration does not exist")
+		}
+		lc := *cfg.Lifecycle
+		lc.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+		return writeXML(w, &lc)
+	case http.MethodPut:
+		lc := new(lifecycleConfiguration)
+		if err := readXML(r, lc); err != nil {
+			return err
+		}
+		if err := lc.check(); err != nil {
+			return err
+		}
+		err = b.updateBucketConfig(_vfs, bucket, func(cfg *bucketConfig) {
+			cfg.Lifecycle = lc
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		err = b.updateBucketConfig(_vfs, bucket, func(cfg *bucketConfig) {
+			cfg.Lifecycle = nil
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return gofakes3.ErrMethodNotAllowed
+	}
+	return nil
+}
+
+// runLifecycle applies the lifecycle rules of the buckets every
+// interval until ctx is cancelled
+func (b *s3Backend) runLifecycle(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_vfs, err := b.s.getVFS(ctx)
+			if err != nil {
+				fs.Errorf("serve s3", "Lifecycle: %v", err)
+				continue
+			}
+			b.applyLifecycle(ctx, _vfs, time.Now())
+		}
+	}
+}
+
+// applyLifecycle applies the lifecycle rules of all the buckets as if
+// the time was now
+func (b *s3Backend) applyLifecycle(ctx context.Context, _vfs *vfs.VFS, now time.Time) {
+	buckets, err := getDirEntries("/", _vfs)
+	if err != nil {
+		fs.Errorf("serve s3", "Lifecycle: failed to list buckets: %v", err)
+		return
+	}
+	for _, entry := range buckets {
+		if !entry.IsDir() {
+			continue
+		}
+		bucket := entry.Name()
+		cfg, err := b.getBucketConfig(_vfs, bucket)
+		if err != nil {
+			fs.Errorf(bucket, "Lifecycle: %v", err)
+			continue
+		}
+		if cfg.Lifecycle == nil {
+			continue
+		}
+		if err := b.expireBucket(ctx, _vfs, bucket, cfg.Lifecycle, now); err != nil {
+			fs.Errorf(bucket, "Lifecycle: %v", err)
+		}
+	}
+}
+
+// allKeys returns the sorted keys in bucket with a current or a
+// noncurrent version
+func (b *s3Backend) allKeys(_vfs *vfs.VFS, bucket string) ([]string, error) {
+	live := gofakes3.NewObjectList()
+	err := b.entryListR(_vfs, bucket, "", "", false, live)
+	if err != nil && err != gofakes3.ErrNoSuchKey {
+		return nil, err
+	}
+	keys, err := versionedKeys(_vfs, bucket)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range live.Contents {
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || keys[i-1] != key {
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
+
+// expireBucket applies the lifecycle rules lc to the objects in bucket
+func (b *s3Backend) expireBucket(ctx context.Context, _vfs *vfs.VFS, bucket string, lc *lifecycleConfiguration, now time.Time) error {
+	keys, err := b.allKeys(_vfs, bucket)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := b.expireObject(ctx, _vfs, bucket, key, lc, now); err != nil {
+			fs.Errorf(path.Join(bucket, key), "Lifecycle: %v", err)
+		}
+	}
+	return nil
+}
+
+// expireObject applies the lifecycle rules lc to the versions of key
+func (b *s3Backend) expireObject(ctx context.Context, _vfs *vfs.VFS, bucket, key string, lc *lifecycleConfiguration, now time.Time) error {
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	var tags url.Values
+	if v := versions[0]; v.Current {
+		tags = b.getTags(ctx, v.Path, v.Node)
+	}
+	for i := range lc.Rules {
+		rule := &lc.Rules[i]
+		if !rule.matches(key, tags) {
+			continue
+		}
+		if nve := rule.NoncurrentVersionExpiration; nve != nil {
+			kept := 0
+			for j := 1; j < len(versions); j++ {
+				v := versions[j]
+				if v.Marker {
+					continue
+				}
+				// a version becomes noncurrent when the next one is made
+				noncurrentSince := versions[j-1].created()
+				if kept < nve.NewerNoncurrentVersions || now.Sub(noncurrentSince) < time.Duration(nve.NoncurrentDays)*day {
+					kept++
+					continue
+				}
+				fs.Infof(path.Join(bucket, key), "Lifecycle: removing noncurrent version %s", v.ID)
+				if _, err := b.DeleteObjectVersion(bucket, key, v.ID); err != nil {
+					return err
+				}
+			}
+		}
+		if exp := rule.Expiration; exp != nil {
+			if err := b.expireCurrent(ctx, _vfs, bucket, key, exp, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expireCurrent applies exp to the current version of key
+func (b *s3Backend) expireCurrent(ctx context.Context, _vfs *vfs.VFS, bucket, key string, exp *lifecycleExpiration, now time.Time) error {
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	v := versions[0]
+	if exp.ExpiredObjectDeleteMarker {
+		if len(versions) == 1 && v.Marker {
+			fs.Infof(path.Join(bucket, key), "Lifecycle: removing expired delete marker %s", v.ID)
+			_, err = b.DeleteObjectVersion(bucket, key, v.ID)
+		}
+		return err
+	}
+	if !v.Current {
+		return nil
+	}
+	date, _ := exp.date()
+	if exp.Days > 0 && now.Sub(v.Node.ModTime()) < time.Duration(exp.Days)*day {
+		return nil
+	}
+	if !date.IsZero() && now.Before(date) {
+		return nil
+	}
+	fs.Infof(path.Join(bucket, key), "Lifecycle: expiring object")
+	_, err = b.deleteObject(ctx, bucket, key)
+	return err
+}
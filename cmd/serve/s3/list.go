@@ -26,6 +26,11 @@ func (b *s3Backend) entryListR(_vfs *vfs.VFS, bucket, fdPath, name string, addPr
 			continue
 		}
 
+		// hide the bucket state
+		if fdPath == "" && object == metaDir {
+			continue
+		}
+
 		if entry.IsDir() {
 			if addPrefix {
 				prefixWithTrailingSlash := objectPath + "/"
@@ -0,0 +1,514 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/swift/v2"
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/multipart"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Multipart uploads to remotes which support OpenChunkWriter are
+// written straight to the backend as each part arrives rather than
+// being held by gofakes3 until the upload is complete.
+//
+// Part N of the upload is written as chunk N-1 so the upload can only
+// be completed with all the parts uploaded, numbered from 1 with no
+// gaps, which is what S3 clients do.
+
+// multipartUpload is a multipart upload in progress
+type multipartUpload struct {
+	id        gofakes3.UploadID
+	bucket    string
+	key       string
+	meta      map[string]string
+	initiated time.Time
+	vfs       *vfs.VFS
+	writer    fs.ChunkWriter
+
+	mu        sync.Mutex
+	parts     map[int]multipartPart // parts uploaded so far by part number
+	partLocks map[int]*sync.Mutex   // held while writing each part number
+}
+
+// multipartPart describes an uploaded part
+type multipartPart struct {
+	md5          []byte
+	size         int64
+	lastModified time.Time
+}
+
+// etag returns the ETag of the part
+func (p *multipartPart) etag() string {
+	return `"` + hex.EncodeToString(p.md5) + `"`
+}
+
+// chunkWriterVFS returns the VFS of the request if its remote can
+// write chunks directly
+func (b *s3Backend) chunkWriterVFS(ctx context.Context) *vfs.VFS {
+	_vfs, err := b.s.getVFS(ctx)
+	if err != nil || _vfs.Fs().Features().OpenChunkWriter == nil {
+		return nil
+	}
+	return _vfs
+}
+
+// isMultipartRequest returns true if r is part of the multipart
+// upload API
+func isMultipartRequest(r *http.Request, object string) bool {
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	if object == "" {
+		return uploads && r.Method == http.MethodGet
+	}
+	return uploads && r.Method == http.MethodPost || query.Get("uploadId") != ""
+}
+
+// serveMultipart serves the multipart upload API for object in bucket
+func (b *s3Backend) serveMultipart(w http.ResponseWriter, r *http.Request, _vfs *vfs.VFS, bucket, object string) error {
+	if _, err := _vfs.Stat(bucket); err != nil {
+		return gofakes3.BucketNotFound(bucket)
+	}
+	if object == "" {
+		return b.listUploads(w, r, _vfs, bucket)
+	}
+	uploadID := gofakes3.UploadID(r.URL.Query().Get("uploadId"))
+	if uploadID == "" {
+		return b.createUpload(w, r, _vfs, bucket, object)
+	}
+	b.uploadsMu.Lock()
+	upload, ok := b.uploads[uploadID]
+	b.uploadsMu.Unlock()
+	if !ok || upload.vfs != _vfs || upload.bucket != bucket || upload.key != object {
+		return gofakes3.ErrNoSuchUpload
+	}
+	switch r.Method {
+	case http.MethodPut:
+		return b.uploadPart(w, r, upload)
+	case http.MethodPost:
+		return b.completeUpload(w, r, upload)
+	case http.MethodDelete:
+		return b.abortUpload(w, r, upload)
+	case http.MethodGet:
+		return b.listParts(w, r, upload)
+	}
+	return gofakes3.ErrMethodNotAllowed
+}
+
+// metadataHeaders returns the headers of r which are stored as the
+// metadata of the object as gofakes3 does
+func metadataHeaders(r *http.Request) map[string]string {
+	meta := map[string]string{}
+	for k, v := range r.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		if strings.HasPrefix(k, "X-Amz-") || strings.HasPrefix(k, "Content-") || k == "Cache-Control" {
+			meta[k] = v[0]
+		}
+	}
+	meta["Last-Modified"] = formatHeaderTime(time.Now())
+	return meta
+}
+
+// createUpload starts a multipart upload by opening a chunk writer
+func (b *s3Backend) createUpload(w http.ResponseWriter, r *http.Request, _vfs *vfs.VFS, bucket, key string) error {
+	if isReserved(key) {
+		return gofakes3.ErrorInvalidArgument("key", key, "key is reserved for serve s3")
+	}
+	upload := &multipartUpload{
+		id:        gofakes3.UploadID(random.String(32)),
+		bucket:    bucket,
+		key:       key,
+		meta:      metadataHeaders(r),
+		initiated: time.Now(),
+		vfs:       _vfs,
+		parts:     map[int]multipartPart{},
+		partLocks: map[int]*sync.Mutex{},
+	}
+	modTime := upload.initiated
+	if val, ok := upload.meta["X-Amz-Meta-Mtime"]; ok {
+		if t, err := swift.FloatStringToTime(val); err == nil {
+			modTime = t
+		}
+	}
+	var options []fs.OpenOption
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		options = append(options, &fs.HTTPOption{Key: "Content-Type", Value: contentType})
+	}
+	f := _vfs.Fs()
+	src := object.NewStaticObjectInfo(path.Join(bucket, key), modTime, -1, true, nil, f)
+	// the writer outlives this request so use the server context
+	_, writer, err := f.Features().OpenChunkWriter(b.s.ctx, src.Remote(), src, options...)
+	if err != nil {
+		return err
+	}
+	upload.writer = writer
+
+	b.uploadsMu.Lock()
+	b.uploads[upload.id] = upload
+	b.uploadsMu.Unlock()
+	fs.Debugf(src, "Started multipart upload %s", upload.id)
+	return writeXML(w, gofakes3.InitiateMultipartUpload{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: upload.id,
+	})
+}
+
+// uploadPart writes a part of the upload to the backend
+func (b *s3Backend) uploadPart(w http.ResponseWriter, r *http.Request, upload *multipartUpload) (err error) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > gofakes3.MaxUploadPartNumber {
+		return gofakes3.ErrInvalidPart
+	}
+	if r.Header.Get("x-amz-copy-source") != "" {
+		return gofakes3.ErrNotImplemented
+	}
+
+	in, sizeHeader := io.Reader(r.Body), "Content-Length"
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		in, sizeHeader = newChunkedReader(r.Body), "X-Amz-Decoded-Content-Length"
+	}
+	size, err := strconv.ParseInt(r.Header.Get(sizeHeader), 10, 64)
+	if err != nil || size < 0 {
+		return gofakes3.ErrMissingContentLength
+	}
+	if maxSize := int64(b.s.opt.MaxPartSize); size > maxSize {
+		return gofakes3.ErrorMessagef(errEntityTooLarge, "Part size %v is bigger than --max-part-size %v", fs.SizeSuffix(size), fs.SizeSuffix(maxSize))
+	}
+
+	// buffer the part as the backend may need to retry writing it
+	rw := multipart.NewRW().Reserve(size)
+	defer fs.CheckClose(rw, &err)
+	hasher := md5.New()
+	n, err := io.Copy(io.MultiWriter(rw, hasher), io.LimitReader(in, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return gofakes3.ErrIncompleteBody
+	}
+	sum := hasher.Sum(nil)
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" && contentMD5 != base64.StdEncoding.EncodeToString(sum) {
+		return gofakes3.ErrBadDigest
+	}
+
+	// A part may be uploaded again, so write each part number one
+	// at a time to keep the backend and the parts list consistent
+	partLock := upload.partLock(partNumber)
+	partLock.Lock()
+	defer partLock.Unlock()
+	if _, err := upload.writer.WriteChunk(r.Context(), partNumber-1, rw); err != nil {
+		return err
+	}
+	part := multipartPart{
+		md5:          sum,
+		size:         size,
+		lastModified: time.Now(),
+	}
+	upload.mu.Lock()
+	upload.parts[partNumber] = part
+	upload.mu.Unlock()
+
+	w.Header().Set("ETag", part.etag())
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// partLock returns the lock for writing partNumber
+func (upload *multipartUpload) partLock(partNumber int) *sync.Mutex {
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	partLock := upload.partLocks[partNumber]
+	if partLock == nil {
+		partLock = new(sync.Mutex)
+		upload.partLocks[partNumber] = partLock
+	}
+	return partLock
+}
+
+// check the parts to complete the upload with are all the parts
+// uploaded in order, returning the ETag of the object
+func (upload *multipartUpload) check(parts []gofakes3.CompletedPart) (etag string, err error) {
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	if len(parts) == 0 {
+		return "", gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, "You must specify at least one part")
+	}
+	hasher := md5.New()
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return "", gofakes3.ErrInvalidPartOrder
+		}
+		uploaded, ok := upload.parts[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != hex.EncodeToString(uploaded.md5) {
+			return "", gofakes3.ErrorMessagef(gofakes3.ErrInvalidPart, "Part %d not found or its ETag doesn't match", p.PartNumber)
+		}
+		if p.PartNumber != i+1 {
+			return "", gofakes3.ErrorMessagef(gofakes3.ErrInvalidPart, "Part %d is out of sequence: the parts must be numbered from 1 with no gaps", p.PartNumber)
+		}
+		_, _ = hasher.Write(uploaded.md5)
+	}
+	if len(parts) != len(upload.parts) {
+		return "", gofakes3.ErrorMessage(gofakes3.ErrInvalidPart, "All the uploaded parts must be used to complete the upload")
+	}
+	return fmt.Sprintf(`"%x-%d"`, hasher.Sum(nil), len(parts)), nil
+}
+
+// completeUpload finishes the upload on the backend
+func (b *s3Backend) completeUpload(w http.ResponseWriter, r *http.Request, upload *multipartUpload) (err error) {
+	ctx := r.Context()
+	var in gofakes3.CompleteMultipartUploadRequest
+	if err := readXML(r, &in); err != nil {
+		return err
+	}
+	etag, err := upload.check(in.Parts)
+	if err != nil {
+		return err
+	}
+	// stop other requests using the upload
+	b.uploadsMu.Lock()
+	if _, ok := b.uploads[upload.id]; !ok {
+		b.uploadsMu.Unlock()
+		return gofakes3.ErrNoSuchUpload
+	}
+	delete(b.uploads, upload.id)
+	b.uploadsMu.Unlock()
+
+	_vfs, bucket, key := upload.vfs, upload.bucket, upload.key
+	versioning, err := b.beginPut(_vfs, bucket, key)
+	if err != nil {
+		_ = upload.writer.Abort(ctx)
+		return err
+	}
+	if versioning != gofakes3.VersioningNone {
+		defer func() {
+			if err != nil {
+				// restore the previous version
+				_ = b.promote(_vfs, bucket, key)
+			}
+		}()
+	}
+	if err := upload.writer.Close(ctx); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	// the object was written behind the back of the VFS
+	fp := path.Join(bucket, key)
+	dir, leaf, err := _vfs.StatParent(fp)
+	if err != nil {
+		return err
+	}
+	dir.ForgetPath(leaf, fs.EntryObject)
+
+	result, err := b.endPut(_vfs, bucket, key, versioning, upload.meta)
+	if err != nil {
+		return err
+	}
+	if val, ok := upload.meta["X-Amz-Meta-Mtime"]; ok {
+		// the modification time was set by the chunk writer
+		b.storeModtime(fp, upload.meta, val)
+	}
+	fs.Debugf(fp, "Completed multipart upload %s with %d parts", upload.id, len(in.Parts))
+
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+	return writeXML(w, gofakes3.CompleteMultipartUploadResult{
+		Bucket: bucket,
+		Key:    key,
+		ETag:   etag,
+	})
+}
+
+// abortUpload cancels the upload on the backend
+func (b *s3Backend) abortUpload(w http.ResponseWriter, r *http.Request, upload *multipartUpload) error {
+	b.uploadsMu.Lock()
+	delete(b.uploads, upload.id)
+	b.uploadsMu.Unlock()
+	if err := upload.writer.Abort(r.Context()); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// abortUploads cancels all the uploads in progress
+func (b *s3Backend) abortUploads(ctx context.Context) {
+	b.uploadsMu.Lock()
+	uploads := b.uploads
+	b.uploads = map[gofakes3.UploadID]*multipartUpload{}
+	b.uploadsMu.Unlock()
+	for _, upload := range uploads {
+		if err := upload.writer.Abort(ctx); err != nil {
+			fs.Errorf(path.Join(upload.bucket, upload.key), "Failed to abort multipart upload %s: %v", upload.id, err)
+		}
+	}
+}
+
+// listParts lists the parts uploaded so far
+func (b *s3Backend) listParts(w http.ResponseWriter, r *http.Request, upload *multipartUpload) error {
+	query := r.URL.Query()
+	marker, _ := strconv.Atoi(query.Get("part-number-marker"))
+	maxParts := gofakes3.DefaultMaxUploadParts
+	if n, err := strconv.Atoi(query.Get("max-parts")); err == nil && n > 0 && n < maxParts {
+		maxParts = n
+	}
+	result := gofakes3.ListMultipartUploadPartsResult{
+		Bucket:           upload.bucket,
+		Key:              upload.key,
+		UploadID:         upload.id,
+		StorageClass:     gofakes3.StorageStandard,
+		PartNumberMarker: marker,
+		MaxParts:         int64(maxParts),
+	}
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	for _, number := range slices.Sorted(maps.Keys(upload.parts)) {
+		if number <= marker {
+			continue
+		}
+		if len(result.Parts) >= maxParts {
+			result.IsTruncated = true
+			break
+		}
+		part := upload.parts[number]
+		result.Parts = append(result.Parts, gofakes3.ListMultipartUploadPartItem{
+			PartNumber:   number,
+			LastModified: gofakes3.NewContentTime(part.lastModified),
+			ETag:         part.etag(),
+			Size:         part.size,
+		})
+		result.NextPartNumberMarker = number
+	}
+	return writeXML(w, result)
+}
+
+// listUploads lists the uploads in progress in the bucket
+func (b *s3Backend) listUploads(w http.ResponseWriter, r *http.Request, _vfs *vfs.VFS, bucket string) error {
+	query := r.URL.Query()
+	maxUploads := gofakes3.DefaultMaxUploads
+	if n, err := strconv.Atoi(query.Get("max-uploads")); err == nil && n > 0 && n < maxUploads {
+		maxUploads = n
+	}
+	result := gofakes3.ListMultipartUploadsResult{
+		Bucket:         bucket,
+		Prefix:         query.Get("prefix"),
+		KeyMarker:      query.Get("key-marker"),
+		UploadIDMarker: gofakes3.UploadID(query.Get("upload-id-marker")),
+		MaxUploads:     int64(maxUploads),
+	}
+
+	var uploads []*multipartUpload
+	b.uploadsMu.Lock()
+	for _, upload := range b.uploads {
+		if upload.vfs == _vfs && upload.bucket == bucket && strings.HasPrefix(upload.key, result.Prefix) {
+			uploads = append(uploads, upload)
+		}
+	}
+	b.uploadsMu.Unlock()
+	slices.SortFunc(uploads, func(a, b *multipartUpload) int {
+		if a.key != b.key {
+			return strings.Compare(a.key, b.key)
+		}
+		return strings.Compare(string(a.id), string(b.id))
+	})
+
+	for _, upload := range uploads {
+		if result.KeyMarker != "" {
+			if upload.key < result.KeyMarker {
+				continue
+			}
+			if upload.key == result.KeyMarker && (result.UploadIDMarker == "" || upload.id <= result.UploadIDMarker) {
+				continue
+			}
+		}
+		if len(result.Uploads) >= maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, gofakes3.ListMultipartUploadItem{
+			Key:          upload.key,
+			UploadID:     upload.id,
+			StorageClass: gofakes3.StorageStandard,
+			Initiated:    gofakes3.NewContentTime(upload.initiated),
+		})
+		result.NextKeyMarker = upload.key
+		result.NextUploadIDMarker = upload.id
+	}
+	return writeXML(w, result)
+}
+
+// chunkedReader decodes a body sent with aws-chunked encoding
+type chunkedReader struct {
+	in        *bufio.Reader
+	remaining int64 // bytes left in the current chunk
+	done      bool
+}
+
+// newChunkedReader makes a reader to decode the aws-chunked body in
+func newChunkedReader(in io.Reader) *chunkedReader {
+	return &chunkedReader{in: bufio.NewReader(in)}
+}
+
+// Read the decoded body
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err = c.in.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads the header of the next chunk of the form
+// "size;chunk-signature=signature\r\n" skipping the end of the
+// previous chunk. The trailers after the last chunk are ignored.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.in.ReadString('\n')
+	if err == nil && strings.TrimSpace(line) == "" {
+		// end of the previous chunk
+		line, err = c.in.ReadString('\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read chunk header: %w", err)
+	}
+	sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid chunk header %q", line)
+	}
+	c.remaining = size
+	c.done = size == 0
+	return nil
+}
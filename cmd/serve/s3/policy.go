@@ -0,0 +1,260 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+)
+
+// policyAction is something an access key can be allowed to do
+type policyAction string
+
+// Actions which can be allowed in the policy
+const (
+	actionRead   policyAction = "read"   // read objects
+	actionWrite  policyAction = "write"  // upload and copy objects, make buckets and change their configuration
+	actionList   policyAction = "list"   // list buckets and objects
+	actionDelete policyAction = "delete" // delete objects and buckets
+)
+
+// policyStatement allows the actions on the keys starting with Prefix
+// in Bucket, or in all the buckets if Bucket is "*"
+type policyStatement struct {
+	Bucket  string         `json:"bucket"`
+	Prefix  string         `json:"prefix,omitempty"`
+	Actions []policyAction `json:"actions"`
+}
+
+// policy maps the access key IDs to the statements which allow them
+// to do things. Anything not allowed is denied.
+type policy map[string][]policyStatement
+
+// policyRequest is an action a request wants to do on a key
+type policyRequest struct {
+	action policyAction
+	bucket string
+	key    string
+}
+
+// loadPolicy reads the policy from the JSON file
+func loadPolicy(file string) (policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth policy: %w", err)
+	}
+	var p policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse auth policy %q: %w", file, err)
+	}
+	if err := p.check(); err != nil {
+		return nil, fmt.Errorf("invalid auth policy %q: %w", file, err)
+	}
+	return p, nil
+}
+
+// check the policy is valid
+func (p policy) check() error {
+	for accessKey, statements := range p {
+		for i, s := range statements {
+			if s.Bucket == "" {
+				return fmt.Errorf("statement %d for %q: bucket must be set", i+1, accessKey)
+			}
+			if s.Bucket != "*" && strings.ContainsAny(s.Bucket, "/*") {
+				return fmt.Errorf("statement %d for %q: invalid bucket %q", i+1, accessKey, s.Bucket)
+			}
+			if len(s.Actions) == 0 {
+				return fmt.Errorf("statement %d for %q: no actions", i+1, accessKey)
+			}
+			for _, action := range s.Actions {
+				switch action {
+				case actionRead, actionWrite, actionList, actionDelete:
+				default:
+					return fmt.Errorf("statement %d for %q: unknown action %q", i+1, accessKey, action)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchesBucket returns true if the statement is about bucket
+func (s *policyStatement) matchesBucket(bucket string) bool {
+	return s.Bucket == "*" || s.Bucket == bucket
+}
+
+// allowed returns true if accessKey may do the request
+func (p policy) allowed(accessKey string, req policyRequest) bool {
+	for _, s := range p[accessKey] {
+		if s.matchesBucket(req.bucket) && strings.HasPrefix(req.key, s.Prefix) && slices.Contains(s.Actions, req.action) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDotSegments returns true if p has "." or ".." segments.
+//
+// These are cleaned away when the object is found so would let the key
+// escape the prefix it was checked against.
+func hasDotSegments(p string) bool {
+	for segment := range strings.SplitSeq(p, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error if the bucket or key of the request could
+// resolve to something different from what the policy is checked on
+func (req *policyRequest) check() error {
+	key := req.key
+	if req.action == actionList {
+		// only the directory part of a listing prefix is a path
+		key, _ = prefixParser(&gofakes3.Prefix{Prefix: key})
+	}
+	if hasDotSegments(req.bucket) || hasDotSegments(key) {
+		return gofakes3.ErrorInvalidArgument("key", path.Join(req.bucket, req.key), "Keys must not contain . or .. segments")
+	}
+	return nil
+}
+
+// allowedBucket returns true if accessKey may do anything in bucket
+func (p policy) allowedBucket(accessKey, bucket string) bool {
+	for _, s := range p[accessKey] {
+		if s.matchesBucket(bucket) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteRequest is the body of a DeleteObjects request
+type deleteRequest struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// policyRequests returns what the request for object in bucket wants
+// to do.
+//
+// Requests on a bucket which aren't listings need the action to be
+// allowed on the whole bucket.
+func policyRequests(r *http.Request, bucket, object string) ([]policyRequest, error) {
+	query := r.URL.Query()
+	switch {
+	case object == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		return []policyRequest{{actionList, bucket, query.Get("prefix")}}, nil
+	case object == "" && r.Method == http.MethodPut:
+		return []policyRequest{{actionWrite, bucket, ""}}, nil
+	case object == "" && r.Method == http.MethodDelete:
+		if len(query) > 0 {
+			// deleting the configuration of the bucket
+			return []policyRequest{{actionWrite, bucket, ""}}, nil
+		}
+		return []policyRequest{{actionDelete, bucket, ""}}, nil
+	case object == "" && r.Method == http.MethodPost:
+		if _, ok := query["delete"]; !ok {
+			// browser form uploads have the key in the body
+			return []policyRequest{{actionWrite, bucket, ""}}, nil
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var in deleteRequest
+		if err := xml.Unmarshal(body, &in); err != nil {
+			return nil, gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, err.Error())
+		}
+		reqs := make([]policyRequest, 0, len(in.Objects))
+		for _, o := range in.Objects {
+			reqs = append(reqs, policyRequest{actionDelete, bucket, o.Key})
+		}
+		return reqs, nil
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return []policyRequest{{actionRead, bucket, object}}, nil
+	case r.Method == http.MethodPut:
+		reqs := []policyRequest{{actionWrite, bucket, object}}
+		if source := r.Header.Get("x-amz-copy-source"); source != "" {
+			source, _, _ = strings.Cut(source, "?")
+			source, err := url.PathUnescape(source)
+			if err != nil {
+				return nil, gofakes3.ErrorInvalidArgument("x-amz-copy-source", source, "Invalid copy source encoding")
+			}
+			srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+			reqs = append(reqs, policyRequest{actionRead, srcBucket, srcKey})
+		}
+		return reqs, nil
+	case r.Method == http.MethodPost:
+		// multipart uploads
+		return []policyRequest{{actionWrite, bucket, object}}, nil
+	case r.Method == http.MethodDelete:
+		if _, ok := query["uploadId"]; ok {
+			return []policyRequest{{actionWrite, bucket, object}}, nil
+		}
+		if _, ok := query["tagging"]; ok {
+			return []policyRequest{{actionWrite, bucket, object}}, nil
+		}
+		return []policyRequest{{actionDelete, bucket, object}}, nil
+	}
+	return nil, gofakes3.ErrMethodNotAllowed
+}
+
+// policyMiddleware denies the requests which the policy doesn't allow
+// for their access key.
+//
+// The signature of the request is checked after this so the access
+// key can't be forged.
+func policyMiddleware(next http.Handler, ws *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKey, _ := parseAccessKeyID(r)
+		bucket, object := ws.bucketAndObject(r)
+		if bucket != "" {
+			reqs, err := policyRequests(r, bucket, object)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			for _, req := range reqs {
+				if err := req.check(); err != nil {
+					fs.Infof(r.URL.Path, "%s: Access denied by policy for %q: %v", r.RemoteAddr, accessKey, err)
+					writeError(w, r, err)
+					return
+				}
+				if !ws.policy.allowed(accessKey, req) {
+					fs.Infof(r.URL.Path, "%s: Access denied by policy to %s %s for %q", r.RemoteAddr, req.action, path.Join(req.bucket, req.key), accessKey)
+					writeError(w, r, gofakes3.ErrorMessage(errAccessDenied, "Access Denied"))
+					return
+				}
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyAccessKey, accessKey))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedBucket returns true if the bucket should be listed for the
+// access key of the request
+func (w *Server) allowedBucket(ctx context.Context, bucket string) bool {
+	if w.policy == nil {
+		return true
+	}
+	accessKey, _ := ctx.Value(ctxKeyAccessKey).(string)
+	return w.policy.allowedBucket(accessKey, bucket)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"strings"
+	"time"
 
 	"github.com/rclone/rclone/cmd"
 	"github.com/rclone/rclone/cmd/serve"
@@ -33,10 +34,22 @@ var OptionsInfo = fs.Options{{
 	Name:    "auth_key",
 	Default: []string{},
 	Help:    "Set key pair for v4 authorization: access_key_id,secret_access_key",
+}, {
+	Name:    "auth_policy",
+	Default: "",
+	Help:    "Path to a JSON file with the buckets and actions allowed for each access key",
 }, {
 	Name:    "no_cleanup",
 	Default: false,
 	Help:    "Not to cleanup empty folder after object is deleted",
+}, {
+	Name:    "lifecycle_interval",
+	Default: fs.Duration(time.Hour),
+	Help:    "Interval between applying the bucket lifecycle rules, 0 to disable",
+}, {
+	Name:    "max_part_size",
+	Default: fs.SizeSuffix(512 * fs.Mebi),
+	Help:    "Largest multipart upload part accepted as parts are held in memory",
 }}.
 	Add(httplib.ConfigInfo).
 	Add(httplib.AuthConfigInfo)
@@ -44,12 +57,15 @@ var OptionsInfo = fs.Options{{
 // Options contains options for the s3 Server
 type Options struct {
 	//TODO add more options
-	ForcePathStyle bool     `config:"force_path_style"`
-	EtagHash       string   `config:"etag_hash"`
-	AuthKey        []string `config:"auth_key"`
-	NoCleanup      bool     `config:"no_cleanup"`
-	Auth           httplib.AuthConfig
-	HTTP           httplib.Config
+	ForcePathStyle    bool          `config:"force_path_style"`
+	EtagHash          string        `config:"etag_hash"`
+	AuthKey           []string      `config:"auth_key"`
+	AuthPolicy        string        `config:"auth_policy"`
+	NoCleanup         bool          `config:"no_cleanup"`
+	LifecycleInterval fs.Duration   `config:"lifecycle_interval"`
+	MaxPartSize       fs.SizeSuffix `config:"max_part_size"`
+	Auth              httplib.AuthConfig
+	HTTP              httplib.Config
 }
 
 // Opt is options set by command line flags
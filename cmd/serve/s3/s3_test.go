@@ -8,15 +8,21 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	_ "github.com/rclone/rclone/backend/local"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/servetest"
@@ -297,3 +303,517 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+// Serve f and return a minio client connected to it
+func newMinioClient(t *testing.T, f fs.Fs) (*minio.Client, *Server) {
+	endpoint, keyid, keysec, s := serveS3(t, f)
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+	})
+	testURL, _ := url.Parse(endpoint)
+	minioClient, err := minio.New(testURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(keyid, keysec, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+	return minioClient, s
+}
+
+// Read the contents of the object or version
+func getContents(t *testing.T, c *minio.Client, bucket, key, versionID string) string {
+	obj, err := c.GetObject(context.Background(), bucket, key, minio.GetObjectOptions{VersionID: versionID})
+	require.NoError(t, err)
+	defer func() {
+		_ = obj.Close()
+	}()
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// Put an object with contents returning its version ID
+func putContents(t *testing.T, c *minio.Client, bucket, key, contents string, opts minio.PutObjectOptions) string {
+	info, err := c.PutObject(context.Background(), bucket, key, bytes.NewBufferString(contents), int64(len(contents)), opts)
+	require.NoError(t, err)
+	return info.VersionID
+}
+
+// List the versions in the bucket as key, version ID and delete marker
+func listVersions(t *testing.T, c *minio.Client, bucket string) (versions []string) {
+	for object := range c.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: true,
+	}) {
+		require.NoError(t, object.Err)
+		v := object.Key + "@" + object.VersionID
+		if object.IsDeleteMarker {
+			v += " marker"
+		}
+		if object.IsLatest {
+			v += " latest"
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	c, _ := newMinioClient(t, f)
+	const bucket = "versioned"
+	require.NoError(t, c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+	require.NoError(t, c.EnableVersioning(ctx, bucket))
+	config, err := c.GetBucketVersioning(ctx, bucket)
+	require.NoError(t, err)
+	assert.True(t, config.Enabled())
+
+	v1 := putContents(t, c, bucket, "dir/file.txt", "one", minio.PutObjectOptions{})
+	time.Sleep(time.Millisecond)
+	v2 := putContents(t, c, bucket, "dir/file.txt", "two", minio.PutObjectOptions{})
+	require.NotEqual(t, "", v1)
+	require.NotEqual(t, v1, v2)
+	assert.Equal(t, "two", getContents(t, c, bucket, "dir/file.txt", ""))
+	assert.Equal(t, "one", getContents(t, c, bucket, "dir/file.txt", v1))
+	assert.Equal(t, []string{"dir/file.txt@" + v2 + " latest", "dir/file.txt@" + v1}, listVersions(t, c, bucket))
+
+	// The bucket state isn't listed
+	var keys []string
+	for object := range c.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		require.NoError(t, object.Err)
+		keys = append(keys, object.Key)
+	}
+	assert.Equal(t, []string{"dir/file.txt"}, keys)
+
+	// Deleting adds a delete marker
+	require.NoError(t, c.RemoveObject(ctx, bucket, "dir/file.txt", minio.RemoveObjectOptions{}))
+	_, err = c.StatObject(ctx, bucket, "dir/file.txt", minio.StatObjectOptions{})
+	require.Error(t, err)
+	versions := listVersions(t, c, bucket)
+	require.Equal(t, 3, len(versions))
+	marker, _, _ := strings.Cut(strings.TrimPrefix(versions[0], "dir/file.txt@"), " ")
+	assert.Equal(t, "dir/file.txt@"+marker+" marker latest", versions[0])
+
+	// Removing the delete marker restores the object
+	require.NoError(t, c.RemoveObject(ctx, bucket, "dir/file.txt", minio.RemoveObjectOptions{VersionID: marker}))
+	assert.Equal(t, "two", getContents(t, c, bucket, "dir/file.txt", ""))
+
+	// Removing the current version restores the previous one
+	require.NoError(t, c.RemoveObject(ctx, bucket, "dir/file.txt", minio.RemoveObjectOptions{VersionID: v2}))
+	assert.Equal(t, "one", getContents(t, c, bucket, "dir/file.txt", ""))
+	assert.Equal(t, []string{"dir/file.txt@" + v1 + " latest"}, listVersions(t, c, bucket))
+
+	// Suspended versioning overwrites the null version
+	require.NoError(t, c.SuspendVersioning(ctx, bucket))
+	assert.Equal(t, "null", putContents(t, c, bucket, "dir/file.txt", "three", minio.PutObjectOptions{}))
+	assert.Equal(t, "null", putContents(t, c, bucket, "dir/file.txt", "four", minio.PutObjectOptions{}))
+	assert.Equal(t, []string{"dir/file.txt@null latest", "dir/file.txt@" + v1}, listVersions(t, c, bucket))
+
+	// The bucket can't be removed until all the versions are
+	require.NoError(t, c.RemoveObject(ctx, bucket, "dir/file.txt", minio.RemoveObjectOptions{VersionID: "null"}))
+	require.Error(t, c.RemoveBucket(ctx, bucket))
+	require.NoError(t, c.RemoveObject(ctx, bucket, "dir/file.txt", minio.RemoveObjectOptions{VersionID: v1}))
+	assert.Equal(t, []string(nil), listVersions(t, c, bucket))
+	require.NoError(t, c.RemoveBucket(ctx, bucket))
+}
+
+func TestTagging(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	c, _ := newMinioClient(t, f)
+	const bucket = "tagged"
+	require.NoError(t, c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+
+	// Tags set on upload
+	putContents(t, c, bucket, "file.txt", "hello", minio.PutObjectOptions{
+		UserTags: map[string]string{"colour": "blue"},
+	})
+	got, err := c.GetObjectTagging(ctx, bucket, "file.txt", minio.GetObjectTaggingOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"colour": "blue"}, got.ToMap())
+	info, err := c.StatObject(ctx, bucket, "file.txt", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.UserTagCount)
+
+	// Replace the tags
+	newTags, err := tags.NewTags(map[string]string{"a": "1", "b": "2"}, true)
+	require.NoError(t, err)
+	require.NoError(t, c.PutObjectTagging(ctx, bucket, "file.txt", newTags, minio.PutObjectTaggingOptions{}))
+	got, err = c.GetObjectTagging(ctx, bucket, "file.txt", minio.GetObjectTaggingOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, got.ToMap())
+
+	// Tags are copied with the object
+	_, err = c.CopyObject(ctx, minio.CopyDestOptions{Bucket: bucket, Object: "copy.txt"}, minio.CopySrcOptions{Bucket: bucket, Object: "file.txt"})
+	require.NoError(t, err)
+	got, err = c.GetObjectTagging(ctx, bucket, "copy.txt", minio.GetObjectTaggingOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, got.ToMap())
+
+	// Remove the tags
+	require.NoError(t, c.RemoveObjectTagging(ctx, bucket, "file.txt", minio.RemoveObjectTaggingOptions{}))
+	got, err = c.GetObjectTagging(ctx, bucket, "file.txt", minio.GetObjectTaggingOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{}, got.ToMap())
+
+	// Missing objects
+	_, err = c.GetObjectTagging(ctx, bucket, "potato", minio.GetObjectTaggingOptions{})
+	assert.Equal(t, "NoSuchKey", minio.ToErrorResponse(err).Code)
+
+	// Bucket tags
+	_, err = c.GetBucketTagging(ctx, bucket)
+	assert.Equal(t, "NoSuchTagSet", minio.ToErrorResponse(err).Code)
+	require.NoError(t, c.SetBucketTagging(ctx, bucket, newTags))
+	got, err = c.GetBucketTagging(ctx, bucket)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, got.ToMap())
+	require.NoError(t, c.RemoveBucketTagging(ctx, bucket))
+	_, err = c.GetBucketTagging(ctx, bucket)
+	assert.Equal(t, "NoSuchTagSet", minio.ToErrorResponse(err).Code)
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	c, s := newMinioClient(t, f)
+	const bucket = "expiring"
+	require.NoError(t, c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+
+	_, err = c.GetBucketLifecycle(ctx, bucket)
+	assert.Equal(t, "NoSuchLifecycleConfiguration", minio.ToErrorResponse(err).Code)
+
+	config := lifecycle.NewConfiguration()
+	config.Rules = []lifecycle.Rule{{
+		ID:         "logs",
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: "logs/"},
+		Expiration: lifecycle.Expiration{Days: 1},
+	}, {
+		ID:     "old-versions",
+		Status: "Enabled",
+		NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+			NoncurrentDays: 2,
+		},
+	}}
+	require.NoError(t, c.SetBucketLifecycle(ctx, bucket, config))
+	got, err := c.GetBucketLifecycle(ctx, bucket)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(got.Rules))
+	assert.Equal(t, "logs/", got.Rules[0].RuleFilter.Prefix)
+	assert.Equal(t, lifecycle.ExpirationDays(1), got.Rules[0].Expiration.Days)
+	assert.Equal(t, lifecycle.ExpirationDays(2), got.Rules[1].NoncurrentVersionExpiration.NoncurrentDays)
+
+	require.NoError(t, c.EnableVersioning(ctx, bucket))
+	putContents(t, c, bucket, "logs/1.log", "log", minio.PutObjectOptions{})
+	putContents(t, c, bucket, "data.txt", "old", minio.PutObjectOptions{})
+	time.Sleep(time.Millisecond)
+	v2 := putContents(t, c, bucket, "data.txt", "new", minio.PutObjectOptions{})
+
+	// Nothing has expired yet
+	s.backend.applyLifecycle(ctx, s._vfs, time.Now())
+	assert.Equal(t, 3, len(listVersions(t, c, bucket)))
+
+	// The log expires to a delete marker and the marker makes the
+	// log noncurrent
+	s.backend.applyLifecycle(ctx, s._vfs, time.Now().Add(day+time.Hour))
+	_, err = c.StatObject(ctx, bucket, "logs/1.log", minio.StatObjectOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, "new", getContents(t, c, bucket, "data.txt", ""))
+	assert.Equal(t, 4, len(listVersions(t, c, bucket)))
+
+	// The noncurrent versions expire
+	s.backend.applyLifecycle(ctx, s._vfs, time.Now().Add(3*day))
+	versions := listVersions(t, c, bucket)
+	require.Equal(t, 2, len(versions))
+	assert.Equal(t, "data.txt@"+v2+" latest", versions[0])
+	assert.Contains(t, versions[1], " marker latest")
+
+	require.NoError(t, c.SetBucketLifecycle(ctx, bucket, lifecycle.NewConfiguration())) // removes the lifecycle
+	_, err = c.GetBucketLifecycle(ctx, bucket)
+	assert.Equal(t, "NoSuchLifecycleConfiguration", minio.ToErrorResponse(err).Code)
+}
+
+func TestPresignedURLs(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	c, _ := newMinioClient(t, f)
+	const bucket = "presigned"
+	require.NoError(t, c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+
+	putURL, err := c.PresignedPutObject(ctx, bucket, "upload.txt", time.Hour)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPut, putURL.String(), strings.NewReader("uploaded"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	getURL, err := c.PresignedGetObject(ctx, bucket, "upload.txt", time.Hour, nil)
+	require.NoError(t, err)
+	resp, err = http.Get(getURL.String())
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "uploaded", string(body))
+
+	// Tampering with the URL invalidates the signature
+	tampered := strings.Replace(getURL.String(), "upload.txt", "other.txt", 1)
+	resp, err = http.Get(tampered)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, bucket := range []string{"team-a", "team-b"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, bucket, "reports"), 0777))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, bucket, "reports", "q1.txt"), []byte("q1"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, bucket, "secret.txt"), []byte("secret"), 0666))
+	}
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`{
+		"alice": [{"bucket": "team-a", "actions": ["read", "write", "list", "delete"]}],
+		"bob": [
+			{"bucket": "team-a", "prefix": "reports/", "actions": ["read", "list"]},
+			{"bucket": "team-b", "actions": ["read", "list"]}
+		]
+	}`), 0666))
+
+	opt := Opt // copy default options
+	opt.AuthKey = []string{"alice,alicesecret", "bob,bobsecret"}
+	opt.AuthPolicy = policyFile
+	opt.HTTP.ListenAddr = []string{endpoint}
+	s, err := newServer(ctx, f, &opt, &vfscommon.Opt, &proxy.Opt)
+	require.NoError(t, err)
+	go func() {
+		require.NoError(t, s.Serve())
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+	})
+	testURL, err := url.Parse(s.server.URLs()[0])
+	require.NoError(t, err)
+	client := func(keyID, keySecret string) *minio.Client {
+		c, err := minio.New(testURL.Host, &minio.Options{
+			Creds: credentials.NewStaticV4(keyID, keySecret, ""),
+		})
+		require.NoError(t, err)
+		return c
+	}
+	alice, bob := client("alice", "alicesecret"), client("bob", "bobsecret")
+	isDenied := func(err error) bool {
+		return minio.ToErrorResponse(err).Code == "AccessDenied"
+	}
+	listBuckets := func(c *minio.Client) (names []string) {
+		buckets, err := c.ListBuckets(ctx)
+		require.NoError(t, err)
+		for _, bucket := range buckets {
+			names = append(names, bucket.Name)
+		}
+		return names
+	}
+
+	// Only the buckets in the policy are listed
+	assert.Equal(t, []string{"team-a"}, listBuckets(alice))
+	assert.Equal(t, []string{"team-a", "team-b"}, listBuckets(bob))
+
+	// Alice can do anything in team-a but nothing in team-b
+	putContents(t, alice, "team-a", "new.txt", "new", minio.PutObjectOptions{})
+	assert.Equal(t, "secret", getContents(t, alice, "team-a", "secret.txt", ""))
+	require.NoError(t, alice.RemoveObject(ctx, "team-a", "new.txt", minio.RemoveObjectOptions{}))
+	_, err = alice.StatObject(ctx, "team-b", "secret.txt", minio.StatObjectOptions{})
+	assert.Error(t, err)
+	_, err = alice.PutObject(ctx, "team-b", "new.txt", strings.NewReader("new"), 3, minio.PutObjectOptions{})
+	assert.True(t, isDenied(err), err)
+
+	// Bob can only read and list reports in team-a
+	assert.Equal(t, "q1", getContents(t, bob, "team-a", "reports/q1.txt", ""))
+	for object := range bob.ListObjects(ctx, "team-a", minio.ListObjectsOptions{Prefix: "reports/"}) {
+		require.NoError(t, object.Err)
+		assert.Equal(t, "reports/q1.txt", object.Key)
+	}
+	for object := range bob.ListObjects(ctx, "team-a", minio.ListObjectsOptions{}) {
+		assert.True(t, isDenied(object.Err), object.Err)
+	}
+	_, err = bob.StatObject(ctx, "team-a", "secret.txt", minio.StatObjectOptions{})
+	assert.Error(t, err)
+	_, err = bob.PutObject(ctx, "team-a", "reports/q2.txt", strings.NewReader("q2"), 2, minio.PutObjectOptions{})
+	assert.True(t, isDenied(err), err)
+	err = bob.RemoveObject(ctx, "team-b", "secret.txt", minio.RemoveObjectOptions{})
+	assert.True(t, isDenied(err), err)
+	assert.Equal(t, "secret", getContents(t, bob, "team-b", "secret.txt", ""))
+
+	// Copying needs read access to the source
+	_, err = alice.CopyObject(ctx, minio.CopyDestOptions{Bucket: "team-a", Object: "copy.txt"}, minio.CopySrcOptions{Bucket: "team-b", Object: "secret.txt"})
+	assert.True(t, isDenied(err), err)
+
+	// Multiple deletes are checked object by object
+	objects := make(chan minio.ObjectInfo, 1)
+	objects <- minio.ObjectInfo{Key: "secret.txt"}
+	close(objects)
+	for result := range bob.RemoveObjects(ctx, "team-b", objects, minio.RemoveObjectsOptions{}) {
+		assert.True(t, isDenied(result.Err), result.Err)
+	}
+
+	// Presigned URLs are checked too
+	getURL, err := bob.PresignedGetObject(ctx, "team-a", "secret.txt", time.Hour, nil)
+	require.NoError(t, err)
+	resp, err := http.Get(getURL.String())
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Dot segments can't be used to escape the prefix or the bucket
+	for _, key := range []string{"reports/../secret.txt", "reports/../../team-b/secret.txt", "reports/./q1.txt"} {
+		getURL, err := bob.PresignedGetObject(ctx, "team-a", key, time.Hour, nil)
+		require.NoError(t, err)
+		resp, err := http.Get(getURL.String())
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode, key)
+		assert.NotContains(t, string(body), "secret", key)
+	}
+	_, err = alice.CopyObject(ctx, minio.CopyDestOptions{Bucket: "team-a", Object: "copy.txt"}, minio.CopySrcOptions{Bucket: "team-a", Object: "../team-b/secret.txt"})
+	assert.Error(t, err)
+	for object := range bob.ListObjects(ctx, "team-a", minio.ListObjectsOptions{Prefix: "reports/../"}) {
+		assert.Error(t, object.Err)
+	}
+}
+
+// chunkWriterFs adds OpenChunkWriter to an Fs
+type chunkWriterFs struct {
+	fs.Fs
+	mu     sync.Mutex
+	chunks int // number of chunks written
+}
+
+// Features returns the optional features of the Fs with OpenChunkWriter
+func (f *chunkWriterFs) Features() *fs.Features {
+	features := *f.Fs.Features()
+	features.OpenChunkWriter = f.OpenChunkWriter
+	return &features
+}
+
+// OpenChunkWriter returns a writer which puts the chunks to the Fs on Close
+func (f *chunkWriterFs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (fs.ChunkWriterInfo, fs.ChunkWriter, error) {
+	return fs.ChunkWriterInfo{ChunkSize: 5 * 1024 * 1024, Concurrency: 1}, &testChunkWriter{f: f, remote: remote, modTime: src.ModTime(ctx), chunks: map[int][]byte{}}, nil
+}
+
+type testChunkWriter struct {
+	f       *chunkWriterFs
+	remote  string
+	modTime time.Time
+	chunks  map[int][]byte
+}
+
+func (w *testChunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (int64, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	w.chunks[chunkNumber] = data
+	w.f.chunks++
+	return int64(len(data)), nil
+}
+
+func (w *testChunkWriter) Close(ctx context.Context) error {
+	var data []byte
+	for i := range len(w.chunks) {
+		data = append(data, w.chunks[i]...)
+	}
+	src := object.NewStaticObjectInfo(w.remote, w.modTime, int64(len(data)), true, nil, w.f)
+	_, err := w.f.Fs.Put(ctx, bytes.NewReader(data), src)
+	return err
+}
+
+func (w *testChunkWriter) Abort(ctx context.Context) error {
+	return nil
+}
+
+func TestMultipartChunkWriter(t *testing.T) {
+	ctx := context.Background()
+	local, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	f := &chunkWriterFs{Fs: local}
+	c, s := newMinioClient(t, f)
+	const bucket = "multipart"
+	require.NoError(t, c.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}))
+
+	// Upload an object in 3 parts
+	const partSize = 5 * 1024 * 1024
+	contents := random.String(2*partSize + 1024)
+	putContents(t, c, bucket, "dir/big.bin", contents, minio.PutObjectOptions{PartSize: partSize})
+	assert.Equal(t, 3, f.chunks)
+	assert.Equal(t, contents, getContents(t, c, bucket, "dir/big.bin", ""))
+	info, err := c.StatObject(ctx, bucket, "dir/big.bin", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), info.Size)
+
+	// Uploads in progress can be listed and aborted
+	core := minio.Core{Client: c}
+	uploadID, err := core.NewMultipartUpload(ctx, bucket, "aborted.bin", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	part, err := core.PutObjectPart(ctx, bucket, "aborted.bin", uploadID, 1, strings.NewReader("part"), 4, minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+	parts, err := core.ListObjectParts(ctx, bucket, "aborted.bin", uploadID, 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(parts.ObjectParts))
+	assert.Equal(t, part.ETag, strings.Trim(parts.ObjectParts[0].ETag, `"`))
+	uploads, err := core.ListMultipartUploads(ctx, bucket, "", "", "", "", 100)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(uploads.Uploads))
+	assert.Equal(t, "aborted.bin", uploads.Uploads[0].Key)
+	require.NoError(t, core.AbortMultipartUpload(ctx, bucket, "aborted.bin", uploadID))
+	uploads, err = core.ListMultipartUploads(ctx, bucket, "", "", "", "", 100)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(uploads.Uploads))
+	_, err = c.StatObject(ctx, bucket, "aborted.bin", minio.StatObjectOptions{})
+	assert.Error(t, err)
+
+	// Parts must be numbered from 1 with no gaps
+	uploadID, err = core.NewMultipartUpload(ctx, bucket, "gap.bin", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	part, err = core.PutObjectPart(ctx, bucket, "gap.bin", uploadID, 2, strings.NewReader("part"), 4, minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+	_, err = core.CompleteMultipartUpload(ctx, bucket, "gap.bin", uploadID, []minio.CompletePart{{PartNumber: 2, ETag: part.ETag}}, minio.PutObjectOptions{})
+	assert.Equal(t, "InvalidPart", minio.ToErrorResponse(err).Code)
+
+	// A part uploaded again replaces the first one
+	uploadID, err = core.NewMultipartUpload(ctx, bucket, "retried.bin", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	first, err := core.PutObjectPart(ctx, bucket, "retried.bin", uploadID, 1, strings.NewReader("first"), 5, minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+	part, err = core.PutObjectPart(ctx, bucket, "retried.bin", uploadID, 1, strings.NewReader("second"), 6, minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+	_, err = core.CompleteMultipartUpload(ctx, bucket, "retried.bin", uploadID, []minio.CompletePart{{PartNumber: 1, ETag: first.ETag}}, minio.PutObjectOptions{})
+	assert.Equal(t, "InvalidPart", minio.ToErrorResponse(err).Code)
+	_, err = core.CompleteMultipartUpload(ctx, bucket, "retried.bin", uploadID, []minio.CompletePart{{PartNumber: 1, ETag: part.ETag}}, minio.PutObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "second", getContents(t, c, bucket, "retried.bin", ""))
+
+	// Parts bigger than --max-part-size are rejected
+	s.opt.MaxPartSize = 5
+	uploadID, err = core.NewMultipartUpload(ctx, bucket, "too-big.bin", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	_, err = core.PutObjectPart(ctx, bucket, "too-big.bin", uploadID, 1, strings.NewReader("too big"), 7, minio.PutObjectPartOptions{})
+	assert.Equal(t, "EntityTooLarge", minio.ToErrorResponse(err).Code)
+	_, err = core.PutObjectPart(ctx, bucket, "too-big.bin", uploadID, 1, strings.NewReader("small"), 5, minio.PutObjectPartOptions{})
+	require.NoError(t, err)
+	require.NoError(t, core.AbortMultipartUpload(ctx, bucket, "too-big.bin", uploadID))
+}
@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rclone/gofakes3"
@@ -26,7 +27,8 @@ import (
 type ctxKey int
 
 const (
-	ctxKeyID ctxKey = iota
+	ctxKeyID        ctxKey = iota
+	ctxKeyAccessKey        // access key ID of the request if using a policy
 )
 
 // Server is a s3.FileSystem interface
@@ -36,11 +38,14 @@ type Server struct {
 	f            fs.Fs
 	_vfs         *vfs.VFS // don't use directly, use getVFS
 	faker        *gofakes3.GoFakeS3
+	backend      *s3Backend
 	handler      http.Handler
 	proxy        *proxy.Proxy
 	ctx          context.Context // for global config
 	s3Secret     string
 	etagHashType hash.Type
+	stop         context.CancelFunc // stops the background tasks
+	policy       policy             // which access keys can do what, if set
 }
 
 // Make a new S3 Server to serve the remote
@@ -70,19 +75,39 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		w.s3Secret = getAuthSecret(opt.AuthKey)
 	}
 
+	if opt.AuthPolicy != "" {
+		if len(opt.AuthKey) == 0 && proxy.Opt.AuthProxy == "" {
+			return nil, errors.New("--auth-policy needs --auth-key or --auth-proxy")
+		}
+		w.policy, err = loadPolicy(opt.AuthPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var newLogger logger
-	w.faker = gofakes3.New(
-		newBackend(w),
+	w.backend = newBackend(w)
+	fakerOpts := []gofakes3.Option{
 		gofakes3.WithHostBucket(!opt.ForcePathStyle),
 		gofakes3.WithLogger(newLogger),
 		gofakes3.WithRequestID(rand.Uint64()),
-		gofakes3.WithoutVersioning(),
 		gofakes3.WithV4Auth(authlistResolver(opt.AuthKey)),
 		gofakes3.WithIntegrityCheck(true), // Check Content-MD5 if supplied
-	)
+	}
+	if proxy.Opt.AuthProxy != "" {
+		// The versioning API doesn't pass the context which holds
+		// the VFS of the user
+		fakerOpts = append(fakerOpts, gofakes3.WithoutVersioning())
+	}
+	w.faker = gofakes3.New(w.backend, fakerOpts...)
 
 	w.handler = w.faker.Server()
+	w.handler = extensionsMiddleware(w.handler, w)
+	if w.policy != nil {
+		w.handler = policyMiddleware(w.handler, w)
+	}
 
+	ctx, w.stop = context.WithCancel(ctx)
 	if proxy.Opt.AuthProxy != "" {
 		w.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
 		// proxy auth middleware
@@ -94,6 +119,10 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		if len(opt.AuthKey) > 0 {
 			w.faker.AddAuthKeys(authlistResolver(opt.AuthKey))
 		}
+
+		if opt.LifecycleInterval > 0 {
+			go w.backend.runLifecycle(ctx, time.Duration(opt.LifecycleInterval))
+		}
 	}
 
 	w.server, err = httplib.NewServer(ctx,
@@ -156,9 +185,85 @@ func (w *Server) Addr() net.Addr {
 
 // Shutdown the server
 func (w *Server) Shutdown() error {
+	w.stop()
+	w.backend.abortUploads(context.Background())
 	return w.server.Shutdown()
 }
 
+// extensionsMiddleware serves the object tagging and bucket lifecycle
+// APIs which gofakes3 doesn't implement.
+//
+// Multipart uploads to remotes which can write chunks are served here
+// so the parts can be written to the backend as they arrive.
+//
+// It also serves the parts of the versioning API which gofakes3 gets
+// wrong: URL encoded version listings, HEAD of versions and deleting
+// null versions, as gofakes3 treats versionId=null as no version.
+func extensionsMiddleware(next http.Handler, ws *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isTagging := query["tagging"]
+		_, isLifecycle := query["lifecycle"]
+		_, isVersions := query["versions"]
+		isListVersions := isVersions && r.Method == http.MethodGet && query.Get("encoding-type") == "url" && ws.proxy == nil
+		versionID := gofakes3.VersionID(query.Get("versionId"))
+		isHeadVersion := r.Method == http.MethodHead && versionID != "" && ws.proxy == nil
+		isDeleteNull := r.Method == http.MethodDelete && versionID == nullVersion && ws.proxy == nil
+		bucket, object := ws.bucketAndObject(r)
+		var chunkWriterVFS *vfs.VFS
+		if bucket != "" && isMultipartRequest(r, object) {
+			chunkWriterVFS = ws.backend.chunkWriterVFS(r.Context())
+		}
+		isMultipart := chunkWriterVFS != nil
+		if bucket == "" || !(isTagging || isLifecycle && object == "" || isListVersions && object == "" || (isHeadVersion || isDeleteNull) && object != "" || isMultipart) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// check the signature as gofakes3 would
+		if len(ws.opt.AuthKey) > 0 || ws.proxy != nil {
+			if result := signature.V4SignVerify(r); result != signature.ErrNone {
+				fs.Infof(r.URL.Path, "%s: Access denied", r.RemoteAddr)
+				resp := signature.GetAPIError(result)
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(resp.HTTPStatusCode)
+				_, _ = w.Write(signature.EncodeAPIErrorToResponse(resp))
+				return
+			}
+		}
+
+		var err error
+		switch {
+		case isMultipart:
+			err = ws.backend.serveMultipart(w, r, chunkWriterVFS, bucket, object)
+		case isTagging:
+			err = ws.backend.serveTagging(w, r, bucket, object)
+		case isLifecycle:
+			err = ws.backend.serveLifecycle(w, r, bucket)
+		case isListVersions:
+			err = ws.backend.serveListVersions(w, r, bucket)
+		case isHeadVersion:
+			err = ws.backend.serveHeadVersion(w, r, bucket, object, versionID)
+		default:
+			err = ws.backend.serveDeleteVersion(w, bucket, object, nullVersion)
+		}
+		if err != nil {
+			writeError(w, r, err)
+		}
+	})
+}
+
+// bucketAndObject returns the bucket and the object the request is for
+func (w *Server) bucketAndObject(r *http.Request) (bucket, object string) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if !w.opt.ForcePathStyle {
+		bucket, _, _ = strings.Cut(r.Host, ".")
+		return bucket, p
+	}
+	bucket, object, _ = strings.Cut(p, "/")
+	return bucket, object
+}
+
 func authPairMiddleware(next http.Handler, ws *Server) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessKey, _ := parseAccessKeyID(r)
@@ -188,6 +293,13 @@ func proxyAuthMiddleware(next http.Handler, ws *Server) http.Handler {
 
 func parseAccessKeyID(r *http.Request) (accessKey string, error signature.ErrorCode) {
 	v4Auth := r.Header.Get("Authorization")
+	if v4Auth == "" {
+		// presigned URLs have the credential in the query
+		if credential := r.URL.Query().Get("X-Amz-Credential"); credential != "" {
+			accessKey, _, _ = strings.Cut(credential, "/")
+			return accessKey, signature.ErrNone
+		}
+	}
 	req, err := signature.ParseSignV4(v4Auth)
 	if err != signature.ErrNone {
 		return "", err
@@ -0,0 +1,297 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+const (
+	tagsMetaKey     = "X-Amz-Tagging" // key of the tags in the object metadata, as set by the PUT header
+	tagsMetadataKey = "x-amz-tagging" // key of the tags in the rclone metadata of the object
+	maxTags         = 10
+	maxTagKeyLen    = 128
+	maxTagValueLen  = 256
+)
+
+// Error codes for the APIs which gofakes3 doesn't know about
+const (
+	errNoSuchTagSet                 gofakes3.ErrorCode = "NoSuchTagSet"
+	errNoSuchLifecycleConfiguration gofakes3.ErrorCode = "NoSuchLifecycleConfiguration"
+	errInvalidTag                   gofakes3.ErrorCode = "InvalidTag"
+	errAccessDenied                 gofakes3.ErrorCode = "AccessDenied"
+	errEntityTooLarge               gofakes3.ErrorCode = "EntityTooLarge"
+)
+
+// tagging is the document used by the tagging APIs
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	TagSet  []tag    `xml:"TagSet>Tag"`
+}
+
+// tag is a single key value pair
+type tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// parseTags checks the tags and returns them as url.Values
+func parseTags(tags []tag) (url.Values, error) {
+	if len(tags) > maxTags {
+		return nil, gofakes3.ErrorMessagef(errInvalidTag, "Object tags cannot be greater than %d", maxTags)
+	}
+	values := url.Values{}
+	for _, t := range tags {
+		switch {
+		case t.Key == "" || len(t.Key) > maxTagKeyLen:
+			return nil, gofakes3.ErrorMessage(errInvalidTag, "The TagKey you have provided is invalid")
+		case len(t.Value) > maxTagValueLen:
+			return nil, gofakes3.ErrorMessage(errInvalidTag, "The TagValue you have provided is invalid")
+		case strings.HasPrefix(t.Key, "aws:"):
+			return nil, gofakes3.ErrorMessage(errInvalidTag, "Your TagKey cannot be prefixed with aws:")
+		case values.Has(t.Key):
+			return nil, gofakes3.ErrorMessage(errInvalidTag, "Cannot provide multiple Tags with the same key")
+		}
+		values.Set(t.Key, t.Value)
+	}
+	return values, nil
+}
+
+// newTagging makes the tagging document for tags
+func newTagging(tags url.Values) *tagging {
+	result := &tagging{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		TagSet: []tag{},
+	}
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		result.TagSet = append(result.TagSet, tag{Key: key, Value: tags.Get(key)})
+	}
+	return result
+}
+
+// tagsToCount replaces the tags in meta with their count as returned
+// by S3 on GET and HEAD
+func tagsToCount(meta map[string]string) {
+	tags, ok := meta[tagsMetaKey]
+	if !ok {
+		return
+	}
+	delete(meta, tagsMetaKey)
+	if values, err := url.ParseQuery(tags); err == nil && len(values) > 0 {
+		meta["X-Amz-Tagging-Count"] = strconv.Itoa(len(values))
+	}
+}
+
+// getTags returns the tags of the object node at fp.
+//
+// These are read from the object metadata, falling back to the rclone
+// metadata of the object if the backend supports it.
+func (b *s3Backend) getTags(ctx context.Context, fp string, node vfs.Node) url.Values {
+	tags, found := "", false
+	if val, ok := b.meta.Load(fp); ok {
+		tags, found = val.(map[string]string)[tagsMetaKey]
+	}
+	if o, ok := node.DirEntry().(fs.Object); ok && !found {
+		if metadata, err := fs.GetMetadata(ctx, o); err == nil {
+			tags = metadata[tagsMetadataKey]
+		}
+	}
+	values, err := url.ParseQuery(tags)
+	if err != nil {
+		return url.Values{}
+	}
+	return values
+}
+
+// setTags stores the tags of the object node at fp in the object
+// metadata and in the rclone metadata of the object if the backend
+// supports it.
+func (b *s3Backend) setTags(ctx context.Context, fp string, node vfs.Node, tags url.Values) {
+	encoded := tags.Encode()
+	meta := map[string]string{}
+	if val, ok := b.meta.Load(fp); ok {
+		maps.Copy(meta, val.(map[string]string))
+	}
+	meta[tagsMetaKey] = encoded
+	b.meta.Store(fp, meta)
+
+	o, ok := node.DirEntry().(fs.Object)
+	if !ok || !o.Fs().Features().UserMetadata {
+		return
+	}
+	if do, ok := o.(fs.SetMetadataer); ok {
+		err := do.SetMetadata(ctx, fs.Metadata{tagsMetadataKey: encoded})
+		if err != nil {
+			fs.Debugf(o, "Failed to store tags in metadata: %v", err)
+		}
+	}
+}
+
+// taggedObject finds the object or the version of it the tagging
+// request is for
+func (b *s3Backend) taggedObject(_vfs *vfs.VFS, bucket, key string, versionID string) (fp string, node vfs.Node, err error) {
+	if versionID == "" {
+		fp = path.Join(bucket, key)
+		node, err = _vfs.Stat(fp)
+		if err != nil || !node.IsFile() || isReserved(key) {
+			return "", nil, gofakes3.KeyNotFound(key)
+		}
+		return fp, node, nil
+	}
+	v, err := b.findVersion(_vfs, bucket, key, gofakes3.VersionID(versionID))
+	if err != nil {
+		return "", nil, err
+	}
+	if v.Marker {
+		return "", nil, gofakes3.ErrMethodNotAllowed
+	}
+	return v.Path, v.Node, nil
+}
+
+// readXML decodes the XML request body into out
+func readXML(r *http.Request, out any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(body, out); err != nil {
+		return gofakes3.ErrorMessage(gofakes3.ErrMalformedXML, err.Error())
+	}
+	return nil
+}
+
+// writeXML writes v as the XML response
+func writeXML(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as an S3 error response
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var resp *gofakes3.ErrorResponse
+	switch e := err.(type) {
+	case *gofakes3.ErrorResponse:
+		resp = e
+	case interface{ ErrorCode() gofakes3.ErrorCode }:
+		resp = &gofakes3.ErrorResponse{Code: e.ErrorCode(), Message: e.ErrorCode().Message()}
+	default:
+		fs.Errorf("serve s3", "%s %s failed: %v", r.Method, r.URL, err)
+		resp = &gofakes3.ErrorResponse{Code: gofakes3.ErrInternal, Message: "Internal Error"}
+	}
+	status := resp.Code.Status()
+	switch resp.Code {
+	case errNoSuchTagSet, errNoSuchLifecycleConfiguration:
+		status = http.StatusNotFound
+	case errInvalidTag, errEntityTooLarge:
+		status = http.StatusBadRequest
+	case errAccessDenied:
+		status = http.StatusForbidden
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write([]byte(xml.Header))
+		_ = xml.NewEncoder(w).Encode(resp)
+	}
+}
+
+// serveTagging serves the tagging APIs of the bucket or of the object
+// if key is set
+func (b *s3Backend) serveTagging(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	ctx := r.Context()
+	_vfs, err := b.s.getVFS(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return gofakes3.BucketNotFound(bucket)
+	}
+	if key == "" {
+		return b.serveBucketTagging(w, r, _vfs, bucket)
+	}
+	versionID := r.URL.Query().Get("versionId")
+	fp, node, err := b.taggedObject(_vfs, bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return writeXML(w, newTagging(b.getTags(ctx, fp, node)))
+	case http.MethodPut:
+		var in tagging
+		if err := readXML(r, &in); err != nil {
+			return err
+		}
+		tags, err := parseTags(in.TagSet)
+		if err != nil {
+			return err
+		}
+		b.setTags(ctx, fp, node, tags)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		b.setTags(ctx, fp, node, url.Values{})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return gofakes3.ErrMethodNotAllowed
+	}
+	return nil
+}
+
+// serveBucketTagging serves the tagging APIs of the bucket
+func (b *s3Backend) serveBucketTagging(w http.ResponseWriter, r *http.Request, _vfs *vfs.VFS, bucket string) error {
+	switch r.Method {
+	case http.MethodGet:
+		cfg, err := b.getBucketConfig(_vfs, bucket)
+		if err != nil {
+			return err
+		}
+		tags, err := url.ParseQuery(cfg.Tags)
+		if err != nil || len(tags) == 0 {
+			return gofakes3.ErrorMessage(errNoSuchTagSet, "The TagSet does not exist")
+		}
+		return writeXML(w, newTagging(tags))
+	case http.MethodPut:
+		var in tagging
+		if err := readXML(r, &in); err != nil {
+			return err
+		}
+		tags, err := parseTags(in.TagSet)
+		if err != nil {
+			return err
+		}
+		err = b.updateBucketConfig(_vfs, bucket, func(cfg *bucketConfig) {
+			cfg.Tags = tags.Encode()
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err := b.updateBucketConfig(_vfs, bucket, func(cfg *bucketConfig) {
+			cfg.Tags = ""
+		})
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		return gofakes3.ErrMethodNotAllowed
+	}
+	return nil
+}
@@ -0,0 +1,682 @@
+package s3
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+)
+
+// Versioning is emulated by keeping the state of each bucket in a
+// hidden directory in the bucket which isn't listed. The noncurrent
+// versions of an object are kept in a directory for each key
+//
+//	bucket/.rclone_s3/bucket.json                - bucket configuration
+//	bucket/.rclone_s3/versions/<key>/<id>        - a noncurrent version
+//	bucket/.rclone_s3/versions/<key>/<id>.marker - a delete marker
+//	bucket/.rclone_s3/versions/<key>/current     - version ID of the object in the bucket
+//
+// where <key> is the path escaped object key.
+const (
+	metaDir          = ".rclone_s3"
+	bucketConfigFile = "bucket.json"
+	versionsDir      = "versions"
+	currentFile      = "current"
+	markerSuffix     = ".marker"
+	nullVersion      = gofakes3.VersionID("null")
+)
+
+// bucketConfig is the configuration of a bucket
+type bucketConfig struct {
+	Versioning gofakes3.VersioningStatus `json:"versioning,omitempty"`
+	Tags       string                    `json:"tags,omitempty"` // URL query encoded
+	Lifecycle  *lifecycleConfiguration   `json:"lifecycle,omitempty"`
+}
+
+// bucketKey identifies a bucket in a VFS
+type bucketKey struct {
+	vfs    *vfs.VFS
+	bucket string
+}
+
+// isReserved returns true if objectName is used for the bucket state
+func isReserved(objectName string) bool {
+	return objectName == metaDir || strings.HasPrefix(objectName, metaDir+"/")
+}
+
+// getBucketConfig returns the configuration of bucket
+func (b *s3Backend) getBucketConfig(_vfs *vfs.VFS, bucket string) (bucketConfig, error) {
+	b.configMu.Lock()
+	defer b.configMu.Unlock()
+	return b.loadBucketConfig(_vfs, bucket)
+}
+
+// loadBucketConfig reads the configuration of bucket - call with
+// configMu held
+func (b *s3Backend) loadBucketConfig(_vfs *vfs.VFS, bucket string) (cfg bucketConfig, err error) {
+	key := bucketKey{vfs: _vfs, bucket: bucket}
+	if cfg, ok := b.configs[key]; ok {
+		return cfg, nil
+	}
+	data, err := _vfs.ReadFile(path.Join(bucket, metaDir, bucketConfigFile))
+	if err == nil {
+		if err = json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("corrupted bucket config for %q: %w", bucket, err)
+		}
+	} else if !errors.Is(err, vfs.ENOENT) {
+		return cfg, err
+	}
+	b.configs[key] = cfg
+	return cfg, nil
+}
+
+// updateBucketConfig calls fn to modify the configuration of bucket
+// and saves it
+func (b *s3Backend) updateBucketConfig(_vfs *vfs.VFS, bucket string, fn func(cfg *bucketConfig)) error {
+	b.configMu.Lock()
+	defer b.configMu.Unlock()
+	cfg, err := b.loadBucketConfig(_vfs, bucket)
+	if err != nil {
+		return err
+	}
+	fn(&cfg)
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	dir := path.Join(bucket, metaDir)
+	if err := mkdirRecursive(dir, _vfs); err != nil {
+		return err
+	}
+	if err := _vfs.WriteFile(path.Join(dir, bucketConfigFile), data, 0666); err != nil {
+		return err
+	}
+	b.configs[bucketKey{vfs: _vfs, bucket: bucket}] = cfg
+	return nil
+}
+
+// forgetBucketConfig removes the cached configuration of bucket
+func (b *s3Backend) forgetBucketConfig(_vfs *vfs.VFS, bucket string) {
+	b.configMu.Lock()
+	delete(b.configs, bucketKey{vfs: _vfs, bucket: bucket})
+	b.configMu.Unlock()
+}
+
+// versioningStatus returns the versioning status of bucket
+func (b *s3Backend) versioningStatus(_vfs *vfs.VFS, bucket string) (gofakes3.VersioningStatus, error) {
+	cfg, err := b.getBucketConfig(_vfs, bucket)
+	return cfg.Versioning, err
+}
+
+// setVersionID sets the version ID of obj read from the bucket if
+// the bucket is versioned
+func (b *s3Backend) setVersionID(_vfs *vfs.VFS, bucket, key string, obj *gofakes3.Object) error {
+	versioning, err := b.versioningStatus(_vfs, bucket)
+	if err != nil {
+		return err
+	}
+	if versioning != gofakes3.VersioningNone {
+		obj.VersionID = currentVersionID(_vfs, bucket, key)
+	}
+	return nil
+}
+
+// newVersionID makes a new version ID which sorts in time order
+func newVersionID() gofakes3.VersionID {
+	return gofakes3.VersionID(fmt.Sprintf("%016x%s", time.Now().UnixNano(), random.String(8)))
+}
+
+// versionTime returns the time the version with id was made
+func versionTime(id gofakes3.VersionID) (time.Time, bool) {
+	if len(id) < 16 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(string(id[:16]), 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// keyVersionsDir returns the directory holding the versions of key
+func keyVersionsDir(bucket, key string) string {
+	return path.Join(bucket, metaDir, versionsDir, url.PathEscape(key))
+}
+
+// objectVersion describes a version of an object
+type objectVersion struct {
+	ID      gofakes3.VersionID
+	Marker  bool   // set if this is a delete marker
+	Current bool   // set if this is the object in the bucket
+	Path    string // path of the version in the VFS
+	Node    vfs.Node
+}
+
+// created returns the time the version was made
+func (v *objectVersion) created() time.Time {
+	if t, ok := versionTime(v.ID); ok {
+		return t
+	}
+	return v.Node.ModTime()
+}
+
+// currentVersionID returns the version ID of the object in the bucket
+func currentVersionID(_vfs *vfs.VFS, bucket, key string) gofakes3.VersionID {
+	data, err := _vfs.ReadFile(path.Join(keyVersionsDir(bucket, key), currentFile))
+	if err != nil || len(data) == 0 {
+		return nullVersion
+	}
+	return gofakes3.VersionID(data)
+}
+
+// setCurrentVersionID sets the version ID of the object in the bucket
+func setCurrentVersionID(_vfs *vfs.VFS, bucket, key string, id gofakes3.VersionID) error {
+	dir := keyVersionsDir(bucket, key)
+	if id == nullVersion {
+		err := _vfs.Remove(path.Join(dir, currentFile))
+		if err != nil && !errors.Is(err, vfs.ENOENT) {
+			return err
+		}
+		return nil
+	}
+	if err := mkdirRecursive(dir, _vfs); err != nil {
+		return err
+	}
+	return _vfs.WriteFile(path.Join(dir, currentFile), []byte(id), 0666)
+}
+
+// objectVersions returns all the versions of key, newest first
+func (b *s3Backend) objectVersions(_vfs *vfs.VFS, bucket, key string) (versions []objectVersion, err error) {
+	fp := path.Join(bucket, key)
+	if node, err := _vfs.Stat(fp); err == nil && node.IsFile() {
+		versions = append(versions, objectVersion{
+			ID:      currentVersionID(_vfs, bucket, key),
+			Current: true,
+			Path:    fp,
+			Node:    node,
+		})
+	}
+	dir := keyVersionsDir(bucket, key)
+	entries, err := getDirEntries(dir, _vfs)
+	if err == gofakes3.ErrNoSuchKey {
+		return versions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var noncurrent []objectVersion
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == currentFile {
+			continue
+		}
+		v := objectVersion{
+			ID:   gofakes3.VersionID(name),
+			Path: path.Join(dir, name),
+			Node: entry,
+		}
+		if id, ok := strings.CutSuffix(name, markerSuffix); ok {
+			v.ID = gofakes3.VersionID(id)
+			v.Marker = true
+		}
+		noncurrent = append(noncurrent, v)
+	}
+	sort.SliceStable(noncurrent, func(i, j int) bool {
+		return noncurrent[i].created().After(noncurrent[j].created())
+	})
+	return append(versions, noncurrent...), nil
+}
+
+// findVersion returns the version id of key
+func (b *s3Backend) findVersion(_vfs *vfs.VFS, bucket, key string, id gofakes3.VersionID) (objectVersion, error) {
+	if id == "" {
+		id = nullVersion
+	}
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil {
+		return objectVersion{}, err
+	}
+	for _, v := range versions {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return objectVersion{}, gofakes3.ErrNoSuchVersion
+}
+
+// moveMeta moves the metadata stored for from to to
+func (b *s3Backend) moveMeta(from, to string) {
+	if val, ok := b.meta.LoadAndDelete(from); ok {
+		b.meta.Store(to, val)
+	}
+}
+
+// removeVersion removes the version at p if it exists
+func (b *s3Backend) removeVersion(_vfs *vfs.VFS, p string) error {
+	b.meta.Delete(p)
+	err := _vfs.Remove(p)
+	if err != nil && !errors.Is(err, vfs.ENOENT) {
+		return err
+	}
+	return nil
+}
+
+// archiveCurrent moves the object in the bucket, if any, into the
+// noncurrent versions of key.
+//
+// If the bucket versioning is suspended the null version is about to
+// be replaced so it is removed instead.
+func (b *s3Backend) archiveCurrent(_vfs *vfs.VFS, bucket, key string, versioning gofakes3.VersioningStatus) error {
+	dir := keyVersionsDir(bucket, key)
+	nullPath := path.Join(dir, string(nullVersion))
+	if versioning == gofakes3.VersioningSuspended {
+		if err := b.removeVersion(_vfs, nullPath+markerSuffix); err != nil {
+			return err
+		}
+		if err := b.removeVersion(_vfs, nullPath); err != nil {
+			return err
+		}
+	}
+	fp := path.Join(bucket, key)
+	node, err := _vfs.Stat(fp)
+	if err != nil || !node.IsFile() {
+		return nil
+	}
+	id := currentVersionID(_vfs, bucket, key)
+	if id == nullVersion && versioning == gofakes3.VersioningSuspended {
+		return b.removeVersion(_vfs, fp)
+	}
+	if err := mkdirRecursive(dir, _vfs); err != nil {
+		return err
+	}
+	dst := path.Join(dir, string(id))
+	if id == nullVersion {
+		// there can only be one null version
+		if err := b.removeVersion(_vfs, nullPath+markerSuffix); err != nil {
+			return err
+		}
+		if err := b.removeVersion(_vfs, nullPath); err != nil {
+			return err
+		}
+	}
+	if err := _vfs.Rename(fp, dst); err != nil {
+		return err
+	}
+	b.moveMeta(fp, dst)
+	return setCurrentVersionID(_vfs, bucket, key, nullVersion)
+}
+
+// addDeleteMarker makes a delete marker with id the latest version of key
+func (b *s3Backend) addDeleteMarker(_vfs *vfs.VFS, bucket, key string, id gofakes3.VersionID) error {
+	dir := keyVersionsDir(bucket, key)
+	if err := mkdirRecursive(dir, _vfs); err != nil {
+		return err
+	}
+	if id == nullVersion {
+		if err := b.removeVersion(_vfs, path.Join(dir, string(id))); err != nil {
+			return err
+		}
+	}
+	return _vfs.WriteFile(path.Join(dir, string(id)+markerSuffix), nil, 0666)
+}
+
+// promote restores the newest noncurrent version of key into the
+// bucket if there is no object there and it isn't a delete marker
+func (b *s3Backend) promote(_vfs *vfs.VFS, bucket, key string) error {
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		// tidy up the empty versions directory
+		_ = _vfs.Remove(keyVersionsDir(bucket, key))
+		return nil
+	}
+	v := versions[0]
+	if v.Current || v.Marker {
+		return nil
+	}
+	fp := path.Join(bucket, key)
+	if objectDir := path.Dir(fp); objectDir != "." {
+		if err := mkdirRecursive(objectDir, _vfs); err != nil {
+			return err
+		}
+	}
+	if err := _vfs.Rename(v.Path, fp); err != nil {
+		return err
+	}
+	b.moveMeta(v.Path, fp)
+	return setCurrentVersionID(_vfs, bucket, key, v.ID)
+}
+
+// versionedKeys returns the keys which have noncurrent versions
+func versionedKeys(_vfs *vfs.VFS, bucket string) ([]string, error) {
+	entries, err := getDirEntries(path.Join(bucket, metaDir, versionsDir), _vfs)
+	if err == gofakes3.ErrNoSuchKey {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, err := url.PathUnescape(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// hasVersions returns true if any key in bucket has noncurrent versions
+func hasVersions(_vfs *vfs.VFS, bucket string) bool {
+	keys, err := versionedKeys(_vfs, bucket)
+	return err != nil || len(keys) > 0
+}
+
+// versionedVFS returns the VFS used for the VersionedBackend methods
+// which don't get a context
+func (b *s3Backend) versionedVFS(bucket string) (*vfs.VFS, error) {
+	_vfs, err := b.s.getVFS(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = _vfs.Stat(bucket); err != nil {
+		return nil, gofakes3.BucketNotFound(bucket)
+	}
+	return _vfs, nil
+}
+
+// VersioningConfiguration returns the versioning status of the bucket.
+func (b *s3Backend) VersioningConfiguration(bucket string) (result gofakes3.VersioningConfiguration, err error) {
+	_vfs, err := b.versionedVFS(bucket)
+	if err != nil {
+		return result, err
+	}
+	result.Status, err = b.versioningStatus(_vfs, bucket)
+	return result, err
+}
+
+// SetVersioningConfiguration enables or suspends versioning on the bucket.
+func (b *s3Backend) SetVersioningConfiguration(bucket string, v gofakes3.VersioningConfiguration) error {
+	if v.MFADelete == gofakes3.MFADeleteEnabled {
+		return gofakes3.ErrNotImplemented
+	}
+	_vfs, err := b.versionedVFS(bucket)
+	if err != nil {
+		return err
+	}
+	return b.updateBucketConfig(_vfs, bucket, func(cfg *bucketConfig) {
+		cfg.Versioning = v.Status
+	})
+}
+
+// GetObjectVersion fetches the given version of the object.
+func (b *s3Backend) GetObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return b.objectVersion(bucketName, objectName, versionID, rangeRequest, true)
+}
+
+// HeadObjectVersion returns the fileinfo for the given version of the object.
+func (b *s3Backend) HeadObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.Object, error) {
+	return b.objectVersion(bucketName, objectName, versionID, nil, false)
+}
+
+// objectVersion implements GetObjectVersion and HeadObjectVersion
+func (b *s3Backend) objectVersion(bucketName, objectName string, versionID gofakes3.VersionID, rangeRequest *gofakes3.ObjectRangeRequest, open bool) (*gofakes3.Object, error) {
+	_vfs, err := b.versionedVFS(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.findVersion(_vfs, bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if v.Marker {
+		return &gofakes3.Object{
+			Name:           objectName,
+			VersionID:      v.ID,
+			IsDeleteMarker: true,
+			Contents:       noOpReadCloser{},
+		}, nil
+	}
+	obj, err := b.openObject(v.Node, v.Path, objectName, rangeRequest, open)
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = v.ID
+	return obj, nil
+}
+
+// DeleteObjectVersion permanently deletes the given version of the object.
+func (b *s3Backend) DeleteObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (result gofakes3.ObjectDeleteResult, err error) {
+	_vfs, err := b.versionedVFS(bucketName)
+	if err != nil {
+		return result, err
+	}
+	v, err := b.findVersion(_vfs, bucketName, objectName, versionID)
+	if err == gofakes3.ErrNoSuchVersion {
+		// S3 doesn't report an error for missing versions
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+	if err := b.removeVersion(_vfs, v.Path); err != nil {
+		return result, err
+	}
+	if v.Current {
+		if err := setCurrentVersionID(_vfs, bucketName, objectName, nullVersion); err != nil {
+			return result, err
+		}
+	}
+	if err := b.promote(_vfs, bucketName, objectName); err != nil {
+		return result, err
+	}
+	fp := path.Join(bucketName, objectName)
+	if _, err := _vfs.Stat(fp); v.Current && err != nil {
+		rmdirRecursive(fp, _vfs)
+	}
+	return gofakes3.ObjectDeleteResult{
+		IsDeleteMarker: v.Marker,
+		VersionID:      v.ID,
+	}, nil
+}
+
+// serveDeleteVersion deletes the version of the object and writes
+// the response
+func (b *s3Backend) serveDeleteVersion(w http.ResponseWriter, bucketName, objectName string, versionID gofakes3.VersionID) error {
+	result, err := b.DeleteObjectVersion(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+	if result.IsDeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+	w.Header().Set("x-amz-version-id", string(versionID))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// serveHeadVersion writes the headers of the version of the object
+// as gofakes3 ignores the versionId of HEAD requests
+func (b *s3Backend) serveHeadVersion(w http.ResponseWriter, r *http.Request, bucketName, objectName string, versionID gofakes3.VersionID) error {
+	obj, err := b.HeadObjectVersion(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("x-amz-version-id", string(obj.VersionID))
+	if obj.IsDeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		return gofakes3.ErrMethodNotAllowed
+	}
+	for k, v := range obj.Metadata {
+		w.Header().Set(k, v)
+	}
+	etag := `"` + hex.EncodeToString(obj.Hash) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// serveListVersions serves a listing of the versions with URL encoded
+// keys as gofakes3 ignores encoding-type=url for this API
+func (b *s3Backend) serveListVersions(w http.ResponseWriter, r *http.Request, bucketName string) error {
+	query := r.URL.Query()
+	prefix := &gofakes3.Prefix{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+	}
+	_, prefix.HasPrefix = query["prefix"]
+	_, prefix.HasDelimiter = query["delimiter"]
+	page := &gofakes3.ListBucketVersionsPage{
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: gofakes3.VersionID(query.Get("version-id-marker")),
+	}
+	_, page.HasKeyMarker = query["key-marker"]
+	_, page.HasVersionIDMarker = query["version-id-marker"]
+	if page.HasKeyMarker && page.KeyMarker == "" {
+		page = &gofakes3.ListBucketVersionsPage{}
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.ParseInt(maxKeys, 10, 64)
+		if err != nil || n < 0 {
+			return gofakes3.ErrorInvalidArgument("max-keys", maxKeys, "Provided max-keys not an integer or within integer range")
+		}
+		page.MaxKeys = min(n, 1000)
+	}
+
+	result, err := b.ListBucketVersions(bucketName, prefix, page)
+	if err != nil {
+		return err
+	}
+	result.Prefix = gofakes3.URLEncode(result.Prefix)
+	result.KeyMarker = gofakes3.URLEncode(result.KeyMarker)
+	result.NextKeyMarker = gofakes3.URLEncode(result.NextKeyMarker)
+	for i := range result.CommonPrefixes {
+		result.CommonPrefixes[i].Prefix = gofakes3.URLEncode(result.CommonPrefixes[i].Prefix)
+	}
+	for _, item := range result.Versions {
+		switch v := item.(type) {
+		case *gofakes3.Version:
+			v.Key = gofakes3.URLEncode(v.Key)
+		case *gofakes3.DeleteMarker:
+			v.Key = gofakes3.URLEncode(v.Key)
+		}
+	}
+	return writeXML(w, result)
+}
+
+// ListBucketVersions lists all the versions of the objects in the bucket.
+func (b *s3Backend) ListBucketVersions(bucketName string, prefix *gofakes3.Prefix, page *gofakes3.ListBucketVersionsPage) (*gofakes3.ListBucketVersionsResult, error) {
+	_vfs, err := b.versionedVFS(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == nil {
+		prefix = emptyPrefix
+	}
+	// workaround as in ListBucket
+	if strings.TrimSpace(prefix.Prefix) == "" {
+		prefix.HasPrefix = false
+	}
+	if strings.TrimSpace(prefix.Delimiter) == "" {
+		prefix.HasDelimiter = false
+	}
+	if page == nil {
+		page = &gofakes3.ListBucketVersionsPage{}
+	}
+
+	keys, err := b.allKeys(_vfs, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := gofakes3.NewListBucketVersionsResult(bucketName, prefix, page)
+	maxKeys := page.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	var (
+		count   int64
+		match   gofakes3.PrefixMatch
+		lastKey string
+		lastID  gofakes3.VersionID
+	)
+	for _, key := range keys {
+		if page.HasKeyMarker && key < page.KeyMarker {
+			continue
+		}
+		if page.HasKeyMarker && key == page.KeyMarker && !page.HasVersionIDMarker {
+			continue
+		}
+		if !prefix.Match(key, &match) {
+			continue
+		}
+		if match.CommonPrefix {
+			result.AddPrefix(match.MatchedPart)
+			continue
+		}
+		versions, err := b.objectVersions(_vfs, bucketName, key)
+		if err != nil {
+			return nil, err
+		}
+		if page.HasKeyMarker && key == page.KeyMarker {
+			for j, v := range versions {
+				if v.ID == page.VersionIDMarker {
+					versions = versions[j+1:]
+					break
+				}
+			}
+		}
+		for j, v := range versions {
+			if count >= maxKeys {
+				result.IsTruncated = true
+				result.NextKeyMarker = lastKey
+				result.NextVersionIDMarker = lastID
+				return result, nil
+			}
+			isLatest := j == 0 && (!page.HasKeyMarker || key != page.KeyMarker)
+			lastModified := gofakes3.NewContentTime(v.created())
+			if v.Marker {
+				result.Versions = append(result.Versions, &gofakes3.DeleteMarker{
+					Key:          key,
+					VersionID:    v.ID,
+					IsLatest:     isLatest,
+					LastModified: lastModified,
+				})
+			} else {
+				result.Versions = append(result.Versions, &gofakes3.Version{
+					Key:          key,
+					VersionID:    v.ID,
+					IsLatest:     isLatest,
+					LastModified: lastModified,
+					Size:         v.Node.Size(),
+					ETag:         `"` + getFileHash(v.Node, b.s.etagHashType) + `"`,
+					StorageClass: gofakes3.StorageStandard,
+				})
+			}
+			lastKey, lastID = key, v.ID
+			count++
+		}
+	}
+	return result, nil
+}
+
+// check interfaces
+var _ gofakes3.VersionedBackend = (*s3Backend)(nil)
@@ -28,6 +28,10 @@ func main() {
 		"_root":    root,
 		"_obscure": "pass",
 	}
+	if _, ok := in["pass"]; !ok && in["public_key"] == "" {
+		// challenge based logins need to know the password
+		out["_password"] = "password"
+	}
 	json.NewEncoder(os.Stdout).Encode(&out)
 	if err != nil {
 		log.Fatal(err)
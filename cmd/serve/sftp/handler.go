@@ -38,11 +38,37 @@ func (v vfsHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
 }
 
 func (v vfsHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	// SFTP has no locking so don't let it truncate locked files
+	if _, found := v.TestLock(r.Filepath, vfs.Lock{Owner: lockOwner, Type: vfs.LockWrite, Start: 0, End: vfs.LockEOF}); found {
+		return nil, vfs.EAGAIN
+	}
 	file, err := v.OpenFile(r.Filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
 	if err != nil {
 		return nil, err
 	}
-	return file, nil
+	return lockedWriter{Handle: file, vfs: v.VFS}, nil
+}
+
+// lockOwner is the VFS lock owner used to check for locks. SFTP
+// clients can't take locks so this never holds any.
+const lockOwner = "sftp"
+
+// lockedWriter refuses writes to byte ranges locked by other users of
+// the VFS
+type lockedWriter struct {
+	vfs.Handle
+	vfs *vfs.VFS
+}
+
+// WriteAt writes len(p) bytes at off unless they are locked
+func (w lockedWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	if len(p) > 0 && off >= 0 {
+		l := vfs.Lock{Owner: lockOwner, Type: vfs.LockWrite, Start: off, End: off + int64(len(p))}
+		if _, found := w.vfs.TestLock(w.Handle.Node().Path(), l); found {
+			return 0, vfs.EAGAIN
+		}
+	}
+	return w.Handle.WriteAt(p, off)
 }
 
 func (v vfsHandler) Filecmd(r *sftp.Request) error {
@@ -0,0 +1,194 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// Prefix is the URL path share links are served under
+const Prefix = "/.share/"
+
+// Handler serves share links for the shares inside the remote served
+// by a VFS
+type Handler struct {
+	store        *Store
+	root         string // canonical name of the remote served
+	VFS          *vfs.VFS
+	htmlTemplate *template.Template
+}
+
+// NewHandler makes a Handler serving the shares in the store given
+// by opt which are inside the remote served by VFS. Directories are
+// listed using htmlTemplate.
+//
+// VFS may be nil if the server is using an auth proxy in which case
+// an error is returned as shares need a single remote.
+func NewHandler(opt *Options, VFS *vfs.VFS, htmlTemplate *template.Template) (*Handler, error) {
+	if VFS == nil {
+		return nil, errors.New("share links can't be used with --auth-proxy")
+	}
+	return &Handler{
+		store:        NewStore(opt.Store),
+		root:         fs.ConfigString(VFS.Fs()),
+		VFS:          VFS,
+		htmlTemplate: htmlTemplate,
+	}, nil
+}
+
+// relative returns the path of remote inside root and whether it is
+// inside root at all
+func relative(root, remote string) (string, bool) {
+	if remote == root {
+		return "", true
+	}
+	prefix := root
+	if !strings.HasSuffix(prefix, ":") && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(remote, prefix) {
+		return "", false
+	}
+	return remote[len(prefix):], true
+}
+
+// countDownload returns true if the request starts a download of a
+// file rather than continuing one
+func countDownload(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// ServeHTTP serves the share link in the URL
+//
+// The URL is Prefix followed by the token, then for shared
+// directories the path of the file or directory inside the share.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tok, subPath, hasSlash := strings.Cut(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	sh, err := h.store.Check(tok)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			fs.Errorf(nil, "Failed to check share link: %v", err)
+		}
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	base, ok := relative(h.root, sh.Remote)
+	if !ok {
+		fs.Debugf(nil, "%s: Share %s of %q isn't inside %q", r.RemoteAddr, sh.ID, sh.Remote, h.root)
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	if _, pass, _ := r.BasicAuth(); !sh.CheckPassword(pass) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rclone share", charset="UTF-8"`)
+		http.Error(w, "Password required", http.StatusUnauthorized)
+		return
+	}
+
+	// Find the node being asked for
+	remote := base
+	if sh.IsDir {
+		subPath = strings.Trim(path.Clean("/"+subPath), "/")
+		remote = path.Join(base, subPath)
+	} else if subPath != "" || hasSlash {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	node, err := h.VFS.Stat(remote)
+	if errors.Is(err, vfs.ENOENT) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		serve.Error(r.Context(), remote, w, "Failed to find file", err)
+		return
+	}
+	if node.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// relative so it works with --baseurl
+			w.Header().Set("Location", path.Base(r.URL.Path)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		h.serveDir(w, r, sh, subPath, node.(*vfs.Dir))
+		return
+	}
+	h.serveFile(w, r, sh, node)
+}
+
+// serveDir lists dir at subPath in the share
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, sh *Share, subPath string, dir *vfs.Dir) {
+	ctx := r.Context()
+	dirEntries, err := dir.ReadDirAll()
+	if err != nil {
+		serve.Error(ctx, dir.Path(), w, "Failed to list directory", err)
+		return
+	}
+	directory := serve.NewDirectory(subPath, h.htmlTemplate)
+	directory.Title = fmt.Sprintf("Shared directory %s", path.Join(path.Base(sh.Remote), subPath))
+	directory.DisableZip = true
+	for _, node := range dirEntries {
+		if vfscommon.Opt.NoModTime {
+			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), time.Time{})
+		} else {
+			directory.AddHTMLEntry(node.Path(), node.IsDir(), node.Size(), node.ModTime().UTC())
+		}
+	}
+	directory.ProcessQueryParams(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
+	directory.Serve(w, r)
+}
+
+// serveFile sends the file at node counting the download
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, sh *Share, node vfs.Node) {
+	ctx := r.Context()
+	remote := node.Path()
+	obj, ok := node.DirEntry().(fs.Object)
+	if !ok {
+		http.Error(w, "Can't open file being written", http.StatusNotFound)
+		return
+	}
+	if countDownload(r) {
+		err := h.store.Download(sh.ID)
+		if errors.Is(err, ErrDownloadLimit) {
+			http.Error(w, "Share link download limit reached", http.StatusGone)
+			return
+		} else if err != nil {
+			serve.Error(ctx, remote, w, "Failed to count download", err)
+			return
+		}
+	}
+	in, err := node.Open(os.O_RDONLY)
+	if err != nil {
+		serve.Error(ctx, remote, w, "Failed to open file", err)
+		return
+	}
+	defer func() {
+		err := in.Close()
+		if err != nil {
+			fs.Errorf(remote, "Failed to close file: %v", err)
+		}
+	}()
+	fs.Infof(remote, "%s: Serving share %s", r.RemoteAddr, sh.ID)
+	tr := accounting.Stats(ctx).NewTransfer(obj, nil)
+	defer tr.Done(ctx, nil)
+	w.Header().Set("Content-Type", fs.MimeType(ctx, obj))
+	http.ServeContent(w, r, node.Name(), node.ModTime(), in)
+}
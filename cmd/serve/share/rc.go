@@ -0,0 +1,163 @@
+package share
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rclone/rclone/fs/rc"
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "share/create",
+		AuthRequired: true,
+		Fn:           rcCreate,
+		Title:        "Make a share link for a file or directory",
+		Help: `This takes the following parameters:
+
+- fs - a remote name string e.g. "drive:"
+- remote - a path within that remote e.g. "dir/file.txt" or "" for the whole remote
+- expire - how long the link lasts e.g. "24h" (optional, default 24h)
+- password - password needed to use the link (optional)
+- maxDownloads - number of times a file may be downloaded (optional, default unlimited)
+- store - path to the share store (optional, default shares.json next to the config file)
+
+The link is served by ` + "`rclone serve http`" + ` and ` + "`rclone serve webdav`" + `
+with ` + "`--shares`" + ` when serving a remote which contains the share.
+
+Returns:
+
+- id - the ID of the share, use this with share/revoke
+- token - the token for the link
+- path - the path to add to the server's URL to make the link
+- expires - when the link expires
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "share/list",
+		AuthRequired: true,
+		Fn:           rcList,
+		Title:        "List the share links which haven't expired",
+		Help: `This takes the following parameters:
+
+- store - path to the share store (optional)
+
+Returns:
+
+- shares - a list of shares each with id, remote, isDir, created,
+  expires, maxDownloads, downloads, hasPassword and path
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "share/revoke",
+		AuthRequired: true,
+		Fn:           rcRevoke,
+		Title:        "Remove a share link",
+		Help: `This takes the following parameters:
+
+- id - the ID of the share to remove
+- store - path to the share store (optional)
+`,
+	})
+}
+
+// getStore returns the store given in the "store" parameter
+func getStore(in rc.Params) (*Store, error) {
+	path, err := in.GetString("store")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	return NewStore(path), nil
+}
+
+// LinkPath returns the path of the link for the share with token tok
+func LinkPath(sh *Share, tok string) string {
+	if sh.IsDir {
+		return Prefix + tok + "/"
+	}
+	return Prefix + tok
+}
+
+// Make a share link
+func rcCreate(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, remote, err := rc.GetFsAndRemote(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	store, err := getStore(in)
+	if err != nil {
+		return nil, err
+	}
+	var opt CreateOptions
+	opt.Expire, err = in.GetDuration("expire")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	opt.Password, err = in.GetString("password")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	maxDownloads, err := in.GetInt64("maxDownloads")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	opt.MaxDownloads = int(maxDownloads)
+	fullRemote, isDir, err := Resolve(ctx, f, remote)
+	if err != nil {
+		return nil, err
+	}
+	sh, tok, err := store.Create(fullRemote, isDir, opt)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"id":      sh.ID,
+		"token":   tok,
+		"path":    LinkPath(sh, tok),
+		"expires": sh.Expires,
+	}, nil
+}
+
+// List the share links
+func rcList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	store, err := getStore(in)
+	if err != nil {
+		return nil, err
+	}
+	shares, tokens, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	list := []rc.Params{}
+	for i, sh := range shares {
+		list = append(list, rc.Params{
+			"id":           sh.ID,
+			"remote":       sh.Remote,
+			"isDir":        sh.IsDir,
+			"created":      sh.Created,
+			"expires":      sh.Expires,
+			"maxDownloads": sh.MaxDownloads,
+			"downloads":    sh.Downloads,
+			"hasPassword":  sh.Password != "",
+			"path":         LinkPath(sh, tokens[i]),
+		})
+	}
+	return rc.Params{"shares": list}, nil
+}
+
+// Remove a share link
+func rcRevoke(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	store, err := getStore(in)
+	if err != nil {
+		return nil, err
+	}
+	id, err := in.GetString("id")
+	if err != nil {
+		return nil, err
+	}
+	err = store.Revoke(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke share %q: %w", id, err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,390 @@
+// Package share implements share links served by rclone serve
+//
+// Share links are signed, expiring tokens which give access to a file
+// or directory without the server's authentication. They are kept in
+// a store file shared by the servers, the rc and the share command.
+package share
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/lib/random"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Help contains text describing share links
+var Help = strings.ReplaceAll(`### Share links
+
+Share links give access to a single file or directory of the remote
+being served without needing the server's authentication. Use
+|--shares| to serve them. They are made with |rclone share create|
+or the |share/create| rc call, for example
+
+    rclone share create remote:path/to/file --expire 24h
+
+Which prints a path like |/.share/TOKEN| which is added to the server's
+URL to make the link. The token is signed with a secret only the share
+store knows, so links can't be guessed or altered. Links expire after
+the time given with |--expire| and can optionally be protected with a
+password, which the browser will ask for, and limited to a maximum
+number of downloads. Use |rclone share revoke| to remove a link before
+it expires.
+
+Only shares of files and directories inside the remote being served
+are served. Share links can't be used with |--auth-proxy|.
+
+The shares are kept in |shares.json| next to the config file unless
+|--share-store| is given.
+
+`, "|", "`")
+
+// OptionsInfo describes the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "shares",
+	Default: false,
+	Help:    "Serve share links made with rclone share",
+}, {
+	Name:    "share_store",
+	Default: "",
+	Help:    "Path to the share link store (default shares.json next to the config file)",
+}}
+
+// Options for serving share links
+type Options struct {
+	Enabled bool   `config:"shares"`
+	Store   string `config:"share_store"`
+}
+
+// DefaultExpire is how long share links last by default
+const DefaultExpire = 24 * time.Hour
+
+// maxExpire caps the expiry so adding it to the time doesn't overflow
+const maxExpire = 100 * 365 * 24 * time.Hour
+
+// Errors returned by the store
+var (
+	ErrNotFound      = errors.New("share link not found or expired")
+	ErrDownloadLimit = errors.New("share link download limit reached")
+)
+
+// Share describes a share link
+type Share struct {
+	ID           string    `json:"id"`
+	Remote       string    `json:"remote"` // remote:path being shared
+	IsDir        bool      `json:"isDir"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	Password     string    `json:"password,omitempty"` // bcrypt hash of the password if set
+	MaxDownloads int       `json:"maxDownloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+}
+
+// Expired returns true if the share has expired at now
+func (sh *Share) Expired(now time.Time) bool {
+	return !now.Before(sh.Expires)
+}
+
+// CheckPassword returns true if password is correct for the share
+func (sh *Share) CheckPassword(password string) bool {
+	if sh.Password == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(sh.Password), []byte(password)) == nil
+}
+
+// CreateOptions are the options for making a share link
+type CreateOptions struct {
+	Expire       time.Duration // how long the link lasts - DefaultExpire if not set
+	Password     string        // password needed to use the link if set
+	MaxDownloads int           // maximum number of downloads if set
+}
+
+// storeFile is the format of the store on disk
+type storeFile struct {
+	Secret string            `json:"secret"`
+	Shares map[string]*Share `json:"shares"`
+}
+
+// Store holds the share links in a file
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// DefaultStorePath returns where the store is kept if not set
+func DefaultStorePath() string {
+	dir := config.GetCacheDir()
+	if configPath := config.GetConfigPath(); configPath != "" {
+		dir = filepath.Dir(configPath)
+	}
+	return filepath.Join(dir, "shares.json")
+}
+
+// Stores are shared in the process so that updates to the same file
+// are serialised
+var (
+	storesMu sync.Mutex
+	stores   = make(map[string]*Store)
+)
+
+// NewStore returns the store kept at path, or the default path if
+// it is empty
+func NewStore(path string) *Store {
+	if path == "" {
+		path = DefaultStorePath()
+	}
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	s, ok := stores[path]
+	if !ok {
+		s = &Store{path: path}
+		stores[path] = s
+	}
+	return s
+}
+
+// load reads the store file, making a new secret if it doesn't exist
+//
+// Call with mu held
+func (s *Store) load() (*storeFile, error) {
+	data := &storeFile{}
+	buf, err := os.ReadFile(s.path)
+	if err == nil {
+		err = json.Unmarshal(buf, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read share store %q: %w", s.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read share store: %w", err)
+	}
+	if data.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to make share store secret: %w", err)
+		}
+		data.Secret = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	if data.Shares == nil {
+		data.Shares = make(map[string]*Share)
+	}
+	return data, nil
+}
+
+// save writes the store file atomically
+//
+// Call with mu held
+func (s *Store) save(data *storeFile) error {
+	buf, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make share store directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, buf, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write share store: %w", err)
+	}
+	return nil
+}
+
+// update loads the store, calls fn then saves the store if fn
+// returns true
+func (s *Store) update(fn func(data *storeFile) (changed bool, err error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := s.load()
+	if err != nil {
+		return err
+	}
+	changed, err := fn(data)
+	if err != nil || !changed {
+		return err
+	}
+	return s.save(data)
+}
+
+// sign returns the signature of the share with secret
+func sign(secret string, sh *Share) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s\x00%s\x00%t\x00%d", sh.ID, sh.Remote, sh.IsDir, sh.Expires.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// token returns the token for the share
+func token(secret string, sh *Share) string {
+	return sh.ID + "." + sign(secret, sh)
+}
+
+// Create makes a share link for remote returning the share and its token
+func (s *Store) Create(remote string, isDir bool, opt CreateOptions) (sh *Share, tok string, err error) {
+	if opt.Expire <= 0 {
+		opt.Expire = DefaultExpire
+	} else if opt.Expire > maxExpire {
+		opt.Expire = maxExpire
+	}
+	if opt.MaxDownloads < 0 {
+		return nil, "", errors.New("max downloads can't be negative")
+	}
+	now := time.Now()
+	sh = &Share{
+		Remote:       remote,
+		IsDir:        isDir,
+		Created:      now,
+		Expires:      now.Add(opt.Expire),
+		MaxDownloads: opt.MaxDownloads,
+	}
+	if opt.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opt.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to hash share password: %w", err)
+		}
+		sh.Password = string(hash)
+	}
+	err = s.update(func(data *storeFile) (bool, error) {
+		for {
+			sh.ID = random.String(12)
+			if _, found := data.Shares[sh.ID]; !found {
+				break
+			}
+		}
+		// Remove expired shares while we are here
+		for id, old := range data.Shares {
+			if old.Expired(now) {
+				delete(data.Shares, id)
+			}
+		}
+		data.Shares[sh.ID] = sh
+		tok = token(data.Secret, sh)
+		return true, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return sh, tok, nil
+}
+
+// List returns the shares which haven't expired with their tokens
+// sorted by creation time
+func (s *Store) List() (shares []*Share, tokens []string, err error) {
+	now := time.Now()
+	err = s.update(func(data *storeFile) (bool, error) {
+		for _, sh := range data.Shares {
+			if !sh.Expired(now) {
+				shares = append(shares, sh)
+			}
+		}
+		sort.Slice(shares, func(i, j int) bool {
+			return shares[i].Created.Before(shares[j].Created)
+		})
+		for _, sh := range shares {
+			tokens = append(tokens, token(data.Secret, sh))
+		}
+		return false, nil
+	})
+	return shares, tokens, err
+}
+
+// Revoke removes the share with id
+func (s *Store) Revoke(id string) error {
+	return s.update(func(data *storeFile) (bool, error) {
+		if _, found := data.Shares[id]; !found {
+			return false, ErrNotFound
+		}
+		delete(data.Shares, id)
+		return true, nil
+	})
+}
+
+// Check returns the share for tok if it is valid and hasn't expired
+func (s *Store) Check(tok string) (sh *Share, err error) {
+	id, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return nil, ErrNotFound
+	}
+	err = s.update(func(data *storeFile) (bool, error) {
+		sh = data.Shares[id]
+		if sh == nil || !hmac.Equal([]byte(sig), []byte(sign(data.Secret, sh))) || sh.Expired(time.Now()) {
+			return false, ErrNotFound
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// Download counts a download of the share with id returning
+// ErrDownloadLimit if there are no downloads left
+func (s *Store) Download(id string) error {
+	return s.update(func(data *storeFile) (bool, error) {
+		sh := data.Shares[id]
+		if sh == nil {
+			return false, ErrNotFound
+		}
+		if sh.MaxDownloads > 0 && sh.Downloads >= sh.MaxDownloads {
+			return false, ErrDownloadLimit
+		}
+		sh.Downloads++
+		return true, nil
+	})
+}
+
+// Resolve finds the canonical name of remote on f and whether it is
+// a directory, for making a share
+func Resolve(ctx context.Context, f fs.Fs, remote string) (fullRemote string, isDir bool, err error) {
+	fullRemote = fspath.JoinRootPath(fs.ConfigString(f), remote)
+	if remote != "" {
+		_, err = f.NewObject(ctx, remote)
+		if err == nil {
+			return fullRemote, false, nil
+		} else if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorIsDir) {
+			return "", false, err
+		}
+	}
+	_, err = f.List(ctx, remote)
+	if err != nil {
+		return "", false, fmt.Errorf("can't share %q: %w", fullRemote, err)
+	}
+	return fullRemote, true, nil
+}
+
+// Format returns a human readable description of the share
+func Format(sh *Share) string {
+	var limits []string
+	if sh.Password != "" {
+		limits = append(limits, "password")
+	}
+	if sh.MaxDownloads > 0 {
+		limits = append(limits, "downloads "+strconv.Itoa(sh.Downloads)+"/"+strconv.Itoa(sh.MaxDownloads))
+	}
+	s := fmt.Sprintf("%s %s expires %s", sh.ID, sh.Remote, sh.Expires.Local().Format("2006-01-02 15:04:05"))
+	if len(limits) > 0 {
+		s += " (" + strings.Join(limits, ", ") + ")"
+	}
+	return s
+}
@@ -0,0 +1,182 @@
+package share
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	libhttp "github.com/rclone/rclone/lib/http"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "shares.json"))
+
+	sh, tok, err := store.Create("remote:path/file.txt", false, CreateOptions{Password: "secret", MaxDownloads: 2})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tok, sh.ID+"."))
+	assert.WithinDuration(t, time.Now().Add(DefaultExpire), sh.Expires, time.Minute)
+	assert.NotEqual(t, "secret", sh.Password)
+
+	// Check the token
+	got, err := store.Check(tok)
+	require.NoError(t, err)
+	assert.Equal(t, "remote:path/file.txt", got.Remote)
+	assert.True(t, got.CheckPassword("secret"))
+	assert.False(t, got.CheckPassword("wrong"))
+	for _, bad := range []string{"", sh.ID, sh.ID + ".", tok + "x", "x" + tok} {
+		_, err = store.Check(bad)
+		assert.ErrorIs(t, err, ErrNotFound, bad)
+	}
+
+	// A store with a different secret doesn't accept it
+	other := NewStore(filepath.Join(t.TempDir(), "shares.json"))
+	_, err = other.Check(tok)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Download limit
+	require.NoError(t, store.Download(sh.ID))
+	require.NoError(t, store.Download(sh.ID))
+	assert.ErrorIs(t, store.Download(sh.ID), ErrDownloadLimit)
+
+	// List
+	sh2, tok2, err := store.Create("remote:dir", true, CreateOptions{Expire: time.Hour})
+	require.NoError(t, err)
+	shares, tokens, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, shares, 2)
+	assert.Equal(t, sh.ID, shares[0].ID)
+	assert.Equal(t, 2, shares[0].Downloads)
+	assert.Equal(t, []string{tok, tok2}, tokens)
+	assert.Equal(t, Prefix+tok2+"/", LinkPath(sh2, tok2))
+
+	// Revoke
+	require.NoError(t, store.Revoke(sh.ID))
+	assert.ErrorIs(t, store.Revoke(sh.ID), ErrNotFound)
+	_, err = store.Check(tok)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Expiry
+	_, tok3, err := store.Create("remote:dir", true, CreateOptions{Expire: time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = store.Check(tok3)
+	assert.ErrorIs(t, err, ErrNotFound)
+	shares, _, err = store.List()
+	require.NoError(t, err)
+	assert.Len(t, shares, 1)
+}
+
+func TestRelative(t *testing.T) {
+	for _, test := range []struct {
+		root, remote string
+		want         string
+		ok           bool
+	}{
+		{"remote:", "remote:", "", true},
+		{"remote:", "remote:dir/file", "dir/file", true},
+		{"remote:dir", "remote:dir/file", "file", true},
+		{"remote:dir", "remote:dir", "", true},
+		{"remote:dir", "remote:directory/file", "", false},
+		{"remote:dir", "other:dir/file", "", false},
+		{"/tmp/root", "/tmp/root/file", "file", true},
+		{"/", "/tmp/file", "tmp/file", true},
+	} {
+		got, ok := relative(test.root, test.remote)
+		assert.Equal(t, test.ok, ok, test)
+		assert.Equal(t, test.want, got, test)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "deeper"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("in sub"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+	VFS := vfs.New(f, &vfscommon.Opt)
+	defer VFS.Shutdown()
+	tmpl, err := libhttp.GetTemplate("")
+	require.NoError(t, err)
+	opt := Options{Enabled: true, Store: filepath.Join(t.TempDir(), "shares.json")}
+	h, err := NewHandler(&opt, VFS, tmpl)
+	require.NoError(t, err)
+	store := NewStore(opt.Store)
+
+	_, err = NewHandler(&opt, nil, tmpl)
+	assert.Error(t, err)
+
+	get := func(URL string, password string) (int, string) {
+		req := httptest.NewRequest("GET", URL, nil)
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		body, err := io.ReadAll(w.Body)
+		require.NoError(t, err)
+		return w.Code, string(body)
+	}
+
+	// A file share
+	fileRemote, isDir, err := Resolve(ctx, f, "file.txt")
+	require.NoError(t, err)
+	assert.False(t, isDir)
+	fileShare, fileTok, err := store.Create(fileRemote, isDir, CreateOptions{MaxDownloads: 1})
+	require.NoError(t, err)
+	code, body := get(LinkPath(fileShare, fileTok), "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+	code, _ = get(LinkPath(fileShare, fileTok), "")
+	assert.Equal(t, http.StatusGone, code)
+	code, _ = get(LinkPath(fileShare, fileTok)+"/secret.txt", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// A directory share with a password
+	dirRemote, isDir, err := Resolve(ctx, f, "sub")
+	require.NoError(t, err)
+	assert.True(t, isDir)
+	dirShare, dirTok, err := store.Create(dirRemote, isDir, CreateOptions{Password: "pw"})
+	require.NoError(t, err)
+	dirLink := LinkPath(dirShare, dirTok)
+	code, _ = get(dirLink, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get(dirLink, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, body = get(dirLink, "pw")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "a.txt")
+	assert.Contains(t, body, "deeper/")
+	code, _ = get(strings.TrimSuffix(dirLink, "/"), "pw")
+	assert.Equal(t, http.StatusMovedPermanently, code)
+	code, body = get(dirLink+"a.txt", "pw")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "in sub", body)
+	code, body = get(dirLink+"../secret.txt", "pw")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.NotContains(t, body, "secret")
+
+	// Shares outside the remote being served aren't served
+	otherShare, otherTok, err := store.Create("other:sub", true, CreateOptions{})
+	require.NoError(t, err)
+	code, _ = get(LinkPath(otherShare, otherTok), "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Bad tokens
+	code, _ = get(Prefix+"nope/", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,619 @@
+package smb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// Create dispositions
+const (
+	fileSupersede   = 0
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOpenIf      = 3
+	fileOverwrite   = 4
+	fileOverwriteIf = 5
+)
+
+// Create options
+const (
+	fileDirectoryFile    = 0x00000001
+	fileNonDirectoryFile = 0x00000040
+	fileDeleteOnClose    = 0x00001000
+)
+
+// Create actions
+const (
+	fileSuperseded  = 0
+	fileOpened      = 1
+	fileCreated     = 2
+	fileOverwritten = 3
+)
+
+// open is a file or directory opened by a client
+type open struct {
+	id            fileID
+	tree          *tree
+	path          string // path in the VFS
+	isDir         bool
+	access        uint32     // the access granted
+	handle        vfs.Handle // nil until the file is read or written
+	writable      bool       // set if handle was opened for writing
+	deleteOnClose bool
+
+	// directory listing state
+	entries []vfs.Node
+	names   []string // names of the entries which may be "." and ".."
+	pos     int
+}
+
+// vfsPath converts an SMB path into a VFS path
+func vfsPath(name string) string {
+	return strings.Trim(strings.ReplaceAll(name, `\`, "/"), "/")
+}
+
+// statusOf converts a VFS error into an NTSTATUS
+func statusOf(err error) uint32 {
+	switch {
+	case err == nil:
+		return statusSuccess
+	case errors.Is(err, vfs.ENOENT):
+		return statusObjectNameNotFound
+	case errors.Is(err, vfs.EEXIST):
+		return statusObjectNameCollision
+	case errors.Is(err, vfs.ENOTEMPTY):
+		return statusDirectoryNotEmpty
+	case errors.Is(err, vfs.EPERM), errors.Is(err, vfs.EROFS), errors.Is(err, vfs.EBADF):
+		return statusAccessDenied
+	case errors.Is(err, vfs.EINVAL):
+		return statusInvalidParameter
+	case errors.Is(err, vfs.ENOSYS):
+		return statusNotSupported
+	}
+	fs.Errorf(nil, "SMB error: %v", err)
+	return statusUnexpectedIOError
+}
+
+// stat finds the node of the open
+func (o *open) stat() (vfs.Node, error) {
+	return o.tree.vfs.Stat(o.path)
+}
+
+// getHandle returns a handle for reading or writing the file.
+//
+// offset is where the first write will be.
+func (o *open) getHandle(write bool, offset int64) (vfs.Handle, error) {
+	if o.handle != nil && (o.writable || !write) {
+		return o.handle, nil
+	}
+	if o.handle != nil {
+		// reopen the read handle for writing
+		_ = o.handle.Close()
+		o.handle = nil
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_RDWR
+		if offset == 0 && o.tree.vfs.Opt.CacheMode < vfscommon.CacheModeWrites {
+			// without the cache writes must replace the file
+			flags |= os.O_TRUNC
+		}
+	}
+	h, err := o.tree.vfs.OpenFile(o.path, flags, 0777)
+	if err != nil {
+		return nil, err
+	}
+	o.handle = h
+	o.writable = write
+	return h, nil
+}
+
+// close the handle and delete the file if asked to
+func (o *open) close() {
+	// release by owner as the file may have been renamed
+	o.tree.vfs.ReleaseLocks(o.lockOwner())
+	if o.handle != nil {
+		if err := o.handle.Close(); err != nil {
+			fs.Errorf(o.path, "SMB failed to close file: %v", err)
+		}
+		o.handle = nil
+	}
+	if o.deleteOnClose {
+		if err := o.tree.vfs.Remove(o.path); err != nil && !errors.Is(err, vfs.ENOENT) {
+			fs.Errorf(o.path, "SMB failed to delete: %v", err)
+		}
+	}
+}
+
+// handleCreate opens or creates a file or directory
+func (c *conn) handleCreate(req *request) (status uint32, body []byte, id fileID) {
+	b := req.body
+	if len(b) < 56 {
+		return statusInvalidParameter, nil, id
+	}
+	access := le.Uint32(b[24:])
+	disposition := le.Uint32(b[36:])
+	options := le.Uint32(b[40:])
+	nameBytes, err := buffer(req.msg, int(le.Uint16(b[44:])), int(le.Uint16(b[46:])))
+	if err != nil {
+		return statusInvalidParameter, nil, id
+	}
+	name := decodeUTF16(nameBytes)
+	if req.tree.ipc {
+		// Named pipes aren't supported
+		return statusObjectNameNotFound, nil, id
+	}
+	name = strings.TrimSuffix(name, "::$DATA")
+	if strings.ContainsRune(name, ':') {
+		// Alternate data streams aren't supported
+		return statusObjectNameInvalid, nil, id
+	}
+	VFS := req.tree.vfs
+	p := vfsPath(name)
+	o := &open{
+		tree:          req.tree,
+		path:          p,
+		access:        access,
+		deleteOnClose: options&fileDeleteOnClose != 0,
+	}
+
+	var action uint32
+	node, err := VFS.Stat(p)
+	switch {
+	case err == nil:
+		o.isDir = node.IsDir()
+		switch {
+		case disposition == fileCreate:
+			return statusObjectNameCollision, nil, id
+		case options&fileDirectoryFile != 0 && !o.isDir:
+			return statusNotADirectory, nil, id
+		case options&fileNonDirectoryFile != 0 && o.isDir:
+			return statusFileIsADirectory, nil, id
+		}
+		action = fileOpened
+		if !o.isDir && (disposition == fileSupersede || disposition == fileOverwrite || disposition == fileOverwriteIf) {
+			o.handle, err = VFS.OpenFile(p, os.O_RDWR|os.O_TRUNC, 0777)
+			if err != nil {
+				return statusOf(err), nil, id
+			}
+			o.writable = true
+			action = fileOverwritten
+			if disposition == fileSupersede {
+				action = fileSuperseded
+			}
+		}
+	case errors.Is(err, vfs.ENOENT):
+		if disposition == fileOpen || disposition == fileOverwrite {
+			if _, _, err := VFS.StatParent(p); err != nil {
+				return statusObjectPathNotFound, nil, id
+			}
+			return statusObjectNameNotFound, nil, id
+		}
+		if _, _, err := VFS.StatParent(p); err != nil {
+			return statusObjectPathNotFound, nil, id
+		}
+		if options&fileDirectoryFile != 0 {
+			o.isDir = true
+			err = VFS.Mkdir(p, 0777)
+		} else {
+			o.handle, err = VFS.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0777)
+			o.writable = true
+		}
+		if err != nil {
+			return statusOf(err), nil, id
+		}
+		action = fileCreated
+	default:
+		return statusOf(err), nil, id
+	}
+	if p == "" && o.deleteOnClose {
+		o.deleteOnClose = false
+		o.close()
+		return statusAccessDenied, nil, id
+	}
+	node, err = VFS.Stat(p)
+	if err != nil {
+		o.close()
+		return statusOf(err), nil, id
+	}
+
+	n := c.s.fileIDs.Add(1)
+	o.id = fileID{n, n}
+	req.sess.opens[o.id] = o
+	fs.Debugf(p, "SMB open %q action %d", name, action)
+
+	body = make([]byte, 88)
+	le.PutUint16(body[0:], 89)
+	le.PutUint32(body[4:], action)
+	putTimes(body[8:], node)
+	le.PutUint64(body[40:], allocationSize(node))
+	le.PutUint64(body[48:], endOfFile(node))
+	le.PutUint32(body[56:], attributes(node))
+	o.id.encode(body[64:])
+	return statusSuccess, body, o.id
+}
+
+// handleClose closes an open file
+func (c *conn) handleClose(req *request, o *open) (status uint32, body []byte) {
+	delete(req.sess.opens, o.id)
+	o.close()
+	body = make([]byte, 60)
+	le.PutUint16(body[0:], 60)
+	if le.Uint16(req.body[2:])&0x0001 != 0 {
+		// POSTQUERY_ATTRIB
+		if node, err := o.stat(); err == nil {
+			le.PutUint16(body[2:], 0x0001)
+			putTimes(body[8:], node)
+			le.PutUint64(body[40:], allocationSize(node))
+			le.PutUint64(body[48:], endOfFile(node))
+			le.PutUint32(body[56:], attributes(node))
+		}
+	}
+	return statusSuccess, body
+}
+
+// handleFlush flushes an open file
+func (c *conn) handleFlush(req *request, o *open) (status uint32, body []byte) {
+	if o.handle != nil {
+		// Don't call Flush as that finishes the upload
+		if err := o.handle.Sync(); err != nil && !errors.Is(err, vfs.ENOSYS) {
+			return statusOf(err), nil
+		}
+	}
+	return statusSuccess, []byte{4, 0, 0, 0}
+}
+
+// handleRead reads from an open file
+func (c *conn) handleRead(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	length := le.Uint32(b[4:])
+	offset := int64(le.Uint64(b[8:]))
+	minimum := le.Uint32(b[32:])
+	if length > maxIOSize {
+		return statusInvalidParameter, nil
+	}
+	if o.isDir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.lockConflict(vfs.LockRead, offset, int64(length)) {
+		return statusFileLockConflict, nil
+	}
+	h, err := o.getHandle(false, 0)
+	if err != nil {
+		return statusOf(err), nil
+	}
+	body = make([]byte, 16+length)
+	n, err := h.ReadAt(body[16:], offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return statusOf(err), nil
+	}
+	if n == 0 || uint32(n) < minimum {
+		return statusEndOfFile, nil
+	}
+	body = body[:16+n]
+	le.PutUint16(body[0:], 17)
+	body[2] = headerSize + 16
+	le.PutUint32(body[4:], uint32(n))
+	return statusSuccess, body
+}
+
+// handleWrite writes to an open file
+func (c *conn) handleWrite(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	data, err := buffer(req.msg, int(le.Uint16(b[2:])), int(le.Uint32(b[4:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	offset := int64(le.Uint64(b[8:]))
+	if o.isDir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.lockConflict(vfs.LockWrite, offset, int64(len(data))) {
+		return statusFileLockConflict, nil
+	}
+	h, err := o.getHandle(true, offset)
+	if err != nil {
+		return statusOf(err), nil
+	}
+	n, err := h.WriteAt(data, offset)
+	if err != nil {
+		return statusOf(err), nil
+	}
+	body = make([]byte, 16)
+	le.PutUint16(body[0:], 17)
+	le.PutUint32(body[4:], uint32(n))
+	return statusSuccess, body
+}
+
+// Lock flags
+const (
+	lockFlagShared          = 0x01
+	lockFlagExclusive       = 0x02
+	lockFlagUnlock          = 0x04
+	lockFlagFailImmediately = 0x10
+)
+
+// lockOwner returns the VFS lock owner for the locks of the open
+//
+// SMB byte range locks belong to the open rather than the client.
+func (o *open) lockOwner() string {
+	return fmt.Sprintf("smb:%x", o.id.persistent)
+}
+
+// lockOf returns the VFS lock for an SMB byte range
+//
+// Zero length ranges lock nothing so ok is false for them.
+func (o *open) lockOf(lockType vfs.LockType, offset, length uint64) (l vfs.Lock, ok bool, status uint32) {
+	if offset > math.MaxInt64 || (length != 0 && offset+length-1 < offset) {
+		return l, false, statusInvalidLockRange
+	}
+	if length == 0 {
+		return l, false, statusSuccess
+	}
+	l = vfs.Lock{Owner: o.lockOwner(), Type: lockType, Start: int64(offset), End: vfs.LockEOF}
+	if end := offset + length; end < math.MaxInt64 {
+		l.End = int64(end)
+	}
+	return l, true, statusSuccess
+}
+
+// lockConflict returns true if another open has locked bytes which
+// would be read or written
+//
+// SMB locks are mandatory so reads and writes must respect them.
+func (o *open) lockConflict(lockType vfs.LockType, offset, length int64) bool {
+	if offset < 0 || length <= 0 {
+		return false
+	}
+	l, ok, _ := o.lockOf(lockType, uint64(offset), uint64(length))
+	if !ok {
+		return false
+	}
+	_, found := o.tree.vfs.TestLock(o.path, l)
+	return found
+}
+
+// handleLock takes or releases byte range locks
+//
+// Blocking lock requests aren't queued - they fail straight away if
+// the range is locked and the client will retry.
+func (c *conn) handleLock(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	count := int(le.Uint16(b[2:]))
+	if count == 0 || len(b) < 24+24*count {
+		return statusInvalidParameter, nil
+	}
+	if o.isDir {
+		return statusInvalidDeviceRequest, nil
+	}
+	VFS := o.tree.vfs
+	var taken []vfs.Lock
+	for i := range count {
+		e := b[24+24*i:]
+		offset, length, flags := le.Uint64(e), le.Uint64(e[8:]), le.Uint32(e[16:])
+		lockType := vfs.LockRead
+		switch flags &^ lockFlagFailImmediately {
+		case lockFlagShared:
+		case lockFlagExclusive:
+			lockType = vfs.LockWrite
+		case lockFlagUnlock:
+			l, ok, status := o.lockOf(lockType, offset, length)
+			if status != statusSuccess {
+				return status, nil
+			}
+			if !ok {
+				continue
+			}
+			if !o.holdsLock(l) {
+				return statusRangeNotLocked, nil
+			}
+			VFS.Unlock(o.path, l)
+			continue
+		default:
+			status = statusInvalidParameter
+		}
+		l, ok, lockStatus := o.lockOf(lockType, offset, length)
+		if status == statusSuccess {
+			status = lockStatus
+		}
+		if status == statusSuccess && ok {
+			if err := VFS.Lock(o.path, l); errors.Is(err, vfs.EAGAIN) {
+				status = statusLockNotGranted
+			} else if err != nil {
+				status = statusOf(err)
+			}
+		}
+		if status != statusSuccess {
+			// undo the locks taken by this request
+			for _, l := range taken {
+				VFS.Unlock(o.path, l)
+			}
+			return status, nil
+		}
+		if ok {
+			taken = append(taken, l)
+		}
+	}
+	return statusSuccess, []byte{4, 0, 0, 0}
+}
+
+// holdsLock returns true if the open holds a lock overlapping l
+func (o *open) holdsLock(l vfs.Lock) bool {
+	for _, held := range o.tree.vfs.Locks(o.path) {
+		if held.Owner == l.Owner && held.Start < l.End && l.Start < held.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Query directory flags
+const (
+	restartScans      = 0x01
+	returnSingleEntry = 0x02
+	reopen            = 0x10
+)
+
+// handleQueryDirectory lists an open directory
+func (c *conn) handleQueryDirectory(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	class := b[2]
+	flags := b[3]
+	patternBytes, err := buffer(req.msg, int(le.Uint16(b[24:])), int(le.Uint16(b[26:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	outputLength := int(le.Uint32(b[28:]))
+	if !o.isDir {
+		return statusInvalidParameter, nil
+	}
+	if !dirInfoClasses[class] {
+		return statusInvalidInfoClass, nil
+	}
+	first := o.entries == nil || flags&(restartScans|reopen) != 0
+	if first {
+		pattern := decodeUTF16(patternBytes)
+		if pattern == "" {
+			pattern = "*"
+		}
+		if err := o.list(pattern); err != nil {
+			return statusOf(err), nil
+		}
+		if len(o.entries) == 0 {
+			return statusNoSuchFile, nil
+		}
+	}
+	if o.pos >= len(o.entries) {
+		return statusNoMoreFiles, nil
+	}
+	var out []byte
+	last := -1
+	for o.pos < len(o.entries) {
+		entry := dirInfo(class, o.entries[o.pos], o.names[o.pos])
+		start := align8(len(out))
+		if start+len(entry) > outputLength {
+			break
+		}
+		out = append(out, make([]byte, start-len(out))...)
+		if last >= 0 {
+			le.PutUint32(out[last:], uint32(start-last))
+		}
+		last = start
+		out = append(out, entry...)
+		o.pos++
+		if flags&returnSingleEntry != 0 {
+			break
+		}
+	}
+	if len(out) == 0 {
+		return statusInfoLengthMismatch, nil
+	}
+	body = make([]byte, 8, 8+len(out))
+	le.PutUint16(body[0:], 9)
+	le.PutUint16(body[2:], headerSize+8)
+	le.PutUint32(body[4:], uint32(len(out)))
+	return statusSuccess, append(body, out...)
+}
+
+// list reads the directory into the open keeping the entries which
+// match the pattern
+func (o *open) list(pattern string) error {
+	node, err := o.stat()
+	if err != nil {
+		return err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return vfs.ENOENT
+	}
+	items, err := dir.ReadDirAll()
+	if err != nil {
+		return err
+	}
+	o.entries = make([]vfs.Node, 0, len(items)+2)
+	o.names = make([]string, 0, len(items)+2)
+	o.pos = 0
+	add := func(node vfs.Node, name string) {
+		if matchPattern(pattern, name) {
+			o.entries = append(o.entries, node)
+			o.names = append(o.names, name)
+		}
+	}
+	add(dir, ".")
+	parent := dir
+	if o.path != "" {
+		if p, err := o.tree.vfs.Stat(path.Dir(o.path)); err == nil {
+			if d, ok := p.(*vfs.Dir); ok {
+				parent = d
+			}
+		}
+	}
+	add(parent, "..")
+	for _, item := range items {
+		add(item, item.Name())
+	}
+	return nil
+}
+
+// matchPattern matches name against a Windows wildcard pattern case
+// insensitively
+func matchPattern(pattern, name string) bool {
+	if pattern == "*" || pattern == "*.*" {
+		return true
+	}
+	p := []rune(strings.ToLower(pattern))
+	n := []rune(strings.ToLower(name))
+	var match func(p, n []rune) bool
+	match = func(p, n []rune) bool {
+		for len(p) > 0 {
+			switch p[0] {
+			case '*', '<':
+				for i := len(n); i >= 0; i-- {
+					if match(p[1:], n[i:]) {
+						return true
+					}
+				}
+				return false
+			case '?', '>':
+				if len(n) == 0 {
+					return p[0] == '>' && match(p[1:], n)
+				}
+			case '"':
+				if len(n) == 0 {
+					return match(p[1:], n)
+				}
+				if n[0] != '.' {
+					return false
+				}
+			default:
+				if len(n) == 0 || n[0] != p[0] {
+					return false
+				}
+			}
+			p, n = p[1:], n[1:]
+		}
+		return len(n) == 0
+	}
+	return match(p, n)
+}
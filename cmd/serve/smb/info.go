@@ -0,0 +1,484 @@
+package smb
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// File attributes
+const (
+	fileAttributeReadonly  = 0x00000001
+	fileAttributeDirectory = 0x00000010
+	fileAttributeArchive   = 0x00000020
+)
+
+// Info types
+const (
+	infoFile       = 0x01
+	infoFilesystem = 0x02
+	infoSecurity   = 0x03
+)
+
+// File information classes
+const (
+	fileDirectoryInformation       = 1
+	fileFullDirectoryInformation   = 2
+	fileBothDirectoryInformation   = 3
+	fileBasicInformation           = 4
+	fileStandardInformation        = 5
+	fileInternalInformation        = 6
+	fileEaInformation              = 7
+	fileAccessInformation          = 8
+	fileRenameInformation          = 10
+	fileNamesInformation           = 12
+	fileDispositionInformation     = 13
+	filePositionInformation        = 14
+	fileModeInformation            = 16
+	fileAlignmentInformation       = 17
+	fileAllInformation             = 18
+	fileAllocationInformation      = 19
+	fileEndOfFileInformation       = 20
+	fileStreamInformation          = 22
+	fileNetworkOpenInformation     = 34
+	fileAttributeTagInformation    = 35
+	fileIDBothDirectoryInformation = 37
+	fileIDFullDirectoryInformation = 38
+	fileNormalizedNameInformation  = 48
+	fileDispositionInformationEx   = 64
+	fileFsVolumeInformation        = 1
+	fileFsSizeInformation          = 3
+	fileFsDeviceInformation        = 4
+	fileFsAttributeInformation     = 5
+	fileFsFullSizeInformation      = 7
+	fileFsSectorSizeInformation    = 11
+)
+
+// Security information
+const (
+	securityInformationOwner = 0x01
+	securityInformationGroup = 0x02
+	securityInformationDACL  = 0x04
+)
+
+// File system geometry and attributes
+const (
+	bytesPerSector = 512
+	sectorsPerUnit = 8
+	bytesPerUnit   = bytesPerSector * sectorsPerUnit
+	fsAttributes   = 0x00000007 // case sensitive search, case preserved names, unicode on disk
+)
+
+// attributes returns the file attributes of node
+func attributes(node vfs.Node) uint32 {
+	var attr uint32 = fileAttributeArchive
+	if node.IsDir() {
+		attr = fileAttributeDirectory
+	}
+	if node.VFS().Opt.ReadOnly {
+		attr |= fileAttributeReadonly
+	}
+	return attr
+}
+
+func endOfFile(node vfs.Node) uint64 {
+	if node.IsDir() {
+		return 0
+	}
+	return uint64(max(node.Size(), 0))
+}
+
+func allocationSize(node vfs.Node) uint64 {
+	return (endOfFile(node) + bytesPerUnit - 1) &^ (bytesPerUnit - 1)
+}
+
+// putTimes writes the creation, access, write and change times of
+// node into b
+func putTimes(b []byte, node vfs.Node) {
+	t := toFiletime(node.ModTime())
+	for i := range 4 {
+		le.PutUint64(b[8*i:], t)
+	}
+}
+
+// dirInfoClasses are the information classes which can be used to
+// list directories
+var dirInfoClasses = map[byte]bool{
+	fileDirectoryInformation:       true,
+	fileFullDirectoryInformation:   true,
+	fileBothDirectoryInformation:   true,
+	fileNamesInformation:           true,
+	fileIDBothDirectoryInformation: true,
+	fileIDFullDirectoryInformation: true,
+}
+
+// dirInfo encodes a directory entry for node called name in the
+// information class
+func dirInfo(class byte, node vfs.Node, name string) []byte {
+	nameBytes := encodeUTF16(name)
+	var b []byte
+	if class == fileNamesInformation {
+		b = make([]byte, 12)
+		le.PutUint32(b[8:], uint32(len(nameBytes)))
+		return append(b, nameBytes...)
+	}
+	var size int
+	switch class {
+	case fileDirectoryInformation:
+		size = 64
+	case fileFullDirectoryInformation:
+		size = 68
+	case fileBothDirectoryInformation:
+		size = 94
+	case fileIDBothDirectoryInformation:
+		size = 104
+	case fileIDFullDirectoryInformation:
+		size = 80
+	}
+	b = make([]byte, size, size+len(nameBytes))
+	putTimes(b[8:], node)
+	le.PutUint64(b[40:], endOfFile(node))
+	le.PutUint64(b[48:], allocationSize(node))
+	le.PutUint32(b[56:], attributes(node))
+	le.PutUint32(b[60:], uint32(len(nameBytes)))
+	switch class {
+	case fileIDBothDirectoryInformation:
+		le.PutUint64(b[96:], node.Inode())
+	case fileIDFullDirectoryInformation:
+		le.PutUint64(b[72:], node.Inode())
+	}
+	return append(b, nameBytes...)
+}
+
+// handleQueryInfo returns information about an open file
+func (c *conn) handleQueryInfo(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 40 {
+		return statusInvalidParameter, nil
+	}
+	infoType := b[2]
+	class := b[3]
+	outputLength := int(le.Uint32(b[4:]))
+	additional := le.Uint32(b[16:])
+	node, err := o.stat()
+	if err != nil {
+		return statusOf(err), nil
+	}
+	var out []byte
+	variable := false // set if the output can be truncated
+	switch infoType {
+	case infoFile:
+		out, variable = o.fileInfo(class, node)
+	case infoFilesystem:
+		out = fsInfo(class, o.tree.vfs)
+	case infoSecurity:
+		out, variable = securityDescriptor(additional), true
+	}
+	if out == nil {
+		return statusInvalidInfoClass, nil
+	}
+	status = statusSuccess
+	if len(out) > outputLength {
+		if !variable {
+			return statusInfoLengthMismatch, nil
+		}
+		out = out[:outputLength]
+		status = statusBufferOverflow
+	}
+	body = make([]byte, 8, 8+len(out))
+	le.PutUint16(body[0:], 9)
+	le.PutUint16(body[2:], headerSize+8)
+	le.PutUint32(body[4:], uint32(len(out)))
+	return status, append(body, out...)
+}
+
+// fileInfo encodes the file information class for node returning nil
+// if not supported. variable is set if the output can be truncated.
+func (o *open) fileInfo(class byte, node vfs.Node) (out []byte, variable bool) {
+	basic := func() []byte {
+		b := make([]byte, 40)
+		putTimes(b, node)
+		le.PutUint32(b[32:], attributes(node))
+		return b
+	}
+	standard := func() []byte {
+		b := make([]byte, 24)
+		le.PutUint64(b[0:], allocationSize(node))
+		le.PutUint64(b[8:], endOfFile(node))
+		le.PutUint32(b[16:], 1)
+		if o.deleteOnClose {
+			b[20] = 1
+		}
+		if node.IsDir() {
+			b[21] = 1
+		}
+		return b
+	}
+	name := func() []byte {
+		nameBytes := encodeUTF16(`\` + strings.ReplaceAll(o.path, "/", `\`))
+		b := make([]byte, 4, 4+len(nameBytes))
+		le.PutUint32(b, uint32(len(nameBytes)))
+		return append(b, nameBytes...)
+	}
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		le.PutUint32(b, v)
+		return b
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		le.PutUint64(b, v)
+		return b
+	}
+	switch class {
+	case fileBasicInformation:
+		return basic(), false
+	case fileStandardInformation:
+		return standard(), false
+	case fileInternalInformation:
+		return u64(node.Inode()), false
+	case fileEaInformation:
+		return u32(0), false
+	case fileAccessInformation:
+		return u32(o.access), false
+	case filePositionInformation:
+		return u64(0), false
+	case fileModeInformation, fileAlignmentInformation:
+		return u32(0), false
+	case fileAllInformation:
+		out = append(basic(), standard()...)
+		out = append(out, u64(node.Inode())...)
+		out = append(out, u32(0)...)        // EaSize
+		out = append(out, u32(o.access)...) // AccessFlags
+		out = append(out, u64(0)...)        // CurrentByteOffset
+		out = append(out, u32(0)...)        // Mode
+		out = append(out, u32(0)...)        // AlignmentRequirement
+		return append(out, name()...), true
+	case fileNormalizedNameInformation:
+		return name(), true
+	case fileStreamInformation:
+		if node.IsDir() {
+			return []byte{}, true
+		}
+		streamName := encodeUTF16("::$DATA")
+		out = make([]byte, 24, 24+len(streamName))
+		le.PutUint32(out[4:], uint32(len(streamName)))
+		le.PutUint64(out[8:], endOfFile(node))
+		le.PutUint64(out[16:], allocationSize(node))
+		return append(out, streamName...), true
+	case fileNetworkOpenInformation:
+		out = make([]byte, 56)
+		putTimes(out, node)
+		le.PutUint64(out[32:], allocationSize(node))
+		le.PutUint64(out[40:], endOfFile(node))
+		le.PutUint32(out[48:], attributes(node))
+		return out, false
+	case fileAttributeTagInformation:
+		out = make([]byte, 8)
+		le.PutUint32(out, attributes(node))
+		return out, false
+	}
+	return nil, false
+}
+
+// fsInfo encodes the file system information class returning nil if
+// not supported
+func fsInfo(class byte, VFS *vfs.VFS) (out []byte) {
+	switch class {
+	case fileFsVolumeInformation:
+		label := encodeUTF16("rclone")
+		out = make([]byte, 18, 18+len(label))
+		le.PutUint32(out[8:], 0x72636c6e) // serial number
+		le.PutUint32(out[12:], uint32(len(label)))
+		return append(out, label...)
+	case fileFsSizeInformation, fileFsFullSizeInformation:
+		total, _, free := VFS.Statfs()
+		totalUnits, freeUnits := uint64(max(total, 0))/bytesPerUnit, uint64(max(free, 0))/bytesPerUnit
+		if class == fileFsSizeInformation {
+			out = make([]byte, 24)
+			le.PutUint64(out[0:], totalUnits)
+			le.PutUint64(out[8:], freeUnits)
+			le.PutUint32(out[16:], sectorsPerUnit)
+			le.PutUint32(out[20:], bytesPerSector)
+			return out
+		}
+		out = make([]byte, 32)
+		le.PutUint64(out[0:], totalUnits)
+		le.PutUint64(out[8:], freeUnits)
+		le.PutUint64(out[16:], freeUnits)
+		le.PutUint32(out[24:], sectorsPerUnit)
+		le.PutUint32(out[28:], bytesPerSector)
+		return out
+	case fileFsDeviceInformation:
+		out = make([]byte, 8)
+		le.PutUint32(out[0:], 0x07) // FILE_DEVICE_DISK
+		le.PutUint32(out[4:], 0x20) // FILE_DEVICE_IS_MOUNTED
+		return out
+	case fileFsAttributeInformation:
+		fsName := encodeUTF16("NTFS")
+		out = make([]byte, 12, 12+len(fsName))
+		le.PutUint32(out[0:], fsAttributes)
+		le.PutUint32(out[4:], 255)
+		le.PutUint32(out[8:], uint32(len(fsName)))
+		return append(out, fsName...)
+	case fileFsSectorSizeInformation:
+		out = make([]byte, 28)
+		for i := range 4 {
+			le.PutUint32(out[4*i:], bytesPerSector)
+		}
+		return out
+	}
+	return nil
+}
+
+// everyoneSID is S-1-1-0
+var everyoneSID = []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+// securityDescriptor returns a self relative security descriptor
+// owned by everyone giving everyone full access
+func securityDescriptor(additional uint32) []byte {
+	out := make([]byte, 20)
+	out[0] = 1                    // Revision
+	le.PutUint16(out[2:], 0x8000) // SE_SELF_RELATIVE
+	if additional&securityInformationOwner != 0 {
+		le.PutUint32(out[4:], uint32(len(out)))
+		out = append(out, everyoneSID...)
+	}
+	if additional&securityInformationGroup != 0 {
+		le.PutUint32(out[8:], uint32(len(out)))
+		out = append(out, everyoneSID...)
+	}
+	if additional&securityInformationDACL != 0 {
+		le.PutUint16(out[2:], 0x8004) // SE_SELF_RELATIVE | SE_DACL_PRESENT
+		le.PutUint32(out[16:], uint32(len(out)))
+		ace := make([]byte, 8, 8+len(everyoneSID))
+		ace[0] = 0 // ACCESS_ALLOWED_ACE_TYPE
+		ace[1] = 3 // OBJECT_INHERIT_ACE | CONTAINER_INHERIT_ACE
+		le.PutUint16(ace[2:], uint16(8+len(everyoneSID)))
+		le.PutUint32(ace[4:], accessFull)
+		ace = append(ace, everyoneSID...)
+		acl := make([]byte, 8, 8+len(ace))
+		acl[0] = 2 // ACL_REVISION
+		le.PutUint16(acl[2:], uint16(8+len(ace)))
+		le.PutUint16(acl[4:], 1)
+		out = append(out, append(acl, ace...)...)
+	}
+	return out
+}
+
+// handleSetInfo changes an open file
+func (c *conn) handleSetInfo(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	infoType := b[2]
+	class := b[3]
+	in, err := buffer(req.msg, int(le.Uint16(b[8:])), int(le.Uint32(b[4:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	ok := []byte{2, 0}
+	switch infoType {
+	case infoSecurity:
+		// Permissions can't be set so pretend they were
+		return statusSuccess, ok
+	case infoFile:
+	default:
+		return statusNotSupported, nil
+	}
+	VFS := o.tree.vfs
+	switch class {
+	case fileBasicInformation:
+		if len(in) < 40 {
+			return statusInfoLengthMismatch, nil
+		}
+		mtime, ok := fromFiletime(le.Uint64(in[16:]))
+		if !ok {
+			break
+		}
+		if o.handle != nil && o.writable {
+			// Set the time when the file is closed
+			node := o.handle.Node()
+			err = node.SetModTime(mtime)
+		} else {
+			err = VFS.Chtimes(o.path, mtime, mtime)
+		}
+		if err != nil {
+			return statusOf(err), nil
+		}
+	case fileRenameInformation:
+		if len(in) < 20 {
+			return statusInfoLengthMismatch, nil
+		}
+		replace := in[0] != 0
+		nameLength := int(le.Uint32(in[16:]))
+		if 20+nameLength > len(in) {
+			return statusInvalidParameter, nil
+		}
+		newPath := vfsPath(decodeUTF16(in[20 : 20+nameLength]))
+		if newPath == "" || o.path == "" {
+			return statusAccessDenied, nil
+		}
+		if !strings.EqualFold(newPath, o.path) {
+			if node, err := VFS.Stat(newPath); err == nil {
+				if !replace {
+					return statusObjectNameCollision, nil
+				}
+				if node.IsDir() {
+					return statusAccessDenied, nil
+				}
+			}
+		}
+		if err := VFS.Rename(o.path, newPath); err != nil {
+			return statusOf(err), nil
+		}
+		fs.Debugf(o.path, "SMB renamed to %q", newPath)
+		o.path = newPath
+	case fileDispositionInformation, fileDispositionInformationEx:
+		if len(in) < 1 {
+			return statusInfoLengthMismatch, nil
+		}
+		deletePending := in[0]&0x01 != 0
+		if deletePending {
+			if o.path == "" || VFS.Opt.ReadOnly {
+				return statusAccessDenied, nil
+			}
+			if o.isDir {
+				if entries, err := VFS.ReadDir(o.path); err != nil {
+					return statusOf(err), nil
+				} else if len(entries) > 0 {
+					return statusDirectoryNotEmpty, nil
+				}
+			}
+		}
+		o.deleteOnClose = deletePending
+	case fileAllocationInformation:
+		// nothing to do
+	case fileEndOfFileInformation:
+		if len(in) < 8 {
+			return statusInfoLengthMismatch, nil
+		}
+		size := int64(le.Uint64(in))
+		h, err := o.getHandle(true, size)
+		if err != nil {
+			return statusOf(err), nil
+		}
+		if err = h.Truncate(size); err != nil {
+			if errors.Is(err, vfs.EPERM) && VFS.Opt.CacheMode < vfscommon.CacheModeWrites {
+				// Clients set the size before writing when copying
+				// which can't be done without the cache, but the
+				// writes will make the file the right size.
+				fs.Debugf(o.path, "SMB ignoring set size to %d without --vfs-cache-mode writes", size)
+				break
+			}
+			return statusOf(err), nil
+		}
+	default:
+		return statusNotSupported, nil
+	}
+	return statusSuccess, ok
+}
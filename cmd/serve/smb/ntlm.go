@@ -0,0 +1,354 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/subtle"
+	"encoding/asn1"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // NTLM needs MD4
+)
+
+// This implements the server side of NTLMv2 authentication wrapped in
+// SPNEGO as described in [MS-NLMP] and RFC 4178.
+
+var (
+	spnegoOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	ntlmOID   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+// SPNEGO negotiation states
+const (
+	negStateAcceptCompleted  = 0
+	negStateAcceptIncomplete = 1
+	negStateReject           = 2
+)
+
+type negTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"explicit,optional,tag:1"`
+	MechToken   []byte                  `asn1:"explicit,optional,tag:2"`
+	MechListMIC []byte                  `asn1:"explicit,optional,tag:3"`
+}
+
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// negTokenRespOut is negTokenResp with the state always present as
+// accept-completed is the zero value
+type negTokenRespOut struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// spnegoInitToken returns the token sent in the negotiate response
+// advertising that NTLM can be used
+func spnegoInitToken() []byte {
+	oid, _ := asn1.Marshal(spnegoOID)
+	init, _ := asn1.MarshalWithParams(negTokenInit{MechTypes: []asn1.ObjectIdentifier{ntlmOID}}, "explicit,tag:0")
+	token, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, init...)})
+	return token
+}
+
+// decodeSpnegoInit decodes the first token from the client returning
+// the NTLM token and the DER encoded mechanism list
+func decodeSpnegoInit(token []byte) (mechToken, mechList []byte, err error) {
+	var outer asn1.RawValue
+	if _, err = asn1.Unmarshal(token, &outer); err != nil {
+		return nil, nil, err
+	}
+	if outer.Class != asn1.ClassApplication || outer.Tag != 0 {
+		return nil, nil, errors.New("not a SPNEGO token")
+	}
+	var oid asn1.ObjectIdentifier
+	rest, err := asn1.Unmarshal(outer.Bytes, &oid)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !oid.Equal(spnegoOID) {
+		return nil, nil, errors.New("not a SPNEGO token")
+	}
+	var init negTokenInit
+	if _, err = asn1.UnmarshalWithParams(rest, &init, "explicit,tag:0"); err != nil {
+		return nil, nil, err
+	}
+	if !containsOID(init.MechTypes, ntlmOID) {
+		return nil, nil, errors.New("client doesn't offer NTLM")
+	}
+	mechList, err = asn1.Marshal(init.MechTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(init.MechToken) == 0 || !init.MechTypes[0].Equal(ntlmOID) {
+		// The optimistic token is for another mechanism
+		return nil, mechList, nil
+	}
+	return init.MechToken, mechList, nil
+}
+
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range oids {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSpnegoResp decodes a subsequent token from the client
+func decodeSpnegoResp(token []byte) (resp negTokenResp, err error) {
+	_, err = asn1.UnmarshalWithParams(token, &resp, "explicit,tag:1")
+	return resp, err
+}
+
+// encodeSpnegoResp encodes a response token to the client
+func encodeSpnegoResp(state int, mechToken, mic []byte) []byte {
+	resp := negTokenRespOut{
+		NegState:      asn1.Enumerated(state),
+		ResponseToken: mechToken,
+		MechListMIC:   mic,
+	}
+	if state == negStateAcceptIncomplete {
+		resp.SupportedMech = ntlmOID
+	}
+	token, _ := asn1.MarshalWithParams(resp, "explicit,tag:1")
+	return token
+}
+
+// NTLM negotiate flags
+const (
+	ntlmNegotiateUnicode                 uint32 = 0x00000001
+	ntlmRequestTarget                    uint32 = 0x00000004
+	ntlmNegotiateSign                    uint32 = 0x00000010
+	ntlmNegotiateSeal                    uint32 = 0x00000020
+	ntlmNegotiateNTLM                    uint32 = 0x00000200
+	ntlmNegotiateAlwaysSign              uint32 = 0x00008000
+	ntlmTargetTypeServer                 uint32 = 0x00020000
+	ntlmNegotiateExtendedSessionsecurity uint32 = 0x00080000
+	ntlmNegotiateTargetInfo              uint32 = 0x00800000
+	ntlmNegotiateVersion                 uint32 = 0x02000000
+	ntlmNegotiate128                     uint32 = 0x20000000
+	ntlmNegotiateKeyExch                 uint32 = 0x40000000
+	ntlmNegotiate56                      uint32 = 0x80000000
+)
+
+// NTLM message types
+const (
+	ntlmNegotiateMessage    = 1
+	ntlmChallengeMessage    = 2
+	ntlmAuthenticateMessage = 3
+)
+
+// AV pair IDs in the target info
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+	avTimestamp       = 7
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+var errLogonFailure = errors.New("logon failure")
+
+// ntlmServer holds the state of one NTLM authentication
+type ntlmServer struct {
+	computerName string
+	domainName   string
+	challenge    [8]byte
+	flags        uint32
+}
+
+// ntlmAuth is the result of a successful NTLM authentication
+type ntlmAuth struct {
+	user       string
+	domain     string
+	anonymous  bool
+	sessionKey []byte // nil if anonymous
+	flags      uint32
+}
+
+// challengeMessage processes the NEGOTIATE_MESSAGE from the client
+// and returns the CHALLENGE_MESSAGE to send back
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if len(negotiate) < 16 || !bytes.Equal(negotiate[:8], ntlmSignature) || le.Uint32(negotiate[8:]) != ntlmNegotiateMessage {
+		return nil, errors.New("bad NTLM negotiate message")
+	}
+	clientFlags := le.Uint32(negotiate[12:])
+	n.flags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmTargetTypeServer | ntlmNegotiateExtendedSessionsecurity | ntlmNegotiateTargetInfo |
+		ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiate56
+	n.flags |= clientFlags & (ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateKeyExch)
+	if _, err := rand.Read(n.challenge[:]); err != nil {
+		return nil, err
+	}
+
+	targetName := encodeUTF16(n.computerName)
+	var targetInfo []byte
+	addAV := func(id uint16, value []byte) {
+		var b [4]byte
+		le.PutUint16(b[0:], id)
+		le.PutUint16(b[2:], uint16(len(value)))
+		targetInfo = append(targetInfo, b[:]...)
+		targetInfo = append(targetInfo, value...)
+	}
+	addAV(avNbDomainName, encodeUTF16(n.domainName))
+	addAV(avNbComputerName, targetName)
+	addAV(avDNSDomainName, encodeUTF16(strings.ToLower(n.domainName)))
+	addAV(avDNSComputerName, encodeUTF16(strings.ToLower(n.computerName)))
+	var timestamp [8]byte
+	le.PutUint64(timestamp[:], toFiletime(time.Now()))
+	addAV(avTimestamp, timestamp[:])
+	addAV(avEOL, nil)
+
+	const payloadOffset = 56
+	msg := make([]byte, payloadOffset, payloadOffset+len(targetName)+len(targetInfo))
+	copy(msg, ntlmSignature)
+	le.PutUint32(msg[8:], ntlmChallengeMessage)
+	putField(msg[12:], len(targetName), payloadOffset)
+	le.PutUint32(msg[20:], n.flags)
+	copy(msg[24:], n.challenge[:])
+	putField(msg[40:], len(targetInfo), payloadOffset+len(targetName))
+	copy(msg[48:], []byte{10, 0, 0x7C, 0x4F, 0, 0, 0, 0x0F}) // version 10.0.20348, NTLM revision 15
+	msg = append(msg, targetName...)
+	msg = append(msg, targetInfo...)
+	return msg, nil
+}
+
+// putField writes an NTLM length, max length, offset field
+func putField(b []byte, length, offset int) {
+	le.PutUint16(b[0:], uint16(length))
+	le.PutUint16(b[2:], uint16(length))
+	le.PutUint32(b[4:], uint32(offset))
+}
+
+// field reads an NTLM length, max length, offset field from msg
+func field(msg []byte, at int) ([]byte, error) {
+	length := int(le.Uint16(msg[at:]))
+	offset := int(le.Uint32(msg[at+4:]))
+	if offset+length > len(msg) {
+		return nil, errors.New("bad NTLM field")
+	}
+	return msg[offset : offset+length], nil
+}
+
+// authenticate processes the AUTHENTICATE_MESSAGE from the client.
+//
+// lookup is called with the user name and should call verify with
+// the password of the user returning an error if the user isn't known
+// or verify returned false.
+func (n *ntlmServer) authenticate(msg []byte, lookup func(user string, verify func(password string) bool) error) (*ntlmAuth, error) {
+	if len(msg) < 64 || !bytes.Equal(msg[:8], ntlmSignature) || le.Uint32(msg[8:]) != ntlmAuthenticateMessage {
+		return nil, errors.New("bad NTLM authenticate message")
+	}
+	var fields [6][]byte // LM response, NT response, domain, user, workstation, session key
+	for i := range fields {
+		var err error
+		fields[i], err = field(msg, 12+8*i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ntResponse, encryptedKey := fields[1], fields[5]
+	flags := le.Uint32(msg[60:])
+	decode := func(b []byte) string {
+		if flags&ntlmNegotiateUnicode != 0 {
+			return decodeUTF16(b)
+		}
+		return string(b)
+	}
+	auth := &ntlmAuth{
+		domain: decode(fields[2]),
+		user:   decode(fields[3]),
+		flags:  flags,
+	}
+	if auth.user == "" && len(ntResponse) == 0 {
+		auth.anonymous = true
+		return auth, nil
+	}
+	if len(ntResponse) < 48 {
+		// Only NTLMv2 is supported
+		return nil, errLogonFailure
+	}
+	proof, blob := ntResponse[:16], ntResponse[16:]
+	verify := func(password string) bool {
+		h := md4.New()
+		_, _ = h.Write(encodeUTF16(password))
+		ntHash := h.Sum(nil)
+		for _, domain := range []string{auth.domain, ""} {
+			ntowf := hmacMD5(ntHash, encodeUTF16(strings.ToUpper(auth.user)+domain))
+			expected := hmacMD5(ntowf, n.challenge[:], blob)
+			if subtle.ConstantTimeCompare(expected, proof) == 1 {
+				auth.sessionKey = hmacMD5(ntowf, proof)
+				return true
+			}
+		}
+		return false
+	}
+	if err := lookup(auth.user, verify); err != nil {
+		return nil, err
+	}
+	if auth.sessionKey != nil && flags&ntlmNegotiateKeyExch != 0 && len(encryptedKey) == 16 {
+		c, _ := rc4.NewCipher(auth.sessionKey)
+		exported := make([]byte, 16)
+		c.XORKeyStream(exported, encryptedKey)
+		auth.sessionKey = exported
+	}
+	return auth, nil
+}
+
+// mechListMIC returns the MIC over the SPNEGO mechanism list which
+// the server sends back to the client, signed with the first server
+// sequence number
+func (a *ntlmAuth) mechListMIC(mechList []byte) []byte {
+	signKey := md5Sum(a.sessionKey, []byte("session key to server-to-client signing key magic constant\x00"))
+	var seq [4]byte
+	checksum := hmacMD5(signKey, seq[:], mechList)[:8]
+	if a.flags&ntlmNegotiateKeyExch != 0 {
+		sealKey := a.sessionKey
+		switch {
+		case a.flags&ntlmNegotiate128 != 0:
+		case a.flags&ntlmNegotiate56 != 0:
+			sealKey = sealKey[:7]
+		default:
+			sealKey = sealKey[:5]
+		}
+		sealKey = md5Sum(sealKey, []byte("session key to server-to-client sealing key magic constant\x00"))
+		c, _ := rc4.NewCipher(sealKey)
+		c.XORKeyStream(checksum, checksum)
+	}
+	mic := make([]byte, 16)
+	le.PutUint32(mic, 1)
+	copy(mic[4:], checksum)
+	return mic
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func md5Sum(data ...[]byte) []byte {
+	h := md5.New()
+	for _, d := range data {
+		_, _ = h.Write(d)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,253 @@
+package smb
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+	"unicode/utf16"
+)
+
+// SMB2 commands
+const (
+	smb2Negotiate      uint16 = 0x00
+	smb2SessionSetup   uint16 = 0x01
+	smb2Logoff         uint16 = 0x02
+	smb2TreeConnect    uint16 = 0x03
+	smb2TreeDisconnect uint16 = 0x04
+	smb2Create         uint16 = 0x05
+	smb2Close          uint16 = 0x06
+	smb2Flush          uint16 = 0x07
+	smb2Read           uint16 = 0x08
+	smb2Write          uint16 = 0x09
+	smb2Lock           uint16 = 0x0A
+	smb2Ioctl          uint16 = 0x0B
+	smb2Cancel         uint16 = 0x0C
+	smb2Echo           uint16 = 0x0D
+	smb2QueryDirectory uint16 = 0x0E
+	smb2ChangeNotify   uint16 = 0x0F
+	smb2QueryInfo      uint16 = 0x10
+	smb2SetInfo        uint16 = 0x11
+	smb2OplockBreak    uint16 = 0x12
+)
+
+// Dialects
+const (
+	dialect202      uint16 = 0x0202
+	dialect210      uint16 = 0x0210
+	dialect300      uint16 = 0x0300
+	dialect302      uint16 = 0x0302
+	dialect311      uint16 = 0x0311
+	dialectWildcard uint16 = 0x02FF
+)
+
+// supportedDialects in order of preference
+var supportedDialects = []uint16{dialect311, dialect302, dialect300, dialect210, dialect202}
+
+// Header flags
+const (
+	flagServerToRedir     uint32 = 0x00000001
+	flagAsyncCommand      uint32 = 0x00000002
+	flagRelatedOperations uint32 = 0x00000004
+	flagSigned            uint32 = 0x00000008
+)
+
+// Security modes, capabilities and session flags
+const (
+	negotiateSigningEnabled  uint16 = 0x0001
+	negotiateSigningRequired uint16 = 0x0002
+
+	capLargeMTU uint32 = 0x00000004
+
+	sessionFlagIsGuest uint16 = 0x0001
+	sessionFlagIsNull  uint16 = 0x0002
+)
+
+// Negotiate contexts
+const (
+	preauthIntegrityCapabilities uint16 = 0x0001
+	hashSHA512                   uint16 = 0x0001
+)
+
+// NTSTATUS codes
+const (
+	statusSuccess                uint32 = 0x00000000
+	statusPending                uint32 = 0x00000103
+	statusBufferOverflow         uint32 = 0x80000005
+	statusNoMoreFiles            uint32 = 0x80000006
+	statusUnsuccessful           uint32 = 0xC0000001
+	statusInvalidInfoClass       uint32 = 0xC0000003
+	statusInfoLengthMismatch     uint32 = 0xC0000004
+	statusInvalidParameter       uint32 = 0xC000000D
+	statusNoSuchFile             uint32 = 0xC000000F
+	statusInvalidDeviceRequest   uint32 = 0xC0000010
+	statusEndOfFile              uint32 = 0xC0000011
+	statusMoreProcessingRequired uint32 = 0xC0000016
+	statusAccessDenied           uint32 = 0xC0000022
+	statusObjectNameInvalid      uint32 = 0xC0000033
+	statusObjectNameNotFound     uint32 = 0xC0000034
+	statusObjectNameCollision    uint32 = 0xC0000035
+	statusObjectPathNotFound     uint32 = 0xC000003A
+	statusFileLockConflict       uint32 = 0xC0000054
+	statusLockNotGranted         uint32 = 0xC0000055
+	statusLogonFailure           uint32 = 0xC000006D
+	statusRangeNotLocked         uint32 = 0xC000007E
+	statusFileIsADirectory       uint32 = 0xC00000BA
+	statusNotSupported           uint32 = 0xC00000BB
+	statusNetworkNameDeleted     uint32 = 0xC00000C9
+	statusBadNetworkName         uint32 = 0xC00000CC
+	statusUnexpectedIOError      uint32 = 0xC00000E9
+	statusDirectoryNotEmpty      uint32 = 0xC0000101
+	statusNotADirectory          uint32 = 0xC0000103
+	statusCancelled              uint32 = 0xC0000120
+	statusFileClosed             uint32 = 0xC0000128
+	statusFSDriverRequired       uint32 = 0xC000019C
+	statusInvalidLockRange       uint32 = 0xC00001A1
+	statusUserSessionDeleted     uint32 = 0xC0000203
+	statusNotFound               uint32 = 0xC0000225
+)
+
+// headerSize is the size of the SMB2 header
+const headerSize = 64
+
+// protocolID starts every SMB2 message
+var protocolID = []byte{0xFE, 'S', 'M', 'B'}
+
+// protocolID1 starts every SMB1 message
+var protocolID1 = []byte{0xFF, 'S', 'M', 'B'}
+
+var errMalformed = errors.New("malformed SMB2 message")
+
+// header is the decoded SMB2 header
+type header struct {
+	creditCharge uint16
+	status       uint32 // ChannelSequence in requests
+	command      uint16
+	credits      uint16
+	flags        uint32
+	nextCommand  uint32
+	messageID    uint64
+	asyncID      uint64 // if flagAsyncCommand
+	treeID       uint32
+	sessionID    uint64
+	signature    [16]byte
+}
+
+// decodeHeader decodes the SMB2 header at the start of b
+func decodeHeader(b []byte) (h header, err error) {
+	if len(b) < headerSize || string(b[:4]) != string(protocolID) || le.Uint16(b[4:]) != headerSize {
+		return h, errMalformed
+	}
+	h.creditCharge = le.Uint16(b[6:])
+	h.status = le.Uint32(b[8:])
+	h.command = le.Uint16(b[12:])
+	h.credits = le.Uint16(b[14:])
+	h.flags = le.Uint32(b[16:])
+	h.nextCommand = le.Uint32(b[20:])
+	h.messageID = le.Uint64(b[24:])
+	if h.flags&flagAsyncCommand != 0 {
+		h.asyncID = le.Uint64(b[32:])
+	} else {
+		h.treeID = le.Uint32(b[36:])
+	}
+	h.sessionID = le.Uint64(b[40:])
+	copy(h.signature[:], b[48:64])
+	return h, nil
+}
+
+// encode the header into b which must be headerSize long
+func (h *header) encode(b []byte) {
+	copy(b, protocolID)
+	le.PutUint16(b[4:], headerSize)
+	le.PutUint16(b[6:], h.creditCharge)
+	le.PutUint32(b[8:], h.status)
+	le.PutUint16(b[12:], h.command)
+	le.PutUint16(b[14:], h.credits)
+	le.PutUint32(b[16:], h.flags)
+	le.PutUint32(b[20:], h.nextCommand)
+	le.PutUint64(b[24:], h.messageID)
+	if h.flags&flagAsyncCommand != 0 {
+		le.PutUint64(b[32:], h.asyncID)
+	} else {
+		le.PutUint32(b[32:], 0xFEFF) // ProcessId
+		le.PutUint32(b[36:], h.treeID)
+	}
+	le.PutUint64(b[40:], h.sessionID)
+	copy(b[48:64], h.signature[:])
+}
+
+var le = binary.LittleEndian
+
+// fileID identifies an open file
+type fileID struct {
+	persistent uint64
+	volatile   uint64
+}
+
+// relatedFileID is used in compounded requests to refer to the file
+// opened by the previous request
+var relatedFileID = fileID{^uint64(0), ^uint64(0)}
+
+func decodeFileID(b []byte) fileID {
+	return fileID{le.Uint64(b), le.Uint64(b[8:])}
+}
+
+func (id fileID) encode(b []byte) {
+	le.PutUint64(b, id.persistent)
+	le.PutUint64(b[8:], id.volatile)
+}
+
+// buffer returns the length bytes at offset which is relative to the
+// start of the SMB2 header of msg
+func buffer(msg []byte, offset, length int) ([]byte, error) {
+	if length == 0 {
+		return nil, nil
+	}
+	if offset < headerSize || offset+length > len(msg) {
+		return nil, errMalformed
+	}
+	return msg[offset : offset+length], nil
+}
+
+// encodeUTF16 encodes s as UTF-16LE
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		le.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// decodeUTF16 decodes UTF-16LE bytes into a string
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = le.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// filetime offset from the Unix epoch in 100ns intervals
+const filetimeEpoch = 116444736000000000
+
+// toFiletime converts t into a Windows FILETIME
+func toFiletime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100 + filetimeEpoch)
+}
+
+// fromFiletime converts a Windows FILETIME to a time. ok is false if
+// the value means the time shouldn't be changed.
+func fromFiletime(ft uint64) (t time.Time, ok bool) {
+	if ft == 0 || ft == ^uint64(0) || ft == ^uint64(0)-1 {
+		return t, false
+	}
+	return time.Unix(0, (int64(ft)-filetimeEpoch)*100), true
+}
+
+// align8 rounds n up to a multiple of 8
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
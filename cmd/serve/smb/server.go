@@ -0,0 +1,538 @@
+package smb
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// maxMessageSize is the largest message accepted from a client
+const maxMessageSize = 16 << 20
+
+// maxIOSize is the largest read, write or transaction
+const maxIOSize = 1 << 20
+
+// Server contains everything to run the Server
+type Server struct {
+	opt          Options
+	ctx          context.Context // for global config
+	vfs          *vfs.VFS        // the VFS if not using auth proxy
+	proxy        *proxy.Proxy    // may be nil if not in use
+	listener     net.Listener
+	guid         [16]byte
+	startTime    time.Time
+	computerName string
+	sessionIDs   atomic.Uint64
+	fileIDs      atomic.Uint64
+	wg           sync.WaitGroup
+	mu           sync.Mutex
+	conns        map[*conn]struct{}
+	closed       bool
+}
+
+// NewServer creates a new server serving f or using the auth proxy
+// if f is nil
+func NewServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (s *Server, err error) {
+	s = &Server{
+		ctx:       ctx,
+		opt:       *opt,
+		startTime: time.Now(),
+		conns:     make(map[*conn]struct{}),
+	}
+	if proxyOpt.AuthProxy != "" {
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
+	} else {
+		s.vfs = vfs.New(f, vfsOpt)
+		if vfsOpt.CacheMode < vfscommon.CacheModeWrites {
+			fs.Logf(f, "SMB clients often write files out of order - use --vfs-cache-mode writes or full if writes fail")
+		}
+	}
+	if s.opt.ShareName == "" {
+		return nil, errors.New("share name can't be empty")
+	}
+	s.computerName = s.opt.ServerName
+	if s.computerName == "" {
+		s.computerName, _ = os.Hostname()
+		s.computerName, _, _ = strings.Cut(s.computerName, ".")
+	}
+	s.computerName = strings.ToUpper(s.computerName)
+	if len(s.computerName) > 15 {
+		s.computerName = s.computerName[:15]
+	}
+	if s.computerName == "" {
+		s.computerName = "RCLONE"
+	}
+	if _, err := rand.Read(s.guid[:]); err != nil {
+		return nil, err
+	}
+	s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open listening socket: %w", err)
+	}
+	return s, nil
+}
+
+// Addr returns the listening address of the server
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Serve runs the SMB server until it is shutdown
+func (s *Server) Serve() error {
+	fs.Logf(nil, "SMB Server serving share %q on %s", s.opt.ShareName, s.listener.Addr())
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		c := &conn{
+			s:        s,
+			nc:       nc,
+			sessions: make(map[uint64]*session),
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = nc.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// authenticate checks the user is allowed to log in, calling verify
+// with the password of the user, and returns the VFS to serve them.
+func (s *Server) authenticate(user string, verify func(password string) bool) (*vfs.VFS, error) {
+	if s.proxy != nil {
+		VFS, _, err := s.proxy.CallChallenge(user, verify)
+		return VFS, err
+	}
+	if strings.EqualFold(user, s.opt.User) && verify(s.opt.Pass) {
+		return s.vfs, nil
+	}
+	return nil, errLogonFailure
+}
+
+// guestAllowed returns true if no authentication is configured so
+// every user is let in as a guest
+func (s *Server) guestAllowed() bool {
+	return s.proxy == nil && s.opt.User == ""
+}
+
+// conn is a connection from a client
+type conn struct {
+	s            *Server
+	nc           net.Conn
+	dialect      uint16 // 0 until negotiated
+	clientGUID   [16]byte
+	securityMode uint16 // of the client
+	capabilities uint32 // of the client
+	preauthHash  [64]byte
+	sessions     map[uint64]*session
+}
+
+// session is an authenticated user on a connection
+type session struct {
+	id          uint64
+	ntlm        *ntlmServer // in progress authentication
+	spnego      bool        // set if the client wraps NTLM in SPNEGO
+	mechList    []byte      // the SPNEGO mechanism list from the client
+	preauthHash [64]byte
+	valid       bool // set when authenticated
+	flags       uint16
+	user        string
+	vfs         *vfs.VFS
+	signer      hash.Hash // nil if messages can't be signed
+	signingReq  bool      // set if all messages must be signed
+	trees       map[uint32]*tree
+	nextTreeID  uint32
+	opens       map[fileID]*open
+}
+
+// tree is a connection to a share
+type tree struct {
+	id  uint32
+	ipc bool // the IPC$ share
+	vfs *vfs.VFS
+}
+
+// request is a single request from a client
+type request struct {
+	hdr  header
+	msg  []byte // the whole message including the header
+	body []byte // the message after the header
+	sess *session
+	tree *tree
+}
+
+// serve reads requests from the connection and answers them until
+// it is closed
+func (c *conn) serve() {
+	defer c.close()
+	remote := c.nc.RemoteAddr().String()
+	fs.Debugf(remote, "SMB connection opened")
+	r := bufio.NewReader(c.nc)
+	for {
+		var frame [4]byte
+		if _, err := io.ReadFull(r, frame[:]); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(remote, "SMB read failed: %v", err)
+			}
+			return
+		}
+		size := int(frame[1])<<16 | int(frame[2])<<8 | int(frame[3])
+		if frame[0] != 0 || size > maxMessageSize || size < 4 {
+			fs.Debugf(remote, "SMB bad frame - closing connection")
+			return
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			fs.Debugf(remote, "SMB read failed: %v", err)
+			return
+		}
+		var err error
+		switch {
+		case string(msg[:4]) == string(protocolID1):
+			err = c.negotiateSMB1(msg)
+		case string(msg[:4]) == string(protocolID):
+			err = c.handleCompound(msg)
+		default:
+			err = errors.New("unsupported protocol - encryption is not supported")
+		}
+		if err != nil {
+			fs.Debugf(remote, "SMB closing connection: %v", err)
+			return
+		}
+	}
+}
+
+// close releases everything the connection holds open
+func (c *conn) close() {
+	_ = c.nc.Close()
+	for _, sess := range c.sessions {
+		sess.closeOpens()
+	}
+	fs.Debugf(c.nc.RemoteAddr().String(), "SMB connection closed")
+}
+
+// closeOpens closes all the files the session has open
+func (sess *session) closeOpens() {
+	for _, o := range sess.opens {
+		o.close()
+	}
+	sess.opens = make(map[fileID]*open)
+}
+
+// writeMessage writes a message with the transport framing
+func (c *conn) writeMessage(msg []byte) error {
+	frame := make([]byte, 4, 4+len(msg))
+	frame[1] = byte(len(msg) >> 16)
+	frame[2] = byte(len(msg) >> 8)
+	frame[3] = byte(len(msg))
+	_, err := c.nc.Write(append(frame, msg...))
+	return err
+}
+
+// errorBody is the body of an error response
+var errorBody = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// handleCompound processes the one or more requests in msg and
+// writes the responses
+func (c *conn) handleCompound(msg []byte) error {
+	var (
+		out        []byte
+		prev       *request
+		prevStatus uint32
+		prevFileID = relatedFileID
+	)
+	for len(msg) > 0 {
+		hdr, err := decodeHeader(msg)
+		if err != nil {
+			return err
+		}
+		end := len(msg)
+		if hdr.nextCommand != 0 {
+			end = int(hdr.nextCommand)
+			if end < headerSize || end > len(msg) {
+				return errMalformed
+			}
+		}
+		req := &request{hdr: hdr, msg: msg[:end], body: msg[headerSize:end]}
+		msg = msg[end:]
+		related := hdr.flags&flagRelatedOperations != 0 && prev != nil
+		if related {
+			req.hdr.sessionID = prev.hdr.sessionID
+			req.hdr.treeID = prev.hdr.treeID
+		}
+
+		if hdr.command == smb2Cancel {
+			// Nothing is ever pending so there is nothing to cancel
+			continue
+		}
+
+		var status uint32
+		var body []byte
+		if related && prevStatus != statusSuccess && prevStatus != statusBufferOverflow {
+			// related requests fail with the status of the failed request
+			status = prevStatus
+		} else {
+			status, body = c.dispatch(req, related, &prevFileID)
+		}
+		if hdr.command == smb2Negotiate && c.dialect == 0 {
+			return errors.New("no dialect in common")
+		}
+		if status != statusSuccess && body == nil {
+			body = errorBody
+		}
+
+		// Build the response
+		resp := make([]byte, headerSize, headerSize+len(body)+8)
+		credits := max(hdr.credits, hdr.creditCharge, 1)
+		rhdr := header{
+			creditCharge: hdr.creditCharge,
+			status:       status,
+			command:      hdr.command,
+			credits:      credits,
+			flags:        flagServerToRedir | hdr.flags&flagRelatedOperations,
+			messageID:    hdr.messageID,
+			treeID:       req.hdr.treeID,
+			sessionID:    req.hdr.sessionID,
+		}
+		if req.tree != nil {
+			rhdr.treeID = req.tree.id
+		}
+		if req.sess != nil {
+			rhdr.sessionID = req.sess.id
+		}
+		resp = append(resp, body...)
+		sign := req.sess != nil && req.sess.signer != nil && hdr.command != smb2Negotiate &&
+			(hdr.flags&flagSigned != 0 || req.sess.signingReq ||
+				hdr.command == smb2SessionSetup && status == statusSuccess && (c.dialect == dialect311 || req.sess.signingReq))
+		if len(msg) > 0 {
+			resp = append(resp, make([]byte, align8(len(resp))-len(resp))...)
+			rhdr.nextCommand = uint32(len(resp))
+		}
+		if sign {
+			rhdr.flags |= flagSigned
+		}
+		rhdr.encode(resp)
+		if sign {
+			req.sess.signer.Reset()
+			_, _ = req.sess.signer.Write(resp)
+			copy(resp[48:64], req.sess.signer.Sum(nil))
+		}
+
+		// Update the preauth integrity hashes for SMB 3.1.1
+		if c.dialect == dialect311 {
+			switch {
+			case hdr.command == smb2Negotiate:
+				c.preauthHash = updatePreauth(c.preauthHash, resp)
+			case hdr.command == smb2SessionSetup && status == statusMoreProcessingRequired && req.sess != nil:
+				req.sess.preauthHash = updatePreauth(req.sess.preauthHash, resp)
+			}
+		}
+
+		out = append(out, resp...)
+		prev = req
+		prevStatus = status
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return c.writeMessage(out)
+}
+
+// updatePreauth returns the preauth integrity hash h updated with msg
+func updatePreauth(h [64]byte, msg []byte) [64]byte {
+	d := sha512.New()
+	_, _ = d.Write(h[:])
+	_, _ = d.Write(msg)
+	var out [64]byte
+	d.Sum(out[:0])
+	return out
+}
+
+// verify checks the signature of the request
+func (req *request) verify() bool {
+	signer := req.sess.signer
+	msg := make([]byte, len(req.msg))
+	copy(msg, req.msg)
+	clear(msg[48:64])
+	signer.Reset()
+	_, _ = signer.Write(msg)
+	return subtle.ConstantTimeCompare(signer.Sum(nil)[:16], req.hdr.signature[:]) == 1
+}
+
+// dispatch finds the session and tree of the request and runs the
+// handler for the command.
+//
+// prevFileID is the file used by the previous request in the
+// compound for related requests.
+func (c *conn) dispatch(req *request, related bool, prevFileID *fileID) (status uint32, body []byte) {
+	hdr := &req.hdr
+	if c.dialect == 0 || c.dialect == dialectWildcard {
+		if hdr.command != smb2Negotiate {
+			return statusInvalidParameter, nil
+		}
+		return c.handleNegotiate(req)
+	}
+	switch hdr.command {
+	case smb2Negotiate:
+		// Only one negotiate is allowed per connection
+		return statusInvalidParameter, nil
+	case smb2SessionSetup:
+		return c.handleSessionSetup(req)
+	case smb2Echo:
+		return statusSuccess, []byte{4, 0, 0, 0}
+	}
+
+	// Everything else needs an authenticated session
+	sess := c.sessions[hdr.sessionID]
+	if sess == nil || !sess.valid {
+		return statusUserSessionDeleted, nil
+	}
+	req.sess = sess
+	if sess.signer != nil {
+		if hdr.flags&flagSigned != 0 {
+			if !req.verify() {
+				fs.Debugf(c.nc.RemoteAddr().String(), "SMB bad signature on command %d", hdr.command)
+				return statusAccessDenied, nil
+			}
+		} else if sess.signingReq {
+			return statusAccessDenied, nil
+		}
+	}
+	switch hdr.command {
+	case smb2Logoff:
+		return c.handleLogoff(req)
+	case smb2TreeConnect:
+		return c.handleTreeConnect(req)
+	}
+
+	// Everything else needs a tree
+	req.tree = sess.trees[hdr.treeID]
+	if req.tree == nil {
+		return statusNetworkNameDeleted, nil
+	}
+
+	// Find the file for the request if it has one
+	var o *open
+	if at := fileIDOffset(hdr.command); at > 0 {
+		if len(req.body) < at+16 {
+			return statusInvalidParameter, nil
+		}
+		id := decodeFileID(req.body[at:])
+		if id == relatedFileID && related {
+			id = *prevFileID
+		}
+		o = sess.opens[id]
+		if o == nil || o.tree != req.tree {
+			if hdr.command == smb2Ioctl {
+				// Some IOCTLs don't need a file
+				o = nil
+			} else {
+				return statusFileClosed, nil
+			}
+		}
+		if o != nil {
+			*prevFileID = o.id
+		}
+	}
+
+	switch hdr.command {
+	case smb2TreeDisconnect:
+		return c.handleTreeDisconnect(req)
+	case smb2Create:
+		status, body, id := c.handleCreate(req)
+		if status == statusSuccess {
+			*prevFileID = id
+		}
+		return status, body
+	case smb2Close:
+		return c.handleClose(req, o)
+	case smb2Flush:
+		return c.handleFlush(req, o)
+	case smb2Read:
+		return c.handleRead(req, o)
+	case smb2Write:
+		return c.handleWrite(req, o)
+	case smb2Lock:
+		return c.handleLock(req, o)
+	case smb2Ioctl:
+		return c.handleIoctl(req, o)
+	case smb2QueryDirectory:
+		return c.handleQueryDirectory(req, o)
+	case smb2QueryInfo:
+		return c.handleQueryInfo(req, o)
+	case smb2SetInfo:
+		return c.handleSetInfo(req, o)
+	}
+	// CHANGE_NOTIFY and OPLOCK_BREAK aren't supported as no
+	// notifications or oplocks are ever given
+	return statusNotSupported, nil
+}
+
+// fileIDOffset returns where the FileId is in the body of requests
+// which have one or 0
+func fileIDOffset(command uint16) int {
+	switch command {
+	case smb2Close, smb2Flush, smb2Lock:
+		return 8
+	case smb2Read, smb2Write:
+		return 16
+	case smb2Ioctl:
+		return 8
+	case smb2QueryDirectory:
+		return 8
+	case smb2QueryInfo:
+		return 24
+	case smb2SetInfo:
+		return 16
+	}
+	return 0
+}
@@ -0,0 +1,444 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// serverSecurityMode returns the security mode the server negotiates
+func (c *conn) serverSecurityMode() uint16 {
+	if c.s.opt.RequireSigning {
+		return negotiateSigningEnabled | negotiateSigningRequired
+	}
+	return negotiateSigningEnabled
+}
+
+// serverCapabilities returns the capabilities for the dialect
+func serverCapabilities(dialect uint16) uint32 {
+	if dialect == dialect202 {
+		return 0
+	}
+	return capLargeMTU
+}
+
+// negotiateResponse makes the body of the negotiate response for
+// dialect with the negotiate contexts for SMB 3.1.1
+func (c *conn) negotiateResponse(dialect uint16, contexts []byte) []byte {
+	token := spnegoInitToken()
+	ioSize := uint32(maxIOSize)
+	if dialect == dialect202 || dialect == dialectWildcard {
+		ioSize = 65536
+	}
+	body := make([]byte, 64, 64+len(token)+8+len(contexts))
+	le.PutUint16(body[0:], 65)
+	le.PutUint16(body[2:], c.serverSecurityMode())
+	le.PutUint16(body[4:], dialect)
+	copy(body[8:], c.s.guid[:])
+	le.PutUint32(body[24:], serverCapabilities(dialect))
+	le.PutUint32(body[28:], ioSize)
+	le.PutUint32(body[32:], ioSize)
+	le.PutUint32(body[36:], ioSize)
+	le.PutUint64(body[40:], toFiletime(time.Now()))
+	le.PutUint64(body[48:], toFiletime(c.s.startTime))
+	le.PutUint16(body[56:], headerSize+64)
+	le.PutUint16(body[58:], uint16(len(token)))
+	body = append(body, token...)
+	if len(contexts) > 0 {
+		body = append(body, make([]byte, align8(len(body))-len(body))...)
+		le.PutUint16(body[6:], 1)
+		le.PutUint32(body[60:], uint32(headerSize+len(body)))
+		body = append(body, contexts...)
+	}
+	return body
+}
+
+// negotiateSMB1 answers an SMB1 negotiate which clients send to find
+// out if SMB2 is supported
+func (c *conn) negotiateSMB1(msg []byte) error {
+	if len(msg) < 35 || msg[4] != 0x72 || c.dialect != 0 {
+		return errors.New("SMB1 is not supported")
+	}
+	var dialect uint16
+	for d := range bytes.SplitSeq(msg[35:], []byte{0}) {
+		switch string(bytes.TrimPrefix(d, []byte{2})) {
+		case "SMB 2.???":
+			dialect = dialectWildcard
+		case "SMB 2.002":
+			if dialect == 0 {
+				dialect = dialect202
+			}
+		}
+	}
+	if dialect == 0 {
+		return errors.New("SMB1 is not supported")
+	}
+	if dialect == dialect202 {
+		c.dialect = dialect202
+	}
+	body := c.negotiateResponse(dialect, nil)
+	resp := make([]byte, headerSize, headerSize+len(body))
+	hdr := header{command: smb2Negotiate, flags: flagServerToRedir, credits: 1}
+	hdr.encode(resp)
+	resp = append(resp, body...)
+	return c.writeMessage(resp)
+}
+
+// handleNegotiate chooses the dialect for the connection
+func (c *conn) handleNegotiate(req *request) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 36 {
+		return statusInvalidParameter, nil
+	}
+	count := int(le.Uint16(b[2:]))
+	if len(b) < 36+2*count {
+		return statusInvalidParameter, nil
+	}
+	c.securityMode = le.Uint16(b[4:])
+	c.capabilities = le.Uint32(b[8:])
+	copy(c.clientGUID[:], b[12:28])
+	var dialects []uint16
+	for i := range count {
+		dialects = append(dialects, le.Uint16(b[36+2*i:]))
+	}
+	for _, d := range supportedDialects {
+		if slices.Contains(dialects, d) {
+			c.dialect = d
+			break
+		}
+	}
+	if c.dialect == 0 || c.dialect == dialectWildcard {
+		c.dialect = 0
+		return statusNotSupported, nil
+	}
+	var contexts []byte
+	if c.dialect == dialect311 {
+		if !hasPreauthSHA512(req.msg, int(le.Uint32(b[28:])), int(le.Uint16(b[32:]))) {
+			c.dialect = 0
+			return statusInvalidParameter, nil
+		}
+		contexts = make([]byte, 8+38)
+		le.PutUint16(contexts[0:], preauthIntegrityCapabilities)
+		le.PutUint16(contexts[2:], 38)
+		le.PutUint16(contexts[8:], 1)
+		le.PutUint16(contexts[10:], 32)
+		le.PutUint16(contexts[12:], hashSHA512)
+		_, _ = rand.Read(contexts[14:])
+		c.preauthHash = updatePreauth(c.preauthHash, req.msg)
+	}
+	fs.Debugf(c.nc.RemoteAddr().String(), "SMB negotiated dialect %x", c.dialect)
+	return statusSuccess, c.negotiateResponse(c.dialect, contexts)
+}
+
+// hasPreauthSHA512 returns true if the negotiate contexts in msg
+// offer SHA-512 for preauth integrity
+func hasPreauthSHA512(msg []byte, offset, count int) bool {
+	for range count {
+		if offset+8 > len(msg) {
+			return false
+		}
+		ctxType := le.Uint16(msg[offset:])
+		length := int(le.Uint16(msg[offset+2:]))
+		data := msg[offset+8:]
+		if length > len(data) {
+			return false
+		}
+		data = data[:length]
+		if ctxType == preauthIntegrityCapabilities && len(data) >= 4 {
+			n := int(le.Uint16(data))
+			for i := range n {
+				if 4+2*i+2 <= len(data) && le.Uint16(data[4+2*i:]) == hashSHA512 {
+					return true
+				}
+			}
+		}
+		offset = align8(offset + 8 + length)
+	}
+	return false
+}
+
+// handleSessionSetup runs the NTLM authentication
+func (c *conn) handleSessionSetup(req *request) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 24 {
+		return statusInvalidParameter, nil
+	}
+	if b[2]&0x01 != 0 {
+		// Binding sessions to more channels isn't supported
+		return statusNotSupported, nil
+	}
+	token, err := buffer(req.msg, int(le.Uint16(b[12:])), int(le.Uint16(b[14:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	sess := c.sessions[req.hdr.sessionID]
+	if req.hdr.sessionID == 0 || sess == nil {
+		if req.hdr.sessionID != 0 {
+			return statusUserSessionDeleted, nil
+		}
+		sess = &session{
+			id:          c.s.sessionIDs.Add(1),
+			preauthHash: c.preauthHash,
+			trees:       make(map[uint32]*tree),
+			opens:       make(map[fileID]*open),
+		}
+		c.sessions[sess.id] = sess
+	}
+	req.sess = sess
+	if c.dialect == dialect311 {
+		sess.preauthHash = updatePreauth(sess.preauthHash, req.msg)
+	}
+	fail := func(status uint32) (uint32, []byte) {
+		if !sess.valid {
+			delete(c.sessions, sess.id)
+		}
+		return status, nil
+	}
+
+	// Unwrap the NTLM message
+	var mechToken []byte
+	switch {
+	case bytes.HasPrefix(token, ntlmSignature):
+		sess.spnego = false
+		mechToken = token
+	case len(token) > 0 && token[0] == 0x60:
+		sess.spnego = true
+		mechToken, sess.mechList, err = decodeSpnegoInit(token)
+		if err != nil {
+			fs.Debugf(c.nc.RemoteAddr().String(), "SMB bad SPNEGO token: %v", err)
+			return fail(statusLogonFailure)
+		}
+		if mechToken == nil {
+			// ask the client to use NTLM
+			return statusMoreProcessingRequired, c.sessionSetupResponse(0, encodeSpnegoResp(negStateAcceptIncomplete, nil, nil))
+		}
+	case len(token) > 0 && token[0] == 0xa1:
+		resp, err := decodeSpnegoResp(token)
+		if err != nil {
+			fs.Debugf(c.nc.RemoteAddr().String(), "SMB bad SPNEGO token: %v", err)
+			return fail(statusLogonFailure)
+		}
+		mechToken = resp.ResponseToken
+	default:
+		return fail(statusLogonFailure)
+	}
+	if len(mechToken) < 12 || !bytes.HasPrefix(mechToken, ntlmSignature) {
+		return fail(statusLogonFailure)
+	}
+
+	switch le.Uint32(mechToken[8:]) {
+	case ntlmNegotiateMessage:
+		sess.ntlm = &ntlmServer{
+			computerName: c.s.computerName,
+			domainName:   strings.ToUpper(c.s.opt.Domain),
+		}
+		challenge, err := sess.ntlm.challengeMessage(mechToken)
+		if err != nil {
+			return fail(statusLogonFailure)
+		}
+		if sess.spnego {
+			challenge = encodeSpnegoResp(negStateAcceptIncomplete, challenge, nil)
+		}
+		return statusMoreProcessingRequired, c.sessionSetupResponse(0, challenge)
+	case ntlmAuthenticateMessage:
+		if sess.ntlm == nil {
+			return fail(statusLogonFailure)
+		}
+	default:
+		return fail(statusLogonFailure)
+	}
+
+	// Check the user
+	remote := c.nc.RemoteAddr().String()
+	var VFS = c.s.vfs
+	auth, err := sess.ntlm.authenticate(mechToken, func(user string, verify func(password string) bool) error {
+		if c.s.guestAllowed() {
+			return nil
+		}
+		VFS, err = c.s.authenticate(user, verify)
+		return err
+	})
+	sess.ntlm = nil
+	if err == nil && auth.anonymous && !c.s.guestAllowed() {
+		err = errLogonFailure
+	}
+	if err != nil {
+		fs.Infof(remote, "SMB login failed: %v", err)
+		return fail(statusLogonFailure)
+	}
+	sess.signingReq = c.s.opt.RequireSigning || c.securityMode&negotiateSigningRequired != 0 || b[3]&0x02 != 0
+	switch {
+	case auth.anonymous:
+		sess.flags = sessionFlagIsNull
+	case c.s.guestAllowed():
+		sess.flags = sessionFlagIsGuest
+	default:
+		sess.signer = newSigner(c.dialect, auth.sessionKey, sess.preauthHash[:])
+	}
+	if sess.signingReq && sess.signer == nil {
+		fs.Infof(remote, "SMB login failed: client requires signing which guests can't do")
+		return fail(statusLogonFailure)
+	}
+	sess.user = auth.user
+	sess.vfs = VFS
+	sess.valid = true
+	fs.Infof(remote, "SMB user %q logged in", auth.user)
+
+	var out []byte
+	if sess.spnego {
+		var mic []byte
+		if auth.sessionKey != nil && len(sess.mechList) > 0 {
+			mic = auth.mechListMIC(sess.mechList)
+		}
+		out = encodeSpnegoResp(negStateAcceptCompleted, nil, mic)
+	}
+	return statusSuccess, c.sessionSetupResponse(sess.flags, out)
+}
+
+// sessionSetupResponse makes the body of a session setup response
+func (c *conn) sessionSetupResponse(flags uint16, token []byte) []byte {
+	body := make([]byte, 8, 8+len(token))
+	le.PutUint16(body[0:], 9)
+	le.PutUint16(body[2:], flags)
+	if len(token) > 0 {
+		le.PutUint16(body[4:], headerSize+8)
+		le.PutUint16(body[6:], uint16(len(token)))
+	}
+	return append(body, token...)
+}
+
+// handleLogoff ends the session
+func (c *conn) handleLogoff(req *request) (status uint32, body []byte) {
+	req.sess.closeOpens()
+	delete(c.sessions, req.sess.id)
+	return statusSuccess, []byte{4, 0, 0, 0}
+}
+
+// Share types
+const (
+	shareTypeDisk = 0x01
+	shareTypePipe = 0x02
+)
+
+// Access masks
+const (
+	accessFull     = 0x001F01FF
+	accessReadOnly = 0x001200A9
+)
+
+// handleTreeConnect connects to the share
+func (c *conn) handleTreeConnect(req *request) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 8 {
+		return statusInvalidParameter, nil
+	}
+	path, err := buffer(req.msg, int(le.Uint16(b[4:])), int(le.Uint16(b[6:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	name := decodeUTF16(path)
+	share := name[strings.LastIndex(name, `\`)+1:]
+	sess := req.sess
+	t := &tree{vfs: sess.vfs}
+	switch {
+	case strings.EqualFold(share, "IPC$"):
+		t.ipc = true
+	case strings.EqualFold(share, c.s.opt.ShareName):
+	default:
+		fs.Debugf(c.nc.RemoteAddr().String(), "SMB unknown share %q", name)
+		return statusBadNetworkName, nil
+	}
+	sess.nextTreeID++
+	t.id = sess.nextTreeID
+	sess.trees[t.id] = t
+	req.tree = t
+
+	body = make([]byte, 16)
+	le.PutUint16(body[0:], 16)
+	body[2] = shareTypeDisk
+	if t.ipc {
+		body[2] = shareTypePipe
+	}
+	access := uint32(accessFull)
+	if t.vfs.Opt.ReadOnly {
+		access = accessReadOnly
+	}
+	le.PutUint32(body[12:], access)
+	return statusSuccess, body
+}
+
+// handleTreeDisconnect disconnects from the share
+func (c *conn) handleTreeDisconnect(req *request) (status uint32, body []byte) {
+	for id, o := range req.sess.opens {
+		if o.tree == req.tree {
+			o.close()
+			delete(req.sess.opens, id)
+		}
+	}
+	delete(req.sess.trees, req.tree.id)
+	return statusSuccess, []byte{4, 0, 0, 0}
+}
+
+// IOCTL control codes
+const (
+	fsctlDfsGetReferrals       = 0x00060194
+	fsctlDfsGetReferralsEx     = 0x000601B0
+	fsctlValidateNegotiateInfo = 0x00140204
+)
+
+// handleIoctl answers the few IOCTLs clients need
+func (c *conn) handleIoctl(req *request, o *open) (status uint32, body []byte) {
+	b := req.body
+	if len(b) < 56 {
+		return statusInvalidParameter, nil
+	}
+	ctlCode := le.Uint32(b[4:])
+	input, err := buffer(req.msg, int(le.Uint32(b[24:])), int(le.Uint32(b[28:])))
+	if err != nil {
+		return statusInvalidParameter, nil
+	}
+	var output []byte
+	switch ctlCode {
+	case fsctlValidateNegotiateInfo:
+		if len(input) < 24 {
+			return statusInvalidParameter, nil
+		}
+		count := int(le.Uint16(input[22:]))
+		if len(input) < 24+2*count {
+			return statusInvalidParameter, nil
+		}
+		var dialects []uint16
+		for i := range count {
+			dialects = append(dialects, le.Uint16(input[24+2*i:]))
+		}
+		if le.Uint32(input[0:]) != c.capabilities || !bytes.Equal(input[4:20], c.clientGUID[:]) ||
+			le.Uint16(input[20:]) != c.securityMode || !slices.Contains(dialects, c.dialect) {
+			// Something has tampered with the negotiate so
+			// drop the connection
+			_ = c.nc.Close()
+			return statusAccessDenied, nil
+		}
+		output = make([]byte, 24)
+		le.PutUint32(output[0:], serverCapabilities(c.dialect))
+		copy(output[4:], c.s.guid[:])
+		le.PutUint16(output[20:], c.serverSecurityMode())
+		le.PutUint16(output[22:], c.dialect)
+	case fsctlDfsGetReferrals, fsctlDfsGetReferralsEx:
+		return statusFSDriverRequired, nil
+	default:
+		return statusNotSupported, nil
+	}
+	body = make([]byte, 48, 48+len(output))
+	le.PutUint16(body[0:], 49)
+	le.PutUint32(body[4:], ctlCode)
+	copy(body[8:24], b[8:24])
+	le.PutUint32(body[24:], headerSize+48)
+	le.PutUint32(body[32:], headerSize+48)
+	le.PutUint32(body[36:], uint32(len(output)))
+	return statusSuccess, append(body, output...)
+}
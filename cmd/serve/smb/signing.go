@@ -0,0 +1,117 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// newSigner returns the hash used to sign messages of a session with
+// sessionKey for the dialect.
+//
+// preauthHash is the preauth integrity hash of the session for SMB
+// 3.1.1.
+func newSigner(dialect uint16, sessionKey, preauthHash []byte) hash.Hash {
+	var key []byte
+	if len(sessionKey) > 16 {
+		sessionKey = sessionKey[:16]
+	}
+	switch dialect {
+	case dialect202, dialect210:
+		return hmac.New(sha256.New, sessionKey)
+	case dialect311:
+		key = kdf(sessionKey, []byte("SMBSigningKey\x00"), preauthHash)
+	default:
+		key = kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // can't happen as the key is 16 bytes
+	}
+	return newCMAC(block)
+}
+
+// kdf is the SP800-108 counter mode key derivation function with
+// HMAC-SHA256 returning a 128 bit key
+func kdf(key, label, context []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	_ = binary.Write(h, binary.BigEndian, uint32(1))
+	_, _ = h.Write(label)
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(context)
+	_ = binary.Write(h, binary.BigEndian, uint32(128))
+	return h.Sum(nil)[:16]
+}
+
+// cmac implements AES-CMAC from RFC 4493 as a hash.Hash
+type cmac struct {
+	block  cipher.Block
+	k1, k2 [aes.BlockSize]byte
+	buf    []byte
+}
+
+func newCMAC(block cipher.Block) *cmac {
+	c := &cmac{block: block}
+	var l [aes.BlockSize]byte
+	block.Encrypt(l[:], l[:])
+	c.k1 = shiftXor(l)
+	c.k2 = shiftXor(c.k1)
+	return c
+}
+
+// shiftXor doubles b in GF(2^128)
+func shiftXor(b [aes.BlockSize]byte) (out [aes.BlockSize]byte) {
+	var carry byte
+	for i := aes.BlockSize - 1; i >= 0; i-- {
+		out[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if b[0]&0x80 != 0 {
+		out[aes.BlockSize-1] ^= 0x87
+	}
+	return out
+}
+
+func (c *cmac) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	return len(p), nil
+}
+
+func (c *cmac) Sum(in []byte) []byte {
+	var x [aes.BlockSize]byte
+	n := (len(c.buf) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n-1; i++ {
+		for j := range x {
+			x[j] ^= c.buf[i*aes.BlockSize+j]
+		}
+		c.block.Encrypt(x[:], x[:])
+	}
+	last := c.buf[(n-1)*aes.BlockSize:]
+	var m [aes.BlockSize]byte
+	if len(last) == aes.BlockSize {
+		for j := range m {
+			m[j] = last[j] ^ c.k1[j]
+		}
+	} else {
+		copy(m[:], last)
+		m[len(last)] = 0x80
+		for j := range m {
+			m[j] ^= c.k2[j]
+		}
+	}
+	for j := range x {
+		x[j] ^= m[j]
+	}
+	c.block.Encrypt(x[:], x[:])
+	return append(in, x[:]...)
+}
+
+func (c *cmac) Reset()         { c.buf = c.buf[:0] }
+func (c *cmac) Size() int      { return aes.BlockSize }
+func (c *cmac) BlockSize() int { return aes.BlockSize }
@@ -0,0 +1,188 @@
+// Package smb implements a server to serve a VFS remote over the SMB2
+// and SMB3 protocols
+package smb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// OptionsInfo descripts the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "addr",
+	Default: "localhost:445",
+	Help:    "IPaddress:Port or :Port to bind server to",
+}, {
+	Name:    "user",
+	Default: "",
+	Help:    "User name for authentication (empty value lets everyone in as a guest)",
+}, {
+	Name:    "pass",
+	Default: "",
+	Help:    "Password for authentication",
+}, {
+	Name:    "share_name",
+	Default: "rclone",
+	Help:    "Name of the share to serve the remote as",
+}, {
+	Name:    "server_name",
+	Default: "",
+	Help:    "NetBIOS name of the server (default the host name)",
+}, {
+	Name:    "domain",
+	Default: "WORKGROUP",
+	Help:    "NetBIOS domain name of the server",
+}, {
+	Name:    "require_signing",
+	Default: false,
+	Help:    "Require clients to sign every message",
+}}
+
+// Options contains options for the SMB server
+type Options struct {
+	ListenAddr     string `config:"addr"`            // Port to listen on
+	User           string `config:"user"`            // single username for authentication
+	Pass           string `config:"pass"`            // password for User
+	ShareName      string `config:"share_name"`      // name of the share
+	ServerName     string `config:"server_name"`     // NetBIOS name of the server
+	Domain         string `config:"domain"`          // NetBIOS domain of the server
+	RequireSigning bool   `config:"require_signing"` // require signed messages
+}
+
+// Opt is options set by command line flags
+var Opt Options
+
+// AddFlags adds flags for serve smb
+func AddFlags(flagSet *pflag.FlagSet) {
+	flags.AddFlagsFromOptions(flagSet, "", OptionsInfo)
+}
+
+func init() {
+	fs.RegisterGlobalOptions(fs.OptionsInfo{Name: "smb", Opt: &Opt, Options: OptionsInfo})
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	proxyflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags())
+	serve.Command.AddCommand(Command)
+	serve.AddRc("smb", func(ctx context.Context, f fs.Fs, in rc.Params) (serve.Handle, error) {
+		// Read VFS Opts
+		var vfsOpt = vfscommon.Opt // set default opts
+		err := configstruct.SetAny(in, &vfsOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read Proxy Opts
+		var proxyOpt = proxy.Opt // set default opts
+		err = configstruct.SetAny(in, &proxyOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read opts
+		var opt = Opt // set default opts
+		err = configstruct.SetAny(in, &opt)
+		if err != nil {
+			return nil, err
+		}
+		// Create server
+		return NewServer(ctx, f, &opt, &vfsOpt, &proxyOpt)
+	})
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "smb remote:path",
+	Short: `Serve remote:path over SMB.`,
+	Long: strings.ReplaceAll(`Run an SMB server to serve a remote over the SMB2 and SMB3
+protocols used by Windows file sharing.
+
+This lets Windows, macOS and Linux clients mount the remote with their
+built in SMB clients, or it can be used with |smbclient| or an rclone
+remote of type smb.
+
+The remote is served as a single share called |rclone| which can be
+changed with |--share-name|, so on Windows you would map the drive
+|\\server\rclone| and from Linux you might use
+
+    smbclient //localhost/rclone -U user%password
+
+The SMB dialects 2.0.2, 2.1, 3.0, 3.0.2 and 3.1.1 are supported with
+message signing. SMB1, encryption, oplocks, leases, change
+notifications, named pipes (so share browsing) and alternate data
+streams are not supported.
+
+### Server options
+
+Use |--addr| to specify which IP address and port the server should
+listen on, e.g. |--addr 1.2.3.4:445| or |--addr :445| to listen to all
+IPs. By default it only listens on localhost.
+
+Windows clients can only connect to port 445, which needs root or
+|CAP_NET_BIND_SERVICE| on Linux. Other clients can use another port,
+e.g. |smbclient -p 4450| or |mount -t cifs -o port=4450|.
+
+The NetBIOS name of the server defaults to the host name and can be
+set with |--server-name|, and the domain with |--domain|.
+
+### Authentication
+
+Clients log in with NTLMv2 which never sends the password to the
+server.
+
+You can set a single username and password with the |--user| and
+|--pass| flags. If |--user| isn't set then anyone can log in and they
+are treated as guests. Guests can't sign messages and recent versions
+of Windows refuse to connect to servers which allow guest logins, so
+setting a user is recommended.
+
+Use |--require-signing| to make clients sign every message, which
+stops them from being tampered with.
+
+If you use |--auth-proxy| then the proxy will be called with just the
+|user| and it must return the password the user should log in with in
+the |_password| parameter, as NTLM needs the server to know the
+password to check the client's response. See the Auth Proxy section
+below.
+
+### VFS cache
+
+SMB clients often write files out of order, or set the size of a file
+before writing it, which needs |--vfs-cache-mode writes| or |full|.
+
+`, "|", "`") + strings.TrimSpace(vfs.Help()+proxy.Help),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		var f fs.Fs
+		if proxy.Opt.AuthProxy == "" {
+			cmd.CheckArgs(1, 1, command, args)
+			f = cmd.NewFsSrc(args)
+		} else {
+			cmd.CheckArgs(0, 0, command, args)
+		}
+		cmd.Run(false, false, command, func() error {
+			s, err := NewServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxy.Opt)
+			if err != nil {
+				return err
+			}
+			return s.Serve()
+		})
+	},
+}
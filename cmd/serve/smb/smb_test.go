@@ -0,0 +1,358 @@
+// Serve smb tests set up a server and connect to it with an SMB
+// client.
+
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	smb2 "github.com/cloudsoda/go-smb2"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testUser  = "rclone"
+	testPass  = "password"
+	testShare = "rclone"
+)
+
+// start an SMB server serving dir returning its address
+func start(t *testing.T, dir string, opt Options, proxyOpt proxy.Options) string {
+	var f fs.Fs
+	if dir != "" {
+		var err error
+		f, err = fs.NewFs(context.Background(), dir)
+		require.NoError(t, err)
+	}
+	opt.ListenAddr = "127.0.0.1:0"
+	opt.ShareName = testShare
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	s, err := NewServer(context.Background(), f, &opt, &vfsOpt, &proxyOpt)
+	require.NoError(t, err)
+	quit := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Serve())
+		close(quit)
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+		<-quit
+	})
+	return s.Addr().String()
+}
+
+// mount the share on the server at addr
+func mount(t *testing.T, addr string, dialect uint16, signing bool, user, pass string) (*smb2.Share, error) {
+	d := &smb2.Dialer{
+		Negotiator: smb2.Negotiator{
+			RequireMessageSigning: signing,
+			SpecifiedDialect:      dialect,
+		},
+		Initiator: &smb2.NTLMInitiator{
+			User:     user,
+			Password: pass,
+			Domain:   "WORKGROUP",
+		},
+	}
+	session, err := d.Dial(context.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = session.Logoff() })
+	return session.Mount(testShare)
+}
+
+// exercise the share
+func exercise(t *testing.T, share *smb2.Share) {
+	// Write and read a file
+	data := []byte("hello, world\n")
+	require.NoError(t, share.WriteFile("file.txt", data, 0666))
+	got, err := share.ReadFile("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Bigger than one read
+	big := bytes.Repeat([]byte("0123456789abcdef"), 3*maxIOSize/16+123)
+	require.NoError(t, share.WriteFile("big.bin", big, 0666))
+	got, err = share.ReadFile("big.bin")
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(big, got), "big file differs")
+
+	// Directories
+	require.NoError(t, share.Mkdir("dir", 0777))
+	require.NoError(t, share.WriteFile(`dir\sub.txt`, data, 0666))
+	entries, err := share.ReadDir("")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	slices.Sort(names)
+	assert.Equal(t, []string{"big.bin", "dir", "file.txt"}, names)
+	fi, err := share.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	fi, err = share.Stat("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), fi.Size())
+	_, err = share.Stat("notfound")
+	assert.True(t, os.IsNotExist(err), err)
+
+	// Modification times
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, share.Chtimes("file.txt", mtime, mtime))
+	fi, err = share.Stat("file.txt")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(fi.ModTime()), fi.ModTime())
+
+	// Rename and delete
+	require.NoError(t, share.Rename("file.txt", `dir\moved.txt`))
+	_, err = share.Stat("file.txt")
+	assert.True(t, os.IsNotExist(err), err)
+	got, err = share.ReadFile(`dir\moved.txt`)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Error(t, share.Remove("dir"), "directory not empty")
+	require.NoError(t, share.RemoveAll("dir"))
+	require.NoError(t, share.Remove("big.bin"))
+	entries, err = share.ReadDir("")
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	// Free space
+	_, err = share.Statfs("")
+	require.NoError(t, err)
+}
+
+// TestSMB runs the server with each dialect the client can use
+func TestSMB(t *testing.T) {
+	fstest.Initialise()
+	dir := t.TempDir()
+	addr := start(t, dir, Options{User: testUser, Pass: testPass, Domain: "WORKGROUP"}, proxy.Options{})
+	for _, dialect := range supportedDialects {
+		for _, signing := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%x/signing=%v", dialect, signing), func(t *testing.T) {
+				share, err := mount(t, addr, dialect, signing, testUser, testPass)
+				require.NoError(t, err)
+				exercise(t, share)
+			})
+		}
+	}
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		_, err := mount(t, addr, 0, false, testUser, "wrong")
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongShare", func(t *testing.T) {
+		share, err := mount(t, addr, 0, false, testUser, testPass)
+		require.NoError(t, err)
+		_ = share
+		d := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: testUser, Password: testPass}}
+		session, err := d.Dial(context.Background(), addr)
+		require.NoError(t, err)
+		defer func() { _ = session.Logoff() }()
+		_, err = session.Mount("potato")
+		assert.Error(t, err)
+	})
+}
+
+// TestGuest checks everyone is let in as a guest when no user is set
+func TestGuest(t *testing.T) {
+	dir := t.TempDir()
+	addr := start(t, dir, Options{}, proxy.Options{})
+	share, err := mount(t, addr, 0, false, "anyone", "anything")
+	require.NoError(t, err)
+	exercise(t, share)
+
+	// Guests can't sign
+	_, err = mount(t, addr, 0, true, "anyone", "anything")
+	assert.Error(t, err)
+}
+
+// TestAuthProxy checks the auth proxy supplies the password
+func TestAuthProxy(t *testing.T) {
+	dir := t.TempDir()
+	prog, err := filepath.Abs("../servetest/proxy_code.go")
+	require.NoError(t, err)
+	addr := start(t, "", Options{}, proxy.Options{AuthProxy: "go run " + prog + " " + dir})
+
+	share, err := mount(t, addr, 0, true, testUser, testPass)
+	require.NoError(t, err)
+	exercise(t, share)
+
+	_, err = mount(t, addr, 0, false, testUser, "wrong")
+	assert.Error(t, err)
+}
+
+// TestSmbclient checks the server works with Samba's smbclient if
+// it is installed
+func TestSmbclient(t *testing.T) {
+	smbclient, err := exec.LookPath("smbclient")
+	if err != nil {
+		t.Skip("smbclient not installed")
+	}
+	dir := t.TempDir()
+	addr := start(t, dir, Options{User: testUser, Pass: testPass, Domain: "WORKGROUP"}, proxy.Options{})
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	local := t.TempDir()
+	data := []byte("hello from smbclient\n")
+	require.NoError(t, os.WriteFile(filepath.Join(local, "upload.txt"), data, 0666))
+
+	for _, protocol := range []string{"SMB2", "SMB3"} {
+		t.Run(protocol, func(t *testing.T) {
+			cmd := exec.Command(smbclient, "//"+host+"/"+testShare,
+				"-p", port, "-U", testUser+"%"+testPass, "-m", protocol,
+				"-c", "mkdir sub; cd sub; put upload.txt; ls; get upload.txt download.txt; rename upload.txt renamed.txt; del renamed.txt; cd ..; rmdir sub",
+			)
+			cmd.Dir = local
+			out, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(out))
+			assert.Contains(t, string(out), "upload.txt")
+			got, err := os.ReadFile(filepath.Join(local, "download.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+			_, err = os.Stat(filepath.Join(dir, "sub"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		cmd := exec.Command(smbclient, "//"+host+"/"+testShare, "-p", port, "-U", testUser+"%wrong", "-c", "ls")
+		out, err := cmd.CombinedOutput()
+		assert.Error(t, err)
+		assert.Contains(t, string(out), "NT_STATUS_LOGON_FAILURE")
+	})
+}
+
+func TestRc(t *testing.T) {
+	servetest.TestRc(t, rc.Params{
+		"type":           "smb",
+		"vfs_cache_mode": "off",
+	})
+}
+
+// TestCMAC checks against the test vectors in RFC 4493
+func TestCMAC(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		h := newCMAC(block)
+		_, _ = h.Write(msg[:test.n])
+		assert.Equal(t, test.want, hex.EncodeToString(h.Sum(nil)), test.n)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "anything", true},
+		{"*.*", "noext", true},
+		{"file.txt", "FILE.TXT", true},
+		{"file.txt", "file.txt2", false},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "a.bin", false},
+		{"f?le", "file", true},
+		{"f?le", "fle", false},
+		{"<.txt", "x.txt", true},
+		{"a>", "a", true},
+		{`a"`, "a", true},
+	} {
+		assert.Equal(t, test.want, matchPattern(test.pattern, test.name), "%q %q", test.pattern, test.name)
+	}
+}
+
+func TestVFSPath(t *testing.T) {
+	assert.Equal(t, "", vfsPath(`\`))
+	assert.Equal(t, "dir/file.txt", vfsPath(`\dir\file.txt`))
+	assert.Equal(t, "dir", vfsPath(`dir\`))
+	assert.False(t, strings.Contains(vfsPath(`a\b\c`), `\`))
+}
+
+// lockRequest makes the body of a LOCK request
+func lockRequest(offset, length uint64, flags uint32) *request {
+	body := make([]byte, 48)
+	le.PutUint16(body[0:], 48)
+	le.PutUint16(body[2:], 1)
+	le.PutUint64(body[24:], offset)
+	le.PutUint64(body[32:], length)
+	le.PutUint32(body[40:], flags)
+	return &request{body: body}
+}
+
+// TestLock checks byte range locks conflict between opens
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello, world"), 0666))
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	tr := &tree{vfs: vfs.New(f, &vfsOpt)}
+	t.Cleanup(tr.vfs.Shutdown)
+	c := &conn{}
+	o1 := &open{id: fileID{1, 1}, tree: tr, path: "file.txt"}
+	o2 := &open{id: fileID{2, 2}, tree: tr, path: "file.txt"}
+
+	status, _ := c.handleLock(lockRequest(0, 5, lockFlagExclusive|lockFlagFailImmediately), o1)
+	assert.Equal(t, statusSuccess, status)
+	status, _ = c.handleLock(lockRequest(4, 2, lockFlagShared|lockFlagFailImmediately), o2)
+	assert.Equal(t, statusLockNotGranted, status)
+	status, _ = c.handleLock(lockRequest(5, 2, lockFlagShared|lockFlagFailImmediately), o2)
+	assert.Equal(t, statusSuccess, status)
+
+	// Locks are mandatory for reads and writes
+	read := make([]byte, 48)
+	le.PutUint32(read[4:], 3)
+	status, _ = c.handleRead(&request{body: read}, o2)
+	assert.Equal(t, statusFileLockConflict, status)
+	status, _ = c.handleRead(&request{body: read}, o1)
+	assert.Equal(t, statusSuccess, status)
+
+	// Unlocking
+	status, _ = c.handleLock(lockRequest(20, 2, lockFlagUnlock), o1)
+	assert.Equal(t, statusRangeNotLocked, status)
+	status, _ = c.handleLock(lockRequest(0, 5, lockFlagUnlock), o1)
+	assert.Equal(t, statusSuccess, status)
+	status, _ = c.handleLock(lockRequest(4, 2, lockFlagShared|lockFlagFailImmediately), o2)
+	assert.Equal(t, statusSuccess, status)
+
+	// Closing releases the locks of the open
+	o2.close()
+	assert.Len(t, tr.vfs.Locks("file.txt"), 0)
+}
@@ -0,0 +1,134 @@
+package webdav
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/vfs"
+	"golang.org/x/net/webdav"
+)
+
+// lockOwnerPrefix is the prefix for the VFS lock owners of WebDAV locks
+const lockOwnerPrefix = "webdav:"
+
+// vfsLockSystem is a webdav.LockSystem which mirrors the WebDAV locks
+// into the VFS so they conflict with locks taken by other users of
+// the VFS, and refuses changes to files locked by them.
+type vfsLockSystem struct {
+	webdav.LockSystem
+	vfs   *vfs.VFS
+	mu    sync.Mutex
+	locks map[string]time.Time // WebDAV lock token to expiry time or zero for never
+}
+
+// newVFSLockSystem makes a webdav.LockSystem which takes locks in VFS
+func newVFSLockSystem(VFS *vfs.VFS) *vfsLockSystem {
+	return &vfsLockSystem{
+		LockSystem: webdav.NewMemLS(),
+		vfs:        VFS,
+		locks:      make(map[string]time.Time),
+	}
+}
+
+// expiry returns when a lock of duration taken at now expires
+func expiry(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+// wholeFile returns a lock for owner on the whole of a file
+func wholeFile(owner string) vfs.Lock {
+	return vfs.Lock{Owner: owner, Type: vfs.LockWrite, Start: 0, End: vfs.LockEOF}
+}
+
+// expire releases the VFS locks of WebDAV locks which have expired
+//
+// Call with mu held
+func (ls *vfsLockSystem) expire(now time.Time) {
+	for token, expires := range ls.locks {
+		if !expires.IsZero() && !now.Before(expires) {
+			ls.vfs.ReleaseLocks(lockOwnerPrefix + token)
+			delete(ls.locks, token)
+		}
+	}
+}
+
+// lockedElsewhere returns true if name is locked in the VFS by
+// something other than WebDAV
+func (ls *vfsLockSystem) lockedElsewhere(name string) bool {
+	for _, l := range ls.vfs.Locks(name) {
+		if !strings.HasPrefix(l.Owner, lockOwnerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions, and that holding the union of
+// all of those locks gives exclusive access to all of the named
+// resources.
+func (ls *vfsLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (release func(), err error) {
+	ls.mu.Lock()
+	ls.expire(now)
+	ls.mu.Unlock()
+	for _, name := range []string{name0, name1} {
+		if name != "" && ls.lockedElsewhere(name) {
+			return nil, webdav.ErrLocked
+		}
+	}
+	return ls.LockSystem.Confirm(now, name0, name1, conditions...)
+}
+
+// Create creates a lock with the given depth, duration, owner and
+// root (name).
+func (ls *vfsLockSystem) Create(now time.Time, details webdav.LockDetails) (token string, err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	if ls.lockedElsewhere(details.Root) {
+		return "", webdav.ErrLocked
+	}
+	token, err = ls.LockSystem.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	err = ls.vfs.Lock(details.Root, wholeFile(lockOwnerPrefix+token))
+	if err != nil {
+		_ = ls.LockSystem.Unlock(now, token)
+		return "", webdav.ErrLocked
+	}
+	ls.locks[token] = expiry(now, details.Duration)
+	return token, nil
+}
+
+// Refresh refreshes the lock with the given token.
+func (ls *vfsLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err != nil {
+		return details, err
+	}
+	if _, found := ls.locks[token]; found {
+		ls.locks[token] = expiry(now, duration)
+	}
+	return details, nil
+}
+
+// Unlock unlocks the lock with the given token.
+func (ls *vfsLockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.expire(now)
+	// Release by owner as the file may have been renamed
+	if _, found := ls.locks[token]; found {
+		ls.vfs.ReleaseLocks(lockOwnerPrefix + token)
+		delete(ls.locks, token)
+	}
+	return ls.LockSystem.Unlock(now, token)
+}
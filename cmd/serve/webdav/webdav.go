@@ -21,6 +21,7 @@ import (
 	cmdserve "github.com/rclone/rclone/cmd/serve"
 	"github.com/rclone/rclone/cmd/serve/proxy"
 	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/cmd/serve/share"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/config/flags"
@@ -48,13 +49,15 @@ var OptionsInfo = fs.Options{{
 }}.
 	Add(libhttp.ConfigInfo).
 	Add(libhttp.AuthConfigInfo).
-	Add(libhttp.TemplateConfigInfo)
+	Add(libhttp.TemplateConfigInfo).
+	Add(share.OptionsInfo)
 
 // Options required for http server
 type Options struct {
 	Auth           libhttp.AuthConfig
 	HTTP           libhttp.Config
 	Template       libhttp.TemplateConfig
+	Share          share.Options
 	EtagHash       string `config:"etag_hash"`
 	DisableDirList bool   `config:"disable_dir_list"`
 }
@@ -170,7 +173,7 @@ rclone --webdav-unix-socket /tmp/my.socket --webdav-url http://localhost lsf :we
 Note that there is no authentication on http protocol - this is expected to be
 done by the permissions on the socket.
 
-` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+vfs.Help()+proxy.Help),
+` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+share.Help+vfs.Help()+proxy.Help),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
 		"groups":            "Filter",
@@ -248,11 +251,15 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		w._vfs = vfs.New(f, vfsOpt)
 	}
 
-	w.server, err = libhttp.NewServer(ctx,
+	serverOpts := []libhttp.Option{
 		libhttp.WithConfig(w.opt.HTTP),
 		libhttp.WithAuth(w.opt.Auth),
 		libhttp.WithTemplate(w.opt.Template),
-	)
+	}
+	if w.opt.Share.Enabled {
+		serverOpts = append(serverOpts, libhttp.WithPublicPrefix(share.Prefix))
+	}
+	w.server, err = libhttp.NewServer(ctx, serverOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init server: %w", err)
 	}
@@ -260,10 +267,16 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	// Make sure BaseURL starts with a / and doesn't end with one
 	w.opt.HTTP.BaseURL = "/" + strings.Trim(w.opt.HTTP.BaseURL, "/")
 
+	// With an auth proxy each user has their own VFS so WebDAV
+	// locks can only be kept in memory
+	var lockSystem webdav.LockSystem = webdav.NewMemLS()
+	if w._vfs != nil {
+		lockSystem = newVFSLockSystem(w._vfs)
+	}
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.opt.HTTP.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: lockSystem,
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
@@ -275,6 +288,13 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	)
 
 	router.Handle("/*", w)
+	if w.opt.Share.Enabled {
+		shareHandler, err := share.NewHandler(&w.opt.Share, w._vfs, w.server.HTMLTemplate())
+		if err != nil {
+			return nil, err
+		}
+		router.Handle(share.Prefix+"*", shareHandler)
+	}
 
 	// Webdav only methods not defined in chi
 	methods := []string{
@@ -25,6 +25,7 @@ import (
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/filter"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -271,3 +272,44 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+// TestVFSLockSystem checks WebDAV locks are held in the VFS
+func TestVFSLockSystem(t *testing.T) {
+	f, err := fs.NewFs(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	VFS := vfs.New(f, &vfscommon.Opt)
+	t.Cleanup(VFS.Shutdown)
+	ls := newVFSLockSystem(VFS)
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+	require.NoError(t, err)
+	err = VFS.Lock("file.txt", vfs.Lock{Owner: "other", Type: vfs.LockRead, Start: 0, End: 1})
+	assert.Equal(t, vfs.EAGAIN, err)
+
+	release, err := ls.Confirm(now, "/file.txt", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	release()
+
+	// Files locked outside WebDAV can't be changed
+	require.NoError(t, VFS.Lock("other.txt", vfs.Lock{Owner: "other", Type: vfs.LockRead, Start: 0, End: 1}))
+	_, err = ls.Confirm(now, "/file.txt", "/other.txt", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrLocked, err)
+	VFS.ReleaseLocks("other")
+
+	// The lock is released when it expires
+	_, err = ls.Confirm(now.Add(2*time.Minute), "/file.txt", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	assert.Len(t, VFS.Locks("file.txt"), 0)
+
+	// Files locked outside WebDAV can't be locked
+	require.NoError(t, VFS.Lock("file.txt", vfs.Lock{Owner: "other", Type: vfs.LockRead, Start: 0, End: 1}))
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: time.Minute})
+	assert.Equal(t, webdav.ErrLocked, err)
+	VFS.ReleaseLocks("other")
+
+	token, err = ls.Create(now, webdav.LockDetails{Root: "/file.txt", Duration: -1})
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(now, token))
+	assert.Len(t, VFS.Locks("file.txt"), 0)
+}
@@ -0,0 +1,141 @@
+// Package share provides the share command.
+package share
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	serveshare "github.com/rclone/rclone/cmd/serve/share"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storePath    = ""
+	expire       = fs.Duration(serveshare.DefaultExpire)
+	password     = ""
+	maxDownloads = 0
+	serverURL    = ""
+)
+
+func init() {
+	cmd.Root.AddCommand(commandDefinition)
+	commandDefinition.AddCommand(createCommand)
+	commandDefinition.AddCommand(listCommand)
+	commandDefinition.AddCommand(revokeCommand)
+	persistentFlags := commandDefinition.PersistentFlags()
+	flags.StringVarP(persistentFlags, &storePath, "share-store", "", storePath, "Path to the share link store (default shares.json next to the config file)", "")
+	flags.StringVarP(persistentFlags, &serverURL, "url", "", serverURL, "URL of the server to make full links e.g. https://example.com:8080/", "")
+	cmdFlags := createCommand.Flags()
+	flags.FVarP(cmdFlags, &expire, "expire", "", "The amount of time that the link will be valid", "")
+	flags.StringVarP(cmdFlags, &password, "password", "", password, "Password needed to use the link", "")
+	flags.IntVarP(cmdFlags, &maxDownloads, "max-downloads", "", maxDownloads, "Maximum number of downloads of a file, 0 for unlimited", "")
+}
+
+var commandDefinition = &cobra.Command{
+	Use:   "share",
+	Short: `Manage share links served by rclone serve.`,
+	Long: `Make, list and revoke share links served by ` + "`rclone serve http`" + `
+and ` + "`rclone serve webdav`" + ` with the ` + "`--shares`" + ` flag.
+
+Unlike [rclone link](/commands/rclone_link/) these work on any remote,
+as rclone serves the files itself rather than using the sharing
+features of the provider.
+
+` + serveshare.Help,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+}
+
+// link returns the link for the share with tok
+func link(sh *serveshare.Share, tok string) string {
+	linkPath := serveshare.LinkPath(sh, tok)
+	if serverURL == "" {
+		return linkPath
+	}
+	return strings.TrimRight(serverURL, "/") + linkPath
+}
+
+var createCommand = &cobra.Command{
+	Use:   "create remote:path",
+	Short: `Make a share link for a file or directory.`,
+	Long: `Make a share link for the given file or directory.
+
+` + "```sh" + `
+rclone share create remote:path/to/file
+rclone share create --expire 7d --password secret remote:path/to/dir
+rclone share create --max-downloads 1 --url https://example.com:8080/ remote:file
+` + "```" + `
+
+The link is printed on the last line of the output. It is only served
+by servers serving a remote which contains remote:path. Without
+` + "`--url`" + ` only the path of the link is printed, add it to the
+server's URL to make the link.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		fsrc, remote := cmd.NewFsFile(args[0])
+		cmd.Run(false, false, command, func() error {
+			fullRemote, isDir, err := serveshare.Resolve(context.Background(), fsrc, remote)
+			if err != nil {
+				return err
+			}
+			sh, tok, err := serveshare.NewStore(storePath).Create(fullRemote, isDir, serveshare.CreateOptions{
+				Expire:       time.Duration(expire),
+				Password:     password,
+				MaxDownloads: maxDownloads,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(serveshare.Format(sh))
+			fmt.Println(link(sh, tok))
+			return nil
+		})
+	},
+}
+
+var listCommand = &cobra.Command{
+	Use:   "list",
+	Short: `List the share links which haven't expired.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(0, 0, command, args)
+		cmd.Run(false, false, command, func() error {
+			shares, tokens, err := serveshare.NewStore(storePath).List()
+			if err != nil {
+				return err
+			}
+			for i, sh := range shares {
+				fmt.Println(serveshare.Format(sh))
+				fmt.Println("    " + link(sh, tokens[i]))
+			}
+			return nil
+		})
+	},
+}
+
+var revokeCommand = &cobra.Command{
+	Use:   "revoke id",
+	Short: `Remove a share link.`,
+	Long: `Remove the share link with the given id, as shown by
+` + "`rclone share list`" + `, so it can't be used any more.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		cmd.Run(false, false, command, func() error {
+			return serveshare.NewStore(storePath).Revoke(args[0])
+		})
+	},
+}
@@ -0,0 +1,503 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"golang.org/x/sync/errgroup"
+)
+
+// Layout of the repository
+const (
+	configName    = "snapshot.json" // repository configuration
+	dataDir       = "data"          // content addressed file contents
+	snapshotsDir  = "snapshots"     // one manifest per snapshot
+	manifestExt   = ".json"         // extension of the manifests
+	idFormat      = "20060102T150405.000000000Z"
+	latestID      = "latest"
+	configVersion = 1
+)
+
+// repoConfig is stored in the root of the repository
+type repoConfig struct {
+	Version int    `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// Entry describes a single file in a snapshot
+type Entry struct {
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"modtime"`
+	Hash     string      `json:"hash"`
+	Metadata fs.Metadata `json:"metadata,omitempty"`
+}
+
+// Manifest describes a snapshot
+type Manifest struct {
+	ID      string    `json:"id"`
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Hash    string    `json:"hash"`
+	Files   int64     `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Repo is a snapshot repository stored on a remote
+type Repo struct {
+	f  fs.Fs
+	ht hash.Type
+}
+
+// openRepo opens the repository on f.
+//
+// If the repository doesn't exist and create is set then it will be
+// initialised using the hash type named by htName. If htName is empty
+// the first hash supported by hashes is used, falling back to MD5.
+func openRepo(ctx context.Context, f fs.Fs, create bool, htName string, hashes hash.Set) (*Repo, error) {
+	r := &Repo{f: f}
+	var config repoConfig
+	err := r.readJSON(ctx, configName, &config)
+	if err == nil {
+		if config.Version > configVersion {
+			return nil, fmt.Errorf("snapshot repository version %d is not supported - upgrade rclone", config.Version)
+		}
+		if err := r.ht.Set(config.Hash); err != nil {
+			return nil, fmt.Errorf("snapshot repository has bad hash type: %w", err)
+		}
+		if htName != "" && !strings.EqualFold(htName, config.Hash) {
+			return nil, fmt.Errorf("snapshot repository uses hash %v not %q", r.ht, htName)
+		}
+		return r, nil
+	}
+	if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, fmt.Errorf("failed to read snapshot repository config: %w", err)
+	}
+	if !create {
+		return nil, fmt.Errorf("no snapshot repository found at %s", fs.ConfigString(f))
+	}
+	if htName != "" {
+		if err := r.ht.Set(htName); err != nil {
+			return nil, err
+		}
+	} else if r.ht = hashes.GetOne(); r.ht == hash.None {
+		r.ht = hash.MD5
+	}
+	config = repoConfig{
+		Version: configVersion,
+		Hash:    r.ht.String(),
+	}
+	if err := r.writeJSON(ctx, configName, &config); err != nil {
+		return nil, fmt.Errorf("failed to initialise snapshot repository: %w", err)
+	}
+	fs.Infof(f, "Initialised snapshot repository using %v hashes", r.ht)
+	return r, nil
+}
+
+// readJSON reads the JSON in remote into out
+func (r *Repo) readJSON(ctx context.Context, remote string, out any) (err error) {
+	o, err := r.f.NewObject(ctx, remote)
+	if err != nil {
+		return err
+	}
+	in, err := operations.Open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	return json.NewDecoder(in).Decode(out)
+}
+
+// writeJSON writes in as JSON to remote
+func (r *Repo) writeJSON(ctx context.Context, remote string, in any) error {
+	buf, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = operations.RcatSize(ctx, r.f, remote, io.NopCloser(bytes.NewReader(buf)), int64(len(buf)), time.Now(), nil)
+	return err
+}
+
+// dataPath returns the path in the repository of the contents with sum
+func dataPath(sum string) string {
+	if len(sum) < 2 {
+		return path.Join(dataDir, sum)
+	}
+	return path.Join(dataDir, sum[:2], sum)
+}
+
+// manifestPath returns the path of the manifest with id
+func manifestPath(id string) string {
+	return path.Join(snapshotsDir, id+manifestExt)
+}
+
+// withoutFilters returns a context with the filters removed so the
+// repository internals are never filtered.
+func withoutFilters(ctx context.Context) context.Context {
+	// This can't fail as there are no rules to parse
+	fi, _ := filter.NewFilter(&filter.Options{
+		MinAge:  fs.DurationOff,
+		MaxAge:  fs.DurationOff,
+		MinSize: fs.SizeSuffix(-1),
+		MaxSize: fs.SizeSuffix(-1),
+	})
+	return filter.ReplaceConfig(ctx, fi)
+}
+
+// listObjects lists all the objects under dir in the repository
+// calling fn for each. A missing directory is not an error.
+func (r *Repo) listObjects(ctx context.Context, dir string, fn func(o fs.Object)) error {
+	err := walk.ListR(withoutFilters(ctx), r.f, dir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(fn)
+		return nil
+	})
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil
+	}
+	return err
+}
+
+// IDs returns the snapshot IDs in the repository, oldest first
+func (r *Repo) IDs(ctx context.Context) (ids []string, err error) {
+	err = r.listObjects(ctx, snapshotsDir, func(o fs.Object) {
+		name := path.Base(o.Remote())
+		if id, ok := strings.CutSuffix(name, manifestExt); ok {
+			ids = append(ids, id)
+		}
+	})
+	slices.Sort(ids)
+	return ids, err
+}
+
+// Load reads the manifest with id which may be "latest"
+func (r *Repo) Load(ctx context.Context, id string) (*Manifest, error) {
+	if id == latestID {
+		ids, err := r.IDs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, errors.New("no snapshots found")
+		}
+		id = ids[len(ids)-1]
+	}
+	m := new(Manifest)
+	err := r.readJSON(ctx, manifestPath(id), m)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, fmt.Errorf("snapshot %q not found", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %q: %w", id, err)
+	}
+	return m, nil
+}
+
+// hashObject returns the hash of o, downloading it if the backend
+// can't supply it.
+func (r *Repo) hashObject(ctx context.Context, o fs.Object) (string, error) {
+	sum, err := o.Hash(ctx, r.ht)
+	if err == nil && sum != "" {
+		return sum, nil
+	}
+	if err != nil && !errors.Is(err, hash.ErrUnsupported) {
+		return "", err
+	}
+	return operations.HashSum(ctx, r.ht, false, true, o)
+}
+
+// Create makes a new snapshot of fsrc storing any new contents in the
+// repository.
+//
+// Files which have the same size and modification time as in the
+// previous snapshot are not re-hashed.
+//
+// The source is listed with walk.ListR rather than compared with
+// fs/march. march pairs up entries with the same path in two trees,
+// but the contents are stored by hash under data/ so no path in the
+// source corresponds to one in the repository, and which contents are
+// already stored is only known once they are hashed. A flat listing
+// of the source, hashed with --transfers concurrency, and a single
+// listing of the stored contents are all that is needed.
+func (r *Repo) Create(ctx context.Context, fsrc fs.Fs) (*Manifest, error) {
+	ci := fs.GetConfig(ctx)
+
+	// Index the previous snapshot so unchanged files needn't be hashed
+	previous := map[string]Entry{}
+	if ids, err := r.IDs(ctx); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		last, err := r.Load(ctx, ids[len(ids)-1])
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range last.Entries {
+			previous[entry.Path] = entry
+		}
+	}
+
+	// Find out which contents are stored already
+	var mu sync.Mutex
+	stored := map[string]struct{}{}
+	inFlight := map[string]*sync.Mutex{} // held while the contents are stored
+	err := r.listObjects(ctx, dataDir, func(o fs.Object) {
+		stored[path.Base(o.Remote())] = struct{}{}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshot contents: %w", err)
+	}
+
+	var objs []fs.Object
+	err = walk.ListR(ctx, fsrc, "", false, operations.ConfigMaxDepth(ctx, true), walk.ListObjects, func(entries fs.DirEntries) error {
+		entries.ForObject(func(o fs.Object) {
+			objs = append(objs, o)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source: %w", err)
+	}
+
+	modifyWindow := fs.GetModifyWindow(ctx, fsrc)
+	entries := make([]Entry, len(objs))
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Transfers)
+	for i, o := range objs {
+		g.Go(func() error {
+			entry := Entry{
+				Path:    o.Remote(),
+				Size:    o.Size(),
+				ModTime: o.ModTime(gCtx),
+			}
+			if prev, ok := previous[entry.Path]; ok && prev.Size == entry.Size && prev.Hash != "" && entry.ModTime.Sub(prev.ModTime).Abs() <= modifyWindow {
+				entry.Hash = prev.Hash
+			} else {
+				sum, err := r.hashObject(gCtx, o)
+				if err != nil {
+					return fmt.Errorf("failed to hash %v: %w", o, err)
+				}
+				entry.Hash = sum
+			}
+			if ci.Metadata {
+				metadata, err := fs.GetMetadata(gCtx, o)
+				if err != nil {
+					return fmt.Errorf("failed to read metadata from %v: %w", o, err)
+				}
+				entry.Metadata = metadata
+			}
+			entries[i] = entry
+
+			// Only one copy of each content is stored at once and it
+			// only counts as stored once the copy has succeeded
+			mu.Lock()
+			storing, ok := inFlight[entry.Hash]
+			if !ok {
+				storing = new(sync.Mutex)
+				inFlight[entry.Hash] = storing
+			}
+			mu.Unlock()
+			storing.Lock()
+			defer storing.Unlock()
+			mu.Lock()
+			_, found := stored[entry.Hash]
+			mu.Unlock()
+			if found {
+				fs.Debugf(o, "Contents already stored")
+				return nil
+			}
+			_, err := operations.Copy(gCtx, r.f, nil, dataPath(entry.Hash), o)
+			if err != nil {
+				return fmt.Errorf("failed to store %v: %w", o, err)
+			}
+			mu.Lock()
+			stored[entry.Hash] = struct{}{}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	now := time.Now().UTC()
+	m := &Manifest{
+		ID:      now.Format(idFormat),
+		Time:    now,
+		Source:  fs.ConfigString(fsrc),
+		Hash:    r.ht.String(),
+		Entries: entries,
+	}
+	for _, entry := range entries {
+		m.Files++
+		m.Bytes += entry.Size
+	}
+	if err := r.writeJSON(ctx, manifestPath(m.ID), m); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+	return m, nil
+}
+
+// restoreObject presents stored contents as the file in the snapshot
+type restoreObject struct {
+	fs.Object
+	entry *Entry
+}
+
+// Remote returns the path of the file in the snapshot
+func (o *restoreObject) Remote() string {
+	return o.entry.Path
+}
+
+// String returns a description of the Object
+func (o *restoreObject) String() string {
+	return o.entry.Path
+}
+
+// ModTime returns the modification time of the file in the snapshot
+func (o *restoreObject) ModTime(ctx context.Context) time.Time {
+	return o.entry.ModTime
+}
+
+// Metadata returns the metadata of the file in the snapshot
+func (o *restoreObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	return o.entry.Metadata, nil
+}
+
+// Check interfaces
+var _ fs.Metadataer = (*restoreObject)(nil)
+
+// Restore copies the files in m to fdst. Files which match the
+// filters are restored and files which are identical already are
+// skipped.
+func (r *Repo) Restore(ctx context.Context, m *Manifest, fdst fs.Fs) error {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	repoCtx := withoutFilters(ctx)
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(ci.Transfers)
+	for i := range m.Entries {
+		entry := &m.Entries[i]
+		if !fi.Include(entry.Path, entry.Size, entry.ModTime, entry.Metadata) {
+			continue
+		}
+		g.Go(func() error {
+			stored, err := r.f.NewObject(repoCtx, dataPath(entry.Hash))
+			if err != nil {
+				return fmt.Errorf("failed to find contents of %q: %w", entry.Path, err)
+			}
+			src := &restoreObject{Object: stored, entry: entry}
+			dst, err := fdst.NewObject(gCtx, entry.Path)
+			if err == nil {
+				if !operations.NeedTransfer(gCtx, dst, src) {
+					return nil
+				}
+			} else if !errors.Is(err, fs.ErrorObjectNotFound) {
+				return err
+			} else {
+				dst = nil
+			}
+			_, err = operations.Copy(gCtx, fdst, dst, entry.Path, src)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// Change is a difference between two snapshots
+type Change struct {
+	Op   byte // '+' added, '-' removed, '*' changed
+	Path string
+}
+
+// Diff returns the changes needed to turn snapshot a into snapshot b
+func Diff(a, b *Manifest) (changes []Change) {
+	i, j := 0, 0
+	for i < len(a.Entries) || j < len(b.Entries) {
+		switch {
+		case j >= len(b.Entries) || (i < len(a.Entries) && a.Entries[i].Path < b.Entries[j].Path):
+			changes = append(changes, Change{Op: '-', Path: a.Entries[i].Path})
+			i++
+		case i >= len(a.Entries) || b.Entries[j].Path < a.Entries[i].Path:
+			changes = append(changes, Change{Op: '+', Path: b.Entries[j].Path})
+			j++
+		default:
+			if a.Entries[i].Hash != b.Entries[j].Hash || a.Entries[i].Size != b.Entries[j].Size {
+				changes = append(changes, Change{Op: '*', Path: a.Entries[i].Path})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+// Prune deletes all but the keepLast most recent snapshots and any
+// snapshots younger than keepWithin, then deletes any stored contents
+// which are no longer referenced.
+func (r *Repo) Prune(ctx context.Context, keepLast int, keepWithin time.Duration) error {
+	ctx = withoutFilters(ctx)
+	ids, err := r.IDs(ctx)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().UTC().Add(-keepWithin)
+	referenced := map[string]struct{}{}
+	for i, id := range ids {
+		keep := i >= len(ids)-keepLast
+		if keepWithin > 0 {
+			if t, err := time.Parse(idFormat, id); err == nil && t.After(cutoff) {
+				keep = true
+			}
+		}
+		if !keep {
+			o, err := r.f.NewObject(ctx, manifestPath(id))
+			if err != nil {
+				return err
+			}
+			if err := operations.DeleteFile(ctx, o); err != nil {
+				return err
+			}
+			continue
+		}
+		m, err := r.Load(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range m.Entries {
+			referenced[entry.Hash] = struct{}{}
+		}
+	}
+	var unreferenced []fs.Object
+	err = r.listObjects(ctx, dataDir, func(o fs.Object) {
+		if _, ok := referenced[path.Base(o.Remote())]; !ok {
+			unreferenced = append(unreferenced, o)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	toBeDeleted := make(fs.ObjectsChan, fs.GetConfig(ctx).Checkers)
+	go func() {
+		for _, o := range unreferenced {
+			toBeDeleted <- o
+		}
+		close(toBeDeleted)
+	}()
+	return operations.DeleteFiles(ctx, toBeDeleted)
+}
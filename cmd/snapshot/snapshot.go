@@ -0,0 +1,253 @@
+// Package snapshot provides the snapshot command.
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+// Globals
+var (
+	hashName   = ""
+	keepLast   = 0
+	keepWithin = fs.Duration(0)
+)
+
+func init() {
+	cmd.Root.AddCommand(Command)
+	Command.AddCommand(createCommand, listCommand, restoreCommand, diffCommand, pruneCommand)
+	flags.StringVarP(createCommand.Flags(), &hashName, "hash", "", hashName, "Hash used to address the contents when creating a repository", "")
+	pruneFlags := pruneCommand.Flags()
+	flags.IntVarP(pruneFlags, &keepLast, "keep-last", "", keepLast, "Keep this many of the most recent snapshots", "")
+	flags.FVarP(pruneFlags, &keepWithin, "keep-within", "", "Keep snapshots younger than this", "")
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "snapshot <subcommand>",
+	Short: `Make, restore and manage incremental snapshots of a remote.`,
+	Long: strings.ReplaceAll(`Rclone snapshot stores point in time copies of a path in a
+repository on any remote.
+
+The contents of each file are stored once only in the repository,
+named by their hash, so unchanged, renamed and duplicated files take
+no extra space or transfers. Each run of |rclone snapshot create|
+writes a manifest listing the path, size, modification time, hash and
+(with |--metadata|) the metadata of every file.
+
+The repository has this layout
+
+- |snapshot.json| - the repository config, including the hash in use
+- |data/ab/abcdef...| - the file contents named by their hash
+- |snapshots/ID.json| - one manifest per snapshot
+
+Snapshot IDs are the UTC time the snapshot was taken, for example
+|20261018T124401.000000000Z|. Wherever an ID is needed |latest| may be
+used to mean the most recent snapshot.
+
+Select which action you want with the subcommand, eg
+
+|||sh
+rclone snapshot create /home/user remote:backup
+rclone snapshot list remote:backup
+rclone snapshot diff remote:backup 20261017T000000.000000000Z latest
+rclone snapshot restore remote:backup latest /tmp/restore
+rclone snapshot prune remote:backup --keep-last 7
+|||
+
+Each subcommand has its own options which you can see in their help.`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+}
+
+var createCommand = &cobra.Command{
+	Use:   "create source:path repo:path",
+	Short: `Take a snapshot of source:path into repo:path.`,
+	Long: strings.ReplaceAll(`Takes a snapshot of source:path storing any contents not already
+in the repository at repo:path. The repository is created if it
+doesn't exist.
+
+The hash used to address the contents is chosen when the repository is
+created. By default this is the first hash the source supports, or MD5
+if it doesn't support any, and can be set with |--hash|. If the source
+can't supply that hash then files will be downloaded to hash them.
+Files whose size and modification time are unchanged since the
+previous snapshot aren't hashed again.
+
+The filters are applied to the source, so only files included by the
+filters are recorded in the snapshot.`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Copy,Filter,Listing",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc := cmd.NewFsSrc(args[:1])
+		frepo := cmd.NewFsDir(args[1:])
+		cmd.Run(true, true, command, func() error {
+			ctx := context.Background()
+			r, err := openRepo(ctx, frepo, true, hashName, fsrc.Hashes())
+			if err != nil {
+				return err
+			}
+			m, err := r.Create(ctx, fsrc)
+			if err != nil {
+				return err
+			}
+			fs.Logf(frepo, "Created snapshot %s with %d files (%v)", m.ID, m.Files, fs.SizeSuffix(m.Bytes))
+			return nil
+		})
+	},
+}
+
+var listCommand = &cobra.Command{
+	Use:   "list repo:path",
+	Short: `List the snapshots in repo:path.`,
+	Long: `Lists the snapshots in the repository, oldest first, showing the ID,
+the number of files, their total size and the source the snapshot was
+taken from.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Listing",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		frepo := cmd.NewFsDir(args)
+		cmd.Run(false, false, command, func() error {
+			ctx := context.Background()
+			r, err := openRepo(ctx, frepo, false, "", hash.Set(hash.None))
+			if err != nil {
+				return err
+			}
+			ids, err := r.IDs(ctx)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				m, err := r.Load(ctx, id)
+				if err != nil {
+					return err
+				}
+				operations.SyncFprintf(os.Stdout, "%s %9d %s %s\n", m.ID, m.Files, operations.SizeStringField(m.Bytes, true, 9), m.Source)
+			}
+			return nil
+		})
+	},
+}
+
+var restoreCommand = &cobra.Command{
+	Use:   "restore repo:path ID dest:path",
+	Short: `Restore a snapshot from repo:path to dest:path.`,
+	Long: strings.ReplaceAll(`Restores the snapshot with the ID given, which may be |latest|,
+to dest:path.
+
+Files in the destination which are identical to those in the snapshot
+are not transferred again and files not in the snapshot are left
+alone. Use the filters to restore only some of the files, eg
+
+|||sh
+rclone snapshot restore remote:backup latest /tmp/restore --include "*.doc"
+|||`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Copy,Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(3, 3, command, args)
+		frepo := cmd.NewFsDir(args[:1])
+		fdst := cmd.NewFsDir(args[2:])
+		cmd.Run(true, true, command, func() error {
+			ctx := context.Background()
+			r, err := openRepo(ctx, frepo, false, "", hash.Set(hash.None))
+			if err != nil {
+				return err
+			}
+			m, err := r.Load(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			return r.Restore(ctx, m, fdst)
+		})
+	},
+}
+
+var diffCommand = &cobra.Command{
+	Use:   "diff repo:path ID1 ID2",
+	Short: `Show the differences between two snapshots.`,
+	Long: strings.ReplaceAll(`Shows the files which differ between snapshot ID1 and ID2, one per
+line, with a symbol and then a space and then the path.
+
+- |+ path| means path was only in ID2
+- |- path| means path was only in ID1
+- |* path| means path was in both but its contents differ`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Listing",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(3, 3, command, args)
+		frepo := cmd.NewFsDir(args[:1])
+		cmd.Run(false, false, command, func() error {
+			ctx := context.Background()
+			r, err := openRepo(ctx, frepo, false, "", hash.Set(hash.None))
+			if err != nil {
+				return err
+			}
+			a, err := r.Load(ctx, args[1])
+			if err != nil {
+				return err
+			}
+			b, err := r.Load(ctx, args[2])
+			if err != nil {
+				return err
+			}
+			for _, change := range Diff(a, b) {
+				operations.SyncFprintf(os.Stdout, "%c %s\n", change.Op, change.Path)
+			}
+			return nil
+		})
+	},
+}
+
+var pruneCommand = &cobra.Command{
+	Use:   "prune repo:path",
+	Short: `Remove old snapshots and unreferenced contents.`,
+	Long: strings.ReplaceAll(`Removes all snapshots except the |--keep-last| most recent ones and
+any taken within |--keep-within| of now, then removes any contents
+which are no longer used by the remaining snapshots.
+
+At least one of |--keep-last| or |--keep-within| must be given.
+
+**Important**: Since this can cause data loss, test first with the
+|--dry-run| or the |--interactive|/|-i| flag.`, "|", "`"),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Important",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		frepo := cmd.NewFsDir(args)
+		cmd.Run(true, false, command, func() error {
+			if keepLast <= 0 && keepWithin <= 0 {
+				return errors.New("need --keep-last or --keep-within")
+			}
+			ctx := context.Background()
+			r, err := openRepo(ctx, frepo, false, "", hash.Set(hash.None))
+			if err != nil {
+				return err
+			}
+			return r.Prune(ctx, keepLast, time.Duration(keepWithin))
+		})
+	},
+}
@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Some times used in the tests
+var (
+	t1 = fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 = fstest.Time("2011-12-25T12:59:59.123456789Z")
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+// count the stored contents in the repository
+func countData(ctx context.Context, t *testing.T, r *Repo) (n int) {
+	require.NoError(t, r.listObjects(ctx, dataDir, func(o fs.Object) {
+		n++
+	}))
+	return n
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("a.txt", "hello", t1)
+	file2 := r.WriteFile("dir/b.txt", "hello", t2)
+	file3 := r.WriteFile("c.txt", "world", t1)
+
+	repo, err := openRepo(ctx, r.Fremote, true, "", r.Flocal.Hashes())
+	require.NoError(t, err)
+
+	_, err = openRepo(ctx, r.Fremote, false, "nonsense", r.Flocal.Hashes())
+	require.Error(t, err)
+
+	// First snapshot stores identical contents once
+	first, err := repo.Create(ctx, r.Flocal)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), first.Files)
+	assert.Equal(t, int64(15), first.Bytes)
+	assert.Equal(t, 2, countData(ctx, t, repo))
+
+	// Change one file and rename another
+	file3 = r.WriteFile("c.txt", "potato", t2)
+	require.NoError(t, r.Flocal.Mkdir(ctx, "new"))
+	file1 = r.RenameFile(file1, "new/a.txt")
+
+	second, err := repo.Create(ctx, r.Flocal)
+	require.NoError(t, err)
+	assert.Equal(t, 3, countData(ctx, t, repo))
+
+	ids, err := repo.IDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{first.ID, second.ID}, ids)
+
+	latest, err := repo.Load(ctx, latestID)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, latest.ID)
+
+	assert.Equal(t, []Change{
+		{Op: '-', Path: "a.txt"},
+		{Op: '*', Path: "c.txt"},
+		{Op: '+', Path: "new/a.txt"},
+	}, Diff(first, second))
+
+	// Restore the first snapshot
+	fdst, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, repo.Restore(ctx, first, fdst))
+	fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{
+		fstest.NewItem("a.txt", "hello", t1),
+		file2,
+		fstest.NewItem("c.txt", "world", t1),
+	}, []string{"dir"}, fs.GetModifyWindow(ctx, fdst))
+
+	// Prune removes the first snapshot and the contents only it used
+	require.NoError(t, repo.Prune(ctx, 1, 0))
+	ids, err = repo.IDs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{second.ID}, ids)
+	assert.Equal(t, 2, countData(ctx, t, repo))
+
+	// Restore the second snapshot on top of the first
+	require.NoError(t, repo.Restore(ctx, second, fdst))
+	fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{
+		fstest.NewItem("a.txt", "hello", t1),
+		file1,
+		file2,
+		file3,
+	}, []string{"dir", "new"}, fs.GetModifyWindow(ctx, fdst))
+}
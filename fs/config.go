@@ -550,6 +550,16 @@ var ConfigOptionsInfo = Options{{
 	Default: ".partial",
 	Help:    "Add partial-suffix to temporary file name when --inplace is not used",
 	Groups:  "Copy",
+}, {
+	Name:    "resume_transfers",
+	Default: false,
+	Help:    "Save the state of multi-thread transfers so an interrupted transfer can be resumed",
+	Groups:  "Copy",
+}, {
+	Name:    "resume_journal",
+	Default: "",
+	Help:    "Journal completed checks, transfers and deletes to this file so an interrupted sync can skip them when restarted",
+	Groups:  "Sync",
 }, {
 	Name:     "max_connections",
 	Help:     "Maximum number of simultaneous backend API connections, 0 for unlimited.",
@@ -676,6 +686,8 @@ type ConfigInfo struct {
 	DefaultTime                Time              `config:"default_time"` // time that directories with no time should display
 	Inplace                    bool              `config:"inplace"`      // Download directly to destination file instead of atomic download to temp/rename
 	PartialSuffix              string            `config:"partial_suffix"`
+	ResumeTransfers            bool              `config:"resume_transfers"`
+	ResumeJournal              string            `config:"resume_journal"`
 	MetadataMapper             SpaceSepList      `config:"metadata_mapper"`
 	MaxConnections             int               `config:"max_connections"`
 	NameTransform              []string          `config:"name_transform"`
@@ -121,6 +121,11 @@ type Features struct {
 	// exists.
 	PutUnchecked func(ctx context.Context, in io.Reader, src ObjectInfo, options ...OpenOption) (Object, error)
 
+	// PutIfNotExists uploads to the remote path only if no object
+	// exists there already, atomically with respect to other
+	// callers, returning ErrorObjectExists if it does.
+	PutIfNotExists func(ctx context.Context, in io.Reader, src ObjectInfo, options ...OpenOption) (Object, error)
+
 	// PutStream uploads to the remote path with the modTime given of indeterminate size
 	//
 	// May create the object even if it returns an error - if so
@@ -327,6 +332,9 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(PutUncheckeder); ok {
 		ft.PutUnchecked = do.PutUnchecked
 	}
+	if do, ok := f.(PutIfNotExistser); ok {
+		ft.PutIfNotExists = do.PutIfNotExists
+	}
 	if do, ok := f.(PutStreamer); ok {
 		ft.PutStream = do.PutStream
 	}
@@ -438,6 +446,9 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.PutUnchecked == nil {
 		ft.PutUnchecked = nil
 	}
+	if mask.PutIfNotExists == nil {
+		ft.PutIfNotExists = nil
+	}
 	if mask.PutStream == nil {
 		ft.PutStream = nil
 	}
@@ -625,6 +636,14 @@ type PutUncheckeder interface {
 	PutUnchecked(ctx context.Context, in io.Reader, src ObjectInfo, options ...OpenOption) (Object, error)
 }
 
+// PutIfNotExistser is an optional interface for Fs
+type PutIfNotExistser interface {
+	// PutIfNotExists uploads to the remote path only if no object
+	// exists there already, atomically with respect to other
+	// callers, returning ErrorObjectExists if it does.
+	PutIfNotExists(ctx context.Context, in io.Reader, src ObjectInfo, options ...OpenOption) (Object, error)
+}
+
 // PutStreamer is an optional interface for Fs
 type PutStreamer interface {
 	// PutStream uploads to the remote path with the modTime given of indeterminate size
@@ -767,6 +786,37 @@ type ChunkWriter interface {
 	Abort(ctx context.Context) error
 }
 
+// ChunkWriterResumer is an optional interface for ChunkWriter which
+// can continue an upload in a later process.
+//
+// The state returned is persisted by the caller and passed back to
+// OpenChunkWriter in a ResumeOption.
+type ChunkWriterResumer interface {
+	// ResumeID returns an identifier for the upload in progress,
+	// e.g. the multipart upload ID.
+	ResumeID() string
+
+	// ChunkToken returns an identifier for chunkNumber which has
+	// been written successfully, e.g. the part ETag.
+	ChunkToken(chunkNumber int) string
+
+	// Resumed returns true if this ChunkWriter is continuing the
+	// upload described by a ResumeOption.
+	Resumed() bool
+}
+
+// WriterAtResumer is an optional interface for Fs which implement
+// OpenWriterAt.
+//
+// It isn't part of Features as it is only used to resume
+// multi-thread copies.
+type WriterAtResumer interface {
+	// ResumeWriterAt opens a file previously opened with
+	// OpenWriterAt for random access writes without truncating
+	// it, so the data already written is kept.
+	ResumeWriterAt(ctx context.Context, remote string, size int64) (WriterAtCloser, error)
+}
+
 // UserInfoer is an optional interface for Fs
 type UserInfoer interface {
 	// UserInfo returns info about the connected user
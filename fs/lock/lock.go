@@ -0,0 +1,519 @@
+// Package lock implements lease based locks on remotes using lock objects
+//
+// A lock called name is stored as a series of lock objects
+// name.lock/<gen>.json where gen is a generation number which only
+// ever increases while the lock is in use. The object with the
+// highest generation describes the current state of the lock.
+//
+// To take the lock a new generation is created. This is done
+// atomically with a conditional write on backends which support
+// PutIfNotExists, otherwise the object is written, and after a short
+// delay read back to check nobody else wrote it at the same time.
+//
+// Locks are leases - they expire if they aren't renewed within their
+// TTL, so a host which crashes holding the lock doesn't hold it
+// forever. Hosts sharing locks need their clocks roughly in sync.
+//
+// Releasing the lock deletes its lock objects and the name.lock
+// directory, so a lock which isn't in use doesn't leave anything
+// behind. The object of the holder is deleted last so the lock is
+// never seen as free with a lower generation than one which might
+// still be taken.
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// DefaultTTL is the lease time of a lock if none is given
+const DefaultTTL = 5 * time.Minute
+
+// Tunables - variables so they can be changed in the tests
+var (
+	// settleDelay is how long to wait after writing a lock object
+	// on a backend without conditional writes before reading it
+	// back to check nobody else overwrote it
+	settleDelay = 2 * time.Second
+
+	// pollInterval is how often to retry taking a lock when waiting
+	pollInterval = time.Second
+)
+
+// Errors returned by this package
+var (
+	// ErrLocked is returned, wrapped in a HeldError, if someone else
+	// holds the lock
+	ErrLocked = errors.New("lock is held by someone else")
+
+	// ErrLockLost is returned when renewing or releasing a lock
+	// which someone else has taken after it expired
+	ErrLockLost = errors.New("lock lost")
+
+	// errUndecodable is returned by readInfo if the lock object
+	// can't be decoded
+	errUndecodable = errors.New("can't decode lock object")
+)
+
+// HeldError is returned if the lock is held by someone else
+type HeldError struct {
+	Info Info // the current holder of the lock
+}
+
+// Error satisfies the error interface
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lock %q is held by %q until %v", e.Info.Name, e.Info.Owner, e.Info.Expires.Format(time.RFC3339))
+}
+
+// Unwrap returns ErrLocked so errors.Is can be used
+func (e *HeldError) Unwrap() error {
+	return ErrLocked
+}
+
+// Options for taking a lock
+type Options struct {
+	TTL   time.Duration // how long the lock lasts unless renewed - DefaultTTL if 0
+	Owner string        // description of the holder - hostname:pid if empty
+	Wait  time.Duration // how long to wait for the lock if it is held - don't wait if 0
+	Renew bool          // if set renew the lock in the background until released
+}
+
+// Info is the contents of a lock object
+type Info struct {
+	Name     string    `json:"name"`               // name of the lock
+	Owner    string    `json:"owner"`              // description of the holder
+	ID       string    `json:"id"`                 // unique ID of this holding of the lock
+	Gen      int64     `json:"gen"`                // generation number
+	Acquired time.Time `json:"acquired"`           // when the lock was taken
+	Expires  time.Time `json:"expires"`            // when the lock expires unless renewed
+	Released bool      `json:"released,omitempty"` // set if the lock was released
+}
+
+// Held returns true if the lock described by info is held at now
+func (info *Info) Held(now time.Time) bool {
+	return !info.Released && now.Before(info.Expires)
+}
+
+// Lock is a lease based lock on a remote
+type Lock struct {
+	f       fs.Fs
+	ttl     time.Duration
+	mu      sync.Mutex
+	info    Info          // current state of the lock
+	obj     fs.Object     // our lock object
+	stop    chan struct{} // closed to stop background renewal
+	stopped chan struct{} // closed when background renewal has stopped
+	lost    chan struct{} // closed if the lock is lost
+}
+
+// dir returns the directory the lock objects for name are stored in
+func dir(name string) string {
+	return name + ".lock"
+}
+
+// genRemote returns the remote of the lock object for gen
+func genRemote(name string, gen int64) string {
+	return path.Join(dir(name), fmt.Sprintf("%020d.json", gen))
+}
+
+// listGens returns the lock objects of name keyed by generation
+// along with the highest generation, or 0 if there are none
+func listGens(ctx context.Context, f fs.Fs, name string) (objs map[int64]fs.Object, maxGen int64, err error) {
+	entries, err := f.List(ctx, dir(name))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list lock %q: %w", name, err)
+	}
+	objs = make(map[int64]fs.Object)
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		gen, err := strconv.ParseInt(strings.TrimSuffix(path.Base(o.Remote()), ".json"), 10, 64)
+		if err != nil || gen <= 0 {
+			continue
+		}
+		objs[gen] = o
+		maxGen = max(maxGen, gen)
+	}
+	return objs, maxGen, nil
+}
+
+// readInfo reads the lock object o
+func readInfo(ctx context.Context, o fs.Object) (info Info, err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return info, fmt.Errorf("failed to open lock object: %w", err)
+	}
+	defer fs.CheckClose(in, &err)
+	data, err := io.ReadAll(io.LimitReader(in, 64*1024))
+	if err != nil {
+		return info, fmt.Errorf("failed to read lock object: %w", err)
+	}
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return info, fmt.Errorf("%w %q: %v", errUndecodable, o.Remote(), err)
+	}
+	return info, nil
+}
+
+// current reads the lock object with the highest generation
+//
+// It returns found false if there isn't one.
+//
+// Backends without atomic writes may show a lock object before it
+// has all been written, so a lock object which can't be decoded is
+// treated as held for DefaultTTL after it was written.
+func current(ctx context.Context, f fs.Fs, name string) (info Info, found bool, err error) {
+	objs, maxGen, err := listGens(ctx, f, name)
+	if err != nil || maxGen == 0 {
+		return info, false, err
+	}
+	o := objs[maxGen]
+	info, err = readInfo(ctx, o)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		// deleted under our feet - treat as not found
+		return info, false, nil
+	}
+	if errors.Is(err, errUndecodable) {
+		fs.Debugf(f, "Treating lock as held: %v", err)
+		modTime := o.ModTime(ctx)
+		return Info{
+			Name:     name,
+			Owner:    "unknown",
+			Gen:      maxGen,
+			Acquired: modTime,
+			Expires:  modTime.Add(DefaultTTL),
+		}, true, nil
+	}
+	return info, err == nil, err
+}
+
+// Status returns the current state of the lock called name on f
+//
+// It returns found false if the lock has never been taken.
+func Status(ctx context.Context, f fs.Fs, name string) (info Info, found bool, err error) {
+	return current(ctx, f, name)
+}
+
+// encode info ready for uploading
+func encode(info Info) (data []byte, src fs.ObjectInfo) {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		panic(err) // can't fail
+	}
+	src = object.NewStaticObjectInfo(genRemote(info.Name, info.Gen), time.Now(), int64(len(data)), true, nil, nil)
+	return data, src
+}
+
+// create writes the lock object for info only if it doesn't exist,
+// returning fs.ErrorObjectExists if it does
+func create(ctx context.Context, f fs.Fs, info Info) (fs.Object, error) {
+	data, src := encode(info)
+	if do := f.Features().PutIfNotExists; do != nil {
+		return do(ctx, bytes.NewReader(data), src)
+	}
+	// Not atomic - the caller must check the lock after settleDelay
+	_, err := f.NewObject(ctx, src.Remote())
+	if err == nil {
+		return nil, fs.ErrorObjectExists
+	} else if !errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil, err
+	}
+	return f.Put(ctx, bytes.NewReader(data), src)
+}
+
+// defaultOwner returns hostname:pid
+func defaultOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// Acquire takes the lock called name on f
+//
+// If the lock is held by someone else it returns a *HeldError which
+// wraps ErrLocked, after waiting for up to opt.Wait for it to become
+// free.
+func Acquire(ctx context.Context, f fs.Fs, name string, opt Options) (*Lock, error) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil, errors.New("lock name must not be empty")
+	}
+	if opt.TTL <= 0 {
+		opt.TTL = DefaultTTL
+	}
+	if opt.Owner == "" {
+		opt.Owner = defaultOwner()
+	}
+	l := &Lock{
+		f:    f,
+		ttl:  opt.TTL,
+		lost: make(chan struct{}),
+	}
+	deadline := time.Now().Add(opt.Wait)
+	for {
+		err := l.try(ctx, name, opt.Owner)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			return nil, err
+		}
+		fs.Debugf(f, "Waiting for lock: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	fs.Debugf(f, "Acquired lock %q generation %d", name, l.info.Gen)
+	l.cleanup(ctx)
+	if opt.Renew {
+		l.stop = make(chan struct{})
+		l.stopped = make(chan struct{})
+		go l.renewer(context.WithoutCancel(ctx))
+	}
+	return l, nil
+}
+
+// try to take the lock once
+func (l *Lock) try(ctx context.Context, name, owner string) error {
+	info, found, err := current(ctx, l.f, name)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if found && info.Held(now) {
+		return &HeldError{Info: info}
+	}
+	l.info = Info{
+		Name:     name,
+		Owner:    owner,
+		ID:       random.String(16),
+		Gen:      info.Gen + 1,
+		Acquired: now,
+		Expires:  now.Add(l.ttl),
+	}
+	l.obj, err = create(ctx, l.f, l.info)
+	if errors.Is(err, fs.ErrorObjectExists) {
+		l.obj, err = l.created(ctx)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to write lock object: %w", err)
+	}
+	if l.f.Features().PutIfNotExists == nil {
+		time.Sleep(settleDelay)
+	}
+	// Check nobody else took the lock at the same time
+	info, found, err = current(ctx, l.f, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("lock object %q vanished after writing it", genRemote(name, l.info.Gen))
+	}
+	if info.ID != l.info.ID {
+		if info.Gen != l.info.Gen {
+			// Our generation was superseded so remove it
+			_ = l.obj.Remove(ctx)
+		}
+		return &HeldError{Info: info}
+	}
+	return nil
+}
+
+// created is called when the lock object for our generation already
+// exists. A conditional write which is retried after its response was
+// lost finds the object it wrote, so if the object is ours it is
+// returned, otherwise someone else took this generation and a
+// *HeldError is returned.
+func (l *Lock) created(ctx context.Context) (fs.Object, error) {
+	remote := genRemote(l.info.Name, l.info.Gen)
+	o, err := l.f.NewObject(ctx, remote)
+	if err == nil {
+		var info Info
+		info, err = readInfo(ctx, o)
+		if err == nil && info.ID == l.info.ID {
+			fs.Debugf(l.f, "Lock object %q was written by us", remote)
+			return o, nil
+		}
+	}
+	// Someone else took this generation - report who
+	info, found, err := current(ctx, l.f, l.info.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		info = l.info
+	}
+	return nil, &HeldError{Info: info}
+}
+
+// check the lock is still ours
+//
+// Call with mu held
+func (l *Lock) check(ctx context.Context) error {
+	if l.obj == nil {
+		return errors.New("lock already released")
+	}
+	info, found, err := current(ctx, l.f, l.info.Name)
+	if err != nil {
+		return err
+	}
+	if !found || info.ID != l.info.ID {
+		return fmt.Errorf("%w: now held by %q", ErrLockLost, info.Owner)
+	}
+	return nil
+}
+
+// update writes l.info to our lock object
+//
+// Call with mu held
+func (l *Lock) update(ctx context.Context) error {
+	data, src := encode(l.info)
+	return l.obj.Update(ctx, bytes.NewReader(data), src)
+}
+
+// Info returns the current state of the lock
+func (l *Lock) Info() Info {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.info
+}
+
+// Lost returns a channel which is closed if background renewal finds
+// the lock has been lost
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Renew extends the lock by its TTL from now
+//
+// It returns an error wrapping ErrLockLost if the lock expired and
+// someone else took it.
+func (l *Lock) Renew(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.check(ctx)
+	if err != nil {
+		return err
+	}
+	l.info.Expires = time.Now().Add(l.ttl)
+	err = l.update(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return nil
+}
+
+// renewer renews the lock every ttl/3 until stopped or lost
+func (l *Lock) renewer(ctx context.Context) {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		err := l.Renew(ctx)
+		if errors.Is(err, ErrLockLost) {
+			fs.Errorf(l.f, "Lock %q: %v", l.info.Name, err)
+			close(l.lost)
+			return
+		} else if err != nil {
+			fs.Errorf(l.f, "Lock %q: failed to renew - will retry: %v", l.info.Name, err)
+		}
+	}
+}
+
+// Release releases the lock, stopping any background renewal
+//
+// The lock objects are deleted along with the directory they are in
+// if they are empty. If our lock object can't be deleted it is marked
+// as released instead.
+func (l *Lock) Release(ctx context.Context) error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.stopped
+		l.stop = nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.check(ctx)
+	if err != nil {
+		return err
+	}
+	l.removeOld(ctx, 0)
+	err = l.obj.Remove(ctx)
+	if err != nil {
+		fs.Debugf(l.f, "Failed to remove lock object - marking it as released: %v", err)
+		l.info.Released = true
+		l.info.Expires = time.Now()
+		err = l.update(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+	} else {
+		// This fails if someone else has taken the lock since
+		err = l.f.Rmdir(ctx, dir(l.info.Name))
+		if err != nil {
+			fs.Debugf(l.f, "Didn't remove lock directory: %v", err)
+		}
+	}
+	l.obj = nil
+	fs.Debugf(l.f, "Released lock %q generation %d", l.info.Name, l.info.Gen)
+	return nil
+}
+
+// cleanup removes all but the last two generations of lock objects
+func (l *Lock) cleanup(ctx context.Context) {
+	l.removeOld(ctx, 1)
+}
+
+// removeOld removes the lock objects older than ours apart from the
+// newest keep of them
+func (l *Lock) removeOld(ctx context.Context, keep int) {
+	objs, _, err := listGens(ctx, l.f, l.info.Name)
+	if err != nil {
+		fs.Debugf(l.f, "Failed to clean up old lock objects: %v", err)
+		return
+	}
+	gens := make([]int64, 0, len(objs))
+	for gen := range objs {
+		if gen < l.info.Gen {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] > gens[j] })
+	for _, gen := range gens[min(keep, len(gens)):] {
+		err := objs[gen].Remove(ctx)
+		if err != nil {
+			fs.Debugf(l.f, "Failed to remove old lock object: %v", err)
+		}
+	}
+}
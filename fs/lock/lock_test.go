@@ -0,0 +1,207 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// retriedPutFs writes the object with PutIfNotExists then says it
+// already exists, as a retry of a write whose response was lost does
+type retriedPutFs struct {
+	fs.Fs
+}
+
+// Features returns the optional features with a retried PutIfNotExists
+func (f retriedPutFs) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	putIfNotExists := ft.PutIfNotExists
+	ft.PutIfNotExists = func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+		_, err := putIfNotExists(ctx, in, src, options...)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fs.ErrorObjectExists
+	}
+	return &ft
+}
+
+// noConditionalFs hides PutIfNotExists to test the fallback
+type noConditionalFs struct {
+	fs.Fs
+}
+
+// Features returns the optional features without PutIfNotExists
+func (f noConditionalFs) Features() *fs.Features {
+	ft := *f.Fs.Features()
+	ft.PutIfNotExists = nil
+	return &ft
+}
+
+func newTestFs(t *testing.T) fs.Fs {
+	f, err := fs.NewFs(context.Background(), ":memory:"+random.String(8))
+	require.NoError(t, err)
+	return f
+}
+
+func testAcquireRelease(t *testing.T, f fs.Fs) {
+	ctx := context.Background()
+
+	l, err := Acquire(ctx, f, "dir/test", Options{Owner: "one", TTL: time.Minute})
+	require.NoError(t, err)
+	info := l.Info()
+	assert.Equal(t, "dir/test", info.Name)
+	assert.Equal(t, "one", info.Owner)
+	assert.Equal(t, int64(1), info.Gen)
+
+	// Someone else can't take it
+	_, err = Acquire(ctx, f, "dir/test", Options{Owner: "two"})
+	assert.ErrorIs(t, err, ErrLocked)
+	var heldErr *HeldError
+	require.True(t, errors.As(err, &heldErr))
+	assert.Equal(t, "one", heldErr.Info.Owner)
+
+	// But can take a different lock
+	other, err := Acquire(ctx, f, "dir/other", Options{Owner: "two"})
+	require.NoError(t, err)
+	require.NoError(t, other.Release(ctx))
+
+	require.NoError(t, l.Renew(ctx))
+	require.NoError(t, l.Release(ctx))
+	assert.Error(t, l.Renew(ctx))
+
+	// Releasing the lock removes the lock objects and directory
+	_, found, err := Status(ctx, f, "dir/test")
+	require.NoError(t, err)
+	assert.False(t, found)
+	entries, err := f.List(ctx, "dir")
+	if !errors.Is(err, fs.ErrorDirNotFound) {
+		require.NoError(t, err)
+		assert.Len(t, entries, 0)
+	}
+
+	// So it can be taken again starting afresh
+	l, err = Acquire(ctx, f, "dir/test", Options{Owner: "two"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), l.Info().Gen)
+	require.NoError(t, l.Release(ctx))
+}
+
+func TestAcquireRelease(t *testing.T) {
+	testAcquireRelease(t, newTestFs(t))
+}
+
+func TestAcquireReleaseNoConditional(t *testing.T) {
+	oldSettleDelay := settleDelay
+	settleDelay = time.Millisecond
+	defer func() { settleDelay = oldSettleDelay }()
+	testAcquireRelease(t, noConditionalFs{newTestFs(t)})
+}
+
+func TestRetriedPut(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t)
+
+	// Our own lock object is found when the write is retried
+	l, err := Acquire(ctx, retriedPutFs{f}, "test", Options{Owner: "one"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), l.Info().Gen)
+
+	// But someone else's isn't taken
+	_, err = Acquire(ctx, retriedPutFs{f}, "test", Options{Owner: "two"})
+	assert.ErrorIs(t, err, ErrLocked)
+	require.NoError(t, l.Release(ctx))
+}
+
+func TestPartialLockObject(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t)
+
+	// A lock object which is still being written is held
+	data := `{"name": "test", "own`
+	src := object.NewStaticObjectInfo(genRemote("test", 1), time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.Put(ctx, strings.NewReader(data), src)
+	require.NoError(t, err)
+	_, err = Acquire(ctx, f, "test", Options{Owner: "one"})
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// Until it is too old to be being written
+	require.NoError(t, o.SetModTime(ctx, time.Now().Add(-2*DefaultTTL)))
+	l, err := Acquire(ctx, f, "test", Options{Owner: "one"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), l.Info().Gen)
+	require.NoError(t, l.Release(ctx))
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t)
+
+	l, err := Acquire(ctx, f, "test", Options{Owner: "one", TTL: 50 * time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// The expired lock can be taken by someone else
+	l2, err := Acquire(ctx, f, "test", Options{Owner: "two"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), l2.Info().Gen)
+
+	// And the old holder finds out it lost it
+	assert.ErrorIs(t, l.Renew(ctx), ErrLockLost)
+	assert.ErrorIs(t, l.Release(ctx), ErrLockLost)
+
+	// Taking it keeps the previous generation
+	objs, maxGen, err := listGens(ctx, f, "test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), maxGen)
+	assert.Len(t, objs, 2)
+
+	// But releasing it removes them all
+	require.NoError(t, l2.Release(ctx))
+	objs, _, err = listGens(ctx, f, "test")
+	require.NoError(t, err)
+	assert.Len(t, objs, 0)
+}
+
+func TestWaitAndRenew(t *testing.T) {
+	oldPollInterval := pollInterval
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = oldPollInterval }()
+	ctx := context.Background()
+	f := newTestFs(t)
+
+	// Background renewal keeps the lock past its TTL
+	l, err := Acquire(ctx, f, "test", Options{Owner: "one", TTL: 60 * time.Millisecond, Renew: true})
+	require.NoError(t, err)
+	_, err = Acquire(ctx, f, "test", Options{Owner: "two", Wait: 150 * time.Millisecond})
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// Waiting gets the lock once it is released
+	done := make(chan error)
+	go func() {
+		l2, err := Acquire(ctx, f, "test", Options{Owner: "two", Wait: time.Minute})
+		if err == nil {
+			err = l2.Release(ctx)
+		}
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, l.Release(ctx))
+	require.NoError(t, <-done)
+
+	select {
+	case <-l.Lost():
+		t.Fatal("lock reported lost")
+	default:
+	}
+}
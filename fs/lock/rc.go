@@ -0,0 +1,215 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// Locks taken via the rc keyed on their ID
+var (
+	rcLocksMu sync.Mutex
+	rcLocks   = make(map[string]*Lock)
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "lock/acquire",
+		AuthRequired: true,
+		Fn:           rcAcquire,
+		Title:        "Take a lease based lock on a remote",
+		Help: `This takes the following parameters:
+
+- fs - a remote name string e.g. "s3:bucket"
+- name - name of the lock, a path relative to fs e.g. "locks/backup"
+- ttl - how long the lock lasts unless renewed e.g. "5m" (optional, default 5m)
+- owner - description of the holder (optional, default hostname:pid)
+- wait - how long to wait for the lock if it is held e.g. "1m" (optional, default don't wait)
+- renew - set to false to stop rclone renewing the lock in the background (optional, default true)
+
+The lock is stored as lock objects in the "name.lock" directory on
+the remote. Backends which support conditional writes (s3, gcs,
+azureblob, local) take it atomically. Other backends write the lock
+object then read it back after a short delay to check no other host
+took the lock at the same time.
+
+If the lock is held by someone else this returns an error.
+
+Returns the state of the lock:
+
+- name - name of the lock
+- owner - description of the holder
+- id - ID of this holding of the lock, pass this to lock/renew and lock/release
+- gen - generation number of the lock
+- acquired - when the lock was taken
+- expires - when the lock expires unless renewed
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "lock/renew",
+		AuthRequired: true,
+		Fn:           rcRenew,
+		Title:        "Renew a lock taken with lock/acquire",
+		Help: `This takes the following parameters:
+
+- id - the id returned by lock/acquire
+
+This extends the lock by its TTL. It returns an error if the lock
+expired and was taken by someone else.
+
+Returns the state of the lock as lock/acquire does.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "lock/release",
+		AuthRequired: true,
+		Fn:           rcRelease,
+		Title:        "Release a lock taken with lock/acquire",
+		Help: `This takes the following parameters:
+
+- id - the id returned by lock/acquire
+
+This deletes the lock objects, and the "name.lock" directory if
+nobody else has taken the lock since, so a lock which isn't in use
+leaves nothing on the remote.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "lock/status",
+		AuthRequired: true,
+		Fn:           rcStatus,
+		Title:        "Show the state of a lock on a remote",
+		Help: `This takes the following parameters:
+
+- fs - a remote name string e.g. "s3:bucket"
+- name - name of the lock, a path relative to fs e.g. "locks/backup"
+
+Returns:
+
+- held - true if the lock is currently held
+- lock - the state of the lock as lock/acquire returns, if it has any lock objects
+`,
+	})
+}
+
+// infoParams turns info into rc.Params
+func infoParams(info Info) (out rc.Params, err error) {
+	err = rc.Reshape(&out, info)
+	if err != nil {
+		return nil, fmt.Errorf("lock Reshape failed: %w", err)
+	}
+	return out, nil
+}
+
+// getLock finds the lock taken by the rc with the id in in
+func getLock(in rc.Params) (*Lock, error) {
+	id, err := in.GetString("id")
+	if err != nil {
+		return nil, err
+	}
+	rcLocksMu.Lock()
+	defer rcLocksMu.Unlock()
+	l, ok := rcLocks[id]
+	if !ok {
+		return nil, fmt.Errorf("lock with id %q not found", id)
+	}
+	return l, nil
+}
+
+// Take a lock
+func rcAcquire(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rc.GetFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	opt := Options{Renew: true}
+	opt.TTL, err = in.GetDuration("ttl")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	opt.Owner, err = in.GetString("owner")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	opt.Wait, err = in.GetDuration("wait")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	renew, err := in.GetBool("renew")
+	if err == nil {
+		opt.Renew = renew
+	} else if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	l, err := Acquire(ctx, f, name, opt)
+	if err != nil {
+		return nil, err
+	}
+	info := l.Info()
+	rcLocksMu.Lock()
+	rcLocks[info.ID] = l
+	rcLocksMu.Unlock()
+	return infoParams(info)
+}
+
+// Renew a lock
+func rcRenew(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	l, err := getLock(in)
+	if err != nil {
+		return nil, err
+	}
+	err = l.Renew(ctx)
+	if errors.Is(err, ErrLockLost) {
+		rcLocksMu.Lock()
+		delete(rcLocks, l.Info().ID)
+		rcLocksMu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return infoParams(l.Info())
+}
+
+// Release a lock
+func rcRelease(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	l, err := getLock(in)
+	if err != nil {
+		return nil, err
+	}
+	rcLocksMu.Lock()
+	delete(rcLocks, l.Info().ID)
+	rcLocksMu.Unlock()
+	return nil, l.Release(ctx)
+}
+
+// Show the status of a lock
+func rcStatus(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rc.GetFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	info, found, err := Status(ctx, f, name)
+	if err != nil {
+		return nil, err
+	}
+	out = rc.Params{"held": found && info.Held(time.Now())}
+	if found {
+		out["lock"], err = infoParams(info)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
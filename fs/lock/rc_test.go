@@ -0,0 +1,50 @@
+package lock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRcLock(t *testing.T) {
+	ctx := context.Background()
+	f := newTestFs(t)
+	remote := f.Name() + ":" + f.Root()
+	cache.Put(remote, f)
+
+	call := func(method string, in rc.Params) (rc.Params, error) {
+		c := rc.Calls.Get(method)
+		require.NotNil(t, c)
+		return c.Fn(ctx, in)
+	}
+
+	out, err := call("lock/acquire", rc.Params{"fs": remote, "name": "test", "owner": "rc", "ttl": "1m"})
+	require.NoError(t, err)
+	assert.Equal(t, "rc", out["owner"])
+	id, ok := out["id"].(string)
+	require.True(t, ok)
+
+	_, err = call("lock/acquire", rc.Params{"fs": remote, "name": "test"})
+	assert.ErrorIs(t, err, ErrLocked)
+
+	out, err = call("lock/status", rc.Params{"fs": remote, "name": "test"})
+	require.NoError(t, err)
+	assert.Equal(t, true, out["held"])
+
+	out, err = call("lock/renew", rc.Params{"id": id})
+	require.NoError(t, err)
+	assert.Equal(t, id, out["id"])
+
+	_, err = call("lock/release", rc.Params{"id": id})
+	require.NoError(t, err)
+	_, err = call("lock/release", rc.Params{"id": id})
+	assert.Error(t, err)
+
+	out, err = call("lock/status", rc.Params{"fs": remote, "name": "test"})
+	require.NoError(t, err)
+	assert.Equal(t, false, out["held"])
+}
@@ -306,6 +306,32 @@ func (o *ChunkOption) String() string {
 	return fmt.Sprintf("ChunkOption(%v)", o.ChunkSize)
 }
 
+// ResumeOption defines an Option which asks OpenChunkWriter to
+// continue an upload started by a previous process rather than
+// starting a new one.
+//
+// Backends which honour this return a ChunkWriter which implements
+// ChunkWriterResumer and reports Resumed() as true.
+type ResumeOption struct {
+	ID     string         // ResumeID of the upload to continue
+	Chunks map[int]string // ChunkToken of each chunk already written
+}
+
+// Header formats the option as an http header
+func (o *ResumeOption) Header() (key string, value string) {
+	return "", ""
+}
+
+// Mandatory returns whether the option must be parsed or can be ignored
+func (o *ResumeOption) Mandatory() bool {
+	return false
+}
+
+// String formats the option into human-readable form
+func (o *ResumeOption) String() string {
+	return fmt.Sprintf("ResumeOption(%q, %d chunks)", o.ID, len(o.Chunks))
+}
+
 // OpenOptionAddHeaders adds each header found in options to the
 // headers map provided the key was non empty.
 func OpenOptionAddHeaders(options []OpenOption, headers map[string]string) {
@@ -55,6 +55,10 @@ func (c *copy) removeFailedCopy(ctx context.Context, o fs.Object) {
 
 // Used to remove a failed partial copy
 func (c *copy) removeFailedPartialCopy(ctx context.Context, f fs.Fs, remote string) {
+	if c.ci.ResumeTransfers && haveResumeState(f, remote) {
+		fs.Infof(remote, "Keeping partial copy so it can be resumed")
+		return
+	}
 	o, err := f.NewObject(ctx, remote)
 	if errors.Is(err, fs.ErrorObjectNotFound) {
 		// Assume object has been deleted
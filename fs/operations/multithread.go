@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"time"
 
 	"github.com/rclone/rclone/fs"
@@ -164,9 +165,33 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		return nil, fmt.Errorf("multi-thread copy: can't copy zero sized file")
 	}
 
-	info, chunkWriter, err := openChunkWriter(ctx, remote, src, options...)
-	if err != nil {
-		return nil, fmt.Errorf("multi-thread copy: failed to open chunk writer: %w", err)
+	var (
+		info        fs.ChunkWriterInfo
+		chunkWriter fs.ChunkWriter
+		resume      *resumeState
+	)
+	if ci.ResumeTransfers {
+		info, chunkWriter, resume = resumeChunkWriter(ctx, f, remote, src, openChunkWriter, options)
+	}
+	if chunkWriter == nil {
+		info, chunkWriter, err = openChunkWriter(ctx, remote, src, options...)
+		if err != nil {
+			return nil, fmt.Errorf("multi-thread copy: failed to open chunk writer: %w", err)
+		}
+	}
+	if ci.ResumeTransfers && resume == nil {
+		_, canResumeWriterAt := f.(fs.WriterAtResumer)
+		if do, ok := chunkWriter.(fs.ChunkWriterResumer); ok && (!usingOpenWriterAt || canResumeWriterAt) {
+			resume, err = newResumeState(ctx, f, remote, src, info.ChunkSize, do.ResumeID())
+			if err != nil {
+				fs.Errorf(src, "multi-thread copy: transfer won't be resumable: %v", err)
+			}
+		} else {
+			fs.Debugf(src, "multi-thread copy: transfer won't be resumable as %v doesn't support it", f)
+		}
+	}
+	if resume != nil {
+		defer resume.close()
 	}
 
 	uploadCtx, cancel := context.WithCancel(ctx)
@@ -177,6 +202,13 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		if info.LeavePartsOnError || uploadedOK {
 			return
 		}
+		// Keep the parts written so far even if this was a resumed
+		// transfer - the next run checks the upload still exists
+		// when it opens the chunk writer and starts afresh if not.
+		if resume != nil {
+			fs.Debugf(src, "multi-thread copy: leaving transfer to be resumed")
+			return
+		}
 		fs.Debugf(src, "multi-thread copy: cancelling transfer on exit")
 		abortErr := chunkWriter.Abort(ctx)
 		if abortErr != nil {
@@ -226,9 +258,20 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		if gCtx.Err() != nil {
 			break
 		}
+		// Skip chunks written by a previous run accounting for them
+		// so the progress reaches 100%
+		if resume != nil && resume.done(chunk) {
+			start := int64(chunk) * mc.partSize
+			mc.acc.ServerSideTransferEnd(min(start+mc.partSize, mc.size) - start)
+			continue
+		}
 		chunk := chunk
 		g.Go(func() error {
-			return mc.copyChunk(gCtx, chunk, chunkWriter)
+			err := mc.copyChunk(gCtx, chunk, chunkWriter)
+			if err == nil && resume != nil {
+				err = resume.add(chunk, chunkWriter.(fs.ChunkWriterResumer).ChunkToken(chunk))
+			}
+			return err
 		})
 	}
 
@@ -241,6 +284,9 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		return nil, fmt.Errorf("multi-thread copy: failed to close object after copy: %w", err)
 	}
 	uploadedOK = true // file is definitely uploaded OK so no need to abort
+	if resume != nil {
+		resume.remove()
+	}
 
 	obj, err := f.NewObject(ctx, remote)
 	if err != nil {
@@ -283,6 +329,40 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	return obj, nil
 }
 
+// resumeChunkWriter attempts to continue the transfer of src to
+// remote from a previous run using the saved resume state.
+//
+// If the transfer can't be resumed it returns a nil resumeState and
+// either a nil writer or the new writer the backend opened instead.
+func resumeChunkWriter(ctx context.Context, f fs.Fs, remote string, src fs.Object, openChunkWriter fs.OpenChunkWriterFn, options []fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, resume *resumeState) {
+	resume, stale := loadResumeState(ctx, f, remote, src)
+	if resume == nil {
+		return info, nil, nil
+	}
+	resumeOptions := append(slices.Clone(options), resume.option())
+	info, writer, err := openChunkWriter(ctx, remote, src, resumeOptions...)
+	if err != nil {
+		fs.Errorf(src, "multi-thread copy: failed to resume transfer so restarting it: %v", err)
+		resume.remove()
+		return info, nil, nil
+	}
+	if do, ok := writer.(fs.ChunkWriterResumer); !ok || !do.Resumed() {
+		// The backend started a new upload so use that
+		resume.remove()
+		return info, writer, nil
+	}
+	if stale || info.ChunkSize != resume.header.ChunkSize {
+		fs.Infof(src, "multi-thread copy: source or chunk size changed so restarting transfer")
+		if err := writer.Abort(ctx); err != nil {
+			fs.Debugf(src, "multi-thread copy: failed to abort previous transfer: %v", err)
+		}
+		resume.remove()
+		return info, nil, nil
+	}
+	fs.Infof(src, "multi-thread copy: resuming transfer with %d chunks already written", len(resume.chunks))
+	return info, writer, resume
+}
+
 // writerAtChunkWriter converts a WriterAtCloser into a ChunkWriter
 type writerAtChunkWriter struct {
 	remote          string
@@ -293,6 +373,7 @@ type writerAtChunkWriter struct {
 	writeBufferSize int64
 	f               fs.Fs
 	closed          bool
+	resumed         bool
 }
 
 // WriteChunk writes chunkNumber from reader
@@ -348,12 +429,41 @@ func (w *writerAtChunkWriter) Abort(ctx context.Context) error {
 	return obj.Remove(ctx)
 }
 
+// ResumeID returns the identifier of the transfer which is the remote
+// being written to
+func (w *writerAtChunkWriter) ResumeID() string {
+	return w.remote
+}
+
+// ChunkToken returns the token for a written chunk which isn't needed
+func (w *writerAtChunkWriter) ChunkToken(chunkNumber int) string {
+	return ""
+}
+
+// Resumed returns true if the file was reopened to continue a transfer
+func (w *writerAtChunkWriter) Resumed() bool {
+	return w.resumed
+}
+
 // openChunkWriterFromOpenWriterAt adapts an OpenWriterAtFn into an OpenChunkWriterFn using chunkSize and writeBufferSize
 func openChunkWriterFromOpenWriterAt(openWriterAt fs.OpenWriterAtFn, chunkSize int64, writeBufferSize int64, f fs.Fs) fs.OpenChunkWriterFn {
 	return func(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
 		ci := fs.GetConfig(ctx)
 
-		writerAt, err := openWriterAt(ctx, remote, src.Size())
+		// Reopen the file without truncating it if resuming
+		var writerAt fs.WriterAtCloser
+		resumed := false
+		for _, option := range options {
+			if resumeOption, ok := option.(*fs.ResumeOption); ok && resumeOption.ID == remote {
+				if do, ok := f.(fs.WriterAtResumer); ok {
+					writerAt, err = do.ResumeWriterAt(ctx, remote, src.Size())
+					resumed = true
+				}
+			}
+		}
+		if !resumed {
+			writerAt, err = openWriterAt(ctx, remote, src.Size())
+		}
 		if err != nil {
 			return info, nil, err
 		}
@@ -370,6 +480,7 @@ func openChunkWriterFromOpenWriterAt(openWriterAt fs.OpenWriterAtFn, chunkSize i
 			writerAt:        writerAt,
 			writeBufferSize: writeBufferSize,
 			f:               f,
+			resumed:         resumed,
 		}
 		info = fs.ChunkWriterInfo{
 			ChunkSize:   chunkSize,
@@ -378,3 +489,6 @@ func openChunkWriterFromOpenWriterAt(openWriterAt fs.OpenWriterAtFn, chunkSize i
 		return info, chunkWriter, nil
 	}
 }
+
+// Check interfaces
+var _ fs.ChunkWriterResumer = (*writerAtChunkWriter)(nil)
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fstest/mockfs"
@@ -333,3 +335,132 @@ func TestMultithreadCopyAbort(t *testing.T) {
 		require.NoError(t, o.Remove(ctx))
 	}
 }
+
+type countOpenObject struct {
+	fs.Object
+	opens *atomic.Int32
+}
+
+// Open opens the file for read counting the number of opens
+func (o countOpenObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	o.opens.Add(1)
+	return o.Object.Open(ctx, options...)
+}
+
+// Make sure an interrupted multi-thread copy can be resumed.
+func TestMultithreadCopyResume(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.ResumeTransfers = true
+	if r.Fremote.Features().OpenChunkWriter == nil {
+		ci.MultiThreadChunkSize = fs.SizeSuffix(fs.Mebi)
+	}
+	chunkSize := skipIfNotMultithread(ctx, t, r)
+	size := 2*chunkSize + 1
+
+	if *fstest.SizeLimit > 0 && int64(size) > *fstest.SizeLimit {
+		t.Skipf("exceeded file size limit %d > %d", size, *fstest.SizeLimit)
+	}
+
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	}()
+
+	const fileName = "test-multithread-resume"
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile(fileName, random.String(size), t1)
+	r.CheckLocalItems(t, file1)
+	src, err := r.Flocal.NewObject(ctx, fileName)
+	require.NoError(t, err)
+
+	// Fail the transfer on the last chunk
+	accounting.GlobalStats().ResetCounters()
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	wg := new(sync.WaitGroup)
+	dst, err := multiThreadCopy(ctx, r.Fremote, fileName, errorObject{src, int64(size), wg}, 1, tr)
+	tr.Done(ctx, err)
+	require.Error(t, err)
+	assert.Nil(t, dst)
+	if !haveResumeState(r.Fremote, fileName) {
+		t.Skip("resuming transfers not supported")
+	}
+
+	// Resume it which should only need the last chunk
+	opens := new(atomic.Int32)
+	tr = accounting.GlobalStats().NewTransfer(src, nil)
+	dst, err = multiThreadCopy(ctx, r.Fremote, fileName, countOpenObject{src, opens}, 1, tr)
+	assert.Equal(t, src.Size(), tr.Snapshot().Bytes, "chunks skipped must be accounted")
+	tr.Done(ctx, err)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), opens.Load())
+	assert.False(t, haveResumeState(r.Fremote, fileName))
+	assert.Equal(t, src.Size(), dst.Size())
+	if r.Fremote.Features().IsLocal {
+		r.CheckRemoteItems(t, file1)
+	}
+	require.NoError(t, dst.Remove(ctx))
+}
+
+// Make sure a resumed multi-thread copy which fails again can still
+// be resumed
+func TestMultithreadCopyResumeFailsAgain(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.ResumeTransfers = true
+	if r.Fremote.Features().OpenChunkWriter == nil {
+		ci.MultiThreadChunkSize = fs.SizeSuffix(fs.Mebi)
+	}
+	chunkSize := skipIfNotMultithread(ctx, t, r)
+	size := 2*chunkSize + 1
+
+	if *fstest.SizeLimit > 0 && int64(size) > *fstest.SizeLimit {
+		t.Skipf("exceeded file size limit %d > %d", size, *fstest.SizeLimit)
+	}
+
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	defer func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	}()
+
+	const fileName = "test-multithread-resume-fails"
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile(fileName, random.String(size), t1)
+	r.CheckLocalItems(t, file1)
+	src, err := r.Flocal.NewObject(ctx, fileName)
+	require.NoError(t, err)
+
+	copyFailing := func() {
+		tr := accounting.GlobalStats().NewTransfer(src, nil)
+		wg := new(sync.WaitGroup)
+		dst, err := multiThreadCopy(ctx, r.Fremote, fileName, errorObject{src, int64(size), wg}, 1, tr)
+		tr.Done(ctx, err)
+		require.Error(t, err)
+		assert.Nil(t, dst)
+	}
+
+	// The first failure leaves the transfer to be resumed
+	copyFailing()
+	if !haveResumeState(r.Fremote, fileName) {
+		t.Skip("resuming transfers not supported")
+	}
+
+	// Failing again when resuming it keeps the parts written
+	copyFailing()
+	assert.True(t, haveResumeState(r.Fremote, fileName))
+
+	// So it can be resumed again needing only the last chunk
+	opens := new(atomic.Int32)
+	tr := accounting.GlobalStats().NewTransfer(src, nil)
+	dst, err := multiThreadCopy(ctx, r.Fremote, fileName, countOpenObject{src, opens}, 1, tr)
+	tr.Done(ctx, err)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), opens.Load())
+	assert.False(t, haveResumeState(r.Fremote, fileName))
+	if r.Fremote.Features().IsLocal {
+		r.CheckRemoteItems(t, file1)
+	}
+	require.NoError(t, dst.Remove(ctx))
+}
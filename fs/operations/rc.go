@@ -465,6 +465,7 @@ This returns info about the remote passed in;
                 "OpenWriterAt": true,
                 "PublicLink": false,
                 "Purge": true,
+                "PutIfNotExists": true,
                 "PutStream": true,
                 "PutUnchecked": false,
                 "ReadMetadata": true,
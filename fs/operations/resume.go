@@ -0,0 +1,217 @@
+package operations
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+)
+
+// resumeDir is the directory in the cache dir the resume state files
+// are kept in
+const resumeDir = "resume"
+
+// resumeHeader is the first line of a resume state file
+type resumeHeader struct {
+	Fs          string `json:"fs"`
+	Remote      string `json:"remote"`
+	Fingerprint string `json:"fingerprint"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunkSize"`
+	ID          string `json:"id"`
+}
+
+// resumeChunk is written to the resume state file for each chunk
+// which has been written
+type resumeChunk struct {
+	Chunk int    `json:"chunk"`
+	Token string `json:"token,omitempty"`
+}
+
+// resumeState records the progress of a multi-thread transfer in a
+// local file so that it can be resumed if rclone is restarted.
+//
+// The file is JSON lines - a resumeHeader followed by a resumeChunk
+// for each chunk written.
+type resumeState struct {
+	path   string
+	header resumeHeader
+	chunks map[int]string // chunks written so far and their tokens
+	mu     sync.Mutex
+	out    *os.File
+}
+
+// resumeStatePath returns the path of the resume state file for
+// remote on f
+func resumeStatePath(f fs.Fs, remote string) string {
+	sum := sha256.Sum256([]byte(fs.ConfigString(f) + "\x00" + remote))
+	return filepath.Join(config.GetCacheDir(), resumeDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// haveResumeState returns true if there is a resume state file for
+// remote on f
+func haveResumeState(f fs.Fs, remote string) bool {
+	_, err := os.Stat(resumeStatePath(f, remote))
+	return err == nil
+}
+
+// loadResumeState reads the resume state file for remote on f.
+//
+// It returns nil if there isn't one. If the state was recorded for a
+// different source then the state is returned with stale set so the
+// caller can clean up.
+func loadResumeState(ctx context.Context, f fs.Fs, remote string, src fs.ObjectInfo) (rs *resumeState, stale bool) {
+	statePath := resumeStatePath(f, remote)
+	in, err := os.Open(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fs.Errorf(remote, "multi-thread copy: failed to read resume state: %v", err)
+		}
+		return nil, false
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	rs = &resumeState{
+		path:   statePath,
+		chunks: make(map[int]string),
+	}
+	dec := json.NewDecoder(bufio.NewReader(in))
+	if err = dec.Decode(&rs.header); err != nil {
+		fs.Errorf(remote, "multi-thread copy: ignoring corrupt resume state: %v", err)
+		rs.remove()
+		return nil, false
+	}
+	// Read the chunks stopping at the first error as the last
+	// line may not have been written completely
+	for {
+		var chunk resumeChunk
+		if dec.Decode(&chunk) != nil {
+			break
+		}
+		rs.chunks[chunk.Chunk] = chunk.Token
+	}
+	if rs.header.Fingerprint != fs.Fingerprint(ctx, src, true) || rs.header.Size != src.Size() {
+		fs.Debugf(remote, "multi-thread copy: resume state is for a different source")
+		return rs, true
+	}
+	return rs, false
+}
+
+// newResumeState creates a resume state file for remote on f
+// recording the upload id.
+func newResumeState(ctx context.Context, f fs.Fs, remote string, src fs.ObjectInfo, chunkSize int64, id string) (rs *resumeState, err error) {
+	rs = &resumeState{
+		path: resumeStatePath(f, remote),
+		header: resumeHeader{
+			Fs:          fs.ConfigString(f),
+			Remote:      remote,
+			Fingerprint: fs.Fingerprint(ctx, src, true),
+			Size:        src.Size(),
+			ChunkSize:   chunkSize,
+			ID:          id,
+		},
+		chunks: make(map[int]string),
+	}
+	if err = os.MkdirAll(filepath.Dir(rs.path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to make resume state directory: %w", err)
+	}
+	if err = rs.open(os.O_TRUNC); err != nil {
+		return nil, err
+	}
+	if err = rs.write(&rs.header); err != nil {
+		rs.remove()
+		return nil, err
+	}
+	return rs, nil
+}
+
+// open the state file for appending
+func (rs *resumeState) open(flags int) (err error) {
+	rs.out, err = os.OpenFile(rs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|flags, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open resume state: %w", err)
+	}
+	return nil
+}
+
+// write a JSON line to the state file - call with lock held or
+// before the state is shared
+func (rs *resumeState) write(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if _, err = rs.out.Write(buf); err != nil {
+		return fmt.Errorf("failed to write resume state: %w", err)
+	}
+	return nil
+}
+
+// done returns true if chunk was written in a previous run
+func (rs *resumeState) done(chunk int) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	_, ok := rs.chunks[chunk]
+	return ok
+}
+
+// add records that chunk has been written with token
+func (rs *resumeState) add(chunk int, token string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.out == nil {
+		if err := rs.open(0); err != nil {
+			return err
+		}
+	}
+	rs.chunks[chunk] = token
+	return rs.write(resumeChunk{Chunk: chunk, Token: token})
+}
+
+// option returns the ResumeOption to continue the upload
+func (rs *resumeState) option() *fs.ResumeOption {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	chunks := make(map[int]string, len(rs.chunks))
+	for chunk, token := range rs.chunks {
+		chunks[chunk] = token
+	}
+	return &fs.ResumeOption{
+		ID:     rs.header.ID,
+		Chunks: chunks,
+	}
+}
+
+// close the state file leaving it in place so the transfer can be
+// resumed
+func (rs *resumeState) close() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.out == nil {
+		return
+	}
+	if err := rs.out.Close(); err != nil {
+		fs.Errorf(rs.header.Remote, "multi-thread copy: failed to close resume state: %v", err)
+	}
+	rs.out = nil
+}
+
+// remove the state file as the transfer is finished with
+func (rs *resumeState) remove() {
+	rs.close()
+	err := os.Remove(rs.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fs.Errorf(rs.header.Remote, "multi-thread copy: failed to remove resume state: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed cron style schedule
+type cronSpec struct {
+	every  time.Duration // if set run at this interval, ignoring the fields below
+	minute uint64        // bit set of minutes 0-59
+	hour   uint64        // bit set of hours 0-23
+	dom    uint64        // bit set of days of the month 1-31
+	month  uint64        // bit set of months 1-12
+	dow    uint64        // bit set of days of the week 0-6, Sunday is 0
+	domAll bool          // set if the day of month field was *
+	dowAll bool          // set if the day of week field was *
+}
+
+// cronField describes a field of a cron spec
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names for the values starting at min, if any
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronDow    = cronField{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Shorthands for common schedules
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a schedule in the standard 5 field cron format
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field may be *, a value, a range a-b, a list of these
+// separated by commas and may have a step /n. Months and days of the
+// week may be given by their three letter names.
+//
+// The shorthands @yearly, @monthly, @weekly, @daily and @hourly are
+// accepted as is "@every <duration>" to run at a fixed interval.
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("bad schedule %q: %w", spec, err)
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("bad schedule %q: interval must be at least 1s", spec)
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if expanded, ok := cronShorthands[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("bad schedule %q: expecting 5 fields but got %d", spec, len(fields))
+	}
+	c := &cronSpec{
+		domAll: fields[2] == "*",
+		dowAll: fields[4] == "*",
+	}
+	for i, p := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&c.minute, cronMinute},
+		{&c.hour, cronHour},
+		{&c.dom, cronDom},
+		{&c.month, cronMonth},
+		{&c.dow, cronDow},
+	} {
+		var err error
+		*p.bits, err = p.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("bad schedule %q: %w", spec, err)
+		}
+	}
+	// 7 is an alias for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	return c, nil
+}
+
+// parse a field returning a bit set of the values
+func (cf cronField) parse(s string) (set uint64, err error) {
+	for _, item := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q in %s", stepPart, cf.name)
+			}
+		}
+		var lo, hi int
+		switch {
+		case rangePart == "*":
+			lo, hi = cf.min, cf.max
+		case strings.Contains(rangePart, "-"):
+			loPart, hiPart, _ := strings.Cut(rangePart, "-")
+			if lo, err = cf.value(loPart); err != nil {
+				return 0, err
+			}
+			if hi, err = cf.value(hiPart); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("bad range %q in %s", rangePart, cf.name)
+			}
+		default:
+			if lo, err = cf.value(rangePart); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = cf.max
+			}
+		}
+		for i := lo; i <= hi; i += step {
+			set |= 1 << i
+		}
+	}
+	return set, nil
+}
+
+// value parses a single value of the field
+func (cf cronField) value(s string) (int, error) {
+	for i, name := range cf.names {
+		if strings.EqualFold(s, name) {
+			return cf.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < cf.min || v > cf.max {
+		return 0, fmt.Errorf("bad value %q in %s: must be in range %d-%d", s, cf.name, cf.min, cf.max)
+	}
+	return v, nil
+}
+
+// errNoNextTime is returned by next if the schedule never fires
+var errNoNextTime = errors.New("schedule never runs")
+
+// dayMatches returns whether the day of t matches the spec
+//
+// As in cron if both day of month and day of week are restricted then
+// the day matches if either of them does.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<t.Day()) != 0
+	dowMatch := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAll || c.dowAll {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time the schedule fires strictly after t
+func (c *cronSpec) next(t time.Time) (time.Time, error) {
+	if c.every > 0 {
+		return t.Add(c.every), nil
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// A schedule which can fire will do so within a leap year cycle
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		nextHour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = nextHour
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			// skip straight to the next matching minute in this hour
+			rest := c.minute >> t.Minute()
+			if rest == 0 {
+				t = nextHour
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)) * time.Minute)
+			}
+			continue
+		}
+		return t, nil
+	}
+	return time.Time{}, errNoNextTime
+}
@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"x * * * *",
+		"* * * foo *",
+		"@every",
+		"@every 1ms",
+		"@every potato",
+	} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday 15 May 2024 10:17:30
+	now := time.Date(2024, 5, 15, 10, 17, 30, 0, time.UTC)
+	for _, test := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 15, 10, 18, 0, 0, time.UTC)},
+		{"30 * * * *", time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)},
+		{"15 * * * *", time.Date(2024, 5, 15, 11, 15, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2024, 5, 15, 10, 20, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 5, 16, 2, 0, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2024, 5, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * sat,sun", time.Date(2024, 5, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * mon", time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)},
+		{"0 8-9,17 * * *", time.Date(2024, 5, 15, 17, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", now.Add(90 * time.Minute)},
+	} {
+		c, err := parseCron(test.spec)
+		require.NoError(t, err, test.spec)
+		got, err := c.next(now)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.want, got, test.spec)
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	c, err := parseCron("0 0 31 feb *")
+	require.NoError(t, err)
+	_, err = c.next(time.Now())
+	assert.Equal(t, errNoNextTime, err)
+}
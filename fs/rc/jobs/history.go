@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// Names of the files persisted in --rc-job-dir
+const (
+	historyFile   = "history.json"
+	schedulesFile = "schedules.json"
+)
+
+// historyEntry records the final status of a finished job
+type historyEntry struct {
+	ExecuteID string    `json:"executeId"`
+	ID        int64     `json:"id"`
+	Group     string    `json:"group"`
+	Schedule  string    `json:"schedule,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Duration  float64   `json:"duration"`
+	Error     string    `json:"error"`
+	Success   bool      `json:"success"`
+	Stats     rc.Params `json:"stats,omitempty"`
+}
+
+// Restore loads the job history and the schedules saved in
+// --rc-job-dir and starts running the schedules. From then on the
+// history and schedules are saved there as they change.
+//
+// It does nothing if --rc-job-dir isn't set. ctx is used as the
+// parent context of the scheduled jobs.
+func Restore(ctx context.Context) error {
+	return running.restore(ctx)
+}
+
+// restore the history and schedules from jobs.opt.JobDir
+func (jobs *Jobs) restore(ctx context.Context) error {
+	dir := jobs.opt.JobDir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to make job directory: %w", err)
+	}
+	var history []historyEntry
+	if err := readJSON(filepath.Join(dir, historyFile), &history); err != nil {
+		return err
+	}
+	var schedules []*schedule
+	if err := readJSON(filepath.Join(dir, schedulesFile), &schedules); err != nil {
+		return err
+	}
+
+	jobs.histMu.Lock()
+	jobs.dir = dir
+	jobs.history = append(history, jobs.history...)
+	jobs.trimHistory()
+	jobs.histMu.Unlock()
+
+	jobs.schedMu.Lock()
+	defer jobs.schedMu.Unlock()
+	jobs.ctx = ctx
+	for _, sched := range schedules {
+		if err := jobs.addSchedule(sched); err != nil {
+			fs.Errorf(nil, "rc: ignoring schedule %q: %v", sched.Name, err)
+		}
+	}
+	if len(schedules) > 0 {
+		fs.Infof(nil, "rc: restored %d schedules from %q", len(jobs.schedules), dir)
+	}
+	return nil
+}
+
+// addHistory records the job in the history if it was started with
+// _async or by a schedule
+func (jobs *Jobs) addHistory(ctx context.Context, job *Job) {
+	job.mu.Lock()
+	if !job.async && job.Schedule == "" {
+		job.mu.Unlock()
+		return
+	}
+	entry := historyEntry{
+		ExecuteID: executeID,
+		ID:        job.ID,
+		Group:     job.Group,
+		Schedule:  job.Schedule,
+		Command:   job.command,
+		StartTime: job.StartTime,
+		EndTime:   job.EndTime,
+		Duration:  job.Duration,
+		Error:     job.Error,
+		Success:   job.Success,
+	}
+	job.mu.Unlock()
+	stats, err := accounting.Stats(ctx).RemoteStats(true)
+	if err == nil {
+		entry.Stats = stats
+	}
+
+	jobs.histMu.Lock()
+	defer jobs.histMu.Unlock()
+	jobs.history = append(jobs.history, entry)
+	jobs.trimHistory()
+}
+
+// trimHistory removes the oldest entries from the history if it is
+// too long and saves it - call with histMu held
+func (jobs *Jobs) trimHistory() {
+	if limit := jobs.opt.JobHistory; limit >= 0 && len(jobs.history) > limit {
+		jobs.history = slices.Delete(jobs.history, 0, len(jobs.history)-limit)
+	}
+	if jobs.dir == "" {
+		return
+	}
+	if err := writeJSON(filepath.Join(jobs.dir, historyFile), jobs.history); err != nil {
+		fs.Errorf(nil, "rc: failed to save job history: %v", err)
+	}
+}
+
+// findHistory returns the history entry for job ID run by this
+// rclone or nil if not found
+func (jobs *Jobs) findHistory(ID int64) *historyEntry {
+	jobs.histMu.Lock()
+	defer jobs.histMu.Unlock()
+	for i := len(jobs.history) - 1; i >= 0; i-- {
+		entry := jobs.history[i]
+		if entry.ID == ID && entry.ExecuteID == executeID {
+			return &entry
+		}
+	}
+	return nil
+}
+
+// readJSON reads the JSON in path into out if the file exists
+func readJSON(path string, out any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read job state: %w", err)
+	}
+	if err = json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse job state %q: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON writes v to path as JSON atomically
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "job/history",
+		Fn:    rcJobHistory,
+		Title: "Lists the finished async and scheduled jobs",
+		Help: `Parameters:
+
+- name - only list the jobs started by this schedule (optional)
+- limit - maximum number of jobs to list (optional, default all)
+
+Results:
+
+- history - array of finished jobs, newest first, each with
+    - executeId - id of the rclone which ran the job
+    - id - id of the job in that rclone
+    - group - stats group of the job
+    - schedule - name of the schedule which started the job, if any
+    - command - rc command run by the schedule, if any
+    - startTime - time the job started
+    - endTime - time the job finished
+    - duration - time in seconds that the job ran for
+    - error - error from the job or empty string for no error
+    - success - boolean - true for success false otherwise
+    - stats - stats of the job as returned by core/stats
+
+The number of jobs kept is set with --rc-job-history. The history
+survives restarts if --rc-job-dir is set.
+`,
+	})
+}
+
+// Returns the job history
+func rcJobHistory(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	limit, err := in.GetInt64("limit")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	history := []historyEntry{}
+	running.histMu.Lock()
+	for i := len(running.history) - 1; i >= 0; i-- {
+		if limit > 0 && int64(len(history)) >= limit {
+			break
+		}
+		if entry := running.history[i]; name == "" || entry.Schedule == name {
+			history = append(history, entry)
+		}
+	}
+	running.histMu.Unlock()
+	out = make(rc.Params)
+	err = rc.Reshape(&out, struct {
+		History []historyEntry `json:"history"`
+	}{history})
+	if err != nil {
+		return nil, fmt.Errorf("reshape failed in job history: %w", err)
+	}
+	return out, nil
+}
@@ -37,8 +37,11 @@ type Job struct {
 	Success   bool      `json:"success"`
 	Duration  float64   `json:"duration"`
 	Output    rc.Params `json:"output"`
+	Schedule  string    `json:"schedule,omitempty"`
 	Stop      func()    `json:"-"`
 	listeners []*func()
+	async     bool   // set if the job was started with _async
+	command   string // rc command run by a scheduled job
 
 	// realErr is the Error before printing it as a string, it's used to return
 	// the real error to the upper application layers while still printing the
@@ -101,6 +104,7 @@ func (job *Job) OnFinish(fn func()) func() {
 
 // run the job until completion writing the return status
 func (job *Job) run(ctx context.Context, fn rc.Func, in rc.Params) {
+	defer running.addHistory(ctx, job) // runs after the recover below
 	defer func() {
 		if r := recover(); r != nil {
 			job.finish(nil, fmt.Errorf("panic received: %v \n%s", r, string(debug.Stack())))
@@ -115,6 +119,12 @@ type Jobs struct {
 	jobs          map[int64]*Job
 	opt           *rc.Options
 	expireRunning bool
+	dir           string          // directory to persist history and schedules in, if set
+	ctx           context.Context // context for scheduled jobs
+	histMu        sync.Mutex
+	history       []historyEntry // finished async and scheduled jobs, oldest first
+	schedMu       sync.Mutex
+	schedules     map[string]*schedule
 }
 
 var (
@@ -126,8 +136,10 @@ var (
 // newJobs makes a new Jobs structure
 func newJobs() *Jobs {
 	return &Jobs{
-		jobs: map[int64]*Job{},
-		opt:  &rc.Opt,
+		jobs:      map[int64]*Job{},
+		opt:       &rc.Opt,
+		ctx:       context.Background(),
+		schedules: map[string]*schedule{},
 	}
 }
 
@@ -261,6 +273,12 @@ var jobKey = jobKeyType{}
 
 // NewJob creates a Job and executes it, possibly in the background if _async is set
 func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
+	return jobs.newJob(ctx, fn, in, nil)
+}
+
+// newJob creates a Job and executes it, recording the schedule that
+// started it if sched is set
+func (jobs *Jobs) newJob(ctx context.Context, fn rc.Func, in rc.Params, sched *schedule) (job *Job, out rc.Params, err error) {
 	id := jobID.Add(1)
 	in = in.Copy() // copy input so we can change it
 
@@ -295,6 +313,11 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 		Group:     group,
 		StartTime: time.Now(),
 		Stop:      stop,
+		async:     isAsync,
+	}
+	if sched != nil {
+		job.Schedule = sched.Name
+		job.command = sched.Command
 	}
 
 	jobs.mu.Lock()
@@ -369,6 +392,11 @@ Results:
 - success - boolean - true for success false otherwise
 - output - output of the job as would have been returned if called synchronously
 - progress - output of the progress related to the underlying job
+- schedule - name of the schedule which started the job, if any
+
+Jobs which have expired are looked up in the job history (see
+[job/history](#job-history)). These don't have the output but do have
+the stats of the job.
 `,
 	})
 }
@@ -381,7 +409,18 @@ func rcJobStatus(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	}
 	job := running.Get(jobID)
 	if job == nil {
-		return nil, errors.New("job not found")
+		// look for the job in the history if it has expired
+		entry := running.findHistory(jobID)
+		if entry == nil {
+			return nil, errors.New("job not found")
+		}
+		out = make(rc.Params)
+		err = rc.Reshape(&out, entry)
+		if err != nil {
+			return nil, fmt.Errorf("reshape failed in job status: %w", err)
+		}
+		out["finished"] = true
+		return out, nil
 	}
 	job.mu.Lock()
 	defer job.mu.Unlock()
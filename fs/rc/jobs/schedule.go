@@ -0,0 +1,292 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// schedule describes an rc command run on a cron style schedule
+type schedule struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Command  string    `json:"command"`
+	Params   rc.Params `json:"params,omitempty"`
+	Next     time.Time `json:"next"`    // time of the next run
+	Running  bool      `json:"running"` // set if a run is in progress
+	spec     *cronSpec
+	timer    *time.Timer
+}
+
+// checkCommand checks that command is an rc call which can be scheduled
+func checkCommand(command string) (*rc.Call, error) {
+	call := rc.Calls.Get(command)
+	if call == nil {
+		return nil, fmt.Errorf("couldn't find method %q", command)
+	}
+	if call.AuthRequired || call.NeedsRequest || call.NeedsResponse {
+		return nil, fmt.Errorf("method %q can't be scheduled", command)
+	}
+	return call, nil
+}
+
+// addSchedule adds sched replacing any schedule with the same name
+// and starts its timer - call with schedMu held
+func (jobs *Jobs) addSchedule(sched *schedule) (err error) {
+	if sched.Name == "" {
+		return errors.New("schedule needs a name")
+	}
+	sched.spec, err = parseCron(sched.Schedule)
+	if err != nil {
+		return err
+	}
+	if _, err = checkCommand(sched.Command); err != nil {
+		return err
+	}
+	// the schedule waits for each run to finish so _async is not needed
+	sched.Params = sched.Params.Copy()
+	delete(sched.Params, "_async")
+	sched.Running = false
+	jobs.removeSchedule(sched.Name)
+	jobs.schedules[sched.Name] = sched
+	jobs.startTimer(sched)
+	return nil
+}
+
+// removeSchedule removes the schedule called name returning false if
+// it wasn't found - call with schedMu held
+//
+// A run of the schedule in progress is left to finish.
+func (jobs *Jobs) removeSchedule(name string) bool {
+	sched, ok := jobs.schedules[name]
+	if !ok {
+		return false
+	}
+	if sched.timer != nil {
+		sched.timer.Stop()
+	}
+	delete(jobs.schedules, name)
+	return true
+}
+
+// startTimer sets the timer for the next run of sched - call with
+// schedMu held
+func (jobs *Jobs) startTimer(sched *schedule) {
+	next, err := sched.spec.next(time.Now())
+	if err != nil {
+		fs.Errorf(nil, "rc: schedule %q: %v", sched.Name, err)
+		sched.Next = time.Time{}
+		return
+	}
+	sched.Next = next
+	sched.timer = time.AfterFunc(time.Until(next), func() {
+		jobs.runSchedule(sched)
+	})
+}
+
+// runSchedule runs the command of sched and waits for it to finish
+func (jobs *Jobs) runSchedule(sched *schedule) {
+	jobs.schedMu.Lock()
+	if jobs.schedules[sched.Name] != sched {
+		// schedule was removed or replaced
+		jobs.schedMu.Unlock()
+		return
+	}
+	jobs.startTimer(sched)
+	if sched.Running {
+		fs.Logf(nil, "rc: schedule %q: skipping run as the previous one is still running", sched.Name)
+		jobs.schedMu.Unlock()
+		return
+	}
+	sched.Running = true
+	ctx := jobs.ctx
+	jobs.schedMu.Unlock()
+
+	defer func() {
+		jobs.schedMu.Lock()
+		sched.Running = false
+		jobs.schedMu.Unlock()
+	}()
+	call, err := checkCommand(sched.Command)
+	if err != nil {
+		fs.Errorf(nil, "rc: schedule %q: %v", sched.Name, err)
+		return
+	}
+	fs.Infof(nil, "rc: schedule %q: running %q", sched.Name, sched.Command)
+	job, _, err := jobs.newJob(ctx, call.Fn, sched.Params, sched)
+	if err != nil {
+		if job == nil {
+			fs.Errorf(nil, "rc: schedule %q: %v", sched.Name, err)
+		} else {
+			fs.Errorf(nil, "rc: schedule %q: job %d failed: %v", sched.Name, job.ID, err)
+		}
+	}
+}
+
+// saveSchedules writes the schedules to the job directory if set -
+// call with schedMu held
+func (jobs *Jobs) saveSchedules() error {
+	if jobs.dir == "" {
+		return nil
+	}
+	return writeJSON(filepath.Join(jobs.dir, schedulesFile), jobs.listSchedules())
+}
+
+// listSchedules returns the schedules sorted by name - call with
+// schedMu held
+func (jobs *Jobs) listSchedules() []*schedule {
+	schedules := make([]*schedule, 0, len(jobs.schedules))
+	for _, sched := range jobs.schedules {
+		schedules = append(schedules, sched)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/schedule",
+		Fn:           rcJobSchedule,
+		Title:        "Run an rc command on a schedule",
+		AuthRequired: true,
+		Help: `Parameters:
+
+- name - name of the schedule (string), replaces any schedule with the same name
+- schedule - when to run the command (string)
+- command - rc command to run, e.g. "sync/sync"
+- params - parameters for the command (object, optional)
+
+The schedule is in the standard cron format of 5 fields
+
+    minute hour day-of-month month day-of-week
+
+Each field may be "*", a value, a range "a-b" or a list of these
+separated by commas and may have a step "/n". Months and days of the
+week may be given by their three letter names, e.g. "30 2 * * mon-fri"
+runs at 02:30 on weekdays. The shorthands "@yearly", "@monthly",
+"@weekly", "@daily" and "@hourly" may be used as may "@every
+<duration>", e.g. "@every 6h", to run at a fixed interval. Times are in
+the local time zone of rclone.
+
+The parameters may include "_config", "_filter" and "_group" as for
+any other rc command. Each run of the schedule is a job which appears
+in [job/list](#job-list) while it is running and in
+[job/history](#job-history) when it has finished. If a run is still in
+progress when the next is due, the next run is skipped.
+
+Commands which require authorisation can't be scheduled.
+
+If --rc-job-dir is set the schedules are saved there and restored
+when rclone restarts.
+
+Results:
+
+- name - name of the schedule
+- next - time of the next run
+`,
+	})
+}
+
+// Adds a schedule
+func rcJobSchedule(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	sched := &schedule{}
+	if sched.Name, err = in.GetString("name"); err != nil {
+		return nil, err
+	}
+	if sched.Schedule, err = in.GetString("schedule"); err != nil {
+		return nil, err
+	}
+	if sched.Command, err = in.GetString("command"); err != nil {
+		return nil, err
+	}
+	if err = in.GetStructMissingOK("params", &sched.Params); err != nil {
+		return nil, err
+	}
+	running.schedMu.Lock()
+	defer running.schedMu.Unlock()
+	if err = running.addSchedule(sched); err != nil {
+		return nil, rc.NewErrParamInvalid(err)
+	}
+	if err = running.saveSchedules(); err != nil {
+		return nil, err
+	}
+	return rc.Params{
+		"name": sched.Name,
+		"next": sched.Next,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "job/unschedule",
+		Fn:           rcJobUnschedule,
+		Title:        "Remove a schedule",
+		AuthRequired: true,
+		Help: `Parameters:
+
+- name - name of the schedule (string)
+
+A run of the schedule in progress is not stopped. Use
+[job/stop](#job-stop) for that.
+`,
+	})
+}
+
+// Removes a schedule
+func rcJobUnschedule(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	name, err := in.GetString("name")
+	if err != nil {
+		return nil, err
+	}
+	running.schedMu.Lock()
+	defer running.schedMu.Unlock()
+	if !running.removeSchedule(name) {
+		return nil, errors.New("schedule not found")
+	}
+	if err = running.saveSchedules(); err != nil {
+		return nil, err
+	}
+	return rc.Params{}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "job/schedules",
+		Fn:    rcJobSchedules,
+		Title: "Lists the schedules",
+		Help: `Parameters: None.
+
+Results:
+
+- schedules - array of schedules sorted by name, each with
+    - name - name of the schedule
+    - schedule - when the command runs
+    - command - rc command run
+    - params - parameters of the command
+    - next - time of the next run
+    - running - boolean - true if a run is in progress
+`,
+	})
+}
+
+// Lists the schedules
+func rcJobSchedules(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	running.schedMu.Lock()
+	defer running.schedMu.Unlock()
+	out = make(rc.Params)
+	err = rc.Reshape(&out, struct {
+		Schedules []*schedule `json:"schedules"`
+	}{running.listSchedules()})
+	if err != nil {
+		return nil, fmt.Errorf("reshape failed in job schedules: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,199 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetSchedules removes all the schedules and the job directory
+func resetSchedules() {
+	running.schedMu.Lock()
+	for name := range running.schedules {
+		running.removeSchedule(name)
+	}
+	running.dir = ""
+	running.schedMu.Unlock()
+	running.histMu.Lock()
+	running.history = nil
+	running.histMu.Unlock()
+}
+
+func TestRcJobSchedule(t *testing.T) {
+	defer resetSchedules()
+	ctx := context.Background()
+	schedule := rc.Calls.Get("job/schedule")
+	require.NotNil(t, schedule)
+	assert.True(t, schedule.AuthRequired)
+
+	for _, in := range []rc.Params{
+		{"name": "bad", "schedule": "potato", "command": "rc/noop"},
+		{"name": "bad", "schedule": "@daily", "command": "rc/potato"},
+		{"name": "bad", "schedule": "@daily", "command": "rc/noopauth"},
+		{"name": "", "schedule": "@daily", "command": "rc/noop"},
+	} {
+		_, err := schedule.Fn(ctx, in)
+		assert.Error(t, err, in)
+	}
+
+	out, err := schedule.Fn(ctx, rc.Params{
+		"name":     "nightly",
+		"schedule": "0 3 * * *",
+		"command":  "rc/noop",
+		"params":   `{"a":"b","_async":true}`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "nightly", out["name"])
+	next := out["next"].(time.Time)
+	assert.Equal(t, 3, next.Hour())
+	assert.True(t, next.After(time.Now()))
+
+	out, err = rc.Calls.Get("job/schedules").Fn(ctx, rc.Params{})
+	require.NoError(t, err)
+	schedules := out["schedules"].([]any)
+	require.Equal(t, 1, len(schedules))
+	got := schedules[0].(map[string]any)
+	assert.Equal(t, "nightly", got["name"])
+	assert.Equal(t, "0 3 * * *", got["schedule"])
+	assert.Equal(t, map[string]any{"a": "b"}, got["params"])
+
+	unschedule := rc.Calls.Get("job/unschedule")
+	assert.True(t, unschedule.AuthRequired)
+	_, err = unschedule.Fn(ctx, rc.Params{"name": "nightly"})
+	require.NoError(t, err)
+	_, err = unschedule.Fn(ctx, rc.Params{"name": "nightly"})
+	assert.ErrorContains(t, err, "schedule not found")
+}
+
+func TestScheduleRunAndHistory(t *testing.T) {
+	defer resetSchedules()
+	ctx := context.Background()
+	_, err := rc.Calls.Get("job/schedule").Fn(ctx, rc.Params{
+		"name":     "fast",
+		"schedule": "@every 1s",
+		"command":  "rc/error",
+		"params":   rc.Params{"_group": "fastgroup"},
+	})
+	require.NoError(t, err)
+
+	// Run the schedule now rather than waiting
+	running.schedMu.Lock()
+	sched := running.schedules["fast"]
+	running.schedMu.Unlock()
+	running.runSchedule(sched)
+
+	out, err := rc.Calls.Get("job/history").Fn(ctx, rc.Params{"name": "fast"})
+	require.NoError(t, err)
+	history := out["history"].([]any)
+	require.GreaterOrEqual(t, len(history), 1)
+	entry := history[0].(map[string]any)
+	assert.Equal(t, "fast", entry["schedule"])
+	assert.Equal(t, "rc/error", entry["command"])
+	assert.Equal(t, "fastgroup", entry["group"])
+	assert.Equal(t, false, entry["success"])
+	assert.Contains(t, entry["error"], "arbitrary error")
+	assert.NotNil(t, entry["stats"])
+
+	// Check the timer runs the schedule too
+	assert.Eventually(t, func() bool {
+		out, err := rc.Calls.Get("job/history").Fn(ctx, rc.Params{"name": "fast"})
+		require.NoError(t, err)
+		return len(out["history"].([]any)) >= 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	out, err = rc.Calls.Get("job/history").Fn(ctx, rc.Params{"name": "fast", "limit": 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(out["history"].([]any)))
+	out, err = rc.Calls.Get("job/history").Fn(ctx, rc.Params{"name": "other"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(out["history"].([]any)))
+}
+
+func TestJobStatusFromHistory(t *testing.T) {
+	defer resetSchedules()
+	ctx := context.Background()
+	job, _, err := NewJob(ctx, shortFn, rc.Params{"_async": true})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return running.findHistory(job.ID) != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Expire the job
+	running.mu.Lock()
+	delete(running.jobs, job.ID)
+	running.mu.Unlock()
+
+	out, err := rc.Calls.Get("job/status").Fn(ctx, rc.Params{"jobid": job.ID})
+	require.NoError(t, err)
+	assert.Equal(t, true, out["finished"])
+	assert.Equal(t, true, out["success"])
+	assert.Equal(t, float64(job.ID), out["id"])
+
+	// Synchronous jobs aren't recorded
+	job, _, err = NewJob(ctx, shortFn, rc.Params{})
+	require.NoError(t, err)
+	assert.Nil(t, running.findHistory(job.ID))
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	newTestJobs := func() *Jobs {
+		jobs := newJobs()
+		opt := *jobs.opt
+		opt.JobDir = dir
+		opt.JobHistory = 2
+		jobs.opt = &opt
+		require.NoError(t, jobs.restore(ctx))
+		return jobs
+	}
+	jobs := newTestJobs()
+
+	jobs.schedMu.Lock()
+	require.NoError(t, jobs.addSchedule(&schedule{
+		Name:     "saved",
+		Schedule: "@weekly",
+		Command:  "rc/noop",
+	}))
+	require.NoError(t, jobs.saveSchedules())
+	jobs.schedMu.Unlock()
+	for i := range 3 {
+		job := &Job{ID: int64(i + 1), async: true}
+		job.finish(nil, nil)
+		jobs.addHistory(ctx, job)
+	}
+	assert.FileExists(t, filepath.Join(dir, schedulesFile))
+	assert.FileExists(t, filepath.Join(dir, historyFile))
+	jobs.schedMu.Lock()
+	jobs.removeSchedule("saved")
+	jobs.schedMu.Unlock()
+
+	// Simulate a restart
+	jobs = newTestJobs()
+	jobs.schedMu.Lock()
+	sched := jobs.schedules["saved"]
+	jobs.schedMu.Unlock()
+	require.NotNil(t, sched)
+	assert.Equal(t, "rc/noop", sched.Command)
+	assert.True(t, sched.Next.After(time.Now()))
+	jobs.histMu.Lock()
+	require.Equal(t, 2, len(jobs.history), "history should be trimmed")
+	assert.Equal(t, int64(2), jobs.history[0].ID)
+	assert.Equal(t, int64(3), jobs.history[1].ID)
+	jobs.histMu.Unlock()
+
+	// Removing the schedule is saved too
+	jobs.schedMu.Lock()
+	assert.True(t, jobs.removeSchedule("saved"))
+	require.NoError(t, jobs.saveSchedules())
+	jobs.schedMu.Unlock()
+	data, err := os.ReadFile(filepath.Join(dir, schedulesFile))
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+}
@@ -83,6 +83,16 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(10 * time.Second),
 	Help:    "Interval to check for expired async jobs",
 	Groups:  "RC",
+}, {
+	Name:    "rc_job_dir",
+	Default: "",
+	Help:    "Directory to save the rc job history and schedules in",
+	Groups:  "RC",
+}, {
+	Name:    "rc_job_history",
+	Default: 1000,
+	Help:    "Number of finished async and scheduled jobs to keep in the job history",
+	Groups:  "RC",
 }, {
 	Name:    "metrics_addr",
 	Default: []string{},
@@ -122,6 +132,8 @@ type Options struct {
 	MetricsTemplate     libhttp.TemplateConfig `config:"metrics"`
 	JobExpireDuration   fs.Duration            `config:"rc_job_expire_duration"`
 	JobExpireInterval   fs.Duration            `config:"rc_job_expire_interval"`
+	JobDir              string                 `config:"rc_job_dir"`
+	JobHistory          int                    `config:"rc_job_history"`
 }
 
 // Opt is the default values used for Options
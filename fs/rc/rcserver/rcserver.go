@@ -36,6 +36,9 @@ import (
 func Start(ctx context.Context, opt *rc.Options) (*Server, error) {
 	jobs.SetOpt(opt) // set the defaults for jobs
 	if opt.Enabled {
+		if err := jobs.Restore(ctx); err != nil {
+			return nil, err
+		}
 		// Serve on the DefaultServeMux so can have global registrations appear
 		s, err := newServer(ctx, opt, http.DefaultServeMux)
 		if err != nil {
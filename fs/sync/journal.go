@@ -0,0 +1,202 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Operations recorded in the journal
+const (
+	journalCheck  = "check"  // src and dst were found to be identical
+	journalCopy   = "copy"   // src was transferred to dst
+	journalDelete = "delete" // dst was deleted
+)
+
+// journalHeader is the first line of the journal and identifies the
+// sync it belongs to
+type journalHeader struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// journalEntry is written to the journal for each completed check,
+// transfer or delete
+type journalEntry struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`          // path of the destination
+	Src  string `json:"src,omitempty"` // fingerprint of the source
+	Dst  string `json:"dst,omitempty"` // fingerprint of the destination
+}
+
+// journal records the progress of a sync in a local file so that a
+// restarted sync can skip the files already verified.
+//
+// The file is JSON lines - a journalHeader followed by a
+// journalEntry for each completed operation.
+//
+// All the methods are safe to call on a nil *journal and do nothing.
+type journal struct {
+	path    string
+	mu      sync.Mutex
+	out     *os.File
+	done    map[string]journalEntry // checks and transfers completed by a previous run
+	skipped int                     // number of files skipped this run
+}
+
+// newJournal opens the journal at path for the sync from fsrc to
+// fdst reading the entries of a previous run if it was for the same
+// sync.
+func newJournal(ctx context.Context, path string, fdst, fsrc fs.Fs) (j *journal, err error) {
+	j = &journal{
+		path: path,
+		done: make(map[string]journalEntry),
+	}
+	header := journalHeader{
+		Src: fs.ConfigString(fsrc),
+		Dst: fs.ConfigString(fdst),
+	}
+	resumed, err := j.read(header)
+	if err != nil {
+		return nil, err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to make resume journal directory: %w", err)
+	}
+	j.out, err = os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open resume journal: %w", err)
+	}
+	if resumed {
+		fs.Infof(fdst, "Resuming sync from journal %q with %d files already done", path, len(j.done))
+	} else if err = j.write(header); err != nil {
+		_ = j.out.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// read the journal from a previous run returning true if it was for
+// the sync described by header
+func (j *journal) read(header journalHeader) (resumed bool, err error) {
+	in, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read resume journal: %w", err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	dec := json.NewDecoder(bufio.NewReader(in))
+	var oldHeader journalHeader
+	if err = dec.Decode(&oldHeader); err != nil {
+		fs.Logf(nil, "Ignoring corrupt resume journal %q: %v", j.path, err)
+		return false, nil
+	}
+	if oldHeader != header {
+		fs.Logf(nil, "Ignoring resume journal %q as it is for a different sync", j.path)
+		return false, nil
+	}
+	// Read the entries stopping at the first error as the last
+	// line may not have been written completely
+	for {
+		var entry journalEntry
+		if dec.Decode(&entry) != nil {
+			break
+		}
+		if entry.Op == journalDelete {
+			delete(j.done, entry.Path)
+		} else {
+			j.done[entry.Path] = entry
+		}
+	}
+	return true, nil
+}
+
+// write a JSON line to the journal - call with lock held or before
+// the journal is shared
+func (j *journal) write(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	if _, err = j.out.Write(buf); err != nil {
+		return fmt.Errorf("failed to write resume journal: %w", err)
+	}
+	return nil
+}
+
+// add an entry for op on dst to the journal
+func (j *journal) add(ctx context.Context, op string, src fs.ObjectInfo, dst fs.ObjectInfo) {
+	if j == nil {
+		return
+	}
+	entry := journalEntry{
+		Op:   op,
+		Path: dst.Remote(),
+	}
+	if src != nil {
+		entry.Src = fs.Fingerprint(ctx, src, true)
+	}
+	if op != journalDelete {
+		entry.Dst = fs.Fingerprint(ctx, dst, true)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.write(entry); err != nil {
+		fs.Errorf(dst, "%v", err)
+	}
+}
+
+// skip returns true if src and dst were checked or transferred by a
+// previous run and neither has changed since
+func (j *journal) skip(ctx context.Context, src fs.ObjectInfo, dst fs.ObjectInfo) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	entry, ok := j.done[dst.Remote()]
+	j.mu.Unlock()
+	if !ok || entry.Src != fs.Fingerprint(ctx, src, true) || entry.Dst != fs.Fingerprint(ctx, dst, true) {
+		return false
+	}
+	j.mu.Lock()
+	j.skipped++
+	j.mu.Unlock()
+	return true
+}
+
+// close the journal removing it if the sync succeeded
+func (j *journal) close(syncErr error) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.skipped > 0 {
+		fs.Infof(nil, "Skipped %d files already done according to the resume journal", j.skipped)
+	}
+	if err := j.out.Close(); err != nil {
+		fs.Errorf(nil, "Failed to close resume journal: %v", err)
+	}
+	if syncErr != nil {
+		fs.Infof(nil, "Keeping resume journal %q so the sync can be resumed", j.path)
+		return
+	}
+	if err := os.Remove(j.path); err != nil {
+		fs.Errorf(nil, "Failed to remove resume journal: %v", err)
+	}
+}
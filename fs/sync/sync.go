@@ -97,6 +97,7 @@ type syncCopyMove struct {
 	setDirModTimesMaxLevel int                    // max level of the directories to set
 	modifiedDirs           map[string]struct{}    // dirs with changed contents (if s.setDirModTimeAfter)
 	allowOverlap           bool                   // whether we allow src and dst to overlap (i.e. for convmv)
+	journal                *journal               // journal of completed operations if --resume-journal is set
 }
 
 // For keeping track of delayed modtime sets
@@ -289,6 +290,21 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 			return nil, err
 		}
 	}
+	if ci.ResumeJournal != "" {
+		switch {
+		case s.DoMove:
+			fs.Errorf(nil, "Ignoring --resume-journal with move")
+		case ci.DryRun:
+			fs.Debugf(nil, "Not using --resume-journal with --dry-run")
+		case s.deleteMode == fs.DeleteModeOnly:
+			// Don't journal the delete pass of --delete-before
+		default:
+			s.journal, err = newJournal(ctx, ci.ResumeJournal, fdst, fsrc)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return s, nil
 }
 
@@ -435,6 +451,9 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 					}
 				}
 			} else {
+				if pair.Dst != nil {
+					s.journal.add(s.ctx, journalCheck, src, pair.Dst)
+				}
 				// If moving need to delete the files we don't need to copy
 				if s.DoMove {
 					// Delete src if no error on copy
@@ -502,7 +521,11 @@ func (s *syncCopyMove) pairCopyOrMove(ctx context.Context, in *pipe, fdst fs.Fs,
 				err = operations.DeleteFile(ctx, src)
 			}
 		} else {
-			_, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
+			var newDst fs.Object
+			newDst, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
+			if err == nil && newDst != nil {
+				s.journal.add(ctx, journalCopy, src, newDst)
+			}
 		}
 		s.processError(err)
 		if err != nil {
@@ -596,7 +619,7 @@ func (s *syncCopyMove) startDeleters() {
 	s.deletersWg.Add(1)
 	go func() {
 		defer s.deletersWg.Done()
-		err := operations.DeleteFilesWithBackupDir(s.ctx, s.deleteFilesCh, s.backupDir)
+		err := s.deleteFilesJournal(s.deleteFilesCh)
 		s.processError(err)
 	}()
 }
@@ -653,7 +676,31 @@ func (s *syncCopyMove) deleteFiles(checkSrcMap bool) error {
 		}
 		close(toDelete)
 	}()
-	return operations.DeleteFilesWithBackupDir(s.ctx, toDelete, s.backupDir)
+	return s.deleteFilesJournal(toDelete)
+}
+
+// deleteFilesJournal deletes the files in toBeDeleted, recording them in
+// the journal if they were all deleted successfully.
+func (s *syncCopyMove) deleteFilesJournal(toBeDeleted fs.ObjectsChan) error {
+	if s.journal == nil {
+		return operations.DeleteFilesWithBackupDir(s.ctx, toBeDeleted, s.backupDir)
+	}
+	var deleted []fs.Object
+	in := make(fs.ObjectsChan, s.ci.Checkers)
+	go func() {
+		for o := range toBeDeleted {
+			deleted = append(deleted, o)
+			in <- o
+		}
+		close(in)
+	}()
+	err := operations.DeleteFilesWithBackupDir(s.ctx, in, s.backupDir)
+	if err == nil {
+		for _, o := range deleted {
+			s.journal.add(s.ctx, journalDelete, nil, o)
+		}
+	}
+	return err
 }
 
 // This deletes the empty directories in the slice passed in.  It
@@ -927,6 +974,7 @@ func (s *syncCopyMove) tryRename(src fs.Object) bool {
 func (s *syncCopyMove) run() error {
 	if operations.Same(s.fdst, s.fsrc) && !s.allowOverlap {
 		fs.Errorf(s.fdst, "Nothing to do as source and destination are the same")
+		s.journal.close(nil)
 		return nil
 	}
 
@@ -1026,7 +1074,9 @@ func (s *syncCopyMove) run() error {
 	// cancel the contexts to free resources
 	s.inCancel()
 	s.cancel()
-	return s.currentError()
+	err := s.currentError()
+	s.journal.close(err)
+	return err
 }
 
 // DstOnly have an object which is in the destination only
@@ -1282,7 +1332,10 @@ func (s *syncCopyMove) Match(ctx context.Context, dst, src fs.DirEntry) (recurse
 			return false
 		}
 		dstX, ok := dst.(fs.Object)
-		if ok {
+		if ok && s.journal.skip(ctx, srcX, dstX) {
+			fs.Debugf(srcX, "Unchanged since done in resume journal")
+			s.logger(ctx, operations.Match, srcX, dstX, nil)
+		} else if ok {
 			// No logger here because we'll handle it in equal()
 			ok = s.toBeChecked.Put(s.inCtx, fs.ObjectPair{Src: srcX, Dst: dstX})
 			if !ok {
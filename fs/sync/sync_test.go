@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
@@ -284,6 +285,54 @@ func TestSyncNoTraverse(t *testing.T) {
 	r.CheckRemoteItems(t, file1)
 }
 
+// Test sync with --resume-journal
+func TestSyncResumeJournal(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	journalPath := filepath.Join(t.TempDir(), "journal.json")
+	ci.ResumeJournal = journalPath
+
+	file1 := r.WriteFile("a", "aaa", t1)
+	file2 := r.WriteFile("sub/b", "bbb", t1)
+	r.WriteObject(ctx, "c", "ccc", t1)
+
+	// A successful sync removes the journal
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	r.CheckRemoteItems(t, file1, file2)
+	assert.NoFileExists(t, journalPath)
+
+	// Simulate a sync interrupted after checking a
+	interrupted := func() {
+		j, err := newJournal(ctx, journalPath, r.Fremote, r.Flocal)
+		require.NoError(t, err)
+		src, err := r.Flocal.NewObject(ctx, "a")
+		require.NoError(t, err)
+		dst, err := r.Fremote.NewObject(ctx, "a")
+		require.NoError(t, err)
+		j.add(ctx, journalCheck, src, dst)
+		j.close(errors.New("interrupted"))
+		require.FileExists(t, journalPath)
+	}
+	interrupted()
+
+	// The restarted sync only checks b
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetChecks())
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetTransfers())
+	assert.NoFileExists(t, journalPath)
+
+	// A source changed since the journal was written is synced
+	interrupted()
+	file1 = r.WriteFile("a", "potato", t2)
+	accounting.GlobalStats().ResetCounters()
+	require.NoError(t, Sync(ctx, r.Fremote, r.Flocal, false))
+	assert.Equal(t, int64(2), accounting.GlobalStats().GetChecks())
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetTransfers())
+	r.CheckRemoteItems(t, file1, file2)
+}
+
 // Test copy with depth
 func TestCopyWithDepth(t *testing.T) {
 	ctx := context.Background()
@@ -2290,6 +2290,32 @@ func Run(t *testing.T, opt *Opt) {
 
 			// Just file2 remains for Purge to clean up
 
+			// TestFsPutIfNotExists tests conditional uploads
+			t.Run("FsPutIfNotExists", func(t *testing.T) {
+				skipIfNotOk(t)
+				doPutIfNotExists := f.Features().PutIfNotExists
+				if doPutIfNotExists == nil {
+					t.Skip("FS has no PutIfNotExists interface")
+				}
+				file := fstest.Item{
+					ModTime: fstest.Time("2001-02-03T04:05:06.499999999Z"),
+					Path:    "put if not exists.txt",
+				}
+				contents := random.String(50)
+				file.Size = int64(len(contents))
+				obji := object.NewStaticObjectInfo(file.Path, file.ModTime, file.Size, true, nil, nil)
+				obj, err := doPutIfNotExists(ctx, bytes.NewBufferString(contents), obji)
+				require.NoError(t, err)
+				assert.Equal(t, file.Size, obj.Size())
+
+				// A second upload must fail and leave the original alone
+				_, err = doPutIfNotExists(ctx, bytes.NewBufferString(random.String(60)), obji)
+				assert.ErrorIs(t, err, fs.ErrorObjectExists)
+				obj = fstest.NewObject(ctx, t, f, file.Path)
+				assert.Equal(t, file.Size, obj.Size())
+				require.NoError(t, obj.Remove(ctx))
+			})
+
 			// TestFsPutStream tests uploading files when size isn't known in advance.
 			// This may trigger large buffer allocation in some backends, keep it
 			// close to the end of suite. (See fs/operations/xtra_operations_test.go)
@@ -198,6 +198,26 @@ func MiddlewareCORS(allowOrigin string) Middleware {
 	}
 }
 
+// MiddlewarePublic instantiates middleware that skips the auth
+// middleware mw for paths starting with any of prefixes
+func MiddlewarePublic(prefixes []string, mw Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		authHandler := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Check the path the router will use so an escaped
+			// path can't skip the auth for a different route
+			urlPath := r.URL.EscapedPath()
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(urlPath, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			authHandler.ServeHTTP(w, r)
+		})
+	}
+}
+
 // MiddlewareStripPrefix instantiates middleware that removes the BaseURL from the path
 func MiddlewareStripPrefix(prefix string) Middleware {
 	return func(next http.Handler) http.Handler {
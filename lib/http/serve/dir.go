@@ -19,13 +19,15 @@ import (
 
 // DirEntry is a directory entry
 type DirEntry struct {
-	remote  string
-	URL     string
-	ZipURL  string
-	Leaf    string
-	IsDir   bool
-	Size    int64
-	ModTime time.Time
+	remote       string
+	URL          string
+	ZipURL       string
+	ThumbnailURL string // set if there is a thumbnail of the entry
+	PreviewURL   string // set if there is a preview of the entry
+	Leaf         string
+	IsDir        bool
+	Size         int64
+	ModTime      time.Time
 }
 
 // Directory represents a directory
@@ -35,6 +37,7 @@ type Directory struct {
 	Name         string
 	ZipURL       string
 	DisableZip   bool
+	EnableWrite  bool
 	Entries      []DirEntry
 	Query        string
 	HTMLTemplate *template.Template
@@ -235,6 +235,7 @@ type Server struct {
 	template     *TemplateConfig
 	htmlTemplate *template.Template
 	usingAuth    bool       // set if we are using auth middleware
+	public       []string   // path prefixes which skip the auth middleware
 	mu           sync.Mutex // mutex protects RW variables below
 	atexitHandle atexit.FnHandle
 }
@@ -256,6 +257,14 @@ func WithConfig(cfg Config) Option {
 	}
 }
 
+// WithPublicPrefix option lets requests for paths starting with
+// prefix through without authentication
+func WithPublicPrefix(prefix string) Option {
+	return func(s *Server) {
+		s.public = append(s.public, prefix)
+	}
+}
+
 // WithTemplate option allows the parsing of a template
 func WithTemplate(cfg TemplateConfig) Option {
 	return func(s *Server) {
@@ -396,6 +405,14 @@ func NewServer(ctx context.Context, options ...Option) (*Server, error) {
 	return s, nil
 }
 
+// useAuth adds the auth middleware mw except for the public paths
+func (s *Server) useAuth(mw Middleware) {
+	if len(s.public) > 0 {
+		mw = MiddlewarePublic(s.public, mw)
+	}
+	s.mux.Use(mw)
+}
+
 func (s *Server) initAuth() {
 	s.usingAuth = false
 	altUsernameEnabled := s.auth.HtPasswd == "" && s.auth.BasicUser == ""
@@ -403,9 +420,9 @@ func (s *Server) initAuth() {
 	if altUsernameEnabled {
 		s.usingAuth = true
 		if s.auth.UserFromHeader != "" {
-			s.mux.Use(MiddlewareAuthGetUserFromHeader(s.auth.UserFromHeader))
+			s.useAuth(MiddlewareAuthGetUserFromHeader(s.auth.UserFromHeader))
 		} else if s.tlsConfig != nil && s.tlsConfig.ClientAuth != tls.NoClientCert {
-			s.mux.Use(MiddlewareAuthCertificateUser())
+			s.useAuth(MiddlewareAuthCertificateUser())
 		} else {
 			s.usingAuth = false
 			altUsernameEnabled = false
@@ -414,19 +431,19 @@ func (s *Server) initAuth() {
 
 	if s.auth.CustomAuthFn != nil {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm, altUsernameEnabled))
+		s.useAuth(MiddlewareAuthCustom(s.auth.CustomAuthFn, s.auth.Realm, altUsernameEnabled))
 		return
 	}
 
 	if s.auth.HtPasswd != "" {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthHtpasswd(s.auth.HtPasswd, s.auth.Realm))
+		s.useAuth(MiddlewareAuthHtpasswd(s.auth.HtPasswd, s.auth.Realm))
 		return
 	}
 
 	if s.auth.BasicUser != "" {
 		s.usingAuth = true
-		s.mux.Use(MiddlewareAuthBasic(s.auth.BasicUser, s.auth.BasicPass, s.auth.Realm, s.auth.Salt))
+		s.useAuth(MiddlewareAuthBasic(s.auth.BasicUser, s.auth.BasicPass, s.auth.Realm, s.auth.Salt))
 		return
 	}
 }
@@ -21,6 +21,7 @@ const (
 	EROFS
 	ENOSYS
 	ELOOP
+	EAGAIN
 )
 
 // Errors which have exact counterparts in os
@@ -40,6 +41,7 @@ var errorNames = []string{
 	EROFS:     "Read only file system",
 	ENOSYS:    "Function not implemented",
 	ELOOP:     "Too many symbolic links",
+	EAGAIN:    "Resource temporarily unavailable",
 }
 
 // Error renders the error as a string
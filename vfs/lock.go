@@ -0,0 +1,264 @@
+// Advisory byte-range locking
+
+package vfs
+
+import (
+	"context"
+	"math"
+	"path"
+	"strings"
+	"sync"
+)
+
+// LockType is the type of a byte-range lock
+type LockType byte
+
+// Types of lock
+const (
+	LockRead  LockType = iota // shared lock - many owners can hold overlapping read locks
+	LockWrite                 // exclusive lock - conflicts with every other owner's locks
+)
+
+// String turns the lock type into a human readable string
+func (t LockType) String() string {
+	if t == LockWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// LockEOF used as the End of a Lock means the lock extends to the end
+// of the file however large it becomes
+const LockEOF = math.MaxInt64
+
+// Lock is an advisory byte-range lock on a file
+//
+// Locks are held per VFS on the path of the file and follow POSIX
+// semantics: locks of the same owner never conflict, and locking or
+// unlocking part of an existing lock of the same owner splits or
+// replaces it.
+type Lock struct {
+	Owner string   // who holds the lock - front ends should prefix this with their name
+	Type  LockType // read or write
+	Start int64    // first byte locked
+	End   int64    // one past the last byte locked, or LockEOF
+}
+
+// overlaps returns true if l and o have bytes in common
+func (l Lock) overlaps(o Lock) bool {
+	return l.Start < o.End && o.Start < l.End
+}
+
+// conflicts returns true if l can't be held at the same time as o
+func (l Lock) conflicts(o Lock) bool {
+	return l.Owner != o.Owner && (l.Type == LockWrite || o.Type == LockWrite) && l.overlaps(o)
+}
+
+// lockManager holds the byte-range locks for a VFS
+type lockManager struct {
+	mu      sync.Mutex
+	files   map[string][]Lock // locks keyed on cleaned path
+	changed chan struct{}     // closed and replaced whenever locks are released
+}
+
+// newLockManager makes a new lockManager
+func newLockManager() *lockManager {
+	return &lockManager{
+		files:   make(map[string][]Lock),
+		changed: make(chan struct{}),
+	}
+}
+
+// lockPath cleans name for use as a key
+func lockPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// released wakes up any waiters
+//
+// Call with mu held
+func (lm *lockManager) released() {
+	close(lm.changed)
+	lm.changed = make(chan struct{})
+}
+
+// conflict returns the first lock conflicting with l
+//
+// Call with mu held
+func (lm *lockManager) conflict(name string, l Lock) (Lock, bool) {
+	for _, o := range lm.files[name] {
+		if l.conflicts(o) {
+			return o, true
+		}
+	}
+	return Lock{}, false
+}
+
+// remove removes the bytes of l from the locks of l.Owner, splitting
+// them if necessary and returning true if anything was removed
+//
+// Call with mu held
+func (lm *lockManager) remove(name string, l Lock) (removed bool) {
+	locks := lm.files[name]
+	out := locks[:0:0]
+	for _, o := range locks {
+		if o.Owner != l.Owner || !o.overlaps(l) {
+			out = append(out, o)
+			continue
+		}
+		removed = true
+		if o.Start < l.Start {
+			before := o
+			before.End = l.Start
+			out = append(out, before)
+		}
+		if o.End > l.End {
+			after := o
+			after.Start = l.End
+			out = append(out, after)
+		}
+	}
+	if len(out) == 0 {
+		delete(lm.files, name)
+	} else {
+		lm.files[name] = out
+	}
+	return removed
+}
+
+// tryLock takes l if there is no conflict, returning the conflicting
+// lock if there is
+func (lm *lockManager) tryLock(name string, l Lock) (conflict Lock, ok bool, changed <-chan struct{}) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if conflict, found := lm.conflict(name, l); found {
+		return conflict, false, lm.changed
+	}
+	// Downgrading a write lock lets others in
+	if lm.remove(name, l) {
+		lm.released()
+	}
+	lm.files[name] = append(lm.files[name], l)
+	return Lock{}, true, nil
+}
+
+// rename moves the locks on oldName and anything under it to newName
+func (lm *lockManager) rename(oldName, newName string) {
+	oldName, newName = lockPath(oldName), lockPath(newName)
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if _, found := lm.files[newName]; found {
+		// locks on a replaced file go with it
+		delete(lm.files, newName)
+		lm.released()
+	}
+	moved := make(map[string][]Lock)
+	for name, locks := range lm.files {
+		if name == oldName {
+			moved[newName] = locks
+		} else if rest, found := strings.CutPrefix(name, oldName+"/"); found {
+			moved[path.Join(newName, rest)] = locks
+		} else {
+			continue
+		}
+		delete(lm.files, name)
+	}
+	for name, locks := range moved {
+		lm.files[name] = locks
+	}
+}
+
+// checkLock checks the range of l is valid
+func checkLock(l Lock) error {
+	if l.Start < 0 || l.End <= l.Start {
+		return EINVAL
+	}
+	return nil
+}
+
+// Lock takes the advisory byte-range lock l on the file name without
+// waiting, returning EAGAIN if another owner holds a conflicting lock.
+//
+// Taking a lock over a range already locked by the same owner
+// replaces it, so this can be used to upgrade or downgrade locks.
+func (vfs *VFS) Lock(name string, l Lock) error {
+	if err := checkLock(l); err != nil {
+		return err
+	}
+	_, ok, _ := vfs.locks.tryLock(lockPath(name), l)
+	if !ok {
+		return EAGAIN
+	}
+	return nil
+}
+
+// LockWait takes the advisory byte-range lock l on the file name,
+// waiting until any conflicting locks are released or ctx is
+// cancelled.
+func (vfs *VFS) LockWait(ctx context.Context, name string, l Lock) error {
+	if err := checkLock(l); err != nil {
+		return err
+	}
+	name = lockPath(name)
+	for {
+		_, ok, changed := vfs.locks.tryLock(name, l)
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TestLock returns the first lock which conflicts with l on the file
+// name if there is one.
+func (vfs *VFS) TestLock(name string, l Lock) (conflict Lock, found bool) {
+	lm := vfs.locks
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.conflict(lockPath(name), l)
+}
+
+// Unlock releases the locks l.Owner holds on the file name between
+// l.Start and l.End. The Type of l is ignored.
+func (vfs *VFS) Unlock(name string, l Lock) {
+	lm := vfs.locks
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.remove(lockPath(name), l) {
+		lm.released()
+	}
+}
+
+// UnlockAll releases all the locks owner holds on the file name.
+func (vfs *VFS) UnlockAll(name string, owner string) {
+	vfs.Unlock(name, Lock{Owner: owner, Start: 0, End: LockEOF})
+}
+
+// ReleaseLocks releases every lock held by owner on any file. Front
+// ends should call this when a client disconnects.
+func (vfs *VFS) ReleaseLocks(owner string) {
+	lm := vfs.locks
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	removed := false
+	for name := range lm.files {
+		if lm.remove(name, Lock{Owner: owner, Start: 0, End: LockEOF}) {
+			removed = true
+		}
+	}
+	if removed {
+		lm.released()
+	}
+}
+
+// Locks returns the locks currently held on the file name.
+func (vfs *VFS) Locks(name string) []Lock {
+	lm := vfs.locks
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return append([]Lock(nil), lm.files[lockPath(name)]...)
+}
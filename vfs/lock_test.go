@@ -0,0 +1,100 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockConflicts(t *testing.T) {
+	vfs := &VFS{locks: newLockManager()}
+
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "a", Type: LockRead, Start: 0, End: 10}))
+	require.NoError(t, vfs.Lock("/file", Lock{Owner: "b", Type: LockRead, Start: 5, End: 15}))
+	assert.Equal(t, EAGAIN, vfs.Lock("file", Lock{Owner: "c", Type: LockWrite, Start: 9, End: 10}))
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "c", Type: LockWrite, Start: 15, End: LockEOF}))
+	require.NoError(t, vfs.Lock("other", Lock{Owner: "c", Type: LockWrite, Start: 0, End: 10}))
+
+	conflict, found := vfs.TestLock("file", Lock{Owner: "d", Type: LockRead, Start: 100, End: 101})
+	assert.True(t, found)
+	assert.Equal(t, Lock{Owner: "c", Type: LockWrite, Start: 15, End: LockEOF}, conflict)
+	_, found = vfs.TestLock("file", Lock{Owner: "c", Type: LockWrite, Start: 100, End: 101})
+	assert.False(t, found)
+
+	assert.Equal(t, EINVAL, vfs.Lock("file", Lock{Owner: "a", Start: 10, End: 10}))
+	assert.Equal(t, EINVAL, vfs.Lock("file", Lock{Owner: "a", Start: -1, End: 10}))
+
+	vfs.ReleaseLocks("c")
+	assert.Len(t, vfs.Locks("other"), 0)
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "c", Type: LockRead, Start: 0, End: LockEOF}))
+}
+
+func TestLockSplit(t *testing.T) {
+	vfs := &VFS{locks: newLockManager()}
+
+	// Locking part of a lock of the same owner replaces that part
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "a", Type: LockWrite, Start: 0, End: 10}))
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "a", Type: LockRead, Start: 3, End: 5}))
+	assert.Equal(t, []Lock{
+		{Owner: "a", Type: LockWrite, Start: 0, End: 3},
+		{Owner: "a", Type: LockWrite, Start: 5, End: 10},
+		{Owner: "a", Type: LockRead, Start: 3, End: 5},
+	}, vfs.Locks("file"))
+
+	// The downgraded part can now be shared
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "b", Type: LockRead, Start: 3, End: 5}))
+
+	vfs.Unlock("file", Lock{Owner: "a", Start: 4, End: 8})
+	assert.Equal(t, []Lock{
+		{Owner: "a", Type: LockWrite, Start: 0, End: 3},
+		{Owner: "a", Type: LockWrite, Start: 8, End: 10},
+		{Owner: "a", Type: LockRead, Start: 3, End: 4},
+		{Owner: "b", Type: LockRead, Start: 3, End: 5},
+	}, vfs.Locks("file"))
+
+	vfs.UnlockAll("file", "a")
+	vfs.UnlockAll("file", "b")
+	assert.Len(t, vfs.Locks("file"), 0)
+}
+
+func TestLockWait(t *testing.T) {
+	vfs := &VFS{locks: newLockManager()}
+	ctx := context.Background()
+	require.NoError(t, vfs.Lock("file", Lock{Owner: "a", Type: LockWrite, Start: 0, End: LockEOF}))
+
+	// Times out while the lock is held
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := vfs.LockWait(timeoutCtx, "file", Lock{Owner: "b", Type: LockRead, Start: 0, End: 1})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Gets the lock when it is released
+	done := make(chan error)
+	go func() {
+		done <- vfs.LockWait(ctx, "file", Lock{Owner: "b", Type: LockRead, Start: 0, End: 1})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("LockWait returned early with %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	vfs.Unlock("file", Lock{Owner: "a", Start: 0, End: LockEOF})
+	require.NoError(t, <-done)
+}
+
+func TestLockRename(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	r.WriteObject(context.Background(), "dir/file", "contents", t1)
+
+	l := Lock{Owner: "a", Type: LockWrite, Start: 0, End: LockEOF}
+	require.NoError(t, vfs.Lock("dir/file", l))
+	require.NoError(t, vfs.Rename("dir/file", "dir/moved"))
+	assert.Len(t, vfs.Locks("dir/file"), 0)
+	assert.Equal(t, []Lock{l}, vfs.Locks("dir/moved"))
+
+	require.NoError(t, vfs.Rename("dir", "dir2"))
+	assert.Equal(t, []Lock{l}, vfs.Locks("dir2/moved"))
+}
@@ -188,6 +188,7 @@ type VFS struct {
 	usage       *fs.Usage
 	pollChan    chan time.Duration
 	inUse       atomic.Int32 // count of number of opens
+	locks       *lockManager
 }
 
 // Keep track of active VFS keyed on fs.ConfigString(f)
@@ -204,6 +205,7 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	vfs := &VFS{
 		f:      f,
 		cancel: cancel,
+		locks:  newLockManager(),
 	}
 	vfs.inUse.Store(1)
 
@@ -51,6 +51,7 @@ type Cache struct {
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
+	flock      fs.Fs                // if set, fs to take writeback locks on
 
 	mu            sync.Mutex       // protects the following variables
 	cond          sync.Cond        // cond lock for synchronous cache cleaning
@@ -128,6 +129,12 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		writeback:  writeback.New(ctx, opt),
 		avFn:       avFn,
 	}
+	if opt.WriteBackLock != "" {
+		c.flock, err = fscache.Get(ctx, opt.WriteBackLock)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open --vfs-write-back-lock remote: %w", err)
+		}
+	}
 
 	// load in the cache and metadata off disk
 	err = c.reload(ctx)
@@ -12,6 +12,7 @@ import (
 
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/fs/lock"
 	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/lib/file"
 	"github.com/rclone/rclone/lib/ranges"
@@ -599,13 +600,30 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	if cacheObj != nil {
 		o, name := item.o, item.name
 		unlockMutexForCall(&item.mu, func() {
+			var l *lock.Lock
+			if item.c.flock != nil {
+				// Stop other hosts writing back this file at the same time
+				l, err = lock.Acquire(ctx, item.c.flock, name, lock.Options{Renew: true})
+				if err != nil {
+					err = fmt.Errorf("vfs cache: failed to lock file for writeback: %w", err)
+					return
+				}
+			}
 			o, err = operations.Copy(ctx, item.c.fremote, o, name, cacheObj)
+			if l != nil {
+				if releaseErr := l.Release(ctx); releaseErr != nil {
+					fs.Errorf(name, "vfs cache: failed to release writeback lock: %v", releaseErr)
+				}
+			}
 		})
 		if err != nil {
 			if errors.Is(err, fs.ErrorCantUploadEmptyFiles) {
 				fs.Errorf(name, "Writeback failed: %v", err)
 				return nil
 			}
+			if errors.Is(err, lock.ErrLocked) {
+				return err
+			}
 			return fmt.Errorf("vfs cache: failed to transfer file from cache to remote: %w", err)
 		}
 		item.o = o
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/lock"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/rclone/rclone/lib/readers"
@@ -131,6 +132,37 @@ func TestItemSync(t *testing.T) {
 	require.NoError(t, item.Close(nil))
 }
 
+func TestItemWriteBackLock(t *testing.T) {
+	ctx := context.Background()
+	r, c := newItemTestCache(t)
+	c.flock = r.Flocal
+
+	// Another host holds the lock on the file
+	l, err := lock.Acquire(ctx, c.flock, "potato", lock.Options{Owner: "other"})
+	require.NoError(t, err)
+
+	item, _ := c.get("potato")
+	require.NoError(t, item.Open(nil))
+	_, err = item.WriteAt([]byte("hello"), 0)
+	require.NoError(t, err)
+	err = item.Close(nil)
+	assert.ErrorIs(t, err, lock.ErrLocked)
+	assert.Equal(t, true, item.IsDirty())
+
+	// Writeback works once it is released
+	require.NoError(t, l.Release(ctx))
+	require.NoError(t, item.store(ctx, nil))
+	assert.Equal(t, false, item.IsDirty())
+	checkObject(t, r, "potato", "hello")
+
+	// And the lock has been released again leaving nothing behind
+	_, found, err := lock.Status(ctx, c.flock, "potato")
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, err = c.flock.List(ctx, "potato.lock")
+	assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+}
+
 func TestItemTruncateNew(t *testing.T) {
 	r, c := newItemTestCache(t)
 	item, _ := c.get("potato")
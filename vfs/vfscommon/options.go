@@ -132,6 +132,11 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(5 * time.Second),
 	Help:    "Time to writeback files after last use when using cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_write_back_lock",
+	Default: "",
+	Help:    "Remote path to take a lock on each file in while writing it back, e.g. remote:locks",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_ahead",
 	Default: 0 * fs.Mebi,
@@ -240,6 +245,7 @@ type Options struct {
 	WriteWait          fs.Duration   `config:"vfs_write_wait"`       // time to wait for in-sequence write
 	ReadWait           fs.Duration   `config:"vfs_read_wait"`        // time to wait for in-sequence read
 	WriteBack          fs.Duration   `config:"vfs_write_back"`       // time to wait before writing back dirty files
+	WriteBackLock      string        `config:"vfs_write_back_lock"`  // if set take locks on this remote while writing back
 	ReadAhead          fs.SizeSuffix `config:"vfs_read_ahead"`       // bytes to read ahead in cache mode "full"
 	UsedIsSize         bool          `config:"vfs_used_is_size"`     // if true, use the `rclone size` algorithm for Used size
 	FastFingerprint    bool          `config:"vfs_fast_fingerprint"` // if set use fast fingerprints